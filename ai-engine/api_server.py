@@ -6,31 +6,89 @@ Expose Agentic RAG qua HTTP REST API.
 
 import sys
 import os
+import json
+import hashlib
+import hmac
+import time
+import queue
+import re
+import threading
+import contextvars
+from contextvars import ContextVar
 from pathlib import Path
-from typing import Optional, List, Dict, Any
+from urllib.parse import urlsplit
+from typing import Annotated, Optional, List, Dict, Any, Literal
 import logging
+import requests
 from dotenv import load_dotenv
 load_dotenv()
 
 # Thêm thư mục hiện tại vào path
 sys.path.insert(0, str(Path(__file__).parent))
 
-from fastapi import FastAPI, HTTPException, status
+from fastapi import FastAPI, HTTPException, Header, Response, status
 from fastapi.middleware.cors import CORSMiddleware
+from fastapi.responses import StreamingResponse
 from pydantic import BaseModel, Field, ConfigDict
 from contextlib import asynccontextmanager
 import uvicorn
 
 from core.agentic_rag import LegalRAGAgent
 
+# ID của request backend đang được xử lý (header X-Request-ID), để log
+# của engine nối được với log của backend
+request_id_var: ContextVar[str] = ContextVar("request_id", default="-")
+
+
+class RequestIDFilter(logging.Filter):
+    """Thêm request_id của request đang xử lý vào mọi dòng log."""
+    
+    def filter(self, record: logging.LogRecord) -> bool:
+        record.request_id = request_id_var.get()
+        return True
+
+
 # Setup logging
 logging.basicConfig(
     level=logging.INFO,
-    format='%(asctime)s - %(name)s - %(levelname)s - %(message)s'
+    format='%(asctime)s - %(name)s - %(levelname)s - [%(request_id)s] %(message)s'
 )
+for handler in logging.getLogger().handlers:
+    handler.addFilter(RequestIDFilter())
 logger = logging.getLogger(__name__)
 
+# Namespace và mã tài liệu riêng: chữ, số, '-' và '_'
+NAMESPACE_PATTERN = r"^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$"
+
 # Pydantic Models
+class IterationPolicy(BaseModel):
+    """Chính sách dừng lặp: fixed chạy tới max_iterations, adaptive dừng khi kết quả bão hòa."""
+    mode: Literal["fixed", "adaptive"] = "fixed"
+    min_novelty: float = Field(0.34, ge=0, le=1, description="Tỷ lệ kết quả mới tối thiểu để tiếp tục")
+    min_score_gain: float = Field(0.02, ge=0, le=1, description="Mức tăng điểm tối thiểu để tiếp tục")
+    patience: int = Field(1, ge=1, le=10, description="Số lần bão hòa liên tiếp trước khi dừng")
+
+
+class ChatTurn(BaseModel):
+    """Một lượt hỏi đáp trước của phiên chat."""
+    question: str
+    answer: str
+
+
+class ContextDocument(BaseModel):
+    """Tài liệu ngữ cảnh của riêng một query (tệp đính kèm, trang web, văn bản OCR), không được đánh chỉ mục."""
+    name: str = Field("", description="Tên tài liệu")
+    text: str = Field(..., description="Nội dung tài liệu")
+    source: str = Field("", description="Nguồn tài liệu, ví dụ upload, inline hoặc url")
+
+
+class Deadlines(BaseModel):
+    """Hạn chót của một query, tính từ lúc engine nhận (mili giây)."""
+    soft_ms: int = Field(..., ge=1, description="Quá hạn này thì dừng lặp và trả lời bằng fast_model")
+    hard_ms: int = Field(..., ge=1, description="Mọi giai đoạn bị cắt ở hạn này")
+    fast_model: Optional[str] = Field(None, max_length=100, description="Model tạo câu trả lời khi bị hạ cấp")
+
+
 class QueryRequest(BaseModel):
     """Request model cho query endpoint."""
     model_config = ConfigDict(
@@ -48,6 +106,66 @@ class QueryRequest(BaseModel):
     max_iterations: Optional[int] = Field(3, description="Số lần tìm kiếm tối đa", ge=1, le=10)
     top_k: Optional[int] = Field(3, description="Số lượng kết quả mỗi lần tìm kiếm", ge=1, le=20)
     enable_web_search: Optional[bool] = Field(True, description="Bật tìm kiếm web")
+    iteration_policy: Optional[IterationPolicy] = Field(None, description="Chính sách dừng lặp")
+    query_variants: List[str] = Field(default_factory=list, max_length=3, description="Các cách viết lại câu hỏi cho lần tìm kiếm đầu")
+    history: List[ChatTurn] = Field(default_factory=list, max_length=100, description="Các lượt hỏi đáp trước của phiên chat, cũ nhất trước")
+    conversation_summary: Optional[str] = Field(None, max_length=8000, description="Tóm tắt các lượt cũ hơn history")
+    namespace: Optional[str] = Field(None, pattern=NAMESPACE_PATTERN, description="Chỉ tìm trong tài liệu riêng của namespace này")
+    collection: Optional[str] = Field(None, max_length=64, description="Chỉ tìm trong bộ tài liệu này của corpus chung hoặc của namespace")
+    exclude_collections: List[Annotated[str, Field(max_length=64)]] = Field(default_factory=list, max_length=500, description="Các bộ tài liệu của corpus chung bị loại khi không chỉ định collection")
+    deadlines: Optional[Deadlines] = Field(None, description="Hạn chót mềm và cứng của query")
+    source_priors: Dict[str, Annotated[float, Field(gt=0, le=10)]] = Field(default_factory=dict, max_length=500, description="Trọng số mức hữu ích của nguồn theo tiêu đề, nhân với điểm khi xếp hạng lại kết quả")
+    context_documents: List[ContextDocument] = Field(default_factory=list, max_length=100, description="Tài liệu ngữ cảnh của riêng query này, đưa vào prompt tạo câu trả lời")
+    style_instructions: Optional[str] = Field(None, max_length=2000, description="Yêu cầu về độ dài, giọng văn và người đọc, thêm vào prompt tạo câu trả lời")
+    language: Optional[Literal["vi", "en"]] = Field(None, description="Ngôn ngữ trả lời, mặc định tiếng Việt")
+    model: Optional[str] = Field(None, max_length=100, pattern=r"^[A-Za-z0-9][A-Za-z0-9._:/-]*$", description="Model Ollama tạo câu trả lời, mặc định OLLAMA_MODEL")
+    response_format: Optional[Literal["markdown", "text"]] = Field(None, description="Cách trình bày câu trả lời")
+
+
+class SubmitRequest(QueryRequest):
+    """Query trả lời nền: kết quả được POST tới callback_url khi xong."""
+    job_id: str = Field(..., pattern=r"^[A-Za-z0-9_-]{1,64}$", description="ID để backend ghép kết quả với query")
+    callback_url: str = Field(..., pattern=r"^https?://", max_length=2048, description="URL nhận kết quả")
+
+
+class DocumentRequest(BaseModel):
+    """Request model cho endpoint đánh chỉ mục tài liệu riêng."""
+    collection: str = Field("", max_length=64, description="Bộ tài liệu trong namespace")
+    title: str = Field("", max_length=300, description="Tiêu đề tài liệu")
+    text: str = Field(..., min_length=1, description="Nội dung tài liệu")
+
+
+class DocumentResponse(BaseModel):
+    """Response model cho endpoint đánh chỉ mục tài liệu riêng."""
+    document_id: str
+    chunks: int
+
+
+class SummarizeRequest(BaseModel):
+    """Request model cho endpoint tóm tắt hội thoại."""
+    turns: List[ChatTurn] = Field(..., min_length=1, max_length=200, description="Các lượt cần tóm tắt, cũ nhất trước")
+    summary: Optional[str] = Field(None, max_length=8000, description="Tóm tắt các lượt trước đó, gộp vào tóm tắt mới")
+    max_words: int = Field(250, ge=50, le=1000, description="Số từ tối đa của tóm tắt")
+
+
+class SummarizeResponse(BaseModel):
+    """Response model cho endpoint tóm tắt hội thoại."""
+    summary: str
+
+
+class SearchRequest(BaseModel):
+    """Request model cho endpoint chỉ tìm kiếm, không tạo câu trả lời."""
+    question: str = Field(..., min_length=1, description="Câu hỏi cần tìm kiếm")
+    top_k: int = Field(3, ge=1, le=20, description="Số lượng kết quả")
+    namespace: Optional[str] = Field(None, pattern=NAMESPACE_PATTERN, description="Chỉ tìm trong tài liệu riêng của namespace này")
+    collection: Optional[str] = Field(None, max_length=64, description="Chỉ tìm trong bộ tài liệu này của corpus chung hoặc của namespace")
+    exclude_collections: List[Annotated[str, Field(max_length=64)]] = Field(default_factory=list, max_length=500, description="Các bộ tài liệu của corpus chung bị loại khi không chỉ định collection")
+
+
+class SearchResponse(BaseModel):
+    """Response model cho endpoint chỉ tìm kiếm."""
+    search_results: List[Dict[str, Any]] = Field(default_factory=list, description="Kết quả tìm kiếm, điểm cao nhất trước")
+    embedding_model: str = Field(..., description="Model embedding đã dùng để embed câu hỏi")
 
 
 class SearchResult(BaseModel):
@@ -77,6 +195,22 @@ class QueryResponse(BaseModel):
     web_results: List[Dict[str, Any]] = Field(default_factory=list, description="Kết quả tìm kiếm web")
     iterations: int = Field(..., description="Số lần tìm kiếm đã thực hiện")
     query_used: str = Field(..., description="Query cuối cùng được sử dụng")
+    iteration_signals: List[Dict[str, Any]] = Field(default_factory=list, description="Tín hiệu của từng lần tìm kiếm")
+    stopped_reason: Optional[str] = Field(None, description="Lý do dừng: max_iterations, agent_decision, plateau, stage_timeout, soft_deadline")
+    speculation: Optional[Dict[str, Any]] = Field(None, description="Đường thắng của lần tìm kiếm song song đầu tiên")
+    stage_timeouts: List[str] = Field(default_factory=list, description="Các giai đoạn đã vượt ngân sách thời gian")
+    downgrade: Optional[Dict[str, Any]] = Field(None, description="Query bị hạ cấp khi vượt hạn chót mềm")
+
+
+class ArticleResponse(BaseModel):
+    """Toàn văn một điều luật trong corpus."""
+    article_id: str = Field(..., description="Mã điều, ví dụ Dieu_25")
+    document_id: str = Field(..., description="Mã văn bản chứa điều luật")
+    article: str = Field(..., description="Số điều, ví dụ Điều 25")
+    title: str = Field("", description="Tiêu đề điều luật")
+    chapter: Optional[str] = None
+    chapter_title: Optional[str] = None
+    text: str = Field(..., description="Toàn văn điều luật")
 
 
 class HealthResponse(BaseModel):
@@ -104,6 +238,8 @@ async def lifespan(app: FastAPI):
     ollama_url = os.getenv("OLLAMA_URL", "http://127.0.0.1:11434")
     ollama_model = os.getenv("OLLAMA_MODEL", "qwen2.5:7b")
     searxng_url = os.getenv("SEARXNG_URL", "http://localhost:8888")
+    # Collection phải được embed bằng cùng model
+    embedding_model = os.getenv("EMBEDDING_MODEL", "bkai-foundation-models/vietnamese-bi-encoder")
     
     try:
         agent = LegalRAGAgent(
@@ -111,11 +247,13 @@ async def lifespan(app: FastAPI):
             collection_name=collection_name,
             ollama_url=ollama_url,
             ollama_model=ollama_model,
+            embedding_model=embedding_model,
             searxng_url=searxng_url,
             enable_web_search=True
         )
         agent.initialize()
         logger.info("✓ Legal RAG Agent initialized successfully")
+        threading.Thread(target=_callback_worker, daemon=True).start()
     except Exception as e:
         logger.error(f"Failed to initialize agent: {e}")
         raise
@@ -146,6 +284,146 @@ app.add_middleware(
 )
 
 
+# Chữ ký HMAC-SHA256 của backend trên mọi request tới /api/: header
+# timestamp, nonce ngẫu nhiên và chữ ký của timestamp, nonce, method,
+# đường dẫn kèm query và SHA-256 của body, mỗi phần kết thúc bằng "\n"
+ENGINE_SIGNING_SECRET = os.getenv("ENGINE_SIGNING_SECRET", "")
+# Độ lệch tối đa giữa timestamp của chữ ký và đồng hồ của engine (giây)
+MAX_SIGNATURE_AGE = float(os.getenv("MAX_SIGNATURE_AGE", "300"))
+
+
+def _signature(secret: bytes, timestamp: str, nonce: str, method: str, path: str, body: bytes) -> str:
+    """Chữ ký HMAC-SHA256 của một request, giống engine.Signer của backend."""
+    parts = [timestamp, nonce, method, path, hashlib.sha256(body).hexdigest()]
+    return hmac.new(secret, "".join(part + "\n" for part in parts).encode(), hashlib.sha256).hexdigest()
+
+
+class EngineSignatureMiddleware:
+    """
+    Từ chối (401) request tới /api/ không có chữ ký hợp lệ của backend,
+    đã hết hạn hoặc gửi lại với nonce đã dùng, để traffic giả mạo trong
+    mạng nội bộ không tới được engine.
+    """
+    
+    def __init__(self, app, secret: str, max_age: float):
+        self.app = app
+        self.secret = secret.encode()
+        self.max_age = max_age
+        self.seen: Dict[str, float] = {}  # nonce -> thời điểm hết hạn
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http" or not scope["path"].startswith("/api/"):
+            await self.app(scope, receive, send)
+            return
+        
+        body = b""
+        more_body = True
+        while more_body:
+            message = await receive()
+            body += message.get("body", b"")
+            more_body = message.get("more_body", False)
+        
+        error = self._verify(scope, body)
+        if error:
+            logger.warning(f"Rejected unsigned engine request {scope['method']} {scope['path']}: {error}")
+            payload = json.dumps({"detail": error}).encode()
+            await send({
+                "type": "http.response.start",
+                "status": 401,
+                "headers": [(b"content-type", b"application/json")]
+            })
+            await send({"type": "http.response.body", "body": payload})
+            return
+        
+        # Trả lại body đã đọc, sau đó nhường cho receive gốc (chờ disconnect)
+        replayed = False
+        
+        async def replay():
+            nonlocal replayed
+            if replayed:
+                return await receive()
+            replayed = True
+            return {"type": "http.request", "body": body, "more_body": False}
+        
+        await self.app(scope, replay, send)
+    
+    def _verify(self, scope, body: bytes) -> Optional[str]:
+        headers = {name.decode("latin-1").lower(): value.decode("latin-1") for name, value in scope["headers"]}
+        timestamp = headers.get("x-engine-timestamp", "")
+        nonce = headers.get("x-engine-nonce", "")
+        signature = headers.get("x-engine-signature", "")
+        if not (timestamp and nonce and signature):
+            return "request is not signed"
+        
+        path = (scope.get("raw_path") or scope["path"].encode()).decode("latin-1")
+        if scope.get("query_string"):
+            path += "?" + scope["query_string"].decode("latin-1")
+        expected = _signature(self.secret, timestamp, nonce, scope["method"], path, body)
+        if not hmac.compare_digest(signature, expected):
+            return "request signature does not match"
+        
+        try:
+            signed_at = int(timestamp)
+        except ValueError:
+            return "request signature does not match"
+        now = time.time()
+        if abs(now - signed_at) > self.max_age:
+            return "request timestamp is outside the allowed clock skew"
+        
+        self.seen = {seen: expires for seen, expires in self.seen.items() if expires >= now}
+        if nonce in self.seen:
+            return "request nonce was already used"
+        self.seen[nonce] = signed_at + self.max_age
+        return None
+
+
+if ENGINE_SIGNING_SECRET:
+    app.add_middleware(EngineSignatureMiddleware, secret=ENGINE_SIGNING_SECRET, max_age=MAX_SIGNATURE_AGE)
+
+
+# Request ID hợp lệ: giống middleware RequestID của backend
+REQUEST_ID_PATTERN = re.compile(r"^[A-Za-z0-9._:-]{1,128}$")
+
+
+class RequestIDMiddleware:
+    """
+    Đọc X-Request-ID do backend gửi (hoặc tạo mới), đặt vào request_id_var
+    cho log và trả lại trong header của response.
+    """
+    
+    def __init__(self, app):
+        self.app = app
+    
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        
+        request_id = ""
+        for name, value in scope["headers"]:
+            if name.lower() == b"x-request-id":
+                request_id = value.decode("latin-1")
+        if not REQUEST_ID_PATTERN.match(request_id):
+            request_id = os.urandom(16).hex()
+        token = request_id_var.set(request_id)
+        
+        async def send_with_id(message):
+            if message["type"] == "http.response.start":
+                message.setdefault("headers", [])
+                message["headers"] = list(message["headers"]) + [(b"x-request-id", request_id.encode())]
+            await send(message)
+        
+        try:
+            await self.app(scope, receive, send_with_id)
+        finally:
+            request_id_var.reset(token)
+
+
+# Thêm sau cùng để bao ngoài các middleware khác: log từ chối chữ ký cũng
+# có request ID
+app.add_middleware(RequestIDMiddleware)
+
+
 @app.get("/", tags=["Root"])
 async def root():
     """Root endpoint."""
@@ -167,11 +445,49 @@ async def health_check():
     )
 
 
+# Header đàm phán ngân sách thời gian của từng giai đoạn, dạng
+# "retrieval=20000, iteration=30000, answer=60000" (mili giây)
+STAGE_BUDGETS_HEADER = "X-Stage-Budgets"
+STAGES = ("retrieval", "iteration", "answer")
+# Ngân sách tối đa engine chấp nhận cho một giai đoạn (giây)
+MAX_STAGE_BUDGET = float(os.getenv("MAX_STAGE_BUDGET", "300"))
+
+
+def _parse_stage_budgets(header: Optional[str]) -> Dict[str, float]:
+    """
+    Đọc header ngân sách giai đoạn thành số giây, giới hạn ở MAX_STAGE_BUDGET.
+    Giai đoạn không biết và giá trị không hợp lệ bị bỏ qua.
+    """
+    budgets: Dict[str, float] = {}
+    for part in (header or "").split(","):
+        name, _, value = part.partition("=")
+        name = name.strip()
+        try:
+            ms = int(value.strip())
+        except ValueError:
+            continue
+        if name in STAGES and ms > 0:
+            budgets[name] = min(ms / 1000, MAX_STAGE_BUDGET)
+    return budgets
+
+
+def _format_stage_budgets(budgets: Dict[str, float]) -> str:
+    """Ngược lại của _parse_stage_budgets: ngân sách đã áp dụng cho header phản hồi."""
+    return ", ".join(f"{stage}={round(budgets[stage] * 1000)}" for stage in STAGES if stage in budgets)
+
+
 @app.post("/api/query", response_model=QueryResponse, tags=["Query"])
-async def query_legal_rag(request: QueryRequest):
+async def query_legal_rag(
+    request: QueryRequest,
+    response: Response,
+    stage_budgets: Optional[str] = Header(None, alias=STAGE_BUDGETS_HEADER)
+):
     """
     Main endpoint để query Legal RAG system.
     
+    Header X-Stage-Budgets (tùy chọn) giới hạn thời gian của từng giai đoạn;
+    ngân sách đã áp dụng được trả lại trong cùng header.
+    
     Args:
         request: QueryRequest với question và các tham số tùy chọn
         
@@ -189,29 +505,10 @@ async def query_legal_rag(request: QueryRequest):
     
     try:
         logger.info(f"Received query: {request.question}")
-        
-        # Update agent configuration if needed
-        if request.max_iterations:
-            agent.max_iterations = request.max_iterations
-        if request.top_k:
-            agent.top_k = request.top_k
-        if request.enable_web_search is not None:
-            agent.enable_web_search = request.enable_web_search
-        
-        # Execute query
-        result = agent.query(request.question)
-        
-        logger.info(f"Query completed: {result['iterations']} iterations, "
-                   f"{len(result['search_results'])} internal results, "
-                   f"{len(result.get('web_results', []))} web results")
-        
-        return QueryResponse(
-            answer=result["answer"],
-            search_results=result.get("search_results", []),
-            web_results=result.get("web_results", []),
-            iterations=result["iterations"],
-            query_used=result["query_used"]
-        )
+        budgets = _parse_stage_budgets(stage_budgets)
+        if budgets:
+            response.headers[STAGE_BUDGETS_HEADER] = _format_stage_budgets(budgets)
+        return _to_response(_run_query(request, stage_budgets=budgets))
         
     except Exception as e:
         logger.error(f"Error processing query: {e}", exc_info=True)
@@ -221,6 +518,389 @@ async def query_legal_rag(request: QueryRequest):
         )
 
 
+@app.post("/api/query/stream", tags=["Query"])
+def query_legal_rag_stream(
+    request: QueryRequest,
+    stage_budgets: Optional[str] = Header(None, alias=STAGE_BUDGETS_HEADER)
+):
+    """
+    Query dạng luồng: trả về NDJSON, mỗi dòng một sự kiện có trường "type".
+    
+    Sự kiện "retrieval" gửi search_results và web_results ngay khi tìm kiếm
+    xong, trước khi tạo câu trả lời; các sự kiện "token" gửi từng đoạn câu
+    trả lời (trường "text") khi LLM sinh ra. Sự kiện cuối cùng là "answer" (trường
+    "response" giống /api/query) hoặc "error" (trường "detail").
+    Header X-Stage-Budgets được xử lý như ở /api/query.
+    """
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    
+    logger.info(f"Received streamed query: {request.question}")
+    budgets = _parse_stage_budgets(stage_budgets)
+    events: "queue.Queue[Optional[Dict[str, Any]]]" = queue.Queue()
+    
+    def run():
+        try:
+            result = _run_query(
+                request,
+                on_retrieval=lambda results: events.put({"type": "retrieval", **results}),
+                on_token=lambda text: events.put({"type": "token", "text": text}),
+                stage_budgets=budgets
+            )
+            events.put({"type": "answer", "response": _to_response(result).model_dump()})
+        except Exception as e:
+            logger.error(f"Error processing query: {e}", exc_info=True)
+            events.put({"type": "error", "detail": f"Lỗi khi xử lý câu hỏi: {str(e)}"})
+        finally:
+            events.put(None)
+    
+    # Chạy trong bản sao context để log của luồng giữ request ID
+    threading.Thread(target=contextvars.copy_context().run, args=(run,), daemon=True).start()
+    
+    def stream():
+        while (event := events.get()) is not None:
+            yield json.dumps(event, ensure_ascii=False, default=str) + "\n"
+    
+    headers = {STAGE_BUDGETS_HEADER: _format_stage_budgets(budgets)} if budgets else None
+    return StreamingResponse(stream(), media_type="application/x-ndjson", headers=headers)
+
+
+# Toàn văn các điều luật, đọc khi được hỏi lần đầu
+ARTICLES_PATH = Path(__file__).parent / os.getenv("ARTICLES_PATH", "data/processed/articles.json")
+# Mã văn bản của corpus, trùng tên file PDF bản chính thức
+CORPUS_DOCUMENT_ID = os.getenv("CORPUS_DOCUMENT_ID", "BoLuatLaoDong2019")
+_articles: Optional[Dict[str, Dict[str, Any]]] = None
+_articles_mtime: float = 0.0
+_articles_lock = threading.Lock()
+
+
+# Hàng đợi của các query trả lời nền: một worker chạy lần lượt từng query
+# (agent không an toàn khi chạy song song), query vượt quá hàng đợi bị từ chối
+CALLBACK_QUEUE_SIZE = int(os.getenv("CALLBACK_QUEUE_SIZE", "100"))
+# Số lần gửi kết quả tới backend khi kết nối lỗi hoặc backend trả 5xx
+CALLBACK_ATTEMPTS = 3
+callback_jobs: "queue.Queue[tuple]" = queue.Queue(maxsize=CALLBACK_QUEUE_SIZE)
+
+
+@app.post("/api/query/submit", status_code=status.HTTP_202_ACCEPTED, tags=["Query"])
+def submit_query(
+    request: SubmitRequest,
+    stage_budgets: Optional[str] = Header(None, alias=STAGE_BUDGETS_HEADER)
+):
+    """
+    Nhận query để trả lời nền và trả 202 ngay. Khi xong, engine POST tới
+    callback_url {"job_id", "response"} (giống /api/query) hoặc
+    {"job_id", "error"}, ký bằng ENGINE_SIGNING_SECRET như request của backend.
+    """
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    if not ENGINE_SIGNING_SECRET:
+        raise HTTPException(
+            status_code=status.HTTP_501_NOT_IMPLEMENTED,
+            detail="Callback cần ENGINE_SIGNING_SECRET để ký kết quả"
+        )
+    
+    budgets = _parse_stage_budgets(stage_budgets)
+    try:
+        # Giữ context để log của worker có request ID
+        callback_jobs.put_nowait((request, budgets, contextvars.copy_context()))
+    except queue.Full:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Hàng đợi query đã đầy"
+        )
+    logger.info(f"Queued job {request.job_id}: {request.question}")
+    return {"job_id": request.job_id, "status": "queued", "queued": callback_jobs.qsize()}
+
+
+def _callback_worker():
+    """Trả lời lần lượt các query trong hàng đợi và gửi kết quả về backend."""
+    while True:
+        request, budgets, ctx = callback_jobs.get()
+        ctx.run(_answer_job, request, budgets)
+
+
+def _answer_job(request: SubmitRequest, budgets: Dict[str, float]):
+    try:
+        payload = {"job_id": request.job_id, "response": _to_response(_run_query(request, stage_budgets=budgets)).model_dump()}
+    except Exception as e:
+        logger.error(f"Error processing job {request.job_id}: {e}", exc_info=True)
+        payload = {"job_id": request.job_id, "error": f"Lỗi khi xử lý câu hỏi: {str(e)}"}
+    _post_callback(request.callback_url, payload)
+
+
+def _post_callback(url: str, payload: Dict[str, Any]):
+    """
+    Gửi kết quả đã ký tới backend. 404 nghĩa là backend không còn chờ job
+    (hết hạn hoặc client đã rời đi), nên không gửi lại.
+    """
+    body = json.dumps(payload, ensure_ascii=False, default=str).encode()
+    parts = urlsplit(url)
+    path = (parts.path or "/") + (f"?{parts.query}" if parts.query else "")
+    for attempt in range(1, CALLBACK_ATTEMPTS + 1):
+        # Mỗi lần gửi cần nonce mới, backend từ chối nonce đã dùng
+        timestamp, nonce = str(int(time.time())), os.urandom(16).hex()
+        headers = {
+            "Content-Type": "application/json",
+            "X-Engine-Timestamp": timestamp,
+            "X-Engine-Nonce": nonce,
+            "X-Engine-Signature": _signature(ENGINE_SIGNING_SECRET.encode(), timestamp, nonce, "POST", path, body),
+        }
+        if request_id_var.get() != "-":
+            headers["X-Request-ID"] = request_id_var.get()
+        try:
+            resp = requests.post(url, data=body, headers=headers, timeout=30)
+            if resp.status_code < 500:
+                if resp.status_code >= 300:
+                    logger.warning(f"Callback of job {payload['job_id']} rejected: {resp.status_code} {resp.text[:200]}")
+                return
+            error = f"status {resp.status_code}"
+        except requests.RequestException as e:
+            error = str(e)
+        logger.warning(f"Callback of job {payload['job_id']} failed (attempt {attempt}/{CALLBACK_ATTEMPTS}): {error}")
+        if attempt < CALLBACK_ATTEMPTS:
+            time.sleep(attempt)
+    logger.error(f"Giving up on the callback of job {payload['job_id']}")
+
+
+def _load_articles() -> Dict[str, Dict[str, Any]]:
+    """Đọc articles.json, đánh chỉ mục theo mã điều (Điều 25 -> Dieu_25).
+
+    Đọc lại khi tệp thay đổi, để backend thấy các điều vừa được sửa đổi
+    hoặc bãi bỏ khi kiểm tra các điều được theo dõi.
+    """
+    global _articles, _articles_mtime
+    with _articles_lock:
+        mtime = ARTICLES_PATH.stat().st_mtime
+        if _articles is None or mtime != _articles_mtime:
+            with open(ARTICLES_PATH, encoding="utf-8") as f:
+                articles = json.load(f)
+            _articles_mtime = mtime
+            _articles = {}
+            for article in articles:
+                metadata = article.get("metadata", {})
+                number = metadata.get("article", "").replace("Điều", "").strip()
+                article_id = metadata.get("article_id") or (f"Dieu_{number}" if number else "")
+                # Giữ điều xuất hiện đầu tiên nếu trùng mã
+                if article_id and article_id not in _articles:
+                    _articles[article_id] = article
+        return _articles
+
+
+def _check_identifier(value: str, name: str) -> None:
+    """Từ chối namespace hoặc mã tài liệu không hợp lệ."""
+    if not re.fullmatch(NAMESPACE_PATTERN, value):
+        raise HTTPException(
+            status_code=status.HTTP_422_UNPROCESSABLE_ENTITY,
+            detail=f"{name} không hợp lệ"
+        )
+
+
+@app.put("/api/namespaces/{namespace}/documents/{document_id}", response_model=DocumentResponse, tags=["Documents"])
+def index_document(namespace: str, document_id: str, request: DocumentRequest):
+    """
+    Đánh chỉ mục một tài liệu riêng trong namespace, thay thế tài liệu cùng mã.
+    
+    Tài liệu riêng chỉ được tìm thấy bởi query có cùng namespace và không bao
+    giờ lẫn vào kết quả của corpus chung.
+    """
+    _check_identifier(namespace, "Namespace")
+    _check_identifier(document_id, "Mã tài liệu")
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    
+    try:
+        chunks = agent.legal_search.index_document(
+            namespace,
+            document_id,
+            request.text,
+            title=request.title,
+            collection=request.collection
+        )
+    except ValueError as e:
+        raise HTTPException(status_code=status.HTTP_422_UNPROCESSABLE_ENTITY, detail=str(e))
+    except Exception as e:
+        logger.error(f"Error indexing document {namespace}/{document_id}: {e}", exc_info=True)
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Lỗi khi đánh chỉ mục tài liệu: {str(e)}"
+        )
+    logger.info(f"Indexed document {namespace}/{document_id}: {chunks} chunks")
+    return DocumentResponse(document_id=document_id, chunks=chunks)
+
+
+@app.delete("/api/namespaces/{namespace}/documents/{document_id}", status_code=status.HTTP_204_NO_CONTENT, tags=["Documents"])
+def delete_document(namespace: str, document_id: str):
+    """Xóa một tài liệu riêng khỏi namespace; tài liệu không tồn tại thì bỏ qua."""
+    _check_identifier(namespace, "Namespace")
+    _check_identifier(document_id, "Mã tài liệu")
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    
+    try:
+        agent.legal_search.delete_document(namespace, document_id)
+    except Exception as e:
+        logger.error(f"Error deleting document {namespace}/{document_id}: {e}", exc_info=True)
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Lỗi khi xóa tài liệu: {str(e)}"
+        )
+    return Response(status_code=status.HTTP_204_NO_CONTENT)
+
+
+@app.post("/api/summarize", response_model=SummarizeResponse, tags=["Query"])
+def summarize_conversation(request: SummarizeRequest):
+    """
+    Tóm tắt các lượt cũ của một hội thoại dài. Backend gửi tóm tắt kèm các
+    câu hỏi tiếp theo trong conversation_summary thay cho các lượt đó.
+    """
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    
+    try:
+        summary = agent.summarize_conversation(
+            [turn.model_dump() for turn in request.turns],
+            summary=request.summary,
+            max_words=request.max_words
+        )
+    except Exception as e:
+        logger.error(f"Error summarizing conversation: {e}", exc_info=True)
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Lỗi khi tóm tắt hội thoại: {str(e)}"
+        )
+    return SummarizeResponse(summary=summary)
+
+
+@app.post("/api/search", response_model=SearchResponse, tags=["Query"])
+def search_legal_documents(request: SearchRequest):
+    """
+    Chỉ tìm kiếm: embed câu hỏi và trả về các đoạn gần nhất, không lặp và
+    không tạo câu trả lời. Backend dùng để so sánh kết quả của các model
+    embedding khác nhau.
+    """
+    if agent is None:
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Agent chưa được khởi tạo"
+        )
+    
+    try:
+        results = agent.legal_search.search(
+            request.question,
+            top_k=request.top_k,
+            namespace=request.namespace,
+            collection=request.collection,
+            exclude_collections=request.exclude_collections
+        )
+    except Exception as e:
+        logger.error(f"Error searching: {e}", exc_info=True)
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Lỗi khi tìm kiếm: {str(e)}"
+        )
+    return SearchResponse(search_results=results, embedding_model=agent.embedding_model)
+
+
+@app.get("/api/articles/{article_id}", response_model=ArticleResponse, tags=["Articles"])
+def get_article(article_id: str):
+    """Trả về toàn văn một điều luật để xuất kèm câu trả lời."""
+    try:
+        articles = _load_articles()
+    except (OSError, ValueError) as e:
+        logger.error(f"Failed to load articles: {e}")
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Không đọc được dữ liệu điều luật"
+        )
+    article = articles.get(article_id)
+    if article is None:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail=f"Không tìm thấy điều luật {article_id}"
+        )
+    metadata = article.get("metadata", {})
+    return ArticleResponse(
+        article_id=article_id,
+        document_id=metadata.get("document_id") or CORPUS_DOCUMENT_ID,
+        article=metadata.get("article", ""),
+        title=metadata.get("article_title") or "",
+        chapter=metadata.get("chapter"),
+        chapter_title=metadata.get("chapter_title"),
+        text=article.get("text", "")
+    )
+
+
+def _run_query(request: QueryRequest, on_retrieval=None, on_token=None, stage_budgets=None) -> Dict[str, Any]:
+    """Cập nhật cấu hình agent theo request và chạy query."""
+    # Update agent configuration if needed
+    if request.max_iterations:
+        agent.max_iterations = request.max_iterations
+    if request.top_k:
+        agent.top_k = request.top_k
+    if request.enable_web_search is not None:
+        agent.enable_web_search = request.enable_web_search
+    
+    # Execute query
+    policy = request.iteration_policy.model_dump() if request.iteration_policy else None
+    result = agent.query(
+        request.question,
+        iteration_policy=policy,
+        query_variants=request.query_variants,
+        on_retrieval=on_retrieval,
+        on_token=on_token,
+        stage_budgets=stage_budgets,
+        history=[turn.model_dump() for turn in request.history],
+        conversation_summary=request.conversation_summary,
+        namespace=request.namespace,
+        collection=request.collection,
+        exclude_collections=request.exclude_collections,
+        deadlines=request.deadlines.model_dump() if request.deadlines else None,
+        source_priors=request.source_priors,
+        context_documents=[doc.model_dump() for doc in request.context_documents],
+        language=request.language,
+        style_instructions=request.style_instructions,
+        model=request.model,
+        response_format=request.response_format
+    )
+    
+    logger.info(f"Query completed: {result['iterations']} iterations, "
+               f"{len(result['search_results'])} internal results, "
+               f"{len(result.get('web_results', []))} web results")
+    return result
+
+
+def _to_response(result: Dict[str, Any]) -> QueryResponse:
+    """Chuyển kết quả của agent thành QueryResponse."""
+    return QueryResponse(
+        answer=result["answer"],
+        search_results=result.get("search_results", []),
+        web_results=result.get("web_results", []),
+        iterations=result["iterations"],
+        query_used=result["query_used"],
+        iteration_signals=result.get("iteration_signals", []),
+        stopped_reason=result.get("stopped_reason"),
+        speculation=result.get("speculation"),
+        stage_timeouts=result.get("stage_timeouts", []),
+        downgrade=result.get("downgrade")
+    )
+
+
 def main():
     """Main function để chạy server."""
     import argparse
@@ -7,8 +7,11 @@ Module Agentic RAG sử dụng LangGraph để tạo hệ thống RAG thông min
 """
 
 import sys
+import time
+import threading
+from concurrent.futures import ThreadPoolExecutor, TimeoutError as FuturesTimeout
 from pathlib import Path
-from typing import TypedDict, Annotated, List, Dict, Any, Optional, Literal
+from typing import TypedDict, Annotated, List, Dict, Any, Optional, Literal, Callable
 from operator import add
 
 # Thêm thư mục ai-engine vào path
@@ -55,6 +58,41 @@ def _contains_specific_data_query(question: str) -> bool:
     return any(keyword in question_lower for keyword in keywords)
 
 
+# Helper function để đặt câu hỏi tiếp theo vào ngữ cảnh hội thoại
+def _with_history(
+    question: str,
+    history: Optional[List[Dict[str, str]]],
+    summary: Optional[str] = None,
+    max_answer_chars: int = 500
+) -> str:
+    """
+    Thêm các lượt hỏi đáp trước vào câu hỏi để LLM hiểu câu hỏi tiếp theo.
+    
+    Args:
+        question: Câu hỏi hiện tại
+        history: Các lượt trước, cũ nhất trước, mỗi lượt có question và answer
+        summary: Tóm tắt các lượt cũ hơn history, nếu có
+        max_answer_chars: Độ dài tối đa của mỗi câu trả lời trước
+        
+    Returns:
+        Câu hỏi kèm hội thoại trước, hoặc câu hỏi gốc nếu không có lịch sử
+    """
+    if not history and not summary:
+        return question
+    parts = []
+    if summary:
+        parts.append(f"Tóm tắt hội thoại trước:\n{summary}")
+    if history:
+        turns = []
+        for turn in history:
+            answer = turn.get("answer", "")
+            if len(answer) > max_answer_chars:
+                answer = answer[:max_answer_chars] + "..."
+            turns.append(f"Hỏi: {turn.get('question', '')}\nĐáp: {answer}")
+        parts.append("Hội thoại trước:\n" + "\n\n".join(turns))
+    return "\n\n".join(parts) + f"\n\nCâu hỏi tiếp theo: {question}"
+
+
 class AgentState(TypedDict):
     """State của agent trong workflow."""
     question: str  # Câu hỏi gốc
@@ -67,6 +105,29 @@ class AgentState(TypedDict):
     needs_refinement: bool  # Có cần refine query không
     should_continue: bool  # Có nên tiếp tục tìm kiếm không
     use_web_search: bool  # Có sử dụng web search không
+    iteration_policy: Dict[str, Any]  # Chính sách dừng lặp (fixed/adaptive)
+    iteration_signals: List[Dict[str, Any]]  # Tín hiệu của từng lần tìm kiếm
+    stopped_reason: Optional[str]  # Lý do dừng tìm kiếm
+    query_variants: List[str]  # Các cách viết lại câu hỏi cho lần tìm kiếm đầu
+    speculation: Optional[Dict[str, Any]]  # Kết quả tìm kiếm song song lần đầu
+    on_retrieval: Optional[Callable[[Dict[str, Any]], None]]  # Gọi khi tìm kiếm xong, trước khi tạo câu trả lời
+    on_token: Optional[Callable[[str], None]]  # Gọi với từng đoạn câu trả lời khi LLM sinh ra
+    history: List[Dict[str, str]]  # Các lượt hỏi đáp trước của phiên chat
+    conversation_summary: Optional[str]  # Tóm tắt các lượt cũ hơn history
+    stage_budgets: Dict[str, float]  # Ngân sách thời gian (giây) của mỗi lần chạy một giai đoạn
+    stage_timeouts: List[str]  # Các giai đoạn đã vượt ngân sách
+    namespace: Optional[str]  # Namespace tài liệu riêng (None = corpus chung)
+    collection: Optional[str]  # Bộ tài liệu của corpus chung hoặc của namespace
+    exclude_collections: List[str]  # Bộ tài liệu của corpus chung không được tìm khi collection trống
+    deadlines: Dict[str, Any]  # Hạn chót mềm/cứng (giây, tính từ started_at) và model nhanh
+    started_at: float  # Thời điểm bắt đầu query (time.monotonic)
+    downgrade: Optional[Dict[str, Any]]  # Thông tin hạ cấp khi vượt hạn chót mềm
+    source_priors: Dict[str, float]  # Trọng số mức hữu ích của nguồn theo tiêu đề
+    context_documents: List[Dict[str, str]]  # Tài liệu ngữ cảnh của riêng query (đính kèm, URL, OCR)
+    language: str  # Ngôn ngữ trả lời (vi/en)
+    style_instructions: Optional[str]  # Yêu cầu về độ dài, giọng văn, người đọc của câu trả lời
+    model: Optional[str]  # Model tạo câu trả lời (None = model mặc định)
+    response_format: Optional[str]  # Cách trình bày câu trả lời: markdown hoặc text
 
 
 class LegalRAGAgent:
@@ -165,12 +226,12 @@ class LegalRAGAgent:
         """Build LangGraph workflow."""
         workflow = StateGraph(AgentState)
         
-        # Thêm các nodes
-        workflow.add_node("decide_action", self._decide_action)
-        workflow.add_node("refine_query", self._refine_query)
-        workflow.add_node("search", self._search)
-        workflow.add_node("search_web", self._search_web)  # NEW: Web search node
-        workflow.add_node("generate_answer", self._generate_answer)
+        # Thêm các nodes, mỗi node chạy trong ngân sách của giai đoạn
+        workflow.add_node("decide_action", self._budgeted("iteration", self._decide_action, self._stop_searching))
+        workflow.add_node("refine_query", self._budgeted("iteration", self._refine_query, lambda state: None))
+        workflow.add_node("search", self._budgeted("retrieval", self._search, self._stop_searching))
+        workflow.add_node("search_web", self._budgeted("retrieval", self._search_web, self._stop_searching))  # NEW: Web search node
+        workflow.add_node("generate_answer", self._budgeted("answer", self._generate_answer, self._fallback_answer))
         
         # Set entry point
         workflow.set_entry_point("decide_action")
@@ -216,6 +277,112 @@ class LegalRAGAgent:
         
         self.workflow = workflow.compile()
     
+    def _budgeted(
+        self,
+        stage: str,
+        node: Callable[[AgentState], AgentState],
+        on_timeout: Callable[[AgentState], None]
+    ) -> Callable[[AgentState], AgentState]:
+        """
+        Giới hạn thời gian chạy của một node theo ngân sách của giai đoạn.
+        
+        Node chạy trên bản sao của state trong thread riêng. Nếu vượt ngân
+        sách, kết quả của node bị bỏ qua (kể cả các sự kiện luồng nó gửi sau
+        đó), giai đoạn được ghi vào stage_timeouts và on_timeout cập nhật
+        state để pipeline tiếp tục với những gì đã có. Khi query có hạn chót
+        cứng, ngân sách không vượt quá thời gian còn lại đến hạn chót.
+        
+        Args:
+            stage: "retrieval", "iteration" hoặc "answer"
+            node: Node cần giới hạn
+            on_timeout: Hàm cập nhật state khi hết ngân sách
+            
+        Returns:
+            Node đã được bọc
+        """
+        def run(state: AgentState) -> AgentState:
+            budget = (state.get("stage_budgets") or {}).get(stage)
+            hard = (state.get("deadlines") or {}).get("hard")
+            if hard:
+                remaining = max(hard - (time.monotonic() - state["started_at"]), 0.001)
+                budget = min(budget, remaining) if budget else remaining
+            if not budget:
+                return node(state)
+            
+            cancelled = threading.Event()
+            working = dict(state)
+            for key in ("search_results", "web_results", "iteration_signals", "stage_timeouts"):
+                working[key] = list(state.get(key) or [])
+            for key in ("on_retrieval", "on_token"):
+                callback = state.get(key)
+                if callback:
+                    working[key] = lambda value, callback=callback: None if cancelled.is_set() else callback(value)
+            
+            executor = ThreadPoolExecutor(max_workers=1)
+            future = executor.submit(node, working)
+            executor.shutdown(wait=False)
+            try:
+                return future.result(timeout=budget)
+            except FuturesTimeout:
+                cancelled.set()
+                print(f"⏱ Giai đoạn {stage} vượt ngân sách {budget:g}s → tiếp tục với kết quả hiện có")
+                state["stage_timeouts"] = list(state.get("stage_timeouts") or []) + [stage]
+                on_timeout(state)
+                return state
+        
+        return run
+    
+    def _stop_searching(self, state: AgentState) -> None:
+        """Dừng tìm kiếm sau khi một giai đoạn vượt ngân sách."""
+        state["iteration"] = state.get("iteration", 0) + 1
+        state["should_continue"] = False
+        state["stopped_reason"] = "stage_timeout"
+    
+    def _fallback_answer(self, state: AgentState) -> None:
+        """
+        Câu trả lời thay thế khi tạo câu trả lời vượt ngân sách: liệt kê các
+        điều luật tìm được để người dùng vẫn có thông tin.
+        """
+        lines = ["Không kịp tạo câu trả lời trong thời gian cho phép. Các điều luật liên quan đã tìm được:"]
+        for r in state.get("search_results", []):
+            metadata = r.get("metadata", {})
+            title = metadata.get("article_title") or r.get("text", "")[:80]
+            article = metadata.get("article_id", "").replace("Dieu_", "Điều ")
+            lines.append(f"- {article}: {title}" if article else f"- {title}")
+        for r in state.get("web_results", []):
+            if r.get("url"):
+                lines.append(f"- {r.get('title', r['url'])} ({r['url']})")
+        state["answer"] = "\n".join(lines)
+    
+    def _check_soft_deadline(self, state: AgentState) -> None:
+        """
+        Hạ cấp query khi đã vượt hạn chót mềm: không lặp thêm (hoặc chỉ tìm
+        kiếm một lần nếu chưa có kết quả nào) và tạo câu trả lời bằng model
+        nhanh để kịp hạn chót cứng.
+        """
+        deadlines = state.get("deadlines") or {}
+        soft = deadlines.get("soft")
+        if not soft or state.get("downgrade"):
+            return
+        elapsed = time.monotonic() - state["started_at"]
+        if elapsed < soft:
+            return
+        
+        iteration = state.get("iteration", 0)
+        has_results = bool(state.get("search_results") or state.get("web_results"))
+        state["max_iterations"] = min(
+            state.get("max_iterations", self.max_iterations),
+            iteration if has_results else iteration + 1
+        )
+        state["downgrade"] = {
+            "reason": "soft_deadline",
+            "after_ms": round(elapsed * 1000),
+            "iterations": iteration,
+            "model": deadlines.get("fast_model") or self.ollama_model
+        }
+        state["stopped_reason"] = "soft_deadline"
+        print(f"⏱ Vượt hạn chót mềm {soft:g}s sau {iteration} lần tìm kiếm → hạ cấp, trả lời bằng {state['downgrade']['model']}")
+    
     def _decide_action(self, state: AgentState) -> AgentState:
         """
         Node quyết định hành động tiếp theo.
@@ -226,6 +393,7 @@ class LegalRAGAgent:
         Returns:
             Updated state
         """
+        self._check_soft_deadline(state)
         question = state["question"]
         query = state.get("query", question)
         iteration = state.get("iteration", 0)
@@ -384,11 +552,20 @@ class LegalRAGAgent:
         print(f"\n[Lần tìm kiếm {iteration + 1}] Đang tìm kiếm: '{query}'")
         
         try:
-            # Thực hiện tìm kiếm
-            new_results = self.legal_search.search(
-                query=query,
-                top_k=self.top_k
-            )
+            # Thực hiện tìm kiếm; lần đầu chạy song song câu hỏi gốc và các
+            # biến thể đã viết lại, giữ kết quả tốt nhất
+            variants = state.get("query_variants") or []
+            if iteration == 0 and not existing_results and variants:
+                new_results, query = self._speculative_search(state, query, variants)
+                state["query"] = query
+            else:
+                new_results = self.legal_search.search(
+                    query=query,
+                    top_k=self.top_k,
+                    namespace=state.get("namespace"),
+                    collection=state.get("collection"),
+                    exclude_collections=state.get("exclude_collections")
+                )
             
             # DEBUG
             print(f"DEBUG: Existing: {len(existing_results)}, New: {len(new_results)}")
@@ -411,8 +588,14 @@ class LegalRAGAgent:
                 
                 print(f"✓ Tìm thấy {len(new_results)} kết quả, thêm {added_count} kết quả mới. Tổng: {len(merged_results)}")
             
+            # Xếp hạng lại theo mức hữu ích của nguồn với người dùng
+            merged_results = self._apply_source_priors(merged_results, state.get("source_priors") or {})
+            
             state["search_results"] = merged_results
             state["iteration"] = iteration + 1
+            added = len(merged_results) - len(existing_results)
+            best_score = max((r.get("score") or 0.0 for r in merged_results), default=0.0)
+            self._record_signal(state, "internal", len(new_results), added, best_score)
             
             if not existing_results:
                 print(f"✓ Tìm thấy {len(new_results)} kết quả. Tổng: {len(merged_results)}")
@@ -423,6 +606,100 @@ class LegalRAGAgent:
         
         return state
     
+    @staticmethod
+    def _apply_source_priors(
+        results: List[Dict[str, Any]],
+        priors: Dict[str, float]
+    ) -> List[Dict[str, Any]]:
+        """
+        Xếp hạng lại kết quả theo điểm nhân với trọng số của nguồn.
+        
+        Args:
+            results: Kết quả tìm kiếm
+            priors: Trọng số theo tiêu đề điều luật (article_title); nguồn
+                không có trọng số giữ nguyên điểm
+            
+        Returns:
+            Danh sách mới; kết quả có trọng số được ghi thêm "prior", "score"
+            giữ nguyên điểm tìm kiếm
+        """
+        if not priors:
+            return results
+        weighted = []
+        for r in results:
+            title = ((r.get("metadata") or {}).get("article_title") or "").strip()
+            weight = priors.get(title)
+            if weight is not None:
+                r = {**r, "prior": weight}
+            weighted.append(r)
+        return sorted(
+            weighted,
+            key=lambda r: (r.get("score") or 0.0) * r.get("prior", 1.0),
+            reverse=True
+        )
+    
+    def _speculative_search(
+        self,
+        state: AgentState,
+        query: str,
+        variants: List[str]
+    ) -> tuple:
+        """
+        Tìm kiếm song song với câu hỏi gốc và các biến thể, chọn đường có điểm
+        trung bình cao nhất (hòa thì giữ câu hỏi gốc).
+        
+        Args:
+            state: Current state
+            query: Câu hỏi gốc
+            variants: Các biến thể đã viết lại
+            
+        Returns:
+            (kết quả của đường thắng, query của đường thắng)
+        """
+        candidates = [("raw", query)]
+        for i, variant in enumerate(v for v in variants if v and v != query):
+            candidates.append(("rewritten" if i == 0 else f"rewritten_{i + 1}", variant))
+        
+        def timed_search(q: str):
+            started = time.perf_counter()
+            results = self.legal_search.search(
+                query=q,
+                top_k=self.top_k,
+                namespace=state.get("namespace"),
+                collection=state.get("collection"),
+                exclude_collections=state.get("exclude_collections")
+            )
+            return results, int((time.perf_counter() - started) * 1000)
+        
+        with ThreadPoolExecutor(max_workers=len(candidates)) as pool:
+            futures = [(path, q, pool.submit(timed_search, q)) for path, q in candidates]
+        
+        outcomes = []
+        results_by_path = {}
+        for path, q, future in futures:
+            try:
+                results, duration_ms = future.result()
+            except Exception as e:
+                print(f"Lỗi khi tìm kiếm song song ({path}): {e}")
+                continue
+            scores = [r.get("score") or 0.0 for r in results]
+            outcomes.append({
+                "path": path,
+                "query": q,
+                "best_score": round(max(scores, default=0.0), 4),
+                "mean_score": round(sum(scores) / len(scores), 4) if scores else 0.0,
+                "duration_ms": duration_ms,
+            })
+            results_by_path[path] = results
+        
+        if not outcomes:
+            raise RuntimeError("tất cả các đường tìm kiếm song song đều lỗi")
+        
+        winner = max(outcomes, key=lambda o: (o["mean_score"], o["best_score"]))
+        print(f"[Song song] Đường thắng: {winner['path']} ('{winner['query']}')")
+        state["speculation"] = {"winner": winner["path"], "candidates": outcomes}
+        return results_by_path[winner["path"]], winner["query"]
+    
     def _search_web(self, state: AgentState) -> AgentState:
         """
         Node thực hiện tìm kiếm trên internet.
@@ -470,6 +747,8 @@ class LegalRAGAgent:
             
             state["web_results"] = merged_web_results
             state["iteration"] = iteration + 1
+            added = len(merged_web_results) - len(existing_web_results)
+            self._record_signal(state, "web", len(results), added, None)
             
             if not existing_web_results:
                 print(f"✓ Tìm thấy {len(results)} kết quả web. Tổng: {len(merged_web_results)}")
@@ -490,7 +769,7 @@ class LegalRAGAgent:
         Returns:
             Updated state với câu trả lời
         """
-        question = state["question"]
+        question = _with_history(state["question"], state.get("history"), state.get("conversation_summary"))
         search_results = state.get("search_results", [])
         web_results = state.get("web_results", [])
         
@@ -528,18 +807,34 @@ class LegalRAGAgent:
                 }
                 all_results.append(web_result)
         
-        if not all_results:
+        # Báo kết quả tìm kiếm trước khi tạo câu trả lời (chế độ luồng)
+        on_retrieval = state.get("on_retrieval")
+        if on_retrieval:
+            try:
+                on_retrieval({"search_results": search_results, "web_results": web_results})
+            except Exception as e:
+                print(f"Lỗi khi gửi kết quả tìm kiếm: {e}")
+        
+        context_documents = state.get("context_documents") or []
+        if not all_results and not context_documents:
             state["answer"] = "Xin lỗi, tôi không tìm thấy thông tin liên quan đến câu hỏi của bạn."
             return state
         
-        print(f"\nĐang tạo câu trả lời từ {len(search_results)} kết quả nội bộ + {len(web_results)} kết quả web...")
+        print(f"\nĐang tạo câu trả lời từ {len(search_results)} kết quả nội bộ + {len(web_results)} kết quả web + {len(context_documents)} tài liệu ngữ cảnh...")
         
         try:
             # Sử dụng LLM generator để tạo câu trả lời
             answer = self.legal_search.generate_answer(
                 question=question,
                 results=all_results,
-                top_k=len(all_results)
+                top_k=len(all_results),
+                on_token=state.get("on_token"),
+                # Model nhanh của lần hạ cấp thắng model được yêu cầu
+                model_name=(state.get("downgrade") or {}).get("model") or state.get("model"),
+                context_documents=context_documents,
+                language=state.get("language") or "vi",
+                style_instructions=state.get("style_instructions"),
+                response_format=state.get("response_format")
             )
             state["answer"] = answer
             print("✓ Đã tạo câu trả lời")
@@ -578,6 +873,62 @@ class LegalRAGAgent:
         else:
             return "search"
     
+    def _record_signal(
+        self,
+        state: AgentState,
+        source: str,
+        returned: int,
+        added: int,
+        best_score: Optional[float]
+    ) -> None:
+        """
+        Ghi lại tín hiệu của lần tìm kiếm vừa thực hiện và đánh dấu dừng sớm
+        nếu chính sách adaptive phát hiện kết quả đã bão hòa.
+        
+        Args:
+            state: Current state
+            source: "internal" hoặc "web"
+            returned: Số kết quả trả về
+            added: Số kết quả mới (chưa có trước đó)
+            best_score: Điểm cao nhất hiện tại (None với web search)
+        """
+        signals = list(state.get("iteration_signals") or [])
+        previous_best = max((s["best_score"] for s in signals), default=0.0)
+        if best_score is None:
+            best_score = previous_best
+        signals.append({
+            "iteration": len(signals) + 1,
+            "source": source,
+            "returned": returned,
+            "added": added,
+            "novelty": round(added / returned, 3) if returned else 0.0,
+            "best_score": round(best_score, 4),
+            "score_gain": round(max(0.0, best_score - previous_best), 4),
+        })
+        state["iteration_signals"] = signals
+        
+        if self._plateaued(state.get("iteration_policy") or {}, signals):
+            print(f"[Adaptive] Kết quả đã bão hòa sau {len(signals)} lần tìm kiếm → tạo câu trả lời")
+            state["stopped_reason"] = "plateau"
+    
+    @staticmethod
+    def _plateaued(policy: Dict[str, Any], signals: List[Dict[str, Any]]) -> bool:
+        """
+        Kiểm tra novelty và điểm tin cậy đã bão hòa trong `patience` lần tìm
+        kiếm gần nhất (không tính lần đầu tiên).
+        """
+        if policy.get("mode") != "adaptive":
+            return False
+        patience = max(1, int(policy.get("patience", 1)))
+        if len(signals) < patience + 1:
+            return False
+        min_novelty = float(policy.get("min_novelty", 0.34))
+        min_score_gain = float(policy.get("min_score_gain", 0.02))
+        return all(
+            s["novelty"] < min_novelty and s["score_gain"] < min_score_gain
+            for s in signals[-patience:]
+        )
+    
     def _route_after_search(self, state: AgentState) -> str:
         """
         Router sau khi search.
@@ -603,15 +954,77 @@ class LegalRAGAgent:
         if not search_results:
             return "end"
         
+        # Một giai đoạn đã vượt ngân sách: trả lời với kết quả hiện có
+        if state.get("stopped_reason") == "stage_timeout":
+            return "answer"
+        
+        # Chính sách adaptive: dừng khi kết quả đã bão hòa
+        if state.get("stopped_reason") == "plateau":
+            return "answer"
+        
         # Luôn quay lại decide_action để LLM quyết định có cần tìm kiếm thêm
         return "continue"
     
-    def query(self, question: str) -> Dict[str, Any]:
+    def query(
+        self,
+        question: str,
+        iteration_policy: Optional[Dict[str, Any]] = None,
+        query_variants: Optional[List[str]] = None,
+        on_retrieval: Optional[Callable[[Dict[str, Any]], None]] = None,
+        on_token: Optional[Callable[[str], None]] = None,
+        stage_budgets: Optional[Dict[str, float]] = None,
+        history: Optional[List[Dict[str, str]]] = None,
+        conversation_summary: Optional[str] = None,
+        namespace: Optional[str] = None,
+        collection: Optional[str] = None,
+        exclude_collections: Optional[List[str]] = None,
+        deadlines: Optional[Dict[str, Any]] = None,
+        source_priors: Optional[Dict[str, float]] = None,
+        context_documents: Optional[List[Dict[str, str]]] = None,
+        language: Optional[str] = None,
+        style_instructions: Optional[str] = None,
+        model: Optional[str] = None,
+        response_format: Optional[str] = None
+    ) -> Dict[str, Any]:
         """
         Thực hiện query với agentic RAG.
         
         Args:
             question: Câu hỏi của người dùng
+            iteration_policy: Chính sách dừng lặp; mode "adaptive" dừng sớm khi
+                novelty và điểm tin cậy bão hòa (mặc định: fixed)
+            query_variants: Các cách viết lại câu hỏi, tìm kiếm song song với
+                câu hỏi gốc ở lần đầu
+            on_retrieval: Hàm nhận search_results và web_results khi tìm kiếm
+                xong, trước khi tạo câu trả lời
+            on_token: Hàm nhận từng đoạn câu trả lời khi LLM sinh ra
+            stage_budgets: Thời gian tối đa (giây) cho mỗi lần chạy giai đoạn
+                "retrieval", "iteration" và "answer"; giai đoạn vượt ngân
+                sách được bỏ qua và pipeline tiếp tục với kết quả đã có
+            history: Các lượt hỏi đáp trước của phiên chat, cũ nhất trước;
+                lần tìm kiếm đầu kèm câu hỏi trước và câu trả lời dựa vào
+                cả hội thoại
+            conversation_summary: Tóm tắt các lượt cũ hơn history, dùng khi
+                tạo câu trả lời
+            namespace: Chỉ tìm trong tài liệu riêng của namespace này
+            collection: Chỉ tìm trong bộ tài liệu này của corpus chung hoặc
+                của namespace
+            exclude_collections: Các bộ tài liệu của corpus chung không được
+                tìm khi không chỉ định collection, những bộ người hỏi không
+                có quyền xem
+            deadlines: Hạn chót tính từ lúc bắt đầu, {"soft_ms", "hard_ms",
+                "fast_model"}; quá soft_ms thì dừng lặp và trả lời bằng
+                fast_model, mọi giai đoạn bị cắt ở hard_ms
+            source_priors: Trọng số mức hữu ích của nguồn theo tiêu đề điều
+                luật; kết quả được xếp theo điểm nhân trọng số
+            context_documents: Tài liệu người dùng gửi kèm câu hỏi (name,
+                text, source), đưa vào context khi tạo câu trả lời
+            language: Ngôn ngữ trả lời, vi (mặc định) hoặc en
+            style_instructions: Yêu cầu về độ dài, giọng văn và người đọc,
+                thêm vào prompt tạo câu trả lời
+            model: Model Ollama tạo câu trả lời thay cho model mặc định; các
+                quyết định tìm kiếm vẫn dùng model mặc định
+            response_format: Cách trình bày câu trả lời, markdown hoặc text
             
         Returns:
             Dict chứa answer, search_results và web_results
@@ -619,10 +1032,13 @@ class LegalRAGAgent:
         if not self.workflow:
             raise ValueError("Agent chưa được khởi tạo. Gọi initialize() trước.")
         
+        # Câu hỏi tiếp theo thường dựa vào chủ đề của câu hỏi trước
+        first_query = f"{history[-1].get('question', '')} {question}".strip() if history else question
+        
         # Khởi tạo state
         initial_state: AgentState = {
             "question": question,
-            "query": question,
+            "query": first_query,
             "search_results": [],
             "web_results": [],  # NEW: Initialize web results
             "answer": None,
@@ -630,7 +1046,34 @@ class LegalRAGAgent:
             "max_iterations": self.max_iterations,
             "needs_refinement": False,
             "should_continue": True,
-            "use_web_search": False  # NEW: Initialize web search flag
+            "use_web_search": False,  # NEW: Initialize web search flag
+            "iteration_policy": iteration_policy or {"mode": "fixed"},
+            "iteration_signals": [],
+            "stopped_reason": None,
+            "query_variants": query_variants or [],
+            "speculation": None,
+            "on_retrieval": on_retrieval,
+            "on_token": on_token,
+            "history": history or [],
+            "conversation_summary": conversation_summary,
+            "stage_budgets": stage_budgets or {},
+            "stage_timeouts": [],
+            "namespace": namespace,
+            "collection": collection,
+            "exclude_collections": exclude_collections or [],
+            "deadlines": {
+                "soft": deadlines["soft_ms"] / 1000,
+                "hard": deadlines["hard_ms"] / 1000,
+                "fast_model": deadlines.get("fast_model")
+            } if deadlines else {},
+            "started_at": time.monotonic(),
+            "downgrade": None,
+            "source_priors": source_priors or {},
+            "context_documents": context_documents or [],
+            "language": language or "vi",
+            "style_instructions": style_instructions,
+            "model": model,
+            "response_format": response_format
         }
         
         # Chạy workflow
@@ -642,13 +1085,57 @@ class LegalRAGAgent:
         
         final_state = self.workflow.invoke(initial_state)
         
+        iterations = final_state.get("iteration", 0)
+        stopped_reason = final_state.get("stopped_reason")
+        if not stopped_reason:
+            stopped_reason = "max_iterations" if iterations >= self.max_iterations else "agent_decision"
+        
         return {
             "answer": final_state.get("answer", "Không thể tạo câu trả lời."),
             "search_results": final_state.get("search_results", []),
             "web_results": final_state.get("web_results", []),  # NEW: Return web results
-            "iterations": final_state.get("iteration", 0),
-            "query_used": final_state.get("query", question)
+            "iterations": iterations,
+            "query_used": final_state.get("query", question),
+            "iteration_signals": final_state.get("iteration_signals", []),
+            "stopped_reason": stopped_reason,
+            "speculation": final_state.get("speculation"),
+            "stage_timeouts": final_state.get("stage_timeouts", []),
+            "downgrade": final_state.get("downgrade")
         }
+    
+    def summarize_conversation(
+        self,
+        turns: List[Dict[str, str]],
+        summary: Optional[str] = None,
+        max_words: int = 250
+    ) -> str:
+        """
+        Tóm tắt các lượt hỏi đáp của một hội thoại dài, gộp với tóm tắt cũ.
+        
+        Args:
+            turns: Các lượt cần tóm tắt, cũ nhất trước
+            summary: Tóm tắt các lượt trước đó, nếu có
+            max_words: Số từ tối đa của tóm tắt
+            
+        Returns:
+            Tóm tắt mới, thay cho tóm tắt cũ
+        """
+        if not self.llm:
+            raise ValueError("Agent chưa được khởi tạo. Gọi initialize() trước.")
+        
+        conversation = "\n\n".join(
+            f"Hỏi: {turn.get('question', '')}\nĐáp: {turn.get('answer', '')}" for turn in turns
+        )
+        previous = f"Tóm tắt trước đó:\n{summary}\n\n" if summary else ""
+        prompt = (
+            "Bạn đang tóm tắt một cuộc hội thoại tư vấn pháp luật để dùng làm ngữ cảnh cho các câu hỏi tiếp theo.\n"
+            f"{previous}Các lượt hỏi đáp mới:\n{conversation}\n\n"
+            f"Viết một bản tóm tắt duy nhất, tối đa {max_words} từ, bằng tiếng Việt. "
+            "Giữ lại tình huống của người hỏi, các điều luật đã viện dẫn và các kết luận chính; "
+            "bỏ lời chào và chi tiết lặp lại. Chỉ trả về bản tóm tắt."
+        )
+        response = self.llm.invoke([HumanMessage(content=prompt)])
+        return response.content.strip()
 
 
 def main():
@@ -3,7 +3,7 @@ Module để tạo câu trả lời tự nhiên từ kết quả tìm kiếm s
 """
 
 import requests
-from typing import List, Dict, Any, Optional
+from typing import List, Dict, Any, Optional, Callable
 import json
 
 # Import PromptTemplates if available
@@ -16,6 +16,20 @@ except ImportError:
         PromptTemplates = None
 
 
+# Ngôn ngữ trả lời backend cho phép, như trong câu chỉ dẫn của system prompt
+ANSWER_LANGUAGES = {
+    "vi": "tiếng Việt",
+    "en": "tiếng Anh (English), giữ nguyên tên văn bản và số hiệu điều luật theo tiếng Việt"
+}
+
+
+# Chỉ dẫn trình bày theo response_format của query
+RESPONSE_FORMATS = {
+    "markdown": "Trình bày bằng Markdown.",
+    "text": "Trình bày bằng văn bản thuần, KHÔNG dùng Markdown (không dùng #, *, bảng hay khối mã)."
+}
+
+
 class OllamaGenerator:
     """Class để generate câu trả lời từ Ollama LLM."""
     
@@ -85,7 +99,9 @@ class OllamaGenerator:
         prompt: str,
         system_prompt: Optional[str] = None,
         temperature: float = 0.7,
-        max_tokens: int = 1000
+        max_tokens: int = 1000,
+        on_token: Optional[Callable[[str], None]] = None,
+        model_name: Optional[str] = None
     ) -> str:
         """
         Generate câu trả lời từ prompt.
@@ -95,14 +111,17 @@ class OllamaGenerator:
             system_prompt: System prompt (optional)
             temperature: Temperature cho generation (0.0 - 1.0)
             max_tokens: Số token tối đa
+            on_token: Hàm nhận từng đoạn câu trả lời khi Ollama sinh ra
+                (chế độ luồng)
+            model_name: Model dùng thay cho model mặc định (optional)
             
         Returns:
             Câu trả lời được generate
         """
         payload = {
-            "model": self.model_name,
+            "model": model_name or self.model_name,
             "prompt": prompt,
-            "stream": False,
+            "stream": on_token is not None,
             "options": {
                 "temperature": temperature,
                 "num_predict": max_tokens
@@ -116,12 +135,28 @@ class OllamaGenerator:
             response = requests.post(
                 self.api_url,
                 json=payload,
-                timeout=120
+                timeout=120,
+                stream=on_token is not None
             )
             response.raise_for_status()
             
-            result = response.json()
-            return result.get('response', '').strip()
+            if on_token is None:
+                result = response.json()
+                return result.get('response', '').strip()
+            
+            # Chế độ luồng: mỗi dòng là một JSON với đoạn tiếp theo
+            parts = []
+            for line in response.iter_lines():
+                if not line:
+                    continue
+                chunk = json.loads(line)
+                text = chunk.get('response', '')
+                if text:
+                    parts.append(text)
+                    on_token(text)
+                if chunk.get('done'):
+                    break
+            return "".join(parts).strip()
             
         except requests.exceptions.RequestException as e:
             raise ConnectionError(f"Không thể kết nối với Ollama: {e}")
@@ -132,7 +167,12 @@ class OllamaGenerator:
         self,
         question: str,
         search_results: List[Dict[str, Any]],
-        language: str = "vi"
+        language: str = "vi",
+        on_token: Optional[Callable[[str], None]] = None,
+        model_name: Optional[str] = None,
+        context_documents: Optional[List[Dict[str, str]]] = None,
+        style_instructions: Optional[str] = None,
+        response_format: Optional[str] = None
     ) -> str:
         """
         Generate câu trả lời từ câu hỏi và kết quả tìm kiếm.
@@ -141,12 +181,29 @@ class OllamaGenerator:
             question: Câu hỏi của người dùng
             search_results: List các kết quả tìm kiếm từ Qdrant
             language: Ngôn ngữ trả lời (mặc định: tiếng Việt)
+            on_token: Hàm nhận từng đoạn câu trả lời (chế độ luồng)
+            model_name: Model dùng thay cho model mặc định (optional)
+            context_documents: Tài liệu người dùng gửi kèm câu hỏi (name,
+                text, source), đặt trước các điều luật trong context
+            style_instructions: Yêu cầu về độ dài, giọng văn và người đọc của
+                câu trả lời, thêm vào cuối user prompt
+            response_format: markdown hoặc text (optional)
             
         Returns:
             Câu trả lời được generate
         """
-        # Tạo context từ search results
+        # Tài liệu ngữ cảnh của riêng câu hỏi này (tệp đính kèm, trang web, văn bản OCR)
         context_parts = []
+        for i, doc in enumerate(context_documents or [], 1):
+            context_item = f"[Tài liệu ngữ cảnh {i}]\n"
+            if doc.get('name'):
+                context_item += f"Tên: {doc['name']}\n"
+            if doc.get('source'):
+                context_item += f"Nguồn: {doc['source']}\n"
+            context_item += f"Nội dung: {doc.get('text', '')}\n"
+            context_parts.append(context_item)
+        
+        # Tạo context từ search results
         for i, result in enumerate(search_results, 1):
             metadata = result.get('metadata', {})
             text = result.get('text', '')
@@ -165,15 +222,18 @@ class OllamaGenerator:
         context = "\n".join(context_parts)
         
         # Use prompt templates if available, otherwise use default
+        answer_language = ANSWER_LANGUAGES.get(language, ANSWER_LANGUAGES["vi"])
         if self.prompt_templates:
             system_prompt = self.prompt_templates.get_system_prompt()
+            if language != "vi":
+                system_prompt += f"\n\nTrả lời bằng {answer_language}."
             user_prompt = self.prompt_templates.get_user_prompt(
                 context=context,
                 question=question
             )
         else:
             # Fallback to default prompts
-            system_prompt = """Bạn là trợ lý pháp lý chuyên nghiệp, chuyên tư vấn Bộ luật Lao động Việt Nam.
+            system_prompt = f"""Bạn là trợ lý pháp lý chuyên nghiệp, chuyên tư vấn Bộ luật Lao động Việt Nam.
 
 QUY TẮC BẮT BUỘC (NGHIÊM NGẶT):
 1. CHỈ sử dụng thông tin từ các điều luật được cung cấp bên dưới
@@ -183,9 +243,9 @@ QUY TẮC BẮT BUỘC (NGHIÊM NGẶT):
 5. LUÔN trích dẫn chính xác số điều và khoản khi đưa ra thông tin
 6. Nếu câu hỏi hỏi về con số cụ thể (%, số tiền, số ngày) mà điều luật không nêu rõ, hãy nói: "Điều luật không quy định cụ thể về [vấn đề]"
 
-Trả lời bằng tiếng Việt, rõ ràng, chính xác, trung thực."""
+Trả lời bằng {answer_language}; rõ ràng, chính xác, trung thực."""
 
-            user_prompt = f"""Dựa CHÍNH XÁC và HOÀN TOÀN vào các điều luật sau, hãy trả lời câu hỏi:
+            user_prompt = f"""Dựa CHÍNH XÁC và HOÀN TOÀN vào các tài liệu và điều luật sau, hãy trả lời câu hỏi:
 
 {context}
 
@@ -198,12 +258,19 @@ Hãy trả lời theo cấu trúc:
 
 Nhớ: CHỈ dùng thông tin từ các điều luật trên, KHÔNG bịa thêm."""
 
+        if response_format in RESPONSE_FORMATS:
+            user_prompt += f"\n\n{RESPONSE_FORMATS[response_format]}"
+        if style_instructions:
+            user_prompt += f"\n\nYêu cầu về cách trả lời: {style_instructions}"
+
         # Generate answer with strict grounding
         answer = self.generate(
             prompt=user_prompt,
             system_prompt=system_prompt,
             temperature=0.1,  # Giảm xuống 0.1 để chính xác hơn, ít hallucination
-            max_tokens=2000
+            max_tokens=2000,
+            on_token=on_token,
+            model_name=model_name
         )
         
         return answer
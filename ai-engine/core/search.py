@@ -4,8 +4,10 @@ Module để tìm kiếm các điều luật liên quan từ câu hỏi của ng
 
 import sys
 import os
+import re
+import uuid
 from pathlib import Path
-from typing import List, Dict, Any, Optional
+from typing import List, Dict, Any, Optional, Callable
 
 # Thêm thư mục ai-engine vào path để import
 current_file = Path(__file__).resolve()
@@ -15,7 +17,9 @@ sys.path.insert(0, str(ai_engine_dir))
 # Import modules
 from embedding.embedder import VietnameseEmbedder
 from qdrant_client import QdrantClient
-from qdrant_client.models import Filter, FieldCondition, MatchValue
+from qdrant_client.models import (
+    Distance, FieldCondition, Filter, FilterSelector, MatchAny, MatchValue, PointStruct, VectorParams
+)
 
 # Import LLM generator
 try:
@@ -79,7 +83,10 @@ class LegalSearch:
         self,
         query: str,
         top_k: int = 3,
-        score_threshold: Optional[float] = None
+        score_threshold: Optional[float] = None,
+        namespace: Optional[str] = None,
+        collection: Optional[str] = None,
+        exclude_collections: Optional[List[str]] = None
     ) -> List[Dict[str, Any]]:
         """
         Tìm kiếm các điều luật liên quan với câu hỏi.
@@ -88,6 +95,12 @@ class LegalSearch:
             query: Câu hỏi của người dùng
             top_k: Số lượng kết quả trả về (mặc định 3)
             score_threshold: Ngưỡng điểm tối thiểu (None = không giới hạn)
+            namespace: Tìm trong tài liệu riêng của namespace thay vì corpus
+                chung; hai bên không bao giờ trộn lẫn
+            collection: Chỉ tìm trong bộ tài liệu này, của corpus chung hoặc
+                của namespace
+            exclude_collections: Bỏ qua các bộ tài liệu này khi không chỉ
+                định collection, ví dụ những bộ người hỏi không có quyền xem
             
         Returns:
             List các dict chứa thông tin điều luật liên quan:
@@ -100,6 +113,25 @@ class LegalSearch:
         if not self.embedder or not self.client:
             raise ValueError("Chưa khởi tạo. Gọi initialize() trước.")
         
+        collection_name = self.collection_name
+        if namespace:
+            collection_name = self.namespace_collection(namespace)
+            # Namespace chưa có tài liệu nào
+            if not self.client.collection_exists(collection_name):
+                return []
+        
+        # Quyền xem bộ tài liệu được áp dụng ngay khi tìm kiếm, để đoạn của
+        # bộ bị hạn chế không bao giờ lọt vào kết quả
+        query_filter = None
+        if collection:
+            query_filter = Filter(must=[
+                FieldCondition(key="collection", match=MatchValue(value=collection))
+            ])
+        elif exclude_collections:
+            query_filter = Filter(must_not=[
+                FieldCondition(key="collection", match=MatchAny(any=list(exclude_collections)))
+            ])
+        
         # Embed câu hỏi
         print(f"\nĐang embed câu hỏi: '{query}'")
         query_embedding = self.embedder.encode_single(query)
@@ -112,8 +144,9 @@ class LegalSearch:
         # Dùng query_points với vector trực tiếp (API mới)
         # query_points có thể nhận vector trực tiếp hoặc NamedVector
         query_result = self.client.query_points(
-            collection_name=self.collection_name,
+            collection_name=collection_name,
             query=query_vector,
+            query_filter=query_filter,
             limit=top_k,
             score_threshold=score_threshold
         )
@@ -138,6 +171,110 @@ class LegalSearch:
         
         return results
     
+    def namespace_collection(self, namespace: str) -> str:
+        """Tên collection Qdrant chứa tài liệu riêng của một namespace."""
+        return f"{self.collection_name}__ns__{namespace}"
+    
+    @staticmethod
+    def _chunk_text(text: str, max_chars: int = 1500) -> List[str]:
+        """
+        Chia văn bản thành các đoạn không quá max_chars ký tự, theo ranh giới
+        đoạn văn; đoạn văn quá dài được chia theo câu.
+        """
+        pieces: List[str] = []
+        for paragraph in re.split(r"\n\s*\n", text):
+            paragraph = " ".join(paragraph.split())
+            if not paragraph:
+                continue
+            if len(paragraph) <= max_chars:
+                pieces.append(paragraph)
+                continue
+            for sentence in re.split(r"(?<=[.!?;:])\s+", paragraph):
+                while len(sentence) > max_chars:
+                    pieces.append(sentence[:max_chars])
+                    sentence = sentence[max_chars:]
+                if sentence:
+                    pieces.append(sentence)
+        
+        chunks: List[str] = []
+        for piece in pieces:
+            if chunks and len(chunks[-1]) + 1 + len(piece) <= max_chars:
+                chunks[-1] = f"{chunks[-1]} {piece}"
+            else:
+                chunks.append(piece)
+        return chunks
+    
+    def index_document(
+        self,
+        namespace: str,
+        document_id: str,
+        text: str,
+        title: str = "",
+        collection: str = ""
+    ) -> int:
+        """
+        Đánh chỉ mục một tài liệu riêng trong collection Qdrant của namespace,
+        thay thế tài liệu cùng mã nếu đã có.
+        
+        Args:
+            namespace: Namespace của tài liệu, ví dụ tenant-acme
+            document_id: Mã tài liệu
+            text: Nội dung tài liệu
+            title: Tiêu đề tài liệu
+            collection: Bộ tài liệu trong namespace
+            
+        Returns:
+            Số đoạn đã đánh chỉ mục
+        """
+        if not self.embedder or not self.client:
+            raise ValueError("Chưa khởi tạo. Gọi initialize() trước.")
+        
+        chunks = self._chunk_text(text)
+        if not chunks:
+            raise ValueError("Tài liệu không có nội dung")
+        vectors = self.embedder.encode(chunks, show_progress_bar=False)
+        
+        collection_name = self.namespace_collection(namespace)
+        if not self.client.collection_exists(collection_name):
+            self.client.create_collection(
+                collection_name=collection_name,
+                vectors_config=VectorParams(size=len(vectors[0]), distance=Distance.COSINE)
+            )
+        self.delete_document(namespace, document_id)
+        
+        points = []
+        for i, (chunk, vector) in enumerate(zip(chunks, vectors)):
+            points.append(PointStruct(
+                id=str(uuid.uuid5(uuid.NAMESPACE_URL, f"{namespace}/{document_id}/{i}")),
+                vector=vector.tolist(),
+                payload={
+                    "text": chunk,
+                    "namespace": namespace,
+                    "collection": collection,
+                    "document_id": document_id,
+                    "document_title": title,
+                    "chunk_index": i,
+                    "content_type": "private_document"
+                }
+            ))
+        self.client.upsert(collection_name=collection_name, points=points)
+        return len(points)
+    
+    def delete_document(self, namespace: str, document_id: str) -> None:
+        """Xóa mọi đoạn của một tài liệu riêng; tài liệu không tồn tại thì bỏ qua."""
+        if not self.client:
+            raise ValueError("Chưa khởi tạo. Gọi initialize() trước.")
+        
+        collection_name = self.namespace_collection(namespace)
+        if not self.client.collection_exists(collection_name):
+            return
+        self.client.delete(
+            collection_name=collection_name,
+            points_selector=FilterSelector(filter=Filter(must=[
+                FieldCondition(key="document_id", match=MatchValue(value=document_id))
+            ]))
+        )
+    
     def search_with_filter(
         self,
         query: str,
@@ -316,7 +453,13 @@ class LegalSearch:
         self,
         question: str,
         results: Optional[List[Dict[str, Any]]] = None,
-        top_k: int = 3
+        top_k: int = 3,
+        on_token: Optional[Callable[[str], None]] = None,
+        model_name: Optional[str] = None,
+        context_documents: Optional[List[Dict[str, str]]] = None,
+        language: str = "vi",
+        style_instructions: Optional[str] = None,
+        response_format: Optional[str] = None
     ) -> str:
         """
         Tìm kiếm và generate câu trả lời tự nhiên.
@@ -325,6 +468,12 @@ class LegalSearch:
             question: Câu hỏi của người dùng
             results: Kết quả tìm kiếm (nếu None sẽ tự động search)
             top_k: Số lượng kết quả để dùng làm context
+            on_token: Hàm nhận từng đoạn câu trả lời (chế độ luồng)
+            model_name: Model dùng thay cho model mặc định (optional)
+            context_documents: Tài liệu người dùng gửi kèm câu hỏi (optional)
+            language: Ngôn ngữ trả lời, vi hoặc en (mặc định: vi)
+            style_instructions: Yêu cầu về cách trả lời (optional)
+            response_format: markdown hoặc text (optional)
             
         Returns:
             Câu trả lời được generate
@@ -338,7 +487,16 @@ class LegalSearch:
         
         # Generate answer
         print("\nĐang tạo câu trả lời với LLM...")
-        answer = self.llm_generator.generate_answer(question, results)
+        answer = self.llm_generator.generate_answer(
+            question,
+            results,
+            on_token=on_token,
+            model_name=model_name,
+            context_documents=context_documents,
+            language=language,
+            style_instructions=style_instructions,
+            response_format=response_format
+        )
         
         return answer
     
@@ -0,0 +1,66 @@
+"""
+Test prompt tạo câu trả lời của OllamaGenerator, không cần Ollama.
+
+Chạy: python -m unittest discover -s tests
+"""
+
+import sys
+import types
+import unittest
+from pathlib import Path
+from unittest import mock
+
+sys.path.insert(0, str(Path(__file__).resolve().parent.parent))
+# Prompt không gọi mạng, nên chạy được cả khi chưa cài requests
+sys.modules.setdefault("requests", types.ModuleType("requests"))
+
+from core.llm_generator import OllamaGenerator
+
+
+class GenerateAnswerPromptTest(unittest.TestCase):
+    def prompt(self, **kwargs) -> str:
+        generator = OllamaGenerator()
+        with mock.patch.object(generator, "generate", return_value="OK") as generate:
+            generator.generate_answer(
+                "Hợp đồng này có điều khoản thử việc trái luật không?",
+                [{"text": "Thời gian thử việc không quá 60 ngày.", "metadata": {"article_id": "Điều 25"}}],
+                **kwargs
+            )
+        return generate.call_args.kwargs["prompt"]
+
+    def test_context_documents_are_in_the_prompt(self):
+        prompt = self.prompt(context_documents=[
+            {"name": "hop-dong.txt", "text": "Thời gian thử việc là 90 ngày.", "source": "upload"}
+        ])
+        self.assertIn("[Tài liệu ngữ cảnh 1]", prompt)
+        self.assertIn("Tên: hop-dong.txt", prompt)
+        self.assertIn("Thời gian thử việc là 90 ngày.", prompt)
+        # Tài liệu đứng trước các điều luật
+        self.assertLess(prompt.index("90 ngày"), prompt.index("Điều 25"))
+
+    def test_prompt_without_context_documents(self):
+        prompt = self.prompt()
+        self.assertNotIn("Tài liệu ngữ cảnh", prompt)
+        self.assertIn("Thời gian thử việc không quá 60 ngày.", prompt)
+
+
+    def test_style_instructions_end_the_prompt(self):
+        prompt = self.prompt(style_instructions="Trả lời ngắn gọn, tối đa 3 câu.")
+        self.assertTrue(prompt.endswith("Yêu cầu về cách trả lời: Trả lời ngắn gọn, tối đa 3 câu."))
+
+    def test_text_response_format_asks_for_plain_text(self):
+        self.assertIn("KHÔNG dùng Markdown", self.prompt(response_format="text"))
+        self.assertNotIn("Markdown", self.prompt())
+
+    def test_language_sets_the_system_prompt(self):
+        generator = OllamaGenerator()
+        with mock.patch.object(generator, "generate", return_value="OK") as generate:
+            generator.generate_answer("Thời gian thử việc tối đa?", [], language="en")
+        self.assertIn("Trả lời bằng tiếng Anh (English)", generate.call_args.kwargs["system_prompt"])
+        with mock.patch.object(generator, "generate", return_value="OK") as generate:
+            generator.generate_answer("Thời gian thử việc tối đa?", [])
+        self.assertIn("Trả lời bằng tiếng Việt", generate.call_args.kwargs["system_prompt"])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,114 @@
+"""
+Test phạm vi tìm kiếm của LegalSearch trên Qdrant chạy trong bộ nhớ
+(qdrant-client local mode), không cần server Qdrant hay model embedding.
+
+Chạy: python -m unittest discover -s tests
+"""
+
+import sys
+import types
+import unittest
+from pathlib import Path
+
+sys.path.insert(0, str(Path(__file__).resolve().parent.parent))
+# Câu hỏi và tài liệu được embed bằng FakeEmbedder, nên chạy được cả khi
+# chưa cài sentence-transformers và requests
+sys.modules.setdefault("requests", types.ModuleType("requests"))
+sys.modules.setdefault("embedding", types.ModuleType("embedding"))
+sys.modules.setdefault("embedding.embedder", types.SimpleNamespace(VietnameseEmbedder=object))
+
+try:
+    from qdrant_client import QdrantClient
+    from qdrant_client.models import Distance, PointStruct, VectorParams
+except ImportError:
+    QdrantClient = None
+else:
+    from core.search import LegalSearch
+
+
+class Vector(list):
+    def tolist(self):
+        return list(self)
+
+
+class FakeEmbedder:
+    """Embed mọi văn bản thành cùng một vector, để mọi đoạn đều khớp."""
+
+    def encode_single(self, text):
+        return Vector([1.0, 0.0, 0.0, 0.0])
+
+    def encode(self, texts, show_progress_bar=False):
+        return [self.encode_single(text) for text in texts]
+
+
+def corpus_search(corpus):
+    """LegalSearch trên Qdrant trong bộ nhớ, với corpus chung gồm các cặp
+    (nội dung, bộ tài liệu hoặc None)."""
+    search = LegalSearch(collection_name="legal_documents")
+    search.client = QdrantClient(":memory:")
+    search.embedder = FakeEmbedder()
+    search.client.create_collection(
+        collection_name="legal_documents",
+        vectors_config=VectorParams(size=4, distance=Distance.COSINE)
+    )
+    search.client.upsert(collection_name="legal_documents", points=[
+        PointStruct(
+            id=i,
+            vector=[1.0, 0.0, 0.0, 0.0],
+            payload={"text": text, **({"collection": collection} if collection else {})}
+        )
+        for i, (text, collection) in enumerate(corpus)
+    ])
+    return search
+
+
+class ScopedSearchTest(unittest.TestCase):
+    def texts(self, **kwargs):
+        return sorted(r["text"] for r in self.search.search("Thời gian thử việc?", top_k=10, **kwargs))
+
+
+@unittest.skipIf(QdrantClient is None, "qdrant-client chưa được cài")
+class SearchScopeTest(ScopedSearchTest):
+    def setUp(self):
+        self.search = corpus_search([
+            ("Điều 25", None),
+            ("Điều 26", "luat_lao_dong"),
+            ("Bản ghi nhớ thử việc", "internal_memos")
+        ])
+
+    def test_unscoped_search_leaves_out_excluded_collections(self):
+        self.assertEqual(self.texts(exclude_collections=["internal_memos"]), ["Điều 25", "Điều 26"])
+        self.assertEqual(self.texts(), ["Bản ghi nhớ thử việc", "Điều 25", "Điều 26"])
+
+    def test_collection_scopes_the_corpus(self):
+        self.assertEqual(self.texts(collection="internal_memos"), ["Bản ghi nhớ thử việc"])
+        # Collection được chỉ định thì không bị loại
+        self.assertEqual(self.texts(collection="luat_lao_dong", exclude_collections=["internal_memos"]), ["Điều 26"])
+
+
+@unittest.skipIf(QdrantClient is None, "qdrant-client chưa được cài")
+class NamespaceIsolationTest(ScopedSearchTest):
+    def setUp(self):
+        self.search = corpus_search([("Điều 25", None)])
+        self.search.index_document("tenant-acme", "hd1", "Thời gian thử việc của ACME là 30 ngày.", collection="hop_dong")
+        self.search.index_document("tenant-globex", "hd1", "Thời gian thử việc của Globex là 60 ngày.", collection="hop_dong")
+
+    def test_a_namespace_sees_only_its_documents(self):
+        self.assertEqual(self.texts(namespace="tenant-acme"), ["Thời gian thử việc của ACME là 30 ngày."])
+        self.assertEqual(self.texts(namespace="tenant-acme", collection="hop_dong"), ["Thời gian thử việc của ACME là 30 ngày."])
+        self.assertEqual(self.texts(namespace="tenant-globex"), ["Thời gian thử việc của Globex là 60 ngày."])
+        # Namespace chưa có tài liệu không thấy tài liệu của namespace khác
+        self.assertEqual(self.texts(namespace="tenant-initech"), [])
+
+    def test_the_corpus_never_returns_private_documents(self):
+        self.assertEqual(self.texts(), ["Điều 25"])
+        self.assertEqual(self.texts(collection="hop_dong"), [])
+
+    def test_delete_stays_in_its_namespace(self):
+        self.search.delete_document("tenant-acme", "hd1")
+        self.assertEqual(self.texts(namespace="tenant-acme"), [])
+        self.assertEqual(self.texts(namespace="tenant-globex"), ["Thời gian thử việc của Globex là 60 ngày."])
+
+
+if __name__ == "__main__":
+    unittest.main()
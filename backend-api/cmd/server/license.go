@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/server"
+)
+
+// runIssueLicense signs a license key for an on-premises deployment with the
+// issuer's private key, whose public key the deployment is configured with
+func runIssueLicense(args []string) {
+	flags := flag.NewFlagSet("issue-license", flag.ExitOnError)
+	keyPath := flags.String("key", "", "PEM PKCS #8 private key of the license issuer (Ed25519, ECDSA or RSA)")
+	id := flags.String("id", "", "license ID")
+	licensee := flags.String("licensee", "", "name of the licensed organisation")
+	seats := flags.Int("seats", 0, "number of users that may use the server within LICENSE_SEAT_IDLE (0 = unlimited)")
+	features := flags.String("features", "", "comma-separated features to enable: "+strings.Join(server.LicenseFeatures(), ", "))
+	expires := flags.String("expires", "", "expiry date, YYYY-MM-DD")
+	flags.Parse(args)
+
+	if *keyPath == "" || *expires == "" {
+		fmt.Fprintln(os.Stderr, "-key and -expires are required")
+		os.Exit(2)
+	}
+	expiresAt, err := time.Parse(time.DateOnly, *expires)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -expires: %v\n", err)
+		os.Exit(2)
+	}
+	key, err := loadIssuerKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load issuer key: %v\n", err)
+		os.Exit(1)
+	}
+
+	license := server.License{
+		ID:        *id,
+		Licensee:  *licensee,
+		Seats:     *seats,
+		IssuedAt:  time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt,
+	}
+	for _, feature := range strings.Split(*features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			license.Features = append(license.Features, feature)
+		}
+	}
+	licenseKey, err := server.IssueLicense(key, license)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to issue license: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(licenseKey)
+}
+
+func loadIssuerKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM encoded PKCS #8 private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %T, which cannot sign", path, key)
+	}
+	return signer, nil
+}
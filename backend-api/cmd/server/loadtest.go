@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/server"
+)
+
+// syntheticQuestions is used when no question file is given
+var syntheticQuestions = []string{
+	"Thời gian thử việc tối đa bao nhiêu ngày?",
+	"Quy định về nghỉ phép năm",
+	"Thời giờ làm việc bình thường là bao nhiêu giờ một ngày?",
+	"Giới hạn số giờ làm thêm trong một tháng là bao nhiêu?",
+	"Lao động nữ được nghỉ thai sản bao lâu?",
+	"Cách tính trợ cấp thôi việc",
+	"Người lao động có được đơn phương chấm dứt hợp đồng lao động không?",
+	"Tiền lương làm thêm giờ vào ngày lễ được tính thế nào?",
+}
+
+// LoadtestReport summarises a load test run
+type LoadtestReport struct {
+	Target      string         `json:"target"`
+	Requests    int            `json:"requests"`
+	Succeeded   int            `json:"succeeded"`
+	Failed      int            `json:"failed"`
+	ErrorRate   float64        `json:"error_rate"`
+	ElapsedMs   int64          `json:"elapsed_ms"`
+	Throughput  float64        `json:"throughput_rps"`
+	LatencyMs   map[string]int `json:"latency_ms"`
+	StatusCodes map[string]int `json:"status_codes"`
+	ErrorCodes  map[string]int `json:"error_codes"`
+}
+
+type loadtestResult struct {
+	latency time.Duration
+	status  int
+	code    string
+}
+
+func runLoadtest(args []string) {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := flags.String("target", "http://localhost:8080", "base URL of the backend to test")
+	questionsPath := flags.String("questions", "", "question file: JSON array, JSON lines (history export) or plain text, one question per line; defaults to a synthetic set")
+	concurrency := flags.Int("concurrency", 4, "number of concurrent clients")
+	rps := flags.Float64("rps", 0, "maximum requests per second across all clients (0 = unlimited)")
+	requests := flags.Int("requests", 100, "total number of requests to send (ignored when -duration is set)")
+	duration := flags.Duration("duration", 0, "run for this long instead of a fixed number of requests")
+	timeout := flags.Duration("timeout", 3*time.Minute, "per-request timeout")
+	sandbox := flags.Bool("sandbox", false, "send X-Sandbox: true so the engine is not called")
+	apiKey := flags.String("api-key", "", "API key to send in X-API-Key, when the backend requires one")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	if *concurrency < 1 {
+		fatal("-concurrency must be at least 1")
+	}
+
+	questions := syntheticQuestions
+	if *questionsPath != "" {
+		loaded, err := loadQuestions(*questionsPath)
+		if err != nil {
+			fatal("Failed to load questions", "error", err)
+		}
+		questions = loaded
+	}
+
+	url := strings.TrimRight(*target, "/") + "/api/legal-query"
+	client := &http.Client{Timeout: *timeout}
+
+	jobs := make(chan string)
+	results := make(chan loadtestResult, *concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for question := range jobs {
+				results <- sendLoadtestQuery(client, url, question, *sandbox, *apiKey)
+			}
+		}()
+	}
+
+	start := time.Now()
+	go func() {
+		defer close(jobs)
+
+		var tick <-chan time.Time
+		if *rps > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / *rps))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for i := 0; ; i++ {
+			if *duration > 0 {
+				if time.Since(start) >= *duration {
+					return
+				}
+			} else if i >= *requests {
+				return
+			}
+			if tick != nil {
+				<-tick
+			}
+			jobs <- questions[i%len(questions)]
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var collected []loadtestResult
+	for result := range results {
+		collected = append(collected, result)
+	}
+
+	report := buildLoadtestReport(*target, collected, time.Since(start))
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printLoadtestReport(report)
+	}
+
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func sendLoadtestQuery(client *http.Client, url, question string, sandbox bool, apiKey string) loadtestResult {
+	body, _ := json.Marshal(server.LegalQueryRequest{Question: question})
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return loadtestResult{code: "CLIENT_ERROR"}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sandbox {
+		req.Header.Set("X-Sandbox", "true")
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return loadtestResult{latency: time.Since(start), code: "CONNECTION_ERROR"}
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	result := loadtestResult{latency: time.Since(start), status: resp.StatusCode}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp server.ErrorResponse
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Code != "" {
+			result.code = string(errResp.Code)
+		} else {
+			result.code = "UNKNOWN"
+		}
+	}
+	return result
+}
+
+// loadQuestions reads questions from a JSON array (of strings or objects with
+// a "question" field), JSON lines such as a history export, or plain text
+func loadQuestions(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var questions []string
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal question array: %w", err)
+		}
+		for _, item := range items {
+			if q := questionFromJSON(item); q != "" {
+				questions = append(questions, q)
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "{") {
+				line = questionFromJSON([]byte(line))
+			}
+			if line != "" {
+				questions = append(questions, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("no questions found in %s", path)
+	}
+	return questions, nil
+}
+
+func questionFromJSON(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return strings.TrimSpace(s)
+	}
+	var obj struct {
+		Question string `json:"question"`
+	}
+	if json.Unmarshal(raw, &obj) == nil {
+		return strings.TrimSpace(obj.Question)
+	}
+	return ""
+}
+
+func buildLoadtestReport(target string, results []loadtestResult, elapsed time.Duration) LoadtestReport {
+	report := LoadtestReport{
+		Target:      target,
+		Requests:    len(results),
+		ElapsedMs:   elapsed.Milliseconds(),
+		LatencyMs:   map[string]int{},
+		StatusCodes: map[string]int{},
+		ErrorCodes:  map[string]int{},
+	}
+
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		if r.status == http.StatusOK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+			report.ErrorCodes[r.code]++
+		}
+		if r.status != 0 {
+			report.StatusCodes[fmt.Sprintf("%d", r.status)]++
+		}
+		latencies = append(latencies, r.latency)
+	}
+
+	if report.Requests > 0 {
+		report.ErrorRate = float64(report.Failed) / float64(report.Requests)
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, p := range []struct {
+		name string
+		q    float64
+	}{{"p50", 0.50}, {"p90", 0.90}, {"p95", 0.95}, {"p99", 0.99}, {"max", 1}} {
+		report.LatencyMs[p.name] = int(percentile(latencies, p.q).Milliseconds())
+	}
+
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+func printLoadtestReport(r LoadtestReport) {
+	fmt.Printf("Target:      %s\n", r.Target)
+	fmt.Printf("Requests:    %d (%d succeeded, %d failed, %.2f%% errors)\n", r.Requests, r.Succeeded, r.Failed, r.ErrorRate*100)
+	fmt.Printf("Elapsed:     %v\n", time.Duration(r.ElapsedMs)*time.Millisecond)
+	fmt.Printf("Throughput:  %.2f req/s\n", r.Throughput)
+	fmt.Printf("Latency:     p50=%dms p90=%dms p95=%dms p99=%dms max=%dms\n",
+		r.LatencyMs["p50"], r.LatencyMs["p90"], r.LatencyMs["p95"], r.LatencyMs["p99"], r.LatencyMs["max"])
+
+	if len(r.StatusCodes) > 0 {
+		fmt.Println("Status codes:")
+		for _, status := range sortedKeys(r.StatusCodes) {
+			fmt.Printf("  %s: %d\n", status, r.StatusCodes[status])
+		}
+	}
+	if len(r.ErrorCodes) > 0 {
+		fmt.Println("Error codes:")
+		for _, code := range sortedKeys(r.ErrorCodes) {
+			fmt.Printf("  %s: %d\n", code, r.ErrorCodes[code])
+		}
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
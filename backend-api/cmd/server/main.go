@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/logging"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+	"github.com/nguyenvothetuyen/legal-rag-backend/server"
+)
+
+const usage = `Usage: legal-rag <command> [flags]
+
+Commands:
+  serve         Start the HTTP API server (default)
+  loadtest      Replay questions against a running server and report latency
+  check-config  Validate the configuration and report every problem found
+  doctor        Check the database schema and stored data, and repair common problems
+  issue-license Sign a license key for an on-premises deployment
+
+Run 'legal-rag <command> -h' for command flags.
+`
+
+func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServe(args)
+	case "loadtest":
+		runLoadtest(args)
+	case "check-config":
+		runCheckConfig(args)
+	case "doctor":
+		runDoctor(args)
+	case "issue-license":
+		runIssueLicense(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Bool("mock-engine", false, "run an in-process fake engine backed by fixtures instead of the Python engine (env MOCK_ENGINE)")
+	fixturesPath := flags.String("fixtures", "", "fixtures file for --mock-engine (defaults to the embedded canned responses)")
+	flags.Bool("strict", false, "refuse to start when the configuration has problems instead of falling back to defaults (env STRICT_CONFIG)")
+	overrides := server.RegisterSettingFlags(flags)
+	flags.Parse(args)
+
+	// Load configuration: flags > environment > config file > profile
+	server.LoadSettings(flags, overrides)
+	config := server.LoadConfig()
+
+	// Structured logs carrying the request ID of the request they were
+	// written for. Repeated messages, such as the same engine error on
+	// every request during an outage, are collapsed.
+	closeLogs := logging.Setup(os.Stderr, logging.Options{
+		Format:       config.LogFormat,
+		Debug:        config.LogLevel == middleware.LogDebug,
+		DedupWindow:  config.LogDedupWindow,
+		ContextAttrs: requestIDAttrs,
+	})
+	defer closeLogs()
+
+	if config.MockEngine {
+		fixtures, err := server.LoadFixtures(*fixturesPath)
+		if err != nil {
+			fatal("Failed to load mock engine fixtures", "error", err)
+		}
+		mock, err := server.StartMockEngine("127.0.0.1:0", fixtures)
+		if err != nil {
+			fatal("Failed to start mock engine", "error", err)
+		}
+		defer mock.Close()
+
+		config.PythonEngineURL = mock.URL()
+		slog.Info("Mock engine enabled", "fixtures", len(fixtures.Fixtures))
+	}
+
+	if config.Strict {
+		if problems := server.CheckConfig(config, true); len(problems) > 0 {
+			server.PrintConfigReport(problems)
+			fatal("Refusing to start in strict mode", "problems", len(problems))
+		}
+		slog.Info("Strict mode: configuration OK")
+	}
+
+	srv, err := server.NewServer(server.Options{Config: config})
+	if err != nil {
+		fatal("Failed to create server", "error", err)
+	}
+	defer srv.Close()
+
+	if err := srv.Run(); err != nil {
+		fatal("Failed to start server", "error", err)
+	}
+}
+
+// requestIDAttrs adds the request ID to the logs written for a request
+func requestIDAttrs(ctx context.Context) []slog.Attr {
+	if trace := engine.TraceFrom(ctx); trace != nil {
+		return []slog.Attr{slog.String("request_id", trace.RequestID)}
+	}
+	return nil
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func runCheckConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "skip checking that configured URLs are reachable")
+	jsonOutput := flags.Bool("json", false, "print the problems as JSON")
+	overrides := server.RegisterSettingFlags(flags)
+	flags.Parse(args)
+
+	// Only the consolidated report is printed
+	log.SetOutput(io.Discard)
+	server.LoadSettings(flags, overrides)
+	config := server.LoadConfig()
+	problems := server.CheckConfig(config, !*offline)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(struct {
+			OK       bool                   `json:"ok"`
+			Problems []server.ConfigProblem `json:"problems"`
+		}{len(problems) == 0, append([]server.ConfigProblem{}, problems...)})
+	} else if len(problems) == 0 {
+		fmt.Println("Configuration OK")
+	} else {
+		server.PrintConfigReport(problems)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair the problems that have a safe repair; stop the server first")
+	jsonOutput := flags.Bool("json", false, "print the checks as JSON")
+	overrides := server.RegisterSettingFlags(flags)
+	flags.Parse(args)
+
+	log.SetOutput(io.Discard)
+	server.LoadSettings(flags, overrides)
+	checks := server.RunDoctor(server.LoadConfig(), *repair)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(checks)
+	} else {
+		server.PrintDoctorReport(checks)
+	}
+	for _, check := range checks {
+		if check.Status == server.DoctorProblems {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the engine while its circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("engine circuit breaker is open")
+
+// CircuitOpenError is ErrCircuitOpen while the breaker waits out its
+// cooldown, with the time left until a probe query is let through
+type CircuitOpenError struct {
+	Failures int
+	RetryIn  time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v after %d failures in a row; retry in %v", ErrCircuitOpen, e.Failures, e.RetryIn.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every query through
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen fails every query at once until the cooldown has passed
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen lets a single probe query through; its outcome closes
+	// or reopens the breaker
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// CircuitBreaker stops sending queries to an engine that failed Threshold
+// times in a row, so that callers get an error at once instead of waiting
+// for the timeout of every query. After the cooldown a probe query is let
+// through; the breaker closes when it succeeds and reopens when it fails.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	// OnStateChange, when set, is called after every change of state
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	trips    int
+	now      func() time.Time
+}
+
+// BreakerStats describes a circuit breaker
+type BreakerStats struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Threshold           int          `json:"threshold"`
+	Cooldown            string       `json:"cooldown"`
+	Trips               int          `json:"trips"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a query may be sent. Once the cooldown has passed,
+// the first query becomes the probe and the others keep failing fast.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerOpen:
+		wait := b.cooldown - b.now().Sub(b.openedAt)
+		if wait > 0 {
+			b.mu.Unlock()
+			return &CircuitOpenError{Failures: b.failures, RetryIn: wait}
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return fmt.Errorf("%w; a probe query is testing the engine", ErrCircuitOpen)
+		}
+		b.probing = true
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+	return nil
+}
+
+// record counts the outcome of a query let through. Queries the caller
+// cancelled tell nothing about the engine and only end a probe.
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case ctx.Err() == context.Canceled:
+		if b.state == BreakerHalfOpen {
+			b.probing = false
+		}
+	case !engineFailure(err):
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+	case b.state == BreakerHalfOpen:
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.probing = false
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openedAt = b.now()
+			b.trips++
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+func (b *CircuitBreaker) changed(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+// Stats returns the current state of the breaker
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Threshold:           b.threshold,
+		Cooldown:            b.cooldown.String(),
+		Trips:               b.trips,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt.UTC()
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
+
+// engineFailure reports an error that means the engine is down or
+// overloaded. An engine rejecting the request itself is up.
+func engineFailure(err error) bool {
+	var statusErr *EngineStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return err != nil
+}
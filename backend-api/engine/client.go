@@ -0,0 +1,410 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// HTTP Client for Python AI Engine
+type PythonClient struct {
+	baseURL string
+
+	// httpClient is replaced, not changed, by SetTimeout
+	httpClient atomic.Pointer[http.Client]
+
+	// Retry is applied to queries; the zero value sends each query once
+	Retry RetryPolicy
+
+	// Breaker, when set, fails queries at once while the engine is down.
+	// A query counts once for the breaker, after its retries.
+	Breaker *CircuitBreaker
+
+	// budgetsIgnored is set once the engine answered without applying the
+	// stage budgets, so the warning is logged once
+	budgetsIgnored atomic.Bool
+}
+
+// RetryPolicy retries queries that failed transiently: the engine refused
+// the connection or answered 502, 503 or 504. The wait before retry n is
+// drawn between half and all of BaseDelay*2^(n-1), capped at MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt; below 2 nothing is retried
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Deadline bounds all attempts and the waits between them; no retry
+	// is made that would start after it. Zero leaves only the timeout of
+	// each attempt.
+	Deadline time.Duration
+}
+
+// backoff is the wait before the retry following attempt n, counted from 1
+func (p RetryPolicy) backoff(n int) time.Duration {
+	delay := p.BaseDelay << (n - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryable reports whether a failed attempt may succeed if sent again. A
+// timeout is not retried: the attempt already used its whole budget.
+func retryable(err error) bool {
+	var statusErr *EngineStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// NewPythonClient creates a client for the engine at baseURL. A nil
+// transport uses http.DefaultTransport.
+func NewPythonClient(baseURL string, timeout time.Duration, transport http.RoundTripper) *PythonClient {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := &PythonClient{baseURL: baseURL}
+	c.httpClient.Store(&http.Client{
+		Timeout:   timeout,
+		Transport: traceTransport{next: transport},
+	})
+	return c
+}
+
+// SetTimeout changes the timeout of engine requests; requests in flight
+// keep the timeout they were sent with
+func (c *PythonClient) SetTimeout(timeout time.Duration) {
+	client := *c.httpClient.Load()
+	client.Timeout = timeout
+	c.httpClient.Store(&client)
+}
+
+// Timeout returns the timeout of engine requests
+func (c *PythonClient) Timeout() time.Duration {
+	return c.httpClient.Load().Timeout
+}
+
+func (c *PythonClient) Query(req *PythonQueryRequest) (*LegalQueryResponse, error) {
+	return c.QueryContext(context.Background(), req)
+}
+
+// QueryContext is Query with a context that cancels the engine request.
+// Transient failures are retried under the client's RetryPolicy.
+func (c *PythonClient) QueryContext(ctx context.Context, req *PythonQueryRequest) (*LegalQueryResponse, error) {
+	// Marshal request
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if c.Breaker == nil {
+		return c.retry(ctx, req, jsonData)
+	}
+	if err := c.Breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.retry(ctx, req, jsonData)
+	c.Breaker.record(ctx, err)
+	return resp, err
+}
+
+// retry sends a query until it succeeds or fails for good
+func (c *PythonClient) retry(ctx context.Context, req *PythonQueryRequest, jsonData []byte) (*LegalQueryResponse, error) {
+	policy := c.Retry
+	var deadline time.Time
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+		deadline, _ = ctx.Deadline()
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := c.query(ctx, req, jsonData)
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return resp, err
+		}
+		delay := policy.backoff(attempt)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Engine request failed, retrying", "engine", c.baseURL, "error", err,
+			"delay", delay.Round(time.Millisecond).String(), "attempt", attempt+1, "max_attempts", policy.MaxAttempts)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// query sends one attempt of a query
+func (c *PythonClient) query(ctx context.Context, req *PythonQueryRequest, jsonData []byte) (*LegalQueryResponse, error) {
+	// Create HTTP request
+	path := "/api/query"
+	if req.OnEvent != nil {
+		path = "/api/query/stream"
+	}
+	url := c.baseURL + path
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if !req.StageBudgets.IsZero() {
+		httpReq.Header.Set(StageBudgetsHeader, req.StageBudgets.Header())
+	}
+
+	// Send request
+	slog.DebugContext(ctx, "Sending request to Python AI Engine", "url", url)
+	resp, err := c.httpClient.Load().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	c.checkStageBudgets(ctx, req, resp)
+
+	if req.OnEvent != nil && resp.StatusCode == http.StatusOK {
+		return readStream(resp.Body, req.OnEvent)
+	}
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		return nil, &EngineStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	// Unmarshal response
+	var queryResp LegalQueryResponse
+	if err := decodeResponse(body, &queryResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &queryResp, nil
+}
+
+// submitRequest is a query the engine answers in the background, posting
+// the result to CallbackURL
+type submitRequest struct {
+	*PythonQueryRequest
+	JobID       string `json:"job_id"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// SubmitQuery queues req on the engine, which answers 202 at once and later
+// posts a QueryCallback for jobID to callbackURL. Streaming is not
+// supported: OnEvent is ignored. The submission counts for the Breaker, but
+// is not retried.
+func (c *PythonClient) SubmitQuery(ctx context.Context, req *PythonQueryRequest, jobID, callbackURL string) error {
+	jsonData, err := json.Marshal(submitRequest{PythonQueryRequest: req, JobID: jobID, CallbackURL: callbackURL})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if c.Breaker != nil {
+		if err := c.Breaker.allow(); err != nil {
+			return err
+		}
+	}
+	err = c.submit(ctx, req, jsonData)
+	if c.Breaker != nil {
+		c.Breaker.record(ctx, err)
+	}
+	return err
+}
+
+func (c *PythonClient) submit(ctx context.Context, req *PythonQueryRequest, jsonData []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/query/submit", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if !req.StageBudgets.IsZero() {
+		httpReq.Header.Set(StageBudgetsHeader, req.StageBudgets.Header())
+	}
+
+	resp, err := c.httpClient.Load().Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return &EngineStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// checkStageBudgets logs when the engine did not apply the requested stage
+// budgets, i.e. it predates them or capped them to its own limits
+func (c *PythonClient) checkStageBudgets(ctx context.Context, req *PythonQueryRequest, resp *http.Response) {
+	if req.StageBudgets.IsZero() || resp.StatusCode != http.StatusOK {
+		return
+	}
+	applied := resp.Header.Get(StageBudgetsHeader)
+	if applied == "" {
+		if !c.budgetsIgnored.Swap(true) {
+			slog.Warn("Engine does not support stage budgets; only the request timeout applies", "engine", c.baseURL)
+		}
+		return
+	}
+	if budgets, err := ParseStageBudgets(applied); err == nil && budgets != req.StageBudgets {
+		slog.InfoContext(ctx, "Engine applied other stage budgets", "engine", c.baseURL, "applied", budgets.Header(), "requested", req.StageBudgets.Header())
+	}
+}
+
+// streamLine is a line of the engine's NDJSON stream: an intermediate event,
+// the answer or an error
+type streamLine struct {
+	StreamEvent
+	Response *LegalQueryResponse `json:"response"`
+	Detail   string              `json:"detail"`
+}
+
+// readStream passes the intermediate events of a streamed query to onEvent
+// and returns the answer that ends the stream
+func readStream(body io.Reader, onEvent func(StreamEvent)) (*LegalQueryResponse, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line streamLine
+		if err := decodeResponse(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+		switch line.Type {
+		case "answer":
+			if line.Response == nil {
+				return nil, fmt.Errorf("stream answer has no response")
+			}
+			return line.Response, nil
+		case "error":
+			return nil, &EngineStatusError{StatusCode: http.StatusInternalServerError, Body: line.Detail}
+		default:
+			onEvent(line.StreamEvent)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return nil, fmt.Errorf("stream ended without an answer")
+}
+
+func (c *PythonClient) HealthCheck() error {
+	url := fmt.Sprintf("%s/health", c.baseURL)
+	resp, err := c.httpClient.Load().Get(url)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Article fetches the full text of an article of the corpus
+func (c *PythonClient) Article(ctx context.Context, id string) (*Article, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/articles/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Load().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrArticleNotFound
+	default:
+		return nil, &EngineStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var article Article
+	if err := json.Unmarshal(body, &article); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal article: %w", err)
+	}
+	return &article, nil
+}
+
+// Search retrieves passages for a question without answering it
+func (c *PythonClient) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	body, err := c.send(ctx, http.MethodPost, c.baseURL+"/api/search", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	var result SearchResponse
+	if err := decodeResponse(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal search results: %w", err)
+	}
+	return &result, nil
+}
+
+// Summarize condenses the turns of a conversation, with its earlier summary,
+// into a new summary
+func (c *PythonClient) Summarize(ctx context.Context, req *SummarizeRequest) (*SummarizeResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	body, err := c.send(ctx, http.MethodPost, c.baseURL+"/api/summarize", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	var result SummarizeResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal summary: %w", err)
+	}
+	return &result, nil
+}
+
+// EngineStatusError is returned by PythonClient when the engine answers
+// with a non-200 status code
+type EngineStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *EngineStatusError) Error() string {
+	return fmt.Sprintf("python service returned status %d: %s", e.StatusCode, e.Body)
+}
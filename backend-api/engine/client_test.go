@@ -0,0 +1,399 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestPythonClientQuery(t *testing.T) {
+	var got PythonQueryRequest
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/query" {
+			t.Errorf("request = %s %s, want POST /api/query", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(LegalQueryResponse{Answer: "Điều 25", Iterations: 2})
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	resp, err := client.Query(&PythonQueryRequest{Question: "Thời gian thử việc?", MaxIterations: 3, TopK: 5})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if resp.Answer != "Điều 25" || resp.Iterations != 2 {
+		t.Errorf("response = %+v, want the engine answer", resp)
+	}
+	if got.Question != "Thời gian thử việc?" || got.MaxIterations != 3 || got.TopK != 5 {
+		t.Errorf("engine received %+v, want the request fields", got)
+	}
+}
+
+func TestPythonClientSchemaMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, field string
+	}{
+		{"search result without text", `{"answer": "a", "search_results": [{"score": 0.8, "metadata": {}}], "iterations": 1}`, "text"},
+		{"metadata of another type", `{"answer": "a", "search_results": [{"text": "Điều 25", "metadata": {"article_id": 25}}], "iterations": 1}`, "metadata.article_id"},
+		{"year that is not a year", `{"answer": "a", "search_results": [{"text": "Điều 25", "metadata": {"year": "mới"}}], "iterations": 1}`, "metadata.year"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tc.body)
+			}))
+			defer engine.Close()
+
+			_, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{Question: "q"})
+			var schemaErr *SchemaError
+			if !errors.Is(err, ErrSchemaMismatch) || !errors.As(err, &schemaErr) || schemaErr.Field != tc.field {
+				t.Errorf("error = %v, want %v naming %s", err, ErrSchemaMismatch, tc.field)
+			}
+		})
+	}
+}
+
+func TestPythonClientDropsInvalidWebResults(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"answer": "a", "web_results": [{"title": "Thử việc", "content": "..."}, {"title": "Điều 25", "url": "https://vbpl.vn/45-2019-qh14", "score": 0.7}, {"url": "https://example.vn", "score": "cao"}], "iterations": 1}`)
+	}))
+	defer engine.Close()
+
+	resp, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{Question: "q"})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if resp.Answer != "a" || len(resp.WebResults) != 1 || resp.WebResults[0].URL != "https://vbpl.vn/45-2019-qh14" {
+		t.Errorf("response = %+v, want the answer with the one valid web result", resp)
+	}
+}
+
+func TestDocumentHitJSON(t *testing.T) {
+	data := `{"text": "Điều 25. Thời gian thử việc", "score": 0.82, "source_type": "internal", "metadata": {"article_id": "Dieu_25", "article_title": "Thời gian thử việc", "clause_id": "Khoan_1", "document_id": "BoLuatLaoDong2019", "year": "2019", "url": "https://vbpl.vn/45-2019-qh14", "effective_date": "2021-01-01", "chunk_hash": "f3a9"}}`
+	var hit DocumentHit
+	if err := json.Unmarshal([]byte(data), &hit); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := DocumentHit{
+		Title:         "Thời gian thử việc",
+		ArticleNumber: "Dieu_25",
+		Excerpt:       "Điều 25. Thời gian thử việc",
+		Score:         0.82,
+		SourceURL:     "https://vbpl.vn/45-2019-qh14",
+		EffectiveDate: "2021-01-01",
+		ClauseID:      "Khoan_1",
+		DocumentID:    "BoLuatLaoDong2019",
+		Year:          2019,
+		SourceType:    "internal",
+	}
+	if hit != want {
+		t.Errorf("hit = %+v, want %+v", hit, want)
+	}
+
+	// Marshalling keeps the engine's layout, so stored answers read back
+	encoded, _ := json.Marshal(hit)
+	var again DocumentHit
+	if err := json.Unmarshal(encoded, &again); err != nil || again != want {
+		t.Errorf("round trip = %+v (%v), want %+v", again, err, want)
+	}
+	if !strings.Contains(string(encoded), `"metadata":{"article_id":"Dieu_25"`) {
+		t.Errorf("encoded = %s, want the metadata object of the engine", encoded)
+	}
+}
+
+func TestPythonClientStatusError(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer engine.Close()
+
+	_, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{Question: "q"})
+	var statusErr *EngineStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error = %v, want *EngineStatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", statusErr.StatusCode)
+	}
+}
+
+func TestPythonClientRetry(t *testing.T) {
+	var attempts, status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		json.NewEncoder(w).Encode(LegalQueryResponse{Answer: "Điều 25"})
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	client.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	if resp, err := client.Query(&PythonQueryRequest{Question: "q"}); err != nil || resp.Answer != "Điều 25" || attempts.Load() != 3 {
+		t.Errorf("Query = %v, %v after %d attempts, want the answer of the third", resp, err, attempts.Load())
+	}
+
+	// Errors other than 502, 503 and 504 are not transient
+	attempts.Store(0)
+	status.Store(http.StatusInternalServerError)
+	if _, err := client.Query(&PythonQueryRequest{Question: "q"}); err == nil || attempts.Load() != 1 {
+		t.Errorf("Query after a 500 = %v after %d attempts, want a single attempt", err, attempts.Load())
+	}
+
+	// No retry starts after the deadline
+	attempts.Store(-100)
+	status.Store(http.StatusBadGateway)
+	client.Retry = RetryPolicy{MaxAttempts: 10, BaseDelay: 40 * time.Millisecond, MaxDelay: 40 * time.Millisecond, Deadline: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := client.Query(&PythonQueryRequest{Question: "q"}); err == nil || attempts.Load()+100 >= 10 || time.Since(start) > time.Second {
+		t.Errorf("Query = %v after %d attempts in %v, want the deadline to stop the retries", err, attempts.Load()+100, time.Since(start))
+	}
+
+	engine.Close()
+	client.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	if _, err := client.Query(&PythonQueryRequest{Question: "q"}); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Query on a closed engine = %v, want the refused connection", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+	down := &EngineStatusError{StatusCode: http.StatusBadGateway}
+
+	// An engine rejecting the request is up, and a success resets the count
+	b.record(ctx, down)
+	b.record(ctx, &EngineStatusError{StatusCode: http.StatusBadRequest})
+	b.record(ctx, down)
+	if b.Stats().State != BreakerClosed {
+		t.Fatalf("state = %s after failures in between successes, want closed", b.Stats().State)
+	}
+	b.record(ctx, down)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow = %v after 2 failures in a row, want %v", err, ErrCircuitOpen)
+	}
+
+	// After the cooldown a single probe goes through; its failure reopens
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second query during the probe = %v, want %v", err, ErrCircuitOpen)
+	}
+	b.record(ctx, down)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow after a failed probe = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	now = now.Add(time.Minute)
+	b.allow()
+	b.record(ctx, nil)
+	if stats := b.Stats(); stats.State != BreakerClosed || stats.Trips != 1 || stats.ConsecutiveFailures != 0 {
+		t.Errorf("stats = %+v after a successful probe, want closed after 1 trip", stats)
+	}
+}
+
+func TestPythonClientHealthCheck(t *testing.T) {
+	healthy := true
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("path = %s, want /health", r.URL.Path)
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	if err := client.HealthCheck(); err != nil {
+		t.Errorf("HealthCheck on a healthy engine: %v", err)
+	}
+	healthy = false
+	if err := client.HealthCheck(); err == nil {
+		t.Error("HealthCheck on an unhealthy engine succeeded, want an error")
+	}
+}
+
+func TestPythonClientArticle(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/articles/Dieu_25" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(Article{ArticleID: "Dieu_25", DocumentID: "BoLuatLaoDong2019", Article: "Điều 25", Text: "Thời gian thử việc"})
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	article, err := client.Article(context.Background(), "Dieu_25")
+	if err != nil {
+		t.Fatalf("Article: %v", err)
+	}
+	if article.DocumentID != "BoLuatLaoDong2019" || article.Text != "Thời gian thử việc" {
+		t.Errorf("article = %+v, want the engine article", article)
+	}
+	if _, err := client.Article(context.Background(), "Dieu_999"); !errors.Is(err, ErrArticleNotFound) {
+		t.Errorf("unknown article error = %v, want ErrArticleNotFound", err)
+	}
+}
+
+func TestPythonClientSearch(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SearchRequest
+		if r.URL.Path != "/api/search" || json.NewDecoder(r.Body).Decode(&req) != nil || req.TopK != 2 {
+			http.Error(w, `{"detail": "bad request"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte(`{"search_results": [{"text": "Thời gian thử việc", "score": 0.82, "metadata": {"article_id": "Dieu_25"}}], "embedding_model": "keepitreal/vietnamese-sbert"}`))
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	resp, err := client.Search(context.Background(), &SearchRequest{Question: "Thời gian thử việc?", TopK: 2})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if resp.EmbeddingModel != "keepitreal/vietnamese-sbert" || len(resp.SearchResults) != 1 || resp.SearchResults[0].ArticleNumber != "Dieu_25" {
+		t.Errorf("search = %+v, want the engine results", resp)
+	}
+	var statusErr *EngineStatusError
+	if _, err := client.Search(context.Background(), &SearchRequest{Question: "Thời gian thử việc?", TopK: 50}); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("rejected search error = %v, want a 422 EngineStatusError", err)
+	}
+}
+
+func TestPythonClientQueryStream(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/query/stream" {
+			t.Errorf("path = %s, want /api/query/stream", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, `{"type": "retrieval", "search_results": [{"text": "Điều 25"}], "web_results": []}`+"\n")
+		io.WriteString(w, `{"type": "token", "text": "Không quá "}`+"\n")
+		io.WriteString(w, `{"type": "token", "text": "60 ngày."}`+"\n")
+		io.WriteString(w, `{"type": "answer", "response": {"answer": "Không quá 60 ngày.", "iterations": 1, "query_used": "thử việc"}}`+"\n")
+	}))
+	defer engine.Close()
+
+	var events []StreamEvent
+	resp, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{
+		Question: "Thời gian thử việc?",
+		OnEvent:  func(e StreamEvent) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(events) != 3 || events[0].Type != EventRetrieval || len(events[0].SearchResults) != 1 {
+		t.Fatalf("events = %+v, want a retrieval event and two tokens", events)
+	}
+	if events[1].Type != EventToken || events[1].Text+events[2].Text != "Không quá 60 ngày." {
+		t.Errorf("tokens = %+v, want the answer", events[1:])
+	}
+	if resp.Answer != "Không quá 60 ngày." {
+		t.Errorf("answer = %q, want the streamed answer", resp.Answer)
+	}
+}
+
+func TestPythonClientQueryStreamError(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"type": "retrieval", "search_results": []}`+"\n"+`{"type": "error", "detail": "LLM unavailable"}`+"\n")
+	}))
+	defer engine.Close()
+
+	_, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{Question: "q", OnEvent: func(StreamEvent) {}})
+	var statusErr *EngineStatusError
+	if !errors.As(err, &statusErr) || statusErr.Body != "LLM unavailable" {
+		t.Errorf("error = %v, want the engine's error detail", err)
+	}
+}
+
+func TestPythonClientStageBudgets(t *testing.T) {
+	budgets := StageBudgets{Retrieval: 20 * time.Second, Answer: time.Minute}
+	var header string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(StageBudgetsHeader)
+		w.Header().Set(StageBudgetsHeader, header)
+		json.NewEncoder(w).Encode(LegalQueryResponse{Answer: "Điều 25", StageTimeouts: []string{StageAnswer}})
+	}))
+	defer engine.Close()
+
+	resp, err := NewPythonClient(engine.URL, time.Second, nil).Query(&PythonQueryRequest{Question: "Thời gian thử việc?", StageBudgets: budgets})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if header != "retrieval=20000, answer=60000" {
+		t.Errorf("%s = %q, want the retrieval and answer budgets", StageBudgetsHeader, header)
+	}
+	if parsed, err := ParseStageBudgets(header); err != nil || parsed != budgets {
+		t.Errorf("ParseStageBudgets(%q) = %+v, %v, want %+v", header, parsed, err, budgets)
+	}
+	if len(resp.StageTimeouts) != 1 || resp.StageTimeouts[0] != StageAnswer {
+		t.Errorf("stage timeouts = %v, want [answer]", resp.StageTimeouts)
+	}
+
+	if _, err := ParseStageBudgets("retrieval=soon"); err == nil {
+		t.Error("ParseStageBudgets accepted a budget that is not a number")
+	}
+}
+
+func TestPythonClientTrace(t *testing.T) {
+	var gotID string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(RequestIDHeader)
+		http.Error(w, "model server unavailable", http.StatusServiceUnavailable)
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	trace := &Trace{RequestID: "req-1"}
+	if _, err := client.QueryContext(WithTrace(context.Background(), trace), &PythonQueryRequest{Question: "Thời gian thử việc?"}); err == nil {
+		t.Fatal("Query succeeded, want the engine's 503")
+	}
+	if gotID != "req-1" || trace.UpstreamStatus() != http.StatusServiceUnavailable {
+		t.Errorf("engine got request ID %q, trace recorded status %d; want req-1 and 503", gotID, trace.UpstreamStatus())
+	}
+}
+
+func TestPythonClientSummarize(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SummarizeRequest
+		if r.URL.Path != "/api/summarize" || json.NewDecoder(r.Body).Decode(&req) != nil || len(req.Turns) != 1 || req.Summary != "Tóm tắt cũ" {
+			http.Error(w, `{"detail": "bad request"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		json.NewEncoder(w).Encode(SummarizeResponse{Summary: "Tóm tắt mới"})
+	}))
+	defer engine.Close()
+
+	client := NewPythonClient(engine.URL, time.Second, nil)
+	resp, err := client.Summarize(context.Background(), &SummarizeRequest{
+		Turns:   []ChatTurn{{Question: "Thời gian thử việc?", Answer: "Không quá 60 ngày."}},
+		Summary: "Tóm tắt cũ",
+	})
+	if err != nil || resp.Summary != "Tóm tắt mới" {
+		t.Errorf("Summarize = %+v, %v, want the new summary", resp, err)
+	}
+}
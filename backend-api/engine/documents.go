@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// IndexDocument indexes a private document in a namespace of the engine
+func (c *PythonClient) IndexDocument(ctx context.Context, namespace string, doc *IndexedDocument) (*IndexResult, error) {
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
+	}
+	body, err := c.send(ctx, http.MethodPut, c.documentURL(namespace, doc.ID), jsonData)
+	if err != nil {
+		return nil, err
+	}
+	var result IndexResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index result: %w", err)
+	}
+	return &result, nil
+}
+
+// DeleteDocument removes a private document from a namespace of the engine.
+// Deleting a document the engine does not have succeeds.
+func (c *PythonClient) DeleteDocument(ctx context.Context, namespace, id string) error {
+	_, err := c.send(ctx, http.MethodDelete, c.documentURL(namespace, id), nil)
+	return err
+}
+
+func (c *PythonClient) documentURL(namespace, id string) string {
+	return fmt.Sprintf("%s/api/namespaces/%s/documents/%s", c.baseURL, url.PathEscape(namespace), url.PathEscape(id))
+}
+
+// send makes a request to the engine and returns the body of a 2xx answer
+func (c *PythonClient) send(ctx context.Context, method, url string, jsonData []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewReader(jsonData)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if jsonData != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Load().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &EngineStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
@@ -0,0 +1,541 @@
+// Package engine defines the contract between the backend and the AI
+// engine: the engine request and response and the QueryEngine interface,
+// with PythonClient as the client of the Python engine. Implement
+// QueryEngine to plug another engine into the server.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryEngine answers legal queries. PythonClient is the production
+// implementation.
+type QueryEngine interface {
+	Query(req *PythonQueryRequest) (*LegalQueryResponse, error)
+}
+
+// ContextQueryEngine is an engine whose requests can be cancelled
+type ContextQueryEngine interface {
+	QueryEngine
+	QueryContext(ctx context.Context, req *PythonQueryRequest) (*LegalQueryResponse, error)
+}
+
+// QueryWithContext queries an engine, cancelling the engine request when ctx
+// is done if the engine supports it
+func QueryWithContext(ctx context.Context, e QueryEngine, req *PythonQueryRequest) (*LegalQueryResponse, error) {
+	if ce, ok := e.(ContextQueryEngine); ok {
+		return ce.QueryContext(ctx, req)
+	}
+	return e.Query(req)
+}
+
+// ArticleSource is an engine that serves the full text of the articles of
+// its corpus, for exports of the sources an answer cited
+type ArticleSource interface {
+	Article(ctx context.Context, id string) (*Article, error)
+}
+
+// Retriever is an engine that searches without answering, returning the
+// passages its embedding model retrieves for a question
+type Retriever interface {
+	Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error)
+}
+
+// SearchRequest is a question to retrieve passages for, from the shared
+// corpus or the private documents of Namespace
+type SearchRequest struct {
+	Question   string `json:"question"`
+	TopK       int    `json:"top_k"`
+	Namespace  string `json:"namespace,omitempty"`
+	Collection string `json:"collection,omitempty"`
+
+	// ExcludeCollections are left out of the search when Collection is
+	// empty
+	ExcludeCollections []string `json:"exclude_collections,omitempty"`
+}
+
+// SearchResponse is what a Retriever found, best first
+type SearchResponse struct {
+	SearchResults []DocumentHit `json:"search_results"`
+	// EmbeddingModel is the model the engine embedded the question with
+	EmbeddingModel string `json:"embedding_model"`
+}
+
+// Summarizer is an engine that condenses the earlier turns of a long
+// conversation, so that they still fit the context of the next question
+type Summarizer interface {
+	Summarize(ctx context.Context, req *SummarizeRequest) (*SummarizeResponse, error)
+}
+
+// SummarizeRequest asks for a summary of Turns that also covers Summary,
+// the summary of the turns before them
+type SummarizeRequest struct {
+	Turns    []ChatTurn `json:"turns"`
+	Summary  string     `json:"summary,omitempty"`
+	MaxWords int        `json:"max_words,omitempty"`
+}
+
+// SummarizeResponse is the new summary, which replaces the earlier one
+type SummarizeResponse struct {
+	Summary string `json:"summary"`
+}
+
+// DocumentIndex is an engine that indexes private documents, such as the
+// contracts of a tenant. Documents live in a namespace of their own and are
+// only searched by queries naming it, never mixed into the shared corpus.
+type DocumentIndex interface {
+	IndexDocument(ctx context.Context, namespace string, doc *IndexedDocument) (*IndexResult, error)
+	DeleteDocument(ctx context.Context, namespace, id string) error
+}
+
+// IndexedDocument is a private document to index; indexing a document again
+// under the same ID replaces it
+type IndexedDocument struct {
+	ID         string `json:"-"`
+	Collection string `json:"collection"`
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+}
+
+// IndexResult describes an indexed document
+type IndexResult struct {
+	DocumentID string `json:"document_id"`
+	Chunks     int    `json:"chunks"`
+}
+
+// ErrArticleNotFound is returned by ArticleSource for an unknown article
+var ErrArticleNotFound = errors.New("article not found")
+
+// Article is the full text of an article of the corpus
+type Article struct {
+	// ArticleID identifies the article, e.g. "Dieu_25"
+	ArticleID string `json:"article_id"`
+
+	// DocumentID identifies the legal document the article belongs to
+	DocumentID   string `json:"document_id"`
+	Article      string `json:"article"`
+	Title        string `json:"title"`
+	Chapter      string `json:"chapter,omitempty"`
+	ChapterTitle string `json:"chapter_title,omitempty"`
+	Text         string `json:"text"`
+}
+
+// PythonQueryRequest represents the request to Python AI engine
+type PythonQueryRequest struct {
+	Question        string `json:"question"`
+	MaxIterations   int    `json:"max_iterations"`
+	TopK            int    `json:"top_k"`
+	EnableWebSearch bool   `json:"enable_web_search"`
+	Model           string `json:"model,omitempty"`
+	ResponseFormat  string `json:"response_format,omitempty"`
+
+	// Style is always resolved; StyleInstructions is its prompt variant
+	Style             AnswerStyle `json:"style"`
+	StyleInstructions string      `json:"style_instructions"`
+
+	Language   string `json:"language,omitempty"`
+	Collection string `json:"collection,omitempty"`
+
+	// Namespace searches the private documents indexed under it instead of
+	// the shared corpus, within Collection when set
+	Namespace string `json:"namespace,omitempty"`
+
+	// ExcludeCollections are left out of the search of the shared corpus
+	// when Collection is empty, the collections the caller may not search
+	ExcludeCollections []string `json:"exclude_collections,omitempty"`
+
+	IterationPolicy IterationPolicy `json:"iteration_policy"`
+
+	// Difficulty is the estimated difficulty when routing is enabled; the
+	// backend routes on it and never sends it to the engine
+	Difficulty string `json:"-"`
+
+	// QueryVariants are searched in parallel with the question in the first
+	// iteration; the engine keeps whichever path scores better
+	QueryVariants []string `json:"query_variants,omitempty"`
+
+	// SourcePriors weigh the scores of search results by how useful users
+	// found their source, keyed by source title; the engine reranks the
+	// results by the weighted scores
+	SourcePriors map[string]float64 `json:"source_priors,omitempty"`
+
+	ContextDocuments []ContextDocument `json:"context_documents,omitempty"`
+
+	// History holds the earlier turns of a chat session, oldest first, so
+	// the engine can resolve follow-up questions
+	History []ChatTurn `json:"history,omitempty"`
+	// ConversationSummary stands for the turns of a long conversation that
+	// are older than History
+	ConversationSummary string `json:"conversation_summary,omitempty"`
+
+	// LatencyBudget is used by the backend only and never sent to the engine
+	LatencyBudget time.Duration `json:"-"`
+
+	// CitationStyle is applied to the answer by the backend; backend only
+	CitationStyle string `json:"-"`
+
+	// StageBudgets are sent in the X-Stage-Budgets header rather than the
+	// body, so engines that do not know them still accept the request
+	StageBudgets StageBudgets `json:"-"`
+
+	// Deadlines let the engine downgrade a slow query rather than fail it
+	Deadlines *Deadlines `json:"deadlines,omitempty"`
+
+	// Premium marks a premium caller's query, which may use the engine
+	// slots reserved for premium tenants; backend only
+	Premium bool `json:"-"`
+
+	// OnEvent, when set, streams the query: PythonClient calls it with each
+	// intermediate event before the answer. Engines that cannot stream
+	// ignore it.
+	OnEvent func(StreamEvent) `json:"-"`
+}
+
+// ChatTurn is an answered question of a chat session
+type ChatTurn struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// Stream event types
+const (
+	// EventRetrieval carries the search results once retrieval completes,
+	// before the answer is generated
+	EventRetrieval = "retrieval"
+
+	// EventToken carries the next piece of the answer as it is generated.
+	// The answer that ends the stream is authoritative: post-processing may
+	// change it.
+	EventToken = "token"
+)
+
+// StreamEvent is an intermediate event of a streamed query
+type StreamEvent struct {
+	Type          string        `json:"type"`
+	SearchResults []DocumentHit `json:"search_results,omitempty"`
+	WebResults    WebHits       `json:"web_results,omitempty"`
+	Text          string        `json:"text,omitempty"`
+}
+
+// LegalQueryResponse represents the response to client
+type LegalQueryResponse struct {
+	Answer        string           `json:"answer"`
+	SearchResults []DocumentHit    `json:"search_results"`
+	WebResults    WebHits          `json:"web_results"`
+	Iterations    int              `json:"iterations"`
+	QueryUsed     string           `json:"query_used"`
+	Sandbox       bool             `json:"sandbox,omitempty"`
+	Rule          string           `json:"rule,omitempty"`
+	Cached        bool             `json:"cached,omitempty"`
+	CacheHit      string           `json:"cache_hit,omitempty"`
+	Highlights    []Highlight      `json:"highlights,omitempty"`
+	Citations     []Citation       `json:"citations,omitempty"`
+	Disclaimer    *DisclaimerStamp `json:"disclaimer,omitempty"`
+	Warnings      []Warning        `json:"warnings,omitempty"`
+	HistoryID     string           `json:"history_id,omitempty"`
+
+	NeedsClarification  bool     `json:"needs_clarification,omitempty"`
+	ClarifyingQuestions []string `json:"clarifying_questions,omitempty"`
+	PendingQueryID      string   `json:"pending_query_id,omitempty"`
+
+	ContextURLErrors []ContextURLError `json:"context_url_errors,omitempty"`
+
+	// Per-iteration retrieval signals and why the engine stopped iterating
+	IterationSignals []IterationSignal `json:"iteration_signals,omitempty"`
+	StoppedReason    string            `json:"stopped_reason,omitempty"`
+
+	Speculation *Speculation `json:"speculation,omitempty"`
+
+	// StageTimeouts lists the pipeline stages that ran out of their budget;
+	// the engine answered with what it had by then
+	StageTimeouts []string `json:"stage_timeouts,omitempty"`
+
+	// Downgrade is set when the query passed its soft deadline and the
+	// engine cut it short
+	Downgrade *Downgrade `json:"downgrade,omitempty"`
+
+	// Hedged is set when a duplicate request to another engine answered first
+	Hedged bool `json:"hedged,omitempty"`
+
+	// Region is the engine region that served the answer
+	Region string `json:"region,omitempty"`
+
+	// Extensions are fields added by post-processors
+	Extensions map[string]any `json:"extensions,omitempty"`
+
+	// Meta lists the features the backend applied to the answer
+	Meta *ResponseMeta `json:"meta,omitempty"`
+
+	// Explanations break down the scores of the search results, in the
+	// order of SearchResults, when the query asked for them
+	Explanations []ResultExplanation `json:"explanations,omitempty"`
+}
+
+// ResultExplanation tells why a search result was selected. VectorScore is
+// the engine's similarity score, KeywordScore how well the result matches
+// the question's terms (0-1) and RecencyBoost what the age of its document
+// adds; RerankScore combines them and Rank orders the results by it.
+type ResultExplanation struct {
+	ResultIndex  int      `json:"result_index"`
+	Rank         int      `json:"rank"`
+	VectorScore  float64  `json:"vector_score"`
+	KeywordScore float64  `json:"keyword_score"`
+	RecencyBoost float64  `json:"recency_boost"`
+	RerankScore  float64  `json:"rerank_score"`
+	MatchedTerms []string `json:"matched_terms"`
+	DocumentYear int      `json:"document_year,omitempty"`
+}
+
+// ResponseMeta explains how an answer was produced, so that clients and
+// support can tell why two identical questions got different answers
+type ResponseMeta struct {
+	// Features are the names of the optional features that applied, in a
+	// fixed order
+	Features        []string    `json:"features"`
+	Model           string      `json:"model,omitempty"`
+	Region          string      `json:"region,omitempty"`
+	IterationPolicy string      `json:"iteration_policy,omitempty"`
+	Difficulty      string      `json:"difficulty,omitempty"`
+	Style           AnswerStyle `json:"style"`
+	QueryVariants   []string    `json:"query_variants,omitempty"`
+	PostProcessors  []string    `json:"post_processors,omitempty"`
+}
+
+// ContextDocument is ad-hoc context forwarded to the engine for one query.
+// It is never indexed into the corpus.
+type ContextDocument struct {
+	Name   string `json:"name"`
+	Text   string `json:"text"`
+	Source string `json:"source"`
+}
+
+// Highlight links a sentence of the answer to the source excerpt that best
+// supports it. Offsets are in Unicode code points, end-exclusive.
+type Highlight struct {
+	AnswerStart int     `json:"answer_start"`
+	AnswerEnd   int     `json:"answer_end"`
+	Source      string  `json:"source"`
+	ResultIndex int     `json:"result_index"`
+	SourceStart int     `json:"source_start"`
+	SourceEnd   int     `json:"source_end"`
+	Score       float64 `json:"score"`
+}
+
+// DisclaimerStamp is the disclaimer an answer was given under
+type DisclaimerStamp struct {
+	Text string `json:"text"`
+
+	// Version is the approved version of the tenant's disclaimer, or 0 for
+	// the default of the deployment
+	Version    int    `json:"version"`
+	ApprovedBy string `json:"approved_by,omitempty"`
+}
+
+// Citation is a legal document, or an article of one, that an answer cited
+// or was based on, with the link to its official text
+type Citation struct {
+	Label string `json:"label"`
+
+	// Document is the official number of the document, e.g. "45/2019/QH14"
+	Document string `json:"document,omitempty"`
+	Article  string `json:"article,omitempty"`
+	URL      string `json:"url,omitempty"`
+
+	// LinkStatus is verified, unverified, dead or unresolved
+	LinkStatus string `json:"link_status"`
+
+	// InAnswer is set when the answer text cites it; ResultIndexes are the
+	// search results from it
+	InAnswer      bool  `json:"in_answer,omitempty"`
+	ResultIndexes []int `json:"result_indexes,omitempty"`
+}
+
+// Warning describes a parameter the server adjusted instead of rejecting
+type Warning struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ContextURLError reports why one of a query's context_urls was not used
+type ContextURLError struct {
+	URL     string `json:"url"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// IterationPolicy tells the engine when to stop iterating. With the adaptive
+// mode, an iteration has plateaued when the share of new results is below
+// MinNovelty and the best score grew by less than MinScoreGain; the engine
+// answers after Patience plateaued iterations in a row.
+type IterationPolicy struct {
+	Mode         string  `json:"mode"`
+	MinNovelty   float64 `json:"min_novelty"`
+	MinScoreGain float64 `json:"min_score_gain"`
+	Patience     int     `json:"patience"`
+}
+
+// Pipeline stages with a budget
+const (
+	// StageRetrieval is one corpus or web search
+	StageRetrieval = "retrieval"
+
+	// StageIteration is the agent deciding on, or refining, the next search
+	// in one iteration
+	StageIteration = "iteration"
+
+	// StageAnswer is generating the answer from the results
+	StageAnswer = "answer"
+)
+
+// StageBudgetsHeader carries the stage budgets to the engine, and the
+// budgets the engine applied back
+const StageBudgetsHeader = "X-Stage-Budgets"
+
+// StageBudgets bound each run of a pipeline stage, so that one slow stage
+// cannot use up the whole request timeout. Zero leaves a stage unbounded.
+type StageBudgets struct {
+	Retrieval time.Duration
+	Iteration time.Duration
+	Answer    time.Duration
+}
+
+// IsZero reports whether no stage has a budget
+func (b StageBudgets) IsZero() bool {
+	return b == StageBudgets{}
+}
+
+// Header encodes the budgets in milliseconds, e.g.
+// "retrieval=20000, iteration=30000, answer=60000"
+func (b StageBudgets) Header() string {
+	var parts []string
+	for _, stage := range []struct {
+		name   string
+		budget time.Duration
+	}{{StageRetrieval, b.Retrieval}, {StageIteration, b.Iteration}, {StageAnswer, b.Answer}} {
+		if stage.budget > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", stage.name, stage.budget.Milliseconds()))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseStageBudgets decodes a StageBudgetsHeader value. Unknown stages are
+// ignored.
+func ParseStageBudgets(header string) (StageBudgets, error) {
+	var b StageBudgets
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		ms, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if !ok || err != nil || ms < 0 {
+			return StageBudgets{}, fmt.Errorf("invalid stage budget %q", part)
+		}
+		budget := time.Duration(ms) * time.Millisecond
+		switch strings.TrimSpace(name) {
+		case StageRetrieval:
+			b.Retrieval = budget
+		case StageIteration:
+			b.Iteration = budget
+		case StageAnswer:
+			b.Answer = budget
+		}
+	}
+	return b, nil
+}
+
+// Deadlines bound a query from when the engine receives it. Past SoftMs the
+// engine stops iterating and generates the answer with FastModel, or its
+// own model when empty; every stage is cut off at HardMs.
+type Deadlines struct {
+	SoftMs    int    `json:"soft_ms"`
+	HardMs    int    `json:"hard_ms"`
+	FastModel string `json:"fast_model,omitempty"`
+}
+
+// Downgrade describes how a query that passed its soft deadline was cut
+// short
+type Downgrade struct {
+	// Reason is soft_deadline
+	Reason string `json:"reason"`
+
+	// AfterMs is when the query was downgraded, Iterations how many
+	// searches it had run by then
+	AfterMs    int `json:"after_ms"`
+	Iterations int `json:"iterations"`
+
+	// Model generated the answer instead of the requested one
+	Model string `json:"model,omitempty"`
+}
+
+// WithMode returns the policy with the mode a client asked for, if any
+func (p IterationPolicy) WithMode(mode string) IterationPolicy {
+	if mode != "" {
+		p.Mode = mode
+	}
+	return p
+}
+
+// IterationSignal is what the engine observed in one retrieval iteration
+type IterationSignal struct {
+	Iteration int     `json:"iteration"`
+	Source    string  `json:"source"`
+	Returned  int     `json:"returned"`
+	Added     int     `json:"added"`
+	Novelty   float64 `json:"novelty"`
+	BestScore float64 `json:"best_score"`
+	ScoreGain float64 `json:"score_gain"`
+}
+
+// SpeculationCandidate is one retrieval path of the first iteration
+type SpeculationCandidate struct {
+	Path       string  `json:"path"`
+	Query      string  `json:"query"`
+	BestScore  float64 `json:"best_score"`
+	MeanScore  float64 `json:"mean_score"`
+	DurationMs int64   `json:"duration_ms"`
+}
+
+// Speculation reports which path of the speculative first retrieval won
+type Speculation struct {
+	Winner     string                 `json:"winner"`
+	Candidates []SpeculationCandidate `json:"candidates"`
+}
+
+// AnswerStyle controls how an answer is written, never what it says
+type AnswerStyle struct {
+	Length   string `json:"length,omitempty"`
+	Tone     string `json:"tone,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+// QueryCallback is what the engine posts back for a submitted query: the
+// answer, or the reason it failed
+type QueryCallback struct {
+	JobID    string              `json:"job_id"`
+	Response *LegalQueryResponse `json:"response,omitempty"`
+
+	// Error is the engine's detail when the query failed
+	Error string `json:"error,omitempty"`
+}
+
+// Err returns the failure reported by the callback, or nil when it carries
+// an answer
+func (cb QueryCallback) Err() error {
+	switch {
+	case cb.Error != "":
+		return &EngineStatusError{StatusCode: http.StatusInternalServerError, Body: cb.Error}
+	case cb.Response == nil:
+		return fmt.Errorf("engine callback for job %s has no response", cb.JobID)
+	}
+	return nil
+}
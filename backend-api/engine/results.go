@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// ErrSchemaMismatch is returned when a response of the engine does not
+// match the result models of the backend, usually because the engine and
+// the backend are of different releases
+var ErrSchemaMismatch = errors.New("engine response does not match the expected schema")
+
+// SchemaError tells which field of a result does not match its model
+type SchemaError struct {
+	Result  string // "search result" or "web result"
+	Field   string
+	Problem string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Result, e.Field, e.Problem)
+}
+
+// DocumentHit is a passage of the corpus, or of a private collection,
+// retrieved for a question. On the wire it keeps the engine's layout: the
+// passage as text, its score and the rest under metadata.
+type DocumentHit struct {
+	// Title is the title of the article, e.g. "Thời gian thử việc"
+	Title string
+	// ArticleNumber is the ID of the article, e.g. Dieu_25; passages of
+	// private documents have none
+	ArticleNumber string
+	// Excerpt is the text of the passage
+	Excerpt string
+	// Score is the engine's similarity score of the passage
+	Score float64
+	// SourceURL is where the document is published, when the corpus knows
+	SourceURL string
+	// EffectiveDate is the date the document took effect, YYYY-MM-DD, when
+	// the corpus knows
+	EffectiveDate string
+
+	// Article is the label of an article-level passage, e.g. "Điều 25"
+	Article      string
+	ClauseID     string
+	Topic        string
+	ContentType  string
+	Chapter      string
+	ChapterTitle string
+	Section      string
+	SectionTitle string
+
+	DocumentID    string
+	DocumentTitle string
+	// Year is the year of the document, 0 when unknown
+	Year int
+
+	// Namespace, Collection and ChunkIndex locate a passage of a private
+	// document
+	Namespace  string
+	Collection string
+	ChunkIndex int
+
+	// Source and SourceType tell where the passage came from, e.g. rule for
+	// the citations of a canned answer
+	Source     string
+	SourceType string
+	// Prior is the usefulness weight the engine reranked the passage by
+	Prior float64
+}
+
+type documentHitJSON struct {
+	Text       *string              `json:"text"`
+	Score      float64              `json:"score"`
+	Metadata   documentMetadataJSON `json:"metadata"`
+	SourceType string               `json:"source_type,omitempty"`
+	Prior      float64              `json:"prior,omitempty"`
+}
+
+type documentMetadataJSON struct {
+	ArticleID     string          `json:"article_id,omitempty"`
+	ArticleTitle  string          `json:"article_title,omitempty"`
+	Article       string          `json:"article,omitempty"`
+	ClauseID      string          `json:"clause_id,omitempty"`
+	Topic         string          `json:"topic,omitempty"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Chapter       string          `json:"chapter,omitempty"`
+	ChapterTitle  string          `json:"chapter_title,omitempty"`
+	Section       string          `json:"section,omitempty"`
+	SectionTitle  string          `json:"section_title,omitempty"`
+	DocumentID    string          `json:"document_id,omitempty"`
+	DocumentTitle string          `json:"document_title,omitempty"`
+	Year          json.RawMessage `json:"year,omitempty"`
+	EffectiveDate string          `json:"effective_date,omitempty"`
+	URL           string          `json:"url,omitempty"`
+	Namespace     string          `json:"namespace,omitempty"`
+	Collection    string          `json:"collection,omitempty"`
+	ChunkIndex    int             `json:"chunk_index,omitempty"`
+	Source        string          `json:"source,omitempty"`
+}
+
+func (h DocumentHit) MarshalJSON() ([]byte, error) {
+	text := h.Excerpt
+	var year json.RawMessage
+	if h.Year != 0 {
+		year = strconv.AppendInt(nil, int64(h.Year), 10)
+	}
+	return json.Marshal(documentHitJSON{
+		Text:  &text,
+		Score: h.Score,
+		Metadata: documentMetadataJSON{
+			ArticleID:     h.ArticleNumber,
+			ArticleTitle:  h.Title,
+			Article:       h.Article,
+			ClauseID:      h.ClauseID,
+			Topic:         h.Topic,
+			ContentType:   h.ContentType,
+			Chapter:       h.Chapter,
+			ChapterTitle:  h.ChapterTitle,
+			Section:       h.Section,
+			SectionTitle:  h.SectionTitle,
+			DocumentID:    h.DocumentID,
+			DocumentTitle: h.DocumentTitle,
+			Year:          year,
+			EffectiveDate: h.EffectiveDate,
+			URL:           h.SourceURL,
+			Namespace:     h.Namespace,
+			Collection:    h.Collection,
+			ChunkIndex:    h.ChunkIndex,
+			Source:        h.Source,
+		},
+		SourceType: h.SourceType,
+		Prior:      h.Prior,
+	})
+}
+
+// UnmarshalJSON reads a search result of the engine, returning a
+// SchemaError when it has no text or a field of another type. Metadata the
+// model does not know is dropped.
+func (h *DocumentHit) UnmarshalJSON(data []byte) error {
+	var in documentHitJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return schemaError("search result", err)
+	}
+	if in.Text == nil {
+		return &SchemaError{Result: "search result", Field: "text", Problem: "is missing"}
+	}
+	m := in.Metadata
+	year, err := parseYear(m.Year)
+	if err != nil {
+		return &SchemaError{Result: "search result", Field: "metadata.year", Problem: "is not a year"}
+	}
+	*h = DocumentHit{
+		Title:         m.ArticleTitle,
+		ArticleNumber: m.ArticleID,
+		Excerpt:       *in.Text,
+		Score:         in.Score,
+		SourceURL:     m.URL,
+		EffectiveDate: m.EffectiveDate,
+		Article:       m.Article,
+		ClauseID:      m.ClauseID,
+		Topic:         m.Topic,
+		ContentType:   m.ContentType,
+		Chapter:       m.Chapter,
+		ChapterTitle:  m.ChapterTitle,
+		Section:       m.Section,
+		SectionTitle:  m.SectionTitle,
+		DocumentID:    m.DocumentID,
+		DocumentTitle: m.DocumentTitle,
+		Year:          year,
+		Namespace:     m.Namespace,
+		Collection:    m.Collection,
+		ChunkIndex:    m.ChunkIndex,
+		Source:        m.Source,
+		SourceType:    in.SourceType,
+		Prior:         in.Prior,
+	}
+	return nil
+}
+
+// parseYear reads the year of a document, which corpora give as a number
+// or as a string of digits
+func parseYear(data json.RawMessage) (int, error) {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return 0, nil
+	}
+	var year int
+	if err := json.Unmarshal(data, &year); err == nil {
+		return year, nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// WebHit is a page found by the engine's web search
+type WebHit struct {
+	Title      string  `json:"title"`
+	URL        string  `json:"url"`
+	Content    string  `json:"content"`
+	Score      float64 `json:"score"`
+	Type       string  `json:"type,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	SourceType string  `json:"source_type,omitempty"`
+	// Engine is the search engine that found the page
+	Engine string `json:"engine,omitempty"`
+}
+
+// UnmarshalJSON reads a web result of the engine, returning a SchemaError
+// when it has no URL or a field of another type
+func (h *WebHit) UnmarshalJSON(data []byte) error {
+	type webHit WebHit
+	var in webHit
+	if err := json.Unmarshal(data, &in); err != nil {
+		return schemaError("web result", err)
+	}
+	if in.URL == "" {
+		return &SchemaError{Result: "web result", Field: "url", Problem: "is missing"}
+	}
+	*h = WebHit(in)
+	return nil
+}
+
+// WebHits are the web results of a response. A page the backend cannot
+// read is dropped with a warning rather than failing the whole answer:
+// the answer stands without it.
+type WebHits []WebHit
+
+// UnmarshalJSON reads the web results of the engine, dropping those that
+// do not match WebHit
+func (hs *WebHits) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return schemaError("web result", err)
+	}
+	if raw == nil {
+		*hs = nil
+		return nil
+	}
+	hits := make(WebHits, 0, len(raw))
+	for i, item := range raw {
+		var hit WebHit
+		if err := hit.UnmarshalJSON(item); err != nil {
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) {
+				return err
+			}
+			slog.Warn("Dropping web result that does not match the schema", "index", i, "error", err)
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	*hs = hits
+	return nil
+}
+
+// schemaError turns a type error decoding a result into a SchemaError
+func schemaError(result string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := typeErr.Field
+		if field == "" {
+			field = "value"
+		}
+		return &SchemaError{Result: result, Field: field, Problem: fmt.Sprintf("is a JSON %s, want %s", typeErr.Value, typeErr.Type)}
+	}
+	return err
+}
+
+// decodeResponse decodes a response of the engine, wrapping
+// ErrSchemaMismatch around a response that is valid JSON but does not match
+// the models
+func decodeResponse(data []byte, v any) error {
+	err := json.Unmarshal(data, v)
+	var schemaErr *SchemaError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &schemaErr) || errors.As(err, &typeErr) {
+		return fmt.Errorf("%w: %w", ErrSchemaMismatch, err)
+	}
+	return err
+}
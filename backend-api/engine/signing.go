@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a request signed with the secret shared by the backend and
+// the engine
+const (
+	TimestampHeader = "X-Engine-Timestamp"
+	NonceHeader     = "X-Engine-Nonce"
+	SignatureHeader = "X-Engine-Signature"
+)
+
+var (
+	ErrUnsigned       = errors.New("request is not signed")
+	ErrBadSignature   = errors.New("request signature does not match")
+	ErrStaleSignature = errors.New("request timestamp is outside the allowed clock skew")
+	ErrReplayed       = errors.New("request nonce was already used")
+)
+
+// Signer signs requests to the engine and verifies requests from it. The
+// signature is an HMAC-SHA256 over the timestamp, a random nonce, the
+// method, the path with its query and the SHA-256 of the body, so a
+// request cannot be altered or sent again once MaxSkew has passed; within
+// MaxSkew, Verify rejects a nonce it has already seen.
+type Signer struct {
+	secret  []byte
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewSigner(secret string, maxSkew time.Duration) *Signer {
+	return &Signer{
+		secret:  []byte(secret),
+		maxSkew: maxSkew,
+		now:     time.Now,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Sign sets the signature headers of req, whose body is body
+func (s *Signer) Sign(req *http.Request, body []byte) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(SignatureHeader, s.signature(req, timestamp, hex.EncodeToString(nonce), body))
+}
+
+// Verify checks the signature headers of req, whose body is body, and
+// records its nonce
+func (s *Signer) Verify(req *http.Request, body []byte) error {
+	timestamp := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	signature := req.Header.Get(SignatureHeader)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrUnsigned
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(req, timestamp, nonce, body))) {
+		return ErrBadSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	now := s.now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-s.maxSkew)) || signedAt.After(now.Add(s.maxSkew)) {
+		return ErrStaleSignature
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for seen, expires := range s.seen {
+		if now.After(expires) {
+			delete(s.seen, seen)
+		}
+	}
+	if _, ok := s.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	// A nonce only needs remembering while its timestamp is accepted
+	s.seen[nonce] = signedAt.Add(s.maxSkew)
+	return nil
+}
+
+func (s *Signer) signature(req *http.Request, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, s.secret)
+	for _, part := range []string{timestamp, nonce, req.Method, req.URL.RequestURI(), hex.EncodeToString(bodyHash[:])} {
+		mac.Write([]byte(part))
+		mac.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
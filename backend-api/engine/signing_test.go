@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	newSigner := func(secret string, at time.Time) *Signer {
+		s := NewSigner(secret, time.Minute)
+		s.now = func() time.Time { return at }
+		return s
+	}
+	signer := newSigner("secret", now)
+	body := []byte(`{"question":"Thời gian thử việc?"}`)
+
+	req := httptest.NewRequest("POST", "/api/query?stream=1", nil)
+	signer.Sign(req, body)
+	if err := signer.Verify(req, body); err != nil {
+		t.Fatalf("Verify of a signed request: %v", err)
+	}
+	if err := signer.Verify(req, body); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Verify of a replayed request = %v, want ErrReplayed", err)
+	}
+
+	tests := []struct {
+		name     string
+		unsigned bool
+		verifier *Signer
+		body     string
+		want     error
+	}{
+		{name: "unsigned", unsigned: true, verifier: newSigner("secret", now), body: string(body), want: ErrUnsigned},
+		{name: "tampered body", verifier: newSigner("secret", now), body: `{"question":"x"}`, want: ErrBadSignature},
+		{name: "other secret", verifier: newSigner("other", now), body: string(body), want: ErrBadSignature},
+		{name: "stale", verifier: newSigner("secret", now.Add(2*time.Minute)), body: string(body), want: ErrStaleSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/query", nil)
+			if !tt.unsigned {
+				signer.Sign(req, body)
+			}
+			if err := tt.verifier.Verify(req, []byte(tt.body)); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestIDHeader carries the ID of the backend request an engine request
+// is made for
+const RequestIDHeader = "X-Request-ID"
+
+// Trace follows the engine requests made for one backend request: it sends
+// the request ID along and records the status of the engine's answers
+type Trace struct {
+	RequestID string
+
+	upstreamStatus atomic.Int32
+}
+
+type traceKey struct{}
+
+// WithTrace returns ctx carrying trace to the engine requests made with it
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// TraceFrom returns the trace carried by ctx, or nil
+func TraceFrom(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+// UpstreamStatus is the status code of the last engine answer, or 0 when
+// the engine was not reached
+func (t *Trace) UpstreamStatus() int {
+	return int(t.upstreamStatus.Load())
+}
+
+// traceTransport adds the request ID of the trace of an engine request and
+// records the status of the answer
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := TraceFrom(req.Context())
+	if trace == nil {
+		return t.next.RoundTrip(req)
+	}
+	if trace.RequestID != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, trace.RequestID)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		trace.upstreamStatus.Store(int32(resp.StatusCode))
+	}
+	return resp, err
+}
@@ -0,0 +1,111 @@
+// Package document renders simple text documents, such as research memos,
+// to downloadable formats. A Document is a title followed by a flat list of
+// blocks; renderers decide the layout.
+package document
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BlockKind is the role of a block in a document
+type BlockKind string
+
+const (
+	Heading    BlockKind = "heading"
+	Subheading BlockKind = "subheading"
+	Paragraph  BlockKind = "paragraph"
+	Bullet     BlockKind = "bullet"
+	Quote      BlockKind = "quote"
+
+	// Note is a footnote or endnote, set small after the text citing it
+	Note BlockKind = "note"
+)
+
+// Markdown footnotes: [^3] in the text and a "[^3]: ..." definition line
+var (
+	noteMarkerPattern     = regexp.MustCompile(`\[\^(\d{1,3})\]`)
+	noteDefinitionPattern = regexp.MustCompile(`^\[\^(\d{1,3})\]:\s*`)
+)
+
+var superscriptDigits = strings.NewReplacer("0", "⁰", "1", "¹", "2", "²", "3", "³", "4", "⁴", "5", "⁵", "6", "⁶", "7", "⁷", "8", "⁸", "9", "⁹")
+
+// Block is a run of text with a role. Newlines in paragraphs and quotes
+// start a new line.
+type Block struct {
+	Kind BlockKind
+	Text string
+}
+
+// Document is the content to render
+type Document struct {
+	Title    string
+	Subtitle string
+	Created  time.Time
+	Blocks   []Block
+}
+
+// Add appends a block, skipping empty text
+func (d *Document) Add(kind BlockKind, text string) {
+	if text = strings.TrimSpace(text); text != "" {
+		d.Blocks = append(d.Blocks, Block{Kind: kind, Text: text})
+	}
+}
+
+// byline returns the subtitle followed by the creation time
+func (d *Document) byline() string {
+	if d.Created.IsZero() {
+		return d.Subtitle
+	}
+	return strings.TrimPrefix(d.Subtitle+" · "+d.Created.Format("02/01/2006 15:04"), " · ")
+}
+
+// AddMarkdown appends answer text that may use light markdown: headings,
+// bullet lists and emphasis markers are turned into blocks and plain text.
+// Footnote markers become superscript numbers and their definitions notes.
+func (d *Document) AddMarkdown(text string) {
+	var paragraph []string
+	flush := func() {
+		d.Add(Paragraph, strings.Join(paragraph, " "))
+		paragraph = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(stripEmphasis(line))
+		if m := noteDefinitionPattern.FindStringSubmatch(line); m != nil {
+			flush()
+			d.Add(Note, superscriptDigits.Replace(m[1])+" "+superscriptNotes(line[len(m[0]):]))
+			continue
+		}
+		line = superscriptNotes(line)
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			flush()
+			d.Add(Subheading, strings.TrimLeft(line, "# "))
+		case strings.HasPrefix(line, "- "), strings.HasPrefix(line, "* "), strings.HasPrefix(line, "• "):
+			flush()
+			d.Add(Bullet, strings.TrimSpace(line[strings.Index(line, " "):]))
+		case strings.HasPrefix(line, ">"):
+			flush()
+			d.Add(Quote, strings.TrimLeft(line, "> "))
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flush()
+}
+
+// superscriptNotes turns footnote markers into superscript numbers
+func superscriptNotes(line string) string {
+	return noteMarkerPattern.ReplaceAllStringFunc(line, func(marker string) string {
+		return superscriptDigits.Replace(marker[2 : len(marker)-1])
+	})
+}
+
+func stripEmphasis(line string) string {
+	line = strings.ReplaceAll(line, "**", "")
+	line = strings.ReplaceAll(line, "__", "")
+	return strings.ReplaceAll(line, "`", "")
+}
@@ -0,0 +1,129 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddMarkdown(t *testing.T) {
+	var doc Document
+	doc.AddMarkdown("## Căn cứ pháp lý\n\nTheo **Điều 25** Bộ luật Lao động\n2019:\n- không quá 180 ngày\n* không quá 60 ngày\n> Thời gian thử việc do hai bên thỏa thuận")
+
+	want := []Block{
+		{Subheading, "Căn cứ pháp lý"},
+		{Paragraph, "Theo Điều 25 Bộ luật Lao động 2019:"},
+		{Bullet, "không quá 180 ngày"},
+		{Bullet, "không quá 60 ngày"},
+		{Quote, "Thời gian thử việc do hai bên thỏa thuận"},
+	}
+	if len(doc.Blocks) != len(want) {
+		t.Fatalf("blocks = %+v, want %+v", doc.Blocks, want)
+	}
+	for i := range want {
+		if doc.Blocks[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, doc.Blocks[i], want[i])
+		}
+	}
+}
+
+func testFont(t *testing.T) *Font {
+	t.Helper()
+	font, err := FindFont("")
+	if err != nil {
+		t.Skipf("no system font: %v", err)
+	}
+	return font
+}
+
+func TestFontGlyphs(t *testing.T) {
+	font := testFont(t)
+	for _, r := range "Aệ₫" {
+		if font.Glyph(r) == 0 {
+			t.Errorf("Glyph(%q) = 0, want a glyph", r)
+		}
+	}
+	if w := font.Width("Điều", 10); w <= 0 || w > 40 {
+		t.Errorf("Width = %v, want a plausible width", w)
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	font := testFont(t)
+	doc := &Document{Title: "Bản ghi nhớ pháp lý", Created: time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)}
+	for i := 0; i < 80; i++ {
+		doc.Add(Heading, "Thời gian thử việc")
+		doc.Add(Paragraph, strings.Repeat("Người sử dụng lao động và người lao động có thể thỏa thuận nội dung thử việc. ", 3))
+	}
+
+	pdf, err := RenderPDF(doc, font)
+	if err != nil {
+		t.Fatalf("RenderPDF: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.7")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("output is not framed as a PDF")
+	}
+	if !bytes.Contains(pdf, []byte("/FontFile2")) || !bytes.Contains(pdf, []byte("/ToUnicode")) {
+		t.Error("font is not embedded with a ToUnicode map")
+	}
+	if bytes.Count(pdf, []byte("/Type /Page ")) < 2 {
+		t.Error("long document did not break across pages")
+	}
+}
+
+func TestRenderDOCX(t *testing.T) {
+	doc := &Document{Title: "Bản ghi nhớ <nháp>"}
+	doc.Add(Heading, "Phân tích")
+	doc.Add(Bullet, "Không quá 60 ngày & một lần")
+
+	data, err := RenderDOCX(doc)
+	if err != nil {
+		t.Fatalf("RenderDOCX: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	var body []byte
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("open document.xml: %v", err)
+			}
+			body, _ = io.ReadAll(r)
+			r.Close()
+		}
+	}
+	if err := xml.Unmarshal(body, new(struct{})); err != nil {
+		t.Fatalf("document.xml is not well-formed: %v", err)
+	}
+	for _, want := range []string{"Bản ghi nhớ &lt;nháp&gt;", `<w:pStyle w:val="Heading1"/>`, "Không quá 60 ngày &amp; một lần"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("document.xml lacks %q", want)
+		}
+	}
+}
+
+func TestAddMarkdownNotes(t *testing.T) {
+	var doc Document
+	doc.AddMarkdown("Thử việc không quá 60 ngày[^1].\n\n[^1]: Điều 25, Bộ luật Lao động 2019.\n[^12]: Tlđd.")
+
+	want := []Block{
+		{Paragraph, "Thử việc không quá 60 ngày¹."},
+		{Note, "¹ Điều 25, Bộ luật Lao động 2019."},
+		{Note, "¹² Tlđd."},
+	}
+	if len(doc.Blocks) != len(want) {
+		t.Fatalf("blocks = %+v, want %+v", doc.Blocks, want)
+	}
+	for i := range want {
+		if doc.Blocks[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, doc.Blocks[i], want[i])
+		}
+	}
+}
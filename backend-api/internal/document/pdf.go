@@ -0,0 +1,291 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 page in points, with the text area inset by pdfMargin
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+)
+
+// pdfStyle is the layout of a block kind
+type pdfStyle struct {
+	size, leading, before, indent, gray float64
+	prefix                              string
+}
+
+var pdfStyles = map[BlockKind]pdfStyle{
+	Heading:    {size: 14, leading: 19, before: 16},
+	Subheading: {size: 12, leading: 16, before: 10},
+	Paragraph:  {size: 11, leading: 15.5, before: 6},
+	Bullet:     {size: 11, leading: 15.5, before: 3, indent: 14, prefix: "•"},
+	Quote:      {size: 10, leading: 14, before: 6, indent: 18, gray: 0.35},
+	Note:       {size: 9, leading: 12, before: 3, gray: 0.25},
+}
+
+// pdfText is a line of text placed on a page
+type pdfText struct {
+	x, y, size, gray float64
+	text             string
+}
+
+// pdfLayout flows the blocks of a document onto pages
+type pdfLayout struct {
+	font  *Font
+	pages [][]pdfText
+	y     float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.pages = append(l.pages, nil)
+	l.y = pdfPageHeight - pdfMargin
+}
+
+func (l *pdfLayout) place(x, size, leading, gray float64, text string) {
+	if l.y-leading < pdfMargin+20 {
+		l.newPage()
+	}
+	l.y -= leading
+	page := len(l.pages) - 1
+	l.pages[page] = append(l.pages[page], pdfText{x: x, y: l.y, size: size, gray: gray, text: text})
+}
+
+// wrap breaks text into lines no wider than width, splitting words that
+// do not fit on a line of their own
+func (l *pdfLayout) wrap(text string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if l.font.Width(candidate, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			line = ""
+			for l.font.Width(word, size) > width {
+				runes := []rune(word)
+				n := len(runes) - 1
+				for n > 1 && l.font.Width(string(runes[:n]), size) > width {
+					n--
+				}
+				lines = append(lines, string(runes[:n]))
+				word = string(runes[n:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (l *pdfLayout) block(style pdfStyle, text string) {
+	width := pdfPageWidth - 2*pdfMargin - style.indent
+	l.y -= style.before
+	for i, line := range l.wrap(text, style.size, width) {
+		l.place(pdfMargin+style.indent, style.size, style.leading, style.gray, line)
+		if i == 0 && style.prefix != "" {
+			// The prefix hangs left of the first line, on its baseline
+			page := len(l.pages) - 1
+			l.pages[page] = append(l.pages[page], pdfText{x: pdfMargin + style.indent - 10, y: l.y, size: style.size, gray: style.gray, text: style.prefix})
+		}
+	}
+}
+
+// RenderPDF lays out the document on A4 pages with font embedded, so any
+// script the font covers renders and can be copied from the PDF
+func RenderPDF(doc *Document, font *Font) ([]byte, error) {
+	l := &pdfLayout{font: font}
+	l.newPage()
+	for _, line := range l.wrap(doc.Title, 18, pdfPageWidth-2*pdfMargin) {
+		l.place(pdfMargin, 18, 24, 0, line)
+	}
+	if byline := doc.byline(); byline != "" {
+		l.place(pdfMargin, 9, 16, 0.4, byline)
+	}
+	for _, b := range doc.Blocks {
+		style, ok := pdfStyles[b.Kind]
+		if !ok {
+			style = pdfStyles[Paragraph]
+		}
+		l.block(style, b.Text)
+	}
+
+	total := len(l.pages)
+	for i := range l.pages {
+		footer := fmt.Sprintf("%d / %d", i+1, total)
+		x := pdfPageWidth - pdfMargin - font.Width(footer, 8)
+		l.pages[i] = append(l.pages[i], pdfText{x: x, y: pdfMargin - 20, size: 8, gray: 0.5, text: footer})
+	}
+	return writePDF(doc.Title, l.pages, font)
+}
+
+// pdfWriter numbers objects and records their offsets for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// object writes object n, which must be the next unwritten one
+func (w *pdfWriter) object(n int, body string) {
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+func (w *pdfWriter) stream(n int, dict string, data []byte) error {
+	compressed, err := deflate(data)
+	if err != nil {
+		return err
+	}
+	w.compressedStream(n, dict, compressed)
+	return nil
+}
+
+func (w *pdfWriter) compressedStream(n int, dict string, compressed []byte) {
+	w.object(n, fmt.Sprintf("<< %s /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", dict, len(compressed), compressed))
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+func writePDF(title string, pages [][]pdfText, font *Font) ([]byte, error) {
+	const (
+		catalogObj = iota + 1
+		pagesObj
+		fontObj
+		cidFontObj
+		descriptorObj
+		fontFileObj
+		toUnicodeObj
+		infoObj
+		firstPageObj
+	)
+	scale := 1000 / float64(font.unitsPerEm)
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	// Glyphs used by the text, for the widths and the ToUnicode map
+	used := make(map[int]rune)
+	contents := make([][]byte, len(pages))
+	for i, page := range pages {
+		var content bytes.Buffer
+		for _, t := range page {
+			var hex strings.Builder
+			for _, r := range t.text {
+				glyph := font.Glyph(r)
+				if _, ok := used[glyph]; !ok {
+					used[glyph] = r
+				}
+				fmt.Fprintf(&hex, "%04X", glyph)
+			}
+			fmt.Fprintf(&content, "%.2f g BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", t.gray, t.size, t.x, t.y, hex.String())
+		}
+		contents[i] = content.Bytes()
+	}
+	glyphs := make([]int, 0, len(used))
+	for glyph := range used {
+		glyphs = append(glyphs, glyph)
+	}
+	sort.Ints(glyphs)
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObj+2*i)
+	}
+	w.object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	w.object(fontObj, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		font.Name, cidFontObj, toUnicodeObj))
+
+	var widths strings.Builder
+	for _, glyph := range glyphs {
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, int(float64(font.advance(glyph))*scale+0.5))
+	}
+	w.object(cidFontObj, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s] /CIDToGIDMap /Identity >>",
+		font.Name, descriptorObj, widths.String()))
+
+	s := func(v int) int { return int(float64(v) * scale) }
+	w.object(descriptorObj, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		font.Name, s(font.bbox[0]), s(font.bbox[1]), s(font.bbox[2]), s(font.bbox[3]), s(font.ascent), s(font.descent), s(font.ascent), fontFileObj))
+	fontFile, err := font.deflated()
+	if err != nil {
+		return nil, err
+	}
+	w.compressedStream(fontFileObj, fmt.Sprintf("/Length1 %d", len(font.data)), fontFile)
+	if err := w.stream(toUnicodeObj, "", toUnicodeCMap(glyphs, used)); err != nil {
+		return nil, err
+	}
+	w.object(infoObj, fmt.Sprintf("<< /Title <%s> /Producer (legal-rag) >>", utf16Hex(title)))
+
+	for i, content := range contents {
+		pageObj := firstPageObj + 2*i
+		w.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, fontObj, pageObj+1))
+		if err := w.stream(pageObj+1, "", content); err != nil {
+			return nil, err
+		}
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalogObj, infoObj, xref)
+	return w.buf.Bytes(), nil
+}
+
+// toUnicodeCMap maps glyphs back to the runes they were drawn for, so text
+// can be searched and copied
+func toUnicodeCMap(glyphs []int, runes map[int]rune) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		chunk := glyphs[start:min(start+100, len(glyphs))]
+		fmt.Fprintf(&b, "%d beginbfchar\n", len(chunk))
+		for _, glyph := range chunk {
+			fmt.Fprintf(&b, "<%04X> <%s>\n", glyph, utf16Hex(string(runes[glyph]))[4:])
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// utf16Hex encodes s as a hex PDF text string with a byte order mark
+func utf16Hex(s string) string {
+	var b strings.Builder
+	b.WriteString("FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	return b.String()
+}
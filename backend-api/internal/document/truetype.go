@@ -0,0 +1,269 @@
+package document
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Font is a TrueType font embedded into PDFs. Only the tables needed to map
+// runes to glyphs and measure text are parsed; the file is embedded as is.
+type Font struct {
+	Name       string
+	data       []byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	advances   []int
+	cmap       func(r rune) int
+
+	// The font file is embedded whole, so it is compressed once
+	deflateOnce sync.Once
+	compressed  []byte
+	deflateErr  error
+}
+
+// fontCandidates are the Unicode fonts looked for when no font is
+// configured: Debian/Ubuntu and Alpine locations of DejaVu Sans
+var fontCandidates = []string{
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+}
+
+// FindFont loads the font at path, or the first installed candidate when
+// path is empty
+func FindFont(path string) (*Font, error) {
+	if path != "" {
+		return LoadFont(path)
+	}
+	for _, candidate := range fontCandidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return LoadFont(candidate)
+		}
+	}
+	return nil, fmt.Errorf("no Unicode TrueType font found (looked for %s)", strings.Join(fontCandidates, ", "))
+}
+
+// LoadFont reads a TrueType (.ttf) font file
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+	font, err := parseFont(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font %s: %w", path, err)
+	}
+	font.Name = strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	return font, nil
+}
+
+var errBadFont = errors.New("not a TrueType font")
+
+func parseFont(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, errBadFont
+	}
+	if v := binary.BigEndian.Uint32(data); v != 0x00010000 && v != 0x74727565 {
+		return nil, errBadFont
+	}
+
+	tables := make(map[string][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errBadFont
+		}
+		offset := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errBadFont
+		}
+		tables[string(data[rec:rec+4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap", "glyf"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+
+	head, hhea, hmtx := tables["head"], tables["hhea"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 {
+		return nil, errBadFont
+	}
+	f := &Font{
+		data:       data,
+		unitsPerEm: int(binary.BigEndian.Uint16(head[18:])),
+		ascent:     int(int16(binary.BigEndian.Uint16(hhea[4:]))),
+		descent:    int(int16(binary.BigEndian.Uint16(hhea[6:]))),
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	if f.unitsPerEm == 0 {
+		return nil, errBadFont
+	}
+
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if numMetrics == 0 || len(hmtx) < 4*numMetrics {
+		return nil, errBadFont
+	}
+	f.advances = make([]int, numMetrics)
+	for i := range f.advances {
+		f.advances[i] = int(binary.BigEndian.Uint16(hmtx[4*i:]))
+	}
+
+	cmap, err := parseCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.cmap = cmap
+	return f, nil
+}
+
+// parseCmap returns the rune to glyph lookup of the Unicode cmap subtable,
+// preferring the full-repertoire format 12 over the BMP-only format 4
+func parseCmap(table []byte) (func(rune) int, error) {
+	if len(table) < 4 {
+		return nil, errBadFont
+	}
+	var format4, format12 []byte
+	numTables := int(binary.BigEndian.Uint16(table[2:]))
+	for i := 0; i < numTables; i++ {
+		rec := 4 + 8*i
+		if rec+8 > len(table) {
+			return nil, errBadFont
+		}
+		platform := binary.BigEndian.Uint16(table[rec:])
+		encoding := binary.BigEndian.Uint16(table[rec+2:])
+		offset := int(binary.BigEndian.Uint32(table[rec+4:]))
+		if offset+4 > len(table) || !(platform == 0 || platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		sub := table[offset:]
+		switch binary.BigEndian.Uint16(sub) {
+		case 4:
+			format4 = sub
+		case 12:
+			format12 = sub
+		}
+	}
+
+	switch {
+	case len(format12) >= 16:
+		return cmapFormat12(format12)
+	case len(format4) >= 14:
+		return cmapFormat4(format4)
+	}
+	return nil, errors.New("no Unicode cmap subtable")
+}
+
+func cmapFormat4(sub []byte) (func(rune) int, error) {
+	segCount := int(binary.BigEndian.Uint16(sub[6:])) / 2
+	endCodes := 14
+	startCodes := endCodes + 2*segCount + 2
+	idDeltas := startCodes + 2*segCount
+	idRangeOffsets := idDeltas + 2*segCount
+	if idRangeOffsets+2*segCount > len(sub) {
+		return nil, errBadFont
+	}
+	u16 := func(at int) int {
+		if at+2 > len(sub) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(sub[at:]))
+	}
+	return func(r rune) int {
+		if r > 0xFFFF {
+			return 0
+		}
+		c := int(r)
+		for i := 0; i < segCount; i++ {
+			if c > u16(endCodes+2*i) {
+				continue
+			}
+			start := u16(startCodes + 2*i)
+			if c < start {
+				return 0
+			}
+			delta := u16(idDeltas + 2*i)
+			rangeOffset := u16(idRangeOffsets + 2*i)
+			if rangeOffset == 0 {
+				return (c + delta) & 0xFFFF
+			}
+			glyph := u16(idRangeOffsets + 2*i + rangeOffset + 2*(c-start))
+			if glyph == 0 {
+				return 0
+			}
+			return (glyph + delta) & 0xFFFF
+		}
+		return 0
+	}, nil
+}
+
+func cmapFormat12(sub []byte) (func(rune) int, error) {
+	groups := int(binary.BigEndian.Uint32(sub[12:]))
+	if 16+12*groups > len(sub) {
+		return nil, errBadFont
+	}
+	return func(r rune) int {
+		c := uint32(r)
+		lo, hi := 0, groups-1
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			g := sub[16+12*mid:]
+			start, end := binary.BigEndian.Uint32(g), binary.BigEndian.Uint32(g[4:])
+			switch {
+			case c < start:
+				hi = mid - 1
+			case c > end:
+				lo = mid + 1
+			default:
+				return int(binary.BigEndian.Uint32(g[8:]) + c - start)
+			}
+		}
+		return 0
+	}, nil
+}
+
+// deflated returns the compressed font file
+func (f *Font) deflated() ([]byte, error) {
+	f.deflateOnce.Do(func() {
+		f.compressed, f.deflateErr = deflate(f.data)
+	})
+	return f.compressed, f.deflateErr
+}
+
+// Glyph returns the glyph of r, 0 (.notdef) when the font lacks it
+func (f *Font) Glyph(r rune) int {
+	return f.cmap(r)
+}
+
+// advance returns the advance width of a glyph in font units
+func (f *Font) advance(glyph int) int {
+	if glyph < len(f.advances) {
+		return f.advances[glyph]
+	}
+	return f.advances[len(f.advances)-1]
+}
+
+// Width returns the width of s in points at the given font size
+func (f *Font) Width(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		units += f.advance(f.Glyph(r))
+	}
+	return float64(units) * size / float64(f.unitsPerEm)
+}
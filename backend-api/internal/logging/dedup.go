@@ -0,0 +1,147 @@
+// Package logging sets up the structured logs of the server and collapses
+// repeated log messages, so an outage that fails every request logs each
+// distinct error once per window with a count instead of once per request.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestampFormat matches the timestamp of the standard logger
+const timestampFormat = "2006/01/02 15:04:05"
+
+// durationPattern matches durations such as 1.2ms, which differ between
+// otherwise identical messages
+var durationPattern = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ns|µs|us|ms|s|m|h)\b`)
+
+// volatilePattern matches the attributes of a JSON or text log line that
+// differ between requests
+var volatilePattern = regexp.MustCompile(`"?\b(?:request_id|latency_ms)"?[=:](?:"[^"]*"|[^\s,}]+)`)
+
+type repeated struct {
+	message string
+	first   time.Time
+	repeats int
+}
+
+// Deduplicator is a log writer that writes the first occurrence of a
+// message and counts the repeats that follow within the window; when the
+// window ends, one line reports how often the message repeated. Messages
+// identical but for durations, request IDs and latencies count as repeats.
+// JSON lines get a time attribute and other lines a timestamp prefix.
+type Deduplicator struct {
+	mu     sync.Mutex
+	out    io.Writer
+	window time.Duration
+	now    func() time.Time
+	seen   map[string]*repeated
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewDeduplicator writes to out and reports repeats every window until it
+// is closed
+func NewDeduplicator(out io.Writer, window time.Duration) *Deduplicator {
+	d := &Deduplicator{
+		out:    out,
+		window: window,
+		now:    time.Now,
+		seen:   make(map[string]*repeated),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *Deduplicator) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.Flush(false)
+		case <-d.stop:
+			d.Flush(true)
+			return
+		}
+	}
+}
+
+// Write logs one message
+func (d *Deduplicator) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	key := volatilePattern.ReplaceAllString(durationPattern.ReplaceAllString(message, "<duration>"), "")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if r, ok := d.seen[key]; ok && now.Sub(r.first) < d.window {
+		r.repeats++
+		return len(p), nil
+	} else if ok {
+		d.reportLocked(r)
+	}
+	d.seen[key] = &repeated{message: message, first: now}
+	if _, err := io.WriteString(d.out, stamp(message, now, "")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stamp adds the time to a log line, followed by note
+func stamp(message string, now time.Time, note string) string {
+	if strings.HasPrefix(message, "{") && strings.HasSuffix(message, "}") {
+		if note != "" {
+			message = strings.TrimSuffix(message, "}") + "," + note + "}"
+		}
+		return fmt.Sprintf(`{"time":%q,%s`, now.Format(time.RFC3339Nano), message[1:]) + "\n"
+	}
+	if note != "" {
+		message += " (" + note + ")"
+	}
+	return now.Format(timestampFormat) + " " + message + "\n"
+}
+
+// Flush reports the messages whose window ended, or all of them when all
+// is set, and forgets them
+func (d *Deduplicator) Flush(all bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	var ended []*repeated
+	for key, r := range d.seen {
+		if all || now.Sub(r.first) >= d.window {
+			ended = append(ended, r)
+			delete(d.seen, key)
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].first.Before(ended[j].first) })
+	for _, r := range ended {
+		d.reportLocked(r)
+	}
+}
+
+func (d *Deduplicator) reportLocked(r *repeated) {
+	if r.repeats == 0 {
+		return
+	}
+	note := fmt.Sprintf("repeated %d more times since %s", r.repeats, r.first.Format("15:04:05"))
+	if strings.HasPrefix(r.message, "{") {
+		note = fmt.Sprintf(`"repeated":%d,"since":%q`, r.repeats, r.first.Format("15:04:05"))
+	}
+	io.WriteString(d.out, stamp(r.message, d.now(), note))
+}
+
+// Close reports the pending repeats and stops the window timer
+func (d *Deduplicator) Close() {
+	close(d.stop)
+	<-d.done
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for the window timer's writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDeduplicator(t *testing.T) {
+	var out syncBuffer
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := NewDeduplicator(&out, time.Hour)
+	d.mu.Lock()
+	d.now = func() time.Time { return clock }
+	d.mu.Unlock()
+
+	for _, duration := range []string{"1.2ms", "980µs", "3ms"} {
+		d.Write([]byte("POST /api/legal-query - 503 - " + duration + "\n"))
+		d.Write([]byte("Error calling Python AI Engine: connection refused\n"))
+	}
+	d.Write([]byte("Created API key k_1\n"))
+	want := "2026/01/02 03:04:05 POST /api/legal-query - 503 - 1.2ms\n" +
+		"2026/01/02 03:04:05 Error calling Python AI Engine: connection refused\n" +
+		"2026/01/02 03:04:05 Created API key k_1\n"
+	if got := out.String(); got != want {
+		t.Fatalf("output = %q, want the repeats collapsed:\n%q", got, want)
+	}
+
+	// A message repeated after its window is written again, after the count
+	// of its repeats
+	clock = clock.Add(2 * time.Hour)
+	d.Write([]byte("Error calling Python AI Engine: connection refused\n"))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || !strings.HasSuffix(lines[3], "connection refused (repeated 2 more times since 03:04:05)") ||
+		!strings.HasSuffix(lines[4], "connection refused") {
+		t.Errorf("output after the window = %q", lines)
+	}
+
+	d.Close()
+	if got := out.String(); !strings.Contains(got, "503 - 1.2ms (repeated 2 more times since 03:04:05)") {
+		t.Errorf("output after Close = %q, want the pending repeats reported", got)
+	}
+}
+
+func TestDeduplicatorJSON(t *testing.T) {
+	var out syncBuffer
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := NewDeduplicator(&out, time.Hour)
+	d.mu.Lock()
+	d.now = func() time.Time { return clock }
+	d.mu.Unlock()
+
+	for _, id := range []string{"a1", "b2", "c3"} {
+		d.Write([]byte(`{"level":"ERROR","msg":"request","status":503,"latency_ms":1.2,"request_id":"` + id + `"}` + "\n"))
+	}
+	want := `{"time":"2026-01-02T03:04:05Z","level":"ERROR","msg":"request","status":503,"latency_ms":1.2,"request_id":"a1"}` + "\n"
+	if got := out.String(); got != want {
+		t.Fatalf("output = %q, want requests differing in ID and latency collapsed:\n%q", got, want)
+	}
+
+	d.Close()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], `"request_id":"a1","repeated":2,"since":"03:04:05"}`) {
+		t.Errorf("output after Close = %q, want the repeats reported as JSON", lines)
+	}
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Formats of the log lines
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+var Formats = []string{FormatJSON, FormatText}
+
+// Options configure the default slog logger
+type Options struct {
+	// Format is FormatJSON or FormatText
+	Format string
+
+	// Debug writes debug logs, which are dropped otherwise
+	Debug bool
+
+	// DedupWindow, when positive, collapses repeated messages with a
+	// Deduplicator
+	DedupWindow time.Duration
+
+	// ContextAttrs, when set, adds attributes taken from the context of a
+	// log call, such as the request ID, unless the call sets them itself
+	ContextAttrs func(ctx context.Context) []slog.Attr
+}
+
+// Setup makes the default slog logger, and with it the standard logger,
+// write to out. The returned function reports the pending repeats of the
+// Deduplicator, if any.
+func Setup(out io.Writer, opts Options) func() {
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if opts.Debug {
+		handlerOpts.Level = slog.LevelDebug
+	}
+
+	closeFn := func() {}
+	if opts.DedupWindow > 0 {
+		dedup := NewDeduplicator(out, opts.DedupWindow)
+		out, closeFn = dedup, dedup.Close
+		// The Deduplicator writes the timestamps, so identical messages
+		// compare equal
+		handlerOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(out, handlerOpts)
+	if opts.Format == FormatText {
+		handler = slog.NewTextHandler(out, handlerOpts)
+	}
+	if opts.ContextAttrs != nil {
+		handler = contextHandler{Handler: handler, attrs: opts.ContextAttrs}
+	}
+	slog.SetDefault(slog.New(handler))
+	return closeFn
+}
+
+// contextHandler adds the attributes of the context of a log call
+type contextHandler struct {
+	slog.Handler
+	attrs func(ctx context.Context) []slog.Attr
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, attr := range h.attrs(ctx) {
+		set := false
+		r.Attrs(func(a slog.Attr) bool {
+			set = a.Key == attr.Key
+			return !set
+		})
+		if !set {
+			r.AddAttrs(attr)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs), attrs: h.attrs}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
@@ -0,0 +1,317 @@
+// Package metrics is a minimal Prometheus instrumentation library. It
+// covers counters, gauges and histograms with labels, plus values read from
+// a function when scraped, and writes them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are histogram buckets, in seconds, for request latencies
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them in registration order
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText writes every metric in the text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics to a scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteText(w)
+}
+
+// meta is the name, help and label names of a family
+type meta struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (m meta) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+}
+
+// series is one labelled time series of a vector
+type series[T any] struct {
+	values []string
+	metric T
+}
+
+// vec maps label values to the series of a family
+type vec[T any] struct {
+	meta
+	mu     sync.Mutex
+	series map[string]*series[T]
+	create func() T
+}
+
+func newVec[T any](m meta, create func() T) *vec[T] {
+	return &vec[T]{meta: m, series: make(map[string]*series[T]), create: create}
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{values: slices.Clone(values), metric: v.create()}
+		v.series[key] = s
+	}
+	return s.metric
+}
+
+// sorted returns the series ordered by their label values
+func (v *vec[T]) sorted() []*series[T] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	list := make([]*series[T], 0, len(v.series))
+	for _, s := range v.series {
+		list = append(list, s)
+	}
+	slices.SortFunc(list, func(a, b *series[T]) int { return slices.Compare(a.values, b.values) })
+	return list
+}
+
+// Value is a counter or gauge value
+type Value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *Value) Add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *Value) Inc() { v.Add(1) }
+func (v *Value) Dec() { v.Add(-1) }
+
+func (v *Value) Set(value float64) {
+	v.mu.Lock()
+	v.v = value
+	v.mu.Unlock()
+}
+
+func (v *Value) Get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// ValueVec is a counter or gauge family; counters must only be increased
+type ValueVec struct {
+	*vec[*Value]
+}
+
+// NewCounterVec registers a counter family
+func (r *Registry) NewCounterVec(name, help string, labels ...string) ValueVec {
+	return r.newValueVec(meta{name, help, "counter", labels})
+}
+
+// NewGaugeVec registers a gauge family
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) ValueVec {
+	return r.newValueVec(meta{name, help, "gauge", labels})
+}
+
+func (r *Registry) newValueVec(m meta) ValueVec {
+	v := ValueVec{newVec(m, func() *Value { return &Value{} })}
+	r.register(m.name, v)
+	return v
+}
+
+// With returns the series of the label values, in the order of the labels
+func (v ValueVec) With(values ...string) *Value {
+	return v.with(values)
+}
+
+func (v ValueVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		writeSample(w, v.name, v.labels, s.values, "", "", s.metric.Get())
+	}
+}
+
+// funcValue is a counter or gauge read when scraped
+type funcValue struct {
+	meta
+	fn func() float64
+}
+
+// NewCounterFunc registers a counter whose value fn returns when scraped
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, funcValue{meta{name: name, help: help, kind: "counter"}, fn})
+}
+
+// NewGaugeFunc registers a gauge whose value fn returns when scraped
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, funcValue{meta{name: name, help: help, kind: "gauge"}, fn})
+}
+
+func (f funcValue) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(value float64) {
+	i, _ := slices.BinarySearch(h.buckets, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// HistogramVec is a histogram family
+type HistogramVec struct {
+	*vec[*Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram family with the given upper bounds
+// of its buckets, in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	buckets = slices.Clone(buckets)
+	h := HistogramVec{
+		vec: newVec(meta{name, help, "histogram", labels}, func() *Histogram {
+			return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		}),
+		buckets: buckets,
+	}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram of the label values, in the order of the labels
+func (h HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		s.metric.mu.Lock()
+		counts := slices.Clone(s.metric.counts)
+		sum, count := s.metric.sum, s.metric.count
+		s.metric.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(count))
+	}
+}
+
+// writeSample writes a sample line, with an extra label such as le when
+// extraName is set
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
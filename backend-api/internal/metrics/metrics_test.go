@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "method", "route")
+	latency := r.NewHistogramVec("request_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	inFlight := r.NewGaugeVec("in_flight", "Requests in flight.")
+	r.NewGaugeFunc("hit_ratio", "Cache hit ratio.", func() float64 { return 0.75 })
+
+	requests.With("POST", "/api/legal-query").Add(2)
+	requests.With("GET", `/say "hi"`).Inc()
+	latency.With("/api/legal-query").Observe(0.05)
+	latency.With("/api/legal-query").Observe(0.5)
+	latency.With("/api/legal-query").Observe(3)
+	inFlight.With().Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, nil)
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="GET",route="/say \"hi\""} 1
+requests_total{method="POST",route="/api/legal-query"} 2
+# HELP request_seconds Request latency.
+# TYPE request_seconds histogram
+request_seconds_bucket{route="/api/legal-query",le="0.1"} 1
+request_seconds_bucket{route="/api/legal-query",le="1"} 2
+request_seconds_bucket{route="/api/legal-query",le="+Inf"} 3
+request_seconds_sum{route="/api/legal-query"} 3.55
+request_seconds_count{route="/api/legal-query"} 3
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP hit_ratio Cache hit ratio.
+# TYPE hit_ratio gauge
+hit_ratio 0.75
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %q", ct)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("requests_total", "Requests served.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	r.NewGaugeVec("requests_total", "Requests served.")
+}
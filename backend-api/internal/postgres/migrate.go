@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration is one step of a schema, applied once and recorded by version
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrationLock is the advisory lock held while migrating, so that servers
+// starting together do not apply the same migration twice
+const migrationLock = 7106820
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// Migrate applies the migrations not yet recorded in schema_migrations, in
+// order of version. Each runs in its own transaction together with its
+// record, so a failed migration leaves the schema as it was.
+func Migrate(pool *pgxpool.Pool, migrations []Migration) (applied int, err error) {
+	// A migration may rewrite a large table, so only connecting is bounded.
+	// The advisory lock belongs to a session, so every statement runs on
+	// one connection.
+	ctx := context.Background()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return 0, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	if _, err := conn.Exec(ctx, createMigrationsTable); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	rows, _ := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	migrations = slices.SortedFunc(slices.Values(migrations), func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	for _, m := range migrations {
+		if slices.Contains(versions, m.Version) {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
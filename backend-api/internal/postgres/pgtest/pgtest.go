@@ -0,0 +1,419 @@
+// Package pgtest runs a server speaking the PostgreSQL protocol, for tests.
+// Statements are answered by a handler rather than a database, which sees
+// each statement with its arguments as text. By default clients are told to
+// send statements with text parameters in a single round trip, as pgx does
+// in its exec mode; with a Describer they prepare statements as pgx does by
+// default, and send and read values in the formats they prefer.
+package pgtest
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Result is the answer to a statement. Types are the names of the column
+// types, text when unset. Rows hold values as text. Tag defaults to
+// "SELECT <rows>".
+type Result struct {
+	Columns []string
+	Types   []string
+	Rows    [][]*string
+	Tag     string
+}
+
+// Error is reported to the client with its SQLSTATE code
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Handler answers a statement with its parameters, nil for NULL
+type Handler func(query string, args []*string) (Result, error)
+
+// Statement describes a statement a client prepares: the type names of its
+// parameters, and the columns of its result, as in Result
+type Statement struct {
+	Params  []string
+	Columns []string
+	Types   []string
+}
+
+// Describer describes the statements clients prepare, before they run them
+type Describer func(query string) (Statement, error)
+
+// Server is a PostgreSQL server for tests
+type Server struct {
+	listener net.Listener
+	handler  Handler
+
+	mu        sync.Mutex
+	types     *pgtype.Map
+	down      bool
+	password  string
+	describer Describer
+}
+
+// NewServer listens on a local port until Close
+func NewServer(handler Handler) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, handler: handler, types: pgtype.NewMap()}
+	go s.serve()
+	return s, nil
+}
+
+// SetPassword requires clients to authenticate with password
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetDescriber lets clients prepare statements, described by describer.
+// It must be called before URL.
+func (s *Server) SetDescriber(describer Describer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.describer = describer
+}
+
+// SetDown makes the server drop every connection on its next message,
+// as during an outage, until it is set up again
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// URL is the postgres:// URL of the server, with its password if any
+func (s *Server) URL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := url.User("test")
+	if s.password != "" {
+		user = url.UserPassword("test", s.password)
+	}
+	query := url.Values{"sslmode": {"disable"}}
+	if s.describer == nil {
+		query.Set("default_query_exec_mode", "exec")
+	}
+	return (&url.URL{Scheme: "postgres", User: user, Host: s.listener.Addr().String(), Path: "/test", RawQuery: query.Encode()}).String()
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// portal is a statement bound to its arguments
+type portal struct {
+	query   string
+	args    []*string
+	formats []int16 // of the result columns
+	result  *Result // once run
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+	if err := s.startup(conn, backend); err != nil {
+		return
+	}
+
+	var (
+		statements = map[string]string{}
+		described  = map[string]Statement{}
+		bound      portal
+		failed     bool
+	)
+	// fail reports err and skips the rest of the statement, up to Sync
+	fail := func(err error) {
+		backend.Send(errorResponse(err))
+		failed = true
+	}
+	run := func() bool {
+		if bound.result != nil {
+			return true
+		}
+		res, err := s.handler(bound.query, bound.args)
+		if err != nil {
+			fail(err)
+			return false
+		}
+		bound.result = &res
+		return true
+	}
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		down := s.down
+		s.mu.Unlock()
+		if down {
+			return
+		}
+		if _, ok := msg.(*pgproto3.Sync); !ok && failed {
+			continue
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			if res, err := s.handler(msg.String, nil); err != nil {
+				backend.Send(errorResponse(err))
+			} else {
+				s.sendRows(backend, res, nil, true)
+			}
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Parse:
+			statements[msg.Name] = msg.Query
+			delete(described, msg.Name)
+			backend.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Describe:
+			if msg.ObjectType == 'S' {
+				stmt, err := s.describe(statements[msg.Name])
+				if err != nil {
+					fail(err)
+					continue
+				}
+				described[msg.Name] = stmt
+				backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: s.oids(stmt.Params)})
+				s.sendDescription(backend, Result{Columns: stmt.Columns, Types: stmt.Types}, nil)
+				continue
+			}
+			if run() {
+				s.sendDescription(backend, *bound.result, bound.formats)
+			}
+		case *pgproto3.Bind:
+			query := statements[msg.PreparedStatement]
+			args, err := s.textArgs(s.oids(described[msg.PreparedStatement].Params), msg.ParameterFormatCodes, msg.Parameters)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			bound = portal{query: query, args: args, formats: msg.ResultFormatCodes}
+			backend.Send(&pgproto3.BindComplete{})
+		case *pgproto3.Execute:
+			if run() {
+				s.sendRows(backend, *bound.result, bound.formats, false)
+			}
+		case *pgproto3.Close:
+			if msg.ObjectType == 'S' {
+				delete(statements, msg.Name)
+				delete(described, msg.Name)
+			}
+			backend.Send(&pgproto3.CloseComplete{})
+		case *pgproto3.Sync:
+			failed = false
+			bound = portal{}
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Terminate:
+			return
+		}
+		if err := backend.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) describe(query string) (Statement, error) {
+	s.mu.Lock()
+	describer := s.describer
+	s.mu.Unlock()
+	if describer == nil {
+		return Statement{}, &Error{Code: "0A000", Message: "pgtest: preparing statements needs a Describer"}
+	}
+	return describer(query)
+}
+
+// startup declines TLS and authenticates the client
+func (s *Server) startup(conn net.Conn, backend *pgproto3.Backend) error {
+	for {
+		msg, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return err
+		}
+		if _, ok := msg.(*pgproto3.StartupMessage); ok {
+			break
+		}
+		if _, err := conn.Write([]byte{'N'}); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	password := s.password
+	s.mu.Unlock()
+	if password != "" {
+		backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+		if err := backend.Flush(); err != nil {
+			return err
+		}
+		backend.SetAuthType(pgproto3.AuthTypeCleartextPassword)
+		msg, err := backend.Receive()
+		if err != nil {
+			return err
+		}
+		if msg, ok := msg.(*pgproto3.PasswordMessage); !ok || msg.Password != password {
+			backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: `password authentication failed for user "test"`})
+			backend.Flush()
+			return fmt.Errorf("wrong password")
+		}
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.BackendKeyData{SecretKey: make([]byte, 4)})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	return backend.Flush()
+}
+
+// oids are the OIDs of the named types, text when unknown
+func (s *Server) oids(names []string) []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oids := make([]uint32, len(names))
+	for i, name := range names {
+		oids[i] = pgtype.TextOID
+		if typ, ok := s.types.TypeForName(name); ok {
+			oids[i] = typ.OID
+		}
+	}
+	return oids
+}
+
+// format is the format code of the ith of n values: formats holds one code
+// per value, one for every value, or none for text
+func format(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return pgtype.TextFormatCode
+	case 1:
+		return formats[0]
+	}
+	return formats[i]
+}
+
+// textArgs converts the parameters of a statement to text
+func (s *Server) textArgs(oids []uint32, formats []int16, values [][]byte) ([]*string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := make([]*string, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		if format(formats, i) == pgtype.BinaryFormatCode {
+			oid := uint32(pgtype.TextOID)
+			if i < len(oids) {
+				oid = oids[i]
+			}
+			text, err := s.convert(oid, pgtype.BinaryFormatCode, pgtype.TextFormatCode, value)
+			if err != nil {
+				return nil, fmt.Errorf("pgtest: parameter $%d: %w", i+1, err)
+			}
+			value = text
+		}
+		arg := string(value)
+		args[i] = &arg
+	}
+	return args, nil
+}
+
+// convert re-encodes a value of type oid from one format to the other
+func (s *Server) convert(oid uint32, from, to int16, value []byte) ([]byte, error) {
+	typ, ok := s.types.TypeForOID(oid)
+	if !ok {
+		return nil, fmt.Errorf("unknown type %d", oid)
+	}
+	decoded, err := typ.Codec.DecodeValue(s.types, oid, from, value)
+	if err != nil {
+		return nil, err
+	}
+	return s.types.Encode(oid, to, decoded, nil)
+}
+
+// sendDescription describes the columns of res, or that it has none
+func (s *Server) sendDescription(backend *pgproto3.Backend, res Result, formats []int16) {
+	if len(res.Columns) == 0 {
+		backend.Send(&pgproto3.NoData{})
+		return
+	}
+	oids := s.oids(res.Types[:min(len(res.Types), len(res.Columns))])
+	desc := &pgproto3.RowDescription{}
+	for i, name := range res.Columns {
+		oid := uint32(pgtype.TextOID)
+		if i < len(oids) {
+			oid = oids[i]
+		}
+		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: oid, DataTypeSize: -1, TypeModifier: -1, Format: format(formats, i)})
+	}
+	backend.Send(desc)
+}
+
+// sendRows sends the rows, in the formats asked for, and command tag,
+// preceded by the columns for simple queries, which are not described
+// beforehand
+func (s *Server) sendRows(backend *pgproto3.Backend, res Result, formats []int16, describe bool) {
+	if describe && len(res.Columns) > 0 {
+		s.sendDescription(backend, res, nil)
+	}
+	oids := s.oids(res.Types[:min(len(res.Types), len(res.Columns))])
+	for _, row := range res.Rows {
+		values := make([][]byte, len(row))
+		for i, value := range row {
+			if value == nil {
+				continue
+			}
+			values[i] = []byte(*value)
+			if format(formats, i) == pgtype.BinaryFormatCode {
+				oid := uint32(pgtype.TextOID)
+				if i < len(oids) {
+					oid = oids[i]
+				}
+				s.mu.Lock()
+				binary, err := s.convert(oid, pgtype.TextFormatCode, pgtype.BinaryFormatCode, values[i])
+				s.mu.Unlock()
+				if err != nil {
+					backend.Send(errorResponse(fmt.Errorf("pgtest: column %s: %w", res.Columns[i], err)))
+					return
+				}
+				values[i] = binary
+			}
+		}
+		backend.Send(&pgproto3.DataRow{Values: values})
+	}
+	tag := res.Tag
+	if tag == "" {
+		tag = fmt.Sprintf("SELECT %d", len(res.Rows))
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+}
+
+func errorResponse(err error) *pgproto3.ErrorResponse {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Code: "XX000", Message: err.Error()}
+	}
+	return &pgproto3.ErrorResponse{Severity: "ERROR", Code: e.Code, Message: e.Message}
+}
@@ -0,0 +1,414 @@
+// Package settings resolves settings from layered sources and records the
+// problems found while reading them. The server defines which settings
+// exist; this package only knows how to look them up and parse them.
+package settings
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Profile bundles setting defaults for a deployment environment
+type Profile struct {
+	Name     string
+	Settings map[string]string
+}
+
+// Problem is an invalid or missing setting. Without strict mode the server
+// logs it and falls back to a default; strict mode refuses to start.
+type Problem struct {
+	Setting string `json:"setting"`
+	Problem string `json:"problem"`
+}
+
+// Schema maps the keys of structured config files, dotted paths of
+// sections such as "server.port", to the settings they set
+type Schema map[string]string
+
+// layers resolves settings from, highest first: command-line flags, the
+// environment, the config file and the profile. Empty values count as
+// unset, so a lower layer or the built-in default applies.
+type layers struct {
+	flags    map[string]string
+	file     map[string]string
+	filePath string
+	profile  Profile
+	problems []Problem
+
+	// profiles and schema are kept for Reload
+	profiles map[string]Profile
+	schema   Schema
+}
+
+// current holds the layers every setting is read from. Loaded layers are
+// replaced rather than changed, except for their problems; mu guards both.
+var (
+	mu      sync.RWMutex
+	current = &layers{}
+)
+
+func loaded() *layers {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Load builds the setting layers from the flag overrides, the config file
+// named by CONFIG_FILE (.env when present otherwise) and the profile
+// selected by APP_ENV. YAML and TOML config files are read with schema. It
+// resets the recorded problems.
+func Load(flags map[string]string, profiles map[string]Profile, schema Schema) {
+	l := &layers{flags: flags, profiles: profiles, schema: schema}
+
+	path := l.get("CONFIG_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = ".env"
+	}
+	var file map[string]string
+	var err error
+	if isStructured(path) {
+		file, err = ReadStructuredFile(path, schema)
+	} else {
+		file, err = ReadFile(path)
+	}
+	switch {
+	case file != nil:
+		l.file = file
+		l.filePath = path
+		// Keys that set nothing are reported one by one
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, err := range joined.Unwrap() {
+				l.warn("CONFIG_FILE", "%v", err)
+			}
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		l.warn("CONFIG_FILE", "%v", err)
+	}
+
+	if name := l.get("APP_ENV"); name != "" {
+		if profile, ok := profiles[name]; ok {
+			l.profile = profile
+		} else {
+			l.warn("APP_ENV", "unknown APP_ENV %q (available: %v), using built-in defaults", name, ProfileNames(profiles))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = l
+}
+
+// Reload reads the config file again, with the flags, profiles and schema
+// of the last Load. It resets the recorded problems.
+func Reload() {
+	l := loaded()
+	Load(l.flags, l.profiles, l.schema)
+}
+
+// State is a snapshot of the setting layers, to go back to when reloaded
+// settings are rejected
+type State struct {
+	layers *layers
+}
+
+// Save returns the current setting layers
+func Save() State {
+	return State{layers: loaded()}
+}
+
+// Restore makes a saved state the current setting layers
+func Restore(s State) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = s.layers
+}
+
+// ProfileName returns the name of the loaded profile, if any
+func ProfileName() string {
+	return loaded().profile.Name
+}
+
+// FilePath returns the path of the loaded config file, if any
+func FilePath() string {
+	return loaded().filePath
+}
+
+// ProfileNames returns the sorted names of profiles
+func ProfileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a setting from the highest layer that sets it
+func Get(name string) string {
+	return loaded().get(name)
+}
+
+func (l *layers) get(name string) string {
+	if value := l.flags[name]; value != "" {
+		return value
+	}
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	if value := l.file[name]; value != "" {
+		return value
+	}
+	return l.profile.Settings[name]
+}
+
+// Warn logs a configuration problem and records it for the consolidated
+// report
+func Warn(setting, format string, args ...any) {
+	loaded().warn(setting, format, args...)
+}
+
+func (l *layers) warn(setting, format string, args ...any) {
+	problem := fmt.Sprintf(format, args...)
+	slog.Warn(problem, "setting", setting)
+	mu.Lock()
+	defer mu.Unlock()
+	l.problems = append(l.problems, Problem{Setting: setting, Problem: problem})
+}
+
+// Problems returns the problems recorded since the last Load
+func Problems() []Problem {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Problem(nil), current.problems...)
+}
+
+// ReadFile parses a .env style file: NAME=VALUE lines, optionally prefixed
+// by export, with blank lines and # comments ignored
+func ReadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid line %d in config file %s, expected NAME=VALUE", line, path)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
+
+// isStructured reports whether a config file is YAML or TOML rather than
+// NAME=VALUE lines
+func isStructured(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// settingName matches the names of settings, which structured files may
+// also use as top-level keys
+var settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// ReadStructuredFile reads a YAML or TOML config file. Keys of schema set
+// their setting, and top-level keys named like settings set themselves;
+// scalars are formatted as in a .env file and lists of scalars are joined
+// with commas. Keys that set nothing, and settings set twice, are returned
+// as a joined error along with the values of the other keys.
+func ReadStructuredFile(path string, schema Schema) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	var tree map[string]any
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		err = toml.Unmarshal(data, &tree)
+	} else {
+		err = yaml.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	setBy := make(map[string]string)
+	var errs []error
+	var walk func(prefix string, tree map[string]any)
+	walk = func(prefix string, tree map[string]any) {
+		keys := make([]string, 0, len(tree))
+		for key := range tree {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			path, value := prefix+key, tree[key]
+			name, ok := schema[path]
+			if !ok && prefix == "" && settingName.MatchString(key) {
+				name, ok = key, true
+			}
+			if !ok {
+				if section, isMap := value.(map[string]any); isMap {
+					walk(path+".", section)
+				} else {
+					errs = append(errs, fmt.Errorf("unknown key %q in config file", path))
+				}
+				continue
+			}
+			formatted, err := formatValue(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("key %q in config file: %w", path, err))
+				continue
+			}
+			if other, set := setBy[name]; set {
+				errs = append(errs, fmt.Errorf("keys %q and %q in config file both set %s", other, path, name))
+				continue
+			}
+			setBy[name] = path
+			values[name] = formatted
+		}
+	}
+	walk("", tree)
+	return values, errors.Join(errs...)
+}
+
+// formatValue formats a scalar, or a list of scalars, of a structured file
+// as the value of a setting
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64, time.Time:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := formatValue(item)
+			if _, isList := item.([]any); err != nil || isList {
+				return "", errors.New("lists may only hold scalars")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("expected a scalar or a list, got %T", value)
+}
+
+// String reads a string setting, returning def when unset
+func String(name, def string) string {
+	if value := Get(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// List reads a comma-separated setting, dropping empty items
+func List(name string) []string {
+	return SplitList(Get(name))
+}
+
+// SplitList splits a comma-separated value, dropping empty items
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Duration reads a duration setting, returning def when the setting is
+// unset or unparseable
+func Duration(name string, def time.Duration) time.Duration {
+	value := Get(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		Warn(name, "%s=%q is not a valid duration, using %v", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// IntInRange reads an integer setting, returning def when the setting is
+// unset, unparseable or outside [lo, hi]
+func IntInRange(name string, def, lo, hi int) int {
+	value := Get(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < lo || parsed > hi {
+		Warn(name, "%s=%q must be an integer between %d and %d, using %d", name, value, lo, hi, def)
+		return def
+	}
+	return parsed
+}
+
+// FloatInRange reads a float setting, falling back to def when it is unset,
+// unparseable or outside [lo, hi]
+func FloatInRange(name string, def, lo, hi float64) float64 {
+	value := Get(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < lo || parsed > hi {
+		Warn(name, "%s=%q must be a number between %g and %g, using %g", name, value, lo, hi, def)
+		return def
+	}
+	return parsed
+}
+
+// Bool reads a boolean setting, returning def when the setting is unset or
+// unparseable
+func Bool(name string, def bool) bool {
+	value := Get(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		Warn(name, "%s=%q is not a boolean, using %v", name, value, def)
+		return def
+	}
+	return parsed
+}
@@ -0,0 +1,258 @@
+package settings
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	return writeNamedFile(t, "legal-rag.env", content)
+}
+
+func writeNamedFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+var testProfiles = map[string]Profile{
+	"prod": {Name: "prod", Settings: map[string]string{"LOG_LEVEL": "warn", "MOCK_ENGINE": "false", "TEST_PROFILE_ONLY": "profile"}},
+}
+
+func TestLayers(t *testing.T) {
+	path := writeFile(t, "LOG_LEVEL=error\nTEST_FILE_ONLY=file\nMOCK_ENGINE=true\n")
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("APP_ENV", "prod")
+
+	Load(map[string]string{"CONFIG_FILE": path, "MOCK_ENGINE": ""}, testProfiles, nil)
+
+	tests := []struct{ name, want string }{
+		{"CONFIG_FILE", path},            // flag
+		{"LOG_LEVEL", "info"},            // environment over file and profile
+		{"MOCK_ENGINE", "true"},          // empty flag counts as unset
+		{"TEST_FILE_ONLY", "file"},       // file
+		{"TEST_PROFILE_ONLY", "profile"}, // profile
+		{"TEST_UNSET", ""},
+	}
+	for _, tt := range tests {
+		if got := Get(tt.name); got != tt.want {
+			t.Errorf("Get(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if ProfileName() != "prod" || FilePath() != path {
+		t.Errorf("profile %q, file %q, want prod and %s", ProfileName(), FilePath(), path)
+	}
+	if problems := Problems(); len(problems) != 0 {
+		t.Errorf("problems = %v, want none", problems)
+	}
+}
+
+func TestLoadProblems(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	Load(map[string]string{"CONFIG_FILE": filepath.Join(t.TempDir(), "missing.env")}, testProfiles, nil)
+
+	problems := Problems()
+	if len(problems) != 2 || problems[0].Setting != "CONFIG_FILE" || problems[1].Setting != "APP_ENV" {
+		t.Fatalf("problems = %v, want CONFIG_FILE and APP_ENV", problems)
+	}
+	if ProfileName() != "" {
+		t.Errorf("profile = %q, want built-in defaults", ProfileName())
+	}
+
+	Load(nil, testProfiles, nil)
+	if problems := Problems(); len(problems) != 1 {
+		t.Errorf("problems after reload = %v, want only the new APP_ENV problem", problems)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := writeFile(t, "# comment\n\nexport A=1\nB = \"two words\"\nC='3'\nD=\n")
+	values, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want := map[string]string{"A": "1", "B": "two words", "C": "3", "D": ""}
+	if len(values) != len(want) {
+		t.Fatalf("values = %v, want %v", values, want)
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+
+	if _, err := ReadFile(writeFile(t, "A=1\nnot a setting\n")); err == nil {
+		t.Error("ReadFile accepted a line without NAME=VALUE")
+	}
+}
+
+var testSchema = Schema{
+	"server.port":         "GO_SERVER_PORT",
+	"server.cors.origins": "CORS_ALLOW_ORIGIN",
+	"cache.ttl":           "RESPONSE_CACHE_TTL",
+	"engine.mock":         "MOCK_ENGINE",
+}
+
+func TestStructuredFile(t *testing.T) {
+	files := map[string]string{
+		"legal-rag.yaml": `
+server:
+  port: 8081
+  cors:
+    origins: [https://a.example, https://b.example]
+cache:
+  ttl: 10m
+engine:
+  mock: true
+HISTORY_MAX_ENTRIES: 500
+`,
+		"legal-rag.toml": `
+HISTORY_MAX_ENTRIES = 500
+
+[server]
+port = 8081
+cors = { origins = ["https://a.example", "https://b.example"] }
+
+[cache]
+ttl = "10m"
+
+[engine]
+mock = true
+`,
+	}
+	want := map[string]string{
+		"GO_SERVER_PORT":      "8081",
+		"CORS_ALLOW_ORIGIN":   "https://a.example,https://b.example",
+		"RESPONSE_CACHE_TTL":  "10m",
+		"MOCK_ENGINE":         "true",
+		"HISTORY_MAX_ENTRIES": "500",
+	}
+	for name, content := range files {
+		values, err := ReadStructuredFile(writeNamedFile(t, name, content), testSchema)
+		if err != nil {
+			t.Fatalf("ReadStructuredFile(%s): %v", name, err)
+		}
+		if len(values) != len(want) {
+			t.Errorf("%s values = %v, want %v", name, values, want)
+		}
+		for setting, value := range want {
+			if values[setting] != value {
+				t.Errorf("%s: %s = %q, want %q", name, setting, values[setting], value)
+			}
+		}
+	}
+
+	// The environment overrides the file, and keys that set nothing are
+	// problems without losing the rest of the file
+	path := writeNamedFile(t, "legal-rag.yml", "server:\n  port: 8081\n  prot: 8082\ncache:\n  ttl: {minutes: 10}\nGO_SERVER_PORT: 9000\n")
+	t.Setenv("RESPONSE_CACHE_TTL", "1h")
+	Load(map[string]string{"CONFIG_FILE": path}, nil, testSchema)
+	if got := Get("RESPONSE_CACHE_TTL"); got != "1h" {
+		t.Errorf("RESPONSE_CACHE_TTL = %q, want the environment's", got)
+	}
+	if got := Get("GO_SERVER_PORT"); got == "" {
+		t.Error("GO_SERVER_PORT unset, want the file's")
+	}
+	problems := Problems()
+	if len(problems) != 3 {
+		t.Fatalf("problems = %v, want the unknown key, the map and the setting set twice", problems)
+	}
+	for _, p := range problems {
+		if p.Setting != "CONFIG_FILE" {
+			t.Errorf("problem %v is not about CONFIG_FILE", p)
+		}
+	}
+
+	if _, err := ReadStructuredFile(writeNamedFile(t, "bad.yaml", "server: [\n"), testSchema); err == nil {
+		t.Error("ReadStructuredFile accepted invalid YAML")
+	}
+}
+
+func TestTypedSettings(t *testing.T) {
+	Load(nil, nil, nil)
+	t.Setenv("TEST_DURATION", "90s")
+	t.Setenv("TEST_BAD_DURATION", "soon")
+	t.Setenv("TEST_INT", "7")
+	t.Setenv("TEST_BIG_INT", "70")
+	t.Setenv("TEST_FLOAT", "0.5")
+	t.Setenv("TEST_BOOL", "true")
+	t.Setenv("TEST_BAD_BOOL", "maybe")
+	t.Setenv("TEST_LIST", " a, ,b ,")
+
+	if got := Duration("TEST_DURATION", time.Second); got != 90*time.Second {
+		t.Errorf("Duration = %v, want 90s", got)
+	}
+	if got := Duration("TEST_BAD_DURATION", time.Second); got != time.Second {
+		t.Errorf("Duration of an invalid value = %v, want the default", got)
+	}
+	if got := IntInRange("TEST_INT", 1, 1, 10); got != 7 {
+		t.Errorf("IntInRange = %d, want 7", got)
+	}
+	if got := IntInRange("TEST_BIG_INT", 1, 1, 10); got != 1 {
+		t.Errorf("IntInRange out of range = %d, want the default", got)
+	}
+	if got := FloatInRange("TEST_FLOAT", 0, 0, 1); got != 0.5 {
+		t.Errorf("FloatInRange = %g, want 0.5", got)
+	}
+	if got := Bool("TEST_BOOL", false); !got {
+		t.Error("Bool = false, want true")
+	}
+	if got := Bool("TEST_BAD_BOOL", false); got {
+		t.Error("Bool of an invalid value = true, want the default")
+	}
+	if got := String("TEST_UNSET", "def"); got != "def" {
+		t.Errorf("String = %q, want the default", got)
+	}
+	if got := List("TEST_LIST"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("List = %q, want [a b]", got)
+	}
+
+	problems := Problems()
+	if len(problems) != 3 {
+		t.Fatalf("problems = %v, want the invalid duration, int and bool", problems)
+	}
+	for i, setting := range []string{"TEST_BAD_DURATION", "TEST_BIG_INT", "TEST_BAD_BOOL"} {
+		if problems[i].Setting != setting {
+			t.Errorf("problem %d is for %s, want %s", i, problems[i].Setting, setting)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeFile(t, "REQUEST_TIMEOUT=60s\n")
+	Load(map[string]string{"CONFIG_FILE": path}, nil, nil)
+	saved := Save()
+
+	if err := os.WriteFile(path, []byte("REQUEST_TIMEOUT=90s\nnot a setting\n"), 0o600); err != nil {
+		t.Fatalf("rewrite config file: %v", err)
+	}
+	Reload()
+	if problems := Problems(); len(problems) != 1 || problems[0].Setting != "CONFIG_FILE" {
+		t.Errorf("problems = %v, want the invalid line", problems)
+	}
+	Restore(saved)
+	if got := Get("REQUEST_TIMEOUT"); got != "60s" || len(Problems()) != 0 {
+		t.Errorf("restored REQUEST_TIMEOUT = %q with problems %v, want 60s and none", got, Problems())
+	}
+
+	if err := os.WriteFile(path, []byte("REQUEST_TIMEOUT=90s\n"), 0o600); err != nil {
+		t.Fatalf("rewrite config file: %v", err)
+	}
+	Reload()
+	if got := Get("REQUEST_TIMEOUT"); got != "90s" || FilePath() != path {
+		t.Errorf("reloaded REQUEST_TIMEOUT = %q from %q, want 90s from %s", got, FilePath(), path)
+	}
+}
@@ -0,0 +1,262 @@
+// Package middleware provides generic Gin middleware used by the server,
+// which can also be added to routes of services embedding it
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log levels of request logging: requests are logged at info, client errors
+// at warn and server errors at error
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+var LogLevels = []string{LogDebug, LogInfo, LogWarn, LogError}
+
+var slogLevels = map[string]slog.Level{
+	LogDebug: slog.LevelDebug,
+	LogInfo:  slog.LevelInfo,
+	LogWarn:  slog.LevelWarn,
+	LogError: slog.LevelError,
+}
+
+const logAttrsKey = "log_attrs"
+
+// AddLogAttrs adds attributes to the log line of the request
+func AddLogAttrs(c *gin.Context, attrs ...slog.Attr) {
+	existing, _ := c.Get(logAttrsKey)
+	current, _ := existing.([]slog.Attr)
+	c.Set(logAttrsKey, append(current, attrs...))
+}
+
+// LogSettings are the request logging level and sample rate, which can be
+// changed while the server runs
+type LogSettings struct {
+	level  atomic.Int32
+	sample atomic.Uint64
+}
+
+// NewLogSettings returns the settings for level, logging the given share
+// of the requests below warn
+func NewLogSettings(level string, sampleRate float64) *LogSettings {
+	s := &LogSettings{}
+	if s.SetLevel(level) != nil {
+		s.SetLevel(LogInfo)
+	}
+	if s.SetSampleRate(sampleRate) != nil {
+		s.SetSampleRate(1)
+	}
+	return s
+}
+
+func (s *LogSettings) Level() string {
+	return LogLevels[s.level.Load()]
+}
+
+func (s *LogSettings) SetLevel(level string) error {
+	i := slices.Index(LogLevels, level)
+	if i < 0 {
+		return fmt.Errorf("unknown log level %q (available: %v)", level, LogLevels)
+	}
+	s.level.Store(int32(i))
+	return nil
+}
+
+// SampleRate is the share of debug and info request logs that are written;
+// warnings and errors are always written
+func (s *LogSettings) SampleRate() float64 {
+	return math.Float64frombits(s.sample.Load())
+}
+
+func (s *LogSettings) SetSampleRate(rate float64) error {
+	if !(rate >= 0 && rate <= 1) {
+		return fmt.Errorf("sample rate must be between 0 and 1, got %v", rate)
+	}
+	s.sample.Store(math.Float64bits(rate))
+	return nil
+}
+
+// Logging logs every request at or above level
+func Logging(level string) gin.HandlerFunc {
+	return LoggingWith(NewLogSettings(level, 1))
+}
+
+// LoggingWith logs requests at or above the level of settings, sampling
+// those below warn
+func LoggingWith(settings *LogSettings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		method := c.Request.Method
+
+		c.Next()
+
+		duration := time.Since(start)
+		statusCode := c.Writer.Status()
+
+		severity := LogInfo
+		switch {
+		case statusCode >= http.StatusInternalServerError:
+			severity = LogError
+		case statusCode >= http.StatusBadRequest:
+			severity = LogWarn
+		}
+		level := settings.Level()
+		if slices.Index(LogLevels, severity) < slices.Index(LogLevels, level) {
+			return
+		}
+		if severity == LogInfo {
+			if rate := settings.SampleRate(); rate < 1 && mathrand.Float64() >= rate {
+				return
+			}
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.String("path", path),
+			slog.Int("status", statusCode),
+			slog.Float64("latency_ms", float64(duration.Microseconds())/1000),
+		}
+		if level == LogDebug {
+			attrs = append(attrs, slog.String("client_ip", c.ClientIP()), slog.String("query", c.Request.URL.RawQuery))
+		}
+		if extra, ok := c.Get(logAttrsKey); ok {
+			attrs = append(attrs, extra.([]slog.Attr)...)
+		}
+		slog.LogAttrs(c.Request.Context(), slogLevels[severity], "request", attrs...)
+	}
+}
+
+// RequestIDHeader carries the ID of a request, from the client or
+// generated, on the response
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// requestIDPattern bounds the request IDs accepted from clients, which end
+// up in logs and in the headers of engine requests
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID takes the request ID from the X-Request-ID header, or
+// generates one when it is missing or malformed, and returns it in the
+// same header and on the request's log line
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		AddLogAttrs(c, slog.String("request_id", id))
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID RequestID gave the request
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// CORSOff disables CORS headers, for deployments behind a same-origin proxy
+const CORSOff = "off"
+
+// CORSConfig is the cross-origin policy of the API
+type CORSConfig struct {
+	// AllowOrigins lists the origins browsers may call the API from, "*"
+	// for any; no origins, or CORSOff, sends no CORS headers
+	AllowOrigins []string
+	// AllowCredentials lets browsers send cookies and HTTP authentication
+	// with cross-origin requests. It is ignored for "*", which browsers
+	// refuse to combine with credentials.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response, 0 for
+	// their default
+	MaxAge time.Duration
+}
+
+// Off reports whether no CORS headers are sent
+func (c CORSConfig) Off() bool {
+	return len(c.AllowOrigins) == 0 || slices.Contains(c.AllowOrigins, CORSOff)
+}
+
+// AllowsAny reports whether any origin is allowed
+func (c CORSConfig) AllowsAny() bool {
+	return !c.Off() && slices.Contains(c.AllowOrigins, "*")
+}
+
+// AllowsOrigin reports whether a browser page from origin may call the API
+func (c CORSConfig) AllowsOrigin(origin string) bool {
+	return c.AllowsAny() || (!c.Off() && slices.Contains(c.AllowOrigins, origin))
+}
+
+// CORS allows cross-origin requests from origin, "*" for any
+func CORS(origin string) gin.HandlerFunc {
+	return CORSWithConfig(CORSConfig{AllowOrigins: []string{origin}})
+}
+
+// CORSWithConfig allows cross-origin requests following config. A single
+// origin is always sent; with several the request's Origin is echoed when
+// allowed, and requests from other origins get no CORS headers. Preflight
+// requests are answered 204 without running the route.
+func CORSWithConfig(config CORSConfig) gin.HandlerFunc {
+	maxAge := ""
+	if config.MaxAge > 0 {
+		maxAge = fmt.Sprint(int(config.MaxAge.Seconds()))
+	}
+	return func(c *gin.Context) {
+		if config.Off() {
+			c.Next()
+			return
+		}
+		allowOrigin := ""
+		switch origin := c.GetHeader("Origin"); {
+		case config.AllowsAny():
+			allowOrigin = "*"
+		case len(config.AllowOrigins) == 1:
+			allowOrigin = config.AllowOrigins[0]
+		case config.AllowsOrigin(origin):
+			allowOrigin = origin
+		}
+		if allowOrigin != "*" {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if allowOrigin != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			if config.AllowCredentials && allowOrigin != "*" {
+				c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Sandbox, X-Admin-Token, X-API-Key, X-Tenant-ID, X-User-ID, X-Request-ID")
+			c.Writer.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
+			if maxAge != "" && c.Request.Method == "OPTIONS" {
+				c.Writer.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func newRouter(handlers ...gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.Use(handlers...)
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/bad", func(c *gin.Context) { c.Status(http.StatusBadRequest) })
+	return router
+}
+
+func serve(router http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		origin     string
+		wantOrigin string
+		wantVary   string
+	}{
+		{"*", "*", ""},
+		{"https://app.example.vn", "https://app.example.vn", "Origin"},
+		{CORSOff, "", ""},
+	}
+	for _, tt := range tests {
+		rec := serve(newRouter(CORS(tt.origin)), http.MethodGet, "/ok")
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("CORS(%q): Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.wantOrigin)
+		}
+		if got := rec.Header().Get("Vary"); got != tt.wantVary {
+			t.Errorf("CORS(%q): Vary = %q, want %q", tt.origin, got, tt.wantVary)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	rec := serve(newRouter(CORS("*")), http.MethodOptions, "/ok")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want 204", rec.Code)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if allowed := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(allowed, method) {
+			t.Errorf("Access-Control-Allow-Methods = %q, want %s", allowed, method)
+		}
+	}
+}
+
+func TestCORSAllowlist(t *testing.T) {
+	router := newRouter(CORSWithConfig(CORSConfig{
+		AllowOrigins:     []string{"https://app.example.vn", "https://admin.example.vn"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	tests := []struct {
+		method      string
+		origin      string
+		wantOrigin  string
+		wantMaxAge  string
+		credentials bool
+	}{
+		{http.MethodGet, "https://admin.example.vn", "https://admin.example.vn", "", true},
+		{http.MethodOptions, "https://app.example.vn", "https://app.example.vn", "600", true},
+		{http.MethodGet, "https://evil.example.com", "", "", false},
+		{http.MethodGet, "", "", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/ok", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s from %q: Access-Control-Allow-Origin = %q, want %q", tt.method, tt.origin, got, tt.wantOrigin)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+			t.Errorf("%s from %q: credentials allowed = %v, want %v", tt.method, tt.origin, got, tt.credentials)
+		}
+		if got := rec.Header().Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+			t.Errorf("%s from %q: Access-Control-Max-Age = %q, want %q", tt.method, tt.origin, got, tt.wantMaxAge)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s from %q: Vary = %q, want Origin", tt.method, tt.origin, got)
+		}
+	}
+
+	// Credentials are never allowed for any origin
+	rec := serve(newRouter(CORSWithConfig(CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true})), http.MethodGet, "/ok")
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("credentials with *: Access-Control-Allow-Credentials = %q, want none", got)
+	}
+}
+
+func TestLoggingLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		level   string
+		path    string
+		logged  bool
+		details bool
+	}{
+		{LogInfo, "/ok", true, false},
+		{LogWarn, "/ok", false, false},
+		{LogWarn, "/bad", true, false},
+		{LogError, "/bad", false, false},
+		{LogDebug, "/ok?page=2", true, true},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		serve(newRouter(Logging(tt.level)), http.MethodGet, tt.path)
+		out := buf.String()
+		if logged := out != ""; logged != tt.logged {
+			t.Errorf("Logging(%q) GET %s: logged = %v, want %v", tt.level, tt.path, logged, tt.logged)
+		}
+		if details := strings.Contains(out, "client_ip="); details != tt.details {
+			t.Errorf("Logging(%q) GET %s: client details = %v, want %v", tt.level, tt.path, details, tt.details)
+		}
+	}
+}
+
+func TestLoggingSettings(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	settings := NewLogSettings(LogInfo, 0)
+	router := newRouter(LoggingWith(settings))
+	serve(router, http.MethodGet, "/ok")
+	serve(router, http.MethodGet, "/bad")
+	if out := buf.String(); strings.Contains(out, "/ok") || !strings.Contains(out, "/bad") {
+		t.Errorf("log = %q, want successful requests sampled out and client errors kept", out)
+	}
+
+	buf.Reset()
+	if err := settings.SetLevel(LogError); err != nil {
+		t.Fatalf("SetLevel: %v", err)
+	}
+	serve(router, http.MethodGet, "/bad")
+	if buf.Len() != 0 {
+		t.Errorf("log = %q after raising the level to error, want nothing", buf.String())
+	}
+
+	if settings.SetLevel("verbose") == nil || settings.SetSampleRate(1.5) == nil {
+		t.Error("invalid settings were accepted")
+	}
+	if settings.Level() != LogError || settings.SampleRate() != 0 {
+		t.Errorf("settings = %s %v, want them unchanged by invalid values", settings.Level(), settings.SampleRate())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	router := newRouter(Logging(LogInfo), RequestID())
+
+	tests := []struct {
+		header string
+		kept   bool
+	}{
+		{"", false},
+		{"req-42.a:b", true},
+		{"bad id\r\nX-Injected: 1", false},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		req.Header.Set(RequestIDHeader, tt.header)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(RequestIDHeader)
+		if kept := id == tt.header; kept != tt.kept || id == "" {
+			t.Errorf("X-Request-ID %q: response ID = %q, want kept = %v", tt.header, id, tt.kept)
+		}
+		if !strings.Contains(buf.String(), "request_id="+id) {
+			t.Errorf("X-Request-ID %q: log = %q, want the request ID", tt.header, buf.String())
+		}
+	}
+}
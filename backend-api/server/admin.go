@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminMiddleware gates admin routes behind the ADMIN_TOKEN shared secret,
+// sent either as "Authorization: Bearer <token>" or "X-Admin-Token: <token>".
+// Admin routes are disabled entirely when no token is configured.
+func adminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			abortWithError(c, ErrCodeForbidden, "Admin API is disabled; set ADMIN_TOKEN to enable it")
+			return
+		}
+
+		provided := c.GetHeader("X-Admin-Token")
+		if auth := c.GetHeader("Authorization"); provided == "" && strings.HasPrefix(auth, "Bearer ") {
+			provided = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if provided == "" {
+			abortWithError(c, ErrCodeUnauthorized, "Missing admin token")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			abortWithError(c, ErrCodeUnauthorized, "Invalid admin token")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Heatmap counts queries by day of week (Monday first) and hour of day
+type Heatmap [7][24]int
+
+func (h *Heatmap) add(t time.Time) {
+	day := (int(t.Weekday()) + 6) % 7
+	h[day][t.Hour()]++
+}
+
+// TenantLoad is the query volume of one tenant; queries made without a
+// tenant have an empty tenant_id
+type TenantLoad struct {
+	TenantID string  `json:"tenant_id"`
+	Total    int     `json:"total"`
+	Heatmap  Heatmap `json:"heatmap"`
+}
+
+// ConcurrencyPeaks reports the most queries in flight at the same time
+type ConcurrencyPeaks struct {
+	Peak   int        `json:"peak"`
+	PeakAt *time.Time `json:"peak_at,omitempty"`
+
+	// ByHour is the peak reached in each hour of the day
+	ByHour [24]int `json:"by_hour"`
+}
+
+// LoadReport is returned by the load analytics endpoint
+type LoadReport struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Timezone    string           `json:"timezone"`
+	Total       int              `json:"total"`
+	Heatmap     Heatmap          `json:"heatmap"`
+	Tenants     []TenantLoad     `json:"tenants"`
+	Concurrency ConcurrencyPeaks `json:"concurrency"`
+}
+
+// buildLoadReport aggregates answered queries into heatmaps in loc. A query
+// is in flight from its start (created_at minus duration) to created_at.
+func buildLoadReport(entries []HistoryEntry, from, to time.Time, loc *time.Location) LoadReport {
+	report := LoadReport{From: from, To: to, Timezone: loc.String(), Tenants: []TenantLoad{}}
+	byTenant := make(map[string]*TenantLoad)
+
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	var events []event
+	for _, e := range entries {
+		if e.CreatedAt.Before(from) || e.CreatedAt.After(to) {
+			continue
+		}
+		start := e.CreatedAt.Add(-time.Duration(e.DurationMs) * time.Millisecond).In(loc)
+		report.Total++
+		report.Heatmap.add(start)
+
+		tenant, ok := byTenant[e.TenantID]
+		if !ok {
+			tenant = &TenantLoad{TenantID: e.TenantID}
+			byTenant[e.TenantID] = tenant
+		}
+		tenant.Total++
+		tenant.Heatmap.add(start)
+
+		events = append(events, event{start, 1}, event{e.CreatedAt.In(loc), -1})
+	}
+
+	for _, tenant := range byTenant {
+		report.Tenants = append(report.Tenants, *tenant)
+	}
+	sort.Slice(report.Tenants, func(i, j int) bool {
+		return report.Tenants[i].Total > report.Tenants[j].Total
+	})
+
+	// Sweep the start and end events; ends sort before starts at the same
+	// instant so back-to-back queries don't count as overlapping
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+	inFlight := 0
+	for _, ev := range events {
+		inFlight += ev.delta
+		if ev.delta < 0 {
+			continue
+		}
+		hour := ev.at.Hour()
+		report.Concurrency.ByHour[hour] = max(report.Concurrency.ByHour[hour], inFlight)
+		if inFlight > report.Concurrency.Peak {
+			at := ev.at
+			report.Concurrency.Peak = inFlight
+			report.Concurrency.PeakAt = &at
+		}
+	}
+	return report
+}
+
+// Handlers
+
+func loadAnalyticsHandler(history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days := 28
+		if value := c.Query("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 365 {
+				abortWithInvalidParam(c, "days", "days must be an integer between 1 and 365")
+				return
+			}
+			days = parsed
+		}
+
+		loc := time.UTC
+		if tz := c.Query("tz"); tz != "" {
+			parsed, err := time.LoadLocation(tz)
+			if err != nil {
+				abortWithInvalidParam(c, "tz", fmt.Sprintf("Unknown time zone %q", tz))
+				return
+			}
+			loc = parsed
+		}
+
+		to := time.Now().UTC()
+		from := to.AddDate(0, 0, -days)
+		entries := history.Since(from)
+		if tenantID, ok := c.GetQuery("tenant"); ok {
+			filtered := entries[:0]
+			for _, e := range entries {
+				if e.TenantID == tenantID {
+					filtered = append(filtered, e)
+				}
+			}
+			entries = filtered
+		}
+
+		c.JSON(http.StatusOK, buildLoadReport(entries, from, to, loc))
+	}
+}
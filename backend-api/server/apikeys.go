@@ -0,0 +1,334 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKey identifies a client of the API. The key itself is shown once when
+// it is created; only its hash is stored.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	TenantID   string     `json:"tenant_id,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// storedAPIKey is an API key as persisted, with the hash of its secret
+type storedAPIKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+const (
+	// apiKeySecretPrefix starts every key, so leaked keys are easy to find
+	apiKeySecretPrefix = "lrk_"
+
+	// apiKeyUsageInterval is how often the last use of a key is persisted
+	apiKeyUsageInterval = time.Minute
+)
+
+var (
+	errAPIKeyNotFound = errors.New("API key not found")
+	errAPIKeyInvalid  = errors.New("invalid or expired API key")
+)
+
+// APIKeyStore keeps API keys in memory, persisted to a JSON file when a path
+// is configured
+type APIKeyStore struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*storedAPIKey
+	byHash map[string]*storedAPIKey
+}
+
+func NewAPIKeyStore(path string) (*APIKeyStore, error) {
+	store := &APIKeyStore{
+		path:   path,
+		keys:   make(map[string]*storedAPIKey),
+		byHash: make(map[string]*storedAPIKey),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var keys []*storedAPIKey
+	if _, err := readJSONFile(path, &keys); err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	for _, k := range keys {
+		store.keys[k.ID] = k
+		store.byHash[k.Hash] = k
+	}
+	return store, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create stores a new key and returns it with its secret
+func (s *APIKeyStore) Create(key APIKey) (APIKey, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := apiKeySecretPrefix + randomHex(24)
+	key.ID = "k_" + randomHex(8)
+	key.Prefix = secret[:len(apiKeySecretPrefix)+6]
+	key.CreatedAt = time.Now().UTC()
+	key.LastUsedAt = nil
+	stored := &storedAPIKey{APIKey: key, Hash: hashAPIKey(secret)}
+	s.keys[key.ID] = stored
+	s.byHash[stored.Hash] = stored
+	return key, secret, s.saveLocked()
+}
+
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Revoke deletes a key; requests using it fail from then on
+func (s *APIKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return errAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	delete(s.byHash, k.Hash)
+	return s.saveLocked()
+}
+
+// Authenticate returns the unexpired key with the given secret and records
+// its use
+func (s *APIKeyStore) Authenticate(secret string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.byHash[hashAPIKey(secret)]
+	now := time.Now().UTC()
+	if !ok || (k.ExpiresAt != nil && now.After(*k.ExpiresAt)) {
+		return APIKey{}, errAPIKeyInvalid
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyUsageInterval {
+		k.LastUsedAt = &now
+		if err := s.saveLocked(); err != nil {
+			slog.Error("Failed to record use of API key", "api_key", k.ID, "error", err)
+		}
+	}
+	return k.APIKey, nil
+}
+
+func (s *APIKeyStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*storedAPIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return writeJSONFile(s.path, keys)
+}
+
+// publicRoutes are served without an API key: health probes, metrics, the
+// error catalog, what clients of share links and signatures need, the
+// public FAQ pages, and the engine callbacks and feeds, which are signed or
+// need a feed token instead
+var publicRoutes = map[string]bool{
+	"/":                        true,
+	"/health":                  true,
+	"/ready":                   true,
+	"/healthz":                 true,
+	"/readyz":                  true,
+	"/metrics":                 true,
+	"/api/errors":              true,
+	"/api/status":              true,
+	"/api/shared/:id":          true,
+	"/api/signing-key":         true,
+	"/api/signatures/verify":   true,
+	"/faq/:tenant":             true,
+	"/faq/:tenant/:slug":       true,
+	"/sitemap.xml":             true,
+	"/robots.txt":              true,
+	engineCallbackPath + ":id": true,
+	"/api/feeds/corpus.rss":    true,
+	"/api/feeds/corpus.atom":   true,
+	"/api/feeds/quickref.rss":  true,
+	"/api/feeds/quickref.atom": true,
+}
+
+const apiKeyContextKey = "api_key"
+
+// apiKeyMiddleware checks the X-API-Key header. When required is set,
+// every route but the public ones and the admin API, which has its own
+// token, needs a valid key. A key bound to a tenant acts for that tenant:
+// X-Tenant-ID defaults to it and may not name another tenant. Likewise a
+// key bound to a user sets X-User-ID.
+func apiKeyMiddleware(store *APIKeyStore, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		provided := c.GetHeader("X-API-Key")
+		// Browsers cannot set headers on WebSocket connections
+		if provided == "" && route == "/ws/chat" {
+			provided = c.Query("api_key")
+		}
+		if provided == "" {
+			if required && !publicRoutes[route] && !strings.HasPrefix(route, "/admin/") {
+				abortWithError(c, ErrCodeUnauthorized, "Missing API key; send it in the X-API-Key header")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		key, err := store.Authenticate(provided)
+		if err != nil {
+			abortWithError(c, ErrCodeUnauthorized, "Invalid or expired API key")
+			return
+		}
+		if key.TenantID != "" {
+			if id := c.GetHeader("X-Tenant-ID"); id != "" && id != key.TenantID {
+				abortWithError(c, ErrCodeForbidden, fmt.Sprintf("API key %s may not act for tenant %q", key.ID, id))
+				return
+			}
+			c.Request.Header.Set("X-Tenant-ID", key.TenantID)
+		}
+		if key.UserID != "" {
+			if id := callerUser(c); id != "" && id != key.UserID {
+				abortWithError(c, ErrCodeForbidden, fmt.Sprintf("API key %s may not act for user %q", key.ID, id))
+				return
+			}
+			c.Request.Header.Set("X-User-ID", key.UserID)
+		}
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// authenticatedUser returns the user the caller's API key is bound to, or
+// "" when it has no such key. Unlike callerUser it cannot be claimed by a
+// header, so access control and approvals rely on it.
+func authenticatedUser(c *gin.Context) string {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return v.(APIKey).UserID
+	}
+	return ""
+}
+
+// authenticatedTenant returns the tenant the caller's API key is bound to,
+// if any. Unlike callerTenant it cannot be claimed by the X-Tenant-ID
+// header, so access control relies on it.
+func authenticatedTenant(c *gin.Context) (Tenant, bool) {
+	if v, ok := c.Get(apiKeyContextKey); !ok || v.(APIKey).TenantID == "" {
+		return Tenant{}, false
+	}
+	return callerTenant(c)
+}
+
+// requireAuthenticatedUser returns authenticatedUser, or aborts with 403
+// when the caller has no API key bound to a user. action names what was
+// refused.
+func requireAuthenticatedUser(c *gin.Context, action string) (string, bool) {
+	user := authenticatedUser(c)
+	if user == "" {
+		abortWithError(c, ErrCodeForbidden, action+" needs an API key bound to a user")
+		return "", false
+	}
+	return user, true
+}
+
+// Handlers
+
+// CreateAPIKeyRequest is the body of POST /admin/api-keys
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	TenantID  string     `json:"tenant_id"`
+	UserID    string     `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse returns the new key's secret, which is never shown
+// again
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func listAPIKeysHandler(store *APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"api_keys": store.List()})
+	}
+}
+
+func createAPIKeyHandler(store *APIKeyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if req.TenantID != "" {
+			if _, ok := tenants.Get(req.TenantID); !ok {
+				abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", req.TenantID))
+				return
+			}
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			abortWithError(c, ErrCodeInvalidRequest, "expires_at must be in the future")
+			return
+		}
+
+		key, secret, err := store.Create(APIKey{
+			Name:      strings.TrimSpace(req.Name),
+			TenantID:  req.TenantID,
+			UserID:    strings.TrimSpace(req.UserID),
+			ExpiresAt: req.ExpiresAt,
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save API key", "api_key", key.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save API key")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Created API key", "api_key", key.ID, "name", key.Name)
+		c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: key, Key: secret})
+	}
+}
+
+func revokeAPIKeyHandler(store *APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.Revoke(c.Param("id"))
+		if errors.Is(err, errAPIKeyNotFound) {
+			abortWithError(c, ErrCodeAPIKeyNotFound, fmt.Sprintf("Unknown API key %q", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to revoke API key", "api_key", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to revoke API key")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Revoked API key", "api_key", c.Param("id"))
+		c.Status(http.StatusNoContent)
+	}
+}
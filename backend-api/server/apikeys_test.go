@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	store, err := NewAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, secret, err := store.Create(APIKey{Name: "portal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, key.Prefix) || len(secret) < 40 {
+		t.Errorf("secret %q does not start with prefix %q", secret, key.Prefix)
+	}
+
+	// Only the hash is persisted, and keys survive a restart
+	reloaded, err := NewAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reloaded.Authenticate(secret); err != nil || got.ID != key.ID || got.LastUsedAt == nil {
+		t.Errorf("Authenticate() after reload = %+v, %v, want %s with its last use", got, err, key.ID)
+	}
+	if _, err := reloaded.Authenticate(secret + "x"); err == nil {
+		t.Error("Authenticate() with a wrong secret = nil, want an error")
+	}
+
+	if err := reloaded.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Authenticate(secret); err == nil {
+		t.Error("Authenticate() after revoke = nil, want an error")
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("REQUIRE_API_KEY", "true")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 180 ngày.", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	do := func(method, path, apiKey string, headers map[string]string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	admin := map[string]string{"X-Admin-Token": "secret"}
+	query := LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày?"}
+
+	if rec := do(http.MethodGet, "/health", "", nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("health without a key = %d, want 200", rec.Code)
+	}
+	if code := decodeError(t, do(http.MethodPost, "/api/legal-query", "", nil, query)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("query without a key = %s, want %s", code, ErrCodeUnauthorized)
+	}
+	if code := decodeError(t, do(http.MethodPost, "/api/legal-query", "lrk_unknown", nil, query)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("query with an unknown key = %s, want %s", code, ErrCodeUnauthorized)
+	}
+
+	if rec := do(http.MethodPost, "/admin/tenants", "", admin, map[string]string{"id": "acme", "name": "ACME"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodPost, "/admin/api-keys", "", admin, map[string]string{"name": "x", "tenant_id": "nope"})).Code; code != ErrCodeTenantNotFound {
+		t.Errorf("key for an unknown tenant = %s, want %s", code, ErrCodeTenantNotFound)
+	}
+	rec := do(http.MethodPost, "/admin/api-keys", "", admin, map[string]string{"name": "ACME portal", "tenant_id": "acme"})
+	var created CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated || created.Key == "" {
+		t.Fatalf("create API key = %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodPost, "/api/legal-query", created.Key, nil, query); rec.Code != http.StatusOK {
+		t.Fatalf("query with a key = %d %s", rec.Code, rec.Body.String())
+	}
+	// The key acts for its tenant only
+	if rec := do(http.MethodGet, "/api/history", created.Key, nil, nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "acme") {
+		t.Errorf("history with a tenant key = %d %s, want the tenant's query", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodGet, "/api/history", created.Key, map[string]string{"X-Tenant-ID": "other"}, nil)).Code; code != ErrCodeForbidden {
+		t.Errorf("tenant key for another tenant = %s, want %s", code, ErrCodeForbidden)
+	}
+
+	// A key bound to a user acts for that user only
+	rec = do(http.MethodPost, "/admin/api-keys", "", admin, map[string]string{"name": "Minh", "user_id": "minh"})
+	var minh CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &minh); err != nil || minh.UserID != "minh" {
+		t.Fatalf("create user API key = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodGet, "/api/history", minh.Key, map[string]string{"X-User-ID": "lan"}, nil)).Code; code != ErrCodeForbidden {
+		t.Errorf("user key for another user = %s, want %s", code, ErrCodeForbidden)
+	}
+
+	rec = do(http.MethodGet, "/admin/api-keys", "", admin, nil)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), created.Key) || strings.Contains(rec.Body.String(), "hash") {
+		t.Errorf("list API keys = %d %s, want the keys without secrets", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, "/admin/api-keys/"+created.ID, "", admin, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke API key = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodDelete, "/admin/api-keys/"+created.ID, "", admin, nil)).Code; code != ErrCodeAPIKeyNotFound {
+		t.Errorf("revoke twice = %s, want %s", code, ErrCodeAPIKeyNotFound)
+	}
+	if code := decodeError(t, do(http.MethodPost, "/api/legal-query", created.Key, nil, query)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("query with a revoked key = %s, want %s", code, ErrCodeUnauthorized)
+	}
+}
+
+// userKey creates an API key bound to user through the admin API of a
+// server started with ADMIN_TOKEN=admin-token
+func userKey(t *testing.T, h http.Handler, user string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(`{"name": "`+user+`", "user_id": "`+user+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Token", "admin-token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var created CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.UserID != user {
+		t.Fatalf("create API key of %s = %d %s", user, rec.Code, rec.Body.String())
+	}
+	return created.Key
+}
+
+// tenantKey creates a tenant and an API key acting for it through the
+// admin API, which needs ADMIN_TOKEN=admin-token, and returns the key
+func tenantKey(t *testing.T, h http.Handler, tenant string) string {
+	t.Helper()
+	var created CreateAPIKeyResponse
+	for _, call := range []struct{ path, body string }{
+		{"/admin/tenants", `{"id": "` + tenant + `", "name": "` + tenant + `"}`},
+		{"/admin/api-keys", `{"name": "` + tenant + `", "tenant_id": "` + tenant + `"}`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, call.path, strings.NewReader(call.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "admin-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s for %s = %d %s", call.path, tenant, rec.Code, rec.Body.String())
+		}
+		json.Unmarshal(rec.Body.Bytes(), &created)
+	}
+	return created.Key
+}
+
+// doWithKey is doAs for a caller identified by an API key
+func doWithKey(t *testing.T, h http.Handler, key, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf strings.Builder
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
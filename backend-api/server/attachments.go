@@ -0,0 +1,474 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// AttachmentInput references context for a single query: either inline text
+// or the ID of a previously uploaded attachment
+type AttachmentInput struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Attachment statuses. Text read by OCR must be confirmed by the user before
+// it can be used as query context.
+const (
+	AttachmentReady               = "ready"
+	AttachmentPendingConfirmation = "pending_confirmation"
+)
+
+// Attachment is an uploaded document kept in memory until it expires
+type Attachment struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	Status       string    `json:"status"`
+	DocumentType string    `json:"document_type,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	// ExtractedText is only returned for attachments awaiting confirmation
+	ExtractedText string `json:"extracted_text,omitempty"`
+
+	tenantID string
+	text     string
+}
+
+// AttachmentLimits bounds the attachments accepted with a query
+type AttachmentLimits struct {
+	MaxCount      int
+	MaxBytes      int
+	MaxImageBytes int
+	TTL           time.Duration
+}
+
+var (
+	errAttachmentNotFound    = errors.New("attachment not found")
+	errAttachmentUnconfirmed = errors.New("attachment text has not been confirmed")
+)
+
+// AttachmentStore keeps uploaded attachments in memory for a limited time
+type AttachmentStore struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	attachments map[string]*Attachment
+}
+
+func NewAttachmentStore(ttl time.Duration) *AttachmentStore {
+	return &AttachmentStore{
+		ttl:         ttl,
+		attachments: make(map[string]*Attachment),
+	}
+}
+
+func (s *AttachmentStore) Put(tenantID, name, contentType, text string) *Attachment {
+	return s.put(&Attachment{
+		Name:        name,
+		ContentType: contentType,
+		Status:      AttachmentReady,
+		tenantID:    tenantID,
+		text:        text,
+	})
+}
+
+// PutForConfirmation stores OCR text that the user must confirm or correct
+// before it can be used
+func (s *AttachmentStore) PutForConfirmation(tenantID, name, contentType, text, documentType string) *Attachment {
+	return s.put(&Attachment{
+		Name:         name,
+		ContentType:  contentType,
+		Status:       AttachmentPendingConfirmation,
+		DocumentType: documentType,
+		tenantID:     tenantID,
+		text:         text,
+	})
+}
+
+func (s *AttachmentStore) put(a *Attachment) *Attachment {
+	now := time.Now().UTC()
+	a.ID = "att_" + randomHex(12)
+	a.Size = len(a.text)
+	a.CreatedAt = now
+	a.ExpiresAt = now.Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	s.attachments[a.ID] = a
+	return a
+}
+
+// Confirm marks an attachment awaiting confirmation as ready, replacing its
+// text when the user supplied a correction
+func (s *AttachmentStore) Confirm(id, tenantID, text string) (*Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[id]
+	if !ok || a.tenantID != tenantID || time.Now().After(a.ExpiresAt) {
+		return nil, errAttachmentNotFound
+	}
+
+	// Replace rather than mutate; readers may hold the previous value
+	confirmed := *a
+	confirmed.Status = AttachmentReady
+	if text != "" {
+		confirmed.text = text
+		confirmed.Size = len(text)
+	}
+	s.attachments[id] = &confirmed
+	return &confirmed, nil
+}
+
+// Get returns an unexpired attachment owned by tenantID
+func (s *AttachmentStore) Get(id, tenantID string) (*Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[id]
+	if !ok || a.tenantID != tenantID || time.Now().After(a.ExpiresAt) {
+		return nil, errAttachmentNotFound
+	}
+	return a, nil
+}
+
+func (s *AttachmentStore) Delete(id, tenantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attachments[id]
+	if !ok || a.tenantID != tenantID {
+		return errAttachmentNotFound
+	}
+	delete(s.attachments, id)
+	return nil
+}
+
+func (s *AttachmentStore) sweepLocked(now time.Time) {
+	for id, a := range s.attachments {
+		if now.After(a.ExpiresAt) {
+			delete(s.attachments, id)
+		}
+	}
+}
+
+// validateAttachments checks count, shape and inline size of attachments
+func validateAttachments(inputs []AttachmentInput, limits AttachmentLimits) []Violation {
+	var violations []Violation
+	if len(inputs) > limits.MaxCount {
+		violations = append(violations, Violation{
+			Field:   "attachments",
+			Code:    ViolationOutOfRange,
+			Message: fmt.Sprintf("at most %d attachments are allowed per query", limits.MaxCount),
+		})
+	}
+
+	for i, in := range inputs {
+		field := fmt.Sprintf("attachments[%d]", i)
+		switch {
+		case in.ID == "" && in.Content == "":
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationRequired,
+				Message: fmt.Sprintf("%s must have either an id or content", field),
+			})
+		case in.ID != "" && in.Content != "":
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationInvalidType,
+				Message: fmt.Sprintf("%s must have either an id or content, not both", field),
+			})
+		case len(in.Content) > limits.MaxBytes:
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("%s content exceeds %d bytes", field, limits.MaxBytes),
+			})
+		case in.Content != "" && !utf8.ValidString(in.Content):
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationInvalidType,
+				Message: fmt.Sprintf("%s content must be UTF-8 text", field),
+			})
+		}
+	}
+	return violations
+}
+
+// resolveAttachments turns validated inputs into context documents, loading
+// uploaded attachments from the store
+func resolveAttachments(inputs []AttachmentInput, store *AttachmentStore, tenantID string) ([]engine.ContextDocument, error) {
+	docs := make([]engine.ContextDocument, 0, len(inputs))
+	for i, in := range inputs {
+		if in.ID == "" {
+			name := in.Name
+			if name == "" {
+				name = fmt.Sprintf("attachment-%d", i+1)
+			}
+			docs = append(docs, engine.ContextDocument{Name: name, Text: in.Content, Source: "inline"})
+			continue
+		}
+
+		a, err := store.Get(in.ID, tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q: %w", in.ID, err)
+		}
+		if a.Status == AttachmentPendingConfirmation {
+			return nil, fmt.Errorf("attachment %q: %w; confirm it with POST /api/attachments/%s/confirm", in.ID, errAttachmentUnconfirmed, in.ID)
+		}
+		docs = append(docs, engine.ContextDocument{Name: a.Name, Text: a.text, Source: "upload"})
+	}
+	return docs, nil
+}
+
+// extractText returns the plain text of an uploaded file. Plain text,
+// Markdown and DOCX are supported.
+func extractText(name, contentType string, data []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case ext == ".docx" || contentType == "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return extractDocxText(data)
+	case ext == ".txt" || ext == ".md" || strings.HasPrefix(contentType, "text/"):
+		if !utf8.Valid(data) {
+			return "", errors.New("file is not valid UTF-8 text")
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("unsupported file type %q; upload .txt, .md or .docx", ext)
+}
+
+// extractDocxText reads the paragraphs of word/document.xml
+func extractDocxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open docx: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open document.xml: %w", err)
+		}
+		defer rc.Close()
+
+		var sb strings.Builder
+		dec := xml.NewDecoder(rc)
+		inText := false
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to parse document.xml: %w", err)
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				inText = t.Name.Local == "t"
+				if t.Name.Local == "tab" {
+					sb.WriteByte('\t')
+				}
+			case xml.EndElement:
+				inText = false
+				if t.Name.Local == "p" {
+					sb.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					sb.Write(t)
+				}
+			}
+		}
+		return strings.TrimSpace(sb.String()), nil
+	}
+	return "", errors.New("docx has no word/document.xml")
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Handlers
+
+// UploadAttachmentRequest is the JSON body of POST /api/attachments
+type UploadAttachmentRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Content string `json:"content" binding:"required"`
+}
+
+// ConfirmAttachmentRequest is the body of POST /api/attachments/:id/confirm.
+// Text replaces the OCR output when the user corrected it.
+type ConfirmAttachmentRequest struct {
+	Text string `json:"text"`
+}
+
+func uploadAttachmentHandler(store *AttachmentStore, limits AttachmentLimits, ocr OCREngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+
+		var name, contentType, text string
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			file, err := c.FormFile("file")
+			if err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Missing file: %v", err))
+				return
+			}
+			if isImageUpload(file.Filename, file.Header.Get("Content-Type")) {
+				uploadImage(c, store, limits, ocr, file)
+				return
+			}
+			if file.Size > int64(limits.MaxBytes)*4 {
+				// Binary formats are larger than their text; the extracted
+				// text is checked against the real limit below
+				abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("File exceeds %d bytes", limits.MaxBytes*4))
+				return
+			}
+			f, err := file.Open()
+			if err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+				return
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+				return
+			}
+
+			name, contentType = file.Filename, file.Header.Get("Content-Type")
+			text, err = extractText(name, contentType, data)
+			if err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, err.Error())
+				return
+			}
+		} else {
+			var req UploadAttachmentRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+				return
+			}
+			if !utf8.ValidString(req.Content) {
+				abortWithError(c, ErrCodeInvalidRequest, "content must be UTF-8 text")
+				return
+			}
+			name, contentType, text = req.Name, "text/plain", req.Content
+		}
+
+		if len(text) > limits.MaxBytes {
+			abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("Attachment text exceeds %d bytes", limits.MaxBytes))
+			return
+		}
+		if strings.TrimSpace(text) == "" {
+			abortWithError(c, ErrCodeInvalidRequest, "Attachment contains no text")
+			return
+		}
+
+		c.JSON(http.StatusCreated, store.Put(tenant.ID, name, contentType, text))
+	}
+}
+
+// uploadImage runs OCR on a photographed document and stores the text for
+// the user to confirm
+func uploadImage(c *gin.Context, store *AttachmentStore, limits AttachmentLimits, ocr OCREngine, file *multipart.FileHeader) {
+	if ocr == nil {
+		abortWithError(c, ErrCodeOCRUnavailable, "Image uploads are disabled because OCR is not available")
+		return
+	}
+	if file.Size > int64(limits.MaxImageBytes) {
+		abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("Image exceeds %d bytes", limits.MaxImageBytes))
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+		return
+	}
+
+	text, err := ocr.Recognize(c.Request.Context(), data)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "OCR failed", "file", file.Filename, "error", err)
+		abortWithError(c, ErrCodeOCRUnavailable, "Failed to read text from the image")
+		return
+	}
+	documentType, ok := detectDocument(text)
+	if !ok {
+		abortWithError(c, ErrCodeInvalidRequest, "No document text was detected in the image; retake the photo with the page filling the frame")
+		return
+	}
+	text = truncateUTF8(text, limits.MaxBytes)
+
+	tenant, _ := callerTenant(c)
+	a := *store.PutForConfirmation(tenant.ID, file.Filename, file.Header.Get("Content-Type"), text, documentType)
+	a.ExtractedText = text
+	slog.InfoContext(c.Request.Context(), "OCR extracted text", "bytes", len(text), "file", file.Filename, "document_type", documentType)
+	c.JSON(http.StatusCreated, a)
+}
+
+func confirmAttachmentHandler(store *AttachmentStore, limits AttachmentLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ConfirmAttachmentRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+				return
+			}
+		}
+		if !utf8.ValidString(req.Text) {
+			abortWithError(c, ErrCodeInvalidRequest, "text must be UTF-8 text")
+			return
+		}
+		if len(req.Text) > limits.MaxBytes {
+			abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("Attachment text exceeds %d bytes", limits.MaxBytes))
+			return
+		}
+
+		tenant, _ := callerTenant(c)
+		a, err := store.Confirm(c.Param("id"), tenant.ID, strings.TrimSpace(req.Text))
+		if err != nil {
+			abortWithError(c, ErrCodeAttachmentNotFound, fmt.Sprintf("Attachment %q not found", c.Param("id")))
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	}
+}
+
+func deleteAttachmentHandler(store *AttachmentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		if err := store.Delete(c.Param("id"), tenant.ID); err != nil {
+			abortWithError(c, ErrCodeAttachmentNotFound, fmt.Sprintf("Attachment %q not found", c.Param("id")))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
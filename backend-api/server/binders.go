@@ -0,0 +1,667 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/document"
+)
+
+// Binder item kinds
+const (
+	BinderItemAnswer = "answer"
+	BinderItemSource = "source"
+	BinderItemNote   = "note"
+)
+
+const (
+	maxBinderItems      = 500
+	maxBinderNameLen    = 200
+	maxBinderTextLen    = 20000
+	maxBinderExcerptLen = 2000
+	maxSourceTitleLen   = 200
+	maxAnswerCitations  = 5
+)
+
+// Binder is a named, ordered collection of answers, sources and notes.
+// A private binder is visible to its owner only; a shared binder to
+// everyone in the owner's tenant.
+type Binder struct {
+	ID          string       `json:"id"`
+	TenantID    string       `json:"tenant_id,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Shared      bool         `json:"shared"`
+	Items       []BinderItem `json:"items"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// BinderItem is an entry of a binder. Answers and sources are copied in
+// when added, so they outlive the history entry they came from.
+type BinderItem struct {
+	ID        string         `json:"id"`
+	Kind      string         `json:"kind"`
+	HistoryID string         `json:"history_id,omitempty"`
+	Question  string         `json:"question,omitempty"`
+	Answer    string         `json:"answer,omitempty"`
+	Citations []BinderSource `json:"citations,omitempty"`
+	Source    *BinderSource  `json:"source,omitempty"`
+	Note      string         `json:"note,omitempty"`
+	AddedBy   string         `json:"added_by,omitempty"`
+	AddedAt   time.Time      `json:"added_at"`
+}
+
+// BinderSource is a legal provision or web page kept in a binder
+type BinderSource struct {
+	Title   string `json:"title"`
+	URL     string `json:"url,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+}
+
+var (
+	errBinderNotFound     = errors.New("binder not found")
+	errBinderItemNotFound = errors.New("binder item not found")
+	errNotBinderOwner     = errors.New("only the binder owner can do this")
+	errBinderFull         = fmt.Errorf("binders hold at most %d items", maxBinderItems)
+	errSaveBinders        = errors.New("failed to save binders")
+)
+
+// BinderStore keeps binders in memory, persisted to a JSON file when a path
+// is configured
+type BinderStore struct {
+	mu      sync.RWMutex
+	path    string
+	binders map[string]*Binder
+}
+
+func NewBinderStore(path string) (*BinderStore, error) {
+	store := &BinderStore{
+		path:    path,
+		binders: make(map[string]*Binder),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var binders []*Binder
+	if _, err := readJSONFile(path, &binders); err != nil {
+		return nil, fmt.Errorf("failed to load binders: %w", err)
+	}
+	for _, b := range binders {
+		store.binders[b.ID] = b
+	}
+	return store, nil
+}
+
+// visible reports whether a user of a tenant may see and edit the binder
+func (b *Binder) visible(tenantID, user string) bool {
+	return b.TenantID == tenantID && (b.Shared || b.Owner == user)
+}
+
+// List returns the binders visible to a user, most recently updated first
+func (s *BinderStore) List(tenantID, user string) []Binder {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	binders := []Binder{}
+	for _, b := range s.binders {
+		if b.visible(tenantID, user) {
+			binders = append(binders, *b)
+		}
+	}
+	sort.Slice(binders, func(i, j int) bool { return binders[i].UpdatedAt.After(binders[j].UpdatedAt) })
+	return binders
+}
+
+func (s *BinderStore) Get(id, tenantID, user string) (Binder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.binders[id]
+	if !ok || !b.visible(tenantID, user) {
+		return Binder{}, errBinderNotFound
+	}
+	return *b, nil
+}
+
+func (s *BinderStore) Create(b Binder) (Binder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	b.ID = "b_" + randomHex(8)
+	b.CreatedAt, b.UpdatedAt = now, now
+	if b.Items == nil {
+		b.Items = []BinderItem{}
+	}
+	s.binders[b.ID] = &b
+	return b, s.saveLocked()
+}
+
+// Update applies fn to a copy of a binder visible to the user and persists
+// the result
+func (s *BinderStore) Update(id, tenantID, user string, fn func(*Binder) error) (Binder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.binders[id]
+	if !ok || !current.visible(tenantID, user) {
+		return Binder{}, errBinderNotFound
+	}
+	updated := *current
+	updated.Items = append([]BinderItem(nil), current.Items...)
+	if err := fn(&updated); err != nil {
+		return Binder{}, err
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	s.binders[id] = &updated
+	return updated, s.saveLocked()
+}
+
+// Delete removes a binder; only its owner can delete a shared binder
+func (s *BinderStore) Delete(id, tenantID, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.binders[id]
+	if !ok || !b.visible(tenantID, user) {
+		return errBinderNotFound
+	}
+	if b.Owner != user {
+		return errNotBinderOwner
+	}
+	delete(s.binders, id)
+	return s.saveLocked()
+}
+
+func (s *BinderStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	binders := make([]*Binder, 0, len(s.binders))
+	for _, b := range s.binders {
+		binders = append(binders, b)
+	}
+	sort.Slice(binders, func(i, j int) bool { return binders[i].ID < binders[j].ID })
+	if err := writeJSONFile(s.path, binders); err != nil {
+		return fmt.Errorf("%w: %w", errSaveBinders, err)
+	}
+	return nil
+}
+
+func findBinderItem(items []BinderItem, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// sourceFromResult turns a search result of an answer into a binder source
+func sourceFromResult(r engine.DocumentHit) BinderSource {
+	text := strings.TrimSpace(r.Excerpt)
+	title := text
+	if t := strings.TrimSpace(r.Title); t != "" {
+		title = t
+	}
+	if len(title) > maxSourceTitleLen {
+		title = truncateUTF8(title, maxSourceTitleLen) + "…"
+	}
+	return BinderSource{Title: title, Excerpt: truncateUTF8(text, maxBinderExcerptLen)}
+}
+
+// sourceFromWebResult turns a web result of an answer into a binder source
+func sourceFromWebResult(r engine.WebHit) BinderSource {
+	return BinderSource{
+		Title:   strings.TrimSpace(r.Title),
+		URL:     strings.TrimSpace(r.URL),
+		Excerpt: truncateUTF8(strings.TrimSpace(r.Content), maxBinderExcerptLen),
+	}
+}
+
+// binderDocument lays a binder out as a research memo, ending with the
+// disclaimer: each answer under
+// its question with the sources it cited, sources as quotations and notes
+// as the reader's commentary
+func binderDocument(b Binder, disclaimer *engine.DisclaimerStamp) *document.Document {
+	doc := &document.Document{
+		Title:    b.Name,
+		Subtitle: fmt.Sprintf("Research memo, %d items", len(b.Items)),
+		Created:  time.Now(),
+	}
+	doc.Add(document.Paragraph, b.Description)
+	n := 0
+	for _, item := range b.Items {
+		switch item.Kind {
+		case BinderItemAnswer:
+			n++
+			doc.Add(document.Heading, fmt.Sprintf("%d. %s", n, item.Question))
+			doc.AddMarkdown(item.Answer)
+			if len(item.Citations) > 0 {
+				doc.Add(document.Subheading, "Sources")
+				for _, source := range item.Citations {
+					doc.Add(document.Bullet, source.Title)
+				}
+			}
+		case BinderItemSource:
+			n++
+			doc.Add(document.Heading, fmt.Sprintf("%d. %s", n, item.Source.Title))
+			doc.Add(document.Quote, item.Source.Excerpt)
+			doc.Add(document.Paragraph, item.Source.URL)
+		}
+		doc.Add(document.Paragraph, item.Note)
+	}
+	addDisclaimer(doc, disclaimer)
+	return doc
+}
+
+// Handlers
+
+// callerUser returns the user named by the X-User-ID header. Like
+// X-Tenant-ID it is trusted as-is, so it only scopes the caller's own data;
+// see authenticatedUser for access control. Callers without it share the ""
+// user.
+func callerUser(c *gin.Context) string {
+	return strings.TrimSpace(c.GetHeader("X-User-ID"))
+}
+
+// CreateBinderRequest is the body of POST /api/binders
+type CreateBinderRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Shared      bool   `json:"shared"`
+}
+
+// UpdateBinderRequest is the body of PATCH /api/binders/:id; omitted fields
+// are left unchanged
+type UpdateBinderRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Shared      *bool   `json:"shared,omitempty"`
+}
+
+// AddBinderItemRequest is the body of POST /api/binders/:id/items. Answers
+// are copied from the history entry; sources either from one of its results
+// (result_index, counting web results when web is set) or from the body.
+type AddBinderItemRequest struct {
+	Kind        string        `json:"kind" binding:"required"`
+	HistoryID   string        `json:"history_id"`
+	ResultIndex *int          `json:"result_index,omitempty"`
+	Web         bool          `json:"web"`
+	Source      *BinderSource `json:"source,omitempty"`
+	Note        string        `json:"note"`
+
+	// Position inserts the item at this index instead of appending it
+	Position *int `json:"position,omitempty"`
+}
+
+// UpdateBinderItemRequest is the body of PUT /api/binders/:id/items/:item_id
+type UpdateBinderItemRequest struct {
+	Note string `json:"note"`
+}
+
+// ReorderBinderRequest is the body of PUT /api/binders/:id/order and lists
+// every item of the binder in its new order
+type ReorderBinderRequest struct {
+	ItemIDs []string `json:"item_ids" binding:"required"`
+}
+
+func validateBinderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxBinderNameLen {
+		return "", fmt.Errorf("name must be at most %d characters", maxBinderNameLen)
+	}
+	return name, nil
+}
+
+func listBindersHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{"binders": store.List(tenant.ID, callerUser(c))})
+	}
+}
+
+func createBinderHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateBinderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		name, err := validateBinderName(req.Name)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		tenant, _ := callerTenant(c)
+		binder, err := store.Create(Binder{
+			TenantID:    tenant.ID,
+			Owner:       callerUser(c),
+			Name:        name,
+			Description: strings.TrimSpace(req.Description),
+			Shared:      req.Shared,
+		})
+		if err != nil {
+			abortWithBinderError(c, "", err)
+			return
+		}
+		c.JSON(http.StatusCreated, binder)
+	}
+}
+
+func getBinderHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		binder, err := store.Get(c.Param("id"), tenant.ID, callerUser(c))
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusOK, binder)
+	}
+}
+
+func updateBinderHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdateBinderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if req.Name != nil {
+			name, err := validateBinderName(*req.Name)
+			if err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, err.Error())
+				return
+			}
+			req.Name = &name
+		}
+		tenant, _ := callerTenant(c)
+		user := callerUser(c)
+		binder, err := store.Update(c.Param("id"), tenant.ID, user, func(b *Binder) error {
+			if req.Shared != nil && *req.Shared != b.Shared {
+				if b.Owner != user {
+					return errNotBinderOwner
+				}
+				b.Shared = *req.Shared
+			}
+			if req.Name != nil {
+				b.Name = *req.Name
+			}
+			if req.Description != nil {
+				b.Description = strings.TrimSpace(*req.Description)
+			}
+			return nil
+		})
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusOK, binder)
+	}
+}
+
+func deleteBinderHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		if err := store.Delete(c.Param("id"), tenant.ID, callerUser(c)); err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// newBinderItem builds an item from a request, copying answers and sources
+// out of the caller's history
+func newBinderItem(req AddBinderItemRequest, history *HistoryStore, tenantID string) (BinderItem, ErrorCode, error) {
+	item := BinderItem{
+		ID:        "bi_" + randomHex(6),
+		Kind:      req.Kind,
+		HistoryID: req.HistoryID,
+		Note:      strings.TrimSpace(req.Note),
+		AddedAt:   time.Now().UTC(),
+	}
+	if len(item.Note) > maxBinderTextLen {
+		return item, ErrCodeInvalidRequest, fmt.Errorf("note must be at most %d bytes", maxBinderTextLen)
+	}
+
+	var entry HistoryEntry
+	if req.HistoryID != "" {
+		var err error
+		if entry, err = history.Get(req.HistoryID, tenantID); err != nil {
+			return item, ErrCodeHistoryNotFound, fmt.Errorf("history entry %q not found", req.HistoryID)
+		}
+	}
+
+	switch req.Kind {
+	case BinderItemAnswer:
+		if req.HistoryID == "" {
+			return item, ErrCodeInvalidRequest, errors.New("history_id is required for answers")
+		}
+		item.Question = entry.Question
+		item.Answer = entry.Response.Answer
+		for _, r := range entry.Response.SearchResults {
+			if len(item.Citations) == maxAnswerCitations {
+				break
+			}
+			item.Citations = append(item.Citations, sourceFromResult(r))
+		}
+	case BinderItemSource:
+		switch {
+		case req.ResultIndex != nil:
+			if req.HistoryID == "" {
+				return item, ErrCodeInvalidRequest, errors.New("history_id is required with result_index")
+			}
+			var sources []BinderSource
+			if req.Web {
+				for _, r := range entry.Response.WebResults {
+					sources = append(sources, sourceFromWebResult(r))
+				}
+			} else {
+				for _, r := range entry.Response.SearchResults {
+					sources = append(sources, sourceFromResult(r))
+				}
+			}
+			if *req.ResultIndex < 0 || *req.ResultIndex >= len(sources) {
+				return item, ErrCodeInvalidRequest, fmt.Errorf("result_index must be between 0 and %d", len(sources)-1)
+			}
+			item.Source = &sources[*req.ResultIndex]
+			item.Question = entry.Question
+		case req.Source != nil:
+			source := BinderSource{
+				Title:   strings.TrimSpace(req.Source.Title),
+				URL:     strings.TrimSpace(req.Source.URL),
+				Excerpt: truncateUTF8(strings.TrimSpace(req.Source.Excerpt), maxBinderExcerptLen),
+			}
+			if source.Title == "" {
+				return item, ErrCodeInvalidRequest, errors.New("source.title must not be empty")
+			}
+			item.Source = &source
+		default:
+			return item, ErrCodeInvalidRequest, errors.New("source items need result_index or source")
+		}
+	case BinderItemNote:
+		if item.Note == "" {
+			return item, ErrCodeInvalidRequest, errors.New("note must not be empty")
+		}
+	default:
+		return item, ErrCodeInvalidRequest, fmt.Errorf("kind must be %q, %q or %q", BinderItemAnswer, BinderItemSource, BinderItemNote)
+	}
+	return item, "", nil
+}
+
+func addBinderItemHandler(store *BinderStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AddBinderItemRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		user := callerUser(c)
+		item, code, err := newBinderItem(req, history, tenant.ID)
+		if err != nil {
+			abortWithError(c, code, err.Error())
+			return
+		}
+		item.AddedBy = user
+
+		binder, err := store.Update(c.Param("id"), tenant.ID, user, func(b *Binder) error {
+			if len(b.Items) >= maxBinderItems {
+				return errBinderFull
+			}
+			at := len(b.Items)
+			if req.Position != nil && *req.Position >= 0 && *req.Position < at {
+				at = *req.Position
+			}
+			b.Items = append(b.Items[:at], append([]BinderItem{item}, b.Items[at:]...)...)
+			return nil
+		})
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusCreated, binder)
+	}
+}
+
+func updateBinderItemHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdateBinderItemRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		note := strings.TrimSpace(req.Note)
+		if len(note) > maxBinderTextLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("note must be at most %d bytes", maxBinderTextLen))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		binder, err := store.Update(c.Param("id"), tenant.ID, callerUser(c), func(b *Binder) error {
+			i := findBinderItem(b.Items, c.Param("item_id"))
+			if i < 0 {
+				return errBinderItemNotFound
+			}
+			if b.Items[i].Kind == BinderItemNote && note == "" {
+				return errors.New("note must not be empty")
+			}
+			b.Items[i].Note = note
+			return nil
+		})
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusOK, binder)
+	}
+}
+
+func deleteBinderItemHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		binder, err := store.Update(c.Param("id"), tenant.ID, callerUser(c), func(b *Binder) error {
+			i := findBinderItem(b.Items, c.Param("item_id"))
+			if i < 0 {
+				return errBinderItemNotFound
+			}
+			b.Items = append(b.Items[:i], b.Items[i+1:]...)
+			return nil
+		})
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusOK, binder)
+	}
+}
+
+func reorderBinderHandler(store *BinderStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReorderBinderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		binder, err := store.Update(c.Param("id"), tenant.ID, callerUser(c), func(b *Binder) error {
+			if len(req.ItemIDs) != len(b.Items) {
+				return fmt.Errorf("item_ids must list all %d items of the binder", len(b.Items))
+			}
+			ordered := make([]BinderItem, 0, len(b.Items))
+			for _, id := range req.ItemIDs {
+				i := findBinderItem(b.Items, id)
+				if i < 0 || findBinderItem(ordered, id) >= 0 {
+					return fmt.Errorf("item_ids must list every item exactly once; %q is unknown or repeated", id)
+				}
+				ordered = append(ordered, b.Items[i])
+			}
+			b.Items = ordered
+			return nil
+		})
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		c.JSON(http.StatusOK, binder)
+	}
+}
+
+func exportBinderHandler(store *BinderStore, font *document.Font, disclaimers *DisclaimerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if format := c.DefaultQuery("format", "pdf"); format != "pdf" {
+			abortWithInvalidParam(c, "format", fmt.Sprintf("Unsupported export format %q; use pdf", format))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		binder, err := store.Get(c.Param("id"), tenant.ID, callerUser(c))
+		if err != nil {
+			abortWithBinderError(c, c.Param("id"), err)
+			return
+		}
+		if font == nil {
+			abortWithError(c, ErrCodeExportUnavailable, "PDF export is disabled because no font is installed")
+			return
+		}
+		pdf, err := document.RenderPDF(binderDocument(binder, disclaimers.Stamp(tenant.ID)), font)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to render binder", "binder", binder.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to render the binder")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, binder.ID))
+		c.Data(http.StatusOK, "application/pdf", pdf)
+	}
+}
+
+// abortWithBinderError maps a BinderStore error onto the error catalog;
+// other errors come from request validation inside an update
+func abortWithBinderError(c *gin.Context, id string, err error) {
+	switch {
+	case errors.Is(err, errBinderNotFound):
+		abortWithError(c, ErrCodeBinderNotFound, fmt.Sprintf("Binder %q not found", id))
+	case errors.Is(err, errBinderItemNotFound):
+		abortWithError(c, ErrCodeBinderNotFound, fmt.Sprintf("Item %q not found in binder %q", c.Param("item_id"), id))
+	case errors.Is(err, errNotBinderOwner):
+		abortWithError(c, ErrCodeForbidden, fmt.Sprintf("Only the owner of binder %q can delete it or change its sharing", id))
+	case errors.Is(err, errBinderFull):
+		abortWithError(c, ErrCodeInvalidRequest, err.Error())
+	case errors.Is(err, errSaveBinders):
+		slog.ErrorContext(c.Request.Context(), "Failed to save binder", "binder", id, "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to save binder")
+	default:
+		abortWithError(c, ErrCodeInvalidRequest, err.Error())
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// doAs sends a JSON request on behalf of a user
+func doAs(t *testing.T, handler http.Handler, user, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", user)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeBinder(t *testing.T, rec *httptest.ResponseRecorder) Binder {
+	t.Helper()
+	var b Binder
+	if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+		t.Fatalf("decode binder %q: %v", rec.Body.String(), err)
+	}
+	return b
+}
+
+func TestBinderRenamePreflight(t *testing.T) {
+	t.Setenv("CORS_ALLOW_ORIGIN", "https://app.example.vn")
+	h := newTestServer(t, Options{Engine: &stubEngine{}}).Handler()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/api/binders/b1", nil)
+	req.Header.Set("Origin", "https://app.example.vn")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), http.MethodPatch) {
+		t.Errorf("preflight = %d, Access-Control-Allow-Methods %q, want PATCH allowed", rec.Code, rec.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestBinders(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"}},
+		Iterations:    1,
+	}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	question := "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+	var answer engine.LegalQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &answer); err != nil || answer.HistoryID == "" {
+		t.Fatalf("query = %d %s, want a history entry", rec.Code, rec.Body.String())
+	}
+
+	rec = doAs(t, h, "lan", http.MethodPost, "/api/binders", CreateBinderRequest{Name: "Thử việc"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	binder := decodeBinder(t, rec)
+	items := "/api/binders/" + binder.ID + "/items"
+
+	doAs(t, h, "lan", http.MethodPost, items, AddBinderItemRequest{Kind: BinderItemAnswer, HistoryID: answer.HistoryID})
+	zero := 0
+	doAs(t, h, "lan", http.MethodPost, items, AddBinderItemRequest{Kind: BinderItemSource, HistoryID: answer.HistoryID, ResultIndex: &zero})
+	rec = doAs(t, h, "lan", http.MethodPost, items, AddBinderItemRequest{Kind: BinderItemNote, Note: "Kiểm tra lại với HĐLĐ mẫu", Position: &zero})
+	binder = decodeBinder(t, rec)
+	if len(binder.Items) != 3 || binder.Items[0].Kind != BinderItemNote || binder.Items[1].Answer != stub.resp.Answer {
+		t.Fatalf("items = %+v, want the note first, then the answer", binder.Items)
+	}
+	if got := binder.Items[2].Source; got == nil || got.Title != "Thời gian thử việc" {
+		t.Errorf("source = %+v, want it copied from the search result", got)
+	}
+
+	order := ReorderBinderRequest{ItemIDs: []string{binder.Items[1].ID, binder.Items[2].ID, binder.Items[0].ID}}
+	binder = decodeBinder(t, doAs(t, h, "lan", http.MethodPut, "/api/binders/"+binder.ID+"/order", order))
+	if binder.Items[2].Kind != BinderItemNote {
+		t.Errorf("reordered items = %+v, want the note last", binder.Items)
+	}
+
+	// Private binders are invisible to colleagues until shared
+	if rec := doAs(t, h, "minh", http.MethodGet, "/api/binders/"+binder.ID, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("colleague get status = %d, want 404", rec.Code)
+	}
+	shared := true
+	doAs(t, h, "lan", http.MethodPatch, "/api/binders/"+binder.ID, UpdateBinderRequest{Shared: &shared})
+	if rec := doAs(t, h, "minh", http.MethodGet, "/api/binders/"+binder.ID, nil); rec.Code != http.StatusOK {
+		t.Errorf("colleague get of shared binder status = %d, want 200", rec.Code)
+	}
+	rec = doAs(t, h, "minh", http.MethodDelete, "/api/binders/"+binder.ID, nil)
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != ErrCodeForbidden {
+		t.Errorf("colleague delete = %d %s, want FORBIDDEN", rec.Code, rec.Body.String())
+	}
+
+	rec = doAs(t, h, "minh", http.MethodGet, "/api/binders/"+binder.ID+"/export", nil)
+	switch rec.Code {
+	case http.StatusOK:
+		if !bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-")) || rec.Header().Get("Content-Type") != "application/pdf" {
+			t.Errorf("export is not a PDF: %q", rec.Header().Get("Content-Type"))
+		}
+	case http.StatusServiceUnavailable:
+		if code := decodeError(t, rec).Code; code != ErrCodeExportUnavailable {
+			t.Errorf("export error = %s, want EXPORT_UNAVAILABLE", code)
+		}
+	default:
+		t.Errorf("export status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
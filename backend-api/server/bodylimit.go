@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// uploadRoutes take files rather than JSON, and have the larger upload
+// limit
+var uploadRoutes = map[string]bool{
+	"/api/attachments":                         true,
+	"/api/private-collections/:name/documents": true,
+}
+
+// reviewJobRoute carries up to REVIEW_JOB_MAX_DOCUMENTS documents, each as
+// large as a query attachment, and has a limit of its own
+const reviewJobRoute = "/api/review-jobs"
+
+// BodyLimitConfig bounds the size of request bodies, so that a client
+// cannot make the server read a multi-megabyte payload before a handler
+// rejects it
+type BodyLimitConfig struct {
+	// MaxBytes bounds every body except uploads
+	MaxBytes int
+	// MaxUploadBytes bounds the bodies of uploadRoutes
+	MaxUploadBytes int
+	// MaxReviewJobBytes bounds the body of reviewJobRoute
+	MaxReviewJobBytes int
+}
+
+// loadBodyLimitConfig loads the body limits. By default a query has room
+// for its inline attachments, a review job for its documents and checklist
+// questions of up to four bytes a character, and an upload for the largest
+// file the upload endpoints accept.
+func loadBodyLimitConfig(caps QueryCaps, attachments AttachmentLimits, privateDocs PrivateDocConfig, reviewJobs ReviewJobConfig) BodyLimitConfig {
+	const overhead = 64 * 1024
+	reviewJob := overhead + reviewJobs.MaxDocuments*attachments.MaxBytes + reviewJobs.MaxQuestions*4*caps.MaxQuestionLength
+	return BodyLimitConfig{
+		MaxBytes:          settings.IntInRange("MAX_BODY_BYTES", overhead+attachments.MaxCount*attachments.MaxBytes, 1024, 64<<20),
+		MaxUploadBytes:    settings.IntInRange("MAX_UPLOAD_BYTES", overhead+max(attachments.MaxImageBytes, 4*privateDocs.MaxBytes), 1024, 256<<20),
+		MaxReviewJobBytes: settings.IntInRange("MAX_REVIEW_JOB_BYTES", reviewJob, 1024, 256<<20),
+	}
+}
+
+// bodyLimitKey holds the limitedBody of a request in its context
+const bodyLimitKey = "body_limit"
+
+// limitedBody is a request body bounded by http.MaxBytesReader. It
+// remembers when the client sent more than the limit, so that the error of
+// the handler that read it can be answered as PAYLOAD_TOO_LARGE.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// bodyLimitMiddleware answers 413 PAYLOAD_TOO_LARGE to a request whose body
+// exceeds its limit. A declared Content-Length is checked before anything
+// is read; a chunked body fails the handler reading it once it goes past
+// the limit, and the handler's error becomes PAYLOAD_TOO_LARGE.
+func bodyLimitMiddleware(config BodyLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		limit := config.MaxBytes
+		switch route := c.FullPath(); {
+		case uploadRoutes[route]:
+			limit = config.MaxUploadBytes
+		case route == reviewJobRoute:
+			limit = config.MaxReviewJobBytes
+		}
+
+		if c.Request.ContentLength > int64(limit) {
+			abortWithBodyTooLarge(c, limit)
+			return
+		}
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(c.Writer, c.Request.Body, int64(limit)), limit: limit}
+		c.Request.Body = body
+		c.Set(bodyLimitKey, body)
+		c.Next()
+	}
+}
+
+// bodyTooLarge returns the limit of the request body when the handler read
+// past it
+func bodyTooLarge(c *gin.Context) (int, bool) {
+	if v, ok := c.Get(bodyLimitKey); ok && v.(*limitedBody).exceeded {
+		return v.(*limitedBody).limit, true
+	}
+	return 0, false
+}
+
+func abortWithBodyTooLarge(c *gin.Context, limit int) {
+	abortWithResponse(c, bodyTooLargeResponse(c, limit))
+}
+
+func bodyTooLargeResponse(c *gin.Context, limit int) ErrorResponse {
+	// The rest of the body is not read, so the connection cannot be reused
+	c.Header("Connection", "close")
+	resp := newErrorResponse(ErrCodePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+	resp.Details = map[string]any{"limit_bytes": limit}
+	return resp
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(bodyLimitMiddleware(BodyLimitConfig{MaxBytes: 1024, MaxUploadBytes: 4096, MaxReviewJobBytes: 2048}))
+	// Handlers fail on a body they cannot read, as the server's do
+	echo := func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	}
+	router.POST("/api/legal-query", echo)
+	router.POST("/api/attachments", echo)
+	router.POST("/api/review-jobs", echo)
+
+	post := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, body))
+		return rec
+	}
+	// chunked hides the length of a body, like a chunked request
+	chunked := func(s string) io.Reader { return io.MultiReader(strings.NewReader(s)) }
+	for _, tc := range []struct {
+		name string
+		path string
+		body io.Reader
+		want int
+	}{
+		{"small query", "/api/legal-query", strings.NewReader(strings.Repeat("a", 1024)), http.StatusOK},
+		{"large query", "/api/legal-query", strings.NewReader(strings.Repeat("a", 1025)), http.StatusRequestEntityTooLarge},
+		{"small chunked query", "/api/legal-query", chunked(strings.Repeat("a", 1000)), http.StatusOK},
+		{"large chunked query", "/api/legal-query", chunked(strings.Repeat("a", 5000)), http.StatusRequestEntityTooLarge},
+		{"upload", "/api/attachments", strings.NewReader(strings.Repeat("a", 4000)), http.StatusOK},
+		{"large upload", "/api/attachments", chunked(strings.Repeat("a", 4097)), http.StatusRequestEntityTooLarge},
+		{"review job", "/api/review-jobs", strings.NewReader(strings.Repeat("a", 2048)), http.StatusOK},
+		{"large review job", "/api/review-jobs", strings.NewReader(strings.Repeat("a", 2049)), http.StatusRequestEntityTooLarge},
+	} {
+		rec := post(tc.path, tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d %s, want %d", tc.name, rec.Code, rec.Body, tc.want)
+			continue
+		}
+		if tc.want != http.StatusRequestEntityTooLarge {
+			continue
+		}
+		resp := decodeError(t, rec)
+		if resp.Code != ErrCodePayloadTooLarge || resp.Details["limit_bytes"] == nil || rec.Header().Get("Connection") != "close" {
+			t.Errorf("%s: %+v, want PAYLOAD_TOO_LARGE with the limit", tc.name, resp)
+		}
+	}
+}
+
+func TestChunkedQueryTooLarge(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "2048")
+	stub := &stubEngine{}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	// A question padded past the limit, sent without a length
+	body := `{"question": "Thời gian thử việc tối đa là bao lâu?", "model": "` + strings.Repeat("a", 4096) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/legal-query", io.MultiReader(strings.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if resp := decodeError(t, rec); rec.Code != http.StatusRequestEntityTooLarge || resp.Details["limit_bytes"] != float64(2048) {
+		t.Errorf("chunked query = %d %+v, want 413 PAYLOAD_TOO_LARGE", rec.Code, resp)
+	}
+	if len(stub.requests) != 0 {
+		t.Errorf("engine called %d times, want never", len(stub.requests))
+	}
+}
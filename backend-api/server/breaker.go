@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// BreakerConfig controls the circuit breakers of the engine clients. A
+// breaker opens after Threshold failed queries in a row and lets a probe
+// through after Cooldown; a zero Threshold disables the breakers.
+type BreakerConfig struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// engineBreaker is the circuit breaker of one engine client. The breakers
+// of the engine regions announce on the status board when they open, as
+// users then get errors.
+type engineBreaker struct {
+	name     string
+	breaker  *engine.CircuitBreaker
+	announce bool
+}
+
+// watchBreakers logs the state changes of the breakers and sets a status
+// message while an announced breaker is open
+func watchBreakers(breakers []engineBreaker, status *StatusBoard) {
+	for _, b := range breakers {
+		b.breaker.OnStateChange = func(from, to engine.BreakerState) {
+			slog.Info("Engine circuit breaker changed state", "engine", b.name, "from", from, "to", to)
+			if !b.announce {
+				return
+			}
+			switch to {
+			case engine.BreakerOpen:
+				status.Raise("circuit_"+b.name, StatusDegraded, SeverityCritical,
+					fmt.Sprintf("The AI engine (%s) is not responding; questions cannot be answered until it recovers", b.name))
+			case engine.BreakerClosed:
+				status.Clear("circuit_" + b.name)
+			}
+		}
+	}
+}
+
+// Handlers
+
+func breakerStatsHandler(breakers []engineBreaker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		type breakerStats struct {
+			Engine string `json:"engine"`
+			engine.BreakerStats
+		}
+		stats := []breakerStats{}
+		for _, b := range breakers {
+			stats = append(stats, breakerStats{b.name, b.breaker.Stats()})
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": len(breakers) > 0, "breakers": stats})
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestEngineCircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	var up atomic.Bool
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/query" {
+			return
+		}
+		requests.Add(1)
+		if !up.Load() {
+			http.Error(w, "model server unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(engine.LegalQueryResponse{Answer: "Theo Bộ luật Lao động 2019...", Iterations: 1})
+	}))
+	defer pythonEngine.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	t.Setenv("ENGINE_RETRY_ATTEMPTS", "1")
+	t.Setenv("ENGINE_BREAKER_THRESHOLD", "2")
+	t.Setenv("ENGINE_BREAKER_COOLDOWN", "50ms")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	srv := newTestServer(t, Options{})
+	h := srv.Handler()
+
+	ask := func(i int) *httptest.ResponseRecorder {
+		question := "Câu hỏi số " + strconv.Itoa(i) + " về thời gian thử việc theo Bộ luật Lao động 2019?"
+		return doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+	}
+	statusMessages := func() []StatusMessage {
+		var resp struct {
+			Messages []StatusMessage `json:"messages"`
+		}
+		json.Unmarshal(doJSON(t, h, http.MethodGet, "/api/status", nil).Body.Bytes(), &resp)
+		return resp.Messages
+	}
+
+	for i := range 2 {
+		resp := decodeError(t, ask(i))
+		if resp.Code != ErrCodeEngineUnavailable {
+			t.Fatalf("query %d = %s, want %s", i, resp.Code, ErrCodeEngineUnavailable)
+		}
+		if resp.Details["engine_status"] != float64(http.StatusServiceUnavailable) {
+			t.Errorf("query %d details = %v, want the engine status", i, resp.Details)
+		}
+	}
+	rec := ask(2)
+	if resp := decodeError(t, rec); resp.Code != ErrCodeEngineCircuitOpen || requests.Load() != 2 {
+		t.Errorf("query with the breaker open = %s after %d engine requests, want %s after 2", resp.Code, requests.Load(), ErrCodeEngineCircuitOpen)
+	} else if resp.RetryAfter != 1 || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("query with the breaker open: retry_after %d, Retry-After %q, want 1 for the cooldown", resp.RetryAfter, rec.Header().Get("Retry-After"))
+	}
+	if messages := statusMessages(); len(messages) != 1 || !messages[0].Automatic || messages[0].Severity != SeverityCritical {
+		t.Errorf("status = %+v, want the open breaker announced", messages)
+	}
+
+	// The first query after the cooldown probes the engine and closes the breaker
+	up.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	if rec := ask(3); rec.Code != http.StatusOK {
+		t.Fatalf("probe = %d %s", rec.Code, rec.Body.String())
+	}
+	if messages := statusMessages(); len(messages) != 0 {
+		t.Errorf("status = %+v after recovery, want none", messages)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/breakers", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var stats struct {
+		Breakers []struct {
+			Engine string `json:"engine"`
+			engine.BreakerStats
+		} `json:"breakers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || len(stats.Breakers) != 1 || stats.Breakers[0].Engine != "primary" || stats.Breakers[0].State != engine.BreakerClosed || stats.Breakers[0].Trips != 1 {
+		t.Errorf("breakers = %d %s, want the primary closed after 1 trip", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Response cache tiers, reported as cache_hit
+const (
+	CacheMemory = "memory"
+	CacheRedis  = "redis"
+)
+
+// CacheConfig controls the response cache and how it is warmed. With
+// RedisURL set, responses are kept in Redis rather than in memory.
+type CacheConfig struct {
+	MaxEntries   int
+	TTL          time.Duration
+	RedisURL     string
+	RedisPrefix  string
+	WarmTopN     int
+	WarmOnStart  bool
+	WarmInterval time.Duration
+}
+
+// Cache stores engine responses by cache key, indexed by the corpus
+// documents each answer was drawn from
+type Cache interface {
+	// Backend names the tier, reported as cache_hit
+	Backend() string
+	Get(key string) (*engine.LegalQueryResponse, bool, error)
+	Put(key string, resp *engine.LegalQueryResponse, documents []string) error
+	// Invalidate drops the responses drawn from any of the documents and
+	// returns how many were dropped
+	Invalidate(documents []string) (int, error)
+	Purge() (int, error)
+	// Size counts the stored responses and the documents indexing them
+	Size() (entries, documents int, err error)
+}
+
+// redisRetryInterval is how long the cache answers from its fallback
+// after Redis failed before trying Redis again
+const redisRetryInterval = 10 * time.Second
+
+// ResponseCache keeps engine responses for identical engine requests.
+// Requests carrying context documents are never cached. Entries are indexed
+// by the corpus documents their answer was drawn from, so a corpus update
+// invalidates only them.
+//
+// A cache shared through Redis falls back to an in-memory LRU while Redis
+// is unavailable, and drops what the fallback holds once Redis is back:
+// invalidations made by other replicas meanwhile never reached it.
+type ResponseCache struct {
+	store    Cache
+	fallback Cache
+
+	mu          sync.Mutex
+	maxEntries  int
+	failedAt    time.Time // zero while the store is healthy
+	hits        int
+	misses      int
+	invalidated int
+	now         func() time.Time
+}
+
+// NewResponseCache keeps up to maxEntries responses in memory
+func NewResponseCache(maxEntries int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{store: newLRUCache(maxEntries, ttl), maxEntries: maxEntries, now: time.Now}
+}
+
+// NewSharedResponseCache keeps the responses in Redis under prefix, and up
+// to fallbackEntries of them in memory while Redis is unavailable
+func NewSharedResponseCache(client *redis.Client, prefix string, ttl time.Duration, fallbackEntries int) *ResponseCache {
+	return &ResponseCache{
+		store:      &redisCache{client: client, prefix: prefix, ttl: ttl},
+		fallback:   newLRUCache(fallbackEntries, ttl),
+		maxEntries: fallbackEntries,
+		now:        time.Now,
+	}
+}
+
+// answerDocuments lists the corpus documents an answer was drawn from: the
+// document_id of each internal search result and, for a result holding an
+// article, the article as document_id/article_id. A corpus without
+// document_id, such as the single-code default corpus, yields bare article
+// IDs like Dieu_25.
+func answerDocuments(resp *engine.LegalQueryResponse) []string {
+	seen := make(map[string]bool)
+	var documents []string
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			documents = append(documents, id)
+		}
+	}
+	for _, r := range resp.SearchResults {
+		add(r.DocumentID)
+		if article := resultArticleID(r); article != "" && r.DocumentID != "" {
+			add(r.DocumentID + "/" + article)
+		} else {
+			add(article)
+		}
+	}
+	sort.Strings(documents)
+	return documents
+}
+
+// resultArticleID returns the article_id of a search result, deriving it
+// from the article label ("Điều 25") of article-level chunks
+func resultArticleID(r engine.DocumentHit) string {
+	if r.ArticleNumber != "" {
+		return r.ArticleNumber
+	}
+	if m := articleCitationPattern.FindStringSubmatch(r.Article); m != nil {
+		return "Dieu_" + m[1]
+	}
+	return ""
+}
+
+// cacheKey hashes the engine request with its question normalized, or
+// returns "" when it must not be cached. Query variants are rewrites of the
+// question and left out, as are source priors, which change whenever they
+// are recomputed and only reorder the results. Queries of private documents are not cached, as an
+// upload changes their answer.
+func cacheKey(req *engine.PythonQueryRequest) string {
+	if len(req.ContextDocuments) > 0 || len(req.History) > 0 || req.ConversationSummary != "" || req.Namespace != "" {
+		return ""
+	}
+	normalized := *req
+	normalized.Question = normalizeQuestion(req.Question)
+	normalized.QueryVariants = nil
+	normalized.Deadlines = nil
+	normalized.SourcePriors = nil
+	data, err := json.Marshal(&normalized)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// active returns the store to use: the fallback while the store is
+// failing, until it is retried
+func (c *ResponseCache) active() Cache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fallback != nil && !c.failedAt.IsZero() && c.now().Sub(c.failedAt) < redisRetryInterval {
+		return c.fallback
+	}
+	return c.store
+}
+
+// observe records the outcome of a call to the store and returns whether
+// it succeeded. The first failure switches to the fallback; the first
+// success after it empties the fallback and switches back.
+func (c *ResponseCache) observe(op string, err error) bool {
+	if c.fallback == nil {
+		return err == nil
+	}
+	c.mu.Lock()
+	failing := !c.failedAt.IsZero()
+	if err != nil {
+		c.failedAt = c.now()
+	} else {
+		c.failedAt = time.Time{}
+	}
+	c.mu.Unlock()
+
+	switch {
+	case err != nil && !failing:
+		slog.Warn("Response cache failed, using the memory cache until it recovers", "op", op, "backend", c.store.Backend(), "error", err)
+	case err != nil:
+		slog.Error("Response cache failed", "op", op, "backend", c.store.Backend(), "error", err)
+	case failing:
+		n, _ := c.fallback.Purge()
+		slog.Info("Response cache recovered; dropped the responses cached in memory meanwhile", "backend", c.store.Backend(), "dropped", n)
+	}
+	return err == nil
+}
+
+func (c *ResponseCache) Get(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, bool) {
+	key := cacheKey(req)
+	if key == "" {
+		return nil, false
+	}
+
+	store := c.active()
+	resp, ok, err := store.Get(key)
+	if store == c.store && !c.observe("read", err) {
+		store = c.fallback
+		resp, ok, _ = store.Get(key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	resp.Cached = true
+	resp.CacheHit = store.Backend()
+	return resp, true
+}
+
+// Put stores a response; clarification requests are not cached
+func (c *ResponseCache) Put(req *engine.PythonQueryRequest, resp *engine.LegalQueryResponse) {
+	key := cacheKey(req)
+	if key == "" || resp.NeedsClarification || resp.Downgrade != nil {
+		return
+	}
+	documents := answerDocuments(resp)
+	store := c.active()
+	if err := store.Put(key, resp, documents); store == c.store && !c.observe("write", err) {
+		c.fallback.Put(key, resp, documents)
+	}
+}
+
+// Invalidate drops the entries drawn from any of the documents, after they
+// were updated or repealed, and returns how many were dropped. Documents are
+// matched exactly: a document_id drops every answer citing the document, a
+// document_id/article_id only those citing the article. The fallback is
+// invalidated as well, so it holds no stale answer when it is next used.
+func (c *ResponseCache) Invalidate(documents []string) int {
+	n, err := c.store.Invalidate(documents)
+	c.observe("invalidate", err)
+	if c.fallback != nil {
+		dropped, _ := c.fallback.Invalidate(documents)
+		n += dropped
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidated += n
+	return n
+}
+
+// Purge drops every entry, e.g. after the whole corpus was re-ingested
+func (c *ResponseCache) Purge() int {
+	n, err := c.store.Purge()
+	c.observe("purge", err)
+	if c.fallback != nil {
+		dropped, _ := c.fallback.Purge()
+		n += dropped
+	}
+	return n
+}
+
+// CacheStats is returned by the cache admin endpoint. While a shared cache
+// is unavailable, Fallback is set and Entries and Documents count the
+// responses cached in memory.
+type CacheStats struct {
+	Backend     string      `json:"backend"`
+	Fallback    bool        `json:"fallback,omitempty"`
+	Entries     int         `json:"entries"`
+	MaxEntries  int         `json:"max_entries"`
+	Documents   int         `json:"documents"`
+	Hits        int         `json:"hits"`
+	Misses      int         `json:"misses"`
+	Invalidated int         `json:"invalidated"`
+	LastWarm    *WarmReport `json:"last_warm,omitempty"`
+}
+
+func (c *ResponseCache) Stats() CacheStats {
+	store := c.active()
+	entries, documents, err := store.Size()
+	if store == c.store && !c.observe("size", err) {
+		store = c.fallback
+		entries, documents, _ = store.Size()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Backend:     c.store.Backend(),
+		Fallback:    store != c.store,
+		Entries:     entries,
+		MaxEntries:  c.maxEntries,
+		Documents:   documents,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Invalidated: c.invalidated,
+	}
+}
+
+// cachedEngine answers from the cache when it can and caches what the next
+// engine answers
+type cachedEngine struct {
+	next  engine.QueryEngine
+	cache *ResponseCache
+}
+
+func (e *cachedEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *cachedEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	if resp, ok := e.cache.Get(req); ok {
+		return resp, nil
+	}
+	resp, err := engine.QueryWithContext(ctx, e.next, req)
+	if err != nil {
+		return nil, err
+	}
+	e.cache.Put(req, resp)
+	stored := *resp
+	return &stored, nil
+}
+
+// WarmReport describes a cache warming run
+type WarmReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Questions  int       `json:"questions"`
+	Warmed     int       `json:"warmed"`
+	Failed     int       `json:"failed"`
+}
+
+// CacheWarmer re-executes the most frequent questions of the history against
+// the engine and stores the fresh answers in the cache, so common questions
+// stay fast after a restart or a corpus update
+type CacheWarmer struct {
+	engine  engine.QueryEngine
+	cache   *ResponseCache
+	history *HistoryStore
+	topN    int
+	build   func(req *LegalQueryRequest) *engine.PythonQueryRequest
+
+	// notifications is told when a pass completes
+	notifications *NotificationCenter
+
+	mu      sync.Mutex
+	running bool
+	last    *WarmReport
+}
+
+// Warm runs one warming pass. It returns false without doing anything when a
+// pass is already running.
+func (w *CacheWarmer) Warm() (WarmReport, bool) {
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		return WarmReport{}, false
+	}
+	w.running = true
+	w.mu.Unlock()
+
+	report := WarmReport{StartedAt: time.Now().UTC()}
+	entries := w.history.TopQuestions(w.topN)
+	report.Questions = len(entries)
+	for _, entry := range entries {
+		// Questions are re-run one at a time so warming never floods the engine
+		req := regenerationRequest(entry, RegenerateRequest{})
+		pythonReq := w.build(&req)
+		resp, err := w.engine.Query(pythonReq)
+		if err != nil {
+			slog.Warn("Failed to warm cache", "entry", entry.ID, "error", err)
+			report.Failed++
+			continue
+		}
+		w.cache.Put(pythonReq, resp)
+		report.Warmed++
+	}
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	slog.Info("Cache warmed", "warmed", report.Warmed, "questions", report.Questions, "duration_ms", report.DurationMs)
+	w.notifications.Publish(Notification{
+		Kind:  NotificationJobCompleted,
+		Title: "Cache warming completed",
+		Body:  fmt.Sprintf("%d of %d questions warmed, %d failed, in %dms", report.Warmed, report.Questions, report.Failed, report.DurationMs),
+		Link:  "/admin/cache",
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = false
+	w.last = &report
+	return report, true
+}
+
+// LastReport returns the report of the last completed pass, if any
+func (w *CacheWarmer) LastReport() *WarmReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// Schedule warms the cache every interval until stop is closed
+func (w *CacheWarmer) Schedule(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Warm()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Handlers
+
+func cacheStatsHandler(cache *ResponseCache, warmer *CacheWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cache == nil {
+			abortWithError(c, ErrCodeForbidden, "Response cache is disabled; set RESPONSE_CACHE_MAX_ENTRIES to enable it")
+			return
+		}
+		stats := cache.Stats()
+		stats.LastWarm = warmer.LastReport()
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// warmCacheHandler starts a warming pass in the background. With
+// ?purge=true the cache is emptied first, for use after a corpus update.
+func warmCacheHandler(cache *ResponseCache, warmer *CacheWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cache == nil {
+			abortWithError(c, ErrCodeForbidden, "Response cache is disabled; set RESPONSE_CACHE_MAX_ENTRIES to enable it")
+			return
+		}
+		purged := 0
+		if c.Query("purge") == "true" {
+			purged = cache.Purge()
+			slog.InfoContext(c.Request.Context(), "Response cache purged", "entries", purged)
+		}
+		go warmer.Warm()
+		c.JSON(http.StatusAccepted, gin.H{"purged": purged, "status": "warming"})
+	}
+}
+
+// InvalidateCacheRequest names the corpus documents that were updated or
+// repealed
+type InvalidateCacheRequest struct {
+	Documents []string `json:"documents"`
+
+	// Warm starts a warming pass once the entries are dropped
+	Warm bool `json:"warm,omitempty"`
+}
+
+// invalidateCacheHandler drops the cached answers drawn from updated or
+// repealed documents, for the ingestion pipeline to call after a change
+func invalidateCacheHandler(cache *ResponseCache, warmer *CacheWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cache == nil {
+			abortWithError(c, ErrCodeForbidden, "Response cache is disabled; set RESPONSE_CACHE_MAX_ENTRIES to enable it")
+			return
+		}
+		var req InvalidateCacheRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if len(req.Documents) == 0 {
+			abortWithError(c, ErrCodeInvalidRequest, "documents must not be empty")
+			return
+		}
+		invalidated := cache.Invalidate(req.Documents)
+		slog.InfoContext(c.Request.Context(), "Response cache invalidated", "entries", invalidated, "documents", req.Documents)
+		resp := gin.H{"invalidated": invalidated}
+		if req.Warm {
+			go warmer.Warm()
+			resp["status"] = "warming"
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
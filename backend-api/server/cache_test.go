@@ -0,0 +1,168 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestAnswerDocuments(t *testing.T) {
+	resp := &engine.LegalQueryResponse{SearchResults: []engine.DocumentHit{
+		{ArticleNumber: "Dieu_25", ClauseID: "Khoan_1"},
+		{Article: "Điều 26"},
+		{DocumentID: "nd-145-2020", ArticleNumber: "Dieu_3"},
+		{ArticleNumber: "Dieu_25", ClauseID: "Khoan_2"},
+		{Excerpt: "no metadata"},
+	}}
+	want := []string{"Dieu_25", "Dieu_26", "nd-145-2020", "nd-145-2020/Dieu_3"}
+	if got := answerDocuments(resp); !slices.Equal(got, want) {
+		t.Errorf("answerDocuments = %v, want %v", got, want)
+	}
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	cache := NewResponseCache(10, time.Hour)
+	answer := func(question string, results ...engine.DocumentHit) *engine.PythonQueryRequest {
+		req := &engine.PythonQueryRequest{Question: question}
+		resp := &engine.LegalQueryResponse{Answer: question, SearchResults: results}
+		cache.Put(req, resp)
+		return req
+	}
+	probation := answer("thử việc", engine.DocumentHit{ArticleNumber: "Dieu_25"})
+	wages := answer("lương thử việc", engine.DocumentHit{ArticleNumber: "Dieu_26"})
+	decree := answer("điều kiện lao động", engine.DocumentHit{DocumentID: "nd-145-2020", ArticleNumber: "Dieu_3"})
+
+	if n := cache.Invalidate([]string{"Dieu_25"}); n != 1 {
+		t.Errorf("Invalidate(Dieu_25) = %d, want 1", n)
+	}
+	if _, ok := cache.Get(probation); ok {
+		t.Error("answer citing the updated article is still cached")
+	}
+	if _, ok := cache.Get(wages); !ok {
+		t.Error("answer citing another article was invalidated")
+	}
+
+	// A repealed document drops every answer drawn from it
+	if n := cache.Invalidate([]string{"nd-145-2020", "Dieu_99"}); n != 1 {
+		t.Errorf("Invalidate(nd-145-2020) = %d, want 1", n)
+	}
+	if _, ok := cache.Get(decree); ok {
+		t.Error("answer drawn from the repealed document is still cached")
+	}
+
+	// Replacing an entry re-indexes it
+	answer("lương thử việc", engine.DocumentHit{ArticleNumber: "Dieu_90"})
+	if n := cache.Invalidate([]string{"Dieu_26"}); n != 0 {
+		t.Errorf("Invalidate of a replaced entry's old article = %d, want 0", n)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Documents != 1 || stats.Invalidated != 2 {
+		t.Errorf("stats = %+v, want 1 entry indexed by 1 document after 2 invalidations", stats)
+	}
+}
+
+// newTestRedisClient connects to srv like a server given its REDIS_URL
+func newTestRedisClient(t *testing.T, srv *miniredis.Miniredis) *redis.Client {
+	t.Helper()
+	client, err := newRedisClient("redis://" + srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSharedResponseCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	cache := NewSharedResponseCache(newTestRedisClient(t, srv), "test:", time.Hour, 10)
+
+	req := &engine.PythonQueryRequest{Question: "Thời gian thử việc tối đa bao nhiêu ngày?", TopK: 3}
+	cache.Put(req, &engine.LegalQueryResponse{
+		Answer:        "Không quá 180 ngày",
+		SearchResults: []engine.DocumentHit{{ArticleNumber: "Dieu_25"}},
+	})
+	if keys := srv.Keys(); len(keys) != 2 || keys[0] != "test:doc:Dieu_25" {
+		t.Fatalf("keys = %v, want the response and its document index", keys)
+	}
+
+	// The same question typed differently is a hit; other parameters are not
+	resp, ok := cache.Get(&engine.PythonQueryRequest{Question: "  thời gian thử việc TỐI ĐA bao nhiêu   ngày", TopK: 3})
+	if !ok || resp.Answer != "Không quá 180 ngày" || !resp.Cached || resp.CacheHit != CacheRedis {
+		t.Fatalf("Get = %+v %v, want the shared answer", resp, ok)
+	}
+	if _, ok := cache.Get(&engine.PythonQueryRequest{Question: req.Question, TopK: 5}); ok {
+		t.Error("a request with other parameters was answered from the cache")
+	}
+
+	// A second replica sees the entry and its invalidation
+	replica := NewSharedResponseCache(newTestRedisClient(t, srv), "test:", time.Hour, 10)
+	if n := replica.Invalidate([]string{"Dieu_25"}); n != 1 {
+		t.Errorf("Invalidate(Dieu_25) = %d, want 1", n)
+	}
+	if _, ok := cache.Get(req); ok {
+		t.Error("invalidated answer is still cached")
+	}
+
+	cache.Put(req, &engine.LegalQueryResponse{Answer: "Không quá 180 ngày"})
+	if stats := cache.Stats(); stats.Backend != CacheRedis || stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 1 shared entry, 1 hit and 2 misses", stats)
+	}
+	if n := cache.Purge(); n != 1 || len(srv.Keys()) != 0 {
+		t.Errorf("Purge = %d leaving %v, want everything dropped", n, srv.Keys())
+	}
+
+	// An unreachable Redis is a miss, not an error
+	srv.Close()
+	if _, ok := cache.Get(req); ok {
+		t.Error("Get succeeded without Redis")
+	}
+}
+
+func TestSharedResponseCacheFallback(t *testing.T) {
+	srv := miniredis.RunT(t)
+	cache := NewSharedResponseCache(newTestRedisClient(t, srv), "test:", time.Hour, 10)
+	clock := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	cache.now = func() time.Time { return clock }
+
+	req := &engine.PythonQueryRequest{Question: "Thời gian thử việc tối đa bao nhiêu ngày?", TopK: 3}
+	resp := &engine.LegalQueryResponse{
+		Answer:        "Không quá 180 ngày",
+		SearchResults: []engine.DocumentHit{{ArticleNumber: "Dieu_25"}},
+	}
+
+	// While Redis is down, responses are cached in memory
+	srv.SetError("ERR Redis is unavailable")
+	cache.Put(req, resp)
+	if got, ok := cache.Get(req); !ok || got.CacheHit != CacheMemory {
+		t.Fatalf("Get during the outage = %+v %v, want a memory hit", got, ok)
+	}
+	if stats := cache.Stats(); stats.Backend != CacheRedis || !stats.Fallback || stats.Entries != 1 {
+		t.Errorf("stats during the outage = %+v, want 1 entry in the fallback", stats)
+	}
+	if n := cache.Invalidate([]string{"Dieu_25"}); n != 1 {
+		t.Errorf("Invalidate during the outage = %d, want the fallback entry dropped", n)
+	}
+	cache.Put(req, resp)
+
+	// Redis is not retried before the retry interval, then the fallback is
+	// dropped once it answers
+	srv.SetError("")
+	if got, ok := cache.Get(req); !ok || got.CacheHit != CacheMemory {
+		t.Fatalf("Get before the retry = %+v %v, want a memory hit", got, ok)
+	}
+	clock = clock.Add(redisRetryInterval)
+	if _, ok := cache.Get(req); ok {
+		t.Error("answer cached during the outage was served after Redis recovered")
+	}
+	cache.Put(req, resp)
+	if got, ok := cache.Get(req); !ok || got.CacheHit != CacheRedis {
+		t.Errorf("Get after the recovery = %+v %v, want a Redis hit", got, ok)
+	}
+	if stats := cache.Stats(); stats.Fallback || stats.Entries != 1 {
+		t.Errorf("stats after the recovery = %+v, want Redis in use", stats)
+	}
+}
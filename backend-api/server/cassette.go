@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cassette modes
+const (
+	CassetteOff    = "off"
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// Cassette is one recorded engine interaction stored on disk
+type Cassette struct {
+	RecordedAt time.Time        `json:"recorded_at"`
+	DurationMs int64            `json:"duration_ms"`
+	Request    CassetteRequest  `json:"request"`
+	Response   CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is the recorded request sent to the engine
+type CassetteRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body"`
+}
+
+// CassetteResponse is the exact response returned by the engine
+type CassetteResponse struct {
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// cassetteTransport records engine HTTP traffic to disk or replays it from
+// previously recorded cassettes, so production issues can be reproduced
+// offline with the exact engine responses
+type cassetteTransport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+}
+
+func newCassetteTransport(mode, dir string, next http.RoundTripper) (*cassetteTransport, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if mode == CassetteRecord {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cassette dir: %w", err)
+		}
+	}
+	return &cassetteTransport{mode: mode, dir: dir, next: next}, nil
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	path := filepath.Join(t.dir, cassetteKey(req.Method, req.URL.Path, body)+".json")
+
+	if t.mode == CassetteReplay {
+		return t.replay(req, path)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	cassette := Cassette{
+		RecordedAt: start.UTC(),
+		DurationMs: time.Since(start).Milliseconds(),
+		Request: CassetteRequest{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Body:   string(body),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    map[string]string{"Content-Type": resp.Header.Get("Content-Type")},
+			Body:       string(respBody),
+		},
+	}
+	if err := writeCassette(path, &cassette); err != nil {
+		// Recording is best effort; never fail the live request because of it
+		slog.Warn("Failed to record cassette", "path", path, "error", err)
+	}
+
+	return cassette.Response.toHTTP(req), nil
+}
+
+func (t *cassetteTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no cassette recorded for %s %s (%s): %w", req.Method, req.URL.Path, filepath.Base(path), err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cassette %s: %w", path, err)
+	}
+
+	return cassette.Response.toHTTP(req), nil
+}
+
+func (r CassetteResponse) toHTTP(req *http.Request) *http.Response {
+	header := make(http.Header)
+	for k, v := range r.Headers {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+func writeCassette(path string, cassette *Cassette) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// cassetteKey identifies an interaction by method, path and request body
+func cassetteKey(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	name := strings.Trim(strings.ReplaceAll(path, "/", "_"), "_")
+	return fmt.Sprintf("%s_%s_%s", strings.ToLower(method), name, hex.EncodeToString(h.Sum(nil))[:16])
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// ChatConfig controls the chat endpoint. The last MaxTurns answered turns
+// of a connection are sent to the engine with each question; a connection
+// that sends nothing for IdleTimeout is closed.
+type ChatConfig struct {
+	MaxTurns        int
+	IdleTimeout     time.Duration
+	MaxMessageBytes int
+}
+
+// Chat message types, named like the events of the streaming endpoint
+const (
+	ChatCitations = "citations"
+	ChatToken     = "token"
+	ChatAnswer    = "answer"
+	ChatError     = "error"
+)
+
+// chatSessionKey stores the session of a chat connection in its context
+const chatSessionKey = "chat_session"
+
+// chatWriteTimeout bounds how long a message may wait on a client that
+// stopped reading
+const chatWriteTimeout = 10 * time.Second
+
+// ChatMessage is a message the server sends on a chat connection. Turn
+// numbers the client's messages from 1; the fields of the citations,
+// token and error events are inlined.
+type ChatMessage struct {
+	Type string `json:"type"`
+	Turn int    `json:"turn"`
+	*CitationsEvent
+	Text     string                     `json:"text,omitempty"`
+	Response *engine.LegalQueryResponse `json:"response,omitempty"`
+	*ErrorResponse
+}
+
+// chatSession is the conversation of one chat connection. Turns are
+// answered one at a time, in the order the client sent them.
+type chatSession struct {
+	conn     *websocket.Conn
+	maxTurns int
+	tokens   bool
+
+	// mu serializes writes and guards the fields below. Engine events may
+	// arrive from other goroutines, e.g. a hedged request, so events of a
+	// turn arriving after its answer are dropped.
+	mu        sync.Mutex
+	turn      int
+	streaming bool
+	sent      bool
+	history   []engine.ChatTurn
+}
+
+func (s *chatSession) sendLocked(msg ChatMessage) {
+	// A failed write ends the session at the next read
+	s.conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+	websocket.JSON.Send(s.conn, msg)
+}
+
+// fail answers the current turn with an error
+func (s *chatSession) fail(resp ErrorResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(ChatMessage{Type: ChatError, Turn: s.turn, ErrorResponse: &resp})
+}
+
+func (s *chatSession) onEvent(event engine.StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.streaming {
+		return
+	}
+	switch {
+	case event.Type == engine.EventRetrieval && !s.sent:
+		s.sent = true
+		citations := citationsFrom(event.SearchResults, event.WebResults)
+		s.sendLocked(ChatMessage{Type: ChatCitations, Turn: s.turn, CitationsEvent: &citations})
+	case event.Type == engine.EventToken && s.sent && s.tokens:
+		s.sendLocked(ChatMessage{Type: ChatToken, Turn: s.turn, Text: event.Text})
+	}
+}
+
+// serve answers the questions of the connection until the client closes it
+// or stays idle
+func (s *chatSession) serve(c *gin.Context, deps queryDeps, idle time.Duration) {
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(idle))
+		var req LegalQueryRequest
+		err := websocket.JSON.Receive(s.conn, &req)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err == nil:
+			s.ask(c, deps, &req)
+		case errors.Is(err, websocket.ErrFrameTooLarge):
+			s.skip()
+			abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("Message exceeds %d bytes", s.conn.MaxPayloadBytes))
+		case errors.As(err, &syntaxErr) || errors.As(err, &typeErr):
+			s.skip()
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid message format: %v", err))
+		default:
+			return
+		}
+	}
+}
+
+// skip starts a turn that is not answered
+func (s *chatSession) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turn++
+}
+
+// ask answers a question with the earlier turns of the session as history
+func (s *chatSession) ask(c *gin.Context, deps queryDeps, req *LegalQueryRequest) {
+	s.mu.Lock()
+	s.turn++
+	s.streaming, s.sent = true, false
+	history := slices.Clone(s.history)
+	s.mu.Unlock()
+
+	resp, ok := deps.answerQuery(c, req, history, s.onEvent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streaming = false
+	if !ok {
+		return
+	}
+
+	// Cached and sandboxed answers come without retrieval events
+	if !s.sent && !resp.NeedsClarification {
+		citations := citationsFrom(resp.SearchResults, resp.WebResults)
+		s.sendLocked(ChatMessage{Type: ChatCitations, Turn: s.turn, CitationsEvent: &citations})
+	}
+	s.sendLocked(ChatMessage{Type: ChatAnswer, Turn: s.turn, Response: resp})
+
+	// Questions pending clarification are not part of the conversation yet
+	if resp.NeedsClarification || s.maxTurns == 0 {
+		return
+	}
+	s.history = append(s.history, engine.ChatTurn{Question: req.Question, Answer: resp.Answer})
+	if len(s.history) > s.maxTurns {
+		s.history = slices.Clone(s.history[len(s.history)-s.maxTurns:])
+	}
+}
+
+// chatOriginAllowed applies the CORS origins to chat connections, which
+// browsers open across origins without a preflight. With CORS off only the
+// server's own host may connect. Clients that are not browsers send no
+// Origin.
+func chatOriginAllowed(origin string, cors middleware.CORSConfig, host string) bool {
+	switch {
+	case origin == "":
+		return true
+	case cors.Off():
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == host
+	}
+	return cors.AllowsOrigin(origin)
+}
+
+// Handlers
+
+// chatHandler holds a multi-turn chat on a WebSocket connection. Every
+// message the client sends is a legal query answered like a streamed
+// one, with citations, token and answer messages, or an error message;
+// the session continues after errors. Earlier answered turns are sent to
+// the engine as history so follow-up questions can refer to them.
+func chatHandler(deps queryDeps, config ChatConfig, cors middleware.CORSConfig) gin.HandlerFunc {
+	tokens := len(deps.postProcessors.Names()) == 0
+	return func(c *gin.Context) {
+		if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			abortWithError(c, ErrCodeInvalidRequest, "The chat endpoint requires a WebSocket connection")
+			return
+		}
+		if origin := c.GetHeader("Origin"); !chatOriginAllowed(origin, cors, c.Request.Host) {
+			abortWithError(c, ErrCodeForbidden, fmt.Sprintf("Origin %q may not open chat connections", origin))
+			return
+		}
+
+		websocket.Server{Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = config.MaxMessageBytes
+			session := &chatSession{conn: conn, maxTurns: config.MaxTurns, tokens: tokens}
+			c.Set(chatSessionKey, session)
+			slog.InfoContext(c.Request.Context(), "Chat session opened", "client_ip", c.ClientIP())
+			session.serve(c, deps, config.IdleTimeout)
+			slog.InfoContext(c.Request.Context(), "Chat session closed", "client_ip", c.ClientIP(), "turns", session.turn)
+		}}.ServeHTTP(c.Writer, c.Request)
+	}
+}
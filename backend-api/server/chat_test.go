@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestChatSession(t *testing.T) {
+	t.Setenv("CHAT_MAX_TURNS", "1")
+	t.Setenv("CHAT_MAX_MESSAGE_BYTES", "1024")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Không quá 180 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", ArticleNumber: "Dieu_25"}},
+		Iterations:    1,
+	}}
+	ts := httptest.NewServer(newTestServer(t, Options{Engine: stub}).Handler())
+	defer ts.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/chat", "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	receive := func() ChatMessage {
+		t.Helper()
+		var msg ChatMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		return msg
+	}
+	ask := func(question string) ChatMessage {
+		t.Helper()
+		if err := websocket.JSON.Send(conn, LegalQueryRequest{Question: question}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		if msg := receive(); msg.Type != ChatCitations || msg.CitationsEvent == nil || len(msg.Sources) != 1 {
+			t.Fatalf("first message = %+v, want the citations", msg)
+		}
+		msg := receive()
+		if msg.Type != ChatAnswer || msg.Response == nil {
+			t.Fatalf("second message = %+v, want the answer", msg)
+		}
+		return msg
+	}
+
+	first := "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày?"
+	second := "Còn đối với công việc cần trình độ cao đẳng thì thời gian thử việc là bao lâu?"
+	if msg := ask(first); msg.Turn != 1 {
+		t.Errorf("first answer turn = %d, want 1", msg.Turn)
+	}
+
+	// Errors answer their turn and keep the session open
+	websocket.Message.Send(conn, `{"question": 42}`)
+	if msg := receive(); msg.Type != ChatError || msg.Turn != 2 || msg.ErrorResponse == nil || msg.Code != ErrCodeInvalidRequest {
+		t.Errorf("malformed message = %+v, want %s on turn 2", msg, ErrCodeInvalidRequest)
+	}
+	websocket.Message.Send(conn, `{"question": "`+strings.Repeat("a", 2000)+`"}`)
+	if msg := receive(); msg.Type != ChatError || msg.ErrorResponse == nil || msg.Code != ErrCodePayloadTooLarge {
+		t.Errorf("oversized message = %+v, want %s", msg, ErrCodePayloadTooLarge)
+	}
+
+	msg := ask(second)
+	if msg.Turn != 4 || !strings.Contains(strings.Join(msg.Response.Meta.Features, ","), FeatureChatHistory) {
+		t.Errorf("follow-up answer = turn %d, meta %+v, want turn 4 with %s", msg.Turn, msg.Response.Meta, FeatureChatHistory)
+	}
+	ask("Người lao động có được hưởng lương trong thời gian thử việc hay không?")
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.requests) != 3 || len(stub.requests[0].History) != 0 {
+		t.Fatalf("engine requests = %+v, want 3, the first without history", stub.requests)
+	}
+	if history := stub.requests[1].History; len(history) != 1 || history[0].Question != first || history[0].Answer != "Không quá 180 ngày." {
+		t.Errorf("follow-up history = %+v, want the first turn", history)
+	}
+	// Only CHAT_MAX_TURNS turns are kept
+	if history := stub.requests[2].History; len(history) != 1 || history[0].Question != second {
+		t.Errorf("third history = %+v, want only the second turn", history)
+	}
+}
+
+func TestChatRequiresWebSocket(t *testing.T) {
+	t.Setenv("CORS_ALLOW_ORIGIN", "https://app.example.com")
+	h := newTestServer(t, Options{Engine: &stubEngine{}}).Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/chat", nil))
+	if code := decodeError(t, rec).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("plain request = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/chat", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if code := decodeError(t, rec).Code; code != ErrCodeForbidden {
+		t.Errorf("foreign origin = %s, want %s", code, ErrCodeForbidden)
+	}
+}
+
+func TestChatAPIKeyParameter(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("REQUIRE_API_KEY", "true")
+	h := newTestServer(t, Options{Engine: &stubEngine{}}).Handler()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/chat"
+
+	if _, err := websocket.Dial(url, "", ts.URL); err == nil {
+		t.Error("dial without an API key succeeded, want the handshake refused")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(`{"name": "web chat"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var created CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Key == "" {
+		t.Fatalf("create API key = %d %s", rec.Code, rec.Body.String())
+	}
+	conn, err := websocket.Dial(url+"?api_key="+created.Key, "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial with the api_key parameter: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Citation styles of answers and memos
+const (
+	// CitationInline leaves citations in the text as the engine wrote them
+	CitationInline = "inline"
+
+	// CitationFootnotes numbers every citation with its own footnote
+	CitationFootnotes = "footnotes"
+
+	// CitationEndnotes numbers every distinct source once, so repeated
+	// citations share a note
+	CitationEndnotes = "endnotes"
+
+	// CitationVNFormal follows the footnote convention of Vietnamese legal
+	// writing, akin to OSCOLA: the first citation of a document is given in
+	// full, an immediate repeat as "Tlđd" (tài liệu đã dẫn) and a later one
+	// refers back to the note that cited it first
+	CitationVNFormal = "vn_formal"
+)
+
+// citationStyles lists the styles in the order documented
+var citationStyles = []string{CitationInline, CitationFootnotes, CitationEndnotes, CitationVNFormal}
+
+func validCitationStyle(style string) bool {
+	for _, s := range citationStyles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// citeSource is a source a citation refers to
+type citeSource struct {
+	// key identifies the source, so repeated citations share an endnote;
+	// document identifies the document it belongs to
+	key      string
+	document string
+
+	title    string
+	number   string
+	pinpoint string
+	url      string
+}
+
+// citeMark is a citation in a text. When replace is set the span is a
+// marker such as [3] that the note replaces; otherwise the span is the
+// cited text, which the note marker follows.
+type citeMark struct {
+	start, end int
+	sources    []citeSource
+	replace    bool
+}
+
+// formatCitations rewrites the marks of text in the given style and returns
+// the text with note markers such as [^2], and the notes in order. Inline
+// style leaves the text unchanged.
+func formatCitations(text string, marks []citeMark, style string) (string, []string) {
+	if style == "" || style == CitationInline || len(marks) == 0 {
+		return text, nil
+	}
+
+	var b strings.Builder
+	var notes []string
+	noteOf := make(map[string]int)
+	firstNote := make(map[string]int)
+	previous := citeSource{}
+	previousDocument := ""
+	last := 0
+	for _, m := range marks {
+		if m.start < last {
+			continue
+		}
+		if m.replace {
+			b.WriteString(strings.TrimRight(text[last:m.start], " "))
+		} else {
+			b.WriteString(text[last:m.end])
+		}
+		last = m.end
+
+		for _, s := range m.sources {
+			if n, ok := noteOf[s.key]; ok && style == CitationEndnotes {
+				fmt.Fprintf(&b, "[^%d]", n)
+				continue
+			}
+
+			var note string
+			switch {
+			case style != CitationVNFormal:
+				note = joinNoteParts(s.pinpoint, s.title, s.url)
+			case s.document == previousDocument && s.pinpoint == previous.pinpoint:
+				note = "Tlđd."
+			case s.document == previousDocument:
+				note = "Tlđd, " + s.pinpoint + "."
+			case firstNote[s.document] > 0:
+				note = joinNoteParts(fmt.Sprintf("%s, tlđd (chú thích %d)", s.title, firstNote[s.document]), s.pinpoint) + "."
+			default:
+				title := s.title
+				if s.number != "" && !strings.Contains(title, s.number) {
+					title += " số " + s.number
+				}
+				note = joinNoteParts(title, s.pinpoint, s.url) + "."
+			}
+			notes = append(notes, note)
+			n := len(notes)
+			noteOf[s.key] = n
+			if firstNote[s.document] == 0 {
+				firstNote[s.document] = n
+			}
+			previous, previousDocument = s, s.document
+			fmt.Fprintf(&b, "[^%d]", n)
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String(), notes
+}
+
+func joinNoteParts(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+// appendNotes adds the note definitions after the text, as markdown
+// footnotes that the document renderers set as notes
+func appendNotes(text string, notes []string) string {
+	if len(notes) == 0 {
+		return text
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(text, "\n "))
+	b.WriteString("\n")
+	for i, note := range notes {
+		fmt.Fprintf(&b, "\n[^%d]: %s", i+1, note)
+	}
+	return b.String()
+}
+
+// answerCiteMarks marks the laws an answer cites, with the links resolved
+// for them
+func answerCiteMarks(resolver *LinkResolver, answer string, citations []engine.Citation) []citeMark {
+	urls := make(map[string]string)
+	for _, c := range citations {
+		urls[normalizeDocumentNumber(c.Document)+"|"+c.Article] = c.URL
+	}
+	var marks []citeMark
+	for _, m := range resolver.mentions(answer) {
+		title := m.number
+		if m.doc != nil {
+			title = m.doc.Title
+		}
+		marks = append(marks, citeMark{
+			start: m.start,
+			end:   m.end,
+			sources: []citeSource{{
+				key:      m.key(),
+				document: normalizeDocumentNumber(m.number),
+				title:    title,
+				number:   m.number,
+				pinpoint: m.article,
+				url:      urls[m.key()],
+			}},
+		})
+	}
+	return marks
+}
+
+// memoCiteMarks marks the numbered citations of a memo, such as [1, 4]
+func memoCiteMarks(text string, sources []MemoSource) []citeMark {
+	var marks []citeMark
+	for _, m := range citationPattern.FindAllStringSubmatchIndex(text, -1) {
+		mark := citeMark{start: m[0], end: m[1], replace: true}
+		for _, n := range strings.Split(text[m[2]:m[3]], ",") {
+			number, _ := strconv.Atoi(strings.TrimSpace(n))
+			if number < 1 || number > len(sources) {
+				continue
+			}
+			s := sources[number-1]
+			key := strconv.Itoa(number)
+			mark.sources = append(mark.sources, citeSource{key: key, document: key, title: s.Title, url: s.URL})
+		}
+		marks = append(marks, mark)
+	}
+	return marks
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestFormatCitations(t *testing.T) {
+	resolver, err := NewLinkResolver(LawLinkConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewLinkResolver: %v", err)
+	}
+	resp := &engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thử việc không quá 60 ngày. Điều 25 áp dụng cho mọi hợp đồng; " +
+			"Điều 7 của Nghị định 145/2020/NĐ-CP hướng dẫn thêm, còn Điều 27 quy định kết thúc thử việc.",
+	}
+	citations := resolver.Resolve(context.Background(), resp)
+	marks := answerCiteMarks(resolver, resp.Answer, citations)
+	if len(marks) != 4 {
+		t.Fatalf("marks = %+v, want 4", marks)
+	}
+
+	text, notes := formatCitations(resp.Answer, marks, CitationInline)
+	if text != resp.Answer || notes != nil {
+		t.Errorf("inline = %q %v, want the answer unchanged", text, notes)
+	}
+
+	text, notes = formatCitations(resp.Answer, marks, CitationFootnotes)
+	if !strings.HasPrefix(text, "Theo Điều 25 Bộ luật Lao động 2019[^1], thử việc") || !strings.Contains(text, "Điều 27[^4] quy định") {
+		t.Errorf("footnotes = %q, want a marker after each citation", text)
+	}
+	if len(notes) != 4 || !strings.HasPrefix(notes[0], "Điều 25, Bộ luật Lao động 2019, https://") {
+		t.Errorf("footnotes = %q, want one note per citation with its link", notes)
+	}
+
+	text, notes = formatCitations(resp.Answer, marks, CitationEndnotes)
+	if !strings.Contains(text, "Điều 25[^1] áp dụng") || !strings.Contains(text, "Điều 27[^3] quy định") || len(notes) != 3 {
+		t.Errorf("endnotes = %q %q, want repeated citations to share a note", text, notes)
+	}
+
+	_, notes = formatCitations(resp.Answer, marks, CitationVNFormal)
+	want := []string{"Bộ luật Lao động 2019 số 45/2019/QH14, Điều 25, ", "Tlđd.", "Nghị định 145/2020/NĐ-CP hướng dẫn", "Bộ luật Lao động 2019, tlđd (chú thích 1), Điều 27."}
+	if len(notes) != len(want) {
+		t.Fatalf("vn_formal = %q, want %d notes", notes, len(want))
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(notes[i], w) {
+			t.Errorf("vn_formal note %d = %q, want it to start with %q", i+1, notes[i], w)
+		}
+	}
+
+	answer := appendNotes("Thử việc[^1].", []string{"Tlđd."})
+	if answer != "Thử việc[^1].\n\n[^1]: Tlđd." {
+		t.Errorf("appendNotes = %q", answer)
+	}
+}
+
+func TestFormatMemoCitations(t *testing.T) {
+	sources := []MemoSource{
+		{Number: 1, Title: "Điều 25. Thời gian thử việc"},
+		{Number: 2, Title: "Hỏi đáp thử việc", URL: "https://example.vn/thu-viec"},
+	}
+	text := "Thử việc tối đa 60 ngày [1, 2]. Người quản lý được 180 ngày [2]."
+	text, notes := formatCitations(text, memoCiteMarks(text, sources), CitationEndnotes)
+	if text != "Thử việc tối đa 60 ngày[^1][^2]. Người quản lý được 180 ngày[^2]." {
+		t.Errorf("text = %q, want the markers replaced by notes", text)
+	}
+	if len(notes) != 2 || notes[1] != "Hỏi đáp thử việc, https://example.vn/thu-viec" {
+		t.Errorf("notes = %q, want the two sources", notes)
+	}
+}
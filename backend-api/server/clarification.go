@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PendingQuery is a query held back until the user answers the clarifying
+// questions returned for it
+type PendingQuery struct {
+	ID        string
+	TenantID  string
+	Request   LegalQueryRequest
+	ExpiresAt time.Time
+}
+
+var errPendingQueryNotFound = errors.New("pending query not found")
+
+// PendingQueryStore keeps pending queries in memory for a limited time
+type PendingQueryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	pending map[string]*PendingQuery
+}
+
+func NewPendingQueryStore(ttl time.Duration) *PendingQueryStore {
+	return &PendingQueryStore{
+		ttl:     ttl,
+		pending: make(map[string]*PendingQuery),
+	}
+}
+
+func (s *PendingQueryStore) Put(tenantID string, req LegalQueryRequest) *PendingQuery {
+	now := time.Now()
+	p := &PendingQuery{
+		ID:        "pq_" + randomHex(12),
+		TenantID:  tenantID,
+		Request:   req,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, old := range s.pending {
+		if now.After(old.ExpiresAt) {
+			delete(s.pending, id)
+		}
+	}
+	s.pending[p.ID] = p
+	return p
+}
+
+// Take removes and returns an unexpired pending query owned by tenantID. A
+// pending query can be answered only once.
+func (s *PendingQueryStore) Take(id, tenantID string) (*PendingQuery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[id]
+	if !ok || p.TenantID != tenantID || time.Now().After(p.ExpiresAt) {
+		return nil, errPendingQueryNotFound
+	}
+	delete(s.pending, id)
+	return p, nil
+}
+
+// applyClarification replaces a follow-up request with the pending query it
+// answers, appending the user's clarification to the original question
+func applyClarification(req *LegalQueryRequest, pending *PendingQuery) {
+	clarification := strings.TrimSpace(req.Clarification)
+	*req = pending.Request
+	req.Question = fmt.Sprintf("%s\n(Làm rõ: %s)", strings.TrimSpace(req.Question), clarification)
+}
+
+// clarificationRule flags questions that mention a broad topic without any of
+// the qualifiers that would say which part of it is meant
+type clarificationRule struct {
+	topic      *regexp.Regexp
+	qualifiers *regexp.Regexp
+	questions  []string
+}
+
+var clarificationRules = []clarificationRule{
+	{
+		topic:      regexp.MustCompile(`chấm dứt|nghỉ việc|thôi việc|sa thải`),
+		qualifiers: regexp.MustCompile(`đơn phương|thỏa thuận|sa thải|kỷ luật|trợ cấp|báo trước|hết hạn`),
+		questions: []string{
+			"Người lao động tự nghỉ việc hay bị người sử dụng lao động cho nghỉ?",
+			"Bạn quan tâm đến thời hạn báo trước hay trợ cấp thôi việc?",
+		},
+	},
+	{
+		topic:      regexp.MustCompile(`nghỉ`),
+		qualifiers: regexp.MustCompile(`phép năm|hằng năm|lễ|tết|thai sản|ốm|việc riêng|không lương|hưu|giữa giờ|hằng tuần`),
+		questions: []string{
+			"Bạn muốn hỏi về nghỉ phép năm, nghỉ lễ tết, nghỉ việc riêng hay nghỉ thai sản?",
+			"Bạn là người lao động hay người sử dụng lao động?",
+		},
+	},
+	{
+		topic:      regexp.MustCompile(`lương`),
+		qualifiers: regexp.MustCompile(`tối thiểu|làm thêm|ngừng việc|thử việc|thôi việc|ban đêm|làm đêm|trả lương|chậm|khấu trừ|tạm ứng`),
+		questions: []string{
+			"Bạn hỏi về lương tối thiểu vùng, tiền lương làm thêm giờ hay thời hạn trả lương?",
+		},
+	},
+	{
+		topic:      regexp.MustCompile(`hợp đồng`),
+		qualifiers: regexp.MustCompile(`thử việc|chấm dứt|thời hạn|giao kết|ký|gia hạn|sửa đổi|vô hiệu|loại`),
+		questions: []string{
+			"Bạn hỏi về loại hợp đồng lao động, việc giao kết hay việc chấm dứt hợp đồng?",
+		},
+	},
+}
+
+var genericClarifyingQuestions = []string{
+	"Bạn có thể mô tả cụ thể hơn tình huống của mình không?",
+	"Câu hỏi liên quan đến quyền lợi của người lao động hay nghĩa vụ của người sử dụng lao động?",
+}
+
+const (
+	// minQuestionTokens is the number of content words below which a
+	// question is always considered too vague to answer
+	minQuestionTokens = 3
+
+	// maxAmbiguousTokens limits the topic rules to short questions; longer
+	// questions usually carry enough context for the engine
+	maxAmbiguousTokens = 8
+)
+
+// detectAmbiguity returns clarifying questions when a question is too vague
+// to answer well, or nil when it can be sent to the engine as is
+func detectAmbiguity(question string) []string {
+	tokens := contentTokens(question)
+	if len(tokens) < minQuestionTokens {
+		return genericClarifyingQuestions
+	}
+	if len(tokens) > maxAmbiguousTokens {
+		return nil
+	}
+
+	lower := strings.ToLower(question)
+	for _, rule := range clarificationRules {
+		if rule.topic.MatchString(lower) && !rule.qualifiers.MatchString(lower) {
+			return rule.questions
+		}
+	}
+	return nil
+}
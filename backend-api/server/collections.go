@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// Collection is a document collection the engine searches. Collections
+// without access rules are visible to every caller.
+type Collection struct {
+	Name        string            `yaml:"name"`
+	Title       string            `yaml:"title"`
+	Description string            `yaml:"description"`
+	Access      *CollectionAccess `yaml:"access"`
+}
+
+// CollectionAccess restricts who may search a collection. Tenants limits
+// the tenants whose users see the collection; Users and Roles, when set,
+// further limit it to those users and the holders of those roles. Users
+// are those of API keys bound to a user, never the X-User-ID header.
+type CollectionAccess struct {
+	Tenants []string `yaml:"tenants"`
+	Users   []string `yaml:"users"`
+	Roles   []string `yaml:"roles"`
+}
+
+// CollectionCatalog lists the collections callers may search. Roles maps
+// role names to user IDs; a tenant's own roles replace them for its users.
+type CollectionCatalog struct {
+	Roles       map[string][]string `yaml:"roles"`
+	Collections []*Collection       `yaml:"collections"`
+}
+
+// LoadCollections reads the collection catalog of a YAML file; without a
+// path every collection is visible to every caller
+func LoadCollections(path string) (*CollectionCatalog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collections: %w", err)
+	}
+	return parseCollections(data)
+}
+
+func parseCollections(data []byte) (*CollectionCatalog, error) {
+	catalog := &CollectionCatalog{}
+	if err := yaml.UnmarshalWithOptions(data, catalog, yaml.Strict()); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collections: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, collection := range catalog.Collections {
+		if collection == nil || !collectionNamePattern.MatchString(collection.Name) {
+			return nil, fmt.Errorf("collection %d: name must be 1-64 letters, digits, '-' or '_'", i+1)
+		}
+		if seen[collection.Name] {
+			return nil, fmt.Errorf("collection %q is defined twice", collection.Name)
+		}
+		seen[collection.Name] = true
+		if collection.Access == nil {
+			continue
+		}
+		for _, role := range collection.Access.Roles {
+			if _, ok := catalog.Roles[role]; !ok {
+				return nil, fmt.Errorf("collection %q: unknown role %q", collection.Name, role)
+			}
+		}
+	}
+	return catalog, nil
+}
+
+// visible reports whether the caller may search the collection
+func (catalog *CollectionCatalog) visible(collection *Collection, tenant Tenant, user string) bool {
+	access := collection.Access
+	if access == nil {
+		return true
+	}
+	if len(access.Tenants) > 0 && !slices.Contains(access.Tenants, tenant.ID) {
+		return false
+	}
+	if len(access.Users) == 0 && len(access.Roles) == 0 {
+		return true
+	}
+	if user == "" {
+		return false
+	}
+	if slices.Contains(access.Users, user) {
+		return true
+	}
+	roles := catalog.Roles
+	if tenant.Settings.Roles != nil {
+		roles = tenant.Settings.Roles
+	}
+	return slices.ContainsFunc(access.Roles, func(role string) bool {
+		return slices.Contains(roles[role], user)
+	})
+}
+
+// Visible returns the collections the caller may search, in catalog order
+func (catalog *CollectionCatalog) Visible(tenant Tenant, user string) []*Collection {
+	collections := []*Collection{}
+	if catalog == nil {
+		return collections
+	}
+	for _, collection := range catalog.Collections {
+		if catalog.visible(collection, tenant, user) {
+			collections = append(collections, collection)
+		}
+	}
+	return collections
+}
+
+// Hidden returns the names of the collections the caller may not search,
+// which the engine leaves out of queries naming no collection
+func (catalog *CollectionCatalog) Hidden(tenant Tenant, user string) []string {
+	if catalog == nil {
+		return nil
+	}
+	var names []string
+	for _, collection := range catalog.Collections {
+		if !catalog.visible(collection, tenant, user) {
+			names = append(names, collection.Name)
+		}
+	}
+	return names
+}
+
+// Has reports whether the catalog lists a collection of that name
+func (catalog *CollectionCatalog) Has(name string) bool {
+	return catalog != nil && slices.ContainsFunc(catalog.Collections, func(c *Collection) bool { return c.Name == name })
+}
+
+// Allows reports whether the caller may search the named collection. The
+// engine's default collection, named by an empty name, is always allowed;
+// with a catalog, collections missing from it are not.
+func (catalog *CollectionCatalog) Allows(name string, tenant Tenant, user string) bool {
+	if catalog == nil || name == "" {
+		return true
+	}
+	for _, collection := range catalog.Collections {
+		if collection.Name == name {
+			return catalog.visible(collection, tenant, user)
+		}
+	}
+	return false
+}
+
+// checkCollection writes the error response and returns false when the
+// caller may not search the collection of the query. Hidden and unknown
+// collections get the same error, so that callers cannot probe for them.
+// A private collection of the caller's tenant is searched in the tenant's
+// namespace; a query naming no collection leaves out the collections the
+// caller may not search. Access follows the tenant and user of the caller's
+// API key, never the headers.
+func (d queryDeps) checkCollection(c *gin.Context, req *LegalQueryRequest) bool {
+	tenant, ok := authenticatedTenant(c)
+	req.namespace = ""
+	req.excludeCollections = nil
+	if ok && req.Collection != "" && d.privateDocs.Has(tenant.ID, req.Collection) {
+		req.namespace = privateNamespace(tenant.ID)
+		return true
+	}
+	user := authenticatedUser(c)
+	if !d.collections.Allows(req.Collection, tenant, user) {
+		abortWithError(c, ErrCodeCollectionNotFound, fmt.Sprintf("Collection %q not found", req.Collection))
+		return false
+	}
+	if req.Collection == "" {
+		req.excludeCollections = d.collections.Hidden(tenant, user)
+	}
+	return true
+}
+
+// Handlers
+
+// CollectionSummary describes a collection the caller may search
+type CollectionSummary struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Restricted  bool   `json:"restricted"`
+
+	// Private marks a private collection of the caller's tenant
+	Private bool `json:"private"`
+}
+
+func listCollectionsHandler(catalog *CollectionCatalog, private *PrivateCollectionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := authenticatedTenant(c)
+		summaries := []CollectionSummary{}
+		if ok {
+			for _, pc := range private.List(tenant.ID) {
+				summaries = append(summaries, CollectionSummary{Name: pc.Name, Title: pc.Title, Restricted: true, Private: true})
+			}
+		}
+		for _, collection := range catalog.Visible(tenant, authenticatedUser(c)) {
+			summaries = append(summaries, CollectionSummary{
+				Name:        collection.Name,
+				Title:       collection.Title,
+				Description: collection.Description,
+				Restricted:  collection.Access != nil,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"collections": summaries})
+	}
+}
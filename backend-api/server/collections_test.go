@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const testCollections = `
+roles:
+  partner: [minh]
+collections:
+  - name: luat_lao_dong
+    title: Bộ luật Lao động
+  - name: internal_memos
+    title: Bản ghi nhớ nội bộ
+    access:
+      roles: [partner]
+      users: [hoa]
+  - name: acme_contracts
+    access:
+      tenants: [acme]
+`
+
+func TestCollectionAccess(t *testing.T) {
+	catalog, err := parseCollections([]byte(testCollections))
+	if err != nil {
+		t.Fatalf("parseCollections: %v", err)
+	}
+	acme := Tenant{ID: "acme"}
+	partners := Tenant{ID: "acme", Settings: TenantSettings{Roles: map[string][]string{"partner": {"lan"}}}}
+	for _, tc := range []struct {
+		collection string
+		tenant     Tenant
+		user       string
+		want       bool
+	}{
+		{"", Tenant{}, "", true},
+		{"luat_lao_dong", Tenant{}, "", true},
+		{"unknown", Tenant{}, "minh", false},
+		{"internal_memos", Tenant{}, "minh", true},
+		{"internal_memos", Tenant{}, "hoa", true},
+		{"internal_memos", Tenant{}, "lan", false},
+		{"internal_memos", Tenant{}, "", false},
+		{"internal_memos", partners, "lan", true},
+		{"internal_memos", partners, "minh", false},
+		{"acme_contracts", acme, "", true},
+		{"acme_contracts", Tenant{ID: "globex"}, "minh", false},
+	} {
+		if got := catalog.Allows(tc.collection, tc.tenant, tc.user); got != tc.want {
+			t.Errorf("Allows(%q, %q, %q) = %v, want %v", tc.collection, tc.tenant.ID, tc.user, got, tc.want)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"invalid name":  "collections:\n  - name: nội bộ\n",
+		"duplicate":     "collections:\n  - name: a\n  - name: a\n",
+		"unknown role":  "collections:\n  - name: a\n    access:\n      roles: [partner]\n",
+		"unknown field": "collections:\n  - name: a\n    access:\n      groups: [partner]\n",
+	} {
+		if _, err := parseCollections([]byte(data)); err == nil {
+			t.Errorf("%s: parseCollections succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRestrictedCollections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.yaml")
+	if err := os.WriteFile(path, []byte(testCollections), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COLLECTIONS_FILE", path)
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo bản ghi nhớ nội bộ ...", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	minh := userKey(t, h, "minh")
+
+	var listed struct {
+		Collections []CollectionSummary `json:"collections"`
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/collections", nil).Body.Bytes(), &listed)
+	if len(listed.Collections) != 1 || listed.Collections[0].Name != "luat_lao_dong" {
+		t.Errorf("collections of lan = %+v, want only luat_lao_dong", listed.Collections)
+	}
+	// Users are those of API keys, so claiming one in X-User-ID gets nothing
+	json.Unmarshal(doAs(t, h, "minh", http.MethodGet, "/api/collections", nil).Body.Bytes(), &listed)
+	if len(listed.Collections) != 1 {
+		t.Errorf("collections claimed for minh = %+v, want only luat_lao_dong", listed.Collections)
+	}
+	json.Unmarshal(doWithKey(t, h, minh, http.MethodGet, "/api/collections", nil).Body.Bytes(), &listed)
+	if len(listed.Collections) != 2 || !listed.Collections[1].Restricted {
+		t.Errorf("collections of minh = %+v, want luat_lao_dong and internal_memos", listed.Collections)
+	}
+
+	query := LegalQueryRequest{Question: "Bản ghi nhớ nội bộ về thử việc nói gì?", Collection: "internal_memos"}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, "/api/legal-query", query)).Code; code != ErrCodeCollectionNotFound {
+		t.Errorf("query by lan = %s, want %s", code, ErrCodeCollectionNotFound)
+	}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, "/api/legal-query/async", query)).Code; code != ErrCodeCollectionNotFound {
+		t.Errorf("async query by lan = %s, want %s", code, ErrCodeCollectionNotFound)
+	}
+	if len(stub.requests) != 0 {
+		t.Errorf("engine got %d queries for a hidden collection", len(stub.requests))
+	}
+	if code := decodeError(t, doAs(t, h, "minh", http.MethodPost, "/api/legal-query", query)).Code; code != ErrCodeCollectionNotFound {
+		t.Errorf("query claimed for minh = %s, want %s", code, ErrCodeCollectionNotFound)
+	}
+	if rec := doWithKey(t, h, minh, http.MethodPost, "/api/legal-query", query); rec.Code != http.StatusOK {
+		t.Errorf("query by minh = %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Tenants are those of API keys too, so claiming one in X-Tenant-ID
+	// gets nothing
+	acme := tenantKey(t, h, "acme")
+	req := httptest.NewRequest(http.MethodGet, "/api/collections", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	json.Unmarshal(rec.Body.Bytes(), &listed)
+	if len(listed.Collections) != 1 {
+		t.Errorf("collections claimed for acme = %+v, want only luat_lao_dong", listed.Collections)
+	}
+	json.Unmarshal(doWithKey(t, h, acme, http.MethodGet, "/api/collections", nil).Body.Bytes(), &listed)
+	if len(listed.Collections) != 2 || listed.Collections[1].Name != "acme_contracts" {
+		t.Errorf("collections of acme = %+v, want luat_lao_dong and acme_contracts", listed.Collections)
+	}
+
+	// Queries naming no collection leave out those the caller may not search
+	unscoped := LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}
+	for _, tc := range []struct {
+		name string
+		key  string
+		want []string
+	}{
+		{"lan", "", []string{"internal_memos", "acme_contracts"}},
+		{"minh", minh, []string{"acme_contracts"}},
+		{"acme", acme, []string{"internal_memos"}},
+	} {
+		var rec *httptest.ResponseRecorder
+		if tc.key == "" {
+			rec = doAs(t, h, tc.name, http.MethodPost, "/api/legal-query", unscoped)
+		} else {
+			rec = doWithKey(t, h, tc.key, http.MethodPost, "/api/legal-query", unscoped)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unscoped query by %s = %d %s", tc.name, rec.Code, rec.Body.String())
+		}
+		if got := stub.requests[len(stub.requests)-1].ExcludeCollections; !slices.Equal(got, tc.want) {
+			t.Errorf("excluded collections of %s = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := stub.requests[0].ExcludeCollections; got != nil {
+		t.Errorf("query of internal_memos excluded %v", got)
+	}
+}
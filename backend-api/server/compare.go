@@ -0,0 +1,290 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// CompareTarget is a model, optionally served by its own engine, that
+// answers can be compared across
+type CompareTarget struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	URL   string `json:"-"`
+}
+
+// compareEngine is a target with the engine that serves it
+type compareEngine struct {
+	CompareTarget
+	engine engine.QueryEngine
+}
+
+// parseCompareTargets parses COMPARE_ENGINES: comma-separated
+// name=model[@engine-url] items
+func parseCompareTargets(value string) ([]CompareTarget, error) {
+	var targets []CompareTarget
+	seen := make(map[string]bool)
+	for _, item := range settings.SplitList(value) {
+		name, spec, ok := strings.Cut(item, "=")
+		if !ok || name == "" || spec == "" {
+			return nil, fmt.Errorf("invalid compare target %q, expected name=model[@url]", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate compare target %q", name)
+		}
+		seen[name] = true
+
+		model, url, _ := strings.Cut(spec, "@")
+		if !modelNamePattern.MatchString(model) {
+			return nil, fmt.Errorf("invalid model %q for compare target %q", model, name)
+		}
+		targets = append(targets, CompareTarget{Name: name, Model: model, URL: url})
+	}
+	return targets, nil
+}
+
+// CompareRequest is a query to answer with two targets
+type CompareRequest struct {
+	LegalQueryRequest
+	Targets []string `json:"targets,omitempty"`
+}
+
+// CompareResult is the answer of one target, or the error it failed with
+type CompareResult struct {
+	Target     string                     `json:"target"`
+	Model      string                     `json:"model"`
+	DurationMs int64                      `json:"duration_ms"`
+	Response   *engine.LegalQueryResponse `json:"response,omitempty"`
+	Error      *ErrorResponse             `json:"error,omitempty"`
+}
+
+// CitationDiff compares the citations of the answered targets
+type CitationDiff struct {
+	Common []string            `json:"common"`
+	OnlyIn map[string][]string `json:"only_in"`
+}
+
+// CompareResponse is returned by the comparison endpoint
+type CompareResponse struct {
+	Question  string           `json:"question"`
+	Results   []CompareResult  `json:"results"`
+	Citations CitationDiff     `json:"citations"`
+	Sources   CitationDiff     `json:"sources"`
+	Warnings  []engine.Warning `json:"warnings,omitempty"`
+
+	ContextURLErrors []engine.ContextURLError `json:"context_url_errors,omitempty"`
+}
+
+var articleCitationPattern = regexp.MustCompile(`(?i)điều\s+(\d+[a-zđ]?)`)
+
+// answerCitations returns the articles cited in the answer text
+func answerCitations(resp *engine.LegalQueryResponse) map[string]bool {
+	cited := make(map[string]bool)
+	for _, m := range articleCitationPattern.FindAllStringSubmatch(resp.Answer, -1) {
+		cited["Điều "+m[1]] = true
+	}
+	return cited
+}
+
+// sourceCitations returns the articles and web pages an answer was based on
+func sourceCitations(resp *engine.LegalQueryResponse) map[string]bool {
+	sources := make(map[string]bool)
+	for _, r := range resp.SearchResults {
+		if strings.HasPrefix(r.ArticleNumber, "Dieu_") {
+			sources["Điều "+strings.TrimPrefix(r.ArticleNumber, "Dieu_")] = true
+		}
+	}
+	for _, r := range resp.WebResults {
+		if r.URL != "" {
+			sources[r.URL] = true
+		}
+	}
+	return sources
+}
+
+// diffCitations splits the citations of each named set into those shared by
+// every set and those unique to one. With a single set, nothing is common.
+func diffCitations(sets map[string]map[string]bool) CitationDiff {
+	diff := CitationDiff{Common: []string{}, OnlyIn: make(map[string][]string)}
+	counts := make(map[string]int)
+	for _, set := range sets {
+		for item := range set {
+			counts[item]++
+		}
+	}
+	for item, n := range counts {
+		if n == len(sets) && n > 1 {
+			diff.Common = append(diff.Common, item)
+		}
+	}
+	for name, set := range sets {
+		only := []string{}
+		for item := range set {
+			if counts[item] == 1 {
+				only = append(only, item)
+			}
+		}
+		sort.Strings(only)
+		diff.OnlyIn[name] = only
+	}
+	sort.Strings(diff.Common)
+	return diff
+}
+
+// selectCompareTargets resolves the requested target names, defaulting to the
+// first two configured targets
+func selectCompareTargets(configured []compareEngine, names []string) ([]compareEngine, error) {
+	if len(names) == 0 {
+		if len(configured) < 2 {
+			return nil, fmt.Errorf("at least two compare targets must be configured")
+		}
+		return configured[:2], nil
+	}
+	if len(names) != 2 || names[0] == names[1] {
+		return nil, fmt.Errorf("targets must name two different compare targets")
+	}
+
+	var selected []compareEngine
+	for _, name := range names {
+		found := false
+		for _, t := range configured {
+			if t.Name == name {
+				selected = append(selected, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown compare target %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// Handlers
+
+func compareTargetsHandler(deps queryDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targets := make([]CompareTarget, len(deps.compare))
+		for i, t := range deps.compare {
+			targets[i] = t.CompareTarget
+		}
+		c.JSON(http.StatusOK, gin.H{"targets": targets})
+	}
+}
+
+func compareHandler(deps queryDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CompareRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+
+		plan := callerPlan(c)
+		if !plan.Compare {
+			abortWithError(c, ErrCodeForbidden, fmt.Sprintf("Answer comparison is not available on plan %q", plan.Name))
+			return
+		}
+		if req.PendingQueryID != "" {
+			abortWithError(c, ErrCodeInvalidRequest, "pending_query_id is not supported when comparing answers")
+			return
+		}
+		if req.ConversationID != "" {
+			abortWithError(c, ErrCodeInvalidRequest, "conversation_id is not supported when comparing answers")
+			return
+		}
+		targets, err := selectCompareTargets(deps.compare, req.Targets)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+
+		tenant, _ := callerTenant(c)
+		deps.preferences.Get(tenant.ID, callerUser(c)).apply(&req.LegalQueryRequest)
+		warnings, ok := deps.validateQuery(c, &req.LegalQueryRequest, plan)
+		if !ok {
+			return
+		}
+		contextDocs, urlErrors, ok := deps.resolveContext(c, &req.LegalQueryRequest, tenant.ID)
+		if !ok {
+			return
+		}
+		base := deps.engineRequest(&req.LegalQueryRequest, tenant.Settings.Defaults, plan)
+		base.ContextDocuments = contextDocs
+
+		results := make([]CompareResult, len(targets))
+		var wg sync.WaitGroup
+		for i, target := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pythonReq := *base
+				pythonReq.Model = target.Model
+				targetEngine := target.engine
+				if isSandboxRequest(c) {
+					targetEngine = deps.sandbox
+				}
+
+				started := time.Now()
+				resp, err := engine.QueryWithContext(c.Request.Context(), targetEngine, &pythonReq)
+				results[i] = CompareResult{
+					Target:     target.Name,
+					Model:      target.Model,
+					DurationMs: time.Since(started).Milliseconds(),
+				}
+				if err != nil {
+					slog.ErrorContext(c.Request.Context(), "Compare target failed", "target", target.Name, "error", err)
+					resp := engineErrorResponse(err, err.Error())
+					results[i].Error = &resp
+					return
+				}
+				resp.Meta = newResponseMeta(&pythonReq, resp, deps.primaryRegion, false)
+				postWarnings, err := deps.postProcessors.Run(c.Request.Context(), &PostProcessInput{Question: req.Question, TenantID: tenant.ID, Response: resp})
+				if err != nil {
+					slog.WarnContext(c.Request.Context(), "Post-processing rejected the answer of a compare target", "target", target.Name, "error", err)
+					resp := newErrorResponse(postProcessErrorCode(err), err.Error())
+					results[i].Error = &resp
+					return
+				}
+				deps.finishAnswer(c.Request.Context(), resp, pythonReq.CitationStyle)
+				resp.Warnings = postWarnings
+				results[i].Response = resp
+			}()
+		}
+		wg.Wait()
+
+		citations := make(map[string]map[string]bool)
+		sources := make(map[string]map[string]bool)
+		for _, r := range results {
+			if r.Response != nil {
+				citations[r.Target] = answerCitations(r.Response)
+				sources[r.Target] = sourceCitations(r.Response)
+			}
+		}
+		if len(citations) == 0 {
+			abortWithError(c, results[0].Error.Code, fmt.Sprintf("All compare targets failed: %s", results[0].Error.Message))
+			return
+		}
+
+		c.JSON(http.StatusOK, CompareResponse{
+			Question:         req.Question,
+			Results:          results,
+			Citations:        diffCitations(citations),
+			Sources:          diffCitations(sources),
+			Warnings:         warnings,
+			ContextURLErrors: urlErrors,
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// Content codings the server can produce
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// compressionEncodings are the supported codings, in the default order of
+// preference
+var compressionEncodings = []string{EncodingBrotli, EncodingGzip}
+
+// brotliLevel trades ratio for speed: answers are compressed per request,
+// and above level 5 brotli gets much slower for little gain
+const brotliLevel = 4
+
+// streamingRoutes flush their response as it is produced, which buffering
+// for compression would hold back
+var streamingRoutes = map[string]bool{
+	"/api/legal-query/stream":   true,
+	"/api/notifications/stream": true,
+	"/ws/chat":                  true,
+}
+
+// CompressionConfig controls the compression of JSON responses
+type CompressionConfig struct {
+	// Encodings are the codings offered, most preferred first; none
+	// disables compression
+	Encodings []string
+	// MinBytes is the size below which a response is sent as is, since
+	// compressing it saves less than it costs
+	MinBytes int
+}
+
+func loadCompressionConfig() CompressionConfig {
+	var encodings []string
+	for _, name := range settings.SplitList(settings.String("COMPRESSION_ENCODINGS", strings.Join(compressionEncodings, ","))) {
+		name = strings.ToLower(name)
+		switch {
+		case name == "none":
+			encodings = nil
+		case !slices.Contains(compressionEncodings, name):
+			settings.Warn("COMPRESSION_ENCODINGS", "unknown COMPRESSION_ENCODINGS item %q (available: %v), ignored", name, compressionEncodings)
+		case !slices.Contains(encodings, name):
+			encodings = append(encodings, name)
+		}
+	}
+	return CompressionConfig{
+		Encodings: encodings,
+		MinBytes:  settings.IntInRange("COMPRESSION_MIN_BYTES", 1024, 0, 16<<20),
+	}
+}
+
+// negotiateEncoding picks the coding of a response from the request's
+// Accept-Encoding: the offered coding with the highest quality, ties going
+// to the earlier offer. It returns "" when the client accepts none of them.
+func negotiateEncoding(acceptEncoding string, offered []string) string {
+	quality := map[string]float64{}
+	wildcard := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			quality[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range offered {
+		q, ok := quality[name]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressionMiddleware compresses JSON responses of at least MinBytes
+// with the best coding the client accepts. The response is buffered up to
+// MinBytes to learn its size, so streaming routes are left alone.
+func compressionMiddleware(config CompressionConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(config.Encodings) == 0 || streamingRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		// Added, not set: CORS may already vary the response by Origin
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), config.Encodings)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minBytes: config.MinBytes}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// encoder is the writer of a content coding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// to compress it, then writes it through an encoder or as is
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minBytes int
+
+	buf     bytes.Buffer
+	decided bool
+	enc     encoder
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	if !w.compressible() {
+		w.decided = true
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minBytes {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends the headers, after which the response can no longer
+// be compressed
+func (w *compressWriter) WriteHeaderNow() {
+	w.passThrough()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Flush sends what has been written so far, compressed only if enough of
+// it was to decide
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	} else {
+		w.passThrough()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compressible reports whether the response is JSON, not already encoded
+// and of a status that has a body
+func (w *compressWriter) compressible() bool {
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch status := w.ResponseWriter.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// start sets the coding headers and compresses the buffered data
+func (w *compressWriter) start() error {
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	switch w.encoding {
+	case EncodingBrotli:
+		w.enc = brotli.NewWriterLevel(w.ResponseWriter, brotliLevel)
+	default:
+		w.enc = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.decided = true
+	_, err := w.enc.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// passThrough sends the buffered data as is and the rest of the response
+// after it
+func (w *compressWriter) passThrough() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// close ends the response: a response smaller than minBytes is sent as is
+func (w *compressWriter) close() {
+	if w.enc != nil {
+		w.enc.Close()
+		return
+	}
+	w.passThrough()
+}
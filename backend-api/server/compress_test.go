@@ -0,0 +1,126 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	offered := []string{EncodingBrotli, EncodingGzip}
+	for header, want := range map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip, deflate":           EncodingGzip,
+		"gzip, deflate, br":       EncodingBrotli,
+		"br;q=0.5, gzip":          EncodingGzip,
+		"GZIP;q=0.8":              EncodingGzip,
+		"*":                       EncodingBrotli,
+		"*;q=0.1, br;q=0":         EncodingGzip,
+		"gzip;q=0, br;q=0":        "",
+		"gzip;q=soon, br;q=0.2":   EncodingBrotli,
+		"deflate, compress;q=0.5": "",
+	} {
+		if got := negotiateEncoding(header, offered); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+	if got := negotiateEncoding("br, gzip", []string{EncodingGzip}); got != EncodingGzip {
+		t.Errorf("negotiateEncoding with brotli disabled = %q, want gzip", got)
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(compressionMiddleware(CompressionConfig{Encodings: compressionEncodings, MinBytes: 1024}))
+	large := strings.Repeat("Thời gian thử việc không quá 60 ngày. ", 100)
+	router.GET("/api/history/:id", func(c *gin.Context) {
+		if c.Param("id") == "small" {
+			c.JSON(http.StatusOK, gin.H{"answer": "Điều 25"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"answer": large})
+	})
+	router.GET("/api/binders/:id/export", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/pdf", []byte(large))
+	})
+	router.GET("/api/notifications/stream", func(c *gin.Context) {
+		c.SSEvent("notification", large)
+		c.Writer.Flush()
+	})
+
+	for _, tc := range []struct {
+		name, path, accept, want string
+	}{
+		{"large answer", "/api/history/large", "gzip, deflate", EncodingGzip},
+		{"large answer, brotli preferred", "/api/history/large", "gzip, deflate, br", EncodingBrotli},
+		{"small answer", "/api/history/small", "gzip", ""},
+		{"no Accept-Encoding", "/api/history/large", "", ""},
+		{"gzip refused", "/api/history/large", "gzip;q=0", ""},
+		{"not JSON", "/api/binders/1/export", "gzip", ""},
+		{"streaming route", "/api/notifications/stream", "gzip", ""},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		router.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding"); got != tc.want {
+			t.Errorf("%s: Content-Encoding %q, want %q", tc.name, got, tc.want)
+			continue
+		}
+
+		var body io.Reader = rec.Body
+		switch tc.want {
+		case EncodingGzip:
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			body = zr
+		case EncodingBrotli:
+			body = brotli.NewReader(rec.Body)
+		}
+		size := rec.Body.Len()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s: read body: %v", tc.name, err)
+		}
+		if tc.path != "/api/history/small" && !strings.Contains(string(data), large) {
+			t.Errorf("%s: body of %d bytes does not hold the response", tc.name, len(data))
+		}
+		if tc.want != "" && size >= len(data)/2 {
+			t.Errorf("%s: %d bytes compressed to %d", tc.name, len(data), size)
+		}
+		if vary := rec.Header().Get("Vary"); (vary == "Accept-Encoding") == (tc.path == "/api/notifications/stream") {
+			t.Errorf("%s: Vary %q", tc.name, vary)
+		}
+	}
+}
+
+func TestCompressionKeepsCORSVary(t *testing.T) {
+	router := gin.New()
+	router.Use(middleware.CORSWithConfig(middleware.CORSConfig{AllowOrigins: []string{"https://app.example.vn", "https://admin.example.vn"}}))
+	router.Use(compressionMiddleware(CompressionConfig{Encodings: compressionEncodings, MinBytes: 1024}))
+	router.GET("/api/history/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"answer": "Điều 25"})
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/history/1", nil)
+	req.Header.Set("Origin", "https://admin.example.vn")
+	router.ServeHTTP(rec, req)
+	if vary := rec.Header().Values("Vary"); !slices.Equal(vary, []string{"Origin", "Accept-Encoding"}) {
+		t.Errorf("Vary = %q, want Origin and Accept-Encoding", vary)
+	}
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/logging"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// Configuration
+type Config struct {
+	Profile         string
+	ConfigFile      string
+	ServerPort      string
+	PythonEngineURL string
+	RequestTimeout  time.Duration
+	DrainTimeout    time.Duration
+	EngineRetry     engine.RetryPolicy
+	Breaker         BreakerConfig
+	EngineSigning   EngineSigningConfig
+	EngineCallbacks EngineCallbackConfig
+	DefaultPlan     Plan
+	QueryDefaults   QueryDefaults
+	ReloadInterval  time.Duration
+	ReadyTimeout    time.Duration
+	SandboxMode     bool
+	CassetteMode    string
+	CassetteDir     string
+	AdminToken      string
+	RequireAPIKey   bool
+	RateLimit       RateLimitConfig
+	Quota           QuotaConfig
+	TrustedProxies  []string
+	FaultInjection  bool
+	DataDir         string
+	LogLevel        string
+	LogSampleRate   float64
+	LogFormat       string
+	LogDedupWindow  time.Duration
+	CORS            middleware.CORSConfig
+	MockEngine      bool
+	Strict          bool
+	QueryCaps       QueryCaps
+	Attachments     AttachmentLimits
+	ContextURLs     ContextURLLimits
+	OCR             OCRConfig
+	PDFFont         string
+	SourcePDFDir    string
+	SigningKeyFile  string
+	Review          ReviewConfig
+	Clarification   ClarificationConfig
+	HistoryMax      int
+	EngagementMax   int
+	DatabaseURL     string
+	CompareTargets  []CompareTarget
+	IterationPolicy engine.IterationPolicy
+	StageBudgets    engine.StageBudgets
+	Speculative     bool
+	Embeddings      EmbeddingConfig
+	Routing         RoutingConfig
+	Cache           CacheConfig
+	SLO             SLOConfig
+	Scaling         ScalingConfig
+	Regions         RegionConfig
+	Hedging         HedgingConfig
+	Warmup          WarmupConfig
+	Slots           SlotConfig
+	Chat            ChatConfig
+	Conversations   ConversationConfig
+	Jobs            JobConfig
+	ReviewJobs      ReviewJobConfig
+	GRPC            GRPCConfig
+	Listeners       ListenerConfig
+	BodyLimits      BodyLimitConfig
+	Compression     CompressionConfig
+	PostProcess     PostProcessConfig
+	LawLinks        LawLinkConfig
+	RulesFile       string
+	CollectionsFile string
+	PrivateDocs     PrivateDocConfig
+	Explain         ExplainConfig
+	Metrics         MetricsConfig
+	Downgrade       DowngradeConfig
+	SourcePriors    SourcePriorConfig
+	EngineMonitor   EngineMonitorConfig
+	License         LicenseConfig
+}
+
+// GRPCConfig enables the gRPC listener for health checks and reflection
+type GRPCConfig struct {
+	Port           string
+	HealthInterval time.Duration
+}
+
+// ReviewConfig controls the answer review workflow, client shares and the
+// sign-off of disclaimers
+type ReviewConfig struct {
+	SeniorLawyers       []string
+	ShareTTL            time.Duration
+	ComplianceReviewers []string
+}
+
+// RoutingConfig controls question difficulty routing
+type RoutingConfig struct {
+	Enabled   bool
+	FastModel string
+}
+
+// ClarificationConfig controls the clarification protocol
+type ClarificationConfig struct {
+	Detect bool
+	TTL    time.Duration
+}
+
+// OCRConfig selects the OCR tool used for image uploads
+type OCRConfig struct {
+	Command   string
+	Languages string
+	Timeout   time.Duration
+}
+
+func LoadConfig() *Config {
+	port := settings.Get("GO_SERVER_PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	pythonURL := settings.Get("PYTHON_AI_ENGINE_URL")
+	if pythonURL == "" {
+		pythonURL = "http://localhost:8000"
+	}
+
+	// Default timeout: 3 minutes for AI processing with multiple RAG iterations
+	timeout := settings.Duration("REQUEST_TIMEOUT", 180*time.Second)
+
+	defaultPlan := plans[defaultPlanName]
+	if planName := settings.Get("DEFAULT_PLAN"); planName != "" {
+		if plan, ok := lookupPlan(planName); ok {
+			defaultPlan = plan
+		} else {
+			settings.Warn("DEFAULT_PLAN", "unknown DEFAULT_PLAN %q (available: %v), using %q", planName, planNames(), defaultPlanName)
+		}
+	}
+
+	cassetteMode := CassetteOff
+	switch mode := strings.ToLower(settings.Get("ENGINE_CASSETTE_MODE")); mode {
+	case "", CassetteOff:
+	case CassetteRecord, CassetteReplay:
+		cassetteMode = mode
+	default:
+		settings.Warn("ENGINE_CASSETTE_MODE", "unknown ENGINE_CASSETTE_MODE %q, cassettes disabled", mode)
+	}
+
+	logLevel := middleware.LogInfo
+	switch level := strings.ToLower(settings.Get("LOG_LEVEL")); level {
+	case "":
+	case middleware.LogDebug, middleware.LogInfo, middleware.LogWarn, middleware.LogError:
+		logLevel = level
+	default:
+		settings.Warn("LOG_LEVEL", "unknown LOG_LEVEL %q (available: %v), using %q", level, middleware.LogLevels, logLevel)
+	}
+
+	logFormat := logging.FormatJSON
+	switch format := strings.ToLower(settings.Get("LOG_FORMAT")); format {
+	case "":
+	case logging.FormatJSON, logging.FormatText:
+		logFormat = format
+	default:
+		settings.Warn("LOG_FORMAT", "unknown LOG_FORMAT %q (available: %v), using %q", format, logging.Formats, logFormat)
+	}
+
+	cassetteDir := settings.Get("ENGINE_CASSETTE_DIR")
+	if cassetteDir == "" {
+		cassetteDir = "cassettes"
+	}
+
+	compareTargets, err := parseCompareTargets(settings.Get("COMPARE_ENGINES"))
+	if err != nil {
+		settings.Warn("COMPARE_ENGINES", "%v, answer comparison disabled", err)
+	}
+
+	slos, err := parseSLOs(settings.String("SLO_DEFINITIONS", defaultSLODefinitions))
+	if err != nil {
+		settings.Warn("SLO_DEFINITIONS", "%v, SLO tracking disabled", err)
+	}
+
+	dataDir := settings.Get("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	config := &Config{
+		Profile:         settings.ProfileName(),
+		ConfigFile:      settings.FilePath(),
+		ServerPort:      port,
+		PythonEngineURL: pythonURL,
+		RequestTimeout:  timeout,
+		DrainTimeout:    settings.Duration("SHUTDOWN_DRAIN_TIMEOUT", 30*time.Second),
+		DefaultPlan:     defaultPlan,
+		QueryDefaults:   loadQueryDefaults(),
+		ReloadInterval:  settings.Duration("CONFIG_RELOAD_INTERVAL", 10*time.Second),
+		ReadyTimeout:    settings.Duration("READINESS_TIMEOUT", 2*time.Second),
+		SandboxMode:     settings.Bool("SANDBOX_MODE", false),
+		CassetteMode:    cassetteMode,
+		CassetteDir:     cassetteDir,
+		AdminToken:      settings.Get("ADMIN_TOKEN"),
+		RequireAPIKey:   settings.Bool("REQUIRE_API_KEY", false),
+		RateLimit: RateLimitConfig{
+			PerMinute: settings.IntInRange("RATE_LIMIT_PER_MINUTE", 60, 0, 1000000),
+			Burst:     settings.IntInRange("RATE_LIMIT_BURST", 20, 1, 1000000),
+		},
+		Quota:          QuotaConfig{QueriesPerDay: settings.IntInRange("QUERY_QUOTA_PER_DAY", 0, 0, 100000000)},
+		TrustedProxies: loadTrustedProxies(),
+		FaultInjection: settings.Bool("ENABLE_FAULT_INJECTION", false),
+		DataDir:        dataDir,
+		LogLevel:       logLevel,
+		LogSampleRate:  settings.FloatInRange("LOG_SAMPLE_RATE", 1, 0, 1),
+		LogFormat:      logFormat,
+		LogDedupWindow: settings.Duration("LOG_DEDUP_WINDOW", 10*time.Second),
+		CORS: middleware.CORSConfig{
+			AllowOrigins:     settings.SplitList(settings.String("CORS_ALLOW_ORIGIN", "*")),
+			AllowCredentials: settings.Bool("CORS_ALLOW_CREDENTIALS", false),
+			MaxAge:           settings.Duration("CORS_MAX_AGE", 0),
+		},
+		MockEngine: settings.Bool("MOCK_ENGINE", false),
+		Strict:     settings.Bool("STRICT_CONFIG", false),
+		QueryCaps: QueryCaps{
+			MaxIterations: settings.IntInRange("MAX_ITERATIONS_CAP", engineMaxIterations, 1, engineMaxIterations),
+			MaxTopK:       settings.IntInRange("MAX_TOP_K_CAP", engineMaxTopK, 1, engineMaxTopK),
+
+			MaxQuestionLength: settings.IntInRange("MAX_QUESTION_LENGTH", 2000, 10, 100000),
+		},
+		Attachments: AttachmentLimits{
+			MaxCount:      settings.IntInRange("MAX_ATTACHMENTS", 3, 0, 20),
+			MaxBytes:      settings.IntInRange("MAX_ATTACHMENT_BYTES", 64*1024, 1, 1024*1024),
+			MaxImageBytes: settings.IntInRange("MAX_IMAGE_BYTES", 10<<20, 1, 50<<20),
+			TTL:           settings.Duration("ATTACHMENT_TTL", time.Hour),
+		},
+		OCR: OCRConfig{
+			Command:   settings.String("OCR_COMMAND", "tesseract"),
+			Languages: settings.String("OCR_LANGUAGES", "vie+eng"),
+			Timeout:   settings.Duration("OCR_TIMEOUT", 30*time.Second),
+		},
+		PDFFont:        settings.Get("PDF_FONT"),
+		SourcePDFDir:   settings.Get("SOURCE_PDF_DIR"),
+		SigningKeyFile: settings.Get("SIGNING_KEY_FILE"),
+		Review: ReviewConfig{
+			SeniorLawyers: settings.List("SENIOR_LAWYERS"),
+			ShareTTL:      settings.Duration("SHARE_TTL", 7*24*time.Hour),
+
+			ComplianceReviewers: settings.List("COMPLIANCE_REVIEWERS"),
+		},
+		Clarification: ClarificationConfig{
+			Detect: settings.Bool("ENABLE_CLARIFICATION", true),
+			TTL:    settings.Duration("CLARIFICATION_TTL", 15*time.Minute),
+		},
+		HistoryMax:      settings.IntInRange("HISTORY_MAX_ENTRIES", 1000, 1, 1000000),
+		EngagementMax:   settings.IntInRange("ENGAGEMENT_MAX_EVENTS", 100000, 1, 10000000),
+		DatabaseURL:     settings.Get("DATABASE_URL"),
+		CompareTargets:  compareTargets,
+		IterationPolicy: loadIterationPolicy(),
+		EngineRetry: engine.RetryPolicy{
+			MaxAttempts: settings.IntInRange("ENGINE_RETRY_ATTEMPTS", 3, 1, 10),
+			BaseDelay:   settings.Duration("ENGINE_RETRY_BASE_DELAY", 250*time.Millisecond),
+			MaxDelay:    settings.Duration("ENGINE_RETRY_MAX_DELAY", 4*time.Second),
+			Deadline:    settings.Duration("ENGINE_RETRY_DEADLINE", timeout),
+		},
+		Breaker: BreakerConfig{
+			Threshold: settings.IntInRange("ENGINE_BREAKER_THRESHOLD", 5, 0, 1000),
+			Cooldown:  settings.Duration("ENGINE_BREAKER_COOLDOWN", 30*time.Second),
+		},
+		EngineSigning: EngineSigningConfig{
+			Secret:  settings.Get("ENGINE_SIGNING_SECRET"),
+			MaxSkew: settings.Duration("ENGINE_SIGNING_MAX_SKEW", 5*time.Minute),
+		},
+		EngineCallbacks: EngineCallbackConfig{
+			URL:     settings.Get("ENGINE_CALLBACK_URL"),
+			Timeout: settings.Duration("ENGINE_CALLBACK_TIMEOUT", 5*time.Minute),
+		},
+		StageBudgets: engine.StageBudgets{
+			Retrieval: settings.Duration("STAGE_BUDGET_RETRIEVAL", 0),
+			Iteration: settings.Duration("STAGE_BUDGET_ITERATION", 0),
+			Answer:    settings.Duration("STAGE_BUDGET_ANSWER", 0),
+		},
+		Speculative: settings.Bool("SPECULATIVE_RETRIEVAL", true),
+		Routing: RoutingConfig{
+			Enabled:   settings.Bool("DIFFICULTY_ROUTING", false),
+			FastModel: settings.Get("FAST_PATH_MODEL"),
+		},
+		Downgrade: DowngradeConfig{
+			SoftDeadline: settings.Duration("SOFT_DEADLINE", 0),
+			HardDeadline: settings.Duration("HARD_DEADLINE", timeout*9/10),
+			FastModel:    settings.String("DOWNGRADE_MODEL", settings.Get("FAST_PATH_MODEL")),
+		},
+		EngineMonitor: EngineMonitorConfig{
+			Interval:  settings.Duration("ENGINE_MONITOR_INTERVAL", 30*time.Second),
+			Timeout:   settings.Duration("ENGINE_MONITOR_TIMEOUT", 5*time.Second),
+			Threshold: settings.IntInRange("ENGINE_MONITOR_THRESHOLD", 2, 1, 100),
+			Window:    settings.Duration("ENGINE_MONITOR_WINDOW", 24*time.Hour),
+		},
+		License: LicenseConfig{
+			KeyFile:       settings.Get("LICENSE_FILE"),
+			PublicKeyFile: settings.Get("LICENSE_PUBLIC_KEY_FILE"),
+			Grace:         settings.Duration("LICENSE_GRACE_PERIOD", 14*24*time.Hour),
+			SeatIdle:      settings.Duration("LICENSE_SEAT_IDLE", 30*24*time.Hour),
+		},
+		SourcePriors: SourcePriorConfig{
+			Strength: settings.FloatInRange("SOURCE_PRIOR_STRENGTH", 0.5, 0, 1),
+			MinShown: settings.IntInRange("SOURCE_PRIOR_MIN_SHOWN", 10, 1, 100000),
+			Window:   settings.Duration("SOURCE_PRIOR_WINDOW", 28*24*time.Hour),
+			Interval: settings.Duration("SOURCE_PRIOR_INTERVAL", time.Hour),
+		},
+		Cache: CacheConfig{
+			MaxEntries:   settings.IntInRange("RESPONSE_CACHE_MAX_ENTRIES", 1000, 0, 1000000),
+			TTL:          settings.Duration("RESPONSE_CACHE_TTL", 6*time.Hour),
+			RedisURL:     settings.Get("REDIS_URL"),
+			RedisPrefix:  settings.String("REDIS_CACHE_PREFIX", defaultRedisCachePrefix),
+			WarmTopN:     settings.IntInRange("WARM_CACHE_TOP_N", 20, 0, 1000),
+			WarmOnStart:  settings.Bool("WARM_CACHE_ON_START", true),
+			WarmInterval: settings.Duration("WARM_CACHE_INTERVAL", 0),
+		},
+		Regions: RegionConfig{
+			PrimaryName:    settings.String("PRIMARY_REGION", "primary"),
+			SecondaryName:  settings.String("SECONDARY_REGION", "secondary"),
+			SecondaryURL:   settings.Get("SECONDARY_ENGINE_URL"),
+			LatencyBudget:  settings.Duration("REGION_LATENCY_BUDGET", 30*time.Second),
+			HealthInterval: settings.Duration("REGION_HEALTH_INTERVAL", 10*time.Second),
+		},
+		GRPC: GRPCConfig{
+			Port:           settings.Get("GRPC_PORT"),
+			HealthInterval: settings.Duration("GRPC_HEALTH_INTERVAL", 10*time.Second),
+		},
+		Listeners:   loadListenerConfig(port),
+		Embeddings:  loadEmbeddingConfig(),
+		Compression: loadCompressionConfig(),
+		PostProcess: PostProcessConfig{
+			Processors:   settings.List("POST_PROCESSORS"),
+			Disclaimer:   settings.String("POST_PROCESSOR_DISCLAIMER", defaultDisclaimer),
+			BlockedTerms: settings.List("POST_PROCESSOR_BLOCKED_TERMS"),
+			HookTimeout:  settings.Duration("POST_PROCESSOR_HOOK_TIMEOUT", 5*time.Second),
+			FailClosed:   settings.Bool("POST_PROCESSOR_FAIL_CLOSED", false),
+		},
+		RulesFile:       settings.Get("RULES_FILE"),
+		CollectionsFile: settings.Get("COLLECTIONS_FILE"),
+		PrivateDocs: PrivateDocConfig{
+			MaxBytes:     settings.IntInRange("PRIVATE_DOCUMENT_MAX_BYTES", 1024*1024, 1, 16*1024*1024),
+			MaxDocuments: settings.IntInRange("PRIVATE_COLLECTION_MAX_DOCUMENTS", 500, 1, 100000),
+		},
+		Explain: ExplainConfig{
+			KeywordWeight: settings.FloatInRange("EXPLAIN_KEYWORD_WEIGHT", 0.3, 0, 1),
+			RecencyWeight: settings.FloatInRange("EXPLAIN_RECENCY_WEIGHT", 0.1, 0, 1),
+			HalfLifeYears: settings.IntInRange("EXPLAIN_RECENCY_HALF_LIFE_YEARS", 10, 1, 100),
+		},
+		Metrics: MetricsConfig{
+			Enabled: settings.Bool("METRICS_ENABLED", true),
+			Token:   settings.Get("METRICS_TOKEN"),
+		},
+		LawLinks: LawLinkConfig{
+			Enabled:      settings.Bool("LAW_LINKS", true),
+			CatalogFile:  settings.Get("LAW_LINKS_FILE"),
+			Check:        settings.Bool("LAW_LINK_CHECK", true),
+			CheckTimeout: settings.Duration("LAW_LINK_CHECK_TIMEOUT", 3*time.Second),
+			CheckTTL:     settings.Duration("LAW_LINK_CHECK_TTL", 24*time.Hour),
+		},
+		Hedging: HedgingConfig{
+			Enabled:    settings.Bool("ENABLE_HEDGING", false),
+			PoolURLs:   settings.List("ENGINE_POOL_URLS"),
+			Budget:     settings.FloatInRange("HEDGE_BUDGET", 0.1, 0, 1),
+			MinSamples: settings.IntInRange("HEDGE_MIN_SAMPLES", 20, 1, hedgeLatencySamples),
+			MinDelay:   settings.Duration("HEDGE_MIN_DELAY", time.Second),
+		},
+		Warmup: WarmupConfig{
+			Enabled:   settings.Bool("ENGINE_WARMUP", false),
+			Queries:   settings.SplitList(settings.String("ENGINE_WARMUP_QUERIES", defaultWarmupQueries)),
+			IdleAfter: settings.Duration("ENGINE_WARMUP_IDLE", 30*time.Minute),
+			Tolerance: settings.FloatInRange("ENGINE_WARMUP_TOLERANCE", 0.25, 0, 10),
+			MaxRounds: settings.IntInRange("ENGINE_WARMUP_MAX_ROUNDS", 5, 2, 50),
+		},
+		Slots: SlotConfig{
+			Limit:        settings.IntInRange("ENGINE_CONCURRENCY_LIMIT", 0, 0, 10000),
+			Reserved:     settings.IntInRange("PREMIUM_RESERVED_SLOTS", 0, 0, 10000),
+			PremiumPlans: settings.SplitList(settings.String("PREMIUM_PLANS", defaultPlanName)),
+			Wait:         settings.Duration("ENGINE_SLOT_WAIT", 30*time.Second),
+		},
+		Chat: ChatConfig{
+			MaxTurns:        settings.IntInRange("CHAT_MAX_TURNS", 10, 0, 100),
+			IdleTimeout:     settings.Duration("CHAT_IDLE_TIMEOUT", 10*time.Minute),
+			MaxMessageBytes: settings.IntInRange("CHAT_MAX_MESSAGE_BYTES", 64<<10, 1<<10, 10<<20),
+		},
+		Conversations: ConversationConfig{
+			MaxTurns:      settings.IntInRange("CONVERSATION_MAX_TURNS", 10, 0, 100),
+			TTL:           settings.Duration("CONVERSATION_TTL", 30*24*time.Hour),
+			SummaryTokens: settings.IntInRange("CONVERSATION_SUMMARY_TOKENS", 2000, 0, 100000),
+		},
+		Jobs: JobConfig{
+			Workers:   settings.IntInRange("JOB_WORKERS", 4, 1, 100),
+			QueueSize: settings.IntInRange("JOB_QUEUE_SIZE", 100, 0, 100000),
+			TTL:       settings.Duration("JOB_TTL", time.Hour),
+		},
+		ReviewJobs: ReviewJobConfig{
+			Workers:      settings.IntInRange("REVIEW_JOB_WORKERS", 2, 1, 100),
+			PerMinute:    settings.IntInRange("REVIEW_JOB_QUESTIONS_PER_MINUTE", 60, 0, 100000),
+			MaxDocuments: settings.IntInRange("REVIEW_JOB_MAX_DOCUMENTS", 50, 1, 1000),
+			MaxQuestions: settings.IntInRange("REVIEW_JOB_MAX_QUESTIONS", 30, 1, 200),
+			TTL:          settings.Duration("REVIEW_JOB_TTL", 24*time.Hour),
+		},
+		Scaling: ScalingConfig{
+			Capacity: settings.IntInRange("ENGINE_CAPACITY", 4, 1, 1000),
+			Webhook:  settings.Get("SCALING_WEBHOOK_URL"),
+			Interval: settings.Duration("SCALING_SIGNAL_INTERVAL", 15*time.Second),
+		},
+		SLO: SLOConfig{
+			SLOs:         slos,
+			FastBurnRate: settings.FloatInRange("SLO_FAST_BURN_RATE", 14.4, 1, 1000),
+			SlowBurnRate: settings.FloatInRange("SLO_SLOW_BURN_RATE", 6, 1, 1000),
+			MinRequests:  settings.IntInRange("SLO_MIN_REQUESTS", 10, 1, 1000000),
+			AlertWebhook: settings.Get("ALERT_WEBHOOK_URL"),
+			EvalInterval: settings.Duration("SLO_EVAL_INTERVAL", time.Minute),
+		},
+		ContextURLs: ContextURLLimits{
+			MaxCount:  settings.IntInRange("MAX_CONTEXT_URLS", 3, 0, 10),
+			Timeout:   settings.Duration("CONTEXT_URL_TIMEOUT", 10*time.Second),
+			Allowlist: settings.List("EGRESS_ALLOWLIST"),
+		},
+	}
+	config.BodyLimits = loadBodyLimitConfig(config.QueryCaps, config.Attachments, config.PrivateDocs, config.ReviewJobs)
+	return config
+}
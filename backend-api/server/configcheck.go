@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/document"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// ConfigProblem is an invalid or missing setting. Without strict mode the
+// server logs it and falls back to a default; strict mode refuses to start.
+type ConfigProblem = settings.Problem
+
+// minAdminTokenLength is the shortest admin token considered safe
+const minAdminTokenLength = 16
+
+// minSigningSecretLength is the shortest engine signing secret considered
+// safe
+const minSigningSecretLength = 32
+
+// CheckConfig returns the problems found while loading config followed by
+// those found validating it. With network set, every configured URL must
+// accept a TCP connection.
+func CheckConfig(config *Config, network bool) []ConfigProblem {
+	problems := settings.Problems()
+	add := func(setting, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Setting: setting, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	type setting struct{ name, value string }
+
+	ports := []setting{
+		{"GO_SERVER_PORT", config.ServerPort},
+		{"GRPC_PORT", config.GRPC.Port},
+		{"ADMIN_PORT", config.Listeners.AdminPort},
+		{"METRICS_PORT", config.Listeners.MetricsPort},
+	}
+	for _, p := range ports {
+		if p.value == "" {
+			continue
+		}
+		value := p.value
+		if _, port, err := net.SplitHostPort(value); err == nil {
+			value = port
+		}
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			add(p.name, "%s=%q is not a valid port", p.name, p.value)
+		}
+	}
+
+	durations := []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"REQUEST_TIMEOUT", config.RequestTimeout},
+		{"SHUTDOWN_DRAIN_TIMEOUT", config.DrainTimeout},
+		{"READINESS_TIMEOUT", config.ReadyTimeout},
+		{"ENGINE_RETRY_BASE_DELAY", config.EngineRetry.BaseDelay},
+		{"ENGINE_RETRY_MAX_DELAY", config.EngineRetry.MaxDelay},
+		{"ENGINE_BREAKER_COOLDOWN", config.Breaker.Cooldown},
+		{"ENGINE_SIGNING_MAX_SKEW", config.EngineSigning.MaxSkew},
+		{"ENGINE_CALLBACK_TIMEOUT", config.EngineCallbacks.Timeout},
+		{"ATTACHMENT_TTL", config.Attachments.TTL},
+		{"OCR_TIMEOUT", config.OCR.Timeout},
+		{"CLARIFICATION_TTL", config.Clarification.TTL},
+		{"SHARE_TTL", config.Review.ShareTTL},
+		{"CONTEXT_URL_TIMEOUT", config.ContextURLs.Timeout},
+		{"RESPONSE_CACHE_TTL", config.Cache.TTL},
+		{"REGION_LATENCY_BUDGET", config.Regions.LatencyBudget},
+		{"REGION_HEALTH_INTERVAL", config.Regions.HealthInterval},
+		{"HEDGE_MIN_DELAY", config.Hedging.MinDelay},
+		{"ENGINE_SLOT_WAIT", config.Slots.Wait},
+		{"CHAT_IDLE_TIMEOUT", config.Chat.IdleTimeout},
+		{"CONVERSATION_TTL", config.Conversations.TTL},
+		{"JOB_TTL", config.Jobs.TTL},
+		{"REVIEW_JOB_TTL", config.ReviewJobs.TTL},
+		{"SCALING_SIGNAL_INTERVAL", config.Scaling.Interval},
+		{"SLO_EVAL_INTERVAL", config.SLO.EvalInterval},
+		{"ENGINE_MONITOR_INTERVAL", config.EngineMonitor.Interval},
+		{"ENGINE_MONITOR_TIMEOUT", config.EngineMonitor.Timeout},
+		{"LICENSE_SEAT_IDLE", config.License.SeatIdle},
+		{"ENGINE_MONITOR_WINDOW", config.EngineMonitor.Window},
+		{"SOURCE_PRIOR_WINDOW", config.SourcePriors.Window},
+		{"SOURCE_PRIOR_INTERVAL", config.SourcePriors.Interval},
+		{"GRPC_HEALTH_INTERVAL", config.GRPC.HealthInterval},
+		{"LAW_LINK_CHECK_TIMEOUT", config.LawLinks.CheckTimeout},
+		{"LAW_LINK_CHECK_TTL", config.LawLinks.CheckTTL},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			add(d.setting, "%s must be a positive duration, got %v", d.setting, d.value)
+		}
+	}
+	if config.EngineRetry.MaxDelay < config.EngineRetry.BaseDelay {
+		add("ENGINE_RETRY_MAX_DELAY", "ENGINE_RETRY_MAX_DELAY=%v is below ENGINE_RETRY_BASE_DELAY=%v", config.EngineRetry.MaxDelay, config.EngineRetry.BaseDelay)
+	}
+	if config.EngineRetry.Deadline < 0 {
+		add("ENGINE_RETRY_DEADLINE", "ENGINE_RETRY_DEADLINE must not be negative, got %v", config.EngineRetry.Deadline)
+	}
+	if config.ReloadInterval < 0 {
+		add("CONFIG_RELOAD_INTERVAL", "CONFIG_RELOAD_INTERVAL must not be negative, got %v", config.ReloadInterval)
+	}
+	for _, v := range validateQueryDefaults(config.QueryDefaults, plans[defaultPlanName]) {
+		add(queryDefaultSettings[v.Field], "%s: %s", queryDefaultSettings[v.Field], v.Message)
+	}
+	if config.Slots.Reserved > 0 && config.Slots.Limit == 0 {
+		add("PREMIUM_RESERVED_SLOTS", "PREMIUM_RESERVED_SLOTS is set but ENGINE_CONCURRENCY_LIMIT is not, so no slots are reserved")
+	}
+	if config.Slots.Limit > 0 && config.Slots.Reserved >= config.Slots.Limit {
+		add("PREMIUM_RESERVED_SLOTS", "PREMIUM_RESERVED_SLOTS=%d must be below ENGINE_CONCURRENCY_LIMIT=%d, or only premium tenants can query", config.Slots.Reserved, config.Slots.Limit)
+	}
+	for _, name := range config.Slots.PremiumPlans {
+		if _, ok := lookupPlan(name); !ok {
+			add("PREMIUM_PLANS", "unknown plan %q in PREMIUM_PLANS (available: %v)", name, planNames())
+		}
+	}
+	for _, budget := range []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"STAGE_BUDGET_RETRIEVAL", config.StageBudgets.Retrieval},
+		{"STAGE_BUDGET_ITERATION", config.StageBudgets.Iteration},
+		{"STAGE_BUDGET_ANSWER", config.StageBudgets.Answer},
+	} {
+		switch {
+		case budget.value < 0:
+			add(budget.setting, "%s must not be negative, got %v", budget.setting, budget.value)
+		case budget.value >= config.RequestTimeout:
+			add(budget.setting, "%s=%v is not below REQUEST_TIMEOUT=%v, so the request times out before the stage does", budget.setting, budget.value, config.RequestTimeout)
+		}
+	}
+	switch downgrade := config.Downgrade; {
+	case downgrade.SoftDeadline < 0:
+		add("SOFT_DEADLINE", "SOFT_DEADLINE must not be negative, got %v", downgrade.SoftDeadline)
+	case downgrade.SoftDeadline == 0:
+	case downgrade.HardDeadline >= config.RequestTimeout:
+		add("HARD_DEADLINE", "HARD_DEADLINE=%v is not below REQUEST_TIMEOUT=%v, so the request times out before the engine answers", downgrade.HardDeadline, config.RequestTimeout)
+	case downgrade.SoftDeadline >= downgrade.HardDeadline:
+		add("SOFT_DEADLINE", "SOFT_DEADLINE=%v is not below HARD_DEADLINE=%v, so queries are never downgraded", downgrade.SoftDeadline, downgrade.HardDeadline)
+	}
+	if config.Warmup.IdleAfter < 0 {
+		add("ENGINE_WARMUP_IDLE", "ENGINE_WARMUP_IDLE must not be negative, got %v", config.Warmup.IdleAfter)
+	}
+	if config.Warmup.Enabled && len(config.Warmup.Queries) == 0 {
+		add("ENGINE_WARMUP_QUERIES", "ENGINE_WARMUP is set but ENGINE_WARMUP_QUERIES is empty")
+	}
+	if config.Cache.WarmInterval < 0 {
+		add("WARM_CACHE_INTERVAL", "WARM_CACHE_INTERVAL must not be negative, got %v", config.Cache.WarmInterval)
+	}
+	if config.CORS.MaxAge < 0 {
+		add("CORS_MAX_AGE", "CORS_MAX_AGE must not be negative, got %v", config.CORS.MaxAge)
+	}
+	for _, origin := range config.CORS.AllowOrigins {
+		if origin == "*" || origin == middleware.CORSOff {
+			if len(config.CORS.AllowOrigins) > 1 {
+				add("CORS_ALLOW_ORIGIN", "CORS_ALLOW_ORIGIN=%q must be the only origin when listed with others", origin)
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			add("CORS_ALLOW_ORIGIN", "CORS_ALLOW_ORIGIN %q is not an origin like https://app.example.vn", origin)
+		}
+	}
+	if config.CORS.AllowCredentials && config.CORS.AllowsAny() {
+		add("CORS_ALLOW_CREDENTIALS", "CORS_ALLOW_CREDENTIALS is set but CORS_ALLOW_ORIGIN is *, which browsers refuse with credentials; list the allowed origins")
+	}
+	if config.LogDedupWindow < 0 {
+		add("LOG_DEDUP_WINDOW", "LOG_DEDUP_WINDOW must not be negative, got %v", config.LogDedupWindow)
+	}
+	if config.BodyLimits.MaxUploadBytes < config.Attachments.MaxImageBytes {
+		add("MAX_UPLOAD_BYTES", "MAX_UPLOAD_BYTES=%d is below MAX_IMAGE_BYTES=%d, so large images are rejected before they are read", config.BodyLimits.MaxUploadBytes, config.Attachments.MaxImageBytes)
+	}
+
+	if config.FaultInjection && config.AdminToken == "" {
+		add("ADMIN_TOKEN", "ENABLE_FAULT_INJECTION is set but ADMIN_TOKEN is missing, so faults cannot be configured")
+	}
+	if config.RequireAPIKey && config.AdminToken == "" {
+		add("ADMIN_TOKEN", "REQUIRE_API_KEY is set but ADMIN_TOKEN is missing, so no API key can be created")
+	}
+	if config.AdminToken != "" && len(config.AdminToken) < minAdminTokenLength {
+		add("ADMIN_TOKEN", "ADMIN_TOKEN must be at least %d characters long", minAdminTokenLength)
+	}
+	if config.EngineSigning.Secret != "" && len(config.EngineSigning.Secret) < minSigningSecretLength {
+		add("ENGINE_SIGNING_SECRET", "ENGINE_SIGNING_SECRET must be at least %d characters long", minSigningSecretLength)
+	}
+	if config.EngineCallbacks.URL != "" && config.EngineSigning.Secret == "" {
+		add("ENGINE_SIGNING_SECRET", "ENGINE_CALLBACK_URL is set but ENGINE_SIGNING_SECRET is missing, so engine callbacks would be rejected")
+	}
+
+	urls := []setting{
+		{"PYTHON_AI_ENGINE_URL", config.PythonEngineURL},
+		{"SECONDARY_ENGINE_URL", config.Regions.SecondaryURL},
+		{"SCALING_WEBHOOK_URL", config.Scaling.Webhook},
+		{"ENGINE_CALLBACK_URL", config.EngineCallbacks.URL},
+		{"ALERT_WEBHOOK_URL", config.SLO.AlertWebhook},
+	}
+	for _, pool := range config.Hedging.PoolURLs {
+		urls = append(urls, setting{"ENGINE_POOL_URLS", pool})
+	}
+	for _, name := range config.PostProcess.Processors {
+		if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+			urls = append(urls, setting{"POST_PROCESSORS", name})
+		}
+	}
+	if _, err := NewPostProcessorChain(config.PostProcess); err != nil {
+		add("POST_PROCESSORS", "%v", err)
+	}
+	if _, err := NewLinkResolver(config.LawLinks); err != nil {
+		add("LAW_LINKS_FILE", "%v", err)
+	}
+	if _, err := LoadRules(config.RulesFile); err != nil {
+		add("RULES_FILE", "%v", err)
+	}
+	if config.Cache.RedisURL != "" {
+		if client, err := newRedisClient(config.Cache.RedisURL); err != nil {
+			add("REDIS_URL", "REDIS_URL %v", err)
+		} else {
+			if network {
+				if err := pingRedis(client); err != nil {
+					add("REDIS_URL", "Redis at %s is unreachable: %v", client.Options().Addr, err)
+				}
+			}
+			client.Close()
+		}
+	}
+	if config.DatabaseURL != "" {
+		if db, err := postgres.Open(config.DatabaseURL); err != nil {
+			add("DATABASE_URL", "DATABASE_URL %v", err)
+		} else {
+			if network {
+				if err := postgres.Ping(db); err != nil {
+					add("DATABASE_URL", "Postgres at %s is unreachable: %v", postgres.Addr(db), err)
+				}
+			}
+			db.Close()
+		}
+	}
+	for _, target := range config.CompareTargets {
+		urls = append(urls, setting{"COMPARE_ENGINES", target.URL})
+	}
+	for _, provider := range config.Embeddings.Providers {
+		urls = append(urls, setting{"EMBEDDING_PROVIDERS", provider.URL})
+	}
+	for _, u := range urls {
+		if u.value == "" {
+			continue
+		}
+		// A replayed or mocked engine is never called
+		network := network && !(u.name == "PYTHON_AI_ENGINE_URL" && (config.CassetteMode == CassetteReplay || config.MockEngine))
+		if err := checkURL(u.value, network); err != nil {
+			add(u.name, "%s=%q %v", u.name, u.value, err)
+		}
+	}
+
+	if info, err := os.Stat(config.DataDir); err == nil && !info.IsDir() {
+		add("DATA_DIR", "DATA_DIR=%q is not a directory", config.DataDir)
+	} else if err == nil {
+		if _, err := NewTenantStore(filepath.Join(config.DataDir, "tenants.json")); err != nil {
+			add("DATA_DIR", "%v", err)
+		}
+	}
+	if config.PDFFont != "" {
+		if _, err := document.LoadFont(config.PDFFont); err != nil {
+			add("PDF_FONT", "PDF_FONT=%q is not usable: %v", config.PDFFont, err)
+		}
+	}
+	if config.SourcePDFDir != "" {
+		if info, err := os.Stat(config.SourcePDFDir); err != nil || !info.IsDir() {
+			add("SOURCE_PDF_DIR", "SOURCE_PDF_DIR=%q is not a directory", config.SourcePDFDir)
+		}
+	}
+	if config.SigningKeyFile != "" {
+		if _, err := loadSigningKey(config.SigningKeyFile, false); err != nil {
+			add("SIGNING_KEY_FILE", "SIGNING_KEY_FILE=%q is not usable: %v", config.SigningKeyFile, err)
+		}
+	}
+	if config.License.KeyFile != "" {
+		if _, err := loadLicense(config.License); err != nil {
+			add("LICENSE_FILE", "LICENSE_FILE=%q is not usable: %v", config.License.KeyFile, err)
+		}
+	}
+	if config.Routing.FastModel != "" && !modelNamePattern.MatchString(config.Routing.FastModel) {
+		add("FAST_PATH_MODEL", "FAST_PATH_MODEL=%q is not a valid model name", config.Routing.FastModel)
+	}
+	if config.CassetteMode == CassetteReplay {
+		if info, err := os.Stat(config.CassetteDir); err != nil || !info.IsDir() {
+			add("ENGINE_CASSETTE_DIR", "ENGINE_CASSETTE_DIR=%q must be an existing directory to replay cassettes", config.CassetteDir)
+		}
+	}
+	return problems
+}
+
+// checkURL reports a URL that is not absolute http(s) or, with network set,
+// does not accept a TCP connection
+func checkURL(value string, network bool) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("is not an absolute http(s) URL")
+	}
+	if !network {
+		return nil
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[parsed.Scheme]
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(parsed.Hostname(), port), 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("is unreachable: %w", err)
+	}
+	conn.Close()
+	return nil
+}
+
+// PrintConfigReport writes a consolidated report of problems to stderr
+func PrintConfigReport(problems []ConfigProblem) {
+	fmt.Fprintf(os.Stderr, "Configuration has %d problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  %-24s %s\n", p.Setting, p.Problem)
+	}
+}
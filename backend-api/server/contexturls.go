@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// maxPageBytes bounds how much of a page is downloaded before extraction
+const maxPageBytes = 2 << 20
+
+// Per-URL fetch error codes
+const (
+	URLErrorNotAllowed  = "URL_NOT_ALLOWED"
+	URLErrorFetchFailed = "FETCH_FAILED"
+	URLErrorHTTPStatus  = "HTTP_STATUS"
+	URLErrorUnsupported = "UNSUPPORTED_CONTENT"
+	URLErrorEmpty       = "EMPTY_CONTENT"
+)
+
+// ContextURLLimits bounds the pages fetched for a query
+type ContextURLLimits struct {
+	MaxCount  int
+	Timeout   time.Duration
+	Allowlist []string
+}
+
+// URLFetcher downloads pages from allowlisted hosts and extracts their main
+// text for use as ad-hoc query context
+type URLFetcher struct {
+	limits     ContextURLLimits
+	maxBytes   int
+	httpClient *http.Client
+}
+
+// NewURLFetcher creates a fetcher whose extracted text is truncated to
+// maxBytes. An empty allowlist blocks every URL.
+func NewURLFetcher(limits ContextURLLimits, maxBytes int) *URLFetcher {
+	f := &URLFetcher{limits: limits, maxBytes: maxBytes}
+	f.httpClient = &http.Client{
+		Timeout: limits.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !f.allowed(req.URL) {
+				return fmt.Errorf("redirect to %s is not in the egress allowlist", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// allowed reports whether u targets an allowlisted host or one of its
+// subdomains
+func (f *URLFetcher) allowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, entry := range f.limits.Allowlist {
+		entry = strings.TrimPrefix(strings.ToLower(entry), "*.")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchAll fetches every URL concurrently. Documents are returned in request
+// order; URLs that could not be used are reported instead of failing the query.
+func (f *URLFetcher) FetchAll(ctx context.Context, urls []string) ([]engine.ContextDocument, []engine.ContextURLError) {
+	docs := make([]*engine.ContextDocument, len(urls))
+	errs := make([]*engine.ContextURLError, len(urls))
+
+	var wg sync.WaitGroup
+	for i, raw := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, urlErr := f.fetch(ctx, raw)
+			if urlErr != nil {
+				slog.InfoContext(ctx, "Context URL skipped", "url", raw, "reason", urlErr.Message)
+				errs[i] = urlErr
+				return
+			}
+			docs[i] = doc
+		}()
+	}
+	wg.Wait()
+
+	var fetched []engine.ContextDocument
+	var failed []engine.ContextURLError
+	for i := range urls {
+		if docs[i] != nil {
+			fetched = append(fetched, *docs[i])
+		}
+		if errs[i] != nil {
+			failed = append(failed, *errs[i])
+		}
+	}
+	return fetched, failed
+}
+
+func (f *URLFetcher) fetch(ctx context.Context, raw string) (*engine.ContextDocument, *engine.ContextURLError) {
+	fail := func(code, format string, args ...any) (*engine.ContextDocument, *engine.ContextURLError) {
+		return nil, &engine.ContextURLError{URL: raw, Code: code, Message: fmt.Sprintf(format, args...)}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fail(URLErrorFetchFailed, "invalid URL: %v", err)
+	}
+	if !f.allowed(u) {
+		return fail(URLErrorNotAllowed, "host %s is not in the egress allowlist", u.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return fail(URLErrorFetchFailed, "failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9")
+	req.Header.Set("User-Agent", "legal-rag-backend/1.0")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return fail(URLErrorFetchFailed, "failed to fetch page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fail(URLErrorHTTPStatus, "page returned status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxPageBytes), contentType)
+	if err != nil {
+		return fail(URLErrorUnsupported, "unsupported charset: %v", err)
+	}
+
+	var title, text string
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		title, text, err = extractMainText(body)
+	case "text/plain", "text/markdown":
+		var data []byte
+		data, err = io.ReadAll(body)
+		text = string(data)
+	default:
+		return fail(URLErrorUnsupported, "unsupported content type %q", mediaType)
+	}
+	if err != nil {
+		return fail(URLErrorFetchFailed, "failed to read page: %v", err)
+	}
+
+	text = truncateUTF8(strings.TrimSpace(text), f.maxBytes)
+	if text == "" {
+		return fail(URLErrorEmpty, "page contains no text")
+	}
+	if title == "" {
+		title = u.String()
+	}
+	return &engine.ContextDocument{Name: title, Text: text, Source: "url"}, nil
+}
+
+// validateContextURLs checks the number and syntax of context URLs. Whether a
+// URL is allowlisted is reported per URL when it is fetched.
+func validateContextURLs(urls []string, maxCount int) []Violation {
+	var violations []Violation
+	if len(urls) > maxCount {
+		violations = append(violations, Violation{
+			Field:   "context_urls",
+			Code:    ViolationOutOfRange,
+			Message: fmt.Sprintf("at most %d context URLs are allowed per query", maxCount),
+		})
+	}
+
+	for i, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			field := fmt.Sprintf("context_urls[%d]", i)
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationInvalidType,
+				Message: fmt.Sprintf("%s must be an absolute http or https URL", field),
+			})
+		}
+	}
+	return violations
+}
+
+// Elements whose text is never part of the main content
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"nav": true, "header": true, "footer": true, "aside": true,
+	"form": true, "button": true, "svg": true, "iframe": true,
+}
+
+// Elements that start a new line in the extracted text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "tr": true, "br": true, "table": true, "blockquote": true, "pre": true,
+}
+
+// extractMainText returns the page title and the readable text of the
+// <main> or <article> element, falling back to <body>, without navigation,
+// scripts and other page chrome
+func extractMainText(r io.Reader) (string, string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	var title string
+	if t := findElement(doc, "title"); t != nil {
+		title = strings.Join(strings.Fields(nodeText(t)), " ")
+	}
+
+	root := findElement(doc, "main")
+	if root == nil {
+		root = findElement(doc, "article")
+	}
+	if root == nil {
+		root = findElement(doc, "body")
+	}
+	if root == nil {
+		return title, "", nil
+	}
+
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.ElementNode:
+			if skippedElements[n.Data] {
+				return
+			}
+			if blockElements[n.Data] {
+				sb.WriteByte('\n')
+			}
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			sb.WriteByte('\n')
+		}
+	}
+	walk(root)
+
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return title, strings.Join(lines, "\n"), nil
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(nodeText(child))
+	}
+	return sb.String()
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
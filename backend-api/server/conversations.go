@@ -0,0 +1,421 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const (
+	maxConversationMessages = 200
+	maxConversationTitleLen = 200
+
+	// conversationTitleLen bounds the title taken from the first question
+	conversationTitleLen = 80
+
+	// summaryKeepTurns are the latest messages a summary leaves out, so
+	// that the next question still sees them word for word
+	summaryKeepTurns = 2
+	// summaryMaxWords bounds the length of a conversation summary
+	summaryMaxWords = 250
+)
+
+// ConversationConfig controls conversations. The last MaxTurns messages of
+// a conversation are sent to the engine with each question; conversations
+// idle for TTL are dropped. Once the messages not yet summarized exceed
+// SummaryTokens or MaxTurns, the engine summarizes all but the latest of
+// them into the rolling summary sent along with the history; 0 disables
+// summaries.
+type ConversationConfig struct {
+	MaxTurns      int
+	TTL           time.Duration
+	SummaryTokens int
+}
+
+// Conversation is a series of questions whose earlier answers are context
+// for the next one. It is visible to the user who started it only.
+type Conversation struct {
+	ID        string                `json:"id"`
+	TenantID  string                `json:"tenant_id,omitempty"`
+	Owner     string                `json:"owner,omitempty"`
+	Title     string                `json:"title,omitempty"`
+	Messages  []ConversationMessage `json:"messages"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+
+	// Summary is what the engine is told of the summarized messages, i.e.
+	// what the assistant remembers of them
+	Summary *ConversationSummary `json:"summary,omitempty"`
+}
+
+// ConversationMessage is an answered question of a conversation. Once
+// Summarized, it is sent to the engine through the summary only.
+type ConversationMessage struct {
+	Question   string    `json:"question"`
+	Answer     string    `json:"answer"`
+	HistoryID  string    `json:"history_id,omitempty"`
+	Summarized bool      `json:"summarized,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ConversationSummary is the rolling summary of the earlier messages of a
+// long conversation
+type ConversationSummary struct {
+	Text string `json:"text"`
+	// Messages counts the messages summarized so far
+	Messages  int       `json:"messages"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var errConversationNotFound = errors.New("conversation not found")
+
+// ConversationStore keeps conversations in memory, persisted to a JSON file
+// when a path is configured
+type ConversationStore struct {
+	mu            sync.Mutex
+	path          string
+	config        ConversationConfig
+	conversations map[string]*Conversation
+	now           func() time.Time
+}
+
+func NewConversationStore(path string, config ConversationConfig) (*ConversationStore, error) {
+	store := &ConversationStore{
+		path:          path,
+		config:        config,
+		conversations: make(map[string]*Conversation),
+		now:           time.Now,
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var conversations []*Conversation
+	if _, err := readJSONFile(path, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to load conversations: %w", err)
+	}
+	for _, conv := range conversations {
+		if !store.expired(conv) {
+			store.conversations[conv.ID] = conv
+		}
+	}
+	return store, nil
+}
+
+func (s *ConversationStore) expired(conv *Conversation) bool {
+	return s.config.TTL > 0 && s.now().Sub(conv.UpdatedAt) > s.config.TTL
+}
+
+// lookupLocked returns a conversation of a user that has not expired
+func (s *ConversationStore) lookupLocked(id, tenantID, user string) (*Conversation, error) {
+	conv, ok := s.conversations[id]
+	if !ok || conv.TenantID != tenantID || conv.Owner != user || s.expired(conv) {
+		return nil, errConversationNotFound
+	}
+	return conv, nil
+}
+
+func (s *ConversationStore) Create(tenantID, user, title string) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().UTC()
+	conv := &Conversation{
+		ID:        "conv_" + randomHex(8),
+		TenantID:  tenantID,
+		Owner:     user,
+		Title:     title,
+		Messages:  []ConversationMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.conversations[conv.ID] = conv
+	return *conv, s.saveLocked()
+}
+
+func (s *ConversationStore) Get(id, tenantID, user string) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, err := s.lookupLocked(id, tenantID, user)
+	if err != nil {
+		return Conversation{}, err
+	}
+	return *conv, nil
+}
+
+// History returns the turns sent to the engine with the next question of a
+// conversation, oldest first
+func (s *ConversationStore) History(id, tenantID, user string) ([]engine.ChatTurn, error) {
+	_, history, err := s.Context(id, tenantID, user)
+	return history, err
+}
+
+// Context returns the summary and the turns sent to the engine with the
+// next question of a conversation. The turns are the latest messages the
+// summary does not cover, oldest first.
+func (s *ConversationStore) Context(id, tenantID, user string) (string, []engine.ChatTurn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, err := s.lookupLocked(id, tenantID, user)
+	if err != nil {
+		return "", nil, err
+	}
+	messages := unsummarized(conv.Messages)
+	messages = messages[max(0, len(messages)-s.config.MaxTurns):]
+	history := make([]engine.ChatTurn, 0, len(messages))
+	for _, m := range messages {
+		history = append(history, engine.ChatTurn{Question: m.Question, Answer: m.Answer})
+	}
+	var summary string
+	if conv.Summary != nil {
+		summary = conv.Summary.Text
+	}
+	return summary, history, nil
+}
+
+// unsummarized returns the messages the summary does not cover yet, which
+// always follow those it does
+func unsummarized(messages []ConversationMessage) []ConversationMessage {
+	for i, m := range messages {
+		if !m.Summarized {
+			return messages[i:]
+		}
+	}
+	return nil
+}
+
+// pendingSummary returns the request summarizing a conversation whose
+// unsummarized messages outgrew the token budget or the turns sent as
+// history, and how many messages it covers
+func (s *ConversationStore) pendingSummary(id string) (*engine.SummarizeRequest, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[id]
+	if !ok || s.expired(conv) || s.config.SummaryTokens == 0 || s.config.MaxTurns == 0 {
+		return nil, 0, false
+	}
+	messages := unsummarized(conv.Messages)
+	tokens := 0
+	for _, m := range messages {
+		tokens += estimateTokens(m.Question) + estimateTokens(m.Answer)
+	}
+	if tokens <= s.config.SummaryTokens && len(messages) <= s.config.MaxTurns {
+		return nil, 0, false
+	}
+	n := len(messages) - min(summaryKeepTurns, s.config.MaxTurns)
+	if n <= 0 {
+		return nil, 0, false
+	}
+
+	req := &engine.SummarizeRequest{MaxWords: summaryMaxWords}
+	if conv.Summary != nil {
+		req.Summary = conv.Summary.Text
+	}
+	for _, m := range messages[:n] {
+		req.Turns = append(req.Turns, engine.ChatTurn{Question: m.Question, Answer: m.Answer})
+	}
+	return req, n, true
+}
+
+// applySummary replaces the summary of a conversation with one that also
+// covers its n oldest unsummarized messages
+func (s *ConversationStore) applySummary(id, text string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[id]
+	if !ok {
+		return errConversationNotFound
+	}
+	// Conversations returned earlier share the messages
+	conv.Messages = slices.Clone(conv.Messages)
+	messages := unsummarized(conv.Messages)
+	n = min(n, len(messages))
+	for i := range n {
+		messages[i].Summarized = true
+	}
+	summarized := n
+	if conv.Summary != nil {
+		summarized += conv.Summary.Messages
+	}
+	conv.Summary = &ConversationSummary{Text: text, Messages: summarized, UpdatedAt: s.now().UTC()}
+	return s.saveLocked()
+}
+
+// estimateTokens estimates the tokens of a text at four characters a token,
+// which errs on the high side for Vietnamese
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// ConversationSummarizer keeps the summaries of long conversations. The
+// engine summarizes in the background after an answer, one summary of a
+// conversation at a time.
+type ConversationSummarizer struct {
+	engine     engine.Summarizer
+	store      *ConversationStore
+	timeout    time.Duration
+	background *sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func newConversationSummarizer(summarizer engine.Summarizer, store *ConversationStore, timeout time.Duration, background *sync.WaitGroup) *ConversationSummarizer {
+	if summarizer == nil || store.config.SummaryTokens == 0 {
+		return nil
+	}
+	return &ConversationSummarizer{
+		engine:     summarizer,
+		store:      store,
+		timeout:    timeout,
+		background: background,
+		running:    make(map[string]bool),
+	}
+}
+
+// Start summarizes a conversation in the background when it has outgrown
+// its budget. A failed summary is tried again after the next answer; until
+// then the latest messages are sent as before.
+func (s *ConversationSummarizer) Start(ctx context.Context, id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return
+	}
+	req, n, ok := s.store.pendingSummary(id)
+	if !ok {
+		return
+	}
+	s.running[id] = true
+	ctx = context.WithoutCancel(ctx)
+	s.background.Go(func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, id)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		resp, err := s.engine.Summarize(ctx, req)
+		if err == nil && strings.TrimSpace(resp.Summary) == "" {
+			err = errors.New("the engine returned an empty summary")
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to summarize the conversation", "conversation_id", id, "error", err)
+			return
+		}
+		if err := s.store.applySummary(id, strings.TrimSpace(resp.Summary), n); err != nil {
+			slog.ErrorContext(ctx, "Failed to save the conversation summary", "conversation_id", id, "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "Summarized conversation", "conversation_id", id, "messages", n)
+	})
+}
+
+// Append adds an answered question to a conversation, dropping the oldest
+// messages beyond maxConversationMessages. A conversation without a title
+// is named after its first question.
+func (s *ConversationStore) Append(id, tenantID, user string, m ConversationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, err := s.lookupLocked(id, tenantID, user)
+	if err != nil {
+		return err
+	}
+	m.CreatedAt = s.now().UTC()
+	conv.Messages = append(conv.Messages, m)
+	if len(conv.Messages) > maxConversationMessages {
+		conv.Messages = conv.Messages[len(conv.Messages)-maxConversationMessages:]
+	}
+	if conv.Title == "" {
+		conv.Title = conversationTitle(m.Question)
+	}
+	conv.UpdatedAt = m.CreatedAt
+	return s.saveLocked()
+}
+
+// conversationTitle shortens a question to a title
+func conversationTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if utf8.RuneCountInString(title) <= conversationTitleLen {
+		return title
+	}
+	runes := []rune(title)[:conversationTitleLen]
+	return strings.TrimSpace(string(runes)) + "…"
+}
+
+// saveLocked persists the conversations, dropping those that expired
+func (s *ConversationStore) saveLocked() error {
+	conversations := make([]*Conversation, 0, len(s.conversations))
+	for id, conv := range s.conversations {
+		if s.expired(conv) {
+			delete(s.conversations, id)
+			continue
+		}
+		conversations = append(conversations, conv)
+	}
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, conversations)
+}
+
+// Handlers
+
+// ConversationRequest is the body of POST /api/conversations
+type ConversationRequest struct {
+	Title string `json:"title"`
+}
+
+func createConversationHandler(store *ConversationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ConversationRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+				return
+			}
+		}
+		title := strings.TrimSpace(req.Title)
+		if utf8.RuneCountInString(title) > maxConversationTitleLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("title must be at most %d characters", maxConversationTitleLen))
+			return
+		}
+
+		tenant, _ := callerTenant(c)
+		conv, err := store.Create(tenant.ID, callerUser(c), title)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save conversation", "conversation_id", conv.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save conversation")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Started conversation", "conversation_id", conv.ID)
+		c.Header("Location", "/api/conversations/"+conv.ID)
+		c.JSON(http.StatusCreated, conv)
+	}
+}
+
+func getConversationHandler(store *ConversationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		conv, err := store.Get(c.Param("id"), tenant.ID, callerUser(c))
+		if err != nil {
+			abortWithError(c, ErrCodeConversationNotFound, fmt.Sprintf("Conversation %q not found", c.Param("id")))
+			return
+		}
+		c.JSON(http.StatusOK, conv)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestConversations(t *testing.T) {
+	t.Setenv("CONVERSATION_MAX_TURNS", "1")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019, không quá 60 ngày.", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	var conv Conversation
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/conversations", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &conv); err != nil || rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/api/conversations/"+conv.ID {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body.String())
+	}
+
+	questions := []string{
+		"Thời gian thử việc tối đa đối với người có trình độ đại học theo Bộ luật Lao động 2019 là bao lâu?",
+		"Còn đối với trình độ cao đẳng thì sao?",
+		"Trong thời gian thử việc có được đơn phương chấm dứt không?",
+	}
+	for _, q := range questions {
+		if rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: q, ConversationID: conv.ID}); rec.Code != http.StatusOK {
+			t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if len(stub.requests) != 3 || len(stub.requests[0].History) != 0 {
+		t.Fatalf("engine requests = %d, want 3 with no history at first", len(stub.requests))
+	}
+	// Only the last CONVERSATION_MAX_TURNS answers are sent as history
+	if history := stub.requests[2].History; len(history) != 1 || history[0].Question != questions[1] || history[0].Answer != stub.resp.Answer {
+		t.Errorf("history = %+v, want the second question only", history)
+	}
+
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/conversations/"+conv.ID, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &conv); err != nil || len(conv.Messages) != 3 || conv.Messages[0].HistoryID == "" || conv.Title != conversationTitle(questions[0]) {
+		t.Errorf("get = %d %s, want the three answered questions", rec.Code, rec.Body.String())
+	}
+
+	if code := decodeError(t, doAs(t, h, "minh", http.MethodGet, "/api/conversations/"+conv.ID, nil)).Code; code != ErrCodeConversationNotFound {
+		t.Errorf("another user's conversation = %s, want %s", code, ErrCodeConversationNotFound)
+	}
+	rec = doAs(t, h, "minh", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: questions[1], ConversationID: conv.ID})
+	if code := decodeError(t, rec).Code; code != ErrCodeConversationNotFound || len(stub.requests) != 3 {
+		t.Errorf("query in another user's conversation = %s, want %s without calling the engine", code, ErrCodeConversationNotFound)
+	}
+}
+
+func TestConversationStoreExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.json")
+	store, err := NewConversationStore(path, ConversationConfig{MaxTurns: 10, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	conv, _ := store.Create("acme", "lan", "Hợp đồng lao động")
+	if err := store.Append(conv.ID, "acme", "lan", ConversationMessage{Question: "Thử việc?", Answer: "60 ngày"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewConversationStore(path, ConversationConfig{MaxTurns: 10, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history, err := reloaded.History(conv.ID, "acme", "lan"); err != nil || len(history) != 1 {
+		t.Errorf("reloaded history = %v %v, want one turn", history, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := store.Get(conv.ID, "acme", "lan"); err != errConversationNotFound {
+		t.Errorf("idle conversation = %v, want %v", err, errConversationNotFound)
+	}
+}
+
+// summarizingEngine answers like stubEngine and summarizes conversations
+type summarizingEngine struct {
+	*stubEngine
+	summaries chan engine.SummarizeRequest
+}
+
+func (e *summarizingEngine) Summarize(_ context.Context, req *engine.SummarizeRequest) (*engine.SummarizeResponse, error) {
+	e.summaries <- *req
+	return &engine.SummarizeResponse{Summary: "Người hỏi có trình độ đại học, thử việc tối đa 60 ngày."}, nil
+}
+
+func TestConversationSummary(t *testing.T) {
+	t.Setenv("CONVERSATION_MAX_TURNS", "3")
+	t.Setenv("CONVERSATION_SUMMARY_TOKENS", "50")
+	stub := &summarizingEngine{
+		stubEngine: &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019, không quá 60 ngày.", Iterations: 1}},
+		summaries:  make(chan engine.SummarizeRequest, 1),
+	}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	var conv Conversation
+	json.Unmarshal(doAs(t, h, "lan", http.MethodPost, "/api/conversations", nil).Body.Bytes(), &conv)
+	ask := func(q string) {
+		t.Helper()
+		if rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: q, ConversationID: conv.ID}); rec.Code != http.StatusOK {
+			t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	questions := []string{
+		"Thời gian thử việc tối đa đối với người có trình độ đại học là bao lâu?",
+		"Còn đối với trình độ cao đẳng thì sao?",
+		"Trong thời gian thử việc có được đơn phương chấm dứt không?",
+		"Tiền lương thử việc ít nhất bằng bao nhiêu?",
+	}
+	for _, q := range questions[:3] {
+		ask(q)
+	}
+
+	// The third answer exceeds the budget; the latest two stay verbatim
+	select {
+	case req := <-stub.summaries:
+		if len(req.Turns) != 1 || req.Turns[0].Question != questions[0] || req.Summary != "" {
+			t.Errorf("summarize request = %+v, want the first question", req)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the conversation was not summarized")
+	}
+	for deadline := time.Now().Add(2 * time.Second); conv.Summary == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/conversations/"+conv.ID, nil).Body.Bytes(), &conv)
+	}
+	if conv.Summary == nil || conv.Summary.Messages != 1 || !conv.Messages[0].Summarized || conv.Messages[1].Summarized {
+		t.Fatalf("conversation = %+v, want the first message summarized", conv)
+	}
+
+	ask(questions[3])
+	last := stub.requests[len(stub.requests)-1]
+	if last.ConversationSummary != conv.Summary.Text || len(last.History) != 2 || last.History[0].Question != questions[1] {
+		t.Errorf("engine request summary %q, history %+v, want the summary and the two unsummarized turns", last.ConversationSummary, last.History)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Question difficulties
+const (
+	// DifficultySimple is a lookup: one article, threshold or deadline
+	DifficultySimple = "simple"
+
+	// DifficultyComplex needs analysis across articles or facts
+	DifficultyComplex = "complex"
+)
+
+var difficulties = []string{DifficultySimple, DifficultyComplex}
+
+// Questions longer than this are complex whatever they ask
+const maxSimpleQuestionWords = 30
+
+var (
+	// lookupPattern matches questions asking for a single fact
+	lookupPattern = regexp.MustCompile(`(?i)bao nhiêu|bao lâu|là gì|quy định gì|quy định như thế nào|tối đa|tối thiểu|thời hạn|mức|how (many|long|much)|what is`)
+
+	// analysisPattern matches questions that need the full pipeline: facts
+	// to weigh, options to compare or advice to give
+	analysisPattern = regexp.MustCompile(`(?i)so sánh|phân tích|đánh giá|trường hợp|nếu|tư vấn|rủi ro|chiến lược|khác nhau|khác biệt|mâu thuẫn|giải quyết|tranh chấp|có nên|nên làm|compare|analy[sz]e|what if|should`)
+)
+
+// classifyDifficulty estimates whether a question is a simple lookup. It
+// errs towards complex: only short questions that name an article or ask for
+// a single fact, with no attached context, are simple.
+func classifyDifficulty(req *LegalQueryRequest) string {
+	if len(req.Attachments) > 0 || len(req.ContextURLs) > 0 {
+		return DifficultyComplex
+	}
+	question := strings.TrimSpace(req.Question)
+	if len(strings.Fields(question)) > maxSimpleQuestionWords || strings.Count(question, "?") > 1 {
+		return DifficultyComplex
+	}
+	if analysisPattern.MatchString(question) {
+		return DifficultyComplex
+	}
+	if articleCitationPattern.MatchString(question) || lookupPattern.MatchString(question) {
+		return DifficultySimple
+	}
+	return DifficultyComplex
+}
+
+// difficultyRouting sends simple questions down a fast path: one retrieval
+// iteration, corpus search only and the fast model when one is configured.
+// Parameters the client or tenant set explicitly are never overridden.
+type difficultyRouting struct {
+	enabled   bool
+	fastModel string
+}
+
+// route classifies req and, for a simple question, adjusts the engine request
+func (r difficultyRouting) route(req *LegalQueryRequest, defaults QueryDefaults, pythonReq *engine.PythonQueryRequest) {
+	if !r.enabled {
+		return
+	}
+	pythonReq.Difficulty = classifyDifficulty(req)
+	if pythonReq.Difficulty != DifficultySimple {
+		return
+	}
+	if req.MaxIterations == nil && defaults.MaxIterations == nil {
+		pythonReq.MaxIterations = 1
+	}
+	if req.EnableWebSearch == nil && defaults.EnableWebSearch == nil {
+		pythonReq.EnableWebSearch = false
+	}
+	if req.Model == "" && defaults.Model == "" && r.fastModel != "" {
+		pythonReq.Model = r.fastModel
+	}
+}
+
+// RoutingStats counts routed queries and their engine latency per
+// difficulty, to check the fast path pays off
+type RoutingStats struct {
+	mu       sync.Mutex
+	queries  map[string]int
+	duration map[string]time.Duration
+}
+
+func NewRoutingStats() *RoutingStats {
+	return &RoutingStats{queries: make(map[string]int), duration: make(map[string]time.Duration)}
+}
+
+// Record counts an answered query. Unrouted, cached and sandboxed queries
+// are skipped.
+func (s *RoutingStats) Record(req *engine.PythonQueryRequest, resp *engine.LegalQueryResponse, took time.Duration) {
+	if req.Difficulty == "" || resp.Cached || resp.Sandbox {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[req.Difficulty]++
+	s.duration[req.Difficulty] += took
+}
+
+// DifficultySummary describes the queries of one difficulty
+type DifficultySummary struct {
+	Queries          int   `json:"queries"`
+	AverageLatencyMs int64 `json:"average_latency_ms"`
+}
+
+// RoutingSummary is returned by the routing stats endpoint
+type RoutingSummary struct {
+	Enabled      bool                         `json:"enabled"`
+	FastModel    string                       `json:"fast_model,omitempty"`
+	Difficulties map[string]DifficultySummary `json:"difficulties"`
+}
+
+func (s *RoutingStats) Summary() map[string]DifficultySummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := make(map[string]DifficultySummary, len(difficulties))
+	for _, d := range difficulties {
+		ds := DifficultySummary{Queries: s.queries[d]}
+		if ds.Queries > 0 {
+			ds.AverageLatencyMs = (s.duration[d] / time.Duration(ds.Queries)).Milliseconds()
+		}
+		summary[d] = ds
+	}
+	return summary
+}
+
+// Handlers
+
+func routingStatsHandler(routing difficultyRouting, stats *RoutingStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, RoutingSummary{
+			Enabled:      routing.enabled,
+			FastModel:    routing.fastModel,
+			Difficulties: stats.Summary(),
+		})
+	}
+}
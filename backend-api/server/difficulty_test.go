@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestClassifyDifficulty(t *testing.T) {
+	tests := []struct {
+		req  LegalQueryRequest
+		want string
+	}{
+		{LegalQueryRequest{Question: "Điều 25 Bộ luật Lao động 2019 quy định gì?"}, DifficultySimple},
+		{LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao nhiêu ngày?"}, DifficultySimple},
+		{LegalQueryRequest{Question: "So sánh Điều 35 và Điều 36 Bộ luật Lao động 2019"}, DifficultyComplex},
+		{LegalQueryRequest{Question: "Nếu người lao động nghỉ việc không báo trước thì phải bồi thường bao nhiêu?"}, DifficultyComplex},
+		{LegalQueryRequest{Question: "Công ty tôi nợ lương ba tháng, tôi cần làm gì?"}, DifficultyComplex},
+		{LegalQueryRequest{Question: "Điều 25 quy định gì?", ContextURLs: []string{"https://example.com/hop-dong"}}, DifficultyComplex},
+	}
+	for _, tt := range tests {
+		if got := classifyDifficulty(&tt.req); got != tt.want {
+			t.Errorf("classifyDifficulty(%q) = %s, want %s", tt.req.Question, got, tt.want)
+		}
+	}
+}
+
+func TestDifficultyRouting(t *testing.T) {
+	t.Setenv("DIFFICULTY_ROUTING", "true")
+	t.Setenv("FAST_PATH_MODEL", "gemini-flash")
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 60 ngày.", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	query := func(req LegalQueryRequest) (engine.PythonQueryRequest, engine.LegalQueryResponse) {
+		t.Helper()
+		rec := doJSON(t, h, http.MethodPost, "/api/legal-query", req)
+		var resp engine.LegalQueryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.Meta == nil {
+			t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+		}
+		return stub.requests[len(stub.requests)-1], resp
+	}
+
+	simple := "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	got, resp := query(LegalQueryRequest{Question: simple})
+	if got.Difficulty != DifficultySimple || got.MaxIterations != 1 || got.EnableWebSearch || got.Model != "gemini-flash" {
+		t.Errorf("simple question request = %+v, want the fast path", got)
+	}
+	if resp.Meta.Difficulty != DifficultySimple || resp.Meta.Features[0] != FeatureFastPath {
+		t.Errorf("meta = %+v, want the fast path reported", resp.Meta)
+	}
+
+	// Explicit parameters win over the fast path
+	iterations := 3
+	got, _ = query(LegalQueryRequest{Question: simple, MaxIterations: &iterations, Model: "gemini-pro"})
+	if got.MaxIterations != 3 || got.Model != "gemini-pro" {
+		t.Errorf("explicit parameters = %d %q, want 3 gemini-pro", got.MaxIterations, got.Model)
+	}
+
+	got, _ = query(LegalQueryRequest{Question: "Phân tích rủi ro khi doanh nghiệp đơn phương chấm dứt hợp đồng lao động với người lao động đang mang thai"})
+	if got.Difficulty != DifficultyComplex || got.MaxIterations != 3 || got.Model != "" {
+		t.Errorf("complex question request = %+v, want the full pipeline", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/routing", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var summary RoutingSummary
+	json.Unmarshal(rec.Body.Bytes(), &summary)
+	if !summary.Enabled || summary.Difficulties[DifficultySimple].Queries != 2 || summary.Difficulties[DifficultyComplex].Queries != 1 {
+		t.Errorf("routing stats = %+v, want 2 simple and 1 complex queries", summary)
+	}
+}
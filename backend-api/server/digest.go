@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const (
+	maxCorpusChanges        = 5000
+	maxCorpusChangesPerCall = 100
+	maxCorpusChangeTextLen  = 50000
+
+	// maxDigestPeriod bounds the period of one digest
+	maxDigestPeriod = 366 * 24 * time.Hour
+)
+
+// Kinds of corpus changes
+const (
+	CorpusChangeNew      = "new"
+	CorpusChangeAmended  = "amended"
+	CorpusChangeRepealed = "repealed"
+)
+
+// CorpusChange is a change of the legal corpus reported by the ingestion
+// pipeline: a new legal document, or an amended or repealed one. Articles
+// lists the articles amended or repealed when not all of them are.
+type CorpusChange struct {
+	ID            string    `json:"id"`
+	Kind          string    `json:"kind"`
+	Document      string    `json:"document"`
+	Title         string    `json:"title"`
+	Articles      []string  `json:"articles,omitempty"`
+	EffectiveDate string    `json:"effective_date,omitempty"`
+	Summary       string    `json:"summary,omitempty"`
+	ReportedAt    time.Time `json:"reported_at"`
+}
+
+// CorpusChangeLog keeps the reported corpus changes, persisted to a JSON
+// file when a path is configured. The oldest are dropped beyond
+// maxCorpusChanges.
+type CorpusChangeLog struct {
+	mu      sync.Mutex
+	path    string
+	changes []*CorpusChange
+}
+
+func NewCorpusChangeLog(path string) (*CorpusChangeLog, error) {
+	log := &CorpusChangeLog{path: path}
+	if path != "" {
+		if _, err := readJSONFile(path, &log.changes); err != nil {
+			return nil, fmt.Errorf("failed to load corpus changes: %w", err)
+		}
+	}
+	return log, nil
+}
+
+// Add records changes and returns them with their IDs
+func (l *CorpusChangeLog) Add(changes []CorpusChange) ([]CorpusChange, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC()
+	for i := range changes {
+		changes[i].ID = "cc_" + randomHex(8)
+		changes[i].ReportedAt = now
+		change := changes[i]
+		l.changes = append(l.changes, &change)
+	}
+	if len(l.changes) > maxCorpusChanges {
+		l.changes = slices.Clone(l.changes[len(l.changes)-maxCorpusChanges:])
+	}
+	return changes, l.saveLocked()
+}
+
+// setSummary stores the summary of a change
+func (l *CorpusChangeLog) setSummary(id, summary string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.changes {
+		if c.ID == id {
+			c.Summary = summary
+			return l.saveLocked()
+		}
+	}
+	return nil
+}
+
+// Between returns the changes reported at or after since and before until,
+// oldest first
+func (l *CorpusChangeLog) Between(since, until time.Time) []CorpusChange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var changes []CorpusChange
+	for _, c := range l.changes {
+		if !c.ReportedAt.Before(since) && c.ReportedAt.Before(until) {
+			changes = append(changes, *c)
+		}
+	}
+	return changes
+}
+
+// Latest returns the n changes reported last, newest first
+func (l *CorpusChangeLog) Latest(n int) []CorpusChange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changes := make([]CorpusChange, 0, min(n, len(l.changes)))
+	for i := len(l.changes) - 1; i >= 0 && len(changes) < n; i-- {
+		changes = append(changes, *l.changes[i])
+	}
+	return changes
+}
+
+func (l *CorpusChangeLog) saveLocked() error {
+	if l.path == "" {
+		return nil
+	}
+	return writeJSONFile(l.path, l.changes)
+}
+
+// summarizeCorpusChange asks the engine for a one-paragraph summary of a
+// change for the digest, from the text of the document when the pipeline
+// sent it. A failed summary leaves the change without one.
+func summarizeCorpusChange(ctx context.Context, e engine.QueryEngine, change CorpusChange, text string) string {
+	name := change.Title
+	if len(change.Articles) > 0 {
+		name = fmt.Sprintf("%s (%s)", change.Title, strings.Join(change.Articles, ", "))
+	}
+	var prompt string
+	switch change.Kind {
+	case CorpusChangeNew:
+		prompt = fmt.Sprintf("Tóm tắt trong một đoạn văn ngắn, bằng ngôn ngữ dễ hiểu cho người không chuyên, "+
+			"nội dung chính của văn bản mới ban hành %s và những ai chịu ảnh hưởng.", name)
+	case CorpusChangeAmended:
+		prompt = fmt.Sprintf("Tóm tắt trong một đoạn văn ngắn, bằng ngôn ngữ dễ hiểu cho người không chuyên, "+
+			"những điểm được sửa đổi, bổ sung trong %s và ảnh hưởng thực tế của chúng.", name)
+	default:
+		prompt = fmt.Sprintf("%s đã bị bãi bỏ. Tóm tắt trong một đoạn văn ngắn, bằng ngôn ngữ dễ hiểu, "+
+			"những quy định không còn áp dụng và điều người đọc cần lưu ý.", name)
+	}
+	req := buildPythonRequest(&LegalQueryRequest{Question: prompt}, QueryDefaults{}, plans[defaultPlanName])
+	req.EnableWebSearch = false
+	req.MaxIterations = 1
+	if text != "" {
+		req.ContextDocuments = []engine.ContextDocument{{Name: change.Title, Source: change.Document, Text: text}}
+	}
+	resp, err := engine.QueryWithContext(ctx, e, req)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to summarize corpus change", "document", change.Document, "kind", change.Kind, "error", err)
+		return ""
+	}
+	return strings.TrimSpace(resp.Answer)
+}
+
+// CorpusChangeInput is a change reported to POST /admin/corpus/changes.
+// Text, e.g. the new or amended articles, is only used for the summary.
+type CorpusChangeInput struct {
+	Kind          string   `json:"kind"`
+	Document      string   `json:"document"`
+	Title         string   `json:"title"`
+	Articles      []string `json:"articles"`
+	EffectiveDate string   `json:"effective_date"`
+	Text          string   `json:"text"`
+}
+
+// ReportCorpusChangesRequest is the body of POST /admin/corpus/changes
+type ReportCorpusChangesRequest struct {
+	Changes []CorpusChangeInput `json:"changes"`
+}
+
+// Digest lists the corpus changes of a period by kind, oldest first
+type Digest struct {
+	Since    time.Time      `json:"since"`
+	Until    time.Time      `json:"until"`
+	Counts   map[string]int `json:"counts"`
+	New      []CorpusChange `json:"new"`
+	Amended  []CorpusChange `json:"amended"`
+	Repealed []CorpusChange `json:"repealed"`
+}
+
+func newDigest(since, until time.Time, changes []CorpusChange) Digest {
+	d := Digest{Since: since, Until: until, New: []CorpusChange{}, Amended: []CorpusChange{}, Repealed: []CorpusChange{}}
+	for _, c := range changes {
+		switch c.Kind {
+		case CorpusChangeNew:
+			d.New = append(d.New, c)
+		case CorpusChangeAmended:
+			d.Amended = append(d.Amended, c)
+		case CorpusChangeRepealed:
+			d.Repealed = append(d.Repealed, c)
+		}
+	}
+	d.Counts = map[string]int{CorpusChangeNew: len(d.New), CorpusChangeAmended: len(d.Amended), CorpusChangeRepealed: len(d.Repealed)}
+	return d
+}
+
+// Handlers
+
+// digestHandler returns the digest of the changes reported since a time
+// or date, until now or the until bound
+func digestHandler(log *CorpusChangeLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("since") == "" {
+			abortWithInvalidParam(c, "since", "since is required")
+			return
+		}
+		since, err := parseHistoryTime(c.Query("since"), false)
+		if err != nil {
+			abortWithInvalidParam(c, "since", fmt.Sprintf("since %v", err))
+			return
+		}
+		until := time.Now().UTC()
+		if c.Query("until") != "" {
+			if until, err = parseHistoryTime(c.Query("until"), true); err != nil {
+				abortWithInvalidParam(c, "until", fmt.Sprintf("until %v", err))
+				return
+			}
+		}
+		switch {
+		case !since.Before(until):
+			abortWithInvalidParam(c, "since", "since must be before until")
+			return
+		case until.Sub(since) > maxDigestPeriod:
+			abortWithInvalidParam(c, "since", "a digest covers at most 366 days")
+			return
+		}
+		c.JSON(http.StatusOK, newDigest(since, until, log.Between(since, until)))
+	}
+}
+
+// reportCorpusChangesHandler records the changes the ingestion pipeline
+// made to the corpus. Their summaries are generated in the background,
+// one at a time.
+func reportCorpusChangesHandler(log *CorpusChangeLog, e engine.QueryEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReportCorpusChangesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if len(req.Changes) == 0 || len(req.Changes) > maxCorpusChangesPerCall {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("changes must list 1 to %d changes", maxCorpusChangesPerCall))
+			return
+		}
+		changes := make([]CorpusChange, 0, len(req.Changes))
+		texts := make([]string, 0, len(req.Changes))
+		for i, in := range req.Changes {
+			in.Document = strings.TrimSpace(in.Document)
+			switch {
+			case in.Kind != CorpusChangeNew && in.Kind != CorpusChangeAmended && in.Kind != CorpusChangeRepealed:
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("changes[%d].kind must be new, amended or repealed", i))
+				return
+			case in.Document == "":
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("changes[%d].document is required", i))
+				return
+			case len(in.Text) > maxCorpusChangeTextLen:
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("changes[%d].text must be at most %d bytes", i, maxCorpusChangeTextLen))
+				return
+			}
+			if in.EffectiveDate != "" {
+				if _, err := time.Parse(time.DateOnly, in.EffectiveDate); err != nil {
+					abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("changes[%d].effective_date must be a YYYY-MM-DD date", i))
+					return
+				}
+			}
+			title := strings.TrimSpace(in.Title)
+			if title == "" {
+				title = in.Document
+			}
+			changes = append(changes, CorpusChange{
+				Kind:          in.Kind,
+				Document:      in.Document,
+				Title:         title,
+				Articles:      in.Articles,
+				EffectiveDate: in.EffectiveDate,
+			})
+			texts = append(texts, in.Text)
+		}
+
+		changes, err := log.Add(changes)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save corpus changes", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save the corpus changes")
+			return
+		}
+		if e != nil {
+			ctx := context.WithoutCancel(c.Request.Context())
+			go func() {
+				for i, change := range changes {
+					if summary := summarizeCorpusChange(ctx, e, change, texts[i]); summary != "" {
+						if err := log.setSummary(change.ID, summary); err != nil {
+							slog.ErrorContext(ctx, "Failed to save corpus change summary", "change", change.ID, "error", err)
+						}
+					}
+				}
+			}()
+		}
+		c.JSON(http.StatusAccepted, gin.H{"changes": changes})
+	}
+}
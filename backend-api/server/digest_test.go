@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestDigest(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Nghị định mới quy định mức lương tối thiểu vùng từ ngày 01/07/2024."}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	report := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/corpus/changes", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	digest := func(query string) Digest {
+		t.Helper()
+		rec := doAs(t, h, "lan", http.MethodGet, "/api/digest?"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("digest = %d %s", rec.Code, rec.Body.String())
+		}
+		var d Digest
+		if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	rec := report(`{"changes": [
+		{"kind": "new", "document": "nd-74-2024", "title": "Nghị định 74/2024/NĐ-CP", "effective_date": "2024-07-01", "text": "Mức lương tối thiểu vùng I là 4.960.000 đồng/tháng."},
+		{"kind": "repealed", "document": "nd-38-2022", "title": "Nghị định 38/2022/NĐ-CP"}
+	]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("report changes = %d %s", rec.Code, rec.Body.String())
+	}
+	since := time.Now().UTC().Format(time.DateOnly)
+
+	// Summaries are generated in the background
+	var d Digest
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if d = digest("since=" + since); len(d.Repealed) == 1 && d.Repealed[0].Summary != "" {
+			break
+		}
+	}
+	if d.Counts[CorpusChangeNew] != 1 || d.Counts[CorpusChangeAmended] != 0 || d.Counts[CorpusChangeRepealed] != 1 {
+		t.Fatalf("counts = %v, want one new decree and one repeal", d.Counts)
+	}
+	if d.New[0].Summary != stub.resp.Answer || d.New[0].EffectiveDate != "2024-07-01" || d.Repealed[0].Summary != stub.resp.Answer {
+		t.Errorf("digest = %+v, want the changes summarized by the engine", d)
+	}
+	stub.mu.Lock()
+	if len(stub.requests) != 2 || len(stub.requests[0].ContextDocuments) != 1 || len(stub.requests[1].ContextDocuments) != 0 {
+		t.Errorf("engine requests = %d, want the text of the new decree as context only", len(stub.requests))
+	}
+	stub.mu.Unlock()
+
+	if d := digest("since=2020-01-01&until=2020-12-31"); d.Counts[CorpusChangeNew] != 0 || len(d.New) != 0 {
+		t.Errorf("digest of 2020 = %+v, want no change", d)
+	}
+	for _, query := range []string{"", "since=yesterday", "since=2020-01-01", "since=2024-02-01&until=2024-01-01"} {
+		if code := decodeError(t, doAs(t, h, "lan", http.MethodGet, "/api/digest?"+query, nil)).Code; code != ErrCodeInvalidParam {
+			t.Errorf("digest?%s = %s, want %s", query, code, ErrCodeInvalidParam)
+		}
+	}
+	if code := decodeError(t, report(`{"changes": [{"kind": "revised", "document": "nd-74-2024"}]}`)).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("unknown kind = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+}
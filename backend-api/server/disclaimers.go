@@ -0,0 +1,349 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/document"
+)
+
+// DisclaimerState is the stage of a disclaimer version in the sign-off
+// workflow
+type DisclaimerState string
+
+const (
+	DisclaimerPending  DisclaimerState = "pending"
+	DisclaimerApproved DisclaimerState = "approved"
+	DisclaimerRejected DisclaimerState = "rejected"
+
+	// DisclaimerWithdrawn is a pending version replaced by a newer proposal
+	DisclaimerWithdrawn DisclaimerState = "withdrawn"
+)
+
+const maxDisclaimerLen = 2000
+
+// DisclaimerVersion is a disclaimer text proposed for a tenant
+type DisclaimerVersion struct {
+	Version    int             `json:"version"`
+	Text       string          `json:"text"`
+	State      DisclaimerState `json:"state"`
+	ProposedBy string          `json:"proposed_by,omitempty"`
+	ProposedAt time.Time       `json:"proposed_at"`
+	ReviewedBy string          `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time      `json:"reviewed_at,omitempty"`
+	Note       string          `json:"note,omitempty"`
+}
+
+// TenantDisclaimer is the disclaimer history of a tenant. Active is the
+// version in force, the last one approved; 0 while the tenant uses the
+// default disclaimer.
+type TenantDisclaimer struct {
+	TenantID string              `json:"tenant_id"`
+	Active   int                 `json:"active"`
+	Versions []DisclaimerVersion `json:"versions"`
+}
+
+var (
+	errDisclaimerNotFound      = errors.New("disclaimer version not found")
+	errDisclaimerNotPending    = errors.New("disclaimer version is not pending")
+	errComplianceReviewersOnly = errors.New("only compliance reviewers can approve or reject disclaimers")
+	errOwnDisclaimer           = errors.New("a disclaimer must be reviewed by someone other than its author")
+)
+
+// DisclaimerStore keeps the disclaimers of tenants, persisted to a JSON
+// file when a path is configured. A tenant's disclaimer only changes when a
+// compliance reviewer approves a proposed version; until then its answers
+// carry the previous one.
+type DisclaimerStore struct {
+	mu          sync.RWMutex
+	path        string
+	defaultText string
+	tenants     map[string]*TenantDisclaimer
+}
+
+func NewDisclaimerStore(path, defaultText string) (*DisclaimerStore, error) {
+	store := &DisclaimerStore{
+		path:        path,
+		defaultText: defaultText,
+		tenants:     make(map[string]*TenantDisclaimer),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var tenants []*TenantDisclaimer
+	if _, err := readJSONFile(path, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to load disclaimers: %w", err)
+	}
+	for _, t := range tenants {
+		store.tenants[t.TenantID] = t
+	}
+	return store, nil
+}
+
+// Get returns the disclaimer history of a tenant
+func (s *DisclaimerStore) Get(tenantID string) TenantDisclaimer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.tenants[tenantID]; ok {
+		return TenantDisclaimer{TenantID: t.TenantID, Active: t.Active, Versions: slices.Clone(t.Versions)}
+	}
+	return TenantDisclaimer{TenantID: tenantID, Versions: []DisclaimerVersion{}}
+}
+
+// Stamp returns the disclaimer in force for a tenant: its approved
+// version, or the default. A nil store stamps nothing.
+func (s *DisclaimerStore) Stamp(tenantID string) *engine.DisclaimerStamp {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.tenants[tenantID]; ok && t.Active > 0 {
+		v := t.Versions[t.Active-1]
+		return &engine.DisclaimerStamp{Text: v.Text, Version: v.Version, ApprovedBy: v.ReviewedBy}
+	}
+	return &engine.DisclaimerStamp{Text: s.defaultText}
+}
+
+// Propose adds a pending version for a tenant, withdrawing the version
+// still pending
+func (s *DisclaimerStore) Propose(tenantID, text, user string) (DisclaimerVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[tenantID]
+	if !ok {
+		t = &TenantDisclaimer{TenantID: tenantID}
+		s.tenants[tenantID] = t
+	}
+	for i := range t.Versions {
+		if t.Versions[i].State == DisclaimerPending {
+			t.Versions[i].State = DisclaimerWithdrawn
+		}
+	}
+	v := DisclaimerVersion{
+		Version:    len(t.Versions) + 1,
+		Text:       text,
+		State:      DisclaimerPending,
+		ProposedBy: user,
+		ProposedAt: time.Now().UTC(),
+	}
+	t.Versions = append(t.Versions, v)
+	return v, s.saveLocked()
+}
+
+// Review approves or rejects a pending version on behalf of a user. An
+// approved version goes live at once.
+func (s *DisclaimerStore) Review(tenantID string, version int, approve bool, user, note string, reviewer bool) (DisclaimerVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[tenantID]
+	if !ok || version < 1 || version > len(t.Versions) {
+		return DisclaimerVersion{}, errDisclaimerNotFound
+	}
+	v := &t.Versions[version-1]
+	switch {
+	case v.State != DisclaimerPending:
+		return DisclaimerVersion{}, fmt.Errorf("%w: version %d is %s", errDisclaimerNotPending, version, v.State)
+	case !reviewer:
+		return DisclaimerVersion{}, errComplianceReviewersOnly
+	case user == v.ProposedBy:
+		return DisclaimerVersion{}, errOwnDisclaimer
+	}
+	now := time.Now().UTC()
+	v.State = DisclaimerRejected
+	if approve {
+		v.State = DisclaimerApproved
+		t.Active = version
+	}
+	v.ReviewedBy, v.ReviewedAt, v.Note = user, &now, note
+	return *v, s.saveLocked()
+}
+
+func (s *DisclaimerStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	tenants := make([]*TenantDisclaimer, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].TenantID < tenants[j].TenantID })
+	return writeJSONFile(s.path, tenants)
+}
+
+// complianceReviewers lists the users allowed to sign off the disclaimers
+// of a tenant: its own reviewers, or COMPLIANCE_REVIEWERS
+func complianceReviewers(tenant Tenant, defaults []string) []string {
+	if len(tenant.Settings.ComplianceReviewers) > 0 {
+		return tenant.Settings.ComplianceReviewers
+	}
+	return defaults
+}
+
+// addDisclaimer ends an exported document with the disclaimer in force
+func addDisclaimer(doc *document.Document, stamp *engine.DisclaimerStamp) {
+	if stamp == nil || stamp.Text == "" {
+		return
+	}
+	doc.Add(document.Subheading, "Disclaimer")
+	doc.Add(document.Paragraph, stamp.Text)
+}
+
+// Handlers
+
+// DisclaimerRequest is the body of POST /api/disclaimer/versions
+type DisclaimerRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+// DisclaimerReviewRequest is the body of
+// POST /api/disclaimer/versions/:version/review
+type DisclaimerReviewRequest struct {
+	State DisclaimerState `json:"state" binding:"required"`
+	Note  string          `json:"note,omitempty"`
+}
+
+// disclaimerTenant returns the caller's tenant; disclaimers are set per
+// tenant, so callers without one are refused
+func disclaimerTenant(c *gin.Context) (Tenant, bool) {
+	tenant, _ := callerTenant(c)
+	if tenant.ID == "" {
+		abortWithError(c, ErrCodeForbidden, "Disclaimers are set per tenant; send X-Tenant-ID")
+		return Tenant{}, false
+	}
+	return tenant, true
+}
+
+func getDisclaimerHandler(store *DisclaimerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := disclaimerTenant(c)
+		if !ok {
+			return
+		}
+		d := store.Get(tenant.ID)
+		c.JSON(http.StatusOK, gin.H{
+			"current":  store.Stamp(tenant.ID),
+			"active":   d.Active,
+			"versions": d.Versions,
+		})
+	}
+}
+
+func proposeDisclaimerHandler(store *DisclaimerStore, defaultReviewers []string, notifications *NotificationCenter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DisclaimerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		text := strings.TrimSpace(req.Text)
+		if text == "" || len(text) > maxDisclaimerLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("text must be 1 to %d bytes", maxDisclaimerLen))
+			return
+		}
+		tenant, ok := disclaimerTenant(c)
+		if !ok {
+			return
+		}
+
+		user, ok := requireAuthenticatedUser(c, "Proposing a disclaimer")
+		if !ok {
+			return
+		}
+		version, err := store.Propose(tenant.ID, text, user)
+		if err != nil {
+			abortWithDisclaimerError(c, err)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Disclaimer proposed", "version", version.Version, "tenant", tenant.ID, "user", user)
+		for _, reviewer := range complianceReviewers(tenant, defaultReviewers) {
+			if reviewer == user {
+				continue
+			}
+			notifications.Publish(Notification{
+				TenantID: tenant.ID,
+				User:     reviewer,
+				Kind:     NotificationDisclaimerReview,
+				Title:    fmt.Sprintf("%s proposed disclaimer version %d", cmp.Or(user, "Someone"), version.Version),
+				Body:     truncateUTF8(text, 500),
+				Link:     "/api/disclaimer",
+			})
+		}
+		c.JSON(http.StatusCreated, version)
+	}
+}
+
+func reviewDisclaimerHandler(store *DisclaimerStore, defaultReviewers []string, notifications *NotificationCenter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DisclaimerReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if req.State != DisclaimerApproved && req.State != DisclaimerRejected {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("state must be %s or %s", DisclaimerApproved, DisclaimerRejected))
+			return
+		}
+		number, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			abortWithError(c, ErrCodeNotFound, fmt.Sprintf("Disclaimer version %q not found", c.Param("version")))
+			return
+		}
+		tenant, ok := disclaimerTenant(c)
+		if !ok {
+			return
+		}
+
+		user, ok := requireAuthenticatedUser(c, "Reviewing a disclaimer")
+		if !ok {
+			return
+		}
+		reviewer := slices.Contains(complianceReviewers(tenant, defaultReviewers), user)
+		version, err := store.Review(tenant.ID, number, req.State == DisclaimerApproved, user, strings.TrimSpace(req.Note), reviewer)
+		if err != nil {
+			abortWithDisclaimerError(c, err)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Disclaimer reviewed", "version", version.Version, "tenant", tenant.ID, "state", version.State, "user", user)
+		if version.ProposedBy != "" {
+			notifications.Publish(Notification{
+				TenantID: tenant.ID,
+				User:     version.ProposedBy,
+				Kind:     NotificationDisclaimerReview,
+				Title:    fmt.Sprintf("Disclaimer version %d was %s", version.Version, version.State),
+				Body:     version.Note,
+				Link:     "/api/disclaimer",
+			})
+		}
+		c.JSON(http.StatusOK, version)
+	}
+}
+
+// abortWithDisclaimerError maps a DisclaimerStore error onto the error
+// catalog
+func abortWithDisclaimerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errDisclaimerNotFound):
+		abortWithError(c, ErrCodeNotFound, fmt.Sprintf("Disclaimer version %q not found", c.Param("version")))
+	case errors.Is(err, errComplianceReviewersOnly), errors.Is(err, errOwnDisclaimer):
+		abortWithError(c, ErrCodeForbidden, err.Error())
+	case errors.Is(err, errDisclaimerNotPending):
+		abortWithError(c, ErrCodeReviewConflict, err.Error())
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to save disclaimers", "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to save the disclaimer")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestDisclaimerSignOff(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	t.Setenv("POST_PROCESSORS", "disclaimer")
+	t.Setenv("COMPLIANCE_REVIEWERS", "hoa")
+	srv := newTestServer(t, Options{Engine: &stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 180 ngày.", Iterations: 1}}})
+	h := srv.Handler()
+	keys := map[string]string{"lan": userKey(t, h, "lan"), "hoa": userKey(t, h, "hoa")}
+
+	do := func(user, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "admin-token")
+		if !strings.HasPrefix(path, "/admin/") {
+			req.Header.Set("X-Tenant-ID", "acme")
+		}
+		if user != "" {
+			req.Header.Set("X-API-Key", keys[user])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do("", http.MethodPost, "/admin/tenants", map[string]string{"id": "acme", "name": "ACME"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	ask := func() engine.LegalQueryResponse {
+		t.Helper()
+		var resp engine.LegalQueryResponse
+		rec := do("lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày?"})
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Disclaimer == nil {
+			t.Fatalf("query = %d %s, want a stamped answer", rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+
+	if resp := ask(); resp.Disclaimer.Version != 0 || !strings.HasSuffix(resp.Answer, defaultDisclaimer) {
+		t.Errorf("answer before any approval = %q %+v, want the default disclaimer", resp.Answer, resp.Disclaimer)
+	}
+
+	text := "ACME: nội dung trên không phải là ý kiến pháp lý chính thức."
+	var proposed DisclaimerVersion
+	rec := do("lan", http.MethodPost, "/api/disclaimer/versions", DisclaimerRequest{Text: text})
+	if err := json.Unmarshal(rec.Body.Bytes(), &proposed); err != nil || rec.Code != http.StatusCreated || proposed.State != DisclaimerPending {
+		t.Fatalf("propose = %d %s, want a pending version", rec.Code, rec.Body.String())
+	}
+	if resp := ask(); resp.Disclaimer.Version != 0 {
+		t.Errorf("pending disclaimer is live: %+v", resp.Disclaimer)
+	}
+
+	review := "/api/disclaimer/versions/1/review"
+	if code := decodeError(t, do("lan", http.MethodPost, review, DisclaimerReviewRequest{State: DisclaimerApproved})).Code; code != ErrCodeForbidden {
+		t.Errorf("approval by a non-reviewer = %s, want %s", code, ErrCodeForbidden)
+	}
+	// Reviewers are the users of API keys, not whoever X-User-ID names
+	spoofed := httptest.NewRequest(http.MethodPost, review, strings.NewReader(`{"state": "approved"}`))
+	spoofed.Header.Set("Content-Type", "application/json")
+	spoofed.Header.Set("X-Tenant-ID", "acme")
+	spoofed.Header.Set("X-User-ID", "hoa")
+	rec = httptest.NewRecorder()
+	if h.ServeHTTP(rec, spoofed); rec.Code != http.StatusForbidden {
+		t.Errorf("approval claimed for hoa in X-User-ID = %d, want 403", rec.Code)
+	}
+	if rec := do("hoa", http.MethodPost, review, DisclaimerReviewRequest{State: DisclaimerApproved, Note: "OK"}); rec.Code != http.StatusOK {
+		t.Fatalf("approval = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do("hoa", http.MethodPost, review, DisclaimerReviewRequest{State: DisclaimerRejected})).Code; code != ErrCodeReviewConflict {
+		t.Errorf("reviewing an approved version = %s, want %s", code, ErrCodeReviewConflict)
+	}
+
+	resp := ask()
+	if resp.Disclaimer.Version != 1 || resp.Disclaimer.ApprovedBy != "hoa" || !strings.HasSuffix(resp.Answer, text) || strings.Contains(resp.Answer, defaultDisclaimer) {
+		t.Errorf("answer after the approval = %q %+v, want the tenant's disclaimer", resp.Answer, resp.Disclaimer)
+	}
+
+	// Reviewers cannot sign off their own text, and a newer proposal
+	// withdraws the pending one
+	do("hoa", http.MethodPost, "/api/disclaimer/versions", DisclaimerRequest{Text: "Bản nháp"})
+	if code := decodeError(t, do("hoa", http.MethodPost, "/api/disclaimer/versions/2/review", DisclaimerReviewRequest{State: DisclaimerApproved})).Code; code != ErrCodeForbidden {
+		t.Errorf("approval of one's own disclaimer = %s, want %s", code, ErrCodeForbidden)
+	}
+	do("lan", http.MethodPost, "/api/disclaimer/versions", DisclaimerRequest{Text: "Bản nháp 2"})
+	var history struct {
+		Current  engine.DisclaimerStamp `json:"current"`
+		Active   int                    `json:"active"`
+		Versions []DisclaimerVersion    `json:"versions"`
+	}
+	json.Unmarshal(do("lan", http.MethodGet, "/api/disclaimer", nil).Body.Bytes(), &history)
+	if history.Active != 1 || history.Current.Text != text || len(history.Versions) != 3 || history.Versions[1].State != DisclaimerWithdrawn {
+		t.Errorf("disclaimer = %+v, want version 1 active and version 2 withdrawn", history)
+	}
+}
@@ -0,0 +1,536 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres"
+)
+
+// Statuses of a doctor check
+const (
+	DoctorOK       = "ok"
+	DoctorProblems = "problems"
+	DoctorRepaired = "repaired"
+	DoctorSkipped  = "skipped"
+)
+
+// DoctorCheck is the outcome of one check of legal-rag doctor
+type DoctorCheck struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Detail   string          `json:"detail,omitempty"`
+	Findings []DoctorFinding `json:"findings,omitempty"`
+}
+
+// DoctorFinding is a problem found by a check. Repair says what -repair
+// does about it, and is empty when it needs a person.
+type DoctorFinding struct {
+	Problem     string `json:"problem"`
+	Repair      string `json:"repair,omitempty"`
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// doctorTempFile matches the temporary files of writeFileAtomic, left
+// behind when the server died while writing
+var doctorTempFile = regexp.MustCompile(`^\..+\.(json|jsonl|pem)-\d+$`)
+
+// doctorIndex matches the indexes created by migrations
+var doctorIndex = regexp.MustCompile(`(?m)^CREATE (?:UNIQUE )?INDEX (\w+) ON (\w+) .*$`)
+
+// doctor checks the data of a server that is not running, repairing what
+// it can when repair is set
+type doctor struct {
+	config *Config
+	repair bool
+	db     *pgxpool.Pool
+	now    time.Time
+}
+
+// RunDoctor checks the schema of the database, the files of DATA_DIR and
+// the records that refer to answers or tenants that no longer exist. With
+// repair set, the problems that have a safe repair are repaired; files are
+// rewritten, so the server must not be running.
+func RunDoctor(config *Config, repair bool) []DoctorCheck {
+	d := &doctor{config: config, repair: repair, now: time.Now().UTC()}
+	checks := []DoctorCheck{d.checkDataFiles()}
+	if config.DatabaseURL == "" {
+		skipped := "DATABASE_URL is not set"
+		checks = append(checks,
+			DoctorCheck{Name: "schema", Status: DoctorSkipped, Detail: skipped},
+			DoctorCheck{Name: "indexes", Status: DoctorSkipped, Detail: skipped})
+	} else if db, err := postgres.Open(config.DatabaseURL); err != nil {
+		checks = append(checks, DoctorCheck{Name: "schema", Status: DoctorProblems, Findings: []DoctorFinding{{Problem: "DATABASE_URL " + err.Error()}}})
+	} else {
+		d.db = db
+		defer d.db.Close()
+		schema := d.checkSchema()
+		checks = append(checks, schema)
+		if schema.Status == DoctorProblems {
+			checks = append(checks, DoctorCheck{Name: "indexes", Status: DoctorSkipped, Detail: "the schema has problems"})
+		} else {
+			checks = append(checks, d.checkIndexes())
+		}
+	}
+	return append(checks, d.checkOrphans(), d.checkSignatures())
+}
+
+// finding records a problem in a check. fix repairs it when repairing;
+// a nil fix means the problem needs a person.
+func (d *doctor) finding(check *DoctorCheck, problem, repair string, fix func() error) {
+	f := DoctorFinding{Problem: problem}
+	if fix != nil {
+		f.Repair = repair
+		if d.repair {
+			if err := fix(); err != nil {
+				f.RepairError = err.Error()
+			} else {
+				f.Repaired = true
+			}
+		}
+	}
+	check.Findings = append(check.Findings, f)
+}
+
+// done sets the status of a check from its findings
+func (d *doctor) done(check DoctorCheck) DoctorCheck {
+	if check.Status != "" {
+		return check
+	}
+	check.Status = DoctorOK
+	for _, f := range check.Findings {
+		if !f.Repaired {
+			check.Status = DoctorProblems
+			return check
+		}
+		check.Status = DoctorRepaired
+	}
+	return check
+}
+
+// moveAside renames a damaged file so that it is kept for inspection
+func (d *doctor) moveAside(path string) (string, error) {
+	aside := path + ".corrupt-" + d.now.Format("20060102150405")
+	return aside, os.Rename(path, aside)
+}
+
+// checkDataFiles checks that the JSON files of DATA_DIR can be read, and
+// that no write was left unfinished
+func (d *doctor) checkDataFiles() DoctorCheck {
+	check := DoctorCheck{Name: "data_files"}
+	entries, err := os.ReadDir(d.config.DataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return DoctorCheck{Name: check.Name, Status: DoctorSkipped, Detail: "DATA_DIR does not exist yet"}
+	}
+	if err != nil {
+		d.finding(&check, err.Error(), "", nil)
+		return d.done(check)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(d.config.DataDir, name)
+		if entry.IsDir() {
+			continue
+		}
+		switch {
+		case doctorTempFile.MatchString(name):
+			d.finding(&check, name+" is a write the server did not finish", "delete it", func() error {
+				return os.Remove(path)
+			})
+		case strings.HasSuffix(name, ".json"):
+			data, err := os.ReadFile(path)
+			if err != nil {
+				d.finding(&check, err.Error(), "", nil)
+			} else if !json.Valid(data) {
+				d.finding(&check, name+" is not valid JSON, so the server cannot start",
+					"move it aside; the server starts without its records", func() error {
+						_, err := d.moveAside(path)
+						return err
+					})
+			}
+		case strings.HasSuffix(name, ".jsonl"):
+			d.checkJSONLines(&check, path)
+		}
+	}
+	return d.done(check)
+}
+
+// checkJSONLines finds the lines of a JSON lines file that cannot be read
+func (d *doctor) checkJSONLines(check *DoctorCheck, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		d.finding(check, err.Error(), "", nil)
+		return
+	}
+	var kept bytes.Buffer
+	var bad []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if !json.Valid(scanner.Bytes()) {
+			bad = append(bad, line)
+			continue
+		}
+		kept.Write(scanner.Bytes())
+		kept.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		d.finding(check, fmt.Sprintf("%s cannot be read: %v", filepath.Base(path), err), "", nil)
+		return
+	}
+	if len(bad) == 0 {
+		return
+	}
+	d.finding(check, fmt.Sprintf("%s has %d unreadable lines (first: %d), so the server cannot start", filepath.Base(path), len(bad), bad[0]),
+		"drop those lines, keeping the original file aside", func() error {
+			aside, err := d.moveAside(path)
+			if err != nil {
+				return err
+			}
+			if err := writeFileAtomic(path, kept.Bytes()); err != nil {
+				return fmt.Errorf("failed to rewrite the file, the original is %s: %w", aside, err)
+			}
+			return nil
+		})
+}
+
+// checkSchema compares the migrations applied to the database with those
+// of this release
+func (d *doctor) checkSchema() DoctorCheck {
+	check := DoctorCheck{Name: "schema"}
+	migrate := func() error {
+		_, err := postgres.Migrate(d.db, historyMigrations)
+		return err
+	}
+	ctx, cancel := postgres.Context()
+	defer cancel()
+	rows, _ := d.db.Query(ctx, "SELECT version FROM schema_migrations")
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+		d.finding(&check, "the database has no schema_migrations table; the schema was never created", "apply every migration", migrate)
+		return d.done(check)
+	}
+	if err != nil {
+		return DoctorCheck{Name: check.Name, Status: DoctorProblems, Findings: []DoctorFinding{{Problem: fmt.Sprintf("Postgres at %s cannot be queried: %v", postgres.Addr(d.db), err)}}}
+	}
+
+	applied := map[string]bool{}
+	for _, version := range versions {
+		applied[fmt.Sprint(version)] = true
+	}
+	var pending []string
+	for _, m := range historyMigrations {
+		version := fmt.Sprint(m.Version)
+		if !applied[version] {
+			pending = append(pending, fmt.Sprintf("%d (%s)", m.Version, m.Name))
+		}
+		delete(applied, version)
+	}
+	if len(pending) > 0 {
+		d.finding(&check, "migrations not applied: "+strings.Join(pending, ", "), "apply them", migrate)
+	}
+	if len(applied) > 0 {
+		unknown := slices.Sorted(maps.Keys(applied))
+		d.finding(&check, fmt.Sprintf("migrations %s were applied by a newer release; upgrade the server", strings.Join(unknown, ", ")), "", nil)
+	}
+	check.Detail = fmt.Sprintf("%d migrations in this release", len(historyMigrations))
+	return d.done(check)
+}
+
+// checkIndexes checks that the indexes created by migrations exist and are
+// valid; an index build that failed leaves an invalid index behind
+func (d *doctor) checkIndexes() DoctorCheck {
+	check := DoctorCheck{Name: "indexes"}
+	ctx, cancel := postgres.Context()
+	defer cancel()
+	rows, _ := d.db.Query(ctx, `SELECT c.relname, i.indisvalid FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid JOIN pg_class t ON t.oid = i.indrelid WHERE t.relname = 'history'`)
+	valid := map[string]bool{}
+	var index string
+	var indexValid bool
+	if _, err := pgx.ForEachRow(rows, []any{&index, &indexValid}, func() error {
+		valid[index] = indexValid
+		return nil
+	}); err != nil {
+		d.finding(&check, fmt.Sprintf("the indexes of the history table cannot be listed: %v", err), "", nil)
+		return d.done(check)
+	}
+	if len(valid) == 0 {
+		d.finding(&check, "the history table has no index; it may have been dropped", "", nil)
+		return d.done(check)
+	}
+
+	for name, ok := range valid {
+		if !ok {
+			d.finding(&check, fmt.Sprintf("index %s is invalid", name), "rebuild it", func() error {
+				// Rebuilding an index takes as long as the table needs
+				_, err := d.db.Exec(context.Background(), "REINDEX INDEX "+name)
+				return err
+			})
+		}
+	}
+	for _, m := range historyMigrations {
+		for _, match := range doctorIndex.FindAllStringSubmatch(m.SQL, -1) {
+			statement, name := strings.TrimSuffix(match[0], ";"), match[1]
+			if _, ok := valid[name]; !ok {
+				d.finding(&check, fmt.Sprintf("index %s is missing, so history queries scan the table", name), "create it", func() error {
+					_, err := d.db.Exec(context.Background(), statement)
+					return err
+				})
+			}
+		}
+	}
+	check.Detail = fmt.Sprintf("%d indexes", len(valid))
+	return d.done(check)
+}
+
+// historyIDs returns the IDs of the answers in the history
+func (d *doctor) historyIDs() (map[string]bool, error) {
+	ids := map[string]bool{}
+	if d.config.DatabaseURL != "" && d.db == nil {
+		return nil, errors.New("DATABASE_URL is invalid")
+	}
+	if d.db != nil {
+		ctx, cancel := postgres.Context()
+		defer cancel()
+		rows, _ := d.db.Query(ctx, "SELECT id FROM history")
+		var id string
+		_, err := pgx.ForEachRow(rows, []any{&id}, func() error {
+			ids[id] = true
+			return nil
+		})
+		return ids, err
+	}
+	history, err := NewHistoryStore(filepath.Join(d.config.DataDir, "history.jsonl"), d.config.HistoryMax)
+	if err != nil {
+		return nil, err
+	}
+	for id := range history.byID {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// checkOrphans finds the records about answers that left the history, and
+// the API keys and private collections of deleted tenants
+func (d *doctor) checkOrphans() DoctorCheck {
+	check := DoctorCheck{Name: "orphans"}
+	path := func(name string) string { return filepath.Join(d.config.DataDir, name) }
+	history, err := d.historyIDs()
+	if err != nil {
+		return DoctorCheck{Name: check.Name, Status: DoctorSkipped, Detail: "the history cannot be read: " + err.Error()}
+	}
+	tenants, err := NewTenantStore(path("tenants.json"))
+	if err != nil {
+		return DoctorCheck{Name: check.Name, Status: DoctorSkipped, Detail: err.Error()}
+	}
+	loadErrors := []string{}
+
+	if reviews, err := NewReviewStore(path("reviews.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for id := range reviews.reviews {
+			if !history[id] {
+				orphans = append(orphans, id)
+			}
+		}
+		d.orphanFinding(&check, orphans, "reviews of answers no longer in the history", func() error {
+			for _, id := range orphans {
+				delete(reviews.reviews, id)
+			}
+			return reviews.saveLocked()
+		})
+	}
+
+	if edits, err := NewEditStore(path("edits.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for id := range edits.edits {
+			if !history[id] {
+				orphans = append(orphans, id)
+			}
+		}
+		d.orphanFinding(&check, orphans, "edits of answers no longer in the history", func() error {
+			for _, id := range orphans {
+				delete(edits.edits, id)
+			}
+			return edits.saveLocked()
+		})
+	}
+
+	if pages, err := NewPublicPageStore(path("public_pages.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for key, p := range pages.pages {
+			if !history[p.HistoryID] {
+				orphans = append(orphans, key)
+			}
+		}
+		d.orphanFinding(&check, orphans, "public pages of answers no longer in the history", func() error {
+			for _, key := range orphans {
+				delete(pages.pages, key)
+			}
+			return pages.saveLocked()
+		})
+	}
+
+	if shares, err := NewShareStore(path("shares.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for id, share := range shares.shares {
+			if slices.ContainsFunc(share.HistoryIDs, func(h string) bool { return !history[h] }) {
+				orphans = append(orphans, id)
+			}
+		}
+		d.orphanFinding(&check, orphans, "share links including answers no longer in the history", func() error {
+			for _, id := range orphans {
+				share := shares.shares[id]
+				share.HistoryIDs = slices.DeleteFunc(share.HistoryIDs, func(h string) bool { return !history[h] })
+				if len(share.HistoryIDs) == 0 {
+					delete(shares.shares, id)
+				}
+			}
+			return shares.saveLocked()
+		})
+	}
+
+	if keys, err := NewAPIKeyStore(path("api_keys.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for id, key := range keys.keys {
+			if _, ok := tenants.Get(key.TenantID); key.TenantID != "" && !ok {
+				orphans = append(orphans, id)
+			}
+		}
+		d.orphanFinding(&check, orphans, "API keys of deleted tenants", func() error {
+			for _, id := range orphans {
+				delete(keys.byHash, keys.keys[id].Hash)
+				delete(keys.keys, id)
+			}
+			return keys.saveLocked()
+		})
+	}
+
+	if collections, err := NewPrivateCollectionStore(path("private_collections.json")); err != nil {
+		loadErrors = append(loadErrors, err.Error())
+	} else {
+		var orphans []string
+		for key, collection := range collections.collections {
+			if _, ok := tenants.Get(collection.TenantID); !ok {
+				orphans = append(orphans, key)
+			}
+		}
+		if len(orphans) > 0 {
+			sort.Strings(orphans)
+			d.finding(&check, fmt.Sprintf("%d private collections of deleted tenants; their documents are still indexed by the engine and must be deleted there: %s",
+				len(orphans), strings.Join(orphans, ", ")), "", nil)
+		}
+	}
+
+	for _, err := range loadErrors {
+		d.finding(&check, err, "", nil)
+	}
+	return d.done(check)
+}
+
+// orphanFinding records the orphaned records of one store, if any
+func (d *doctor) orphanFinding(check *DoctorCheck, orphans []string, what string, fix func() error) {
+	if len(orphans) == 0 {
+		return
+	}
+	sort.Strings(orphans)
+	listed := orphans
+	if len(listed) > 10 {
+		listed = append(slices.Clone(listed[:10]), "...")
+	}
+	d.finding(check, fmt.Sprintf("%d %s: %s", len(orphans), what, strings.Join(listed, ", ")), "delete them", fix)
+}
+
+// checkSignatures checks that the signatures of approved answers were made
+// with the current signing key, which a lost or replaced key file breaks
+func (d *doctor) checkSignatures() DoctorCheck {
+	check := DoctorCheck{Name: "signatures"}
+	reviews, err := NewReviewStore(filepath.Join(d.config.DataDir, "reviews.json"))
+	if err != nil {
+		return DoctorCheck{Name: check.Name, Status: DoctorSkipped, Detail: err.Error()}
+	}
+	byKey := map[string]int{}
+	for _, r := range reviews.reviews {
+		if r.Signature != nil {
+			byKey[r.Signature.KeyID]++
+		}
+	}
+	if len(byKey) == 0 {
+		return DoctorCheck{Name: check.Name, Status: DoctorOK, Detail: "no signed answers"}
+	}
+
+	keyFile := d.config.SigningKeyFile
+	if keyFile == "" {
+		keyFile = filepath.Join(d.config.DataDir, "signing_key.pem")
+	}
+	key, err := loadSigningKey(keyFile, false)
+	if err != nil {
+		d.finding(&check, fmt.Sprintf("the signing key %s cannot be loaded (%v); signatures of approved answers will not verify", keyFile, err), "", nil)
+		return d.done(check)
+	}
+	signer, err := NewAnswerSigner(key)
+	if err != nil {
+		d.finding(&check, err.Error(), "", nil)
+		return d.done(check)
+	}
+	for keyID, count := range byKey {
+		if keyID != signer.keyID {
+			d.finding(&check, fmt.Sprintf("%d approved answers are signed with key %s, not the current key %s; approve them again to sign them anew", count, keyID, signer.keyID), "", nil)
+		}
+	}
+	return d.done(check)
+}
+
+// PrintDoctorReport prints the checks of RunDoctor for a person
+func PrintDoctorReport(checks []DoctorCheck) {
+	for _, check := range checks {
+		line := fmt.Sprintf("%-12s %s", check.Name, check.Status)
+		if check.Detail != "" {
+			line += " (" + check.Detail + ")"
+		}
+		fmt.Println(line)
+		for _, f := range check.Findings {
+			switch {
+			case f.Repaired:
+				fmt.Printf("  - %s: repaired, %s\n", f.Problem, f.Repair)
+			case f.RepairError != "":
+				fmt.Printf("  - %s: repair failed: %s\n", f.Problem, f.RepairError)
+			case f.Repair != "":
+				fmt.Printf("  - %s (-repair will %s)\n", f.Problem, f.Repair)
+			default:
+				fmt.Printf("  - %s\n", f.Problem)
+			}
+		}
+	}
+}
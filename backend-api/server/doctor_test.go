@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres/pgtest"
+)
+
+func doctorChecks(checks []DoctorCheck) map[string]DoctorCheck {
+	byName := map[string]DoctorCheck{}
+	for _, check := range checks {
+		byName[check.Name] = check
+	}
+	return byName
+}
+
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, _ := json.Marshal(HistoryEntry{ID: "q_kept", Question: "Thời gian thử việc tối đa là bao lâu?", CreatedAt: time.Now().UTC()})
+	write("history.jsonl", string(entry)+"\n{\"id\": \"q_cut\n")
+	write("tenants.json", `[{"id": "acme", "name": "ACME", "plan": "standard"}]`)
+	write("reviews.json", `[{"history_id": "q_kept", "state": "reviewed"}, {"history_id": "q_gone", "state": "approved"}]`)
+	write("api_keys.json", `[{"id": "key_1", "name": "portal", "tenant_id": "acme", "hash": "h1"}, {"id": "key_2", "name": "old portal", "tenant_id": "globex", "hash": "h2"}]`)
+	write("binders.json", `[{"id": "b_1", `)
+	write(".shares.json-2840193", `[`)
+
+	config := &Config{DataDir: dir, HistoryMax: 100}
+	checks := doctorChecks(RunDoctor(config, false))
+	if files := checks["data_files"]; files.Status != DoctorProblems || len(files.Findings) != 3 {
+		t.Fatalf("data_files = %+v, want the cut history line, the broken binders and the temporary file", files)
+	}
+	if orphans := checks["orphans"]; orphans.Status != DoctorSkipped {
+		t.Errorf("orphans = %+v, want skipped while the history cannot be read", orphans)
+	}
+	if schema := checks["schema"]; schema.Status != DoctorSkipped {
+		t.Errorf("schema = %+v, want skipped without DATABASE_URL", schema)
+	}
+
+	checks = doctorChecks(RunDoctor(config, true))
+	if files := checks["data_files"]; files.Status != DoctorRepaired {
+		t.Errorf("data_files = %+v, want repaired", files)
+	}
+	orphans := checks["orphans"]
+	if orphans.Status != DoctorRepaired || len(orphans.Findings) != 2 ||
+		!strings.Contains(orphans.Findings[0].Problem, "q_gone") || !strings.Contains(orphans.Findings[1].Problem, "key_2") {
+		t.Errorf("orphans = %+v, want the review of q_gone and the key of globex deleted", orphans)
+	}
+	if aside, _ := filepath.Glob(filepath.Join(dir, "history.jsonl.corrupt-*")); len(aside) != 1 {
+		t.Errorf("history moved aside to %v, want one copy", aside)
+	}
+
+	// Repaired data loads, and a second run finds nothing
+	if _, err := NewHistoryStore(filepath.Join(dir, "history.jsonl"), 100); err != nil {
+		t.Errorf("history after repair: %v", err)
+	}
+	reviews, _ := NewReviewStore(filepath.Join(dir, "reviews.json"))
+	if len(reviews.reviews) != 1 || reviews.reviews["q_kept"] == nil {
+		t.Errorf("reviews after repair = %v, want only q_kept", reviews.reviews)
+	}
+	for _, check := range RunDoctor(config, false) {
+		if check.Status == DoctorProblems || check.Status == DoctorRepaired {
+			t.Errorf("%s after repair = %+v, want no problems", check.Name, check)
+		}
+	}
+}
+
+func TestDoctorDatabase(t *testing.T) {
+	var mu sync.Mutex
+	var executed []string
+	str := func(s string) *string { return &s }
+	pg, err := pgtest.NewServer(func(query string, args []*string) (pgtest.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case query == "SELECT version FROM schema_migrations":
+			return pgtest.Result{Columns: []string{"version"}, Types: []string{"int4"}, Rows: [][]*string{{str("1")}, {str("2")}}}, nil
+		case strings.Contains(query, "FROM pg_index"):
+			return pgtest.Result{Columns: []string{"relname", "indisvalid"}, Types: []string{"name", "bool"}, Rows: [][]*string{
+				{str("history_pkey"), str("t")},
+				{str("history_tenant_created"), str("f")},
+			}}, nil
+		case query == "SELECT id FROM history":
+			return pgtest.Result{Columns: []string{"id"}}, nil
+		}
+		executed = append(executed, query)
+		return pgtest.Result{Tag: "CREATE INDEX"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+
+	config := &Config{DataDir: t.TempDir(), DatabaseURL: pg.URL()}
+	checks := doctorChecks(RunDoctor(config, true))
+	schema := checks["schema"]
+	if schema.Status != DoctorProblems || len(schema.Findings) != 1 || schema.Findings[0].Repair != "" || !strings.Contains(schema.Findings[0].Problem, "2") {
+		t.Errorf("schema = %+v, want migration 2 reported as applied by a newer release", schema)
+	}
+	if indexes := checks["indexes"]; indexes.Status != DoctorSkipped {
+		t.Errorf("indexes = %+v, want skipped while the schema has problems", indexes)
+	}
+
+	db, err := postgres.Open(pg.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := &doctor{config: config, repair: true, db: db}
+	indexes := d.checkIndexes()
+	if indexes.Status != DoctorRepaired || len(indexes.Findings) != 2 {
+		t.Fatalf("indexes = %+v, want the invalid index rebuilt and the missing one created", indexes)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(executed) != 2 || executed[0] != "REINDEX INDEX history_tenant_created" ||
+		!strings.HasPrefix(executed[1], "CREATE INDEX history_tenant_user_created ON history") {
+		t.Errorf("executed = %q, want a REINDEX and the CREATE INDEX of the migration", executed)
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// DowngradeConfig sets the deadlines past which the engine downgrades a
+// slow query: it stops iterating at SoftDeadline and answers with FastModel,
+// finishing within HardDeadline instead of failing at REQUEST_TIMEOUT
+type DowngradeConfig struct {
+	// SoftDeadline of zero disables downgrades
+	SoftDeadline time.Duration
+	HardDeadline time.Duration
+	FastModel    string
+}
+
+// deadlines returns the deadlines sent with every engine query, or nil when
+// downgrades are disabled
+func (c DowngradeConfig) deadlines() *engine.Deadlines {
+	if c.SoftDeadline <= 0 {
+		return nil
+	}
+	return &engine.Deadlines{
+		SoftMs:    int(c.SoftDeadline.Milliseconds()),
+		HardMs:    int(c.HardDeadline.Milliseconds()),
+		FastModel: c.FastModel,
+	}
+}
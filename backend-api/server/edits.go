@@ -0,0 +1,481 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxEditAnswerLen = 20000
+	maxEditNoteLen   = 1000
+
+	// maxAnswerVersions bounds the edited versions kept per answer; the
+	// oldest are dropped first
+	maxAnswerVersions = 50
+
+	// maxDiffCells bounds the table of the word diff. Larger changes are
+	// reported as the whole changed span deleted and inserted.
+	maxDiffCells = 1 << 20
+)
+
+// Edit patterns, the kinds of change an edit made to the answer
+const (
+	EditCitationsAdded   = "citations_added"
+	EditCitationsRemoved = "citations_removed"
+	EditShortened        = "shortened"
+	EditExpanded         = "expanded"
+	EditReworded         = "reworded"
+)
+
+// diffTokenPattern splits text into words with the whitespace after them
+var diffTokenPattern = regexp.MustCompile(`^\s+|\S+\s*`)
+
+// AnswerEdits is the original text of an answer in the history with the
+// versions lawyers edited from it. It keeps the question and sources, so it
+// outlives the history entry in the evaluation dataset.
+type AnswerEdits struct {
+	HistoryID string       `json:"history_id"`
+	TenantID  string       `json:"tenant_id,omitempty"`
+	Question  string       `json:"question"`
+	Original  string       `json:"original"`
+	Sources   []string     `json:"sources,omitempty"`
+	Versions  []AnswerEdit `json:"versions"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// AnswerEdit is an edited version of an answer, compared with the original
+type AnswerEdit struct {
+	Version   int         `json:"version"`
+	Answer    string      `json:"answer"`
+	Note      string      `json:"note,omitempty"`
+	Editor    string      `json:"editor,omitempty"`
+	Diff      []DiffOp    `json:"diff"`
+	Summary   EditSummary `json:"summary"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// DiffOp is a run of text the edit kept (equal), added (insert) or removed
+// (delete)
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// EditSummary counts what an edit changed; Patterns names the kinds of
+// change, for the evaluation dataset
+type EditSummary struct {
+	WordsAdded       int      `json:"words_added"`
+	WordsRemoved     int      `json:"words_removed"`
+	CitationsAdded   []string `json:"citations_added,omitempty"`
+	CitationsRemoved []string `json:"citations_removed,omitempty"`
+	Patterns         []string `json:"patterns"`
+}
+
+// latest is the current version of the answer, if it was edited
+func (e *AnswerEdits) latest() (AnswerEdit, bool) {
+	if e == nil || len(e.Versions) == 0 {
+		return AnswerEdit{}, false
+	}
+	return e.Versions[len(e.Versions)-1], true
+}
+
+var errAnswerUnchanged = errors.New("answer is unchanged")
+
+// EditStore keeps the edited versions of answers, persisted to a JSON file
+// when a path is configured
+type EditStore struct {
+	mu    sync.RWMutex
+	path  string
+	edits map[string]*AnswerEdits
+}
+
+func NewEditStore(path string) (*EditStore, error) {
+	store := &EditStore{
+		path:  path,
+		edits: make(map[string]*AnswerEdits),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var edits []*AnswerEdits
+	if _, err := readJSONFile(path, &edits); err != nil {
+		return nil, fmt.Errorf("failed to load answer edits: %w", err)
+	}
+	for _, e := range edits {
+		store.edits[e.HistoryID] = e
+	}
+	return store, nil
+}
+
+// Get returns the edits of an answer owned by tenantID; an answer that was
+// never edited has no versions
+func (s *EditStore) Get(entry HistoryEntry) AnswerEdits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.edits[entry.ID]; ok && e.TenantID == entry.TenantID {
+		return *e
+	}
+	return AnswerEdits{
+		HistoryID: entry.ID,
+		TenantID:  entry.TenantID,
+		Question:  entry.Question,
+		Original:  entry.Response.Answer,
+		Versions:  []AnswerEdit{},
+	}
+}
+
+// Current returns the entry with its answer replaced by the latest edited
+// version. Shares and signatures show the answer as the lawyers left it.
+func (s *EditStore) Current(entry HistoryEntry) HistoryEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.edits[entry.ID]; ok && e.TenantID == entry.TenantID {
+		if latest, ok := e.latest(); ok {
+			entry.Response.Answer = latest.Answer
+		}
+	}
+	return entry
+}
+
+// Add stores an edited version of an answer, compared with the original
+func (s *EditStore) Add(entry HistoryEntry, resolver *LinkResolver, edit AnswerEdit) (AnswerEdit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.edits[entry.ID]
+	if !ok || e.TenantID != entry.TenantID {
+		e = &AnswerEdits{
+			HistoryID: entry.ID,
+			TenantID:  entry.TenantID,
+			Question:  entry.Question,
+			Original:  entry.Response.Answer,
+		}
+		for _, r := range entry.Response.SearchResults {
+			e.Sources = append(e.Sources, sourceFromResult(r).Title)
+		}
+	}
+	current := e.Original
+	if latest, ok := e.latest(); ok {
+		current = latest.Answer
+		edit.Version = latest.Version + 1
+	} else {
+		edit.Version = 1
+	}
+	if edit.Answer == current {
+		return AnswerEdit{}, errAnswerUnchanged
+	}
+
+	edit.Diff = wordDiff(e.Original, edit.Answer)
+	edit.Summary = summarizeEdit(resolver, e.Original, edit.Answer, edit.Diff)
+	edit.CreatedAt = time.Now().UTC()
+	e.Versions = append(e.Versions, edit)
+	if len(e.Versions) > maxAnswerVersions {
+		e.Versions = e.Versions[len(e.Versions)-maxAnswerVersions:]
+	}
+	e.UpdatedAt = edit.CreatedAt
+	s.edits[entry.ID] = e
+	return edit, s.saveLocked()
+}
+
+// Edited returns the answers with at least one edited version, most
+// recently edited first
+func (s *EditStore) Edited(since time.Time) []AnswerEdits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	edited := []AnswerEdits{}
+	for _, e := range s.edits {
+		if len(e.Versions) > 0 && !e.UpdatedAt.Before(since) {
+			edited = append(edited, *e)
+		}
+	}
+	sort.Slice(edited, func(i, j int) bool { return edited[i].UpdatedAt.After(edited[j].UpdatedAt) })
+	return edited
+}
+
+func (s *EditStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	edits := make([]*AnswerEdits, 0, len(s.edits))
+	for _, e := range s.edits {
+		edits = append(edits, e)
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].HistoryID < edits[j].HistoryID })
+	return writeJSONFile(s.path, edits)
+}
+
+// wordDiff compares two texts word by word. The text shared at both ends is
+// skipped before the longest common subsequence of the words in between is
+// found, so a local edit of a long answer stays cheap.
+func wordDiff(from, to string) []DiffOp {
+	a := diffTokenPattern.FindAllString(from, -1)
+	b := diffTokenPattern.FindAllString(to, -1)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []DiffOp
+	emit := func(op string, tokens ...string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Op == op {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, DiffOp{Op: op, Text: text})
+	}
+	emit("equal", a[:prefix]...)
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(am)+1)*(len(bm)+1) > maxDiffCells {
+		emit("delete", am...)
+		emit("insert", bm...)
+	} else {
+		// lcs[i][j] is the length of the common subsequence of am[i:] and bm[j:]
+		width := len(bm) + 1
+		lcs := make([]int32, (len(am)+1)*width)
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+				} else {
+					lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(am) || j < len(bm) {
+			switch {
+			case i < len(am) && j < len(bm) && am[i] == bm[j]:
+				emit("equal", am[i])
+				i, j = i+1, j+1
+			case i < len(am) && (j == len(bm) || lcs[(i+1)*width+j] >= lcs[i*width+j+1]):
+				emit("delete", am[i])
+				i++
+			default:
+				emit("insert", bm[j])
+				j++
+			}
+		}
+	}
+	emit("equal", a[len(a)-suffix:]...)
+	if ops == nil {
+		ops = []DiffOp{}
+	}
+	return ops
+}
+
+// summarizeEdit counts the words and citations an edit added and removed,
+// and names its patterns
+func summarizeEdit(resolver *LinkResolver, original, edited string, diff []DiffOp) EditSummary {
+	summary := EditSummary{Patterns: []string{}}
+	for _, op := range diff {
+		switch op.Op {
+		case "insert":
+			summary.WordsAdded += len(strings.Fields(op.Text))
+		case "delete":
+			summary.WordsRemoved += len(strings.Fields(op.Text))
+		}
+	}
+
+	labels := func(text string) map[string]string {
+		cited := make(map[string]string)
+		for _, m := range resolver.mentions(text) {
+			cited[m.key()] = m.label()
+		}
+		return cited
+	}
+	before, after := labels(original), labels(edited)
+	for key, label := range after {
+		if _, ok := before[key]; !ok {
+			summary.CitationsAdded = append(summary.CitationsAdded, label)
+		}
+	}
+	for key, label := range before {
+		if _, ok := after[key]; !ok {
+			summary.CitationsRemoved = append(summary.CitationsRemoved, label)
+		}
+	}
+	slices.Sort(summary.CitationsAdded)
+	slices.Sort(summary.CitationsRemoved)
+
+	if len(summary.CitationsAdded) > 0 {
+		summary.Patterns = append(summary.Patterns, EditCitationsAdded)
+	}
+	if len(summary.CitationsRemoved) > 0 {
+		summary.Patterns = append(summary.Patterns, EditCitationsRemoved)
+	}
+	words, editedWords := len(strings.Fields(original)), len(strings.Fields(edited))
+	switch {
+	case editedWords*5 < words*4:
+		summary.Patterns = append(summary.Patterns, EditShortened)
+	case editedWords*5 > words*6:
+		summary.Patterns = append(summary.Patterns, EditExpanded)
+	case summary.WordsAdded+summary.WordsRemoved > 0:
+		summary.Patterns = append(summary.Patterns, EditReworded)
+	}
+	return summary
+}
+
+// unsupportedCitations returns the citations of an edited answer that
+// neither the original answer nor its search results cite. An edit may drop
+// or move citations, but not cite provisions nobody checked the answer
+// against; a document cited as a whole may be cited again, an article only
+// if it was cited or retrieved.
+func unsupportedCitations(resolver *LinkResolver, entry HistoryEntry, edited string) []string {
+	articles := make(map[string]bool)
+	documents := make(map[string]bool)
+	allow := func(m citationMention) {
+		articles[m.key()] = true
+		documents[normalizeDocumentNumber(m.number)] = true
+	}
+	for _, m := range resolver.mentions(entry.Response.Answer) {
+		allow(m)
+	}
+	for _, r := range entry.Response.SearchResults {
+		if m, ok := resolver.resultMention(r); ok {
+			allow(m)
+		}
+	}
+
+	var unsupported []string
+	for _, m := range resolver.mentions(edited) {
+		if articles[m.key()] || (m.article == "" && documents[normalizeDocumentNumber(m.number)]) {
+			continue
+		}
+		if label := m.label(); !slices.Contains(unsupported, label) {
+			unsupported = append(unsupported, label)
+		}
+	}
+	return unsupported
+}
+
+// Handlers
+
+// EditRequest is the body of POST /api/history/:id/edits
+type EditRequest struct {
+	Answer string `json:"answer" binding:"required"`
+	Note   string `json:"note,omitempty"`
+}
+
+func listEditsHandler(edits *EditStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, edits.Get(entry))
+	}
+}
+
+// editAnswerHandler stores an edited version of an answer. Approved answers
+// cannot be edited: their approval has to be revoked first, so a share or
+// signature never shows text the senior lawyer did not approve.
+func editAnswerHandler(edits *EditStore, reviews *ReviewStore, history *HistoryStore, resolver *LinkResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		answer := strings.TrimSpace(req.Answer)
+		if answer == "" || len(answer) > maxEditAnswerLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("answer must be 1 to %d bytes", maxEditAnswerLen))
+			return
+		}
+		note := strings.TrimSpace(req.Note)
+		if len(note) > maxEditNoteLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("note must be at most %d bytes", maxEditNoteLen))
+			return
+		}
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		if state := reviews.Get(entry.ID, entry.TenantID).State; state == ReviewApproved {
+			abortWithError(c, ErrCodeReviewConflict, fmt.Sprintf("Answer %q is approved; revoke the approval before editing it", entry.ID))
+			return
+		}
+		if unsupported := unsupportedCitations(resolver, entry, answer); len(unsupported) > 0 {
+			abortWithError(c, ErrCodeCitationUnsupported, fmt.Sprintf("The edited answer cites %s, which neither the original answer nor its sources cite", strings.Join(unsupported, "; ")))
+			return
+		}
+
+		edit, err := edits.Add(entry, resolver, AnswerEdit{Answer: answer, Note: note, Editor: callerUser(c)})
+		if errors.Is(err, errAnswerUnchanged) {
+			abortWithError(c, ErrCodeInvalidRequest, "answer is unchanged from the current version")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save answer edits", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save the edited answer")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Answer edited", "history_id", entry.ID, "version", edit.Version, "editor", edit.Editor)
+		c.JSON(http.StatusCreated, edit)
+	}
+}
+
+// EditExample is a line of the evaluation dataset: the engine's answer and
+// the answer as lawyers last edited it, which serves as the reference
+type EditExample struct {
+	HistoryID string      `json:"history_id"`
+	TenantID  string      `json:"tenant_id,omitempty"`
+	Question  string      `json:"question"`
+	Sources   []string    `json:"sources,omitempty"`
+	Original  string      `json:"original"`
+	Reference string      `json:"reference"`
+	Versions  int         `json:"versions"`
+	Summary   EditSummary `json:"summary"`
+	EditedAt  time.Time   `json:"edited_at"`
+}
+
+// exportEditsHandler streams the evaluation dataset of edited answers as
+// JSON lines, most recently edited first
+func exportEditsHandler(edits *EditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		since, err := parseHistoryTime(c.Query("since"), false)
+		if err != nil {
+			abortWithInvalidParam(c, "since", fmt.Sprintf("since %v", err))
+			return
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="answer-edits.jsonl"`)
+		c.Status(http.StatusOK)
+		enc := json.NewEncoder(c.Writer)
+		for _, e := range edits.Edited(since) {
+			latest, _ := e.latest()
+			if err := enc.Encode(EditExample{
+				HistoryID: e.HistoryID,
+				TenantID:  e.TenantID,
+				Question:  e.Question,
+				Sources:   e.Sources,
+				Original:  e.Original,
+				Reference: latest.Answer,
+				Versions:  len(e.Versions),
+				Summary:   latest.Summary,
+				EditedAt:  latest.CreatedAt,
+			}); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write the edit dataset", "error", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestWordDiff(t *testing.T) {
+	from := "Thời gian thử việc không quá 60 ngày."
+	to := "Thời gian thử việc tối đa không quá 60 ngày làm việc."
+	diff := wordDiff(from, to)
+	want := []DiffOp{
+		{Op: "equal", Text: "Thời gian thử việc "},
+		{Op: "insert", Text: "tối đa "},
+		{Op: "equal", Text: "không quá 60 "},
+		{Op: "delete", Text: "ngày."},
+		{Op: "insert", Text: "ngày làm việc."},
+	}
+	if !slices.Equal(diff, want) {
+		t.Errorf("wordDiff = %+v, want %+v", diff, want)
+	}
+	var before, after strings.Builder
+	for _, op := range diff {
+		if op.Op != "insert" {
+			before.WriteString(op.Text)
+		}
+		if op.Op != "delete" {
+			after.WriteString(op.Text)
+		}
+	}
+	if before.String() != from || after.String() != to {
+		t.Errorf("diff rebuilds %q and %q", before.String(), after.String())
+	}
+}
+
+func TestAnswerEdits(t *testing.T) {
+	t.Setenv("SENIOR_LAWYERS", "minh")
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 24. Thỏa thuận thử việc", ArticleNumber: "Dieu_24", Title: "Thỏa thuận thử việc"}},
+		Iterations:    1,
+	}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+	lan, minh := userKey(t, h, "lan"), userKey(t, h, "minh")
+
+	var answer engine.LegalQueryResponse
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao lâu?"})
+	if err := json.Unmarshal(rec.Body.Bytes(), &answer); err != nil || answer.HistoryID == "" {
+		t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+	}
+	edits := "/api/history/" + answer.HistoryID + "/edits"
+
+	edited := "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày; việc thử việc được thỏa thuận theo Điều 24 Bộ luật Lao động 2019."
+	var edit AnswerEdit
+	rec = doAs(t, h, "lan", http.MethodPost, edits, EditRequest{Answer: edited, Note: "Dẫn thêm Điều 24"})
+	if err := json.Unmarshal(rec.Body.Bytes(), &edit); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("edit = %d %s", rec.Code, rec.Body.String())
+	}
+	if edit.Version != 1 || edit.Editor != "lan" || !slices.Equal(edit.Summary.CitationsAdded, []string{"Điều 24 Bộ luật Lao động 2019"}) || !slices.Contains(edit.Summary.Patterns, EditCitationsAdded) {
+		t.Errorf("edit = %+v, want version 1 adding Điều 24", edit)
+	}
+
+	// Article 36 was neither cited nor retrieved
+	rec = doAs(t, h, "lan", http.MethodPost, edits, EditRequest{Answer: edited + " Xem thêm Điều 36 Bộ luật Lao động 2019."})
+	if code := decodeError(t, rec).Code; code != ErrCodeCitationUnsupported {
+		t.Errorf("unsupported citation = %s, want %s", code, ErrCodeCitationUnsupported)
+	}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, edits, EditRequest{Answer: edited})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("unchanged answer = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+
+	var versions AnswerEdits
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, edits, nil).Body.Bytes(), &versions)
+	if versions.Original != stub.resp.Answer || len(versions.Versions) != 1 {
+		t.Errorf("edits = %+v, want the original and one version", versions)
+	}
+
+	// Shares and signatures carry the edited answer
+	review := "/api/history/" + answer.HistoryID + "/review"
+	doWithKey(t, h, lan, http.MethodPost, review, ReviewRequest{State: ReviewReviewed})
+	if rec := doWithKey(t, h, minh, http.MethodPost, review, ReviewRequest{State: ReviewApproved}); rec.Code != http.StatusOK {
+		t.Fatalf("approve = %d %s", rec.Code, rec.Body.String())
+	}
+	var share Share
+	json.Unmarshal(doAs(t, h, "lan", http.MethodPost, "/api/shares", CreateShareRequest{HistoryIDs: []string{answer.HistoryID}}).Body.Bytes(), &share)
+	var shared struct {
+		Answers []SharedAnswer `json:"answers"`
+	}
+	json.Unmarshal(doJSON(t, h, http.MethodGet, "/api/shared/"+share.ID, nil).Body.Bytes(), &shared)
+	if len(shared.Answers) != 1 || shared.Answers[0].Answer != edited {
+		t.Errorf("shared answers = %+v, want the edited answer", shared.Answers)
+	}
+	var signature struct {
+		MatchesHistory bool `json:"matches_history"`
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/history/"+answer.HistoryID+"/signature", nil).Body.Bytes(), &signature)
+	if !signature.MatchesHistory {
+		t.Error("signature does not match the edited answer")
+	}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, edits, EditRequest{Answer: stub.resp.Answer})).Code; code != ErrCodeReviewConflict {
+		t.Errorf("editing an approved answer = %s, want %s", code, ErrCodeReviewConflict)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/evaluation/edits", nil)
+	req.Header.Set("X-Admin-Token", "admin-token")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var examples []EditExample
+	for scanner := bufio.NewScanner(rec.Body); scanner.Scan(); {
+		var example EditExample
+		if err := json.Unmarshal(scanner.Bytes(), &example); err != nil {
+			t.Fatalf("dataset line %q: %v", scanner.Text(), err)
+		}
+		examples = append(examples, example)
+	}
+	if len(examples) != 1 || examples[0].Reference != edited || examples[0].Original != stub.resp.Answer || examples[0].Sources[0] == "" {
+		t.Errorf("dataset = %d %+v, want the edited answer as the reference", rec.Code, examples)
+	}
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// embeddingExperimentHeader asks for the embedding experiment on a query
+// regardless of the sample rate, e.g. from an evaluation script
+const embeddingExperimentHeader = "X-Embedding-Experiment"
+
+// baselineProvider names the main engine among the embedding providers
+const baselineProvider = "default"
+
+// EmbeddingProvider is an engine deployment retrieving with another
+// embedding model, e.g. a Vietnamese-specific one, over a collection
+// embedded with that model
+type EmbeddingProvider struct {
+	Name string `json:"name"`
+	URL  string `json:"-"`
+}
+
+// EmbeddingConfig configures the embedding experiment: a sample of queries
+// is also retrieved by every provider, and the results are compared with
+// those of the main engine
+type EmbeddingConfig struct {
+	Providers []EmbeddingProvider
+	// SampleRate is the fraction of queries the experiment runs for
+	SampleRate float64
+	Timeout    time.Duration
+}
+
+func loadEmbeddingConfig() EmbeddingConfig {
+	providers, err := parseEmbeddingProviders(settings.Get("EMBEDDING_PROVIDERS"))
+	if err != nil {
+		settings.Warn("EMBEDDING_PROVIDERS", "%v, embedding experiment disabled", err)
+	}
+	return EmbeddingConfig{
+		Providers:  providers,
+		SampleRate: settings.FloatInRange("EMBEDDING_EXPERIMENT_RATE", 0, 0, 1),
+		Timeout:    settings.Duration("EMBEDDING_EXPERIMENT_TIMEOUT", 10*time.Second),
+	}
+}
+
+// parseEmbeddingProviders parses EMBEDDING_PROVIDERS: comma-separated
+// name=engine-url items
+func parseEmbeddingProviders(value string) ([]EmbeddingProvider, error) {
+	var providers []EmbeddingProvider
+	seen := map[string]bool{baselineProvider: true}
+	for _, item := range settings.SplitList(value) {
+		name, url, ok := strings.Cut(item, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid embedding provider %q, expected name=url", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate embedding provider %q", name)
+		}
+		seen[name] = true
+		providers = append(providers, EmbeddingProvider{Name: name, URL: url})
+	}
+	return providers, nil
+}
+
+// RetrievalOverlap compares what a provider retrieved with what the main
+// engine retrieved for the same question
+type RetrievalOverlap struct {
+	// Overlap is the share of the main engine's passages the provider also
+	// retrieved
+	Overlap float64 `json:"overlap"`
+	// Jaccard is the size of the intersection over the size of the union
+	Jaccard float64 `json:"jaccard"`
+	// TopMatch is set when both retrieved the same best passage
+	TopMatch bool `json:"top_match"`
+}
+
+// retrievalOverlap compares the passages of a provider with the baseline,
+// each passage counted once
+func retrievalOverlap(baseline, candidate []engine.DocumentHit) RetrievalOverlap {
+	base := make(map[string]bool, len(baseline))
+	for _, hit := range baseline {
+		base[hitKey(hit)] = true
+	}
+	found := make(map[string]bool, len(candidate))
+	shared := 0
+	for _, hit := range candidate {
+		key := hitKey(hit)
+		if base[key] && !found[key] {
+			shared++
+		}
+		found[key] = true
+	}
+
+	var o RetrievalOverlap
+	if len(base) > 0 {
+		o.Overlap = float64(shared) / float64(len(base))
+	}
+	if union := len(base) + len(found) - shared; union > 0 {
+		o.Jaccard = float64(shared) / float64(union)
+	}
+	o.TopMatch = len(baseline) > 0 && len(candidate) > 0 && hitKey(baseline[0]) == hitKey(candidate[0])
+	return o
+}
+
+// hitKey identifies a passage across providers: its article, or its chunk
+// of a private document, or else its text
+func hitKey(hit engine.DocumentHit) string {
+	if id := resultArticleID(hit); id != "" {
+		return id
+	}
+	if hit.DocumentID != "" {
+		return hit.DocumentID + "#" + strconv.Itoa(hit.ChunkIndex)
+	}
+	return hit.Excerpt
+}
+
+// embeddingProvider is a provider with the engine that serves it
+type embeddingProvider struct {
+	EmbeddingProvider
+	retriever engine.Retriever
+}
+
+// EmbeddingExperiment retrieves sampled queries with every provider in the
+// background, logging and summing up how their results overlap with those
+// of the main engine. Answers never depend on it.
+type EmbeddingExperiment struct {
+	baseline   engine.Retriever
+	providers  []embeddingProvider
+	sampleRate float64
+	timeout    time.Duration
+	background *sync.WaitGroup
+
+	mu    sync.Mutex
+	stats map[string]*providerStats
+}
+
+type providerStats struct {
+	runs     int
+	failures int
+	overlap  float64
+	jaccard  float64
+	topMatch int
+	latency  time.Duration
+}
+
+func newEmbeddingExperiment(config EmbeddingConfig, baseline engine.Retriever, providers []embeddingProvider, background *sync.WaitGroup) *EmbeddingExperiment {
+	e := &EmbeddingExperiment{
+		baseline:   baseline,
+		providers:  providers,
+		sampleRate: config.SampleRate,
+		timeout:    config.Timeout,
+		background: background,
+		stats:      map[string]*providerStats{baselineProvider: {}},
+	}
+	for _, p := range providers {
+		e.stats[p.Name] = &providerStats{}
+	}
+	return e
+}
+
+// Enabled reports whether there is anything to compare
+func (e *EmbeddingExperiment) Enabled() bool {
+	return e != nil && e.baseline != nil && len(e.providers) > 0
+}
+
+// sampled reports whether the experiment runs for a query, asked for by
+// the request header or drawn at the sample rate
+func (e *EmbeddingExperiment) sampled(c *gin.Context) bool {
+	if !e.Enabled() {
+		return false
+	}
+	if forced, err := strconv.ParseBool(c.GetHeader(embeddingExperimentHeader)); err == nil {
+		return forced
+	}
+	return e.sampleRate > 0 && rand.Float64() < e.sampleRate
+}
+
+// Start runs the experiment for the query of a request when it is sampled,
+// without delaying the answer
+func (e *EmbeddingExperiment) Start(c *gin.Context, req *engine.PythonQueryRequest) {
+	if !e.sampled(c) {
+		return
+	}
+	search := &engine.SearchRequest{Question: req.Question, TopK: req.TopK, Namespace: req.Namespace, Collection: req.Collection, ExcludeCollections: req.ExcludeCollections}
+	// The request's context carries its ID into the logs but ends with it
+	ctx := context.WithoutCancel(c.Request.Context())
+	e.background.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+		e.Run(ctx, search)
+	})
+}
+
+// Run retrieves the question with the main engine and every provider in
+// parallel and records how the results of each provider overlap with the
+// main engine's
+func (e *EmbeddingExperiment) Run(ctx context.Context, req *engine.SearchRequest) {
+	type outcome struct {
+		resp    *engine.SearchResponse
+		err     error
+		latency time.Duration
+	}
+	retrievers := []engine.Retriever{e.baseline}
+	for _, p := range e.providers {
+		retrievers = append(retrievers, p.retriever)
+	}
+	outcomes := make([]outcome, len(retrievers))
+	var wg sync.WaitGroup
+	for i, r := range retrievers {
+		wg.Go(func() {
+			started := time.Now()
+			resp, err := r.Search(ctx, req)
+			outcomes[i] = outcome{resp: resp, err: err, latency: time.Since(started)}
+		})
+	}
+	wg.Wait()
+
+	base := outcomes[0]
+	if base.err != nil {
+		slog.WarnContext(ctx, "Embedding experiment: the main engine failed to retrieve", "error", base.err)
+		e.record(baselineProvider, nil, 0)
+		return
+	}
+	e.record(baselineProvider, &RetrievalOverlap{Overlap: 1, Jaccard: 1, TopMatch: true}, base.latency)
+	for i, p := range e.providers {
+		out := outcomes[i+1]
+		if out.err != nil {
+			slog.WarnContext(ctx, "Embedding experiment: provider failed to retrieve", "provider", p.Name, "error", out.err)
+			e.record(p.Name, nil, 0)
+			continue
+		}
+		o := retrievalOverlap(base.resp.SearchResults, out.resp.SearchResults)
+		slog.InfoContext(ctx, "Embedding experiment", "provider", p.Name, "model", out.resp.EmbeddingModel,
+			"baseline_model", base.resp.EmbeddingModel, "top_k", req.TopK, "overlap", o.Overlap, "jaccard", o.Jaccard,
+			"top_match", o.TopMatch, "latency_ms", out.latency.Milliseconds(), "baseline_latency_ms", base.latency.Milliseconds())
+		e.record(p.Name, &o, out.latency)
+	}
+}
+
+// record adds a run of a provider to its stats; o is nil when it failed
+func (e *EmbeddingExperiment) record(provider string, o *RetrievalOverlap, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.stats[provider]
+	s.runs++
+	if o == nil {
+		s.failures++
+		return
+	}
+	s.overlap += o.Overlap
+	s.jaccard += o.Jaccard
+	if o.TopMatch {
+		s.topMatch++
+	}
+	s.latency += latency
+}
+
+// EmbeddingProviderSummary averages the runs of a provider that succeeded
+type EmbeddingProviderSummary struct {
+	Name             string  `json:"name"`
+	Runs             int     `json:"runs"`
+	Failures         int     `json:"failures"`
+	AverageOverlap   float64 `json:"average_overlap"`
+	AverageJaccard   float64 `json:"average_jaccard"`
+	TopMatchRate     float64 `json:"top_match_rate"`
+	AverageLatencyMs int64   `json:"average_latency_ms"`
+}
+
+// EmbeddingExperimentSummary is returned by the embedding experiment
+// endpoint; the main engine is the provider named default
+type EmbeddingExperimentSummary struct {
+	Enabled    bool                       `json:"enabled"`
+	SampleRate float64                    `json:"sample_rate"`
+	Providers  []EmbeddingProviderSummary `json:"providers"`
+}
+
+func (e *EmbeddingExperiment) Summary() EmbeddingExperimentSummary {
+	summary := EmbeddingExperimentSummary{Enabled: e.Enabled(), Providers: []EmbeddingProviderSummary{}}
+	if e == nil {
+		return summary
+	}
+	summary.SampleRate = e.sampleRate
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, s := range e.stats {
+		p := EmbeddingProviderSummary{Name: name, Runs: s.runs, Failures: s.failures}
+		if ok := s.runs - s.failures; ok > 0 {
+			p.AverageOverlap = s.overlap / float64(ok)
+			p.AverageJaccard = s.jaccard / float64(ok)
+			p.TopMatchRate = float64(s.topMatch) / float64(ok)
+			p.AverageLatencyMs = (s.latency / time.Duration(ok)).Milliseconds()
+		}
+		summary.Providers = append(summary.Providers, p)
+	}
+	slices.SortFunc(summary.Providers, func(a, b EmbeddingProviderSummary) int { return strings.Compare(a.Name, b.Name) })
+	return summary
+}
+
+// Handlers
+
+func embeddingExperimentHandler(experiment *EmbeddingExperiment) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, experiment.Summary())
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestRetrievalOverlap(t *testing.T) {
+	hit := func(article string) engine.DocumentHit {
+		return engine.DocumentHit{ArticleNumber: article, Excerpt: article}
+	}
+	baseline := []engine.DocumentHit{hit("Dieu_25"), hit("Dieu_26"), hit("Dieu_27"), hit("Dieu_24")}
+	for _, tc := range []struct {
+		name      string
+		candidate []engine.DocumentHit
+		want      RetrievalOverlap
+	}{
+		{"same", baseline, RetrievalOverlap{Overlap: 1, Jaccard: 1, TopMatch: true}},
+		{"half", []engine.DocumentHit{hit("Dieu_26"), hit("Dieu_25"), hit("Dieu_90"), hit("Dieu_91")}, RetrievalOverlap{Overlap: 0.5, Jaccard: 2.0 / 6}},
+		{"chunks counted once", []engine.DocumentHit{hit("Dieu_25"), hit("Dieu_25"), hit("Dieu_25")}, RetrievalOverlap{Overlap: 0.25, Jaccard: 0.25, TopMatch: true}},
+		{"nothing", nil, RetrievalOverlap{}},
+	} {
+		if got := retrievalOverlap(baseline, tc.candidate); got != tc.want {
+			t.Errorf("%s: overlap = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	private := []engine.DocumentHit{{DocumentID: "hd-01", ChunkIndex: 0, Excerpt: "a"}, {DocumentID: "hd-01", ChunkIndex: 3, Excerpt: "b"}}
+	if got := retrievalOverlap(private, private[1:]); got.Overlap != 0.5 || got.TopMatch {
+		t.Errorf("private chunks: overlap = %+v, want 0.5 without top match", got)
+	}
+}
+
+func TestParseEmbeddingProviders(t *testing.T) {
+	providers, err := parseEmbeddingProviders("vi-sbert=http://engine-vi:8000, e5=http://engine-e5:8000")
+	if err != nil || len(providers) != 2 || providers[1] != (EmbeddingProvider{Name: "e5", URL: "http://engine-e5:8000"}) {
+		t.Fatalf("providers = %+v, %v", providers, err)
+	}
+	for _, value := range []string{"vi-sbert", "vi-sbert=", "default=http://engine:8000", "a=http://x,a=http://y"} {
+		if _, err := parseEmbeddingProviders(value); err == nil {
+			t.Errorf("parseEmbeddingProviders(%q) accepted", value)
+		}
+	}
+}
+
+func TestEmbeddingExperiment(t *testing.T) {
+	// searchEngine answers queries and retrieves the given articles
+	searchEngine := func(model string, articles ...string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/query":
+				json.NewEncoder(w).Encode(engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019...", Iterations: 1})
+			case "/api/search":
+				var req engine.SearchRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				resp := engine.SearchResponse{EmbeddingModel: model}
+				for _, a := range articles[:min(req.TopK, len(articles))] {
+					resp.SearchResults = append(resp.SearchResults, engine.DocumentHit{ArticleNumber: a, Excerpt: a, Score: 0.8})
+				}
+				json.NewEncoder(w).Encode(resp)
+			}
+		}))
+	}
+	primary := searchEngine("bkai-foundation-models/vietnamese-bi-encoder", "Dieu_25", "Dieu_26", "Dieu_27")
+	defer primary.Close()
+	vietnamese := searchEngine("keepitreal/vietnamese-sbert", "Dieu_25", "Dieu_27", "Dieu_90")
+	defer vietnamese.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", primary.URL)
+	t.Setenv("EMBEDDING_PROVIDERS", "vi-sbert="+vietnamese.URL)
+	t.Setenv("ADMIN_TOKEN", "embedding-admin-token")
+	srv := newTestServer(t, Options{})
+
+	ask := func(question, experiment string) {
+		body, _ := json.Marshal(LegalQueryRequest{Question: question})
+		req := httptest.NewRequest(http.MethodPost, "/api/legal-query", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if experiment != "" {
+			req.Header.Set(embeddingExperimentHeader, experiment)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query: status %d %s", rec.Code, rec.Body)
+		}
+	}
+	ask("Thời gian thử việc tối đa là bao lâu?", "")
+	ask("Người lao động được nghỉ phép năm bao nhiêu ngày?", "false")
+	ask("Tiền lương thử việc ít nhất bằng bao nhiêu?", "true")
+
+	var summary EmbeddingExperimentSummary
+	// The experiment runs after the answer is sent
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/embeddings", nil)
+		req.Header.Set("X-Admin-Token", "embedding-admin-token")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("summary: %d %s", rec.Code, rec.Body)
+		}
+		if len(summary.Providers) == 2 && summary.Providers[1].Runs > 0 {
+			break
+		}
+	}
+	if !summary.Enabled || len(summary.Providers) != 2 {
+		t.Fatalf("summary = %+v, want the default and vi-sbert providers", summary)
+	}
+	got := summary.Providers[1]
+	if got.Name != "vi-sbert" || got.Runs != 1 || got.Failures != 0 || got.TopMatchRate != 1 {
+		t.Errorf("vi-sbert = %+v, want one run with the same top passage", got)
+	}
+	if got.AverageOverlap != 2.0/3 || got.AverageJaccard != 0.5 {
+		t.Errorf("vi-sbert overlap = %v, jaccard = %v, want 2/3 and 1/2", got.AverageOverlap, got.AverageJaccard)
+	}
+}
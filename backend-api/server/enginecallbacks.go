@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// EngineCallbackConfig enables the callback mode of the engine: queries are
+// submitted to the engine, which queues them itself and posts each answer
+// back, instead of the backend holding a connection open until the answer
+// is ready. URL is the base URL of the backend as the engine reaches it.
+type EngineCallbackConfig struct {
+	URL string
+
+	// Timeout bounds the wait for the callback of one query
+	Timeout time.Duration
+}
+
+// engineCallbackPath, followed by the job ID, receives the answers of
+// submitted queries
+const engineCallbackPath = "/engine/callbacks/"
+
+// queryCallback is a query awaiting its answer from the engine
+type queryCallback chan engine.QueryCallback
+
+// callbackEngine submits queries to the engine with a callback URL and waits
+// for the engine to post the answer, correlated by job ID. Streamed queries
+// need the connection and are sent directly.
+type callbackEngine struct {
+	client   *engine.PythonClient
+	url      string
+	timeout  time.Duration
+	shutdown context.Context
+
+	mu      sync.Mutex
+	pending map[string]queryCallback
+}
+
+func newCallbackEngine(config EngineCallbackConfig, client *engine.PythonClient, shutdown context.Context) *callbackEngine {
+	return &callbackEngine{
+		client:   client,
+		url:      strings.TrimSuffix(config.URL, "/"),
+		timeout:  config.Timeout,
+		shutdown: shutdown,
+		pending:  make(map[string]queryCallback),
+	}
+}
+
+func (e *callbackEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *callbackEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	if req.OnEvent != nil {
+		return e.client.QueryContext(ctx, req)
+	}
+
+	id := "eng_" + randomHex(12)
+	callback := make(queryCallback, 1)
+	e.mu.Lock()
+	e.pending[id] = callback
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, id)
+		e.mu.Unlock()
+	}()
+
+	if err := e.client.SubmitQuery(ctx, req, id, e.url+engineCallbackPath+id); err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Query submitted to the engine", "job", id)
+
+	timer := time.NewTimer(e.timeout)
+	defer timer.Stop()
+	select {
+	case cb := <-callback:
+		if err := cb.Err(); err != nil {
+			return nil, err
+		}
+		return cb.Response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.shutdown.Done():
+		return nil, context.Cause(e.shutdown)
+	case <-timer.C:
+		return nil, fmt.Errorf("engine did not call back for job %s within %s: %w", id, e.timeout, context.DeadlineExceeded)
+	}
+}
+
+// HealthCheck checks the engine the queries are submitted to
+func (e *callbackEngine) HealthCheck() error {
+	return e.client.HealthCheck()
+}
+
+// deliver hands a callback to the query awaiting it, and reports whether
+// one was
+func (e *callbackEngine) deliver(cb engine.QueryCallback) bool {
+	e.mu.Lock()
+	callback, ok := e.pending[cb.JobID]
+	delete(e.pending, cb.JobID)
+	e.mu.Unlock()
+	if ok {
+		callback <- cb
+	}
+	return ok
+}
+
+// Handlers
+
+// engineCallbackHandler receives the answer of a submitted query. Callbacks
+// for queries no longer awaited, because they timed out or the client left,
+// are answered 404 so the engine does not retry them.
+func engineCallbackHandler(callbacks *callbackEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cb engine.QueryCallback
+		if err := c.ShouldBindJSON(&cb); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if cb.JobID == "" {
+			cb.JobID = c.Param("id")
+		}
+		if cb.JobID != c.Param("id") {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("job_id %q does not match the callback URL", cb.JobID))
+			return
+		}
+		if !callbacks.deliver(cb) {
+			abortWithError(c, ErrCodeJobNotFound, fmt.Sprintf("Job %q is not awaited", cb.JobID))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestEngineCallbacks(t *testing.T) {
+	signer := engine.NewSigner(testSigningSecret, time.Minute)
+	postCallback := func(url string, cb engine.QueryCallback) int {
+		body, _ := json.Marshal(cb)
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		signer.Sign(req, body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("callback: %v", err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	failing := LegalQueryRequest{Question: "Mức phạt khi không ký hợp đồng lao động là bao nhiêu?"}
+
+	// The engine answers the submission at once and posts the answer later
+	callbackStatus := make(chan int, 1)
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/query/submit" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var submitted struct {
+			Question    string `json:"question"`
+			JobID       string `json:"job_id"`
+			CallbackURL string `json:"callback_url"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &submitted)
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			cb := engine.QueryCallback{JobID: submitted.JobID, Response: &engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019...", Iterations: 1}}
+			if submitted.Question == failing.Question {
+				cb = engine.QueryCallback{JobID: submitted.JobID, Error: "model crashed"}
+			}
+			callbackStatus <- postCallback(submitted.CallbackURL, cb)
+		}()
+	}))
+	defer pythonEngine.Close()
+
+	var h http.Handler
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h.ServeHTTP(w, r) }))
+	defer backend.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	t.Setenv("ENGINE_SIGNING_SECRET", testSigningSecret)
+	t.Setenv("ENGINE_CALLBACK_URL", backend.URL)
+	t.Setenv("REQUIRE_API_KEY", "true")
+	t.Setenv("ADMIN_TOKEN", "test-admin-token-0123456789")
+	h = newTestServer(t, Options{}).Handler()
+	post := func(path string, headers map[string]string, body any) *http.Response {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, backend.URL+path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		return resp
+	}
+
+	// Engine callbacks need no API key
+	resp := post("/admin/api-keys", map[string]string{"X-Admin-Token": "test-admin-token-0123456789"}, map[string]string{"name": "portal"})
+	var key CreateAPIKeyResponse
+	json.NewDecoder(resp.Body).Decode(&key)
+	resp.Body.Close()
+	if key.Key == "" {
+		t.Fatalf("create API key = %d", resp.StatusCode)
+	}
+	client := func(body any) *http.Response {
+		return post("/api/legal-query", map[string]string{"X-API-Key": key.Key}, body)
+	}
+
+	resp = client(LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"})
+	var answer engine.LegalQueryResponse
+	json.NewDecoder(resp.Body).Decode(&answer)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || answer.Answer == "" {
+		t.Fatalf("query = %d %+v, want the answer posted back by the engine", resp.StatusCode, answer)
+	}
+	if status := <-callbackStatus; status != http.StatusNoContent {
+		t.Errorf("callback = %d, want %d", status, http.StatusNoContent)
+	}
+
+	resp = client(failing)
+	var errResp ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	resp.Body.Close()
+	if errResp.Code != ErrCodeEngineError {
+		t.Errorf("failed query = %d %s, want %s", resp.StatusCode, errResp.Code, ErrCodeEngineError)
+	}
+	<-callbackStatus
+
+	if status := postCallback(backend.URL+engineCallbackPath+"eng_unknown", engine.QueryCallback{JobID: "eng_unknown", Error: "late"}); status != http.StatusNotFound {
+		t.Errorf("callback of a job not awaited = %d, want %d", status, http.StatusNotFound)
+	}
+	resp = post(engineCallbackPath+"eng_unknown", nil, engine.QueryCallback{JobID: "eng_unknown", Error: "forged"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned callback = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// EngineSigningConfig sets the secret shared with the engine. When it is
+// set, requests to the engine are signed and engine callbacks must be.
+type EngineSigningConfig struct {
+	Secret  string
+	MaxSkew time.Duration
+}
+
+// signer returns the signer for the secret, or nil when signing is off
+func (c EngineSigningConfig) signer() *engine.Signer {
+	if c.Secret == "" {
+		return nil
+	}
+	return engine.NewSigner(c.Secret, c.MaxSkew)
+}
+
+// signingTransport signs every engine request, so the engine can reject
+// traffic that does not come from the backend
+type signingTransport struct {
+	next   http.RoundTripper
+	signer *engine.Signer
+}
+
+func newSigningTransport(signer *engine.Signer, next http.RoundTripper) *signingTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &signingTransport{next: next, signer: signer}
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+	// A RoundTripper must not modify the request it was given
+	signed := req.Clone(req.Context())
+	if req.Body != nil {
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.signer.Sign(signed, body)
+	return t.next.RoundTrip(signed)
+}
+
+// requireEngineSignature rejects engine callbacks that are unsigned, altered
+// or replayed
+func requireEngineSignature(signer *engine.Signer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if signer == nil {
+			abortWithError(c, ErrCodeForbidden, "Engine callbacks require ENGINE_SIGNING_SECRET")
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+		if err := signer.Verify(c.Request, body); err != nil {
+			slog.WarnContext(c.Request.Context(), "Rejected engine callback", "path", c.Request.URL.Path, "client_ip", c.ClientIP(), "error", err)
+			abortWithError(c, ErrCodeUnauthorized, "Invalid engine signature")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
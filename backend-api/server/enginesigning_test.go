@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const testSigningSecret = "0123456789abcdef0123456789abcdef"
+
+func TestEngineRequestSigning(t *testing.T) {
+	verifier := engine.NewSigner(testSigningSecret, time.Minute)
+	var verifyErr error
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if verifyErr = verifier.Verify(r, body); verifyErr != nil {
+			http.Error(w, verifyErr.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019...", Iterations: 1})
+	}))
+	defer pythonEngine.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	t.Setenv("ENGINE_SIGNING_SECRET", testSigningSecret)
+	h := newTestServer(t, Options{}).Handler()
+
+	rec := doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"})
+	if rec.Code != http.StatusOK || verifyErr != nil {
+		t.Fatalf("query = %d (engine verification: %v), want a signed request answered", rec.Code, verifyErr)
+	}
+}
+
+func TestRequireEngineSignature(t *testing.T) {
+	signer := engine.NewSigner(testSigningSecret, time.Minute)
+	router := gin.New()
+	router.POST("/callback", requireEngineSignature(signer), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	body := []byte(`{"job_id":"job-1"}`)
+	signed := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	engine.NewSigner(testSigningSecret, time.Minute).Sign(signed, body)
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(signed); rec.Code != http.StatusOK || rec.Body.String() != string(body) {
+		t.Fatalf("signed callback = %d %q, want 200 with the body passed on", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, send(signed)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("replayed callback = %s, want %s", code, ErrCodeUnauthorized)
+	}
+	if code := decodeError(t, send(httptest.NewRequest(http.MethodPost, "/callback", nil))).Code; code != ErrCodeUnauthorized {
+		t.Errorf("unsigned callback = %s, want %s", code, ErrCodeUnauthorized)
+	}
+}
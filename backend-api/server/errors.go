@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// ErrorCode is a stable, machine-readable identifier returned with every
+// error response. Clients should branch on the code, never on the message.
+type ErrorCode string
+
+// Error codes. Values are part of the public API and must never change;
+// add new codes instead of renaming existing ones.
+const (
+	ErrCodeInvalidRequest       ErrorCode = "INVALID_REQUEST"
+	ErrCodeInvalidParam         ErrorCode = "INVALID_PARAM"
+	ErrCodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden            ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound             ErrorCode = "NOT_FOUND"
+	ErrCodeTenantNotFound       ErrorCode = "TENANT_NOT_FOUND"
+	ErrCodeAttachmentNotFound   ErrorCode = "ATTACHMENT_NOT_FOUND"
+	ErrCodePayloadTooLarge      ErrorCode = "PAYLOAD_TOO_LARGE"
+	ErrCodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeQuotaExceeded        ErrorCode = "QUOTA_EXCEEDED"
+	ErrCodeRateLimited          ErrorCode = "RATE_LIMITED"
+	ErrCodeCollectionNotFound   ErrorCode = "COLLECTION_NOT_FOUND"
+	ErrCodeCollectionExists     ErrorCode = "COLLECTION_EXISTS"
+	ErrCodeDocumentNotFound     ErrorCode = "DOCUMENT_NOT_FOUND"
+	ErrCodePresetNotFound       ErrorCode = "PRESET_NOT_FOUND"
+	ErrCodeOCRUnavailable       ErrorCode = "OCR_UNAVAILABLE"
+	ErrCodeExportUnavailable    ErrorCode = "EXPORT_UNAVAILABLE"
+	ErrCodePendingQueryNotFound ErrorCode = "PENDING_QUERY_NOT_FOUND"
+	ErrCodeHistoryNotFound      ErrorCode = "HISTORY_NOT_FOUND"
+	ErrCodeTopicNotFound        ErrorCode = "TOPIC_NOT_FOUND"
+	ErrCodeBinderNotFound       ErrorCode = "BINDER_NOT_FOUND"
+	ErrCodeCommentNotFound      ErrorCode = "COMMENT_NOT_FOUND"
+	ErrCodeShareNotFound        ErrorCode = "SHARE_NOT_FOUND"
+	ErrCodePublicPageNotFound   ErrorCode = "PUBLIC_PAGE_NOT_FOUND"
+	ErrCodeReviewConflict       ErrorCode = "REVIEW_CONFLICT"
+	ErrCodeNotificationNotFound ErrorCode = "NOTIFICATION_NOT_FOUND"
+	ErrCodeQuickRefNotFound     ErrorCode = "QUICKREF_NOT_FOUND"
+	ErrCodeAPIKeyNotFound       ErrorCode = "API_KEY_NOT_FOUND"
+	ErrCodeFeedTokenNotFound    ErrorCode = "FEED_TOKEN_NOT_FOUND"
+	ErrCodeJobNotFound          ErrorCode = "JOB_NOT_FOUND"
+	ErrCodeJobQueueFull         ErrorCode = "JOB_QUEUE_FULL"
+	ErrCodeHistoryUnavailable   ErrorCode = "HISTORY_UNAVAILABLE"
+	ErrCodeStatusNotFound       ErrorCode = "STATUS_MESSAGE_NOT_FOUND"
+	ErrCodeReviewJobNotFound    ErrorCode = "REVIEW_JOB_NOT_FOUND"
+	ErrCodeConversationNotFound ErrorCode = "CONVERSATION_NOT_FOUND"
+	ErrCodeWatchNotFound        ErrorCode = "WATCH_NOT_FOUND"
+	ErrCodeCitationUnsupported  ErrorCode = "CITATION_UNSUPPORTED"
+	ErrCodePolicyViolation      ErrorCode = "POLICY_VIOLATION"
+	ErrCodeConfigInvalid        ErrorCode = "CONFIG_INVALID"
+	ErrCodeLicenseExpired       ErrorCode = "LICENSE_EXPIRED"
+	ErrCodeLicenseSeatsExceeded ErrorCode = "LICENSE_SEATS_EXCEEDED"
+	ErrCodeFeatureUnlicensed    ErrorCode = "LICENSE_FEATURE_DISABLED"
+	ErrCodeEngineTimeout        ErrorCode = "ENGINE_TIMEOUT"
+	ErrCodeEngineUnavailable    ErrorCode = "ENGINE_UNAVAILABLE"
+	ErrCodeEngineError          ErrorCode = "ENGINE_ERROR"
+	ErrCodeEngineSchema         ErrorCode = "ENGINE_SCHEMA_MISMATCH"
+	ErrCodeEngineWarmingUp      ErrorCode = "ENGINE_WARMING_UP"
+	ErrCodeEngineBusy           ErrorCode = "ENGINE_BUSY"
+	ErrCodeEngineCircuitOpen    ErrorCode = "ENGINE_CIRCUIT_OPEN"
+	ErrCodeShuttingDown         ErrorCode = "SHUTTING_DOWN"
+	ErrCodeClientClosed         ErrorCode = "CLIENT_CLOSED_REQUEST"
+	ErrCodeInternal             ErrorCode = "INTERNAL_ERROR"
+)
+
+// statusClientClosedRequest is the non-standard status, borrowed from
+// nginx, logged for requests whose client went away before the answer
+const statusClientClosedRequest = 499
+
+// ErrorDefinition documents a single entry of the error catalog
+type ErrorDefinition struct {
+	Code        ErrorCode `json:"code"`
+	HTTPStatus  int       `json:"http_status"`
+	Retryable   bool      `json:"retryable"`
+	Description string    `json:"description"`
+}
+
+// errorCatalog is the single source of truth for error codes, their HTTP
+// status and whether a client may safely retry.
+var errorCatalog = []ErrorDefinition{
+	{ErrCodeInvalidRequest, http.StatusBadRequest, false, "The request body is malformed or fails validation."},
+	{ErrCodeInvalidParam, http.StatusBadRequest, false, "A query string parameter is malformed or out of range; details.param names it."},
+	{ErrCodeUnauthorized, http.StatusUnauthorized, false, "Credentials are missing or invalid."},
+	{ErrCodeForbidden, http.StatusForbidden, false, "The caller is not allowed to perform this operation, or the feature is disabled."},
+	{ErrCodeNotFound, http.StatusNotFound, false, "The requested route or resource does not exist."},
+	{ErrCodeTenantNotFound, http.StatusNotFound, false, "The tenant named by X-Tenant-ID or the URL does not exist."},
+	{ErrCodeAttachmentNotFound, http.StatusNotFound, false, "A referenced attachment does not exist, has expired, or belongs to another tenant."},
+	{ErrCodeStatusNotFound, http.StatusNotFound, false, "The status message does not exist or was deleted."},
+	{ErrCodeReviewJobNotFound, http.StatusNotFound, false, "The review job does not exist, expired after finishing, or belongs to another tenant."},
+	{ErrCodeConversationNotFound, http.StatusNotFound, false, "The conversation does not exist, expired after staying idle, or was started by another user."},
+	{ErrCodeWatchNotFound, http.StatusNotFound, false, "The watch does not exist or belongs to another user."},
+	{ErrCodePayloadTooLarge, http.StatusRequestEntityTooLarge, false, "The request body or an uploaded file exceeds the allowed size."},
+	{ErrCodeMethodNotAllowed, http.StatusMethodNotAllowed, false, "The route exists but does not accept this HTTP method."},
+	{ErrCodeQuotaExceeded, http.StatusTooManyRequests, true, "The client sent its daily queries; retry after the quota renews at midnight UTC."},
+	{ErrCodeRateLimited, http.StatusTooManyRequests, true, "The client sent requests faster than the rate limit; retry after the Retry-After delay."},
+	{ErrCodeCollectionNotFound, http.StatusNotFound, false, "The requested document collection does not exist or is not visible to the caller."},
+	{ErrCodeCollectionExists, http.StatusConflict, false, "A private collection of that name already exists for the tenant, or a shared collection has the name."},
+	{ErrCodeDocumentNotFound, http.StatusNotFound, false, "The document does not exist in the private collection."},
+	{ErrCodePresetNotFound, http.StatusNotFound, false, "The query preset is neither built in nor defined by the caller's tenant."},
+	{ErrCodePendingQueryNotFound, http.StatusNotFound, false, "The pending query being clarified does not exist, has expired, or was already answered."},
+	{ErrCodeHistoryNotFound, http.StatusNotFound, false, "The history entry does not exist, was evicted, or belongs to another tenant."},
+	{ErrCodeTopicNotFound, http.StatusNotFound, false, "The taxonomy topic, or the parent named in the request, does not exist in the taxonomy."},
+	{ErrCodeBinderNotFound, http.StatusNotFound, false, "The binder, or the item named in the URL, does not exist or is private to another user."},
+	{ErrCodeCommentNotFound, http.StatusNotFound, false, "The comment, or the parent comment named in the request, does not exist on this answer."},
+	{ErrCodeShareNotFound, http.StatusNotFound, false, "The share link does not exist, has expired, or was revoked."},
+	{ErrCodePublicPageNotFound, http.StatusNotFound, false, "The answer is not published, its approval was revoked, or the tenant does not publish public pages."},
+	{ErrCodeNotificationNotFound, http.StatusNotFound, false, "The notification does not exist, was dropped as one of the oldest, or is addressed to another user."},
+	{ErrCodeQuickRefNotFound, http.StatusNotFound, false, "No quick reference exists for the topic, or it is not published yet."},
+	{ErrCodeAPIKeyNotFound, http.StatusNotFound, false, "The API key does not exist or was already revoked."},
+	{ErrCodeFeedTokenNotFound, http.StatusNotFound, false, "The feed token does not exist or was already revoked."},
+	{ErrCodeJobNotFound, http.StatusNotFound, false, "The query job does not exist, expired after finishing, or belongs to another tenant."},
+	{ErrCodeReviewConflict, http.StatusConflict, false, "The review transition is not allowed from the answer's current state, an answer is not approved for sharing or is approved and cannot be edited, or a disclaimer version is no longer pending."},
+	{ErrCodePolicyViolation, http.StatusUnprocessableEntity, false, "A post-processor refused the answer under the deployment's response policy."},
+	{ErrCodeCitationUnsupported, http.StatusUnprocessableEntity, false, "An edited answer cites a provision that neither the original answer nor its sources cite."},
+	{ErrCodeConfigInvalid, http.StatusUnprocessableEntity, false, "Reloaded configuration has problems and strict mode kept the previous settings."},
+	{ErrCodeLicenseExpired, http.StatusForbidden, false, "The license of this on-premises deployment expired and its grace period is over."},
+	{ErrCodeLicenseSeatsExceeded, http.StatusForbidden, false, "Every seat of the license is held by other users; a seat frees up once its user stays idle."},
+	{ErrCodeFeatureUnlicensed, http.StatusForbidden, false, "The license of this on-premises deployment does not include the feature."},
+	{ErrCodeOCRUnavailable, http.StatusServiceUnavailable, false, "Text could not be read from an uploaded image because OCR is not installed or failed."},
+	{ErrCodeExportUnavailable, http.StatusServiceUnavailable, false, "Documents cannot be exported because no Unicode font is installed on the server."},
+	{ErrCodeEngineTimeout, http.StatusGatewayTimeout, true, "The AI engine did not answer within the configured timeout."},
+	{ErrCodeEngineUnavailable, http.StatusServiceUnavailable, true, "The AI engine could not be reached."},
+	{ErrCodeEngineError, http.StatusBadGateway, false, "The AI engine returned an error or an unreadable response."},
+	{ErrCodeEngineSchema, http.StatusBadGateway, false, "The AI engine returned search results the backend cannot read, usually because the engine and the backend are of different releases."},
+	{ErrCodeEngineWarmingUp, http.StatusServiceUnavailable, true, "The server is not ready because an AI engine is still warming up."},
+	{ErrCodeEngineBusy, http.StatusServiceUnavailable, true, "Every engine slot available to the caller stayed in use for the configured wait; retry shortly."},
+	{ErrCodeEngineCircuitOpen, http.StatusServiceUnavailable, true, "The AI engine failed repeatedly, so queries fail at once until a probe query shows it has recovered; retry after the cooldown."},
+	{ErrCodeShuttingDown, http.StatusServiceUnavailable, true, "The server shut down before the AI engine answered; retry against another instance."},
+	{ErrCodeClientClosed, statusClientClosedRequest, false, "The client closed the connection before the answer was ready, and the engine request was cancelled."},
+	{ErrCodeJobQueueFull, http.StatusServiceUnavailable, true, "Too many query jobs are waiting for a worker; retry shortly."},
+	{ErrCodeHistoryUnavailable, http.StatusServiceUnavailable, true, "The database keeping the query history could not be reached."},
+	{ErrCodeInternal, http.StatusInternalServerError, false, "An unexpected error occurred in the backend."},
+}
+
+func lookupError(code ErrorCode) ErrorDefinition {
+	for _, def := range errorCatalog {
+		if def.Code == code {
+			return def
+		}
+	}
+	return ErrorDefinition{Code: code, HTTPStatus: http.StatusInternalServerError}
+}
+
+// legacyErrors are the error strings engine failures were reported with
+// before the catalog; they stay in the error field so that clients matching
+// on it keep working
+var legacyErrors = map[ErrorCode]string{
+	ErrCodeEngineTimeout:     "ai_engine_error",
+	ErrCodeEngineUnavailable: "ai_engine_error",
+	ErrCodeEngineError:       "ai_engine_error",
+	ErrCodeEngineSchema:      "ai_engine_error",
+	ErrCodeEngineBusy:        "ai_engine_error",
+	ErrCodeEngineCircuitOpen: "ai_engine_error",
+	ErrCodeShuttingDown:      "ai_engine_error",
+}
+
+// errorName is the error field of a response: the legacy string of the
+// code, or the code in lower case
+func errorName(code ErrorCode) string {
+	if name, ok := legacyErrors[code]; ok {
+		return name
+	}
+	return strings.ToLower(string(code))
+}
+
+// newErrorResponse builds the response of a catalog error
+func newErrorResponse(code ErrorCode, message string) ErrorResponse {
+	return ErrorResponse{
+		Error:   errorName(code),
+		Code:    code,
+		Message: message,
+	}
+}
+
+// abortWithError writes a catalog error response and stops the handler chain
+func abortWithError(c *gin.Context, code ErrorCode, message string) {
+	abortWithResponse(c, newErrorResponse(code, message))
+}
+
+// abortWithViolations rejects a request body with INVALID_REQUEST, listing
+// its invalid fields
+func abortWithViolations(c *gin.Context, violations []Violation) {
+	resp := newErrorResponse(ErrCodeInvalidRequest, formatViolations(violations))
+	resp.Violations = violations
+	abortWithResponse(c, resp)
+}
+
+// abortWithInvalidParam rejects a malformed query string parameter with
+// INVALID_PARAM, naming it in the details
+func abortWithInvalidParam(c *gin.Context, param, message string) {
+	resp := newErrorResponse(ErrCodeInvalidParam, message)
+	resp.Details = map[string]any{"param": param}
+	abortWithResponse(c, resp)
+}
+
+// abortWithEngineError writes the response of a failed engine call
+func abortWithEngineError(c *gin.Context, err error, message string) {
+	abortWithResponse(c, engineErrorResponse(err, message))
+}
+
+// engineErrorResponse classifies a failed engine call, with the engine's
+// status in the details when it answered, and when to retry while its
+// circuit breaker is open
+func engineErrorResponse(err error, message string) ErrorResponse {
+	resp := newErrorResponse(classifyEngineError(err), message)
+	var statusErr *engine.EngineStatusError
+	if errors.As(err, &statusErr) {
+		resp.Details = map[string]any{"engine_status": statusErr.StatusCode}
+	}
+	var openErr *engine.CircuitOpenError
+	if errors.As(err, &openErr) {
+		resp.RetryAfter = max(ceilSeconds(openErr.RetryIn), 1)
+	}
+	return resp
+}
+
+func abortWithResponse(c *gin.Context, resp ErrorResponse) {
+	// A handler that failed reading a body past its limit failed because
+	// of the size of the body
+	if limit, ok := bodyTooLarge(c); ok && resp.Code != ErrCodePayloadTooLarge {
+		resp = bodyTooLargeResponse(c, limit)
+	}
+	def := lookupError(resp.Code)
+	c.Set(errorCodeKey, resp.Code)
+	if resp.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(resp.RetryAfter))
+	}
+	// On a chat connection the error answers the current turn
+	if session, ok := c.Get(chatSessionKey); ok {
+		session.(*chatSession).fail(resp)
+		c.Abort()
+		return
+	}
+	// In an asynchronous job the error fails the job
+	if run, ok := c.Get(queryJobKey); ok {
+		run.(*jobRun).fail(resp)
+		c.Abort()
+		return
+	}
+	// Once an event stream has started, the status is sent; the error
+	// becomes the last event
+	if c.Writer.Written() && strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+		c.SSEvent("error", resp)
+		c.Writer.Flush()
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(def.HTTPStatus, resp)
+}
+
+// classifyEngineError maps a PythonClient error onto the error catalog
+func classifyEngineError(err error) ErrorCode {
+	if errors.Is(err, errEngineBusy) {
+		return ErrCodeEngineBusy
+	}
+	if errors.Is(err, engine.ErrCircuitOpen) {
+		return ErrCodeEngineCircuitOpen
+	}
+	if errors.Is(err, engine.ErrSchemaMismatch) {
+		return ErrCodeEngineSchema
+	}
+	if errors.Is(err, errShuttingDown) {
+		return ErrCodeShuttingDown
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrCodeClientClosed
+	}
+
+	var statusErr *engine.EngineStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusServiceUnavailable:
+			return ErrCodeEngineUnavailable
+		case http.StatusGatewayTimeout:
+			return ErrCodeEngineTimeout
+		}
+		return ErrCodeEngineError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrCodeEngineTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrCodeEngineTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return ErrCodeEngineUnavailable
+	}
+
+	return ErrCodeEngineError
+}
+
+// Handlers
+
+func errorCatalogHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"errors": errorCatalog,
+	})
+}
+
+func notFoundHandler(c *gin.Context) {
+	abortWithError(c, ErrCodeNotFound, fmt.Sprintf("Route %s %s not found", c.Request.Method, c.Request.URL.Path))
+}
+
+func methodNotAllowedHandler(c *gin.Context) {
+	abortWithError(c, ErrCodeMethodNotAllowed, fmt.Sprintf("Method %s not allowed on %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// Middleware
+
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		slog.ErrorContext(c.Request.Context(), "Panic recovered", "panic", recovered)
+		abortWithError(c, ErrCodeInternal, "Internal server error")
+	})
+}
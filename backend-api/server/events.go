@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxEventsPerCall = 100
+
+	// maxEngagementSources bounds the sources listed in an engagement report
+	maxEngagementSources = 100
+)
+
+// Kinds of engagement events
+const (
+	EventCitationClicked = "citation_clicked"
+	EventSourceExpanded  = "source_expanded"
+	EventAnswerCopied    = "answer_copied"
+)
+
+// sourceEvents are the kinds of events about one source of an answer
+var sourceEvents = map[string]bool{
+	EventCitationClicked: true,
+	EventSourceExpanded:  true,
+}
+
+// EngagementEvent is an interaction of a user with an answer reported by
+// the frontend. Source is the title of the source the event is about,
+// resolved from the answer's search results rather than taken from the
+// client.
+type EngagementEvent struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	HistoryID   string    `json:"history_id"`
+	SourceIndex *int      `json:"source_index,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// EventLog keeps the most recent engagement events in memory, appending
+// them to a JSON lines file when a path is configured
+type EventLog struct {
+	mu        sync.RWMutex
+	path      string
+	maxEvents int
+	events    []*EngagementEvent
+}
+
+func NewEventLog(path string, maxEvents int) (*EventLog, error) {
+	log := &EventLog{path: path, maxEvents: maxEvents}
+	if path == "" {
+		return log, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read engagement events: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lines := 0
+	for scanner.Scan() {
+		var e EngagementEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal engagement event line %d: %w", lines+1, err)
+		}
+		log.appendLocked(&e)
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read engagement events: %w", err)
+	}
+
+	// Drop events that no longer fit so the file doesn't grow forever
+	if lines > len(log.events) {
+		if err := log.rewriteLocked(); err != nil {
+			return nil, fmt.Errorf("failed to compact engagement events: %w", err)
+		}
+	}
+	return log, nil
+}
+
+// Add records events, assigning their IDs and times
+func (l *EventLog) Add(events []EngagementEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range events {
+		e := events[i]
+		e.ID = "ev_" + randomHex(8)
+		e.At = now
+		l.appendLocked(&e)
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to marshal engagement event: %w", err)
+		}
+	}
+	if l.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open engagement events: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write engagement events: %w", err)
+	}
+	return nil
+}
+
+// Since returns the events recorded at or after t, oldest first
+func (l *EventLog) Since(t time.Time) []EngagementEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.events), func(i int) bool { return !l.events[i].At.Before(t) })
+	events := make([]EngagementEvent, 0, len(l.events)-i)
+	for _, e := range l.events[i:] {
+		events = append(events, *e)
+	}
+	return events
+}
+
+func (l *EventLog) appendLocked(e *EngagementEvent) {
+	l.events = append(l.events, e)
+	if len(l.events) > l.maxEvents {
+		l.events = l.events[1:]
+	}
+}
+
+func (l *EventLog) rewriteLocked() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range l.events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to marshal engagement event: %w", err)
+		}
+	}
+	return writeFileAtomic(l.path, buf.Bytes())
+}
+
+// SourceEngagement is how often users relied on one source: how many
+// answers cited it, and how often they clicked or expanded it
+type SourceEngagement struct {
+	Source         string `json:"source"`
+	Shown          int    `json:"shown"`
+	CitationClicks int    `json:"citation_clicks"`
+	Expansions     int    `json:"expansions"`
+	Answers        int    `json:"answers"`
+
+	// Rate is the share of the answers citing the source in which users
+	// clicked or expanded it
+	Rate float64 `json:"rate"`
+}
+
+// EngagementReport is returned by the engagement analytics endpoint
+type EngagementReport struct {
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Total  int            `json:"total"`
+	ByKind map[string]int `json:"by_kind"`
+
+	// Answers counts the answers of the period; EngagedAnswers and
+	// CopiedAnswers the answers users interacted with and copied
+	Answers        int `json:"answers"`
+	EngagedAnswers int `json:"engaged_answers"`
+	CopiedAnswers  int `json:"copied_answers"`
+
+	// ByPosition counts clicks and expansions by the rank of the source in
+	// the search results, first result first
+	ByPosition []int              `json:"by_position"`
+	Sources    []SourceEngagement `json:"sources"`
+}
+
+// buildEngagementReport aggregates events with the answers of the same
+// period, which tell how often each source was shown, listing the sources
+// users relied on most
+func buildEngagementReport(events []EngagementEvent, entries []HistoryEntry, from, to time.Time) EngagementReport {
+	report := aggregateEngagement(events, entries, from, to)
+	if len(report.Sources) > maxEngagementSources {
+		report.Sources = report.Sources[:maxEngagementSources]
+	}
+	return report
+}
+
+// aggregateEngagement builds an engagement report listing every source
+func aggregateEngagement(events []EngagementEvent, entries []HistoryEntry, from, to time.Time) EngagementReport {
+	report := EngagementReport{
+		From:       from,
+		To:         to,
+		ByKind:     map[string]int{EventCitationClicked: 0, EventSourceExpanded: 0, EventAnswerCopied: 0},
+		ByPosition: []int{},
+		Sources:    []SourceEngagement{},
+	}
+	bySource := make(map[string]*SourceEngagement)
+	source := func(title string) *SourceEngagement {
+		s, ok := bySource[title]
+		if !ok {
+			s = &SourceEngagement{Source: title}
+			bySource[title] = s
+		}
+		return s
+	}
+
+	for _, e := range entries {
+		if e.CreatedAt.Before(from) || e.CreatedAt.After(to) {
+			continue
+		}
+		report.Answers++
+		seen := make(map[string]bool)
+		for _, r := range e.Response.SearchResults {
+			if title := sourceFromResult(r).Title; title != "" && !seen[title] {
+				seen[title] = true
+				source(title).Shown++
+			}
+		}
+	}
+
+	engaged := make(map[string]bool)
+	copied := make(map[string]bool)
+	relied := make(map[string]map[string]bool)
+	for _, e := range events {
+		if e.At.Before(from) || e.At.After(to) {
+			continue
+		}
+		report.Total++
+		report.ByKind[e.Kind]++
+		engaged[e.HistoryID] = true
+		if e.Kind == EventAnswerCopied {
+			copied[e.HistoryID] = true
+			continue
+		}
+		if e.SourceIndex != nil {
+			for len(report.ByPosition) <= *e.SourceIndex {
+				report.ByPosition = append(report.ByPosition, 0)
+			}
+			report.ByPosition[*e.SourceIndex]++
+		}
+		if e.Source == "" {
+			continue
+		}
+		s := source(e.Source)
+		if e.Kind == EventCitationClicked {
+			s.CitationClicks++
+		} else {
+			s.Expansions++
+		}
+		if relied[e.Source] == nil {
+			relied[e.Source] = make(map[string]bool)
+		}
+		relied[e.Source][e.HistoryID] = true
+	}
+	report.EngagedAnswers = len(engaged)
+	report.CopiedAnswers = len(copied)
+
+	for title, s := range bySource {
+		s.Answers = len(relied[title])
+		if s.Shown > 0 {
+			s.Rate = min(float64(s.Answers)/float64(s.Shown), 1)
+		}
+		report.Sources = append(report.Sources, *s)
+	}
+	sort.Slice(report.Sources, func(i, j int) bool {
+		a, b := report.Sources[i], report.Sources[j]
+		if a.Answers != b.Answers {
+			return a.Answers > b.Answers
+		}
+		if a.CitationClicks+a.Expansions != b.CitationClicks+b.Expansions {
+			return a.CitationClicks+a.Expansions > b.CitationClicks+b.Expansions
+		}
+		if a.Shown != b.Shown {
+			return a.Shown > b.Shown
+		}
+		return a.Source < b.Source
+	})
+	return report
+}
+
+// Handlers
+
+// EngagementEventInput is an event reported to POST /api/events.
+// SourceIndex is the position of the source in the answer's search results,
+// required for citation clicks and source expansions.
+type EngagementEventInput struct {
+	Kind        string `json:"kind"`
+	HistoryID   string `json:"history_id"`
+	SourceIndex *int   `json:"source_index"`
+}
+
+// ReportEventsRequest is the body of POST /api/events
+type ReportEventsRequest struct {
+	Events []EngagementEventInput `json:"events"`
+}
+
+// reportEventsHandler records interaction events of the caller with the
+// answers of their tenant. The batch is rejected as a whole when one event
+// is invalid, so clients can fix and resend it.
+func reportEventsHandler(events *EventLog, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReportEventsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if len(req.Events) == 0 || len(req.Events) > maxEventsPerCall {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("events must hold between 1 and %d events", maxEventsPerCall))
+			return
+		}
+
+		tenant, _ := callerTenant(c)
+		user := callerUser(c)
+		entries := make(map[string]HistoryEntry)
+		recorded := make([]EngagementEvent, 0, len(req.Events))
+		for i, in := range req.Events {
+			if in.Kind != EventAnswerCopied && !sourceEvents[in.Kind] {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("events[%d]: kind must be %s, %s or %s", i, EventCitationClicked, EventSourceExpanded, EventAnswerCopied))
+				return
+			}
+			entry, ok := entries[in.HistoryID]
+			if !ok {
+				var err error
+				if entry, err = history.Get(in.HistoryID, tenant.ID); err != nil {
+					abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("events[%d]: history entry %q not found", i, in.HistoryID))
+					return
+				}
+				entries[in.HistoryID] = entry
+			}
+
+			event := EngagementEvent{Kind: in.Kind, TenantID: tenant.ID, UserID: user, HistoryID: entry.ID}
+			if sourceEvents[in.Kind] {
+				results := entry.Response.SearchResults
+				if in.SourceIndex == nil || *in.SourceIndex < 0 || *in.SourceIndex >= len(results) {
+					abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("events[%d]: source_index must be between 0 and %d", i, len(results)-1))
+					return
+				}
+				index := *in.SourceIndex
+				event.SourceIndex = &index
+				event.Source = sourceFromResult(results[index]).Title
+			}
+			recorded = append(recorded, event)
+		}
+
+		if err := events.Add(recorded); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save engagement events", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save the events")
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"accepted": len(recorded)})
+	}
+}
+
+func engagementAnalyticsHandler(events *EventLog, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days := 28
+		if value := c.Query("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 365 {
+				abortWithInvalidParam(c, "days", "days must be an integer between 1 and 365")
+				return
+			}
+			days = parsed
+		}
+
+		to := time.Now().UTC()
+		from := to.AddDate(0, 0, -days)
+		recorded := events.Since(from)
+		entries := history.Since(from)
+		if tenantID, ok := c.GetQuery("tenant"); ok {
+			filteredEvents := recorded[:0]
+			for _, e := range recorded {
+				if e.TenantID == tenantID {
+					filteredEvents = append(filteredEvents, e)
+				}
+			}
+			recorded = filteredEvents
+			filtered := entries[:0]
+			for _, e := range entries {
+				if e.TenantID == tenantID {
+					filtered = append(filtered, e)
+				}
+			}
+			entries = filtered
+		}
+
+		c.JSON(http.StatusOK, buildEngagementReport(recorded, entries, from, to))
+	}
+}
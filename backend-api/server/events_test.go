@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestEngagementEvents(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{
+			{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"},
+			{Excerpt: "Điều 24. Thỏa thuận thử việc", Title: "Thỏa thuận thử việc"},
+		},
+		Iterations: 1,
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "secret")
+		req.Header.Set("X-User-ID", "lan")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	var first, second engine.LegalQueryResponse
+	json.Unmarshal(do(http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}).Body.Bytes(), &first)
+	json.Unmarshal(do(http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thử việc có cần hợp đồng không?"}).Body.Bytes(), &second)
+
+	index := func(i int) *int { return &i }
+	rec := do(http.MethodPost, "/api/events", ReportEventsRequest{Events: []EngagementEventInput{
+		{Kind: EventCitationClicked, HistoryID: first.HistoryID, SourceIndex: index(0)},
+		{Kind: EventSourceExpanded, HistoryID: first.HistoryID, SourceIndex: index(0)},
+		{Kind: EventAnswerCopied, HistoryID: first.HistoryID},
+		{Kind: EventCitationClicked, HistoryID: second.HistoryID, SourceIndex: index(1)},
+	}})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("report events = %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Invalid events reject the whole batch
+	tests := []struct {
+		name  string
+		event EngagementEventInput
+		want  ErrorCode
+	}{
+		{"unknown kind", EngagementEventInput{Kind: "scrolled", HistoryID: first.HistoryID}, ErrCodeInvalidRequest},
+		{"unknown answer", EngagementEventInput{Kind: EventAnswerCopied, HistoryID: "q_missing"}, ErrCodeHistoryNotFound},
+		{"missing source", EngagementEventInput{Kind: EventCitationClicked, HistoryID: first.HistoryID}, ErrCodeInvalidRequest},
+		{"source out of range", EngagementEventInput{Kind: EventSourceExpanded, HistoryID: first.HistoryID, SourceIndex: index(2)}, ErrCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		events := []EngagementEventInput{{Kind: EventAnswerCopied, HistoryID: second.HistoryID}, tt.event}
+		if code := decodeError(t, do(http.MethodPost, "/api/events", ReportEventsRequest{Events: events})).Code; code != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, code, tt.want)
+		}
+	}
+
+	rec = do(http.MethodGet, "/admin/analytics/engagement?days=7", nil)
+	var report EngagementReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("engagement report = %d %s", rec.Code, rec.Body.String())
+	}
+	if report.Total != 4 || report.Answers != 2 || report.EngagedAnswers != 2 || report.CopiedAnswers != 1 {
+		t.Errorf("report = %+v, want the 4 accepted events over 2 answers", report)
+	}
+	if len(report.ByPosition) != 2 || report.ByPosition[0] != 2 || report.ByPosition[1] != 1 {
+		t.Errorf("by_position = %v, want [2 1]", report.ByPosition)
+	}
+	want := []SourceEngagement{
+		{Source: "Thời gian thử việc", Shown: 2, CitationClicks: 1, Expansions: 1, Answers: 1, Rate: 0.5},
+		{Source: "Thỏa thuận thử việc", Shown: 2, CitationClicks: 1, Answers: 1, Rate: 0.5},
+	}
+	if len(report.Sources) != len(want) {
+		t.Fatalf("sources = %+v, want %+v", report.Sources, want)
+	}
+	for i := range want {
+		if report.Sources[i] != want[i] {
+			t.Errorf("source %d = %+v, want %+v", i, report.Sources[i], want[i])
+		}
+	}
+	if resp := decodeError(t, do(http.MethodGet, "/admin/analytics/engagement?days=0", nil)); resp.Code != ErrCodeInvalidParam || resp.Details["param"] != "days" {
+		t.Errorf("days=0 = %s %v, want %s naming days", resp.Code, resp.Details, ErrCodeInvalidParam)
+	}
+}
+
+func TestEventLogPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := NewEventLog(path, 2)
+	if err != nil {
+		t.Fatalf("NewEventLog: %v", err)
+	}
+	for _, kind := range []string{EventAnswerCopied, EventCitationClicked, EventSourceExpanded} {
+		if err := log.Add([]EngagementEvent{{Kind: kind, HistoryID: "q_1"}}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// Reloading keeps the newest events and compacts the file
+	log, err = NewEventLog(path, 2)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	events := log.Since(time.Time{})
+	if len(events) != 2 || events[0].Kind != EventCitationClicked || events[1].Kind != EventSourceExpanded {
+		t.Errorf("events = %+v, want the 2 newest", events)
+	}
+}
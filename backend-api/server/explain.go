@@ -0,0 +1,177 @@
+package server
+
+import (
+	"cmp"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// BM25 parameters of the keyword score
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// ExplainConfig sets the default weights of the rerank score given to
+// explained search results
+type ExplainConfig struct {
+	KeywordWeight float64
+	RecencyWeight float64
+
+	// HalfLifeYears is the document age at which the recency boost halves
+	HalfLifeYears int
+}
+
+// ExplainOptions asks for the explanation of the search results' scores.
+// The weights override those of the server for this query.
+type ExplainOptions struct {
+	KeywordWeight *float64 `json:"keyword_weight,omitempty"`
+	RecencyWeight *float64 `json:"recency_weight,omitempty"`
+}
+
+// weights returns the server's weights overridden by the options
+func (o *ExplainOptions) weights(config ExplainConfig) ExplainConfig {
+	if o.KeywordWeight != nil {
+		config.KeywordWeight = *o.KeywordWeight
+	}
+	if o.RecencyWeight != nil {
+		config.RecencyWeight = *o.RecencyWeight
+	}
+	return config
+}
+
+// documentYearPattern finds the year of a document in its number, title or
+// ID, e.g. 45/2019/QH14 or BoLuatLaoDong2019
+var documentYearPattern = regexp.MustCompile(`(?:^|\D)(19[4-9]\d|20\d\d)(?:\D|$)`)
+
+// explainResults breaks down the score of every search result: the engine's
+// vector score, a BM25 keyword score of the question's terms normalized to
+// the best result, and a recency boost halving every HalfLifeYears of the
+// document's age. The rerank score combines them, and rank is the position
+// of the result when ordered by it; the results themselves keep the
+// engine's order.
+func explainResults(question string, results []engine.DocumentHit, config ExplainConfig, links *LinkResolver, now time.Time) []engine.ResultExplanation {
+	if len(results) == 0 {
+		return nil
+	}
+	terms := make([]string, 0)
+	for term := range contentTokens(question) {
+		terms = append(terms, term)
+	}
+	slices.Sort(terms)
+
+	counts := make([]map[string]int, len(results))
+	lengths := make([]int, len(results))
+	documentFrequency := make(map[string]int)
+	total := 0
+	for i, result := range results {
+		counts[i], lengths[i] = termCounts(result.Excerpt)
+		total += lengths[i]
+		for _, term := range terms {
+			if counts[i][term] > 0 {
+				documentFrequency[term]++
+			}
+		}
+	}
+	averageLength := max(float64(total)/float64(len(results)), 1)
+
+	explanations := make([]engine.ResultExplanation, len(results))
+	bestKeyword := 0.0
+	for i, result := range results {
+		e := engine.ResultExplanation{ResultIndex: i, MatchedTerms: []string{}}
+		e.VectorScore = result.Score
+		for _, term := range terms {
+			tf := float64(counts[i][term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(documentFrequency[term])
+			idf := math.Log(1 + (float64(len(results))-df+0.5)/(df+0.5))
+			e.KeywordScore += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/averageLength))
+			e.MatchedTerms = append(e.MatchedTerms, term)
+		}
+		bestKeyword = max(bestKeyword, e.KeywordScore)
+
+		e.DocumentYear = documentYear(result, links.resultDocument(result))
+		if e.DocumentYear > 0 {
+			age := max(now.Year()-e.DocumentYear, 0)
+			e.RecencyBoost = config.RecencyWeight * math.Pow(0.5, float64(age)/float64(config.HalfLifeYears))
+		}
+		explanations[i] = e
+	}
+
+	for i := range explanations {
+		e := &explanations[i]
+		if bestKeyword > 0 {
+			e.KeywordScore /= bestKeyword
+		}
+		e.RerankScore = (1-config.KeywordWeight)*e.VectorScore + config.KeywordWeight*e.KeywordScore + e.RecencyBoost
+		e.KeywordScore = roundScore(e.KeywordScore)
+		e.RecencyBoost = roundScore(e.RecencyBoost)
+		e.RerankScore = roundScore(e.RerankScore)
+	}
+
+	order := make([]int, len(explanations))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(explanations[b].RerankScore, explanations[a].RerankScore)
+	})
+	for rank, i := range order {
+		explanations[i].Rank = rank + 1
+	}
+	return explanations
+}
+
+// termCounts counts the content words of a text and returns them with the
+// number of words counted
+func termCounts(text string) (map[string]int, int) {
+	counts := make(map[string]int)
+	n := 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !groundingStopwords[word] {
+			counts[word]++
+			n++
+		}
+	}
+	return counts, n
+}
+
+// documentYear is the year of a search result's document: the year the
+// engine gives, or the year in its document's title or ID or of its catalog
+// document. It is 0 when unknown.
+func documentYear(result engine.DocumentHit, doc *LawDocument) int {
+	if result.Year > 0 {
+		return result.Year
+	}
+	candidates := []string{}
+	for _, value := range []string{result.DocumentTitle, result.DocumentID} {
+		if value != "" {
+			candidates = append(candidates, value)
+		}
+	}
+	if doc != nil {
+		candidates = append(candidates, doc.Number, doc.Title)
+	}
+	for _, candidate := range candidates {
+		if m := documentYearPattern.FindStringSubmatch(candidate); m != nil {
+			year, _ := strconv.Atoi(m[1])
+			return year
+		}
+	}
+	return 0
+}
+
+func roundScore(score float64) float64 {
+	return math.Round(score*10000) / 10000
+}
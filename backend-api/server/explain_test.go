@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestExplainResults(t *testing.T) {
+	links, err := NewLinkResolver(LawLinkConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []engine.DocumentHit{
+		{Excerpt: "Điều 25. Thời gian thử việc không quá 180 ngày đối với người quản lý doanh nghiệp.", Score: 0.80, ArticleNumber: "Dieu_25"},
+		{Excerpt: "Điều 24. Thử việc. Người sử dụng lao động và người lao động có thể thỏa thuận nội dung thử việc.", Score: 0.82, ArticleNumber: "Dieu_24"},
+		{Excerpt: "Nghị định quy định chi tiết về tiền lương.", Score: 0.81, DocumentTitle: "Nghị định 145/2020/NĐ-CP", Year: 2020},
+	}
+	config := ExplainConfig{KeywordWeight: 0.5, RecencyWeight: 0.1, HalfLifeYears: 10}
+	now := time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	explanations := explainResults("Thời gian thử việc tối đa là bao lâu?", results, config, links, now)
+	if len(explanations) != 3 {
+		t.Fatalf("explanations = %d, want 3", len(explanations))
+	}
+	first, second, third := explanations[0], explanations[1], explanations[2]
+	if first.KeywordScore != 1 || !slices.Equal(first.MatchedTerms, []string{"gian", "thời", "thử", "việc"}) {
+		t.Errorf("first = %+v, want the best keyword score with all the question's terms", first)
+	}
+	if second.KeywordScore <= 0 || second.KeywordScore >= 1 || third.KeywordScore != 0 || len(third.MatchedTerms) != 0 {
+		t.Errorf("keyword scores = %v, %v, want a partial match and none", second.KeywordScore, third.KeywordScore)
+	}
+	// The corpus document is from 2019, ten years old: half the weight
+	if first.DocumentYear != 2019 || first.RecencyBoost != 0.05 || third.DocumentYear != 2020 {
+		t.Errorf("years = %d (boost %v), %d, want 2019 (0.05) and 2020", first.DocumentYear, first.RecencyBoost, third.DocumentYear)
+	}
+	if want := roundScore(0.5*0.80 + 0.5*1 + 0.05); first.RerankScore != want || first.Rank != 1 || third.Rank != 3 {
+		t.Errorf("first = %+v, third = %+v, want the keyword match ranked first", first, third)
+	}
+
+	config.KeywordWeight = 0
+	if e := explainResults("Thời gian thử việc tối đa là bao lâu?", results, config, links, now); e[1].Rank != 1 {
+		t.Errorf("ranks without keywords = %d %d %d, want the best vector score first", e[0].Rank, e[1].Rank, e[2].Rank)
+	}
+}
+
+func TestLegalQueryExplain(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc tối đa là 180 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Score: 0.8}},
+		Iterations:    1,
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	question := "Thời gian thử việc tối đa là bao lâu?"
+
+	var resp engine.LegalQueryResponse
+	json.Unmarshal(doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question}).Body.Bytes(), &resp)
+	if resp.Explanations != nil {
+		t.Errorf("explanations without explain = %+v", resp.Explanations)
+	}
+	weight := 1.0
+	json.Unmarshal(doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question, Explain: &ExplainOptions{KeywordWeight: &weight}}).Body.Bytes(), &resp)
+	if len(resp.Explanations) != 1 || resp.Explanations[0].VectorScore != 0.8 || resp.Explanations[0].RerankScore < 1 {
+		t.Errorf("explanations = %+v, want the keyword score to rank the result", resp.Explanations)
+	}
+
+	weight = 2
+	if code := decodeError(t, doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question, Explain: &ExplainOptions{RecencyWeight: &weight}})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("recency weight of 2 = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FaultConfig controls which faults are injected into engine calls. Rates are
+// probabilities in [0, 1] evaluated independently for every engine request.
+type FaultConfig struct {
+	Enabled       bool    `json:"enabled"`
+	LatencyRate   float64 `json:"latency_rate"`
+	LatencyMs     int     `json:"latency_ms"`
+	DropRate      float64 `json:"drop_rate"`
+	MalformedRate float64 `json:"malformed_rate"`
+	ErrorRate     float64 `json:"error_rate"`
+}
+
+func (f FaultConfig) validate() error {
+	for name, rate := range map[string]float64{
+		"latency_rate":   f.LatencyRate,
+		"drop_rate":      f.DropRate,
+		"malformed_rate": f.MalformedRate,
+		"error_rate":     f.ErrorRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if f.LatencyMs < 0 {
+		return errors.New("latency_ms must not be negative")
+	}
+	return nil
+}
+
+// faultTransport injects artificial latency, dropped connections, engine
+// errors and malformed responses into engine traffic, for validating the
+// resilience of the backend against a misbehaving engine
+type faultTransport struct {
+	next http.RoundTripper
+
+	mu     sync.RWMutex
+	config FaultConfig
+}
+
+func newFaultTransport(next http.RoundTripper) *faultTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{next: next}
+}
+
+func (t *faultTransport) Config() FaultConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config
+}
+
+func (t *faultTransport) SetConfig(config FaultConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config = config
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := t.Config()
+	if !config.Enabled {
+		return t.next.RoundTrip(req)
+	}
+
+	if hit(config.LatencyRate) && config.LatencyMs > 0 {
+		select {
+		case <-time.After(time.Duration(config.LatencyMs) * time.Millisecond):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if hit(config.DropRate) {
+		slog.WarnContext(req.Context(), "Fault injection: dropping connection", "path", req.URL.Path)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer (injected fault)")}
+	}
+
+	if hit(config.ErrorRate) {
+		slog.WarnContext(req.Context(), "Fault injection: returning 503", "path", req.URL.Path)
+		return injectedResponse(req, http.StatusServiceUnavailable, `{"detail":"injected fault"}`), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !hit(config.MalformedRate) {
+		return resp, err
+	}
+
+	// Truncate the real body so the response is syntactically invalid JSON
+	slog.WarnContext(req.Context(), "Fault injection: malforming response", "path", req.URL.Path)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return injectedResponse(req, resp.StatusCode, string(body[:len(body)/2])+`{"malformed`), nil
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+func injectedResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Handlers
+
+func getFaultsHandler(faults *faultTransport) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if faults == nil {
+			abortWithError(c, ErrCodeForbidden, "Fault injection is disabled; set ENABLE_FAULT_INJECTION=true to enable it")
+			return
+		}
+		c.JSON(http.StatusOK, faults.Config())
+	}
+}
+
+func putFaultsHandler(faults *faultTransport) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if faults == nil {
+			abortWithError(c, ErrCodeForbidden, "Fault injection is disabled; set ENABLE_FAULT_INJECTION=true to enable it")
+			return
+		}
+
+		var config FaultConfig
+		if err := c.ShouldBindJSON(&config); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if err := config.validate(); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+
+		faults.SetConfig(config)
+		slog.InfoContext(c.Request.Context(), "Fault injection updated", "config", config)
+		c.JSON(http.StatusOK, config)
+	}
+}
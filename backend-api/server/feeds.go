@@ -0,0 +1,460 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feed formats
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+)
+
+const (
+	// feedTokenSecretPrefix starts every feed token, so leaked tokens are
+	// easy to find
+	feedTokenSecretPrefix = "lrf_"
+
+	maxFeedItems = 50
+
+	// feedMaxAge is how long feed readers may cache a feed
+	feedMaxAge = 5 * time.Minute
+)
+
+// FeedToken lets a feed reader, which cannot send headers, fetch the feeds
+// of a tenant. Like API keys, the token is shown once when it is created
+// and only its hash is stored; it grants nothing but the feeds.
+type FeedToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storedFeedToken is a feed token as persisted, with the hash of its secret
+type storedFeedToken struct {
+	FeedToken
+	Hash string `json:"hash"`
+}
+
+var (
+	errFeedTokenNotFound = errors.New("feed token not found")
+	errFeedTokenInvalid  = errors.New("invalid feed token")
+)
+
+// FeedTokenStore keeps feed tokens in memory, persisted to a JSON file when
+// a path is configured
+type FeedTokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens map[string]*storedFeedToken
+	byHash map[string]*storedFeedToken
+}
+
+func NewFeedTokenStore(path string) (*FeedTokenStore, error) {
+	store := &FeedTokenStore{
+		path:   path,
+		tokens: make(map[string]*storedFeedToken),
+		byHash: make(map[string]*storedFeedToken),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var tokens []*storedFeedToken
+	if _, err := readJSONFile(path, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to load feed tokens: %w", err)
+	}
+	for _, t := range tokens {
+		store.tokens[t.ID] = t
+		store.byHash[t.Hash] = t
+	}
+	return store, nil
+}
+
+// Create stores a new token and returns it with its secret
+func (s *FeedTokenStore) Create(token FeedToken) (FeedToken, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := feedTokenSecretPrefix + randomHex(24)
+	token.ID = "ft_" + randomHex(8)
+	token.Prefix = secret[:len(feedTokenSecretPrefix)+6]
+	token.CreatedAt = time.Now().UTC()
+	stored := &storedFeedToken{FeedToken: token, Hash: hashAPIKey(secret)}
+	s.tokens[token.ID] = stored
+	s.byHash[stored.Hash] = stored
+	return token, secret, s.saveLocked()
+}
+
+func (s *FeedTokenStore) List() []FeedToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]FeedToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t.FeedToken)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Revoke deletes a token; feed readers using it fail from then on
+func (s *FeedTokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return errFeedTokenNotFound
+	}
+	delete(s.tokens, id)
+	delete(s.byHash, t.Hash)
+	return s.saveLocked()
+}
+
+// Authenticate returns the token with the given secret
+func (s *FeedTokenStore) Authenticate(secret string) (FeedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.byHash[hashAPIKey(secret)]; ok {
+		return t.FeedToken, nil
+	}
+	return FeedToken{}, errFeedTokenInvalid
+}
+
+func (s *FeedTokenStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	tokens := make([]*storedFeedToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return writeJSONFile(s.path, tokens)
+}
+
+// feed is a feed before it is rendered as RSS or Atom. Items are newest
+// first.
+type feed struct {
+	ID          string
+	Title       string
+	Description string
+	Items       []feedItem
+}
+
+type feedItem struct {
+	ID      string
+	Title   string
+	Summary string
+	Link    string
+	Updated time.Time
+}
+
+// updated is the time of the newest item, zero for an empty feed
+func (f feed) updated() time.Time {
+	if len(f.Items) == 0 {
+		return time.Time{}
+	}
+	return f.Items[0].Updated
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Summary string     `xml:"summary,omitempty"`
+	Links   []atomLink `xml:"link"`
+}
+
+// render encodes the feed in format; self is the URL of the feed, without
+// its token
+func (f feed) render(format, self string) ([]byte, error) {
+	var doc any
+	switch format {
+	case FeedAtom:
+		updated := f.updated()
+		if updated.IsZero() {
+			updated = time.Unix(0, 0).UTC()
+		}
+		atom := atomFeed{
+			ID:       f.ID,
+			Title:    f.Title,
+			Subtitle: f.Description,
+			Updated:  updated.UTC().Format(time.RFC3339),
+			Author:   atomAuthor{Name: "Legal RAG"},
+			Links:    []atomLink{{Rel: "self", Href: self}},
+		}
+		for _, item := range f.Items {
+			entry := atomEntry{ID: item.ID, Title: item.Title, Updated: item.Updated.UTC().Format(time.RFC3339), Summary: item.Summary}
+			if item.Link != "" {
+				entry.Links = []atomLink{{Href: item.Link}}
+			}
+			atom.Entries = append(atom.Entries, entry)
+		}
+		doc = atom
+	default:
+		channel := rssChannel{Title: f.Title, Link: self, Description: f.Description}
+		if updated := f.updated(); !updated.IsZero() {
+			channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+		}
+		for _, item := range f.Items {
+			channel.Items = append(channel.Items, rssItem{
+				Title:       item.Title,
+				Link:        item.Link,
+				Description: item.Summary,
+				GUID:        rssGUID{IsPermaLink: "false", Value: item.ID},
+				PubDate:     item.Updated.UTC().Format(time.RFC1123Z),
+			})
+		}
+		doc = rssDocument{Version: "2.0", Channel: channel}
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// corpusChangeTitles name the kinds of corpus changes in feed items
+var corpusChangeTitles = map[string]string{
+	CorpusChangeNew:      "Văn bản mới",
+	CorpusChangeAmended:  "Sửa đổi",
+	CorpusChangeRepealed: "Bãi bỏ",
+}
+
+// corpusFeed lists the latest changes of the corpus, the same for every
+// tenant
+func corpusFeed(log *CorpusChangeLog) func(token FeedToken, base string) feed {
+	return func(token FeedToken, base string) feed {
+		f := feed{
+			ID:          "urn:legal-rag:feed:corpus",
+			Title:       "Cập nhật văn bản pháp luật",
+			Description: "Văn bản mới ban hành, được sửa đổi và bị bãi bỏ trong kho văn bản",
+		}
+		for _, change := range log.Latest(maxFeedItems) {
+			title := fmt.Sprintf("%s: %s", corpusChangeTitles[change.Kind], change.Title)
+			if len(change.Articles) > 0 {
+				title += " (" + strings.Join(change.Articles, ", ") + ")"
+			}
+			summary := change.Summary
+			if change.EffectiveDate != "" {
+				summary = strings.TrimSpace(fmt.Sprintf("%s Hiệu lực từ %s.", summary, change.EffectiveDate))
+			}
+			f.Items = append(f.Items, feedItem{
+				ID:      "urn:legal-rag:corpus-change:" + change.ID,
+				Title:   title,
+				Summary: summary,
+				Link:    fmt.Sprintf("%s/api/digest?since=%s", base, change.ReportedAt.Format(time.DateOnly)),
+				Updated: change.ReportedAt,
+			})
+		}
+		return f
+	}
+}
+
+// quickRefFeed lists the quick references published for the tenant of the
+// token, its own or the default ones. Every published version is a new item.
+func quickRefFeed(store *QuickRefStore) func(token FeedToken, base string) feed {
+	return func(token FeedToken, base string) feed {
+		tenant := token.TenantID
+		if tenant == "" {
+			tenant = "default"
+		}
+		f := feed{
+			ID:          "urn:legal-rag:feed:quickref:" + tenant,
+			Title:       "Tra cứu nhanh",
+			Description: "Các bảng tra cứu nhanh mới công bố hoặc cập nhật",
+		}
+		refs := store.List(token.TenantID, true, true)
+		sort.SliceStable(refs, func(i, j int) bool { return refs[i].UpdatedAt.After(refs[j].UpdatedAt) })
+		if len(refs) > maxFeedItems {
+			refs = refs[:maxFeedItems]
+		}
+		for _, q := range refs {
+			owner := q.TenantID
+			if owner == "" {
+				owner = "default"
+			}
+			f.Items = append(f.Items, feedItem{
+				ID:      fmt.Sprintf("urn:legal-rag:quickref:%s:%s:%d", owner, q.Topic, q.Version),
+				Title:   q.Title,
+				Summary: q.Summary,
+				Link:    base + "/api/quickref/" + q.Topic,
+				Updated: q.UpdatedAt,
+			})
+		}
+		return f
+	}
+}
+
+// requestBaseURL is the scheme and host the client reached the server at
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// Handlers
+
+// feedHandler serves a feed in format to readers holding a feed token,
+// which they pass in the token query parameter. Feeds are public routes of
+// the API key middleware; the token is their only credential.
+func feedHandler(tokens *FeedTokenStore, format string, build func(token FeedToken, base string) feed) gin.HandlerFunc {
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == FeedAtom {
+		contentType = "application/atom+xml; charset=utf-8"
+	}
+	return func(c *gin.Context) {
+		secret := c.Query("token")
+		if secret == "" {
+			abortWithError(c, ErrCodeUnauthorized, "Missing feed token; pass it in the token query parameter")
+			return
+		}
+		token, err := tokens.Authenticate(secret)
+		if err != nil {
+			abortWithError(c, ErrCodeUnauthorized, "Invalid feed token")
+			return
+		}
+
+		base := requestBaseURL(c)
+		f := build(token, base)
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(feedMaxAge.Seconds())))
+		if updated := f.updated(); !updated.IsZero() {
+			c.Header("Last-Modified", updated.UTC().Format(http.TimeFormat))
+			if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !updated.Truncate(time.Second).After(since) {
+				c.Status(http.StatusNotModified)
+				return
+			}
+		}
+		body, err := f.render(format, base+c.Request.URL.Path)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to render feed", "feed", f.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to render the feed")
+			return
+		}
+		c.Data(http.StatusOK, contentType, body)
+	}
+}
+
+// CreateFeedTokenRequest is the body of POST /admin/feed-tokens
+type CreateFeedTokenRequest struct {
+	Name     string `json:"name" binding:"required"`
+	TenantID string `json:"tenant_id"`
+}
+
+// CreateFeedTokenResponse returns the new token's secret, which is never
+// shown again
+type CreateFeedTokenResponse struct {
+	FeedToken
+	Token string `json:"token"`
+}
+
+func listFeedTokensHandler(store *FeedTokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"feed_tokens": store.List()})
+	}
+}
+
+func createFeedTokenHandler(store *FeedTokenStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateFeedTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if req.TenantID != "" {
+			if _, ok := tenants.Get(req.TenantID); !ok {
+				abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", req.TenantID))
+				return
+			}
+		}
+
+		token, secret, err := store.Create(FeedToken{Name: strings.TrimSpace(req.Name), TenantID: req.TenantID})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save feed token", "feed_token", token.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save feed token")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Created feed token", "feed_token", token.ID, "name", token.Name)
+		c.JSON(http.StatusCreated, CreateFeedTokenResponse{FeedToken: token, Token: secret})
+	}
+}
+
+func revokeFeedTokenHandler(store *FeedTokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.Revoke(c.Param("id"))
+		if errors.Is(err, errFeedTokenNotFound) {
+			abortWithError(c, ErrCodeFeedTokenNotFound, fmt.Sprintf("Unknown feed token %q", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to revoke feed token", "feed_token", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to revoke feed token")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Revoked feed token", "feed_token", c.Param("id"))
+		c.Status(http.StatusNoContent)
+	}
+}
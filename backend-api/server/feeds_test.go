@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestFeeds(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	t.Setenv("REQUIRE_API_KEY", "true")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Mức lương tối thiểu vùng I tăng lên 4.960.000 đồng/tháng."}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	do := func(method, path string, body any, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "admin-token")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/tenants", map[string]string{"id": "acme", "name": "ACME"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPost, "/admin/feed-tokens", CreateFeedTokenRequest{Name: "ACME intranet", TenantID: "acme"})
+	var created CreateFeedTokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated || !strings.HasPrefix(created.Token, feedTokenSecretPrefix) {
+		t.Fatalf("create feed token = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/admin/corpus/changes", ReportCorpusChangesRequest{Changes: []CorpusChangeInput{
+		{Kind: CorpusChangeNew, Document: "nd-74-2024", Title: "Nghị định 74/2024/NĐ-CP", EffectiveDate: "2024-07-01"},
+	}}); rec.Code != http.StatusAccepted {
+		t.Fatalf("report changes = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/admin/quickref/hop-dong-lao-dong?tenant=acme", QuickRef{Title: "Hợp đồng lao động", Summary: "Thử việc tối đa 180 ngày.", Status: QuickRefPublished}, "X-API-Key", userKey(t, h, "minh")); rec.Code != http.StatusOK {
+		t.Fatalf("publish quick reference = %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Feed readers authenticate with the token alone, without an API key
+	rec = do(http.MethodGet, "/api/feeds/corpus.rss?token="+created.Token, nil)
+	var rss rssDocument
+	if err := xml.Unmarshal(rec.Body.Bytes(), &rss); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("corpus RSS = %d %s", rec.Code, rec.Body.String())
+	}
+	if items := rss.Channel.Items; len(items) != 1 || items[0].Title != "Văn bản mới: Nghị định 74/2024/NĐ-CP" || strings.Contains(rss.Channel.Link, "token") {
+		t.Errorf("corpus RSS = %+v, want the new decree and a link without the token", rss.Channel)
+	}
+	lastModified := rec.Header().Get("Last-Modified")
+	if rec := do(http.MethodGet, "/api/feeds/corpus.rss?token="+created.Token, nil, "If-Modified-Since", lastModified); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged corpus RSS = %d, want 304", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/api/feeds/quickref.atom?token="+created.Token, nil)
+	var atom atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &atom); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("quick reference Atom = %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/atom+xml") {
+		t.Errorf("Content-Type = %q, want Atom", got)
+	}
+	if len(atom.Entries) != 1 || atom.Entries[0].Title != "Hợp đồng lao động" || atom.Entries[0].ID != "urn:legal-rag:quickref:acme:hop-dong-lao-dong:1" {
+		t.Errorf("quick reference Atom = %+v, want ACME's published reference", atom.Entries)
+	}
+
+	if code := decodeError(t, do(http.MethodGet, "/api/feeds/corpus.atom", nil)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("feed without a token = %s, want %s", code, ErrCodeUnauthorized)
+	}
+	if rec := do(http.MethodDelete, "/admin/feed-tokens/"+created.ID, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke feed token = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodGet, "/api/feeds/corpus.atom?token="+created.Token, nil)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("feed with a revoked token = %s, want %s", code, ErrCodeUnauthorized)
+	}
+	if code := decodeError(t, do(http.MethodDelete, "/admin/feed-tokens/"+created.ID, nil)).Code; code != ErrCodeFeedTokenNotFound {
+		t.Errorf("revoke again = %s, want %s", code, ErrCodeFeedTokenNotFound)
+	}
+}
@@ -0,0 +1,445 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// maxGraphQLRequestBytes bounds the body of a GraphQL request
+const maxGraphQLRequestBytes = 1 << 20
+
+// GraphQLRequest is the body of POST /graphql
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlCallerKey stores the graphqlCall of a request in the context
+// passed to resolvers
+type graphqlCallerKey struct{}
+
+// graphqlCall is a GraphQL request being resolved. At most one answer is
+// generated per request, so aliases cannot multiply engine queries.
+type graphqlCall struct {
+	c       *gin.Context
+	answers atomic.Int32
+}
+
+func graphqlCaller(p graphql.ResolveParams) *graphqlCall {
+	return p.Context.Value(graphqlCallerKey{}).(*graphqlCall)
+}
+
+// fieldContext returns a copy of the request's context for resolving a
+// field: abortWithError records the error of the field instead of
+// writing the response
+func (call *graphqlCall) fieldContext() (*gin.Context, *jobRun) {
+	fc := call.c.Copy()
+	run := &jobRun{}
+	fc.Set(queryJobKey, run)
+	return fc, run
+}
+
+// graphqlError is an error of the REST API reported for a field, with its
+// code in the extensions
+type graphqlError struct {
+	ErrorResponse
+}
+
+func (e graphqlError) Error() string {
+	return e.Message
+}
+
+func (e graphqlError) Extensions() map[string]any {
+	return map[string]any{"code": e.Code}
+}
+
+// fieldError converts the error recorded for a field
+func fieldError(run *jobRun) error {
+	if run.err == nil {
+		return graphqlError{ErrorResponse{Error: "internal_error", Code: ErrCodeInternal, Message: "The field could not be resolved"}}
+	}
+	return graphqlError{*run.err}
+}
+
+// graphqlJSON passes values through as the REST API encodes them
+var graphqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:         "JSON",
+	Description:  "A JSON value, encoded as by the REST API",
+	Serialize:    func(value any) any { return value },
+	ParseValue:   func(value any) any { return value },
+	ParseLiteral: func(ast.Value) any { return nil },
+})
+
+// newGraphQLSchema builds the schema of /graphql. Its fields are resolved
+// like the matching REST endpoints, for the caller of the request.
+func newGraphQLSchema(deps queryDeps, history *HistoryStore, reviews *ReviewStore, privateDocs *PrivateCollectionStore, index engine.DocumentIndex) (graphql.Schema, error) {
+	source := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Source",
+		Description: "A document or web page cited by an answer",
+		Fields: graphql.Fields{
+			"title":   &graphql.Field{Type: graphql.String},
+			"url":     &graphql.Field{Type: graphql.String},
+			"excerpt": &graphql.Field{Type: graphql.String},
+		},
+	})
+	citations := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Citations",
+		Description: "The sources of an answer, in the order of its searchResults and webResults",
+		Fields: graphql.Fields{
+			"sources":    &graphql.Field{Type: graphql.NewList(source)},
+			"webSources": &graphql.Field{Type: graphql.NewList(source)},
+		},
+	})
+	warning := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Warning",
+		Fields: graphql.Fields{
+			"field":   &graphql.Field{Type: graphql.String},
+			"code":    &graphql.Field{Type: graphql.String},
+			"message": &graphql.Field{Type: graphql.String},
+		},
+	})
+	answer := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Answer",
+		Description: "The answer to a legal query",
+		Fields: graphql.Fields{
+			"answer": &graphql.Field{Type: graphql.String},
+			"citations": &graphql.Field{Type: citations, Resolve: func(p graphql.ResolveParams) (any, error) {
+				resp := answerSource(p.Source)
+				return citationsFrom(resp.SearchResults, resp.WebResults), nil
+			}},
+			"searchResults":       &graphql.Field{Type: graphql.NewList(graphqlJSON)},
+			"webResults":          &graphql.Field{Type: graphql.NewList(graphqlJSON)},
+			"iterations":          &graphql.Field{Type: graphql.Int},
+			"queryUsed":           &graphql.Field{Type: graphql.String},
+			"cached":              &graphql.Field{Type: graphql.Boolean},
+			"historyId":           &graphql.Field{Type: graphql.ID},
+			"needsClarification":  &graphql.Field{Type: graphql.Boolean},
+			"clarifyingQuestions": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"pendingQueryId":      &graphql.Field{Type: graphql.ID},
+			"warnings":            &graphql.Field{Type: graphql.NewList(warning)},
+			"region":              &graphql.Field{Type: graphql.String},
+			"details": &graphql.Field{
+				Type:        graphqlJSON,
+				Description: "The complete answer of the REST API",
+				Resolve:     func(p graphql.ResolveParams) (any, error) { return p.Source, nil },
+			},
+		},
+	})
+
+	reviewEvent := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ReviewEvent",
+		Fields: graphql.Fields{
+			"from": &graphql.Field{Type: graphql.String},
+			"to":   &graphql.Field{Type: graphql.String},
+			"by":   &graphql.Field{Type: graphql.String},
+			"note": &graphql.Field{Type: graphql.String},
+			"at":   &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	// Comment threads embed their comment, which the default resolver does
+	// not look into
+	embedded := func(p graphql.ResolveParams) (any, error) {
+		p.Source = p.Source.(CommentThread).Comment
+		return graphql.DefaultResolveFn(p)
+	}
+	comment := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Comment",
+		Description: "A comment on an answer, with its replies",
+		Fields: graphql.Fields{
+			"id":        &graphql.Field{Type: graphql.ID, Resolve: embedded},
+			"parentId":  &graphql.Field{Type: graphql.ID, Resolve: embedded},
+			"author":    &graphql.Field{Type: graphql.String, Resolve: embedded},
+			"body":      &graphql.Field{Type: graphql.String, Resolve: embedded},
+			"deleted":   &graphql.Field{Type: graphql.Boolean, Resolve: embedded},
+			"createdAt": &graphql.Field{Type: graphql.DateTime, Resolve: embedded},
+		},
+	})
+	comment.AddFieldConfig("replies", &graphql.Field{Type: graphql.NewList(comment)})
+	review := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Review",
+		Description: "The feedback on an answer: its review state and comments",
+		Fields: graphql.Fields{
+			"state":     &graphql.Field{Type: graphql.String},
+			"updatedAt": &graphql.Field{Type: graphql.DateTime},
+			"events":    &graphql.Field{Type: graphql.NewList(reviewEvent)},
+			"comments": &graphql.Field{Type: graphql.NewList(comment), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return commentThreads(p.Source.(Review).Comments), nil
+			}},
+		},
+	})
+	historyEntry := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "HistoryEntry",
+		Description: "An answered query",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.ID},
+			"user":     &graphql.Field{Type: graphql.String},
+			"question": &graphql.Field{Type: graphql.String},
+			"answer": &graphql.Field{Type: answer, Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(HistoryEntry).Response, nil
+			}},
+			"durationMs":      &graphql.Field{Type: graphql.Int},
+			"createdAt":       &graphql.Field{Type: graphql.DateTime},
+			"topics":          &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"regeneratedFrom": &graphql.Field{Type: graphql.ID},
+			"review": &graphql.Field{Type: review, Resolve: func(p graphql.ResolveParams) (any, error) {
+				entry := p.Source.(HistoryEntry)
+				return reviews.Get(entry.ID, entry.TenantID), nil
+			}},
+		},
+	})
+	historyPage := graphql.NewObject(graphql.ObjectConfig{
+		Name: "HistoryPage",
+		Fields: graphql.Fields{
+			"entries":    &graphql.Field{Type: graphql.NewList(historyEntry)},
+			"nextCursor": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	document := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Document",
+		Description: "A document of a private collection",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.ID},
+			"filename":   &graphql.Field{Type: graphql.String},
+			"title":      &graphql.Field{Type: graphql.String},
+			"bytes":      &graphql.Field{Type: graphql.Int},
+			"chunks":     &graphql.Field{Type: graphql.Int},
+			"uploadedBy": &graphql.Field{Type: graphql.String},
+			"uploadedAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	privateCollection := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PrivateCollection",
+		Description: "A collection of the caller's tenant",
+		Fields: graphql.Fields{
+			"name":      &graphql.Field{Type: graphql.String},
+			"title":     &graphql.Field{Type: graphql.String},
+			"createdBy": &graphql.Field{Type: graphql.String},
+			"createdAt": &graphql.Field{Type: graphql.DateTime},
+			"documents": &graphql.Field{Type: graphql.NewList(document)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"answer": &graphql.Field{
+				Type:        answer,
+				Description: "Answers a question like POST /api/legal-query; one answer per request",
+				Args: graphql.FieldConfigArgument{
+					"question":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"maxIterations":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"topK":            &graphql.ArgumentConfig{Type: graphql.Int},
+					"enableWebSearch": &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"model":           &graphql.ArgumentConfig{Type: graphql.String},
+					"language":        &graphql.ArgumentConfig{Type: graphql.String},
+					"collection":      &graphql.ArgumentConfig{Type: graphql.String},
+					"preset":          &graphql.ArgumentConfig{Type: graphql.String},
+					"citationStyle":   &graphql.ArgumentConfig{Type: graphql.String},
+					"conversationId":  &graphql.ArgumentConfig{Type: graphql.String},
+					"pendingQueryId":  &graphql.ArgumentConfig{Type: graphql.String},
+					"clarification":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					call := graphqlCaller(p)
+					if call.answers.Add(1) > 1 {
+						return nil, graphqlError{ErrorResponse{Error: "invalid_request", Code: ErrCodeInvalidRequest, Message: "A request may ask for one answer"}}
+					}
+					req := queryRequestFromArgs(p.Args)
+					fc, run := call.fieldContext()
+					resp, ok := deps.answerQuery(fc, &req, nil, nil)
+					if !ok {
+						return nil, fieldError(run)
+					}
+					return resp, nil
+				},
+			},
+			"history": &graphql.Field{
+				Type:        historyPage,
+				Description: "The caller's query history, newest first, like GET /api/history",
+				Args: graphql.FieldConfigArgument{
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"cursor": &graphql.ArgumentConfig{Type: graphql.String},
+					"topic":  &graphql.ArgumentConfig{Type: graphql.String},
+					"q":      &graphql.ArgumentConfig{Type: graphql.String},
+					"user":   &graphql.ArgumentConfig{Type: graphql.String},
+					"from":   &graphql.ArgumentConfig{Type: graphql.String},
+					"to":     &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return resolveHistory(graphqlCaller(p), history, p.Args)
+				},
+			},
+			"historyEntry": &graphql.Field{
+				Type:        historyEntry,
+				Description: "An entry of the caller's query history",
+				Args:        graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					call := graphqlCaller(p)
+					tenant, _ := callerTenant(call.c)
+					id, _ := p.Args["id"].(string)
+					entry, err := history.Get(id, tenant.ID)
+					if errors.Is(err, errHistoryNotFound) {
+						return nil, graphqlError{ErrorResponse{Error: "history_not_found", Code: ErrCodeHistoryNotFound, Message: fmt.Sprintf("History entry %q not found", id)}}
+					}
+					if err != nil {
+						slog.ErrorContext(p.Context, "Failed to read history entry", "id", id, "error", err)
+						return nil, graphqlError{ErrorResponse{Error: "history_unavailable", Code: ErrCodeHistoryUnavailable, Message: "Failed to read the query history"}}
+					}
+					return entry, nil
+				},
+			},
+			"privateCollections": &graphql.Field{
+				Type:        graphql.NewList(privateCollection),
+				Description: "The private collections of the caller's tenant",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					fc, run := graphqlCaller(p).fieldContext()
+					tenant, ok := privateCollectionsTenant(fc, index)
+					if !ok {
+						return nil, fieldError(run)
+					}
+					return privateDocs.List(tenant.ID), nil
+				},
+			},
+			"documents": &graphql.Field{
+				Type:        graphql.NewList(document),
+				Description: "The documents of a private collection",
+				Args:        graphql.FieldConfigArgument{"collection": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					fc, run := graphqlCaller(p).fieldContext()
+					tenant, ok := privateCollectionsTenant(fc, index)
+					if !ok {
+						return nil, fieldError(run)
+					}
+					name, _ := p.Args["collection"].(string)
+					pc, err := privateDocs.Get(tenant.ID, name)
+					if err != nil {
+						privateCollectionError(fc, err, name)
+						return nil, fieldError(run)
+					}
+					return pc.Documents, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// answerSource returns the answer an Answer field is resolved on
+func answerSource(source any) engine.LegalQueryResponse {
+	if resp, ok := source.(*engine.LegalQueryResponse); ok {
+		return *resp
+	}
+	return source.(engine.LegalQueryResponse)
+}
+
+func queryRequestFromArgs(args map[string]any) LegalQueryRequest {
+	str := func(name string) string {
+		s, _ := args[name].(string)
+		return s
+	}
+	req := LegalQueryRequest{
+		Question:       str("question"),
+		Model:          str("model"),
+		Language:       str("language"),
+		Collection:     str("collection"),
+		Preset:         str("preset"),
+		CitationStyle:  str("citationStyle"),
+		ConversationID: str("conversationId"),
+		PendingQueryID: str("pendingQueryId"),
+		Clarification:  str("clarification"),
+	}
+	if v, ok := args["maxIterations"].(int); ok {
+		req.MaxIterations = &v
+	}
+	if v, ok := args["topK"].(int); ok {
+		req.TopK = &v
+	}
+	if v, ok := args["enableWebSearch"].(bool); ok {
+		req.EnableWebSearch = &v
+	}
+	return req
+}
+
+// resolveHistory lists the history like listHistoryHandler
+func resolveHistory(call *graphqlCall, history *HistoryStore, args map[string]any) (any, error) {
+	invalid := func(message string) error {
+		return graphqlError{ErrorResponse{Error: "invalid_request", Code: ErrCodeInvalidRequest, Message: message}}
+	}
+	str := func(name string) string {
+		s, _ := args[name].(string)
+		return s
+	}
+	limit, _ := args["limit"].(int)
+	if limit < 1 || limit > 100 {
+		return nil, invalid("limit must be an integer between 1 and 100")
+	}
+	filter := HistoryFilter{
+		Topic:  str("topic"),
+		Query:  strings.TrimSpace(str("q")),
+		User:   str("user"),
+		Before: str("cursor"),
+	}
+	var err error
+	if filter.From, err = parseHistoryTime(str("from"), false); err != nil {
+		return nil, invalid(fmt.Sprintf("from %v", err))
+	}
+	if filter.To, err = parseHistoryTime(str("to"), true); err != nil {
+		return nil, invalid(fmt.Sprintf("to %v", err))
+	}
+
+	tenant, _ := callerTenant(call.c)
+	entries, err := history.List(tenant.ID, limit+1, filter)
+	if err != nil {
+		slog.ErrorContext(call.c.Request.Context(), "Failed to list history", "error", err)
+		return nil, graphqlError{ErrorResponse{Error: "history_unavailable", Code: ErrCodeHistoryUnavailable, Message: "Failed to read the query history"}}
+	}
+	page := map[string]any{"entries": entries}
+	if len(entries) > limit {
+		page["entries"] = entries[:limit]
+		page["nextCursor"] = entries[limit-1].ID
+	}
+	return page, nil
+}
+
+// Handlers
+
+// graphqlHandler executes a GraphQL query. As usual for GraphQL, the
+// response is 200 with the errors of the fields next to the data; only
+// malformed requests get an error response.
+func graphqlHandler(schema graphql.Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGraphQLRequestBytes)
+		var req GraphQLRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			abortWithError(c, ErrCodeInvalidRequest, "query must not be empty")
+			return
+		}
+
+		call := &graphqlCall{c: c}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(c.Request.Context(), graphqlCallerKey{}, call),
+		})
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestGraphQL(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 180 ngày.",
+		SearchResults: []engine.DocumentHit{{
+			Excerpt: "Thời gian thử việc không quá 180 ngày đối với công việc của người quản lý doanh nghiệp.",
+			Title:   "Điều 25. Thời gian thử việc",
+		}},
+		Iterations: 1,
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	graphql := func(query string, variables map[string]any) (data map[string]any, errors []map[string]any) {
+		t.Helper()
+		rec := doAs(t, h, "lan", http.MethodPost, "/graphql", GraphQLRequest{Query: query, Variables: variables})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("graphql = %d %s", rec.Code, rec.Body.String())
+		}
+		var result struct {
+			Data   map[string]any   `json:"data"`
+			Errors []map[string]any `json:"errors"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		return result.Data, result.Errors
+	}
+	errorCode := func(errors []map[string]any) any {
+		if len(errors) == 0 {
+			return nil
+		}
+		extensions, _ := errors[0]["extensions"].(map[string]any)
+		return extensions["code"]
+	}
+
+	// Only the fields asked for are returned
+	data, errs := graphql(`query($q: String!) { answer(question: $q, topK: 3) { answer historyId citations { sources { title } } } }`,
+		map[string]any{"q": "Thời gian thử việc tối đa là bao lâu?"})
+	if len(errs) != 0 {
+		t.Fatalf("answer errors = %v", errs)
+	}
+	answer := data["answer"].(map[string]any)
+	if answer["answer"] != stub.resp.Answer || len(answer) != 3 {
+		t.Errorf("answer = %v, want the answer, its history ID and citations only", answer)
+	}
+	if title := answer["citations"].(map[string]any)["sources"].([]any)[0].(map[string]any)["title"]; title != "Điều 25. Thời gian thử việc" {
+		t.Errorf("citation title = %v", title)
+	}
+	if got := stub.requests[0].TopK; got != 3 {
+		t.Errorf("engine top_k = %d, want 3", got)
+	}
+	historyID := answer["historyId"].(string)
+
+	if rec := doWithKey(t, h, userKey(t, h, "minh"), http.MethodPost, "/api/history/"+historyID+"/comments", CommentRequest{Body: "Cần dẫn thêm Nghị định 145/2020"}); rec.Code != http.StatusCreated {
+		t.Fatalf("add comment = %d %s", rec.Code, rec.Body.String())
+	}
+	data, errs = graphql(`{
+		history(limit: 5) { entries { id question } nextCursor }
+		historyEntry(id: "`+historyID+`") { answer { answer } review { state comments { author body } } }
+	}`, nil)
+	if len(errs) != 0 {
+		t.Fatalf("history errors = %v", errs)
+	}
+	if entries := data["history"].(map[string]any)["entries"].([]any); len(entries) != 1 || entries[0].(map[string]any)["id"] != historyID {
+		t.Errorf("history = %v, want the answered query", entries)
+	}
+	review := data["historyEntry"].(map[string]any)["review"].(map[string]any)
+	if comments := review["comments"].([]any); review["state"] != string(ReviewDraft) || len(comments) != 1 || comments[0].(map[string]any)["author"] != "minh" {
+		t.Errorf("review = %v, want the draft with minh's comment", review)
+	}
+
+	// Errors keep the code of the REST API
+	if _, errs := graphql(`{ a: answer(question: "Thời gian thử việc là bao lâu?") { answer } b: answer(question: "Lương thử việc là bao nhiêu?") { answer } }`, nil); errorCode(errs) != string(ErrCodeInvalidRequest) {
+		t.Errorf("two answers = %v, want %s", errs, ErrCodeInvalidRequest)
+	}
+	if _, errs := graphql(`{ historyEntry(id: "q_unknown") { id } }`, nil); errorCode(errs) != string(ErrCodeHistoryNotFound) {
+		t.Errorf("unknown history entry = %v, want %s", errs, ErrCodeHistoryNotFound)
+	}
+	if _, errs := graphql(`{ documents(collection: "hop-dong") { id } }`, nil); errorCode(errs) != string(ErrCodeForbidden) {
+		t.Errorf("documents without a tenant = %v, want %s", errs, ErrCodeForbidden)
+	}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, "/graphql", GraphQLRequest{})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("empty query = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+}
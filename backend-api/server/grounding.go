@@ -0,0 +1,138 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const (
+	// minGroundingScore is the share of a sentence's content words that
+	// must appear in an excerpt for it to count as support
+	minGroundingScore = 0.5
+
+	// minGroundingTokens skips sentences too short to ground reliably
+	minGroundingTokens = 3
+)
+
+// groundingStopwords are frequent Vietnamese function words that would
+// otherwise make unrelated sentences look similar
+var groundingStopwords = map[string]bool{
+	"và": true, "của": true, "các": true, "là": true, "có": true, "được": true,
+	"cho": true, "với": true, "đối": true, "theo": true, "trong": true, "này": true,
+	"không": true, "thì": true, "để": true, "khi": true, "một": true, "những": true,
+	"đó": true, "tại": true, "về": true, "từ": true, "hoặc": true, "bị": true,
+}
+
+// textSpan is a sentence or clause of a text with its content words
+type textSpan struct {
+	start, end int
+	tokens     map[string]bool
+}
+
+// groundAnswer maps every answer sentence to the best supporting excerpt of
+// the internal and web results
+func groundAnswer(resp *engine.LegalQueryResponse) []engine.Highlight {
+	type source struct {
+		name  string
+		index int
+		spans []textSpan
+	}
+	var sources []source
+	for i, r := range resp.SearchResults {
+		sources = append(sources, source{"search_results", i, splitSpans(r.Excerpt)})
+	}
+	for i, r := range resp.WebResults {
+		sources = append(sources, source{"web_results", i, splitSpans(r.Content)})
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	var highlights []engine.Highlight
+	for _, sentence := range splitSpans(resp.Answer) {
+		if len(sentence.tokens) < minGroundingTokens {
+			continue
+		}
+
+		var best engine.Highlight
+		for _, src := range sources {
+			for _, excerpt := range src.spans {
+				score := overlap(sentence.tokens, excerpt.tokens)
+				if score > best.Score {
+					best = engine.Highlight{
+						AnswerStart: sentence.start,
+						AnswerEnd:   sentence.end,
+						Source:      src.name,
+						ResultIndex: src.index,
+						SourceStart: excerpt.start,
+						SourceEnd:   excerpt.end,
+						Score:       score,
+					}
+				}
+			}
+		}
+		if best.Score >= minGroundingScore {
+			best.Score = float64(int(best.Score*100+0.5)) / 100
+			highlights = append(highlights, best)
+		}
+	}
+	return highlights
+}
+
+// overlap returns the share of sentence tokens found in the excerpt
+func overlap(sentence, excerpt map[string]bool) float64 {
+	found := 0
+	for token := range sentence {
+		if excerpt[token] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(sentence))
+}
+
+// splitSpans splits text into sentences and clauses at '.', '!', '?' or ';'
+// followed by whitespace, and at line breaks. Leading list markers and
+// whitespace are excluded from each span.
+func splitSpans(text string) []textSpan {
+	runes := []rune(text)
+	var spans []textSpan
+	emit := func(start, end int) {
+		for start < end && (unicode.IsSpace(runes[start]) || strings.ContainsRune("-*•", runes[start])) {
+			start++
+		}
+		for end > start && unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+		if start < end {
+			spans = append(spans, textSpan{start: start, end: end, tokens: contentTokens(string(runes[start:end]))})
+		}
+	}
+
+	start := 0
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			emit(start, i)
+			start = i + 1
+		case strings.ContainsRune(".!?;", r) && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])):
+			emit(start, i+1)
+			start = i + 1
+		}
+	}
+	emit(start, len(runes))
+	return spans
+}
+
+func contentTokens(text string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !groundingStopwords[word] {
+			tokens[word] = true
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	legalragv1 "github.com/nguyenvothetuyen/legal-rag-backend/proto/legalrag/v1"
+)
+
+// grpcErrorDomain is the domain of the ErrorInfo detail of gRPC errors,
+// whose reason is the error code of the REST API
+const grpcErrorDomain = "legalrag"
+
+// grpcQueryService serves LegalQueryService by passing each call through
+// the HTTP router as a request to the matching REST endpoint. Calls share
+// the middleware of the REST API, from API keys and rate limits to request
+// logs and metrics, and the engine behind it.
+type grpcQueryService struct {
+	legalragv1.UnimplementedLegalQueryServiceServer
+	api http.Handler
+}
+
+func (s *grpcQueryService) Query(ctx context.Context, req *legalragv1.QueryRequest) (*legalragv1.QueryResponse, error) {
+	w := &rpcResponseWriter{header: http.Header{}}
+	if err := s.serve(ctx, "/api/legal-query", req, w); err != nil {
+		return nil, err
+	}
+	grpc.SetHeader(ctx, responseMetadata(w.header))
+	if w.status >= http.StatusBadRequest {
+		return nil, rpcErrorFromBody(w.status, w.body.Bytes())
+	}
+	return queryResponseToProto(w.body.Bytes())
+}
+
+func (s *grpcQueryService) StreamQuery(req *legalragv1.QueryRequest, stream grpc.ServerStreamingServer[legalragv1.QueryEvent]) error {
+	var failure *ErrorResponse
+	w := &rpcResponseWriter{header: http.Header{}}
+	w.onEvent = func(name string, data []byte) error {
+		if !w.streaming {
+			w.streaming = true
+			stream.SetHeader(responseMetadata(w.header))
+		}
+		if name == "error" {
+			failure = &ErrorResponse{}
+			return json.Unmarshal(data, failure)
+		}
+		event, err := queryEventToProto(name, data)
+		if err != nil || event == nil {
+			return err
+		}
+		return stream.Send(event)
+	}
+	if err := s.serve(stream.Context(), "/api/legal-query/stream", req, w); err != nil {
+		return err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if failure != nil {
+		return rpcError(*failure)
+	}
+	if !w.streaming && w.status >= http.StatusBadRequest {
+		stream.SetHeader(responseMetadata(w.header))
+		return rpcErrorFromBody(w.status, w.body.Bytes())
+	}
+	return nil
+}
+
+// serve runs the call as a POST of the query to path. Metadata is passed as
+// request headers and the peer as the client address.
+func (s *grpcQueryService) serve(ctx context.Context, path string, req *legalragv1.QueryRequest, w *rpcResponseWriter) error {
+	body, err := json.Marshal(queryRequestFromProto(req))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode the query: %v", err)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build the request: %v", err)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if !forwardedMetadata(key) {
+				continue
+			}
+			for _, v := range values {
+				r.Header.Add(key, v)
+			}
+		}
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	s.api.ServeHTTP(w, r)
+	return nil
+}
+
+// forwardedMetadata reports whether a metadata key is passed to the router
+// as a header. Pseudo-headers, the headers of the gRPC protocol itself and
+// binary values are not.
+func forwardedMetadata(key string) bool {
+	switch {
+	case strings.HasPrefix(key, ":"), strings.HasPrefix(key, "grpc-"), strings.HasSuffix(key, "-bin"):
+		return false
+	case key == "content-type", key == "te":
+		return false
+	}
+	return true
+}
+
+// responseMetadata returns the X- headers of a response, e.g. X-Request-ID
+// and the rate limit headers, as gRPC metadata
+func responseMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		if strings.HasPrefix(key, "X-") {
+			md.Append(key, values...)
+		}
+	}
+	return md
+}
+
+// rpcResponseWriter records the router's response to a call. Once the
+// response turns out to be an event stream, each event is passed to
+// onEvent as soon as it is complete instead.
+type rpcResponseWriter struct {
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	onEvent func(name string, data []byte) error
+	err     error
+
+	// streaming is set by onEvent once it got the first event
+	streaming bool
+}
+
+func (w *rpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *rpcResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *rpcResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.body.Write(p)
+	if w.onEvent == nil || !strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream") {
+		return len(p), nil
+	}
+	for w.err == nil {
+		frame, rest, ok := bytes.Cut(w.body.Bytes(), []byte("\n\n"))
+		if !ok {
+			break
+		}
+		var name string
+		var data [][]byte
+		for _, line := range bytes.Split(frame, []byte("\n")) {
+			if v, ok := bytes.CutPrefix(line, []byte("event:")); ok {
+				name = string(bytes.TrimSpace(v))
+			} else if v, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				data = append(data, bytes.TrimPrefix(v, []byte(" ")))
+			}
+		}
+		rest = bytes.Clone(rest)
+		w.body.Reset()
+		w.body.Write(rest)
+		if name != "" {
+			w.err = w.onEvent(name, bytes.Join(data, []byte("\n")))
+		}
+	}
+	return len(p), w.err
+}
+
+// Flush is a no-op: events are sent as soon as they are written
+func (w *rpcResponseWriter) Flush() {}
+
+func queryRequestFromProto(req *legalragv1.QueryRequest) LegalQueryRequest {
+	q := LegalQueryRequest{
+		Question:        req.GetQuestion(),
+		EnableWebSearch: req.EnableWebSearch,
+		Model:           req.GetModel(),
+		Language:        req.GetLanguage(),
+		Collection:      req.GetCollection(),
+		Preset:          req.GetPreset(),
+		CitationStyle:   req.GetCitationStyle(),
+		ConversationID:  req.GetConversationId(),
+		PendingQueryID:  req.GetPendingQueryId(),
+		Clarification:   req.GetClarification(),
+	}
+	if req.MaxIterations != nil {
+		v := int(req.GetMaxIterations())
+		q.MaxIterations = &v
+	}
+	if req.TopK != nil {
+		v := int(req.GetTopK())
+		q.TopK = &v
+	}
+	return q
+}
+
+// queryResponseToProto converts the JSON answer of the REST API, keeping
+// all of it in Details
+func queryResponseToProto(data []byte) (*legalragv1.QueryResponse, error) {
+	var resp engine.LegalQueryResponse
+	var details map[string]any
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid answer: %v", err)
+	}
+	if err := json.Unmarshal(data, &details); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid answer: %v", err)
+	}
+	out := &legalragv1.QueryResponse{
+		Answer:              resp.Answer,
+		Iterations:          int32(resp.Iterations),
+		QueryUsed:           resp.QueryUsed,
+		Cached:              resp.Cached,
+		HistoryId:           resp.HistoryID,
+		NeedsClarification:  resp.NeedsClarification,
+		ClarifyingQuestions: resp.ClarifyingQuestions,
+		PendingQueryId:      resp.PendingQueryID,
+	}
+	var err error
+	if out.SearchResults, err = structsToProto(details["search_results"]); err != nil {
+		return nil, err
+	}
+	if out.WebResults, err = structsToProto(details["web_results"]); err != nil {
+		return nil, err
+	}
+	if out.Details, err = structpb.NewStruct(details); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid answer: %v", err)
+	}
+	for _, w := range resp.Warnings {
+		out.Warnings = append(out.Warnings, &legalragv1.Warning{Field: w.Field, Code: w.Code, Message: w.Message})
+	}
+	return out, nil
+}
+
+// structsToProto converts the search or web results of a JSON answer
+func structsToProto(results any) ([]*structpb.Struct, error) {
+	list, _ := results.([]any)
+	out := make([]*structpb.Struct, 0, len(list))
+	for _, r := range list {
+		m, _ := r.(map[string]any)
+		s, err := structpb.NewStruct(m)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid search result: %v", err)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// queryEventToProto converts an event of the streaming endpoint; events the
+// service does not know are skipped
+func queryEventToProto(name string, data []byte) (*legalragv1.QueryEvent, error) {
+	switch name {
+	case "citations":
+		var event CitationsEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid citations: %v", err)
+		}
+		return &legalragv1.QueryEvent{Event: &legalragv1.QueryEvent_Citations{Citations: &legalragv1.Citations{
+			Sources:    sourcesToProto(event.Sources),
+			WebSources: sourcesToProto(event.WebSources),
+		}}}, nil
+	case "token":
+		var event TokenEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid token: %v", err)
+		}
+		return &legalragv1.QueryEvent{Event: &legalragv1.QueryEvent_Token{Token: event.Text}}, nil
+	case "answer":
+		resp, err := queryResponseToProto(data)
+		if err != nil {
+			return nil, err
+		}
+		return &legalragv1.QueryEvent{Event: &legalragv1.QueryEvent_Answer{Answer: resp}}, nil
+	}
+	return nil, nil
+}
+
+func sourcesToProto(sources []BinderSource) []*legalragv1.Source {
+	out := make([]*legalragv1.Source, 0, len(sources))
+	for _, s := range sources {
+		out = append(out, &legalragv1.Source{Title: s.Title, Url: s.URL, Excerpt: s.Excerpt})
+	}
+	return out
+}
+
+// rpcErrorFromBody converts an error response of the router; responses
+// that are not catalog errors, e.g. from another middleware, keep their
+// HTTP status
+func rpcErrorFromBody(httpStatus int, body []byte) error {
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Code == "" {
+		return status.Error(grpcCode(httpStatus), http.StatusText(httpStatus))
+	}
+	return rpcError(resp)
+}
+
+// rpcError converts a catalog error to a gRPC status, with the error code
+// as the reason of an ErrorInfo detail and its details as the metadata,
+// the violations of an invalid request as a BadRequest detail and the
+// retry delay as a RetryInfo detail
+func rpcError(resp ErrorResponse) error {
+	st := status.New(grpcCode(lookupError(resp.Code).HTTPStatus), resp.Message)
+	info := &errdetails.ErrorInfo{Reason: string(resp.Code), Domain: grpcErrorDomain}
+	if len(resp.Details) > 0 {
+		info.Metadata = make(map[string]string, len(resp.Details))
+		for key, value := range resp.Details {
+			info.Metadata[key] = fmt.Sprint(value)
+		}
+	}
+	details := []protoadapt.MessageV1{info}
+	if len(resp.Violations) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range resp.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Message})
+		}
+		details = append(details, badRequest)
+	}
+	if resp.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(resp.RetryAfter) * time.Second)})
+	}
+	detailed, err := st.WithDetails(details...)
+	if err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcCode maps an HTTP status to the gRPC code closest to it
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if httpStatus >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	legalragv1 "github.com/nguyenvothetuyen/legal-rag-backend/proto/legalrag/v1"
+)
+
+func TestGRPCQueryService(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 180 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Score: 0.92}},
+		Iterations:    1,
+	}}
+	t.Setenv("REQUIRE_API_KEY", "true")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(`{"name": "internal"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var key CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &key); err != nil || key.Key == "" {
+		t.Fatalf("create API key = %d %s", rec.Code, rec.Body.String())
+	}
+
+	grpcServer, err := NewGRPCServer("127.0.0.1:0", h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve()
+	defer grpcServer.Stop()
+	conn, err := grpc.NewClient(grpcServer.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := legalragv1.NewLegalQueryServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key.Key, "x-request-id", "grpc-test-1")
+	question := &legalragv1.QueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}
+
+	var header metadata.MD
+	resp, err := client.Query(ctx, question, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if resp.Answer != stub.resp.Answer || len(resp.SearchResults) != 1 || resp.Details.Fields["answer"].GetStringValue() != stub.resp.Answer {
+		t.Errorf("Query = %+v, want the engine's answer", resp)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "grpc-test-1" {
+		t.Errorf("x-request-id = %v, want the caller's", got)
+	}
+
+	stream, err := client.StreamQuery(ctx, question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*legalragv1.QueryEvent
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("StreamQuery: %v", err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 || len(events[0].GetCitations().GetSources()) != 1 || events[1].GetAnswer().GetAnswer() != stub.resp.Answer {
+		t.Errorf("StreamQuery events = %v, want the citations then the answer", events)
+	}
+
+	// Errors keep the code of the REST API as the reason
+	_, err = client.Query(ctx, &legalragv1.QueryRequest{})
+	if st := status.Convert(err); st.Code() != codes.InvalidArgument || errorReason(st) != string(ErrCodeInvalidRequest) {
+		t.Errorf("empty question = %v, want InvalidArgument with reason %s", err, ErrCodeInvalidRequest)
+	} else if field := fieldViolation(st); field != "question" {
+		t.Errorf("empty question field violation = %q, want question", field)
+	}
+	stream, _ = client.StreamQuery(context.Background(), question)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stream without an API key = %v, want Unauthenticated", err)
+	}
+}
+
+func fieldViolation(st *status.Status) string {
+	for _, d := range st.Details() {
+		if badRequest, ok := d.(*errdetails.BadRequest); ok && len(badRequest.FieldViolations) > 0 {
+			return badRequest.FieldViolations[0].Field
+		}
+	}
+	return ""
+}
+
+func errorReason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestRPCErrorDetails(t *testing.T) {
+	resp := newErrorResponse(ErrCodeRateLimited, "Too many requests; retry in 3 seconds")
+	resp.Details = map[string]any{"limit": 10}
+	resp.RetryAfter = 3
+	st := status.Convert(rpcError(resp))
+	if st.Code() != codes.ResourceExhausted {
+		t.Errorf("code = %s, want ResourceExhausted", st.Code())
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Metadata["limit"] != "10" {
+				t.Errorf("ErrorInfo metadata = %v, want the details", d.Metadata)
+			}
+		case *errdetails.RetryInfo:
+			retry = d
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() != 3*time.Second {
+		t.Errorf("RetryInfo = %v, want a delay of 3s", retry)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	legalragv1 "github.com/nguyenvothetuyen/legal-rag-backend/proto/legalrag/v1"
+)
+
+// grpcServiceName is the health service name load balancers can probe in
+// addition to the overall ("") status
+const grpcServiceName = "legalrag.Backend"
+
+// GRPCServer serves LegalQueryService next to the standard grpc.health.v1
+// and reflection services. The health status follows the health of the
+// Python engine.
+type GRPCServer struct {
+	server   *grpc.Server
+	health   *health.Server
+	listener net.Listener
+}
+
+// NewGRPCServer listens on addr. Queries are answered by api, the router of
+// the REST API.
+func NewGRPCServer(addr string, api http.Handler) (*GRPCServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s := &GRPCServer{
+		server:   grpc.NewServer(),
+		health:   health.NewServer(),
+		listener: listener,
+	}
+	healthpb.RegisterHealthServer(s.server, s.health)
+	legalragv1.RegisterLegalQueryServiceServer(s.server, &grpcQueryService{api: api})
+	reflection.Register(s.server)
+	s.setServing(false)
+	return s, nil
+}
+
+func (s *GRPCServer) setServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", status)
+	s.health.SetServingStatus(grpcServiceName, status)
+}
+
+// Serve blocks until the listener fails or Stop is called
+func (s *GRPCServer) Serve() error {
+	return s.server.Serve(s.listener)
+}
+
+// Stop reports NOT_SERVING and stops the server once its calls finish
+func (s *GRPCServer) Stop() {
+	s.health.Shutdown()
+	s.server.GracefulStop()
+}
+
+// Close cancels the calls still running and closes their connections
+func (s *GRPCServer) Close() {
+	s.server.Stop()
+}
+
+// watchEngine updates the health status from an engine health check every
+// interval until stop is closed
+func (s *GRPCServer) watchEngine(check func() error, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	serving := false
+	for {
+		err := check()
+		if healthy := err == nil; healthy != serving {
+			serving = healthy
+			s.setServing(serving)
+			if serving {
+				slog.Info("gRPC health changed", "status", "SERVING")
+			} else {
+				slog.Warn("gRPC health changed", "status", "NOT_SERVING", "error", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
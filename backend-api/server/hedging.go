@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// HedgingConfig controls hedged engine requests
+type HedgingConfig struct {
+	Enabled    bool
+	PoolURLs   []string
+	Budget     float64
+	MinSamples int
+	MinDelay   time.Duration
+}
+
+const (
+	// hedgeLatencySamples is how many recent latencies the hedging delay is
+	// computed from
+	hedgeLatencySamples = 200
+
+	// hedgeBudgetWindow is how many recent queries the hedging budget covers
+	hedgeBudgetWindow = 1000
+)
+
+// hedgingEngine sends a query to the first engine of the pool and, when it
+// has not answered within the p95 of recent latencies, sends a duplicate to
+// another engine. The first answer wins and the other request is cancelled.
+// At most Budget of the recent queries are hedged.
+type hedgingEngine struct {
+	pool       []engine.ContextQueryEngine
+	budget     float64
+	minSamples int
+	minDelay   time.Duration
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	hedged    []bool
+	next      int
+	queries   int
+	hedges    int
+	hedgeWins int
+}
+
+func newHedgingEngine(config HedgingConfig, pool []engine.ContextQueryEngine) *hedgingEngine {
+	return &hedgingEngine{
+		pool:       pool,
+		budget:     config.Budget,
+		minSamples: config.MinSamples,
+		minDelay:   config.MinDelay,
+	}
+}
+
+// hedgeDelay returns the p95 of recent latencies, or false while there are
+// too few samples to hedge
+func (e *hedgingEngine) hedgeDelay() (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.latencies) < e.minSamples {
+		return 0, false
+	}
+	sorted := slices.Clone(e.latencies)
+	slices.Sort(sorted)
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+	return max(p95, e.minDelay), true
+}
+
+// allowHedge reserves a hedge if it fits the budget and picks the engine to
+// send it to, rotating through the rest of the pool
+func (e *hedgingEngine) allowHedge() (engine.ContextQueryEngine, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	hedged := 0
+	for _, h := range e.hedged {
+		if h {
+			hedged++
+		}
+	}
+	if float64(hedged+1) > e.budget*float64(max(len(e.hedged), 1)) {
+		return nil, false
+	}
+	e.next = e.next%(len(e.pool)-1) + 1
+	e.hedges++
+	e.hedged[len(e.hedged)-1] = true
+	return e.pool[e.next], true
+}
+
+func (e *hedgingEngine) begin() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.queries++
+	e.hedged = append(e.hedged, false)
+	if len(e.hedged) > hedgeBudgetWindow {
+		e.hedged = e.hedged[1:]
+	}
+}
+
+func (e *hedgingEngine) observe(latency time.Duration, hedgeWon bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.latencies = append(e.latencies, latency)
+	if len(e.latencies) > hedgeLatencySamples {
+		e.latencies = e.latencies[1:]
+	}
+	if hedgeWon {
+		e.hedgeWins++
+	}
+}
+
+func (e *hedgingEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *hedgingEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	e.begin()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp  *engine.LegalQueryResponse
+		err   error
+		hedge bool
+	}
+	results := make(chan result, 2)
+	started := time.Now()
+	attempt := exclusiveStream(req)
+	go func() {
+		resp, err := e.pool[0].QueryContext(ctx, attempt())
+		results <- result{resp, err, false}
+	}()
+
+	var timeout <-chan time.Time
+	if delay, ok := e.hedgeDelay(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	pending := 1
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				e.observe(time.Since(started), r.hedge)
+				r.resp.Hedged = r.hedge
+				return r.resp, nil
+			}
+			// Wait for the other request if it is still running
+			if pending == 0 {
+				return nil, r.err
+			}
+		case <-timeout:
+			timeout = nil
+			hedge, ok := e.allowHedge()
+			if !ok {
+				continue
+			}
+			slog.InfoContext(ctx, "Hedging engine request", "after", time.Since(started).Round(time.Millisecond).String())
+			pending++
+			hedgeReq := attempt()
+			go func() {
+				resp, err := hedge.QueryContext(ctx, hedgeReq)
+				results <- result{resp, err, true}
+			}()
+		}
+	}
+}
+
+// HedgingStats is returned by the hedging admin endpoint
+type HedgingStats struct {
+	Queries   int     `json:"queries"`
+	Hedges    int     `json:"hedges"`
+	HedgeWins int     `json:"hedge_wins"`
+	DelayMs   int64   `json:"delay_ms"`
+	Budget    float64 `json:"budget"`
+	PoolSize  int     `json:"pool_size"`
+}
+
+func (e *hedgingEngine) Stats() HedgingStats {
+	delay, _ := e.hedgeDelay()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return HedgingStats{
+		Queries:   e.queries,
+		Hedges:    e.hedges,
+		HedgeWins: e.hedgeWins,
+		DelayMs:   delay.Milliseconds(),
+		Budget:    e.budget,
+		PoolSize:  len(e.pool),
+	}
+}
+
+// Handlers
+
+func hedgingStatsHandler(hedging *hedgingEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hedging == nil {
+			abortWithError(c, ErrCodeForbidden, "Request hedging is disabled; set ENABLE_HEDGING=true and ENGINE_POOL_URLS to enable it")
+			return
+		}
+		c.JSON(http.StatusOK, hedging.Stats())
+	}
+}
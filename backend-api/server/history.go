@@ -0,0 +1,535 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// QueryParameters are the resolved parameters a query was answered with
+type QueryParameters struct {
+	MaxIterations    int                `json:"max_iterations"`
+	TopK             int                `json:"top_k"`
+	EnableWebSearch  bool               `json:"enable_web_search"`
+	Model            string             `json:"model,omitempty"`
+	ResponseFormat   string             `json:"response_format,omitempty"`
+	CitationStyle    string             `json:"citation_style,omitempty"`
+	Style            engine.AnswerStyle `json:"style"`
+	Language         string             `json:"language,omitempty"`
+	Collection       string             `json:"collection,omitempty"`
+	IterationPolicy  string             `json:"iteration_policy,omitempty"`
+	ContextDocuments int                `json:"context_documents,omitempty"`
+}
+
+// HistoryEntry records an answered query. Context documents are counted but
+// their text is not kept.
+type HistoryEntry struct {
+	ID         string                    `json:"id"`
+	TenantID   string                    `json:"tenant_id,omitempty"`
+	User       string                    `json:"user,omitempty"`
+	Question   string                    `json:"question"`
+	Parameters QueryParameters           `json:"parameters"`
+	Response   engine.LegalQueryResponse `json:"response"`
+	DurationMs int64                     `json:"duration_ms"`
+	CreatedAt  time.Time                 `json:"created_at"`
+
+	// RegeneratedFrom links a regenerated answer to the original entry
+	RegeneratedFrom string `json:"regenerated_from,omitempty"`
+
+	// Topics are the taxonomy topics the question was tagged with when it
+	// was answered
+	Topics []string `json:"topics,omitempty"`
+}
+
+// HistoryFilter selects history entries; zero fields match every entry
+type HistoryFilter struct {
+	// Topic matches entries tagged with the topic or one of its subtopics
+	Topic string
+
+	// Query matches entries whose question contains it, case-insensitively
+	Query string
+
+	// User matches entries asked by the user
+	User string
+
+	// From and To match entries created at or after From and before To
+	From, To time.Time
+
+	// Before matches entries older than the entry with this ID, to page
+	// through the history; an unknown ID matches nothing
+	Before string
+}
+
+func (f HistoryFilter) matches(e *HistoryEntry) bool {
+	switch {
+	case f.Topic != "" && !slices.Contains(e.Topics, f.Topic):
+		return false
+	case f.User != "" && e.User != f.User:
+		return false
+	case !f.From.IsZero() && e.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.CreatedAt.Before(f.To):
+		return false
+	}
+	return f.Query == "" || strings.Contains(strings.ToLower(e.Question), strings.ToLower(f.Query))
+}
+
+var errHistoryNotFound = errors.New("history entry not found")
+
+// HistoryStore keeps the most recent answered queries in memory, appending
+// each one to a JSON lines file when a path is configured. With a database,
+// every query is kept in Postgres and listed from there, while statistics
+// such as TopQuestions still cover the entries kept in memory.
+type HistoryStore struct {
+	mu         sync.RWMutex
+	path       string
+	db         *pgxpool.Pool
+	maxEntries int
+	entries    []*HistoryEntry
+	byID       map[string]*HistoryEntry
+}
+
+func NewHistoryStore(path string, maxEntries int) (*HistoryStore, error) {
+	store := &HistoryStore{
+		path:       path,
+		maxEntries: maxEntries,
+		byID:       make(map[string]*HistoryEntry),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal history line %d: %w", lines+1, err)
+		}
+		store.appendLocked(&e)
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	// Drop entries that no longer fit so the file doesn't grow forever
+	if lines > len(store.entries) {
+		if err := store.rewriteLocked(); err != nil {
+			return nil, fmt.Errorf("failed to compact history: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// Add assigns an ID to the entry and records it
+func (s *HistoryStore) Add(e HistoryEntry) (HistoryEntry, error) {
+	e.ID = "q_" + randomHex(12)
+	e.CreatedAt = time.Now().UTC()
+	if s.db != nil {
+		s.mu.Lock()
+		s.appendLocked(&e)
+		s.mu.Unlock()
+		return e, s.insert(e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appendLocked(&e)
+	if s.path == "" {
+		return e, nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return e, fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return e, fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return e, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return e, fmt.Errorf("failed to write history: %w", err)
+	}
+	return e, nil
+}
+
+// Get returns an entry owned by tenantID
+func (s *HistoryStore) Get(id, tenantID string) (HistoryEntry, error) {
+	s.mu.RLock()
+	e, ok := s.byID[id]
+	s.mu.RUnlock()
+	if !ok && s.db != nil {
+		return s.get(id, tenantID)
+	}
+	if !ok || e.TenantID != tenantID {
+		return HistoryEntry{}, errHistoryNotFound
+	}
+	return *e, nil
+}
+
+// List returns up to limit entries owned by tenantID, newest first
+func (s *HistoryStore) List(tenantID string, limit int, filter HistoryFilter) ([]HistoryEntry, error) {
+	if s.db != nil {
+		return s.list(tenantID, limit, filter)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []HistoryEntry{}
+	i := len(s.entries) - 1
+	if filter.Before != "" {
+		before, ok := s.byID[filter.Before]
+		if !ok {
+			return entries, nil
+		}
+		i = slices.Index(s.entries, before) - 1
+	}
+	for ; i >= 0 && len(entries) < limit; i-- {
+		if s.entries[i].TenantID == tenantID && filter.matches(s.entries[i]) {
+			entries = append(entries, *s.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+// TopicCounts returns how many entries owned by tenantID are tagged with
+// each topic
+func (s *HistoryStore) TopicCounts(tenantID string) map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, e := range s.entries {
+		if e.TenantID != tenantID {
+			continue
+		}
+		for _, topic := range e.Topics {
+			counts[topic]++
+		}
+	}
+	return counts
+}
+
+// Since returns the entries of every tenant created at or after t, oldest first
+func (s *HistoryStore) Since(t time.Time) []HistoryEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []HistoryEntry
+	for _, e := range s.entries {
+		if !e.CreatedAt.Before(t) {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// TopQuestions returns the latest entry of each of the n most frequently asked
+// questions across all tenants, most frequent first. Questions are compared
+// case- and whitespace-insensitively; entries that used context documents,
+// were regenerated or were answered by a rule are skipped.
+func (s *HistoryStore) TopQuestions(n int) []HistoryEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type question struct {
+		count  int
+		latest *HistoryEntry
+	}
+	byKey := make(map[string]*question)
+	var order []*question
+	for _, e := range s.entries {
+		if e.Parameters.ContextDocuments > 0 || e.RegeneratedFrom != "" || e.Response.Rule != "" {
+			continue
+		}
+		key := strings.ToLower(strings.Join(strings.Fields(e.Question), " "))
+		q, ok := byKey[key]
+		if !ok {
+			q = &question{}
+			byKey[key] = q
+			order = append(order, q)
+		}
+		q.count++
+		q.latest = e
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].count > order[j].count
+	})
+	entries := []HistoryEntry{}
+	for _, q := range order[:min(n, len(order))] {
+		entries = append(entries, *q.latest)
+	}
+	return entries
+}
+
+func (s *HistoryStore) appendLocked(e *HistoryEntry) {
+	s.entries = append(s.entries, e)
+	s.byID[e.ID] = e
+	if len(s.entries) > s.maxEntries {
+		delete(s.byID, s.entries[0].ID)
+		s.entries = s.entries[1:]
+	}
+}
+
+func (s *HistoryStore) rewriteLocked() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range s.entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to marshal history entry: %w", err)
+		}
+	}
+	return writeFileAtomic(s.path, buf.Bytes())
+}
+
+// Handlers
+
+func listHistoryHandler(history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 20
+		if value := c.Query("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 100 {
+				abortWithInvalidParam(c, "limit", "limit must be an integer between 1 and 100")
+				return
+			}
+			limit = parsed
+		}
+
+		filter := HistoryFilter{
+			Topic:  c.Query("topic"),
+			Query:  strings.TrimSpace(c.Query("q")),
+			User:   c.Query("user"),
+			Before: c.Query("cursor"),
+		}
+		var err error
+		if filter.From, err = parseHistoryTime(c.Query("from"), false); err != nil {
+			abortWithInvalidParam(c, "from", fmt.Sprintf("from %v", err))
+			return
+		}
+		if filter.To, err = parseHistoryTime(c.Query("to"), true); err != nil {
+			abortWithInvalidParam(c, "to", fmt.Sprintf("to %v", err))
+			return
+		}
+
+		// One more entry than asked tells whether there is a next page
+		tenant, _ := callerTenant(c)
+		entries, err := history.List(tenant.ID, limit+1, filter)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list history", "error", err)
+			abortWithError(c, ErrCodeHistoryUnavailable, "Failed to read the query history")
+			return
+		}
+		resp := gin.H{"entries": entries}
+		if len(entries) > limit {
+			resp["entries"] = entries[:limit]
+			resp["next_cursor"] = entries[limit-1].ID
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// parseHistoryTime reads a from or to bound, either an RFC 3339 time or a
+// date. A date bounding the end of the range includes that whole day.
+func parseHistoryTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 time or a YYYY-MM-DD date, got %q", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// RegenerateRequest lists the parameters to change when re-running a stored
+// query; omitted parameters keep their original value. Style dimensions are
+// overridden individually.
+type RegenerateRequest struct {
+	MaxIterations   *int                `json:"max_iterations,omitempty"`
+	TopK            *int                `json:"top_k,omitempty"`
+	EnableWebSearch *bool               `json:"enable_web_search,omitempty"`
+	Model           string              `json:"model,omitempty"`
+	ResponseFormat  string              `json:"response_format,omitempty"`
+	CitationStyle   string              `json:"citation_style,omitempty"`
+	Style           *engine.AnswerStyle `json:"style,omitempty"`
+	IterationPolicy string              `json:"iteration_policy,omitempty"`
+}
+
+// RegenerateResponse returns the original and the regenerated entry for
+// comparison
+type RegenerateResponse struct {
+	Original    HistoryEntry     `json:"original"`
+	Regenerated HistoryEntry     `json:"regenerated"`
+	Warnings    []engine.Warning `json:"warnings,omitempty"`
+}
+
+// regenerationRequest rebuilds the client request of a stored query with the
+// overrides applied
+func regenerationRequest(entry HistoryEntry, overrides RegenerateRequest) LegalQueryRequest {
+	p := entry.Parameters
+	req := LegalQueryRequest{
+		Question:        entry.Question,
+		MaxIterations:   &p.MaxIterations,
+		TopK:            &p.TopK,
+		EnableWebSearch: &p.EnableWebSearch,
+		Model:           p.Model,
+		ResponseFormat:  p.ResponseFormat,
+		CitationStyle:   p.CitationStyle,
+		Style:           &p.Style,
+		Language:        p.Language,
+		Collection:      p.Collection,
+		IterationPolicy: p.IterationPolicy,
+	}
+
+	if overrides.MaxIterations != nil {
+		req.MaxIterations = overrides.MaxIterations
+	}
+	if overrides.TopK != nil {
+		req.TopK = overrides.TopK
+	}
+	if overrides.EnableWebSearch != nil {
+		req.EnableWebSearch = overrides.EnableWebSearch
+	}
+	if overrides.Model != "" {
+		req.Model = overrides.Model
+	}
+	if overrides.ResponseFormat != "" {
+		req.ResponseFormat = overrides.ResponseFormat
+	}
+	if overrides.CitationStyle != "" {
+		req.CitationStyle = overrides.CitationStyle
+	}
+	if overrides.IterationPolicy != "" {
+		req.IterationPolicy = overrides.IterationPolicy
+	}
+	if overrides.Style != nil {
+		style := p.Style
+		if overrides.Style.Length != "" {
+			style.Length = overrides.Style.Length
+		}
+		if overrides.Style.Tone != "" {
+			style.Tone = overrides.Style.Tone
+		}
+		if overrides.Style.Audience != "" {
+			style.Audience = overrides.Style.Audience
+		}
+		req.Style = &style
+	}
+	return req
+}
+
+func regenerateHandler(deps queryDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		var overrides RegenerateRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&overrides); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+				return
+			}
+		}
+
+		tenant, _ := callerTenant(c)
+		original, err := deps.history.Get(c.Param("id"), tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", c.Param("id")))
+			return
+		}
+
+		plan := callerPlan(c)
+		req := regenerationRequest(original, overrides)
+		if violations := validateQueryRequest(&req, plan); len(violations) > 0 {
+			abortWithViolations(c, violations)
+			return
+		}
+		if !deps.checkCollection(c, &req) {
+			return
+		}
+		warnings := clampQueryRequest(&req, plan)
+		if original.Parameters.ContextDocuments > 0 {
+			warnings = append(warnings, engine.Warning{
+				Field:   "context_documents",
+				Code:    WarningContextDropped,
+				Message: fmt.Sprintf("the original query used %d context documents, which are not kept in the history; the answer was regenerated without them", original.Parameters.ContextDocuments),
+			})
+		}
+
+		pythonReq := deps.engineRequest(&req, QueryDefaults{}, plan)
+		resp, err := deps.queryEngine(c, pythonReq)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error calling Python AI Engine", "error", err)
+			abortWithEngineError(c, err, fmt.Sprintf("Failed to regenerate answer: %v", err))
+			return
+		}
+		resp.Meta = newResponseMeta(pythonReq, resp, deps.primaryRegion, false)
+		postWarnings, err := deps.postProcessors.Run(c.Request.Context(), &PostProcessInput{Question: req.Question, TenantID: tenant.ID, Response: resp})
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Post-processing rejected the regenerated answer", "error", err)
+			abortWithError(c, postProcessErrorCode(err), err.Error())
+			return
+		}
+		deps.finishAnswer(c.Request.Context(), resp, pythonReq.CitationStyle)
+		warnings = append(warnings, postWarnings...)
+
+		regenerated := deps.record(tenant.ID, callerUser(c), pythonReq, resp, started, original.ID)
+		slog.InfoContext(c.Request.Context(), "Regenerated answer", "original", original.ID, "regenerated", regenerated.ID)
+		c.JSON(http.StatusOK, RegenerateResponse{
+			Original:    original,
+			Regenerated: regenerated,
+			Warnings:    warnings,
+		})
+	}
+}
+
+func getHistoryHandler(history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		entry, err := history.Get(c.Param("id"), tenant.ID)
+		if errors.Is(err, errHistoryNotFound) {
+			abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to read history entry", "id", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeHistoryUnavailable, "Failed to read the query history")
+			return
+		}
+		c.JSON(http.StatusOK, entry)
+	}
+}
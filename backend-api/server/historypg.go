@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres"
+)
+
+// historyMigrations is the schema of the history kept in Postgres. Applied
+// migrations must never change; add new ones instead.
+var historyMigrations = []postgres.Migration{
+	{Version: 1, Name: "create history", SQL: `
+CREATE TABLE history (
+	id text PRIMARY KEY,
+	tenant_id text NOT NULL DEFAULT '',
+	user_id text NOT NULL DEFAULT '',
+	question text NOT NULL,
+	parameters jsonb NOT NULL,
+	response jsonb NOT NULL,
+	duration_ms bigint NOT NULL,
+	topics jsonb NOT NULL DEFAULT '[]',
+	regenerated_from text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL
+);
+CREATE INDEX history_tenant_created ON history (tenant_id, created_at DESC, id DESC);
+CREATE INDEX history_tenant_user_created ON history (tenant_id, user_id, created_at DESC);
+`},
+}
+
+const (
+	historyColumns   = `id, tenant_id, user_id, question, parameters, response, duration_ms, topics, regenerated_from, created_at`
+	insertHistorySQL = `INSERT INTO history (` + historyColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	recentHistorySQL = `SELECT ` + historyColumns + ` FROM history ORDER BY created_at DESC, id DESC LIMIT $1`
+	getHistorySQL    = `SELECT ` + historyColumns + ` FROM history WHERE id = $1 AND tenant_id = $2`
+	listHistorySQL   = `SELECT ` + historyColumns + ` FROM history WHERE tenant_id = $1`
+)
+
+// historyRow is a row of the history table
+type historyRow struct {
+	ID              string                    `db:"id"`
+	TenantID        string                    `db:"tenant_id"`
+	UserID          string                    `db:"user_id"`
+	Question        string                    `db:"question"`
+	Parameters      QueryParameters           `db:"parameters"`
+	Response        engine.LegalQueryResponse `db:"response"`
+	DurationMs      int64                     `db:"duration_ms"`
+	Topics          []string                  `db:"topics"`
+	RegeneratedFrom string                    `db:"regenerated_from"`
+	CreatedAt       time.Time                 `db:"created_at"`
+}
+
+func (r *historyRow) entry() HistoryEntry {
+	return HistoryEntry{
+		ID:              r.ID,
+		TenantID:        r.TenantID,
+		User:            r.UserID,
+		Question:        r.Question,
+		Parameters:      r.Parameters,
+		Response:        r.Response,
+		DurationMs:      r.DurationMs,
+		Topics:          r.Topics,
+		RegeneratedFrom: r.RegeneratedFrom,
+		CreatedAt:       r.CreatedAt,
+	}
+}
+
+// NewPostgresHistoryStore keeps the history in Postgres, migrating its
+// schema first, and the maxEntries most recent queries in memory
+func NewPostgresHistoryStore(db *pgxpool.Pool, maxEntries int) (*HistoryStore, error) {
+	if _, err := postgres.Migrate(db, historyMigrations); err != nil {
+		return nil, fmt.Errorf("failed to migrate history: %w", err)
+	}
+	store := &HistoryStore{
+		db:         db,
+		maxEntries: maxEntries,
+		byID:       make(map[string]*HistoryEntry),
+	}
+	entries, err := store.query(recentHistorySQL, maxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		store.appendLocked(&entries[i])
+	}
+	return store, nil
+}
+
+func (s *HistoryStore) insert(e HistoryEntry) error {
+	ctx, cancel := postgres.Context()
+	defer cancel()
+	topics := append([]string{}, e.Topics...)
+	if _, err := s.db.Exec(ctx, insertHistorySQL, e.ID, e.TenantID, e.User, e.Question, e.Parameters, e.Response, e.DurationMs, topics, e.RegeneratedFrom, e.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert history entry: %w", err)
+	}
+	return nil
+}
+
+// get reads an entry no longer kept in memory
+func (s *HistoryStore) get(id, tenantID string) (HistoryEntry, error) {
+	entries, err := s.query(getHistorySQL, id, tenantID)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	if len(entries) == 0 {
+		return HistoryEntry{}, errHistoryNotFound
+	}
+	return entries[0], nil
+}
+
+func (s *HistoryStore) list(tenantID string, limit int, filter HistoryFilter) ([]HistoryEntry, error) {
+	query := listHistorySQL
+	args := []any{tenantID}
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		query += " AND " + strings.ReplaceAll(condition, "$n", fmt.Sprintf("$%d", len(args)))
+	}
+	if filter.Topic != "" {
+		where("topics @> jsonb_build_array($n::text)", filter.Topic)
+	}
+	if filter.Query != "" {
+		where("strpos(lower(question), lower($n)) > 0", filter.Query)
+	}
+	if filter.User != "" {
+		where("user_id = $n", filter.User)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $n", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $n", filter.To)
+	}
+	if filter.Before != "" {
+		where("(created_at, id) < (SELECT created_at, id FROM history WHERE id = $n AND tenant_id = $1)", filter.Before)
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	entries, err := s.query(query, args...)
+	if entries == nil && err == nil {
+		entries = []HistoryEntry{}
+	}
+	return entries, err
+}
+
+// query runs a statement selecting history rows
+func (s *HistoryStore) query(query string, args ...any) ([]HistoryEntry, error) {
+	ctx, cancel := postgres.Context()
+	defer cancel()
+	rows, _ := s.db.Query(ctx, query, args...)
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (HistoryEntry, error) {
+		r, err := pgx.RowToStructByName[historyRow](row)
+		return r.entry(), err
+	})
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres/pgtest"
+)
+
+type historyPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+func TestHistoryPagination(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Bộ luật Lao động 2019...", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	for i, user := range []string{"lan", "minh", "lan"} {
+		question := "Câu hỏi số " + strconv.Itoa(i+1) + " về thời gian thử việc theo Bộ luật Lao động 2019?"
+		if rec := doAs(t, h, user, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question}); rec.Code != http.StatusOK {
+			t.Fatalf("query status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	list := func(query string) historyPage {
+		t.Helper()
+		var page historyPage
+		rec := doJSON(t, h, http.MethodGet, "/api/history?"+query, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("history?%s = %d %s", query, rec.Code, rec.Body.String())
+		}
+		return page
+	}
+
+	first := list("limit=2")
+	if len(first.Entries) != 2 || first.Entries[0].User != "lan" || first.Entries[1].User != "minh" || first.NextCursor != first.Entries[1].ID {
+		t.Fatalf("first page = %+v, want the two newest entries and a cursor", first)
+	}
+	second := list("limit=2&cursor=" + first.NextCursor)
+	if len(second.Entries) != 1 || !strings.Contains(second.Entries[0].Question, "số 1") || second.NextCursor != "" {
+		t.Errorf("second page = %+v, want the oldest entry and no cursor", second)
+	}
+
+	if page := list("user=lan"); len(page.Entries) != 2 {
+		t.Errorf("user=lan = %d entries, want 2", len(page.Entries))
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if page := list("from=" + today + "&to=" + today); len(page.Entries) != 3 {
+		t.Errorf("today = %d entries, want all 3", len(page.Entries))
+	}
+	if page := list("to=" + time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)); len(page.Entries) != 0 {
+		t.Errorf("until yesterday = %d entries, want none", len(page.Entries))
+	}
+	if page := list("cursor=q_unknown"); len(page.Entries) != 0 {
+		t.Errorf("unknown cursor = %d entries, want none", len(page.Entries))
+	}
+	if code := decodeError(t, doJSON(t, h, http.MethodGet, "/api/history?from=yesterday", nil)).Code; code != ErrCodeInvalidParam {
+		t.Errorf("invalid from = %s, want %s", code, ErrCodeInvalidParam)
+	}
+}
+
+// historyDB emulates the history table behind the statements the store runs
+type historyDB struct {
+	mu         sync.Mutex
+	rows       [][]*string
+	migrations []string
+	lastList   string
+	lastArgs   []*string
+}
+
+// historyTypes are the types of historyColumns
+var historyTypes = []string{"text", "text", "text", "text", "jsonb", "jsonb", "int8", "jsonb", "text", "timestamptz"}
+
+// historyParam is a parameter of a history query with the column, or
+// keyword, before it
+var historyParam = regexp.MustCompile(`(\w+)\W*\$(\d+)`)
+
+// describe describes the statements of Migrate and of the store, as
+// Postgres would from the schema
+func (db *historyDB) describe(query string) (pgtest.Statement, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_"):
+		return pgtest.Statement{Params: []string{"int8"}}, nil
+	case query == "SELECT version FROM schema_migrations":
+		return pgtest.Statement{Columns: []string{"version"}, Types: []string{"int4"}}, nil
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		return pgtest.Statement{Params: []string{"int4", "text"}}, nil
+	case query == insertHistorySQL:
+		return pgtest.Statement{Params: historyTypes}, nil
+	case strings.HasPrefix(query, "SELECT "+historyColumns+" "):
+		var params []string
+		for _, m := range historyParam.FindAllStringSubmatch(query, -1) {
+			n, _ := strconv.Atoi(m[2])
+			params = append(params, make([]string, max(n-len(params), 0))...)
+			switch m[1] {
+			case "created_at":
+				params[n-1] = "timestamptz"
+			case "LIMIT":
+				params[n-1] = "int8"
+			default:
+				params[n-1] = "text"
+			}
+		}
+		return pgtest.Statement{Params: params, Columns: strings.Split(historyColumns, ", "), Types: historyTypes}, nil
+	}
+	return pgtest.Statement{}, fmt.Errorf("unexpected statement %q", query)
+}
+
+func (db *historyDB) handle(query string, args []*string) (pgtest.Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	result := func(rows [][]*string) pgtest.Result {
+		return pgtest.Result{Columns: strings.Split(historyColumns, ", "), Types: historyTypes, Rows: rows}
+	}
+	switch {
+	case query == insertHistorySQL:
+		db.rows = append(db.rows, args)
+		return pgtest.Result{Tag: "INSERT 0 1"}, nil
+	case query == getHistorySQL:
+		for _, row := range db.rows {
+			if *row[0] == *args[0] {
+				return result([][]*string{row}), nil
+			}
+		}
+		return result(nil), nil
+	case strings.HasPrefix(query, listHistorySQL):
+		db.lastList, db.lastArgs = query, args
+		rows := slices.Clone(db.rows)
+		slices.Reverse(rows)
+		limit, _ := strconv.Atoi(*args[len(args)-1])
+		return result(rows[:min(limit, len(rows))]), nil
+	case query == recentHistorySQL:
+		return result(nil), nil
+	case strings.Contains(query, "CREATE TABLE history"):
+		db.migrations = append(db.migrations, query)
+	}
+	return pgtest.Result{}, nil
+}
+
+func TestPostgresHistory(t *testing.T) {
+	db := &historyDB{}
+	pg, err := pgtest.NewServer(db.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+	pg.SetPassword("s3cret")
+	pg.SetDescriber(db.describe)
+	t.Setenv("DATABASE_URL", pg.URL())
+	t.Setenv("HISTORY_MAX_ENTRIES", "1")
+
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Bộ luật Lao động 2019...", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+	if len(db.migrations) != 1 || !strings.Contains(db.migrations[0], "CREATE TABLE history") {
+		t.Fatalf("migrations = %q, want the history table created", db.migrations)
+	}
+
+	var ids []string
+	for _, user := range []string{"lan", "minh"} {
+		rec := doAs(t, h, user, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày?"})
+		var resp engine.LegalQueryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("query status = %d: %s", rec.Code, rec.Body.String())
+		}
+		ids = append(ids, resp.HistoryID)
+	}
+
+	// The first entry no longer fits in memory and is read from Postgres
+	var entry HistoryEntry
+	rec := doJSON(t, h, http.MethodGet, "/api/history/"+ids[0], nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil || entry.ID != ids[0] || entry.User != "lan" || entry.Response.Answer == "" ||
+		entry.Parameters.TopK != 3 || time.Since(entry.CreatedAt) > time.Minute {
+		t.Errorf("get = %d %s, want the first entry", rec.Code, rec.Body.String())
+	}
+
+	var page historyPage
+	rec = doJSON(t, h, http.MethodGet, "/api/history?limit=1&user=minh&from=2026-01-01&topic=lao-dong&cursor="+ids[1], nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || len(page.Entries) != 1 || page.Entries[0].ID != ids[1] || page.NextCursor != ids[1] {
+		t.Errorf("list = %d %s, want one entry and a cursor", rec.Code, rec.Body.String())
+	}
+	for _, condition := range []string{"topics @> jsonb_build_array($2::text)", "user_id = $3", "created_at >= $4", "WHERE id = $5 AND tenant_id = $1", "LIMIT $6"} {
+		if !strings.Contains(db.lastList, condition) {
+			t.Errorf("list query %q lacks %q", db.lastList, condition)
+		}
+	}
+	if len(db.lastArgs) != 6 || *db.lastArgs[2] != "minh" || *db.lastArgs[5] != "2" {
+		t.Errorf("list args = %v, want minh and a limit of 2", db.lastArgs)
+	}
+
+	pg.SetDown(true)
+	if code := decodeError(t, doJSON(t, h, http.MethodGet, "/api/history", nil)).Code; code != ErrCodeHistoryUnavailable {
+		t.Errorf("list while Postgres is down = %s, want %s", code, ErrCodeHistoryUnavailable)
+	}
+	if rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Người lao động được nghỉ phép năm bao nhiêu ngày theo Bộ luật Lao động 2019?"}); rec.Code != http.StatusOK {
+		t.Errorf("query while Postgres is down = %d, want the answer anyway", rec.Code)
+	}
+}
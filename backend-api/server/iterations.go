@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// Iteration policy modes
+const (
+	// IterationFixed runs retrieval until max_iterations or until the agent
+	// decides to answer
+	IterationFixed = "fixed"
+
+	// IterationAdaptive additionally stops once retrieval novelty and
+	// confidence plateau; max_iterations stays the upper bound
+	IterationAdaptive = "adaptive"
+)
+
+// loadIterationPolicy reads the server-wide iteration policy
+func loadIterationPolicy() engine.IterationPolicy {
+	policy := engine.IterationPolicy{
+		Mode:         IterationAdaptive,
+		MinNovelty:   settings.FloatInRange("ADAPTIVE_MIN_NOVELTY", 0.34, 0, 1),
+		MinScoreGain: settings.FloatInRange("ADAPTIVE_MIN_SCORE_GAIN", 0.02, 0, 1),
+		Patience:     settings.IntInRange("ADAPTIVE_PATIENCE", 1, 1, engineMaxIterations),
+	}
+	switch mode := strings.ToLower(settings.Get("ITERATION_POLICY")); mode {
+	case "":
+	case IterationFixed, IterationAdaptive:
+		policy.Mode = mode
+	default:
+		settings.Warn("ITERATION_POLICY", "unknown ITERATION_POLICY %q, using %q", mode, policy.Mode)
+	}
+	return policy
+}
+
+// validateIterationPolicy reports an unknown iteration_policy mode
+func validateIterationPolicy(mode string) []Violation {
+	if mode == "" || mode == IterationFixed || mode == IterationAdaptive {
+		return nil
+	}
+	return []Violation{{
+		Field:   "iteration_policy",
+		Code:    ViolationOutOfRange,
+		Message: fmt.Sprintf("iteration_policy must be one of: %s, %s", IterationAdaptive, IterationFixed),
+	}}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// JobConfig controls asynchronous query jobs. Workers jobs run at once and
+// up to QueueSize more wait for a worker; a finished job is kept for TTL.
+type JobConfig struct {
+	Workers   int
+	QueueSize int
+	TTL       time.Duration
+}
+
+// JobStatus is the stage of an asynchronous query job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// queryJobKey stores the run of an asynchronous job in its context
+const queryJobKey = "query_job"
+
+// QueryJob is a legal query answered in the background. Result is set once
+// it succeeded, Error once it failed.
+type QueryJob struct {
+	ID         string                     `json:"id"`
+	TenantID   string                     `json:"tenant_id,omitempty"`
+	User       string                     `json:"user,omitempty"`
+	Status     JobStatus                  `json:"status"`
+	Question   string                     `json:"question"`
+	Result     *engine.LegalQueryResponse `json:"result,omitempty"`
+	Error      *ErrorResponse             `json:"error,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	StartedAt  *time.Time                 `json:"started_at,omitempty"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+// jobRun collects the error response of a job, which abortWithError
+// records instead of writing it
+type jobRun struct {
+	err *ErrorResponse
+}
+
+func (r *jobRun) fail(resp ErrorResponse) {
+	r.err = &resp
+}
+
+// jobTask is a queued job with the function answering it
+type jobTask struct {
+	id  string
+	run func() (*engine.LegalQueryResponse, *ErrorResponse)
+}
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobQueueFull = errors.New("job queue full")
+)
+
+// QueryJobs runs asynchronous query jobs on a pool of workers. Jobs are
+// kept in memory: those queued or running when the server stops are lost,
+// but their answers, like every answer, are in the history.
+type QueryJobs struct {
+	mu            sync.Mutex
+	jobs          map[string]*QueryJob
+	queue         chan jobTask
+	ttl           time.Duration
+	notifications *NotificationCenter
+	now           func() time.Time
+}
+
+func NewQueryJobs(config JobConfig, notifications *NotificationCenter) *QueryJobs {
+	return &QueryJobs{
+		jobs:          make(map[string]*QueryJob),
+		queue:         make(chan jobTask, config.QueueSize),
+		ttl:           config.TTL,
+		notifications: notifications,
+		now:           time.Now,
+	}
+}
+
+// Start runs workers goroutines answering queued jobs until stop is closed
+func (j *QueryJobs) Start(workers int, stop <-chan struct{}) {
+	for range workers {
+		go func() {
+			for {
+				select {
+				case task := <-j.queue:
+					j.execute(task)
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues a job answering question with run
+func (j *QueryJobs) Submit(tenantID, user, question string, run func() (*engine.LegalQueryResponse, *ErrorResponse)) (QueryJob, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.sweepLocked()
+	job := &QueryJob{
+		ID:        "job_" + randomHex(12),
+		TenantID:  tenantID,
+		User:      user,
+		Status:    JobQueued,
+		Question:  question,
+		CreatedAt: j.now().UTC(),
+	}
+	select {
+	case j.queue <- jobTask{id: job.ID, run: run}:
+	default:
+		return QueryJob{}, errJobQueueFull
+	}
+	j.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Get returns a job of tenantID
+func (j *QueryJobs) Get(id, tenantID string) (QueryJob, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.sweepLocked()
+	job, ok := j.jobs[id]
+	if !ok || job.TenantID != tenantID {
+		return QueryJob{}, errJobNotFound
+	}
+	return *job, nil
+}
+
+// sweepLocked drops the jobs that finished more than the TTL ago
+func (j *QueryJobs) sweepLocked() {
+	now := j.now()
+	for id, job := range j.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > j.ttl {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+func (j *QueryJobs) execute(task jobTask) {
+	j.update(task.id, func(job *QueryJob) {
+		started := j.now().UTC()
+		job.Status, job.StartedAt = JobRunning, &started
+	})
+
+	resp, errResp := task.run()
+
+	job := j.update(task.id, func(job *QueryJob) {
+		finished := j.now().UTC()
+		job.FinishedAt = &finished
+		if errResp != nil || resp == nil {
+			job.Status, job.Error = JobFailed, errResp
+			if job.Error == nil {
+				job.Error = &ErrorResponse{Error: "internal_error", Code: ErrCodeInternal, Message: "The job ended without an answer"}
+			}
+			return
+		}
+		job.Status, job.Result = JobSucceeded, resp
+	})
+	slog.Info("Job finished", "job", job.ID, "status", job.Status, "duration", job.FinishedAt.Sub(*job.StartedAt).String())
+
+	if job.User == "" {
+		return
+	}
+	j.notifications.Publish(Notification{
+		TenantID: job.TenantID,
+		User:     job.User,
+		Kind:     NotificationJobCompleted,
+		Title:    fmt.Sprintf("Query job %s", job.Status),
+		Body:     truncateUTF8(job.Question, 500),
+		Link:     "/api/jobs/" + job.ID,
+	})
+}
+
+// update applies fn to a job and returns a copy of the result
+func (j *QueryJobs) update(id string, fn func(*QueryJob)) QueryJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job := j.jobs[id]
+	fn(job)
+	return *job
+}
+
+// Handlers
+
+// asyncLegalQueryHandler queues a legal query and answers 202 with the job
+// at once, for clients behind proxies that time out before long queries
+// are answered. The query is validated before it is queued; every other
+// error fails the job.
+func asyncLegalQueryHandler(deps queryDeps, jobs *QueryJobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LegalQueryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		violations := validateQueryRequest(&req, callerPlan(c))
+		violations = append(violations, validateAttachments(req.Attachments, deps.attachmentLimits)...)
+		violations = append(violations, validateContextURLs(req.ContextURLs, deps.fetcher.limits.MaxCount)...)
+		if len(violations) > 0 {
+			abortWithViolations(c, violations)
+			return
+		}
+		if !deps.checkCollection(c, &req) {
+			return
+		}
+
+		// The job outlives the request: it runs on a copy of the context
+		// that is not canceled when the response is sent
+		jc := c.Copy()
+		jc.Request = c.Request.WithContext(context.WithoutCancel(c.Request.Context()))
+		run := &jobRun{}
+		jc.Set(queryJobKey, run)
+
+		tenant, _ := callerTenant(c)
+		job, err := jobs.Submit(tenant.ID, callerUser(c), req.Question, func() (*engine.LegalQueryResponse, *ErrorResponse) {
+			resp, ok := deps.answerQuery(jc, &req, nil, nil)
+			if !ok {
+				return nil, run.err
+			}
+			return resp, nil
+		})
+		if err != nil {
+			abortWithError(c, ErrCodeJobQueueFull, "Too many queries are waiting to be answered; retry shortly")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Queued job", "job", job.ID)
+		c.Header("Location", "/api/jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+func getJobHandler(jobs *QueryJobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		job, err := jobs.Get(c.Param("id"), tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodeJobNotFound, fmt.Sprintf("Job %q not found or expired", c.Param("id")))
+			return
+		}
+		if job.Status == JobQueued || job.Status == JobRunning {
+			c.Header("Retry-After", "2")
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
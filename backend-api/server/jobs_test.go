@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestQueryJobs(t *testing.T) {
+	t.Setenv("JOB_WORKERS", "1")
+	t.Setenv("JOB_QUEUE_SIZE", "1")
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	blocking := &blockingEngine{
+		stubEngine: stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 180 ngày.", Iterations: 1}},
+		started:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	srv := newTestServer(t, Options{Engine: blocking})
+	defer srv.Close()
+	h := srv.Handler()
+
+	submit := func(question string) QueryJob {
+		t.Helper()
+		var job QueryJob
+		rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query/async", LegalQueryRequest{Question: question})
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil || rec.Code != http.StatusAccepted || rec.Header().Get("Location") != "/api/jobs/"+job.ID {
+			t.Fatalf("submit = %d %s, want 202 with the job", rec.Code, rec.Body.String())
+		}
+		return job
+	}
+	wait := func(id string) QueryJob {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var job QueryJob
+			rec := doAs(t, h, "lan", http.MethodGet, "/api/jobs/"+id, nil)
+			if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("poll = %d %s", rec.Code, rec.Body.String())
+			}
+			if job.Status == JobSucceeded || job.Status == JobFailed || time.Now().After(deadline) {
+				return job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The only worker is busy and the queue holds one job
+	slow := submit("Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày, xin chờ kết quả?")
+	<-blocking.started
+	queued := submit("Thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?")
+	if queued.Status != JobQueued {
+		t.Errorf("status = %s, want %s", queued.Status, JobQueued)
+	}
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query/async", LegalQueryRequest{Question: "Người lao động có được hưởng lương trong thời gian thử việc không?"})
+	if code := decodeError(t, rec).Code; rec.Code != http.StatusServiceUnavailable || code != ErrCodeJobQueueFull {
+		t.Errorf("submit to a full queue = %d %s, want 503 %s", rec.Code, code, ErrCodeJobQueueFull)
+	}
+	close(blocking.release)
+
+	for _, id := range []string{slow.ID, queued.ID} {
+		job := wait(id)
+		if job.Status != JobSucceeded || job.Result == nil || job.Result.Answer != "Không quá 180 ngày." || job.Result.HistoryID == "" || job.FinishedAt == nil {
+			t.Errorf("job %s = %+v, want the recorded answer", id, job)
+		}
+	}
+
+	// Invalid queries are refused before they are queued; engine errors
+	// fail the job
+	rec = doAs(t, h, "lan", http.MethodPost, "/api/legal-query/async", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?", ResponseFormat: "html"})
+	if code := decodeError(t, rec).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("invalid query = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+	blocking.mu.Lock()
+	blocking.err = errors.New("engine exploded")
+	blocking.mu.Unlock()
+	failed := wait(submit("Hợp đồng thử việc có bắt buộc phải lập thành văn bản không?").ID)
+	if failed.Status != JobFailed || failed.Error == nil || failed.Error.Code != ErrCodeEngineError || failed.Result != nil {
+		t.Errorf("failed job = %+v, want the engine error", failed)
+	}
+
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodGet, "/api/jobs/job_missing", nil)).Code; code != ErrCodeJobNotFound {
+		t.Errorf("unknown job = %s, want %s", code, ErrCodeJobNotFound)
+	}
+}
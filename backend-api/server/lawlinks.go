@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+//go:embed fixtures/law_links.json
+var lawLinksJSON []byte
+
+// LawLinkConfig configures the official links added to citations
+type LawLinkConfig struct {
+	Enabled bool
+
+	// CatalogFile replaces the built-in catalog of documents and their links
+	CatalogFile string
+
+	// Check enables dead-link detection; results are cached for CheckTTL
+	Check        bool
+	CheckTimeout time.Duration
+	CheckTTL     time.Duration
+}
+
+// Link statuses of a citation
+const (
+	// LinkVerified is a link that answered the last check
+	LinkVerified = "verified"
+
+	// LinkUnverified is a link that could not be checked, or checking is
+	// disabled
+	LinkUnverified = "unverified"
+
+	// LinkDead is a link that answered 404 or 410; it is returned only when
+	// every link of the document is dead
+	LinkDead = "dead"
+
+	// LinkUnresolved is a citation of a document missing from the catalog
+	LinkUnresolved = "unresolved"
+)
+
+// linkRecheckInterval is how long a link that could not be checked is left
+// alone before it is checked again
+const linkRecheckInterval = time.Minute
+
+var (
+	// documentNumberPattern matches official document numbers such as
+	// 45/2019/QH14 or 145/2020/NĐ-CP
+	documentNumberPattern = regexp.MustCompile(`\b\d{1,4}/\d{4}/[A-ZĐ][A-ZĐa-z0-9]*(?:-[A-ZĐ][A-ZĐa-z0-9]*)*`)
+
+	// articleDocumentGap is what may separate an article from the document
+	// it belongs to, as in "Điều 25 của Bộ luật Lao động 2019"
+	articleDocumentGap = regexp.MustCompile(`^[\s,]*(?i:của|thuộc|tại)?\s*$`)
+)
+
+// LawDocument is a legal document of the catalog with its official links,
+// preferred first
+type LawDocument struct {
+	Number  string   `json:"number"`
+	Title   string   `json:"title"`
+	Aliases []string `json:"aliases,omitempty"`
+
+	// DocumentID is the engine's ID of the document when it is in the corpus
+	DocumentID string `json:"document_id,omitempty"`
+
+	// Corpus marks the document that articles cited without a document
+	// belong to
+	Corpus bool     `json:"corpus,omitempty"`
+	URLs   []string `json:"urls"`
+}
+
+// LinkResolver maps the documents an answer cites to their official links
+type LinkResolver struct {
+	documents    []LawDocument
+	byNumber     map[string]*LawDocument
+	byDocumentID map[string]*LawDocument
+	corpus       *LawDocument
+	aliases      *regexp.Regexp
+	aliasOf      map[string]*LawDocument
+
+	// checker is nil when dead-link detection is disabled
+	checker *linkChecker
+}
+
+// NewLinkResolver loads the catalog. It returns nil when official links are
+// disabled.
+func NewLinkResolver(config LawLinkConfig) (*LinkResolver, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	data := lawLinksJSON
+	if config.CatalogFile != "" {
+		var err error
+		if data, err = os.ReadFile(config.CatalogFile); err != nil {
+			return nil, fmt.Errorf("failed to read law link catalog: %w", err)
+		}
+	}
+	var catalog struct {
+		Documents []LawDocument `json:"documents"`
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal law link catalog: %w", err)
+	}
+
+	r := &LinkResolver{
+		documents:    catalog.Documents,
+		byNumber:     make(map[string]*LawDocument),
+		byDocumentID: make(map[string]*LawDocument),
+		aliasOf:      make(map[string]*LawDocument),
+	}
+	var aliases []string
+	for i := range r.documents {
+		doc := &r.documents[i]
+		if doc.Number == "" || doc.Title == "" {
+			return nil, fmt.Errorf("law link catalog entry %d needs a number and a title", i)
+		}
+		for _, u := range doc.URLs {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("law link catalog entry %s has an invalid URL %q", doc.Number, u)
+			}
+		}
+		r.byNumber[normalizeDocumentNumber(doc.Number)] = doc
+		if doc.DocumentID != "" {
+			r.byDocumentID[doc.DocumentID] = doc
+		}
+		if doc.Corpus {
+			if r.corpus != nil {
+				return nil, fmt.Errorf("law link catalog has two corpus documents, %s and %s", r.corpus.Number, doc.Number)
+			}
+			r.corpus = doc
+		}
+		for _, alias := range doc.Aliases {
+			r.aliasOf[strings.ToLower(alias)] = doc
+			aliases = append(aliases, regexp.QuoteMeta(alias))
+		}
+	}
+	if len(aliases) > 0 {
+		// Longer aliases first, so "Bộ luật Lao động năm 2019" wins over a
+		// shorter alias it contains
+		sort.Slice(aliases, func(i, j int) bool { return len(aliases[i]) > len(aliases[j]) })
+		r.aliases = regexp.MustCompile(`(?i)` + strings.Join(aliases, "|"))
+	}
+	if config.Check {
+		r.checker = newLinkChecker(config.CheckTimeout, config.CheckTTL)
+	}
+	return r, nil
+}
+
+// normalizeDocumentNumber folds the spellings of a document number, e.g.
+// 145/2020/nđ-cp and 145/2020/ND-CP
+func normalizeDocumentNumber(number string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(number)), "Đ", "D")
+}
+
+// lawRef is a mention of a document in the answer text
+type lawRef struct {
+	start, end int
+	number     string
+	doc        *LawDocument
+}
+
+// documentRefs finds the documents mentioned in text, by number or alias
+func (r *LinkResolver) documentRefs(text string) []lawRef {
+	var refs []lawRef
+	for _, m := range documentNumberPattern.FindAllStringIndex(text, -1) {
+		number := text[m[0]:m[1]]
+		refs = append(refs, lawRef{start: m[0], end: m[1], number: number, doc: r.byNumber[normalizeDocumentNumber(number)]})
+	}
+	if r.aliases != nil {
+		for _, m := range r.aliases.FindAllStringIndex(text, -1) {
+			doc := r.aliasOf[strings.ToLower(text[m[0]:m[1]])]
+			if doc != nil {
+				refs = append(refs, lawRef{start: m[0], end: m[1], number: doc.Number, doc: doc})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].start != refs[j].start {
+			return refs[i].start < refs[j].start
+		}
+		return refs[i].end > refs[j].end
+	})
+
+	// "Nghị định 145/2020/NĐ-CP" matches both an alias and a number
+	var kept []lawRef
+	for _, ref := range refs {
+		if len(kept) > 0 && ref.start < kept[len(kept)-1].end {
+			continue
+		}
+		kept = append(kept, ref)
+	}
+	return kept
+}
+
+// citationMention is a citation in an answer: an article, spanning the
+// document named after it, or a document alone
+type citationMention struct {
+	start, end int
+
+	// number is the document number; doc is nil for documents missing from
+	// the catalog
+	number  string
+	doc     *LawDocument
+	article string
+}
+
+func (m citationMention) key() string {
+	return normalizeDocumentNumber(m.number) + "|" + m.article
+}
+
+// label names the cited article or document, e.g. "Điều 25 Bộ luật Lao
+// động 2019"
+func (m citationMention) label() string {
+	name := m.number
+	if m.doc != nil {
+		name = m.doc.Title
+	}
+	return strings.TrimSpace(m.article + " " + name)
+}
+
+// mentions finds the citations in text. An article belongs to the document
+// named right after it, or to the corpus; documents named elsewhere are
+// cited as a whole unless one of their articles is. A nil resolver knows no
+// documents.
+func (r *LinkResolver) mentions(text string) []citationMention {
+	if r == nil {
+		r = &LinkResolver{}
+	}
+	var mentions []citationMention
+	refs := r.documentRefs(text)
+	bound := make(map[int]bool)
+	withArticles := make(map[string]bool)
+	for _, m := range articleCitationPattern.FindAllStringSubmatchIndex(text, -1) {
+		mention := citationMention{start: m[0], end: m[1], doc: r.corpus, article: "Điều " + text[m[2]:m[3]]}
+		for i, ref := range refs {
+			if ref.start >= m[1] && articleDocumentGap.MatchString(text[m[1]:ref.start]) {
+				mention.end, mention.number, mention.doc = ref.end, ref.number, ref.doc
+				bound[i] = true
+				break
+			}
+		}
+		if mention.doc != nil {
+			mention.number = mention.doc.Number
+		}
+		mentions = append(mentions, mention)
+		withArticles[normalizeDocumentNumber(mention.number)] = true
+	}
+	for i, ref := range refs {
+		mention := citationMention{start: ref.start, end: ref.end, number: ref.number, doc: ref.doc}
+		if ref.doc != nil {
+			mention.number = ref.doc.Number
+		}
+		if !bound[i] && !withArticles[normalizeDocumentNumber(mention.number)] {
+			mentions = append(mentions, mention)
+		}
+	}
+	sort.SliceStable(mentions, func(i, j int) bool { return mentions[i].start < mentions[j].start })
+	return mentions
+}
+
+// Resolve lists the documents and articles resp cites, in the answer text
+// then in its search results, each with the link to its official text
+func (r *LinkResolver) Resolve(ctx context.Context, resp *engine.LegalQueryResponse) []engine.Citation {
+	if r == nil || resp.NeedsClarification {
+		return nil
+	}
+
+	var citations []engine.Citation
+	var docs []*LawDocument
+	index := make(map[string]int)
+	add := func(m citationMention) int {
+		if i, ok := index[m.key()]; ok {
+			return i
+		}
+		citations = append(citations, engine.Citation{Label: m.label(), Document: m.number, Article: m.article})
+		docs = append(docs, m.doc)
+		index[m.key()] = len(citations) - 1
+		return len(citations) - 1
+	}
+
+	for _, m := range r.mentions(resp.Answer) {
+		citations[add(m)].InAnswer = true
+	}
+	for i, result := range resp.SearchResults {
+		if m, ok := r.resultMention(result); ok {
+			c := &citations[add(m)]
+			c.ResultIndexes = append(c.ResultIndexes, i)
+		}
+	}
+
+	r.addLinks(ctx, citations, docs)
+	return citations
+}
+
+// resultMention is the article a search result was retrieved from, if it is
+// an article. A nil resolver knows no documents.
+func (r *LinkResolver) resultMention(result engine.DocumentHit) (citationMention, bool) {
+	if r == nil {
+		r = &LinkResolver{}
+	}
+	id := resultArticleID(result)
+	if !strings.HasPrefix(id, "Dieu_") {
+		return citationMention{}, false
+	}
+	doc := r.resultDocument(result)
+	m := citationMention{doc: doc, article: "Điều " + strings.TrimPrefix(id, "Dieu_")}
+	if doc != nil {
+		m.number = doc.Number
+	}
+	return m, true
+}
+
+// resultDocument is the catalog document of a search result: the document
+// it names, or else the corpus document
+func (r *LinkResolver) resultDocument(result engine.DocumentHit) *LawDocument {
+	if r == nil {
+		return nil
+	}
+	if result.DocumentID != "" {
+		return r.byDocumentID[result.DocumentID]
+	}
+	return r.corpus
+}
+
+// addLinks resolves the link of each cited document once, checking the
+// documents concurrently
+func (r *LinkResolver) addLinks(ctx context.Context, citations []engine.Citation, docs []*LawDocument) {
+	type link struct{ url, status string }
+	var unique []*LawDocument
+	for _, doc := range docs {
+		if doc != nil && !slices.Contains(unique, doc) {
+			unique = append(unique, doc)
+		}
+	}
+	links := make(map[*LawDocument]link)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, doc := range unique {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, status := r.link(ctx, doc)
+			mu.Lock()
+			links[doc] = link{u, status}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for i, doc := range docs {
+		citations[i].LinkStatus = LinkUnresolved
+		if l, ok := links[doc]; ok {
+			citations[i].URL, citations[i].LinkStatus = l.url, l.status
+		}
+	}
+}
+
+// link returns the first live link of doc. A dead link is returned only
+// when no other link might work.
+func (r *LinkResolver) link(ctx context.Context, doc *LawDocument) (string, string) {
+	if len(doc.URLs) == 0 {
+		return "", LinkUnresolved
+	}
+	if r.checker == nil {
+		return doc.URLs[0], LinkUnverified
+	}
+	unverified := ""
+	for _, u := range doc.URLs {
+		switch r.checker.check(ctx, u) {
+		case LinkVerified:
+			return u, LinkVerified
+		case LinkUnverified:
+			if unverified == "" {
+				unverified = u
+			}
+		}
+	}
+	if unverified != "" {
+		return unverified, LinkUnverified
+	}
+	return doc.URLs[0], LinkDead
+}
+
+// LawLinkStatus is a catalog document with the last check of its links
+type LawLinkStatus struct {
+	Number string            `json:"number"`
+	Title  string            `json:"title"`
+	Links  []LinkCheckResult `json:"links"`
+}
+
+// LinkCheckResult is the last check of a link
+type LinkCheckResult struct {
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// Status lists the catalog with the last known status of every link
+func (r *LinkResolver) Status() []LawLinkStatus {
+	statuses := make([]LawLinkStatus, 0, len(r.documents))
+	for _, doc := range r.documents {
+		status := LawLinkStatus{Number: doc.Number, Title: doc.Title, Links: []LinkCheckResult{}}
+		for _, u := range doc.URLs {
+			result := LinkCheckResult{URL: u, Status: LinkUnverified}
+			if check, ok := r.checker.last(u); ok {
+				result.Status = check.status
+				result.CheckedAt = &check.checkedAt
+			}
+			status.Links = append(status.Links, result)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// linkChecker detects dead links, caching the result of each check
+type linkChecker struct {
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	results map[string]linkCheck
+}
+
+type linkCheck struct {
+	status    string
+	checkedAt time.Time
+	expires   time.Time
+}
+
+func newLinkChecker(timeout, ttl time.Duration) *linkChecker {
+	return &linkChecker{
+		client:  &http.Client{Timeout: timeout},
+		ttl:     ttl,
+		results: make(map[string]linkCheck),
+	}
+}
+
+// last returns the cached check of u, if any
+func (c *linkChecker) last(u string) (linkCheck, bool) {
+	if c == nil {
+		return linkCheck{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	check, ok := c.results[u]
+	return check, ok
+}
+
+// check returns the status of u, from the cache while it is fresh. Links
+// that could not be checked are retried after linkRecheckInterval.
+func (c *linkChecker) check(ctx context.Context, u string) string {
+	if check, ok := c.last(u); ok && time.Now().Before(check.expires) {
+		return check.status
+	}
+
+	status := c.probe(ctx, u)
+	now := time.Now()
+	ttl := c.ttl
+	if status == LinkUnverified {
+		ttl = linkRecheckInterval
+	}
+	c.mu.Lock()
+	previous := c.results[u]
+	c.results[u] = linkCheck{status: status, checkedAt: now, expires: now.Add(ttl)}
+	c.mu.Unlock()
+	if status == LinkDead && previous.status != LinkDead {
+		slog.WarnContext(ctx, "Official link is dead", "url", u)
+	}
+	return status
+}
+
+// probe requests u with HEAD, falling back to GET for servers that do not
+// allow HEAD
+func (c *linkChecker) probe(ctx context.Context, u string) string {
+	code, err := c.request(ctx, http.MethodHead, u)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = c.request(ctx, http.MethodGet, u)
+	}
+	switch {
+	case err != nil:
+		return LinkUnverified
+	case code < http.StatusBadRequest:
+		return LinkVerified
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return LinkDead
+	default:
+		return LinkUnverified
+	}
+}
+
+func (c *linkChecker) request(ctx context.Context, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "legal-rag-link-checker")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Handlers
+
+func lawLinksHandler(resolver *LinkResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if resolver == nil {
+			c.JSON(http.StatusOK, gin.H{"enabled": false, "documents": []LawLinkStatus{}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "check": resolver.checker != nil, "documents": resolver.Status()})
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestLinkResolver(t *testing.T) {
+	var checks atomic.Int32
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		switch r.URL.Path {
+		case "/moved":
+			http.NotFound(w, r)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}
+	}))
+	defer site.Close()
+
+	catalog := `{"documents": [
+		{"number": "45/2019/QH14", "title": "Bộ luật Lao động 2019", "aliases": ["Bộ luật Lao động 2019"], "document_id": "BoLuatLaoDong2019", "corpus": true,
+		 "urls": ["` + site.URL + `/moved", "` + site.URL + `/blld"]},
+		{"number": "145/2020/NĐ-CP", "title": "Nghị định 145/2020/NĐ-CP", "aliases": ["Nghị định 145/2020"], "urls": ["` + site.URL + `/no-head"]}
+	]}`
+	path := filepath.Join(t.TempDir(), "law_links.json")
+	if err := os.WriteFile(path, []byte(catalog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := NewLinkResolver(LawLinkConfig{Enabled: true, CatalogFile: path, Check: true, CheckTimeout: time.Second, CheckTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("NewLinkResolver: %v", err)
+	}
+
+	resp := &engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thử việc không quá 60 ngày; Điều 7 của Nghị định 145/2020/NĐ-CP hướng dẫn thêm. " +
+			"Xem thêm Thông tư 10/2020/TT-BLĐTBXH và Bộ luật Lao động 2019.",
+		SearchResults: []engine.DocumentHit{
+			{Excerpt: "Điều 25", ArticleNumber: "Dieu_25"},
+			{Excerpt: "Điều 24", ArticleNumber: "Dieu_24"},
+			{Excerpt: "Điều 25", Article: "Điều 25"},
+		},
+	}
+	citations := resolver.Resolve(context.Background(), resp)
+
+	want := []engine.Citation{
+		{Label: "Điều 25 Bộ luật Lao động 2019", Document: "45/2019/QH14", Article: "Điều 25", URL: site.URL + "/blld", LinkStatus: LinkVerified, InAnswer: true, ResultIndexes: []int{0, 2}},
+		{Label: "Điều 7 Nghị định 145/2020/NĐ-CP", Document: "145/2020/NĐ-CP", Article: "Điều 7", URL: site.URL + "/no-head", LinkStatus: LinkVerified, InAnswer: true},
+		{Label: "10/2020/TT-BLĐTBXH", Document: "10/2020/TT-BLĐTBXH", LinkStatus: LinkUnresolved, InAnswer: true},
+		{Label: "Điều 24 Bộ luật Lao động 2019", Document: "45/2019/QH14", Article: "Điều 24", URL: site.URL + "/blld", LinkStatus: LinkVerified, ResultIndexes: []int{1}},
+	}
+	if len(citations) != len(want) {
+		t.Fatalf("citations = %+v, want %d", citations, len(want))
+	}
+	for i, w := range want {
+		got := citations[i]
+		if got.Label != w.Label || got.Document != w.Document || got.Article != w.Article || got.URL != w.URL ||
+			got.LinkStatus != w.LinkStatus || got.InAnswer != w.InAnswer || len(got.ResultIndexes) != len(w.ResultIndexes) {
+			t.Errorf("citation %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	// Link checks are cached
+	made := checks.Load()
+	resolver.Resolve(context.Background(), resp)
+	if checks.Load() != made {
+		t.Errorf("second resolve made %d more checks, want them cached", checks.Load()-made)
+	}
+	var dead bool
+	for _, doc := range resolver.Status() {
+		for _, link := range doc.Links {
+			if strings.HasSuffix(link.URL, "/moved") {
+				dead = link.Status == LinkDead && link.CheckedAt != nil
+			}
+		}
+	}
+	if !dead {
+		t.Errorf("status = %+v, want the moved link reported dead", resolver.Status())
+	}
+}
+
+func TestLinkResolverWithoutChecks(t *testing.T) {
+	resolver, err := NewLinkResolver(LawLinkConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewLinkResolver: %v", err)
+	}
+	citations := resolver.Resolve(context.Background(), &engine.LegalQueryResponse{Answer: "Theo Điều 25, thời gian thử việc không quá 60 ngày."})
+	if len(citations) != 1 || citations[0].Document != "45/2019/QH14" || citations[0].URL == "" || citations[0].LinkStatus != LinkUnverified {
+		t.Errorf("citations = %+v, want Điều 25 of the corpus with an unverified link", citations)
+	}
+
+	if resolver, err := NewLinkResolver(LawLinkConfig{}); resolver != nil || err != nil {
+		t.Errorf("disabled resolver = %v, %v, want nil", resolver, err)
+	}
+}
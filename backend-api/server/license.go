@@ -0,0 +1,426 @@
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Features a license can enable. Core querying is always available.
+const (
+	LicenseWebSearch          = "web_search"
+	LicenseCompare            = "compare"
+	LicenseGraphQL            = "graphql"
+	LicenseChat               = "chat"
+	LicenseReviewJobs         = "review_jobs"
+	LicensePrivateCollections = "private_collections"
+	LicensePublicPages        = "public_pages"
+)
+
+// licenseRoutes maps route prefixes to the feature they need. Web search
+// has no route: it is removed from the caller's plan instead.
+var licenseRoutes = []struct{ prefix, feature string }{
+	{"/api/legal-query/compare", LicenseCompare},
+	{"/graphql", LicenseGraphQL},
+	{"/ws/chat", LicenseChat},
+	{"/api/review-jobs", LicenseReviewJobs},
+	{"/api/private-collections", LicensePrivateCollections},
+	{"/api/history/:id/publish", LicensePublicPages},
+	{"/api/public-pages", LicensePublicPages},
+	{"/faq/", LicensePublicPages},
+	{"/sitemap.xml", LicensePublicPages},
+}
+
+var licenseFeatures = []string{
+	LicenseWebSearch, LicenseCompare, LicenseGraphQL, LicenseChat,
+	LicenseReviewJobs, LicensePrivateCollections, LicensePublicPages,
+}
+
+// LicenseFeatures lists the features a license can enable
+func LicenseFeatures() []string {
+	return slices.Clone(licenseFeatures)
+}
+
+// States of a license
+const (
+	LicenseUnlicensed = "unlicensed"
+	LicenseValid      = "valid"
+	LicenseGrace      = "grace"
+	LicenseExpired    = "expired"
+)
+
+// licenseExpiryNotice is how long before expiry the status board warns
+const licenseExpiryNotice = 14 * 24 * time.Hour
+
+// License is what a license key grants an on-premises deployment. Its JSON
+// encoding is the signed payload of the key.
+type License struct {
+	ID        string    `json:"id"`
+	Licensee  string    `json:"licensee"`
+	Seats     int       `json:"seats"`
+	Features  []string  `json:"features"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LicenseConfig enables license enforcement for on-premises distributions.
+// Without a key file the server is unlicensed and nothing is enforced.
+type LicenseConfig struct {
+	KeyFile       string
+	PublicKeyFile string
+
+	// Grace is how long the server keeps answering after the license
+	// expired, so that renewing it does not cause an outage
+	Grace time.Duration
+
+	// SeatIdle is how long a user holds a seat after their last request
+	SeatIdle time.Duration
+}
+
+var (
+	errLicenseMalformed = errors.New("license key is malformed")
+	errLicenseSignature = errors.New("license key is not signed by the license issuer")
+)
+
+// IssueLicense signs a license with the issuer's key and returns the
+// license key: the base64 payload and signature joined by a dot
+func IssueLicense(key crypto.Signer, license License) (string, error) {
+	if _, err := signatureAlgorithm(key.Public()); err != nil {
+		return "", err
+	}
+	if err := license.validate(); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(license)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signPayload(key, payload)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(sig), nil
+}
+
+// ParseLicense checks that a license key is signed by the issuer and
+// returns its license, expired or not
+func ParseLicense(key string, issuer crypto.PublicKey) (License, error) {
+	payloadPart, sigPart, ok := strings.Cut(strings.TrimSpace(key), ".")
+	if !ok {
+		return License{}, errLicenseMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return License{}, errLicenseMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return License{}, errLicenseMalformed
+	}
+	if !verifyPayload(issuer, payload, sig) {
+		return License{}, errLicenseSignature
+	}
+
+	var license License
+	if err := json.Unmarshal(payload, &license); err != nil {
+		return License{}, fmt.Errorf("%w: %v", errLicenseMalformed, err)
+	}
+	if err := license.validate(); err != nil {
+		return License{}, err
+	}
+	return license, nil
+}
+
+func (l License) validate() error {
+	if l.ID == "" || l.Licensee == "" {
+		return errors.New("license needs an id and a licensee")
+	}
+	if l.Seats < 0 {
+		return errors.New("license seats must not be negative")
+	}
+	if l.ExpiresAt.IsZero() {
+		return errors.New("license needs an expiry date")
+	}
+	for _, feature := range l.Features {
+		if !slices.Contains(licenseFeatures, feature) {
+			return fmt.Errorf("unknown license feature %q (known: %s)", feature, strings.Join(licenseFeatures, ", "))
+		}
+	}
+	return nil
+}
+
+// loadLicense reads the license key and the issuer's PEM public key named
+// by the config
+func loadLicense(config LicenseConfig) (License, error) {
+	if config.PublicKeyFile == "" {
+		return License{}, errors.New("LICENSE_PUBLIC_KEY_FILE is required to verify the license")
+	}
+	data, err := os.ReadFile(config.PublicKeyFile)
+	if err != nil {
+		return License{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return License{}, fmt.Errorf("%s is not a PEM encoded public key", config.PublicKeyFile)
+	}
+	issuer, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return License{}, err
+	}
+	if _, err := signatureAlgorithm(issuer); err != nil {
+		return License{}, err
+	}
+
+	key, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return License{}, err
+	}
+	return ParseLicense(string(key), issuer)
+}
+
+// Licensing enforces a license: its expiry, its features, and its seats,
+// which users take with their first request and free after staying idle
+type Licensing struct {
+	license License
+	config  LicenseConfig
+	status  *StatusBoard
+
+	mu    sync.Mutex
+	path  string
+	seats map[string]time.Time
+}
+
+// NewLicensing loads the license of the config. It returns nil when no
+// license is configured, and fails when the key is not valid; an expired
+// license still loads, so that the admin API can report it.
+func NewLicensing(config LicenseConfig, path string, status *StatusBoard) (*Licensing, error) {
+	if config.KeyFile == "" {
+		return nil, nil
+	}
+	license, err := loadLicense(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid license: %w", err)
+	}
+	l := &Licensing{license: license, config: config, status: status, path: path, seats: map[string]time.Time{}}
+	if _, err := readJSONFile(path, &l.seats); err != nil {
+		return nil, fmt.Errorf("failed to load license seats: %w", err)
+	}
+	return l, nil
+}
+
+// State tells whether the license is valid at now, expired but within its
+// grace period, or expired
+func (l *Licensing) State(now time.Time) string {
+	switch {
+	case l == nil:
+		return LicenseUnlicensed
+	case now.Before(l.license.ExpiresAt):
+		return LicenseValid
+	case now.Before(l.license.ExpiresAt.Add(l.config.Grace)):
+		return LicenseGrace
+	}
+	return LicenseExpired
+}
+
+// Allows tells whether the license enables a feature
+func (l *Licensing) Allows(feature string) bool {
+	return l == nil || slices.Contains(l.license.Features, feature)
+}
+
+// takeSeat records a request of user, taking a free seat for a user without
+// one. It fails when every seat is held.
+func (l *Licensing) takeSeat(user string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lastSeen, ok := l.seats[user]
+	held := ok && now.Sub(lastSeen) < l.config.SeatIdle
+	if !held && l.license.Seats > 0 && l.usedLocked(now) >= l.license.Seats {
+		return false
+	}
+	l.seats[user] = now
+	// A seat taken is saved at once; a held one daily at most
+	if !held || now.Sub(lastSeen) > 24*time.Hour {
+		l.saveLocked(now)
+	}
+	return true
+}
+
+// usedLocked counts the seats held at now
+func (l *Licensing) usedLocked(now time.Time) int {
+	used := 0
+	for _, lastSeen := range l.seats {
+		if now.Sub(lastSeen) < l.config.SeatIdle {
+			used++
+		}
+	}
+	return used
+}
+
+// saveLocked drops the idle seats and writes the others
+func (l *Licensing) saveLocked(now time.Time) {
+	for user, lastSeen := range l.seats {
+		if now.Sub(lastSeen) >= l.config.SeatIdle {
+			delete(l.seats, user)
+		}
+	}
+	if l.path == "" {
+		return
+	}
+	if err := writeJSONFile(l.path, l.seats); err != nil {
+		slog.Error("Failed to save license seats", "error", err)
+	}
+}
+
+// Refresh tells users through a status message when the license is about
+// to expire or has expired
+func (l *Licensing) Refresh(now time.Time) {
+	expires := l.license.ExpiresAt.Format(time.DateOnly)
+	switch l.State(now) {
+	case LicenseValid:
+		if l.license.ExpiresAt.Sub(now) > licenseExpiryNotice {
+			l.status.Clear("license")
+			return
+		}
+		l.status.Raise("license", StatusNotice, SeverityWarning, "The license expires on "+expires+"; contact your administrator to renew it")
+	case LicenseGrace:
+		stops := l.license.ExpiresAt.Add(l.config.Grace).Format(time.DateOnly)
+		l.status.Raise("license", StatusNotice, SeverityWarning, "The license expired on "+expires+"; the service stops answering on "+stops+" unless it is renewed")
+	case LicenseExpired:
+		l.status.Raise("license", StatusNotice, SeverityCritical, "The license expired on "+expires+"; contact your administrator to renew it")
+	}
+}
+
+// Run refreshes the status message hourly until stop is closed
+func (l *Licensing) Run(stop <-chan struct{}) {
+	l.Refresh(time.Now().UTC())
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.Refresh(now.UTC())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// LicenseSeat is a user holding a seat
+type LicenseSeat struct {
+	User     string    `json:"user"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// LicenseStatus is reported by GET /admin/license
+type LicenseStatus struct {
+	State     string        `json:"state"`
+	License   *License      `json:"license,omitempty"`
+	GraceEnds *time.Time    `json:"grace_ends,omitempty"`
+	SeatsUsed int           `json:"seats_used"`
+	Seats     []LicenseSeat `json:"seats"`
+}
+
+// Status reports the license and the seats held at now
+func (l *Licensing) Status(now time.Time) LicenseStatus {
+	status := LicenseStatus{State: l.State(now), Seats: []LicenseSeat{}}
+	if l == nil {
+		return status
+	}
+	license := l.license
+	graceEnds := license.ExpiresAt.Add(l.config.Grace)
+	status.License, status.GraceEnds = &license, &graceEnds
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for user, lastSeen := range l.seats {
+		if now.Sub(lastSeen) < l.config.SeatIdle {
+			status.Seats = append(status.Seats, LicenseSeat{User: user, LastSeen: lastSeen})
+		}
+	}
+	sort.Slice(status.Seats, func(i, j int) bool { return status.Seats[i].User < status.Seats[j].User })
+	status.SeatsUsed = len(status.Seats)
+	return status
+}
+
+// licenseMiddleware enforces the license: routes of features it does not
+// enable are refused, and web search is removed from the caller's plan.
+// Once the grace period is over, or every seat is held by other users,
+// requests are refused but for the public routes and the admin API, so
+// that a new license can be installed. It must run after planMiddleware.
+func licenseMiddleware(l *Licensing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		for _, r := range licenseRoutes {
+			if strings.HasPrefix(route, r.prefix) && !l.Allows(r.feature) {
+				abortWithError(c, ErrCodeFeatureUnlicensed, fmt.Sprintf("The license does not include the %q feature", r.feature))
+				return
+			}
+		}
+		if !l.Allows(LicenseWebSearch) {
+			plan := callerPlan(c)
+			plan.WebSearch = false
+			c.Set(planContextKey, plan)
+		}
+		if route == "" || publicRoutes[route] || strings.HasPrefix(route, "/admin/") {
+			c.Next()
+			return
+		}
+
+		now := time.Now().UTC()
+		if l.State(now) == LicenseExpired {
+			abortWithError(c, ErrCodeLicenseExpired, fmt.Sprintf("The license expired on %s", l.license.ExpiresAt.Format(time.DateOnly)))
+			return
+		}
+		holder := seatHolder(c)
+		if holder == "" && l.license.Seats > 0 {
+			abortWithError(c, ErrCodeUnauthorized, fmt.Sprintf("The license is limited to %d seats; send an API key in the X-API-Key header", l.license.Seats))
+			return
+		}
+		if holder != "" && !l.takeSeat(holder, now) {
+			abortWithError(c, ErrCodeLicenseSeatsExceeded, fmt.Sprintf("All %d licensed seats are in use", l.license.Seats))
+			return
+		}
+		c.Next()
+	}
+}
+
+// seatHolder returns who a request's seat is counted for: the user its API
+// key is bound to, or else the key itself. X-User-ID is not trusted here,
+// since a caller could take a fresh seat per request or borrow a held one.
+// "" means the caller is unidentified.
+func seatHolder(c *gin.Context) string {
+	if user := authenticatedUser(c); user != "" {
+		return user
+	}
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return "key:" + v.(APIKey).ID
+	}
+	return ""
+}
+
+// Handlers
+
+func licenseStatusHandler(l *Licensing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, l.Status(time.Now().UTC()))
+	}
+}
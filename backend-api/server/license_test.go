@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLicense(t *testing.T) {
+	_, issuer, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	license := License{
+		ID:        "lic-1",
+		Licensee:  "Công ty Luật Minh An",
+		Seats:     5,
+		Features:  []string{LicenseWebSearch, LicenseCompare},
+		IssuedAt:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	key, err := IssueLicense(issuer, license)
+	if err != nil {
+		t.Fatalf("IssueLicense: %v", err)
+	}
+
+	parsed, err := ParseLicense(key+"\n", issuer.Public())
+	if err != nil {
+		t.Fatalf("ParseLicense: %v", err)
+	}
+	if parsed.Licensee != license.Licensee || parsed.Seats != 5 || !parsed.ExpiresAt.Equal(license.ExpiresAt) || len(parsed.Features) != 2 {
+		t.Errorf("parsed = %+v, want %+v", parsed, license)
+	}
+	if _, err := ParseLicense(key, other.Public()); !errors.Is(err, errLicenseSignature) {
+		t.Errorf("key of another issuer: err = %v, want %v", err, errLicenseSignature)
+	}
+
+	// Raising the seats invalidates the signature
+	payload, sig, _ := strings.Cut(key, ".")
+	tampered, _ := json.Marshal(License{ID: "lic-1", Licensee: license.Licensee, Seats: 500, ExpiresAt: license.ExpiresAt})
+	if _, err := ParseLicense(base64.RawURLEncoding.EncodeToString(tampered)+"."+sig, issuer.Public()); !errors.Is(err, errLicenseSignature) {
+		t.Errorf("tampered key: err = %v, want %v", err, errLicenseSignature)
+	}
+	if _, err := ParseLicense(payload, issuer.Public()); !errors.Is(err, errLicenseMalformed) {
+		t.Errorf("key without signature: err = %v, want %v", err, errLicenseMalformed)
+	}
+
+	license.Features = []string{"teleport"}
+	if _, err := IssueLicense(issuer, license); err == nil {
+		t.Error("IssueLicense accepted an unknown feature")
+	}
+}
+
+func TestLicenseState(t *testing.T) {
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &Licensing{license: License{ExpiresAt: expires}, config: LicenseConfig{Grace: 7 * 24 * time.Hour}}
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{expires.Add(-time.Hour), LicenseValid},
+		{expires.Add(time.Hour), LicenseGrace},
+		{expires.Add(8 * 24 * time.Hour), LicenseExpired},
+	} {
+		if got := l.State(tc.at); got != tc.want {
+			t.Errorf("State(%v) = %s, want %s", tc.at, got, tc.want)
+		}
+	}
+	if got := (*Licensing)(nil).State(expires); got != LicenseUnlicensed {
+		t.Errorf("State without a license = %s, want %s", got, LicenseUnlicensed)
+	}
+}
+
+func TestLicenseEnforcement(t *testing.T) {
+	dir := t.TempDir()
+	_, issuer, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(issuer.Public())
+	publicKeyFile := filepath.Join(dir, "issuer.pem")
+	os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+	key, err := IssueLicense(issuer, License{
+		ID:        "lic-2",
+		Licensee:  "Văn phòng Luật sư Hà",
+		Seats:     1,
+		Features:  []string{LicenseWebSearch},
+		ExpiresAt: time.Now().Add(365 * 24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("IssueLicense: %v", err)
+	}
+	keyFile := filepath.Join(dir, "license.key")
+	os.WriteFile(keyFile, []byte(key), 0o600)
+	t.Setenv("LICENSE_FILE", keyFile)
+	t.Setenv("LICENSE_PUBLIC_KEY_FILE", publicKeyFile)
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+	lan, minh := userKey(t, srv.Router(), "lan"), userKey(t, srv.Router(), "minh")
+	get := func(path, key, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if user != "" {
+			req.Header.Set("X-User-ID", user)
+		}
+		req.Header.Set("X-Admin-Token", "admin-token")
+		rec := httptest.NewRecorder()
+		srv.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/review-jobs", lan, ""); rec.Code != http.StatusForbidden || decodeError(t, rec).Code != ErrCodeFeatureUnlicensed {
+		t.Errorf("unlicensed feature: status %d %s, want 403 %s", rec.Code, rec.Body, ErrCodeFeatureUnlicensed)
+	}
+	if rec := get("/api/history", lan, ""); rec.Code != http.StatusOK {
+		t.Errorf("first user: status %d %s, want 200", rec.Code, rec.Body)
+	}
+	if rec := get("/api/history", minh, ""); rec.Code != http.StatusForbidden || decodeError(t, rec).Code != ErrCodeLicenseSeatsExceeded {
+		t.Errorf("second user: status %d %s, want 403 %s", rec.Code, rec.Body, ErrCodeLicenseSeatsExceeded)
+	}
+	if rec := get("/api/history", lan, ""); rec.Code != http.StatusOK {
+		t.Errorf("seat holder: status %d %s, want 200", rec.Code, rec.Body)
+	}
+	// X-User-ID alone neither takes a seat nor borrows a held one
+	for _, user := range []string{"", "lan", "hoa"} {
+		if rec := get("/api/history", "", user); rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != ErrCodeUnauthorized {
+			t.Errorf("caller without a key as %q: status %d %s, want 401 %s", user, rec.Code, rec.Body, ErrCodeUnauthorized)
+		}
+	}
+	if rec := get("/healthz", "", "minh"); rec.Code != http.StatusOK {
+		t.Errorf("public route: status %d, want 200", rec.Code)
+	}
+
+	rec := get("/admin/license", "", "")
+	var status LicenseStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("license status: %d %s", rec.Code, rec.Body)
+	}
+	if status.State != LicenseValid || status.License.ID != "lic-2" || status.SeatsUsed != 1 || status.Seats[0].User != "lan" {
+		t.Errorf("license status = %+v, want valid with lan holding the seat", status)
+	}
+}
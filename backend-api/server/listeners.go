@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// ListenerConfig moves the admin API and the metrics off the public port,
+// so that they can be bound to an interface the internet cannot reach
+type ListenerConfig struct {
+	// AdminPort serves /admin on its own listener, e.g. 127.0.0.1:9090 or
+	// 9090, and removes it from the public port
+	AdminPort string
+	// MetricsPort does the same for /metrics; it may equal AdminPort
+	MetricsPort string
+	// UnixSocket also serves the public API on a Unix socket, for a proxy
+	// on the same host
+	UnixSocket string
+}
+
+func loadListenerConfig(serverPort string) ListenerConfig {
+	config := ListenerConfig{
+		AdminPort:   settings.Get("ADMIN_PORT"),
+		MetricsPort: settings.Get("METRICS_PORT"),
+		UnixSocket:  settings.Get("UNIX_SOCKET"),
+	}
+	if config.AdminPort == serverPort {
+		settings.Warn("ADMIN_PORT", "ADMIN_PORT %s is the public port, serving the admin API there", config.AdminPort)
+		config.AdminPort = ""
+	}
+	if config.MetricsPort == serverPort {
+		settings.Warn("METRICS_PORT", "METRICS_PORT %s is the public port, serving the metrics there", config.MetricsPort)
+		config.MetricsPort = ""
+	}
+	return config
+}
+
+// listenAddr turns a port, or a host:port, into an address to listen on
+func listenAddr(port string) string {
+	if _, _, err := net.SplitHostPort(port); err == nil {
+		return port
+	}
+	return ":" + port
+}
+
+// newInternalRouter builds the router of a listener other than the public
+// one. It logs, recovers, bounds request bodies and compresses responses
+// like the public router, but runs none of the middleware meant for API
+// callers: CORS, API keys, rate limits, tenants and plans.
+func newInternalRouter(logSettings *middleware.LogSettings, bodyLimits BodyLimitConfig, compression CompressionConfig, trustedProxies []string) *gin.Engine {
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	// the API router has already accepted the same proxies
+	_ = router.SetTrustedProxies(trustedProxies)
+	router.Use(recoveryMiddleware())
+	router.Use(middleware.LoggingWith(logSettings))
+	router.Use(middleware.RequestID(), requestLogMiddleware())
+	router.Use(bodyLimitMiddleware(bodyLimits))
+	router.Use(compressionMiddleware(compression))
+	router.GET("/healthz", livenessHandler)
+	router.NoRoute(notFoundHandler)
+	router.NoMethod(methodNotAllowedHandler)
+	return router
+}
+
+// listener is an HTTP server with the socket it serves
+type listener struct {
+	name   string
+	server *http.Server
+	ln     net.Listener
+}
+
+// listen binds every configured listener, closing those already bound when
+// one fails so that a port in use stops the start
+func (s *Server) listen() ([]listener, error) {
+	type target struct {
+		name, network, addr string
+		handler             http.Handler
+	}
+	targets := []target{{"api", "tcp", listenAddr(s.config.ServerPort), s.router}}
+	if s.config.Listeners.UnixSocket != "" {
+		targets = append(targets, target{"api", "unix", s.config.Listeners.UnixSocket, s.router})
+	}
+	if s.adminRouter != nil {
+		targets = append(targets, target{"admin", "tcp", listenAddr(s.config.Listeners.AdminPort), s.adminRouter})
+	}
+	if s.metricsRouter != nil && s.metricsRouter != s.adminRouter {
+		targets = append(targets, target{"metrics", "tcp", listenAddr(s.config.Listeners.MetricsPort), s.metricsRouter})
+	}
+
+	var listeners []listener
+	for _, t := range targets {
+		if t.network == "unix" {
+			if err := removeStaleSocket(t.addr); err != nil {
+				closeListeners(listeners)
+				return nil, err
+			}
+		}
+		ln, err := net.Listen(t.network, t.addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to listen for the %s on %s: %w", t.name, t.addr, err)
+		}
+		if t.network == "unix" {
+			// Only the owner and its group, e.g. the proxy, may connect
+			if err := os.Chmod(t.addr, 0o660); err != nil {
+				ln.Close()
+				closeListeners(listeners)
+				return nil, fmt.Errorf("failed to restrict %s: %w", t.addr, err)
+			}
+		}
+		slog.Info("Server listening", "listener", t.name, "network", t.network, "addr", t.addr)
+		listeners = append(listeners, listener{name: t.name, server: &http.Server{Handler: t.handler}, ln: ln})
+	}
+	return listeners, nil
+}
+
+// removeStaleSocket removes the socket left by a server that did not shut
+// down cleanly; any other file at path is left alone
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("UNIX_SOCKET %s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func closeListeners(listeners []listener) {
+	for _, l := range listeners {
+		l.ln.Close()
+	}
+}
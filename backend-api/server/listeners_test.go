@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSeparateListeners(t *testing.T) {
+	port, adminPort := freePort(t), freePort(t)
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	t.Setenv("GO_SERVER_PORT", port)
+	t.Setenv("ADMIN_PORT", "127.0.0.1:"+adminPort)
+	t.Setenv("METRICS_PORT", "127.0.0.1:"+adminPort)
+	t.Setenv("UNIX_SOCKET", socket)
+	t.Setenv("ADMIN_TOKEN", "listener-admin-token")
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	ran := make(chan error, 1)
+	go func() { ran <- srv.RunContext(ctx) }()
+	public, admin := "http://127.0.0.1:"+port, "http://127.0.0.1:"+adminPort
+	waitForServer(t, public)
+
+	get := func(client *http.Client, url string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Admin-Token", "listener-admin-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{public + "/admin/status", http.StatusNotFound},
+		{public + "/metrics", http.StatusNotFound},
+		{admin + "/admin/status", http.StatusOK},
+		{admin + "/metrics", http.StatusOK},
+		{admin + "/api/history", http.StatusNotFound},
+	} {
+		if got := get(http.DefaultClient, tc.url); got != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.url, got, tc.want)
+		}
+	}
+
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	if got := get(unix, "http://api/health"); got != http.StatusOK {
+		t.Errorf("GET /health over the Unix socket = %d, want 200", got)
+	}
+
+	stop()
+	if err := <-ran; err != nil {
+		t.Fatalf("RunContext = %v", err)
+	}
+	if _, err := http.Get(admin + "/healthz"); err == nil {
+		t.Error("admin listener still accepts requests after shutdown")
+	}
+	if _, err := os.Stat(socket); err == nil {
+		t.Error("Unix socket left behind after shutdown")
+	}
+}
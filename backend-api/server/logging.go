@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// LoggingStatus is the request logging configuration in effect
+type LoggingStatus struct {
+	Level      string  `json:"level"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// UpdateLoggingRequest changes the request logging while the server runs;
+// omitted fields keep their value. Changes last until the next restart.
+type UpdateLoggingRequest struct {
+	Level      string   `json:"level"`
+	SampleRate *float64 `json:"sample_rate"`
+}
+
+func loggingStatus(settings *middleware.LogSettings) LoggingStatus {
+	return LoggingStatus{Level: settings.Level(), SampleRate: settings.SampleRate()}
+}
+
+// requestLogMiddleware sends the request ID to the engine requests made
+// for the request, and adds the caller and the status of the last engine
+// answer to the request's log line
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trace := &engine.Trace{RequestID: middleware.GetRequestID(c)}
+		c.Request = c.Request.WithContext(engine.WithTrace(c.Request.Context(), trace))
+
+		c.Next()
+
+		var attrs []slog.Attr
+		if user := callerUser(c); user != "" {
+			attrs = append(attrs, slog.String("user", user))
+		}
+		if v, ok := c.Get(apiKeyContextKey); ok {
+			attrs = append(attrs, slog.String("api_key", v.(APIKey).ID))
+		}
+		if tenant, ok := callerTenant(c); ok {
+			attrs = append(attrs, slog.String("tenant", tenant.ID))
+		}
+		if status := trace.UpstreamStatus(); status != 0 {
+			attrs = append(attrs, slog.Int("upstream_status", status))
+		}
+		middleware.AddLogAttrs(c, attrs...)
+	}
+}
+
+// Handlers
+
+func getLoggingHandler(settings *middleware.LogSettings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, loggingStatus(settings))
+	}
+}
+
+func putLoggingHandler(settings *middleware.LogSettings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdateLoggingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+
+		// Validate both before applying either
+		update := middleware.NewLogSettings(settings.Level(), settings.SampleRate())
+		if req.Level != "" {
+			if err := update.SetLevel(req.Level); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, err.Error())
+				return
+			}
+		}
+		if req.SampleRate != nil {
+			if err := update.SetSampleRate(*req.SampleRate); err != nil {
+				abortWithError(c, ErrCodeInvalidRequest, err.Error())
+				return
+			}
+		}
+
+		settings.SetLevel(update.Level())
+		settings.SetSampleRate(update.SampleRate())
+		slog.InfoContext(c.Request.Context(), "Request logging updated", "level", settings.Level(), "sample_rate", settings.SampleRate())
+		c.JSON(http.StatusOK, loggingStatus(settings))
+	}
+}
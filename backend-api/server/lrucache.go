@@ -0,0 +1,117 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// lruCache keeps responses in memory, evicting the least recently used
+// entry when full. It never fails.
+type lruCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	lru        *list.List
+	entries    map[string]*list.Element
+	documents  map[string]map[string]bool
+}
+
+type cachedResponse struct {
+	key       string
+	resp      engine.LegalQueryResponse
+	expiresAt time.Time
+	documents []string
+}
+
+func newLRUCache(maxEntries int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		documents:  make(map[string]map[string]bool),
+	}
+}
+
+func (c *lruCache) Backend() string {
+	return CacheMemory
+}
+
+func (c *lruCache) Get(key string) (*engine.LegalQueryResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok || time.Now().After(elem.Value.(*cachedResponse).expiresAt) {
+		return nil, false, nil
+	}
+	c.lru.MoveToFront(elem)
+	resp := elem.Value.(*cachedResponse).resp
+	return &resp, true, nil
+}
+
+func (c *lruCache) Put(key string, resp *engine.LegalQueryResponse, documents []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cachedResponse{key: key, resp: *resp, expiresAt: time.Now().Add(c.ttl), documents: documents}
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for _, document := range entry.documents {
+		if c.documents[document] == nil {
+			c.documents[document] = make(map[string]bool)
+		}
+		c.documents[document][key] = true
+	}
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+	return nil
+}
+
+// removeLocked drops an entry and its document index
+func (c *lruCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cachedResponse)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	for _, document := range entry.documents {
+		delete(c.documents[document], entry.key)
+		if len(c.documents[document]) == 0 {
+			delete(c.documents, document)
+		}
+	}
+}
+
+func (c *lruCache) Invalidate(documents []string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, document := range documents {
+		for key := range c.documents[document] {
+			if elem, ok := c.entries[key]; ok {
+				c.removeLocked(elem)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *lruCache) Purge() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.documents = make(map[string]map[string]bool)
+	return n, nil
+}
+
+func (c *lruCache) Size() (entries, documents int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), len(c.documents), nil
+}
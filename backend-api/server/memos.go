@@ -0,0 +1,410 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/document"
+)
+
+// Memo output formats
+const (
+	MemoFormatDOCX = "docx"
+	MemoFormatPDF  = "pdf"
+	MemoFormatJSON = "json"
+)
+
+const (
+	maxMemoAnswers     = 10
+	maxMemoSections    = 12
+	maxMemoSources     = 30
+	maxMemoSectionLen  = 200
+	defaultMemoTitle   = "Legal memo"
+	memoIterationLimit = 1
+
+	// minCitationScore is the share of a citing sentence's content words
+	// that must appear in the cited source. It is lower than
+	// minGroundingScore because a memo paraphrases its sources.
+	minCitationScore = 0.3
+)
+
+// defaultMemoOutline is used when the request has no outline
+var defaultMemoOutline = []string{"Vấn đề pháp lý", "Căn cứ pháp lý", "Phân tích", "Kết luận và khuyến nghị"}
+
+// citationPattern matches citation markers such as [3] or [1, 4]
+var citationPattern = regexp.MustCompile(`\[(\d{1,3}(?:\s*,\s*\d{1,3})*)\]`)
+
+// MemoRequest is the body of POST /api/memos
+type MemoRequest struct {
+	HistoryIDs []string `json:"history_ids" binding:"required"`
+	Outline    []string `json:"outline,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Model      string   `json:"model,omitempty"`
+
+	// Format is docx (default), pdf, or json for the memo with its citation
+	// report
+	Format string `json:"format,omitempty"`
+
+	// CitationStyle rewrites the memo's numbered citations: inline (default)
+	// keeps them, the other styles turn them into notes
+	CitationStyle string `json:"citation_style,omitempty"`
+}
+
+// MemoSource is a source of the combined answers, numbered as the memo
+// cites it
+type MemoSource struct {
+	Number     int      `json:"number"`
+	Title      string   `json:"title"`
+	URL        string   `json:"url,omitempty"`
+	Excerpt    string   `json:"excerpt,omitempty"`
+	HistoryIDs []string `json:"history_ids"`
+	Cited      bool     `json:"cited"`
+}
+
+// MemoCitation is a citation of a memo sentence checked against its source
+type MemoCitation struct {
+	Source    int     `json:"source"`
+	Sentence  string  `json:"sentence"`
+	Score     float64 `json:"score"`
+	Supported bool    `json:"supported"`
+}
+
+// Memo is the synthesized memo with its consolidated sources
+type Memo struct {
+	Title         string           `json:"title"`
+	Outline       []string         `json:"outline"`
+	Text          string           `json:"text"`
+	CitationStyle string           `json:"citation_style"`
+	Sources       []MemoSource     `json:"sources"`
+	Citations     []MemoCitation   `json:"citations"`
+	Warnings      []engine.Warning `json:"warnings,omitempty"`
+
+	Disclaimer *engine.DisclaimerStamp `json:"disclaimer,omitempty"`
+}
+
+// consolidateSources numbers the distinct sources of the entries, merging
+// a provision or page cited by several answers into one source
+func consolidateSources(entries []HistoryEntry) []MemoSource {
+	var sources []MemoSource
+	index := make(map[string]int)
+	add := func(entryID string, source BinderSource) {
+		key := source.URL
+		if key == "" {
+			key = truncateUTF8(source.Excerpt, 200)
+		}
+		if key == "" {
+			return
+		}
+		if i, ok := index[key]; ok {
+			if ids := sources[i].HistoryIDs; ids[len(ids)-1] != entryID {
+				sources[i].HistoryIDs = append(ids, entryID)
+			}
+			return
+		}
+		if len(sources) == maxMemoSources {
+			return
+		}
+		index[key] = len(sources)
+		sources = append(sources, MemoSource{
+			Number:     len(sources) + 1,
+			Title:      source.Title,
+			URL:        source.URL,
+			Excerpt:    source.Excerpt,
+			HistoryIDs: []string{entryID},
+		})
+	}
+	for _, e := range entries {
+		for _, r := range e.Response.SearchResults {
+			add(e.ID, sourceFromResult(r))
+		}
+		for _, r := range e.Response.WebResults {
+			add(e.ID, sourceFromWebResult(r))
+		}
+	}
+	return sources
+}
+
+// memoEngineRequest asks the engine to merge the answers into one memo. The
+// answers and numbered sources are passed as context documents, so the
+// engine cites the sources by number.
+func memoEngineRequest(title string, outline []string, entries []HistoryEntry, sources []MemoSource, model string, plan Plan, policy engine.IterationPolicy) *engine.PythonQueryRequest {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Soạn một bản ghi nhớ pháp lý thống nhất với tiêu đề %q, tổng hợp các câu trả lời trong tài liệu ngữ cảnh theo dàn ý sau:\n", title)
+	for i, section := range outline {
+		fmt.Fprintf(&prompt, "%d. %s\n", i+1, section)
+	}
+	prompt.WriteString("Mỗi phần bắt đầu bằng một dòng \"## <tên phần>\". Chỉ dựa vào các nguồn được đánh số trong tài liệu ngữ cảnh và trích dẫn chúng bằng số trong ngoặc vuông, ví dụ [1] hoặc [2, 3], ngay sau câu được hỗ trợ. Không trích dẫn nguồn nào khác. Khi các câu trả lời mâu thuẫn, nêu rõ điểm mâu thuẫn.")
+
+	var docs []engine.ContextDocument
+	for i, e := range entries {
+		docs = append(docs, engine.ContextDocument{
+			Name:   fmt.Sprintf("Câu trả lời %d", i+1),
+			Text:   fmt.Sprintf("Câu hỏi: %s\n\nTrả lời: %s", e.Question, e.Response.Answer),
+			Source: "history:" + e.ID,
+		})
+	}
+	for _, s := range sources {
+		docs = append(docs, engine.ContextDocument{
+			Name:   fmt.Sprintf("[%d] %s", s.Number, s.Title),
+			Text:   s.Excerpt,
+			Source: s.URL,
+		})
+	}
+
+	req := buildPythonRequest(&LegalQueryRequest{Question: prompt.String(), Model: model}, QueryDefaults{}, plan)
+	req.MaxIterations = memoIterationLimit
+	req.EnableWebSearch = false
+	req.IterationPolicy = policy
+	req.ContextDocuments = docs
+	return req
+}
+
+// checkCitations removes citations of sources that do not exist and checks
+// every remaining citation against the sentence citing it
+func checkCitations(text string, sources []MemoSource) (string, []MemoCitation, []engine.Warning) {
+	var warnings []engine.Warning
+	text = citationPattern.ReplaceAllStringFunc(text, func(marker string) string {
+		var kept []string
+		for _, n := range strings.Split(marker[1:len(marker)-1], ",") {
+			n = strings.TrimSpace(n)
+			if number, _ := strconv.Atoi(n); number >= 1 && number <= len(sources) {
+				kept = append(kept, n)
+				continue
+			}
+			warnings = append(warnings, engine.Warning{
+				Field:   "text",
+				Code:    WarningCitationRemoved,
+				Message: fmt.Sprintf("citation [%s] matches none of the %d sources and was removed", n, len(sources)),
+			})
+		}
+		if len(kept) == 0 {
+			return ""
+		}
+		return "[" + strings.Join(kept, ", ") + "]"
+	})
+
+	citations := []MemoCitation{}
+	runes := []rune(text)
+	previous := ""
+	for _, span := range splitSpans(text) {
+		sentence := string(runes[span.start:span.end])
+		markers := citationPattern.FindAllStringSubmatch(sentence, -1)
+		if len(markers) == 0 {
+			previous = sentence
+			continue
+		}
+		// A citation placed after the full stop cites the sentence before it
+		tokens := contentTokens(citationPattern.ReplaceAllString(sentence, ""))
+		if len(tokens) == 0 {
+			sentence = previous + " " + sentence
+			tokens = contentTokens(previous)
+		}
+		previous = sentence
+		for _, m := range markers {
+			for _, n := range strings.Split(m[1], ",") {
+				number, _ := strconv.Atoi(strings.TrimSpace(n))
+				source := &sources[number-1]
+				source.Cited = true
+				score := 0.0
+				if len(tokens) > 0 {
+					score = overlap(tokens, contentTokens(source.Title+" "+source.Excerpt))
+				}
+				citation := MemoCitation{
+					Source:    number,
+					Sentence:  sentence,
+					Score:     float64(int(score*100+0.5)) / 100,
+					Supported: score >= minCitationScore,
+				}
+				citations = append(citations, citation)
+				if !citation.Supported {
+					warnings = append(warnings, engine.Warning{
+						Field:   "text",
+						Code:    WarningCitationUnsupported,
+						Message: fmt.Sprintf("source [%d] shares little wording with the sentence citing it: %q", number, truncateUTF8(sentence, 200)),
+					})
+				}
+			}
+		}
+	}
+	return text, citations, warnings
+}
+
+// memoDocument lays the memo out with its sections as headings, followed
+// by the numbered sources, or by the notes citing them, and the disclaimer
+func memoDocument(memo Memo, answers int) *document.Document {
+	doc := &document.Document{
+		Title:    memo.Title,
+		Subtitle: fmt.Sprintf("Synthesized from %d answers", answers),
+		Created:  time.Now(),
+	}
+	var section []string
+	flush := func() {
+		doc.AddMarkdown(strings.Join(section, "\n"))
+		section = nil
+	}
+	for _, line := range strings.Split(memo.Text, "\n") {
+		if strings.HasPrefix(line, "[^1]:") {
+			flush()
+			doc.Add(document.Heading, "Notes")
+		}
+		if heading := strings.TrimSpace(line); strings.HasPrefix(heading, "#") {
+			flush()
+			doc.Add(document.Heading, strings.TrimLeft(heading, "# "))
+			continue
+		}
+		section = append(section, line)
+	}
+	flush()
+
+	if len(memo.Sources) > 0 && (memo.CitationStyle == "" || memo.CitationStyle == CitationInline) {
+		doc.Add(document.Heading, "Sources")
+		for _, s := range memo.Sources {
+			entry := fmt.Sprintf("[%d] %s", s.Number, s.Title)
+			if s.URL != "" {
+				entry += " (" + s.URL + ")"
+			}
+			doc.Add(document.Paragraph, entry)
+		}
+	}
+	addDisclaimer(doc, memo.Disclaimer)
+	return doc
+}
+
+// Handlers
+
+func memoHandler(deps queryDeps, font *document.Font) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MemoRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+
+		format := strings.ToLower(req.Format)
+		switch format {
+		case "":
+			format = MemoFormatDOCX
+		case MemoFormatDOCX, MemoFormatPDF, MemoFormatJSON:
+		default:
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("format must be %s, %s or %s", MemoFormatDOCX, MemoFormatPDF, MemoFormatJSON))
+			return
+		}
+		if format == MemoFormatPDF && font == nil {
+			abortWithError(c, ErrCodeExportUnavailable, "PDF export is disabled because no font is installed")
+			return
+		}
+		if len(req.HistoryIDs) == 0 || len(req.HistoryIDs) > maxMemoAnswers {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("history_ids must list 1 to %d answers", maxMemoAnswers))
+			return
+		}
+		if req.Model != "" && !modelNamePattern.MatchString(req.Model) {
+			abortWithError(c, ErrCodeInvalidRequest, "model must be a model name of at most 100 characters")
+			return
+		}
+		style := req.CitationStyle
+		if style == "" {
+			style = CitationInline
+		}
+		if !validCitationStyle(style) {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("citation_style must be one of: %s", strings.Join(citationStyles, ", ")))
+			return
+		}
+
+		outline := []string{}
+		for _, section := range req.Outline {
+			if section = strings.TrimSpace(section); section != "" {
+				outline = append(outline, truncateUTF8(section, maxMemoSectionLen))
+			}
+		}
+		if len(outline) > maxMemoSections {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("outline must have at most %d sections", maxMemoSections))
+			return
+		}
+		if len(outline) == 0 {
+			outline = defaultMemoOutline
+		}
+		title := strings.TrimSpace(req.Title)
+		if title == "" {
+			title = defaultMemoTitle
+		}
+
+		tenant, _ := callerTenant(c)
+		var entries []HistoryEntry
+		seen := make(map[string]bool)
+		for _, id := range req.HistoryIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			entry, err := deps.history.Get(id, tenant.ID)
+			if err != nil {
+				abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", id))
+				return
+			}
+			entries = append(entries, entry)
+		}
+
+		sources := consolidateSources(entries)
+		pythonReq := memoEngineRequest(title, outline, entries, sources, req.Model, callerPlan(c), deps.iterationPolicy)
+		keyTenant, _ := authenticatedTenant(c)
+		pythonReq.ExcludeCollections = deps.collections.Hidden(keyTenant, authenticatedUser(c))
+		resp, err := deps.queryEngine(c, pythonReq)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error calling Python AI Engine", "error", err)
+			abortWithEngineError(c, err, fmt.Sprintf("Failed to synthesize memo: %v", err))
+			return
+		}
+		warnings, err := deps.postProcessors.Run(c.Request.Context(), &PostProcessInput{Question: title, TenantID: tenant.ID, Response: resp})
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Post-processing rejected the memo", "error", err)
+			abortWithError(c, postProcessErrorCode(err), err.Error())
+			return
+		}
+
+		text, citations, citationWarnings := checkCitations(resp.Answer, sources)
+		text = appendNotes(formatCitations(text, memoCiteMarks(text, sources), style))
+		memo := Memo{
+			Title:         title,
+			Outline:       outline,
+			Text:          text,
+			CitationStyle: style,
+			Sources:       sources,
+			Citations:     citations,
+			Warnings:      append(warnings, citationWarnings...),
+			Disclaimer:    resp.Disclaimer,
+		}
+		if memo.Sources == nil {
+			memo.Sources = []MemoSource{}
+		}
+		slog.InfoContext(c.Request.Context(), "Synthesized memo", "answers", len(entries), "citations", len(citations), "warnings", len(memo.Warnings))
+
+		var data []byte
+		var contentType string
+		switch format {
+		case MemoFormatJSON:
+			c.JSON(http.StatusOK, memo)
+			return
+		case MemoFormatPDF:
+			data, err = document.RenderPDF(memoDocument(memo, len(entries)), font)
+			contentType = "application/pdf"
+		default:
+			data, err = document.RenderDOCX(memoDocument(memo, len(entries)))
+			contentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to render memo", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to render the memo")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="memo-%s.%s"`, time.Now().Format("20060102-150405"), format))
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestCheckCitations(t *testing.T) {
+	sources := []MemoSource{
+		{Number: 1, Title: "Thời gian thử việc", Excerpt: "Thời gian thử việc không quá 60 ngày đối với công việc cần trình độ cao đẳng."},
+		{Number: 2, Title: "Tiền lương thử việc", Excerpt: "Tiền lương thử việc ít nhất bằng 85% mức lương của công việc đó."},
+	}
+	text := "## Căn cứ pháp lý\nThời gian thử việc không quá 60 ngày với trình độ cao đẳng [1, 7]. Người lao động được nghỉ phép năm [2].\nLương thử việc ít nhất bằng 85% mức lương. [2]"
+
+	got, citations, warnings := checkCitations(text, sources)
+	want := "## Căn cứ pháp lý\nThời gian thử việc không quá 60 ngày với trình độ cao đẳng [1]. Người lao động được nghỉ phép năm [2].\nLương thử việc ít nhất bằng 85% mức lương. [2]"
+	if got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if len(citations) != 3 {
+		t.Fatalf("citations = %+v, want 3", citations)
+	}
+	if !citations[0].Supported || citations[1].Supported || !citations[2].Supported {
+		t.Errorf("supported = %v %v %v, want true false true", citations[0].Supported, citations[1].Supported, citations[2].Supported)
+	}
+	codes := map[string]int{}
+	for _, w := range warnings {
+		codes[w.Code]++
+	}
+	if codes[WarningCitationRemoved] != 1 || codes[WarningCitationUnsupported] != 1 {
+		t.Errorf("warnings = %+v, want one removed and one unsupported citation", warnings)
+	}
+	if !sources[0].Cited || !sources[1].Cited {
+		t.Error("cited sources are not marked")
+	}
+}
+
+func TestMemo(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{
+			{Excerpt: "Điều 25. Thời gian thử việc không quá 60 ngày đối với công việc cần trình độ cao đẳng trở lên."},
+		},
+		Iterations: 1,
+	}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	var ids []string
+	for _, q := range []string{
+		"Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?",
+		"Theo Bộ luật Lao động 2019, người sử dụng lao động có được yêu cầu thử việc hai lần đối với cùng một công việc không?",
+	} {
+		rec := doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: q})
+		var resp engine.LegalQueryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.HistoryID == "" {
+			t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+		}
+		ids = append(ids, resp.HistoryID)
+	}
+
+	stub.resp = engine.LegalQueryResponse{Answer: "## Phân tích\nThời gian thử việc không quá 60 ngày đối với công việc cần trình độ cao đẳng [1][3].", Iterations: 1}
+	rec := doJSON(t, h, http.MethodPost, "/api/memos", MemoRequest{HistoryIDs: ids, Outline: []string{"Phân tích"}, Format: MemoFormatJSON})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("memo status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var memo Memo
+	if err := json.Unmarshal(rec.Body.Bytes(), &memo); err != nil {
+		t.Fatalf("decode memo: %v", err)
+	}
+	if len(memo.Sources) != 1 || len(memo.Sources[0].HistoryIDs) != 2 {
+		t.Errorf("sources = %+v, want the shared provision consolidated", memo.Sources)
+	}
+	if len(memo.Citations) != 1 || !memo.Citations[0].Supported || len(memo.Warnings) != 1 || memo.Warnings[0].Code != WarningCitationRemoved {
+		t.Errorf("citations = %+v, warnings = %+v, want [1] kept and [3] removed", memo.Citations, memo.Warnings)
+	}
+	last := stub.requests[len(stub.requests)-1]
+	if len(last.ContextDocuments) != 3 {
+		t.Errorf("engine got %d context documents, want 2 answers and 1 source", len(last.ContextDocuments))
+	}
+
+	rec = doJSON(t, h, http.MethodPost, "/api/memos", MemoRequest{HistoryIDs: ids})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("docx memo status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len())); err != nil {
+		t.Errorf("docx memo is not a zip archive: %v", err)
+	}
+
+	rec = doJSON(t, h, http.MethodPost, "/api/memos", MemoRequest{HistoryIDs: []string{"q_missing"}})
+	if code := decodeError(t, rec).Code; code != ErrCodeHistoryNotFound {
+		t.Errorf("unknown history error = %s, want HISTORY_NOT_FOUND", code)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Features reported in the meta object of a response
+const (
+	FeatureCacheHit               = "cache_hit"
+	FeatureSandbox                = "sandbox"
+	FeatureRule                   = "rule"
+	FeatureRegionFallback         = "region_fallback"
+	FeatureHedged                 = "hedged"
+	FeatureQueryVariants          = "query_variants"
+	FeatureSourcePriors           = "source_priors"
+	FeatureContextDocuments       = "context_documents"
+	FeatureChatHistory            = "chat_history"
+	FeatureClarified              = "clarified"
+	FeatureClarificationRequested = "clarification_requested"
+	FeaturePostProcessed          = "post_processed"
+	FeatureFastPath               = "fast_path"
+	FeatureStageTimeout           = "stage_timeout"
+	FeatureDowngraded             = "downgraded"
+)
+
+// metaFeatures lists every feature name, in the order they are reported
+var metaFeatures = []string{
+	FeatureCacheHit,
+	FeatureSandbox,
+	FeatureRule,
+	FeatureRegionFallback,
+	FeatureHedged,
+	FeatureFastPath,
+	FeatureStageTimeout,
+	FeatureDowngraded,
+	FeatureQueryVariants,
+	FeatureSourcePriors,
+	FeatureContextDocuments,
+	FeatureChatHistory,
+	FeatureClarified,
+	FeatureClarificationRequested,
+	FeaturePostProcessed,
+}
+
+// newResponseMeta describes how the engine answered req. Post-processors
+// add themselves when they run. primaryRegion is empty without failover.
+func newResponseMeta(req *engine.PythonQueryRequest, resp *engine.LegalQueryResponse, primaryRegion string, clarified bool) *engine.ResponseMeta {
+	applied := map[string]bool{
+		FeatureCacheHit:               resp.Cached,
+		FeatureSandbox:                resp.Sandbox,
+		FeatureRule:                   resp.Rule != "",
+		FeatureRegionFallback:         primaryRegion != "" && resp.Region != "" && resp.Region != primaryRegion,
+		FeatureHedged:                 resp.Hedged,
+		FeatureFastPath:               req.Difficulty == DifficultySimple,
+		FeatureStageTimeout:           len(resp.StageTimeouts) > 0,
+		FeatureDowngraded:             resp.Downgrade != nil,
+		FeatureQueryVariants:          len(req.QueryVariants) > 0,
+		FeatureSourcePriors:           len(req.SourcePriors) > 0,
+		FeatureContextDocuments:       len(req.ContextDocuments) > 0,
+		FeatureChatHistory:            len(req.History) > 0,
+		FeatureClarified:              clarified,
+		FeatureClarificationRequested: resp.NeedsClarification,
+	}
+
+	meta := &engine.ResponseMeta{
+		Features:        []string{},
+		Model:           req.Model,
+		Region:          resp.Region,
+		IterationPolicy: req.IterationPolicy.Mode,
+		Difficulty:      req.Difficulty,
+		Style:           req.Style,
+		QueryVariants:   req.QueryVariants,
+	}
+	for _, feature := range metaFeatures {
+		if applied[feature] {
+			meta.Features = append(meta.Features, feature)
+		}
+	}
+	return meta
+}
+
+// addPostProcessorMeta records the post-processors that changed resp
+func addPostProcessorMeta(resp *engine.LegalQueryResponse, names []string) {
+	if resp.Meta == nil || len(names) == 0 {
+		return
+	}
+	resp.Meta.PostProcessors = append(resp.Meta.PostProcessors, names...)
+	resp.Meta.Features = append(resp.Meta.Features, FeaturePostProcessed)
+}
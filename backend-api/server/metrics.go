@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/metrics"
+)
+
+// MetricsConfig controls the Prometheus endpoint at /metrics
+type MetricsConfig struct {
+	Enabled bool
+
+	// Token, when set, must be sent as a bearer token to scrape
+	Token string
+}
+
+// engineBuckets are the buckets, in seconds, of engine call durations: an
+// agentic query with several iterations can take minutes
+var engineBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+// errorCodeKey holds the code of the error a request was aborted with
+const errorCodeKey = "error_code"
+
+// serverMetrics are the Prometheus metrics of the server
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests        metrics.ValueVec
+	requestDuration metrics.HistogramVec
+	inFlight        *metrics.Value
+	errors          metrics.ValueVec
+
+	engineDuration metrics.HistogramVec
+	engineErrors   metrics.ValueVec
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:        r,
+		requests:        r.NewCounterVec("legal_rag_http_requests_total", "HTTP requests served, by method, route and status.", "method", "route", "status"),
+		requestDuration: r.NewHistogramVec("legal_rag_http_request_duration_seconds", "HTTP request latency, by method and route.", metrics.DefBuckets, "method", "route"),
+		inFlight:        r.NewGaugeVec("legal_rag_http_requests_in_flight", "HTTP requests being served.").With(),
+		errors:          r.NewCounterVec("legal_rag_http_errors_total", "HTTP requests that failed, by route and error code.", "route", "code"),
+		engineDuration:  r.NewHistogramVec("legal_rag_engine_request_duration_seconds", "Python engine call duration, by outcome.", engineBuckets, "outcome"),
+		engineErrors:    r.NewCounterVec("legal_rag_engine_errors_total", "Python engine calls that failed, by error code.", "code"),
+	}
+}
+
+// observeEngine reports the engine calls in flight, counted by pressure
+func (m *serverMetrics) observeEngine(pressure *pressureEngine) {
+	m.registry.NewGaugeFunc("legal_rag_engine_requests_in_flight", "Python engine calls in flight.", func() float64 {
+		return float64(pressure.Signal(false).InFlight)
+	})
+}
+
+// observeCache reports the response cache's hits and misses and their
+// ratio, read from its stats when scraped
+func (m *serverMetrics) observeCache(cache *ResponseCache) {
+	m.registry.NewCounterFunc("legal_rag_cache_hits_total", "Queries answered from the response cache.", func() float64 {
+		return float64(cache.Stats().Hits)
+	})
+	m.registry.NewCounterFunc("legal_rag_cache_misses_total", "Queries not found in the response cache.", func() float64 {
+		return float64(cache.Stats().Misses)
+	})
+	m.registry.NewGaugeFunc("legal_rag_cache_hit_ratio", "Share of cache lookups that were hits since the server started.", func() float64 {
+		stats := cache.Stats()
+		if stats.Hits+stats.Misses == 0 {
+			return 0
+		}
+		return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	})
+}
+
+// metricsMiddleware counts requests, their latency and their errors by
+// route. Requests matching no route are counted under "unmatched", so that
+// scanners cannot add a series per path.
+func metricsMiddleware(m *serverMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		m.requests.With(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.requestDuration.With(method, route).Observe(time.Since(start).Seconds())
+		if code, ok := c.Get(errorCodeKey); ok {
+			m.errors.With(route, string(code.(ErrorCode))).Inc()
+		}
+	}
+}
+
+// meteredEngine times the engine calls and counts their errors
+type meteredEngine struct {
+	next    engine.QueryEngine
+	metrics *serverMetrics
+}
+
+func (e *meteredEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *meteredEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	start := time.Now()
+	resp, err := engine.QueryWithContext(ctx, e.next, req)
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		e.metrics.engineErrors.With(string(classifyEngineError(err))).Inc()
+	}
+	e.metrics.engineDuration.With(outcome).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// Handlers
+
+func metricsHandler(m *serverMetrics, token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			provided, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				abortWithError(c, ErrCodeUnauthorized, "Missing or invalid metrics token")
+				return
+			}
+		}
+		c.Status(http.StatusOK)
+		m.registry.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestMetrics(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019 ...", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	question := LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}
+
+	doJSON(t, h, http.MethodPost, "/api/legal-query", question)
+	doJSON(t, h, http.MethodPost, "/api/legal-query", question)
+	doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{})
+	doJSON(t, h, http.MethodGet, "/wp-login.php", nil)
+	stub.err = &engine.EngineStatusError{StatusCode: http.StatusServiceUnavailable}
+	doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Mức lương tối thiểu vùng là bao nhiêu?"})
+
+	rec := doJSON(t, h, http.MethodGet, "/metrics", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("metrics = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`legal_rag_http_requests_total{method="POST",route="/api/legal-query",status="200"} 2`,
+		`legal_rag_http_requests_total{method="POST",route="/api/legal-query",status="400"} 1`,
+		`legal_rag_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`legal_rag_http_request_duration_seconds_count{method="POST",route="/api/legal-query"} 4`,
+		`legal_rag_http_errors_total{route="/api/legal-query",code="INVALID_REQUEST"} 1`,
+		`legal_rag_http_errors_total{route="/api/legal-query",code="ENGINE_UNAVAILABLE"} 1`,
+		`legal_rag_http_requests_in_flight 1`,
+		`legal_rag_engine_request_duration_seconds_count{outcome="success"} 1`,
+		`legal_rag_engine_errors_total{code="ENGINE_UNAVAILABLE"} 1`,
+		`legal_rag_engine_requests_in_flight 0`,
+		`legal_rag_cache_hits_total 1`,
+		`legal_rag_cache_hit_ratio 0.3333333333333333`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	t.Setenv("METRICS_TOKEN", "scrape")
+	h := newTestServer(t, Options{Engine: &stubEngine{}}).Handler()
+
+	if code := decodeError(t, doJSON(t, h, http.MethodGet, "/metrics", nil)).Code; code != ErrCodeUnauthorized {
+		t.Errorf("metrics without a token = %s, want %s", code, ErrCodeUnauthorized)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("metrics with the token = %d", rec.Code)
+	}
+
+	t.Setenv("METRICS_ENABLED", "false")
+	h = newTestServer(t, Options{Engine: &stubEngine{}}).Handler()
+	if rec := doJSON(t, h, http.MethodGet, "/metrics", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled metrics = %d, want 404", rec.Code)
+	}
+}
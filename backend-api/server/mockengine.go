@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// MockEngine is an in-process HTTP server speaking the Python AI engine
+// protocol. Answers come from fixtures keyed by question patterns, so the Go
+// layer can be developed and tested end to end without Python.
+type MockEngine struct {
+	fixtures *FixtureSet
+	listener net.Listener
+	server   *http.Server
+}
+
+// LoadFixtures reads fixtures from path, falling back to the embedded
+// canned responses when path is empty
+func LoadFixtures(path string) (*FixtureSet, error) {
+	if path == "" {
+		return parseFixtures(cannedResponsesJSON)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	return parseFixtures(data)
+}
+
+// StartMockEngine starts the mock engine on addr ("127.0.0.1:0" picks a free
+// port) and serves until Close is called
+func StartMockEngine(addr string, fixtures *FixtureSet) (*MockEngine, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	m := &MockEngine{
+		fixtures: fixtures,
+		listener: listener,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", m.handleHealth)
+	mux.HandleFunc("POST /api/query", m.handleQuery)
+	mux.HandleFunc("POST /api/query/stream", m.handleQueryStream)
+	mux.HandleFunc("POST /api/search", m.handleSearch)
+	mux.HandleFunc("POST /api/summarize", m.handleSummarize)
+	m.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Mock engine stopped", "error", err)
+		}
+	}()
+
+	return m, nil
+}
+
+// URL returns the base URL to configure PythonClient with
+func (m *MockEngine) URL() string {
+	return "http://" + m.listener.Addr().String()
+}
+
+func (m *MockEngine) Close() error {
+	return m.server.Close()
+}
+
+func (m *MockEngine) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, HealthResponse{
+		Status:  "healthy",
+		Service: "Legal RAG Mock Engine",
+		Version: "1.0.0",
+	})
+}
+
+func (m *MockEngine) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if resp, ok := m.answer(w, r); ok {
+		writeMockJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleQueryStream answers like the Python streaming endpoint: NDJSON lines
+// with a retrieval event, the answer word by word, then the answer
+func (m *MockEngine) handleQueryStream(w http.ResponseWriter, r *http.Request) {
+	resp, ok := m.answer(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	enc.Encode(engine.StreamEvent{Type: engine.EventRetrieval, SearchResults: resp.SearchResults, WebResults: resp.WebResults})
+	for _, word := range strings.SplitAfter(resp.Answer, " ") {
+		enc.Encode(engine.StreamEvent{Type: engine.EventToken, Text: word})
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	enc.Encode(map[string]any{"type": "answer", "response": resp})
+}
+
+// handleSearch answers like the Python search endpoint, with the search
+// results of the fixture the question matches
+func (m *MockEngine) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req engine.SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMockDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if strings.TrimSpace(req.Question) == "" || req.TopK < 1 || req.TopK > engineMaxTopK {
+		writeMockDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("question must not be empty and top_k between 1 and %d", engineMaxTopK))
+		return
+	}
+	resp, ok := m.fixtures.Answer(&engine.PythonQueryRequest{Question: req.Question, TopK: req.TopK, MaxIterations: 1})
+	if !ok {
+		writeMockDetail(w, http.StatusInternalServerError, "no fixture matches question")
+		return
+	}
+	results := resp.SearchResults
+	if len(results) > req.TopK {
+		results = results[:req.TopK]
+	}
+	writeMockJSON(w, http.StatusOK, engine.SearchResponse{SearchResults: results, EmbeddingModel: "mock"})
+}
+
+// handleSummarize answers like the Python summarize endpoint, listing the
+// questions of the turns after the earlier summary
+func (m *MockEngine) handleSummarize(w http.ResponseWriter, r *http.Request) {
+	var req engine.SummarizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Turns) == 0 {
+		writeMockDetail(w, http.StatusUnprocessableEntity, "turns must not be empty")
+		return
+	}
+	parts := []string{}
+	if req.Summary != "" {
+		parts = append(parts, req.Summary)
+	}
+	for _, turn := range req.Turns {
+		parts = append(parts, "Đã hỏi: "+turn.Question)
+	}
+	writeMockJSON(w, http.StatusOK, engine.SummarizeResponse{Summary: strings.Join(parts, "\n")})
+}
+
+// answer decodes and checks a query and finds its fixture. It writes the
+// error response and returns false when there is no answer.
+func (m *MockEngine) answer(w http.ResponseWriter, r *http.Request) (*engine.LegalQueryResponse, bool) {
+	// Fixtures answer at once, so every stage budget is accepted as is
+	if budgets := r.Header.Get(engine.StageBudgetsHeader); budgets != "" {
+		w.Header().Set(engine.StageBudgetsHeader, budgets)
+	}
+
+	var req engine.PythonQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMockDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid request body: %v", err))
+		return nil, false
+	}
+
+	// Mirror the constraints of the Python QueryRequest model
+	switch {
+	case strings.TrimSpace(req.Question) == "":
+		writeMockDetail(w, http.StatusUnprocessableEntity, "question must not be empty")
+		return nil, false
+	case req.MaxIterations < 1 || req.MaxIterations > engineMaxIterations:
+		writeMockDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("max_iterations must be between 1 and %d", engineMaxIterations))
+		return nil, false
+	case req.TopK < 1 || req.TopK > engineMaxTopK:
+		writeMockDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("top_k must be between 1 and %d", engineMaxTopK))
+		return nil, false
+	}
+
+	resp, ok := m.fixtures.Answer(&req)
+	if !ok {
+		writeMockDetail(w, http.StatusInternalServerError, "no fixture matches question")
+		return nil, false
+	}
+	return resp, true
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeMockDetail writes an error in FastAPI's {"detail": ...} format
+func writeMockDetail(w http.ResponseWriter, status int, detail string) {
+	writeMockJSON(w, status, map[string]string{"detail": detail})
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// States of the engine seen by the health monitor
+const (
+	EngineUp      = "up"
+	EngineDown    = "down"
+	EngineUnknown = "unknown"
+)
+
+// maxEngineWindows bounds the status history kept by the health monitor
+const maxEngineWindows = 500
+
+// EngineMonitorConfig controls the background health checks of the engine
+type EngineMonitorConfig struct {
+	Interval time.Duration
+
+	// Timeout bounds each check, so that stopping the monitor never waits
+	// for a hanging engine
+	Timeout time.Duration
+
+	// Threshold is the number of consecutive failed checks after which the
+	// engine is down, so that one lost ping does not count as an outage
+	Threshold int
+
+	// Window is the period of the availability and the status history
+	Window time.Duration
+}
+
+// EngineWindow is a period in which the engine stayed up or down, from
+// its first check to its last one. Reason is why a down window started.
+type EngineWindow struct {
+	State  string    `json:"state"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// EngineFailure is the last failed health check. Reason is coarse, as the
+// status is public: the error itself is only logged.
+type EngineFailure struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+// EngineHealth is the state of the engine reported by GET /api/status
+type EngineHealth struct {
+	State       string         `json:"state"`
+	Since       *time.Time     `json:"since,omitempty"`
+	CheckedAt   *time.Time     `json:"checked_at,omitempty"`
+	LastFailure *EngineFailure `json:"last_failure,omitempty"`
+
+	// Availability is the percentage of the observed time of the window
+	// the engine was up; time the server was not running is not observed
+	Availability *float64       `json:"availability,omitempty"`
+	Window       string         `json:"window"`
+	History      []EngineWindow `json:"history"`
+}
+
+// engineMonitorState is persisted so the history survives restarts
+type engineMonitorState struct {
+	Windows     []EngineWindow `json:"windows"`
+	LastFailure *EngineFailure `json:"last_failure,omitempty"`
+}
+
+// EngineMonitor checks the engine periodically, keeping the windows in
+// which it was up or down. While it is down, a status message tells users.
+type EngineMonitor struct {
+	config EngineMonitorConfig
+	check  func() error
+	status *StatusBoard
+
+	mu       sync.Mutex
+	path     string
+	state    engineMonitorState
+	failures int
+
+	// resumed is false until the first check after loading, which starts
+	// a new window since the server did not observe the time in between
+	resumed bool
+}
+
+func NewEngineMonitor(path string, config EngineMonitorConfig, check func() error, status *StatusBoard) (*EngineMonitor, error) {
+	m := &EngineMonitor{config: config, check: check, status: status, path: path}
+	if _, err := readJSONFile(path, &m.state); err != nil {
+		return nil, fmt.Errorf("failed to load engine status history: %w", err)
+	}
+	return m, nil
+}
+
+// Run checks the engine at once, then every interval until stop is closed.
+// It returns once a check in progress is recorded.
+func (m *EngineMonitor) Run(stop <-chan struct{}) {
+	m.Check(time.Now().UTC())
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.Check(now.UTC())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Check checks the engine and records the outcome at now
+func (m *EngineMonitor) Check(now time.Time) {
+	err := checkWithin(m.check, m.config.Timeout)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := EngineUp
+	reason := ""
+	current := m.currentLocked()
+	fresh := current == nil || !m.resumed
+	if err != nil {
+		m.failures++
+		reason = engineFailureReason(err)
+		m.state.LastFailure = &EngineFailure{At: now, Reason: reason}
+		// Below the threshold the engine keeps its state; the first check
+		// has no state to keep
+		if m.failures >= m.config.Threshold || fresh || current.State == EngineDown {
+			state = EngineDown
+		}
+	} else {
+		m.failures = 0
+	}
+
+	switch {
+	case fresh:
+		slog.Info("Python AI Engine health", "state", state, "error", err)
+		m.openLocked(state, reason, now)
+	case current.State != state:
+		if state == EngineDown {
+			slog.Warn("Python AI Engine is down", "error", err, "failures", m.failures)
+		} else {
+			slog.Info("Python AI Engine is up again", "down_for", now.Sub(current.Start).Round(time.Second).String())
+		}
+		current.End = now
+		m.openLocked(state, reason, now)
+	default:
+		current.End = now
+	}
+	m.resumed = true
+
+	if state == EngineDown {
+		m.status.Raise("engine", StatusDegraded, SeverityCritical, "The AI engine is unavailable; questions cannot be answered until it recovers")
+	} else {
+		m.status.Clear("engine")
+	}
+	m.pruneLocked(now)
+	if m.path != "" {
+		if err := writeJSONFile(m.path, m.state); err != nil {
+			slog.Error("Failed to save engine status history", "error", err)
+		}
+	}
+}
+
+func (m *EngineMonitor) currentLocked() *EngineWindow {
+	if len(m.state.Windows) == 0 {
+		return nil
+	}
+	return &m.state.Windows[len(m.state.Windows)-1]
+}
+
+func (m *EngineMonitor) openLocked(state, reason string, now time.Time) {
+	m.state.Windows = append(m.state.Windows, EngineWindow{State: state, Start: now, End: now, Reason: reason})
+}
+
+// pruneLocked drops the windows that ended before the window of the
+// history, keeping the current one
+func (m *EngineMonitor) pruneLocked(now time.Time) {
+	from := now.Add(-m.config.Window)
+	keep := 0
+	for keep < len(m.state.Windows)-1 && m.state.Windows[keep].End.Before(from) {
+		keep++
+	}
+	keep = max(keep, len(m.state.Windows)-maxEngineWindows)
+	m.state.Windows = m.state.Windows[keep:]
+}
+
+// Health reports the state of the engine at now
+func (m *EngineMonitor) Health(now time.Time) EngineHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := EngineHealth{State: EngineUnknown, Window: m.config.Window.String(), History: []EngineWindow{}, LastFailure: m.state.LastFailure}
+	if current := m.currentLocked(); current != nil && m.resumed {
+		health.State = current.State
+		since, checkedAt := current.Start, current.End
+		health.Since, health.CheckedAt = &since, &checkedAt
+	}
+
+	from := now.Add(-m.config.Window)
+	var observed, up time.Duration
+	for i := len(m.state.Windows) - 1; i >= 0; i-- {
+		w := m.state.Windows[i]
+		if w.End.Before(from) {
+			continue
+		}
+		health.History = append(health.History, w)
+		d := w.End.Sub(maxTime(w.Start, from))
+		observed += d
+		if w.State == EngineUp {
+			up += d
+		}
+	}
+	if observed > 0 {
+		availability := math.Round(float64(up)/float64(observed)*10000) / 100
+		health.Availability = &availability
+	}
+	return health
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// engineFailureReason classifies a failed health check
+func engineFailureReason(err error) string {
+	if errors.As(err, new(checkTimeoutError)) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "unreachable"
+	}
+	return "unhealthy"
+}
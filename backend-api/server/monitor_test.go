@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEngineMonitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine_status.json")
+	board, _ := NewStatusBoard("")
+	var healthErr error
+	check := func() error { return healthErr }
+	config := EngineMonitorConfig{Interval: time.Minute, Timeout: time.Second, Threshold: 2, Window: time.Hour}
+	monitor, err := NewEngineMonitor(path, config, check, board)
+	if err != nil {
+		t.Fatalf("NewEngineMonitor: %v", err)
+	}
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	if health := monitor.Health(start); health.State != EngineUnknown || health.Availability != nil {
+		t.Errorf("health before the first check = %+v, want unknown", health)
+	}
+
+	// One failed check is not an outage; two in a row are
+	monitor.Check(at(0))
+	healthErr = &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	monitor.Check(at(1))
+	if health := monitor.Health(at(1)); health.State != EngineUp || health.LastFailure == nil || health.LastFailure.Reason != "unreachable" {
+		t.Errorf("health after one failure = %+v, want up with the failure recorded", health)
+	}
+	monitor.Check(at(2))
+	if health := monitor.Health(at(2)); health.State != EngineDown || len(board.Current()) != 1 {
+		t.Errorf("health after two failures = %+v with %d messages, want down and announced", health, len(board.Current()))
+	}
+	healthErr = nil
+	monitor.Check(at(5))
+	monitor.Check(at(10))
+
+	health := monitor.Health(at(10))
+	if health.State != EngineUp || health.Since == nil || !health.Since.Equal(at(5)) || len(board.Current()) != 0 {
+		t.Errorf("health after recovery = %+v, want up since 08:05 with no message", health)
+	}
+	// Down from 08:02 to 08:05 out of 10 observed minutes
+	if health.Availability == nil || *health.Availability != 70 {
+		t.Errorf("availability = %v, want 70", health.Availability)
+	}
+	if len(health.History) != 3 || health.History[0].State != EngineUp || health.History[1].State != EngineDown || health.History[1].Reason != "unreachable" {
+		t.Errorf("history = %+v, want up, down, up, most recent first", health.History)
+	}
+
+	// The history survives a restart, and time the server was not running
+	// is not counted
+	monitor, err = NewEngineMonitor(path, config, check, board)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	monitor.Check(at(40))
+	health = monitor.Health(at(40))
+	if len(health.History) != 4 || health.Availability == nil || *health.Availability != 70 {
+		t.Errorf("health after a restart = %+v, want a new window and the same availability", health)
+	}
+
+	// Windows that ended before the period are dropped
+	monitor.Check(at(75))
+	if health := monitor.Health(at(75)); len(health.History) != 1 || *health.Availability != 100 {
+		t.Errorf("health an hour later = %+v, want only the current window", health)
+	}
+}
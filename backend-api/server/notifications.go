@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationKind says what a notification is about
+type NotificationKind string
+
+const (
+	NotificationJobCompleted     NotificationKind = "job_completed"
+	NotificationAnswerStale      NotificationKind = "answer_stale"
+	NotificationDocumentIngested NotificationKind = "document_ingested"
+	NotificationMention          NotificationKind = "mention"
+	NotificationAlert            NotificationKind = "alert"
+	NotificationDisclaimerReview NotificationKind = "disclaimer_review"
+	NotificationArticleChanged   NotificationKind = "article_changed"
+)
+
+var notificationKinds = []NotificationKind{
+	NotificationJobCompleted, NotificationAnswerStale, NotificationDocumentIngested, NotificationMention, NotificationAlert,
+	NotificationDisclaimerReview, NotificationArticleChanged,
+}
+
+const (
+	// maxNotifications is how many notifications are kept per recipient
+	maxNotifications = 200
+
+	notificationHeartbeat = 30 * time.Second
+)
+
+// mentionPattern finds @user mentions in comments
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// Notification is an in-app notification. It goes to one user of a tenant,
+// or to every user of the tenant when User is empty.
+type Notification struct {
+	ID        string           `json:"id"`
+	TenantID  string           `json:"tenant_id,omitempty"`
+	User      string           `json:"user,omitempty"`
+	Kind      NotificationKind `json:"kind"`
+	Title     string           `json:"title"`
+	Body      string           `json:"body,omitempty"`
+	Link      string           `json:"link,omitempty"`
+	Read      bool             `json:"read"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// storedNotification keeps who has read a notification; tenant-wide
+// notifications are read per user
+type storedNotification struct {
+	Notification
+	ReadBy []string `json:"read_by,omitempty"`
+}
+
+// view is the notification as seen by a user
+func (n *storedNotification) view(user string) Notification {
+	v := n.Notification
+	v.Read = slices.Contains(n.ReadBy, user)
+	return v
+}
+
+func (n *storedNotification) visibleTo(tenantID, user string) bool {
+	return n.TenantID == tenantID && (n.User == "" || n.User == user)
+}
+
+var errNotificationNotFound = errors.New("notification not found")
+
+// NotificationCenter stores notifications, persisted to a JSON file when a
+// path is configured, and pushes new ones to subscribed streams
+type NotificationCenter struct {
+	mu            sync.Mutex
+	path          string
+	notifications []*storedNotification
+	subscribers   map[chan Notification]notificationSubscriber
+
+	// preferences leave out the kinds users muted or take out of the app
+	preferences *PreferenceStore
+}
+
+type notificationSubscriber struct {
+	tenantID string
+	user     string
+}
+
+func NewNotificationCenter(path string, preferences *PreferenceStore) (*NotificationCenter, error) {
+	center := &NotificationCenter{
+		path:        path,
+		subscribers: make(map[chan Notification]notificationSubscriber),
+		preferences: preferences,
+	}
+	if path == "" {
+		return center, nil
+	}
+
+	if _, err := readJSONFile(path, &center.notifications); err != nil {
+		return nil, fmt.Errorf("failed to load notifications: %w", err)
+	}
+	return center, nil
+}
+
+// Publish stores a notification and pushes it to the recipients' streams.
+// A nil center drops notifications.
+func (nc *NotificationCenter) Publish(n Notification) {
+	if nc == nil {
+		return
+	}
+	if n.User != "" && !nc.inApp(n.TenantID, n.User, n.Kind) {
+		return
+	}
+	n.ID = "n_" + randomHex(8)
+	n.Read = false
+	n.CreatedAt = time.Now().UTC()
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.notifications = append(nc.notifications, &storedNotification{Notification: n})
+	nc.trimLocked(n.TenantID, n.User)
+	if err := nc.saveLocked(); err != nil {
+		slog.Error("Failed to save notifications", "error", err)
+	}
+
+	for ch, sub := range nc.subscribers {
+		if sub.tenantID != n.TenantID || (n.User != "" && n.User != sub.user) || !nc.inApp(sub.tenantID, sub.user, n.Kind) {
+			continue
+		}
+		select {
+		case ch <- n:
+		default:
+			// A stream that is not keeping up catches up from the list
+		}
+	}
+}
+
+// inApp reports whether a user takes a kind of notification in the app
+func (nc *NotificationCenter) inApp(tenantID, user string, kind NotificationKind) bool {
+	return nc.preferences.Get(tenantID, user).Notifications.Delivers(ChannelInApp, kind)
+}
+
+// trimLocked drops the oldest notifications of a recipient beyond
+// maxNotifications
+func (nc *NotificationCenter) trimLocked(tenantID, user string) {
+	count := 0
+	for i := len(nc.notifications) - 1; i >= 0; i-- {
+		n := nc.notifications[i]
+		if n.TenantID != tenantID || n.User != user {
+			continue
+		}
+		count++
+		if count > maxNotifications {
+			nc.notifications = slices.Delete(nc.notifications, i, i+1)
+		}
+	}
+}
+
+// List returns a user's notifications, newest first, and the number unread
+func (nc *NotificationCenter) List(tenantID, user string, unreadOnly bool, limit int) ([]Notification, int) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	prefs := nc.preferences.Get(tenantID, user).Notifications
+	list := []Notification{}
+	unread := 0
+	for i := len(nc.notifications) - 1; i >= 0; i-- {
+		n := nc.notifications[i]
+		if !n.visibleTo(tenantID, user) || !prefs.Delivers(ChannelInApp, n.Kind) {
+			continue
+		}
+		v := n.view(user)
+		if !v.Read {
+			unread++
+		}
+		if (unreadOnly && v.Read) || len(list) >= limit {
+			continue
+		}
+		list = append(list, v)
+	}
+	return list, unread
+}
+
+// MarkRead sets the read state of one of a user's notifications, or of all
+// of them when id is empty
+func (nc *NotificationCenter) MarkRead(tenantID, user, id string, read bool) (int, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	changed := 0
+	for _, n := range nc.notifications {
+		if !n.visibleTo(tenantID, user) || (id != "" && n.ID != id) {
+			continue
+		}
+		i := slices.Index(n.ReadBy, user)
+		switch {
+		case read && i < 0:
+			n.ReadBy = append(n.ReadBy, user)
+			changed++
+		case !read && i >= 0:
+			n.ReadBy = slices.Delete(n.ReadBy, i, i+1)
+			changed++
+		}
+		if id != "" {
+			return changed, nc.saveLocked()
+		}
+	}
+	if id != "" {
+		return 0, errNotificationNotFound
+	}
+	return changed, nc.saveLocked()
+}
+
+// Subscribe returns a channel receiving a user's new notifications until
+// cancel is called
+func (nc *NotificationCenter) Subscribe(tenantID, user string) (<-chan Notification, func()) {
+	ch := make(chan Notification, 16)
+	nc.mu.Lock()
+	nc.subscribers[ch] = notificationSubscriber{tenantID: tenantID, user: user}
+	nc.mu.Unlock()
+	return ch, func() {
+		nc.mu.Lock()
+		delete(nc.subscribers, ch)
+		nc.mu.Unlock()
+	}
+}
+
+func (nc *NotificationCenter) saveLocked() error {
+	if nc.path == "" {
+		return nil
+	}
+	return writeJSONFile(nc.path, nc.notifications)
+}
+
+// Notify publishes operational alerts to callers without a tenant, so the
+// center can be one of the alert notifiers
+func (nc *NotificationCenter) Notify(ctx context.Context, alert Alert) error {
+	nc.Publish(Notification{
+		Kind:  NotificationAlert,
+		Title: fmt.Sprintf("[%s] %s", alert.Status, alert.Name),
+		Body:  alert.Summary,
+		Link:  "/admin/slo",
+	})
+	return nil
+}
+
+// mentions returns the users mentioned in a comment, without duplicates
+func mentions(body string) []string {
+	var users []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		user := strings.TrimRight(m[1], ".-")
+		if user != "" && !slices.Contains(users, user) {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// Handlers
+
+func listNotificationsHandler(center *NotificationCenter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		limit := 50
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxNotifications {
+				abortWithInvalidParam(c, "limit", fmt.Sprintf("limit must be between 1 and %d", maxNotifications))
+				return
+			}
+			limit = n
+		}
+		tenant, _ := callerTenant(c)
+		list, unread := center.List(tenant.ID, user, c.Query("unread") == "true", limit)
+		c.JSON(http.StatusOK, gin.H{"notifications": list, "unread": unread})
+	}
+}
+
+// MarkNotificationsRequest is the body of POST /api/notifications/read
+type MarkNotificationsRequest struct {
+	// IDs are the notifications to mark; all of the caller's when empty
+	IDs []string `json:"ids,omitempty"`
+
+	// Read marks the notifications unread when false
+	Read *bool `json:"read,omitempty"`
+}
+
+func markNotificationsHandler(center *NotificationCenter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MarkNotificationsRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		read := req.Read == nil || *req.Read
+		tenant, _ := callerTenant(c)
+
+		ids := req.IDs
+		if len(ids) == 0 {
+			ids = []string{""}
+		}
+		changed := 0
+		for _, id := range ids {
+			n, err := center.MarkRead(tenant.ID, user, id, read)
+			if errors.Is(err, errNotificationNotFound) {
+				abortWithError(c, ErrCodeNotificationNotFound, fmt.Sprintf("Notification %q not found", id))
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to save notifications", "error", err)
+				abortWithError(c, ErrCodeInternal, "Failed to save notifications")
+				return
+			}
+			changed += n
+		}
+		_, unread := center.List(tenant.ID, user, true, 0)
+		c.JSON(http.StatusOK, gin.H{"changed": changed, "unread": unread})
+	}
+}
+
+// streamNotificationsHandler pushes new notifications as server-sent events
+// until the client disconnects or the server stops
+func streamNotificationsHandler(center *NotificationCenter, stop <-chan struct{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		tenant, _ := callerTenant(c)
+		ch, cancel := center.Subscribe(tenant.ID, user)
+		defer cancel()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		_, unread := center.List(tenant.ID, user, true, 0)
+		c.SSEvent("unread", gin.H{"unread": unread})
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(notificationHeartbeat)
+		defer heartbeat.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case n := <-ch:
+				c.SSEvent("notification", n)
+				return true
+			case <-heartbeat.C:
+				// A comment line keeps proxies from closing an idle stream
+				io.WriteString(w, ": keep-alive\n\n")
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			case <-stop:
+				return false
+			}
+		})
+	}
+}
+
+// PublishNotificationRequest is the body of POST /admin/notifications, used
+// by the ingestion pipeline and other jobs to reach users in-app
+type PublishNotificationRequest struct {
+	TenantID string           `json:"tenant_id,omitempty"`
+	User     string           `json:"user,omitempty"`
+	Kind     NotificationKind `json:"kind" binding:"required"`
+	Title    string           `json:"title" binding:"required"`
+	Body     string           `json:"body,omitempty"`
+	Link     string           `json:"link,omitempty"`
+}
+
+func publishNotificationHandler(center *NotificationCenter, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PublishNotificationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if !slices.Contains(notificationKinds, req.Kind) {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("kind must be one of %v", notificationKinds))
+			return
+		}
+		if req.TenantID != "" {
+			if _, ok := tenants.Get(req.TenantID); !ok {
+				abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Tenant %q not found", req.TenantID))
+				return
+			}
+		}
+		center.Publish(Notification{
+			TenantID: req.TenantID,
+			User:     req.User,
+			Kind:     req.Kind,
+			Title:    truncateUTF8(strings.TrimSpace(req.Title), 200),
+			Body:     truncateUTF8(req.Body, 2000),
+			Link:     req.Link,
+		})
+		c.Status(http.StatusAccepted)
+	}
+}
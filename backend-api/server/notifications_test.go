@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestMentions(t *testing.T) {
+	got := mentions("@minh xem lại giúp, cc @thao. Email lan@example.com, @minh")
+	if strings.Join(got, ",") != "minh,thao" {
+		t.Errorf("mentions = %v, want [minh thao]", got)
+	}
+}
+
+func TestNotificationCenter(t *testing.T) {
+	center, err := NewNotificationCenter("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, cancel := center.Subscribe("acme", "lan")
+	defer cancel()
+
+	center.Publish(Notification{TenantID: "acme", Kind: NotificationDocumentIngested, Title: "Nghị định 145/2020 đã được nạp"})
+	center.Publish(Notification{TenantID: "acme", User: "minh", Kind: NotificationAnswerStale, Title: "Câu trả lời đã cũ"})
+	center.Publish(Notification{TenantID: "other", Kind: NotificationJobCompleted, Title: "Xong"})
+
+	select {
+	case n := <-ch:
+		if n.Kind != NotificationDocumentIngested {
+			t.Errorf("pushed %+v, want the tenant-wide notification", n)
+		}
+	default:
+		t.Fatal("tenant-wide notification was not pushed")
+	}
+	if len(ch) != 0 {
+		t.Errorf("%d more notifications pushed, want none for other users and tenants", len(ch))
+	}
+
+	// Tenant-wide notifications are read per user
+	if _, err := center.MarkRead("acme", "lan", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, unread := center.List("acme", "lan", false, 10); unread != 0 {
+		t.Errorf("lan unread = %d, want 0", unread)
+	}
+	if list, unread := center.List("acme", "minh", false, 10); len(list) != 2 || unread != 2 {
+		t.Errorf("minh = %d notifications, %d unread, want 2 and 2", len(list), unread)
+	}
+	latest, _ := center.List("acme", "minh", false, 1)
+	if _, err := center.MarkRead("acme", "lan", latest[0].ID, true); err == nil {
+		t.Error("marking another user's notification succeeded")
+	}
+}
+
+func TestNotificationsAPI(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 60 ngày.", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Stream minh's notifications
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/notifications/stream", nil)
+	req.Header.Set("X-User-ID", "minh")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer stream.Body.Close()
+	if ct := stream.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("stream Content-Type = %q", ct)
+	}
+	events := bufio.NewScanner(stream.Body)
+	nextEvent := func() string {
+		t.Helper()
+		for events.Scan() {
+			if name, ok := strings.CutPrefix(events.Text(), "event:"); ok {
+				return name
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return ""
+	}
+	if name := nextEvent(); name != "unread" {
+		t.Fatalf("first event = %q, want unread", name)
+	}
+
+	h := srv.Handler()
+	question := "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	var answer engine.LegalQueryResponse
+	json.Unmarshal(doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question}).Body.Bytes(), &answer)
+	rec := doWithKey(t, h, userKey(t, h, "lan"), http.MethodPost, "/api/history/"+answer.HistoryID+"/comments", CommentRequest{Body: "@minh kiểm tra giúp Điều 25"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("comment status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if name := nextEvent(); name != "notification" {
+		t.Fatalf("event = %q, want notification", name)
+	}
+
+	var listed struct {
+		Notifications []Notification `json:"notifications"`
+		Unread        int            `json:"unread"`
+	}
+	json.Unmarshal(doAs(t, h, "minh", http.MethodGet, "/api/notifications", nil).Body.Bytes(), &listed)
+	if listed.Unread != 1 || len(listed.Notifications) != 1 || listed.Notifications[0].Kind != NotificationMention {
+		t.Fatalf("notifications = %+v, want one unread mention", listed)
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/notifications", nil).Body.Bytes(), &listed)
+	if listed.Unread != 0 {
+		t.Errorf("author has %d unread, want 0", listed.Unread)
+	}
+
+	rec = doAs(t, h, "minh", http.MethodPost, "/api/notifications/read", MarkNotificationsRequest{IDs: []string{"n_missing"}})
+	if code := decodeError(t, rec).Code; code != ErrCodeNotificationNotFound {
+		t.Errorf("marking a missing notification = %s, want %s", code, ErrCodeNotificationNotFound)
+	}
+	doAs(t, h, "minh", http.MethodPost, "/api/notifications/read", nil)
+	json.Unmarshal(doAs(t, h, "minh", http.MethodGet, "/api/notifications?unread=true", nil).Body.Bytes(), &listed)
+	if listed.Unread != 0 || len(listed.Notifications) != 0 {
+		t.Errorf("after read-all = %+v, want nothing unread", listed)
+	}
+}
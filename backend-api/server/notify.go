@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Alert is an operational event sent to the configured notifiers
+type Alert struct {
+	Name     string    `json:"name"`
+	Severity string    `json:"severity"`
+	Status   string    `json:"status"`
+	Summary  string    `json:"summary"`
+	FiredAt  time.Time `json:"fired_at"`
+
+	// Text repeats the summary for Slack-compatible incoming webhooks
+	Text string `json:"text"`
+}
+
+// Alert statuses
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Notifier delivers alerts
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// logNotifier writes alerts to the server log
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, alert Alert) error {
+	slog.WarnContext(ctx, "Alert", "status", alert.Status, "name", alert.Name, "severity", alert.Severity, "summary", alert.Summary)
+	return nil
+}
+
+// WebhookNotifier posts alerts as JSON to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	alert.Text = fmt.Sprintf("[%s] %s: %s", alert.Status, alert.Name, alert.Summary)
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// multiNotifier delivers every alert to each notifier, logging failures
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(ctx context.Context, alert Alert) error {
+	for _, n := range m {
+		if err := n.Notify(ctx, alert); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver alert", "name", alert.Name, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// OCREngine extracts the text of a photographed or scanned document
+type OCREngine interface {
+	Recognize(ctx context.Context, image []byte) (string, error)
+}
+
+// TesseractOCR runs the tesseract command line tool
+type TesseractOCR struct {
+	path      string
+	languages string
+	timeout   time.Duration
+}
+
+// NewTesseractOCR locates the tesseract binary. It returns an error when the
+// binary is not installed, in which case image uploads are disabled.
+func NewTesseractOCR(command, languages string, timeout time.Duration) (*TesseractOCR, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", command, err)
+	}
+	return &TesseractOCR{path: path, languages: languages, timeout: timeout}, nil
+}
+
+func (t *TesseractOCR) Recognize(ctx context.Context, image []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.path, "stdin", "stdout", "-l", t.languages)
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("ocr timed out after %v", t.timeout)
+		}
+		return "", fmt.Errorf("ocr failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return cleanOCRText(stdout.String()), nil
+}
+
+// cleanOCRText drops form feeds and collapses the blank lines tesseract emits
+// between blocks
+func cleanOCRText(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\f", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isImageUpload reports whether an uploaded file should go through OCR
+func isImageUpload(name, contentType string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".webp", ".tif", ".tiff", ".bmp":
+		return true
+	}
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// Document types detected in OCR text
+const (
+	DocumentPenaltyDecision = "penalty_decision"
+	DocumentViolationRecord = "violation_record"
+	DocumentContract        = "contract"
+	DocumentDecision        = "decision"
+	DocumentNotice          = "notice"
+	DocumentUnknown         = "unknown"
+)
+
+// documentMarkers are matched against the upper-cased OCR text in order, so
+// more specific titles come first
+var documentMarkers = []struct {
+	marker string
+	kind   string
+}{
+	{"QUYẾT ĐỊNH XỬ PHẠT", DocumentPenaltyDecision},
+	{"BIÊN BẢN VI PHẠM", DocumentViolationRecord},
+	{"HỢP ĐỒNG", DocumentContract},
+	{"QUYẾT ĐỊNH", DocumentDecision},
+	{"THÔNG BÁO", DocumentNotice},
+}
+
+// minDocumentLetters is the number of letters below which OCR output is
+// treated as noise rather than a document
+const minDocumentLetters = 20
+
+// detectDocument reports whether OCR text looks like a document and, if so,
+// which kind of legal document it is
+func detectDocument(text string) (string, bool) {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minDocumentLetters {
+		return "", false
+	}
+
+	upper := strings.ToUpper(text)
+	for _, m := range documentMarkers {
+		if strings.Contains(upper, m.marker) {
+			return m.kind, true
+		}
+	}
+	return DocumentUnknown, true
+}
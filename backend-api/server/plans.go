@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Plan describes the query features available to a caller
+type Plan struct {
+	Name          string `json:"name"`
+	MaxIterations int    `json:"max_iterations"`
+	MaxTopK       int    `json:"max_top_k"`
+	WebSearch     bool   `json:"web_search"`
+	Compare       bool   `json:"compare"`
+
+	// MaxQuestionLength is the server-wide limit on the characters of a
+	// question; 0 means no limit
+	MaxQuestionLength int `json:"max_question_length,omitempty"`
+}
+
+// Engine-side hard limits, mirrored from the Python QueryRequest model
+const (
+	engineMaxIterations = 10
+	engineMaxTopK       = 20
+)
+
+const defaultPlanName = "unlimited"
+
+var plans = map[string]Plan{
+	"free": {
+		Name:          "free",
+		MaxIterations: 3,
+		MaxTopK:       5,
+		WebSearch:     false,
+		Compare:       false,
+	},
+	"standard": {
+		Name:          "standard",
+		MaxIterations: 5,
+		MaxTopK:       10,
+		WebSearch:     true,
+		Compare:       false,
+	},
+	defaultPlanName: {
+		Name:          defaultPlanName,
+		MaxIterations: engineMaxIterations,
+		MaxTopK:       engineMaxTopK,
+		WebSearch:     true,
+		Compare:       true,
+	},
+}
+
+func lookupPlan(name string) (Plan, bool) {
+	plan, ok := plans[name]
+	return plan, ok
+}
+
+func planNames() []string {
+	names := make([]string, 0, len(plans))
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const planContextKey = "plan"
+
+// QueryCaps are server-wide hard limits that apply on top of every plan
+type QueryCaps struct {
+	MaxIterations     int
+	MaxTopK           int
+	MaxQuestionLength int
+}
+
+// capped returns the plan with its limits reduced to the server-wide caps
+// and the server-wide question length
+func (p Plan) capped(caps QueryCaps) Plan {
+	p.MaxIterations = min(p.MaxIterations, caps.MaxIterations)
+	p.MaxTopK = min(p.MaxTopK, caps.MaxTopK)
+	p.MaxQuestionLength = caps.MaxQuestionLength
+	return p
+}
+
+// planMiddleware attaches the caller's plan to the request context: the
+// tenant's plan when the caller belongs to one, the default plan otherwise,
+// with limits reduced to the server-wide caps. It must run after
+// tenantMiddleware.
+func planMiddleware(defaultPlan Plan, caps QueryCaps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plan := defaultPlan
+		if tenant, ok := callerTenant(c); ok {
+			if tenantPlan, ok := lookupPlan(tenant.Plan); ok {
+				plan = tenantPlan
+			}
+		}
+		c.Set(planContextKey, plan.capped(caps))
+		c.Next()
+	}
+}
+
+// callerPlan returns the plan attached by planMiddleware
+func callerPlan(c *gin.Context) Plan {
+	if plan, ok := c.Get(planContextKey); ok {
+		return plan.(Plan)
+	}
+	return plans[defaultPlanName]
+}
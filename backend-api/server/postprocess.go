@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// PostProcessInput is the answer a post-processor works on, with the query
+// it answers
+type PostProcessInput struct {
+	Question string                     `json:"question"`
+	TenantID string                     `json:"tenant_id,omitempty"`
+	Response *engine.LegalQueryResponse `json:"response"`
+}
+
+// PostProcessor adjusts an answer before it is returned to the client. It
+// may rewrite Response.Answer, add fields with setExtension, or refuse the
+// answer by returning a *PolicyViolation.
+type PostProcessor interface {
+	Name() string
+	Process(ctx context.Context, in *PostProcessInput) error
+}
+
+// PolicyViolation is returned by a post-processor that refuses an answer
+type PolicyViolation struct {
+	Processor string
+	Message   string
+}
+
+func (e *PolicyViolation) Error() string {
+	return fmt.Sprintf("post-processor %s refused the answer: %s", e.Processor, e.Message)
+}
+
+// defaultDisclaimer is appended by the disclaimer post-processor
+const defaultDisclaimer = "Thông tin trên chỉ mang tính tham khảo, không thay thế ý kiến tư vấn của luật sư."
+
+// PostProcessConfig selects and configures the post-processors
+type PostProcessConfig struct {
+	// Processors are built-in or registered names and sidecar hook URLs,
+	// run in order
+	Processors   []string
+	Disclaimer   string
+	BlockedTerms []string
+	HookTimeout  time.Duration
+
+	// Disclaimers stamps every answer with the disclaimer of its tenant,
+	// which the disclaimer post-processor appends instead of Disclaimer
+	Disclaimers *DisclaimerStore
+
+	// FailClosed rejects the answer when a post-processor fails instead of
+	// returning it unprocessed with a warning
+	FailClosed bool
+}
+
+// PostProcessorFactory builds a post-processor from the configuration
+type PostProcessorFactory func(config PostProcessConfig) (PostProcessor, error)
+
+var postProcessorFactories = map[string]PostProcessorFactory{
+	"disclaimer": func(config PostProcessConfig) (PostProcessor, error) {
+		return disclaimerProcessor{text: config.Disclaimer}, nil
+	},
+	"answer-stats": func(PostProcessConfig) (PostProcessor, error) {
+		return answerStatsProcessor{}, nil
+	},
+	"blocked-terms": func(config PostProcessConfig) (PostProcessor, error) {
+		if len(config.BlockedTerms) == 0 {
+			return nil, errors.New("blocked-terms needs POST_PROCESSOR_BLOCKED_TERMS")
+		}
+		return blockedTermsProcessor{terms: config.BlockedTerms}, nil
+	},
+}
+
+// RegisterPostProcessor makes a compiled-in post-processor available to
+// POST_PROCESSORS under name. Call it from an init function.
+func RegisterPostProcessor(name string, factory PostProcessorFactory) {
+	if _, ok := postProcessorFactories[name]; ok {
+		panic(fmt.Sprintf("post-processor %q registered twice", name))
+	}
+	postProcessorFactories[name] = factory
+}
+
+func postProcessorNames() []string {
+	names := make([]string, 0, len(postProcessorFactories))
+	for name := range postProcessorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PostProcessorChain runs post-processors in order
+type PostProcessorChain struct {
+	processors  []PostProcessor
+	failClosed  bool
+	disclaimers *DisclaimerStore
+}
+
+// NewPostProcessorChain builds the configured post-processors. Entries that
+// are http(s) URLs are sidecar hooks; the others name registered ones.
+func NewPostProcessorChain(config PostProcessConfig) (*PostProcessorChain, error) {
+	chain := &PostProcessorChain{failClosed: config.FailClosed, disclaimers: config.Disclaimers}
+	for _, name := range config.Processors {
+		if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+			chain.processors = append(chain.processors, NewHookProcessor(name, config.HookTimeout))
+			continue
+		}
+		factory, ok := postProcessorFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown post-processor %q (available: %v)", name, postProcessorNames())
+		}
+		processor, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create post-processor %q: %w", name, err)
+		}
+		chain.processors = append(chain.processors, processor)
+	}
+	return chain, nil
+}
+
+// Names returns the post-processors in the order they run
+func (p *PostProcessorChain) Names() []string {
+	names := make([]string, len(p.processors))
+	for i, processor := range p.processors {
+		names[i] = processor.Name()
+	}
+	return names
+}
+
+// Run stamps the answer with its disclaimer and applies every
+// post-processor to it. Failures are returned as warnings, or as an error in
+// fail-closed mode; a policy violation is always an error.
+func (p *PostProcessorChain) Run(ctx context.Context, in *PostProcessInput) ([]engine.Warning, error) {
+	if p == nil || in.Response.NeedsClarification {
+		return nil, nil
+	}
+	in.Response.Disclaimer = p.disclaimers.Stamp(in.TenantID)
+	var warnings []engine.Warning
+	var applied []string
+	for _, processor := range p.processors {
+		err := processor.Process(ctx, in)
+		if err == nil {
+			applied = append(applied, processor.Name())
+			continue
+		}
+		var violation *PolicyViolation
+		if errors.As(err, &violation) || p.failClosed {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Post-processor failed", "processor", processor.Name(), "error", err)
+		warnings = append(warnings, engine.Warning{
+			Field:   "answer",
+			Code:    WarningPostProcessorFailed,
+			Message: fmt.Sprintf("post-processor %s failed and was skipped", processor.Name()),
+		})
+	}
+	addPostProcessorMeta(in.Response, applied)
+	return warnings, nil
+}
+
+// postProcessErrorCode maps a Run error onto the error catalog
+func postProcessErrorCode(err error) ErrorCode {
+	var violation *PolicyViolation
+	if errors.As(err, &violation) {
+		return ErrCodePolicyViolation
+	}
+	return ErrCodeInternal
+}
+
+// setExtension adds a field under the extensions of a response
+func setExtension(resp *engine.LegalQueryResponse, name string, value any) {
+	if resp.Extensions == nil {
+		resp.Extensions = make(map[string]any)
+	}
+	resp.Extensions[name] = value
+}
+
+// disclaimerProcessor appends a disclaimer to every answer: the one the
+// answer was stamped with, or the configured text
+type disclaimerProcessor struct {
+	text string
+}
+
+func (disclaimerProcessor) Name() string { return "disclaimer" }
+
+func (p disclaimerProcessor) Process(_ context.Context, in *PostProcessInput) error {
+	text := p.text
+	if in.Response.Disclaimer != nil {
+		text = in.Response.Disclaimer.Text
+	}
+	if !strings.Contains(in.Response.Answer, text) {
+		in.Response.Answer = strings.TrimRight(in.Response.Answer, "\n") + "\n\n" + text
+	}
+	return nil
+}
+
+// answerStatsProcessor adds the length and citations of the answer
+type answerStatsProcessor struct{}
+
+func (answerStatsProcessor) Name() string { return "answer-stats" }
+
+func (answerStatsProcessor) Process(_ context.Context, in *PostProcessInput) error {
+	setExtension(in.Response, "answer_stats", map[string]int{
+		"words":     len(strings.Fields(in.Response.Answer)),
+		"citations": len(answerCitations(in.Response)),
+		"sources":   len(in.Response.SearchResults) + len(in.Response.WebResults),
+	})
+	return nil
+}
+
+// blockedTermsProcessor refuses answers containing any of the terms
+type blockedTermsProcessor struct {
+	terms []string
+}
+
+func (blockedTermsProcessor) Name() string { return "blocked-terms" }
+
+func (p blockedTermsProcessor) Process(_ context.Context, in *PostProcessInput) error {
+	answer := strings.ToLower(in.Response.Answer)
+	for _, term := range p.terms {
+		if strings.Contains(answer, strings.ToLower(term)) {
+			return &PolicyViolation{Processor: p.Name(), Message: fmt.Sprintf("the answer contains the blocked term %q", term)}
+		}
+	}
+	return nil
+}
+
+// HookResult is what a sidecar hook answers. Every field is optional.
+type HookResult struct {
+	// Answer replaces the answer when set
+	Answer *string `json:"answer,omitempty"`
+
+	// Extensions are merged into the extensions of the response
+	Extensions map[string]any `json:"extensions,omitempty"`
+
+	// Reject refuses the answer with a message for the client
+	Reject *struct {
+		Message string `json:"message"`
+	} `json:"reject,omitempty"`
+}
+
+// HookProcessor is a post-processor running in a sidecar: the answer is
+// POSTed to its URL as a PostProcessInput and it replies with a HookResult
+type HookProcessor struct {
+	url    string
+	client *http.Client
+}
+
+func NewHookProcessor(url string, timeout time.Duration) *HookProcessor {
+	return &HookProcessor{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HookProcessor) Name() string { return h.url }
+
+func (h *HookProcessor) Process(ctx context.Context, in *PostProcessInput) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hook request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call hook: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read hook response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hook returned status %d: %s", resp.StatusCode, data)
+	}
+
+	var result HookResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal hook response: %w", err)
+	}
+	if result.Reject != nil {
+		return &PolicyViolation{Processor: h.Name(), Message: result.Reject.Message}
+	}
+	if result.Answer != nil {
+		in.Response.Answer = *result.Answer
+	}
+	for name, value := range result.Extensions {
+		setExtension(in.Response, name, value)
+	}
+	return nil
+}
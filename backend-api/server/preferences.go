@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Notification channels. The notification center delivers in_app
+// notifications; email and webhook are left to external delivery services,
+// which read the preference.
+const (
+	ChannelInApp   = "in_app"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+var notificationChannels = []string{ChannelInApp, ChannelEmail, ChannelWebhook}
+
+// UserPreferences are a user's defaults, stored server-side so they follow
+// the user across devices. Query defaults apply when a query omits the
+// parameter, ahead of the tenant defaults.
+type UserPreferences struct {
+	Language      string                  `json:"language,omitempty"`
+	Style         *engine.AnswerStyle     `json:"style,omitempty"`
+	Collection    string                  `json:"collection,omitempty"`
+	Notifications NotificationPreferences `json:"notifications"`
+	UpdatedAt     time.Time               `json:"updated_at,omitzero"`
+}
+
+// NotificationPreferences choose how a user is notified
+type NotificationPreferences struct {
+	// Channels default to in_app when unset
+	Channels []string `json:"channels,omitempty"`
+
+	// Muted kinds are not delivered on any channel
+	Muted []NotificationKind `json:"muted,omitempty"`
+}
+
+// Delivers reports whether a notification kind reaches the user on a channel
+func (p NotificationPreferences) Delivers(channel string, kind NotificationKind) bool {
+	if slices.Contains(p.Muted, kind) {
+		return false
+	}
+	if p.Channels == nil {
+		return channel == ChannelInApp
+	}
+	return slices.Contains(p.Channels, channel)
+}
+
+// apply fills the query parameters the request leaves unset. Style
+// dimensions are filled one by one.
+func (p UserPreferences) apply(req *LegalQueryRequest) {
+	if req.Language == "" {
+		req.Language = p.Language
+	}
+	if req.Collection == "" {
+		req.Collection = p.Collection
+	}
+	if p.Style == nil {
+		return
+	}
+	style := *p.Style
+	if req.Style != nil {
+		if req.Style.Length != "" {
+			style.Length = req.Style.Length
+		}
+		if req.Style.Tone != "" {
+			style.Tone = req.Style.Tone
+		}
+		if req.Style.Audience != "" {
+			style.Audience = req.Style.Audience
+		}
+	}
+	req.Style = &style
+}
+
+// validatePreferences reports preferences a query would reject
+func validatePreferences(p UserPreferences) []Violation {
+	violations := validateQueryRequest(&LegalQueryRequest{
+		Question:   "preferences",
+		Language:   p.Language,
+		Style:      p.Style,
+		Collection: p.Collection,
+	}, Plan{})
+	for _, channel := range p.Notifications.Channels {
+		if !slices.Contains(notificationChannels, channel) {
+			violations = append(violations, Violation{
+				Field:   "notifications.channels",
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("channel %q must be one of %v", channel, notificationChannels),
+			})
+		}
+	}
+	for _, kind := range p.Notifications.Muted {
+		if !slices.Contains(notificationKinds, kind) {
+			violations = append(violations, Violation{
+				Field:   "notifications.muted",
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("kind %q must be one of %v", kind, notificationKinds),
+			})
+		}
+	}
+	return violations
+}
+
+// userPreferences are the stored preferences of one user of a tenant
+type userPreferences struct {
+	TenantID    string          `json:"tenant_id,omitempty"`
+	User        string          `json:"user"`
+	Preferences UserPreferences `json:"preferences"`
+}
+
+// PreferenceStore keeps user preferences, persisted to a JSON file when a
+// path is configured
+type PreferenceStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*userPreferences
+}
+
+func NewPreferenceStore(path string) (*PreferenceStore, error) {
+	store := &PreferenceStore{
+		path:  path,
+		users: make(map[string]*userPreferences),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var users []*userPreferences
+	if _, err := readJSONFile(path, &users); err != nil {
+		return nil, fmt.Errorf("failed to load preferences: %w", err)
+	}
+	for _, u := range users {
+		store.users[preferenceKey(u.TenantID, u.User)] = u
+	}
+	return store, nil
+}
+
+func preferenceKey(tenantID, user string) string {
+	return tenantID + "\x00" + user
+}
+
+// Get returns a user's preferences; users without stored preferences get
+// the zero value. A nil store has no preferences.
+func (s *PreferenceStore) Get(tenantID, user string) UserPreferences {
+	if s == nil || user == "" {
+		return UserPreferences{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.users[preferenceKey(tenantID, user)]; ok {
+		return u.Preferences
+	}
+	return UserPreferences{}
+}
+
+// Put replaces a user's preferences
+func (s *PreferenceStore) Put(tenantID, user string, p UserPreferences) (UserPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.UpdatedAt = time.Now().UTC()
+	s.users[preferenceKey(tenantID, user)] = &userPreferences{TenantID: tenantID, User: user, Preferences: p}
+	return p, s.saveLocked()
+}
+
+// Delete resets a user's preferences
+func (s *PreferenceStore) Delete(tenantID, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, preferenceKey(tenantID, user))
+	return s.saveLocked()
+}
+
+func (s *PreferenceStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	users := make([]*userPreferences, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return preferenceKey(users[i].TenantID, users[i].User) < preferenceKey(users[j].TenantID, users[j].User)
+	})
+	return writeJSONFile(s.path, users)
+}
+
+// Handlers
+
+// requireUser returns the caller's user ID, or aborts when the request has
+// no X-User-ID header
+func requireUser(c *gin.Context) (string, bool) {
+	user := callerUser(c)
+	if user == "" {
+		abortWithError(c, ErrCodeInvalidRequest, "X-User-ID header is required")
+		return "", false
+	}
+	return user, true
+}
+
+func getPreferencesHandler(preferences *PreferenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, preferences.Get(tenant.ID, user))
+	}
+}
+
+func putPreferencesHandler(preferences *PreferenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req UserPreferences
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if violations := validatePreferences(req); len(violations) > 0 {
+			abortWithViolations(c, violations)
+			return
+		}
+		tenant, _ := callerTenant(c)
+		saved, err := preferences.Put(tenant.ID, user, req)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save preferences", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save preferences")
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+}
+
+func deletePreferencesHandler(preferences *PreferenceStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		tenant, _ := callerTenant(c)
+		if err := preferences.Delete(tenant.ID, user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save preferences", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to reset preferences")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestPreferences(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Probation may not exceed 60 days.", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	rec := doAs(t, h, "lan", http.MethodPut, "/api/me/preferences", UserPreferences{Language: "fr", Collection: "labor law"})
+	if code := decodeError(t, rec).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("invalid preferences = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+	prefs := UserPreferences{
+		Language:      "en",
+		Style:         &engine.AnswerStyle{Audience: "lawyer", Length: "concise"},
+		Collection:    "labor",
+		Notifications: NotificationPreferences{Channels: []string{ChannelInApp, ChannelEmail}, Muted: []NotificationKind{NotificationMention}},
+	}
+	if rec = doAs(t, h, "lan", http.MethodPut, "/api/me/preferences", prefs); rec.Code != http.StatusOK {
+		t.Fatalf("put status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got UserPreferences
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/me/preferences", nil).Body.Bytes(), &got)
+	if got.Language != "en" || got.Collection != "labor" || got.UpdatedAt.IsZero() {
+		t.Errorf("preferences = %+v, want them stored", got)
+	}
+
+	// Preferences fill what the query leaves unset, the query wins otherwise
+	question := "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question, Style: &engine.AnswerStyle{Length: "detailed"}})
+	req := stub.requests[len(stub.requests)-1]
+	if req.Language != "en" || req.Collection != "labor" || !strings.Contains(req.StyleInstructions, "tiếng Anh") {
+		t.Errorf("engine request = %+v, want the preferred language and collection", req)
+	}
+	if want := (engine.AnswerStyle{Length: "detailed", Tone: "formal", Audience: "lawyer"}); req.Style != want {
+		t.Errorf("style = %+v, want %+v", req.Style, want)
+	}
+
+	// Other users keep the defaults
+	doAs(t, h, "minh", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+	if req = stub.requests[len(stub.requests)-1]; req.Language != "" || req.Style.Audience != "layperson" {
+		t.Errorf("engine request for another user = %+v, want the defaults", req)
+	}
+
+	// Muted kinds are not delivered in the app
+	var answer engine.LegalQueryResponse
+	json.Unmarshal(doAs(t, h, "minh", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question}).Body.Bytes(), &answer)
+	doAs(t, h, "minh", http.MethodPost, "/api/history/"+answer.HistoryID+"/comments", CommentRequest{Body: "@lan xem giúp"})
+	var listed struct {
+		Notifications []Notification `json:"notifications"`
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/notifications", nil).Body.Bytes(), &listed)
+	if len(listed.Notifications) != 0 {
+		t.Errorf("notifications = %+v, want the muted mention left out", listed.Notifications)
+	}
+
+	if rec = doAs(t, h, "lan", http.MethodDelete, "/api/me/preferences", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d", rec.Code)
+	}
+	got = UserPreferences{}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/me/preferences", nil).Body.Bytes(), &got)
+	if got.Language != "" {
+		t.Errorf("preferences after reset = %+v, want none", got)
+	}
+}
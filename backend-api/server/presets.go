@@ -0,0 +1,251 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// QueryPreset is a named set of query parameters selected with the preset
+// field of a query. Parameters the query sets itself take precedence.
+type QueryPreset struct {
+	Name            string              `json:"name"`
+	Description     string              `json:"description,omitempty"`
+	MaxIterations   *int                `json:"max_iterations,omitempty"`
+	TopK            *int                `json:"top_k,omitempty"`
+	EnableWebSearch *bool               `json:"enable_web_search,omitempty"`
+	Model           string              `json:"model,omitempty"`
+	Style           *engine.AnswerStyle `json:"style,omitempty"`
+
+	// BuiltIn marks the presets every tenant has unless it defines one of
+	// the same name
+	BuiltIn bool `json:"built_in,omitempty"`
+}
+
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+var errPresetNotFound = errors.New("preset not found")
+
+// builtinPresets are available to every caller
+var builtinPresets = []QueryPreset{
+	{
+		Name:            "quick_lookup",
+		Description:     "One retrieval round over a few sources for a short, plain answer",
+		MaxIterations:   intPtr(1),
+		TopK:            intPtr(3),
+		EnableWebSearch: boolPtr(false),
+		Style:           &engine.AnswerStyle{Length: "concise", Tone: "plain"},
+		BuiltIn:         true,
+	},
+	{
+		Name:            "deep_research",
+		Description:     "Several retrieval rounds over many sources and the web for a detailed answer",
+		MaxIterations:   intPtr(6),
+		TopK:            intPtr(12),
+		EnableWebSearch: boolPtr(true),
+		Style:           &engine.AnswerStyle{Length: "detailed"},
+		BuiltIn:         true,
+	},
+	{
+		Name:            "litigation_prep",
+		Description:     "The most thorough retrieval for a formal answer citing exact provisions, for lawyers",
+		MaxIterations:   intPtr(8),
+		TopK:            intPtr(15),
+		EnableWebSearch: boolPtr(true),
+		Style:           &engine.AnswerStyle{Length: "detailed", Tone: "formal", Audience: "lawyer"},
+		BuiltIn:         true,
+	},
+}
+
+func intPtr(v int) *int    { return &v }
+func boolPtr(v bool) *bool { return &v }
+
+// tenantPresets returns the presets of a tenant: its own, then the
+// built-in ones it does not redefine, ordered by name
+func tenantPresets(tenant Tenant) []QueryPreset {
+	presets := slices.Clone(tenant.Settings.Presets)
+	for _, p := range builtinPresets {
+		if !slices.ContainsFunc(presets, func(own QueryPreset) bool { return own.Name == p.Name }) {
+			presets = append(presets, p)
+		}
+	}
+	slices.SortFunc(presets, func(a, b QueryPreset) int { return strings.Compare(a.Name, b.Name) })
+	return presets
+}
+
+func lookupPreset(tenant Tenant, name string) (QueryPreset, bool) {
+	for _, p := range tenantPresets(tenant) {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return QueryPreset{}, false
+}
+
+// apply fills the parameters the query leaves unset. Web search is left
+// out when the plan does not include it, with a warning; the iterations and
+// top_k are clamped to the plan later, like the query's own.
+func (p QueryPreset) apply(req *LegalQueryRequest, plan Plan) []engine.Warning {
+	var warnings []engine.Warning
+	if req.MaxIterations == nil && p.MaxIterations != nil {
+		req.MaxIterations = intPtr(*p.MaxIterations)
+	}
+	if req.TopK == nil && p.TopK != nil {
+		req.TopK = intPtr(*p.TopK)
+	}
+	if req.EnableWebSearch == nil && p.EnableWebSearch != nil {
+		if *p.EnableWebSearch && !plan.WebSearch {
+			warnings = append(warnings, engine.Warning{
+				Field:   "enable_web_search",
+				Code:    WarningPresetAdjusted,
+				Message: fmt.Sprintf("preset %q enables web search, which is not available on plan %q; it was left out", p.Name, plan.Name),
+			})
+		} else {
+			req.EnableWebSearch = boolPtr(*p.EnableWebSearch)
+		}
+	}
+	if req.Model == "" {
+		req.Model = p.Model
+	}
+	if p.Style != nil {
+		style := *p.Style
+		if req.Style != nil {
+			if req.Style.Length != "" {
+				style.Length = req.Style.Length
+			}
+			if req.Style.Tone != "" {
+				style.Tone = req.Style.Tone
+			}
+			if req.Style.Audience != "" {
+				style.Audience = req.Style.Audience
+			}
+		}
+		req.Style = &style
+	}
+	return warnings
+}
+
+// validatePresets checks a tenant's presets against its plan with the rules
+// of tenant defaults: out-of-range values are rejected rather than clamped
+func validatePresets(presets []QueryPreset, plan Plan) []Violation {
+	var violations []Violation
+	seen := make(map[string]bool)
+	for _, p := range presets {
+		field := "presets." + p.Name
+		switch {
+		case !presetNamePattern.MatchString(p.Name):
+			violations = append(violations, Violation{
+				Field:   "presets",
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("preset name %q must be 1-63 lowercase letters, digits, '-' or '_'", p.Name),
+			})
+			continue
+		case seen[p.Name]:
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("preset %q is defined twice", p.Name),
+			})
+			continue
+		}
+		seen[p.Name] = true
+
+		req := LegalQueryRequest{
+			Question:        "preset",
+			MaxIterations:   p.MaxIterations,
+			TopK:            p.TopK,
+			EnableWebSearch: p.EnableWebSearch,
+			Model:           p.Model,
+			Style:           p.Style,
+		}
+		found := validateQueryRequest(&req, plan)
+		for _, w := range clampQueryRequest(&req, plan) {
+			found = append(found, Violation{Field: w.Field, Code: ViolationOutOfRange, Message: w.Message})
+		}
+		for _, v := range found {
+			v.Field = field + "." + v.Field
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// Handlers
+
+func listPresetsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{"presets": tenantPresets(tenant)})
+	}
+}
+
+func putTenantPresetHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var preset QueryPreset
+		if err := c.ShouldBindJSON(&preset); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		preset.Name = c.Param("name")
+		preset.BuiltIn = false
+
+		var violations []Violation
+		_, err := store.Update(c.Param("id"), func(t *Tenant) error {
+			plan, _ := lookupPlan(t.Plan)
+			if violations = validatePresets([]QueryPreset{preset}, plan); len(violations) > 0 {
+				return errors.New("invalid preset")
+			}
+			presets := slices.DeleteFunc(slices.Clone(t.Settings.Presets), func(p QueryPreset) bool { return p.Name == preset.Name })
+			t.Settings.Presets = append(presets, preset)
+			return nil
+		})
+		switch {
+		case errors.Is(err, errTenantNotFound):
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", c.Param("id")))
+			return
+		case len(violations) > 0:
+			abortWithViolations(c, violations)
+			return
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "Failed to save tenant", "tenant", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save tenant")
+			return
+		}
+
+		c.JSON(http.StatusOK, preset)
+	}
+}
+
+func deleteTenantPresetHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		_, err := store.Update(c.Param("id"), func(t *Tenant) error {
+			if !slices.ContainsFunc(t.Settings.Presets, func(p QueryPreset) bool { return p.Name == name }) {
+				return errPresetNotFound
+			}
+			t.Settings.Presets = slices.DeleteFunc(slices.Clone(t.Settings.Presets), func(p QueryPreset) bool { return p.Name == name })
+			return nil
+		})
+		switch {
+		case errors.Is(err, errTenantNotFound):
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", c.Param("id")))
+			return
+		case errors.Is(err, errPresetNotFound):
+			abortWithError(c, ErrCodePresetNotFound, fmt.Sprintf("Tenant %q has no preset %q", c.Param("id"), name))
+			return
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "Failed to save tenant", "tenant", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save tenant")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestQueryPresets(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019 ...", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	do := func(tenant, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "secret")
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	ask := func(tenant string, req LegalQueryRequest) (engine.PythonQueryRequest, engine.LegalQueryResponse) {
+		t.Helper()
+		rec := do(tenant, http.MethodPost, "/api/legal-query", req)
+		var resp engine.LegalQueryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("query with preset %q = %d %s", req.Preset, rec.Code, rec.Body.String())
+		}
+		return stub.requests[len(stub.requests)-1], resp
+	}
+
+	var listed struct {
+		Presets []QueryPreset `json:"presets"`
+	}
+	json.Unmarshal(do("", http.MethodGet, "/api/presets", nil).Body.Bytes(), &listed)
+	if len(listed.Presets) != 3 || listed.Presets[0].Name != "deep_research" || !listed.Presets[0].BuiltIn {
+		t.Errorf("presets = %+v, want the built-in ones", listed.Presets)
+	}
+
+	sent, _ := ask("", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?", Preset: "quick_lookup"})
+	if sent.MaxIterations != 1 || sent.TopK != 3 || sent.EnableWebSearch || sent.Style.Length != "concise" || sent.Style.Tone != "plain" {
+		t.Errorf("quick lookup sent %+v", sent)
+	}
+	topK := 8
+	sent, _ = ask("", LegalQueryRequest{Question: "Mức lương tối thiểu vùng là bao nhiêu?", Preset: "litigation_prep", TopK: &topK, Style: &engine.AnswerStyle{Tone: "plain"}})
+	if sent.MaxIterations != 8 || sent.TopK != 8 || !sent.EnableWebSearch || sent.Style.Tone != "plain" || sent.Style.Audience != "lawyer" {
+		t.Errorf("litigation prep with its own top_k and tone sent %+v", sent)
+	}
+	if code := decodeError(t, do("", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc?", Preset: "nope"})).Code; code != ErrCodePresetNotFound {
+		t.Errorf("unknown preset = %s, want %s", code, ErrCodePresetNotFound)
+	}
+
+	if rec := do("", http.MethodPost, "/admin/tenants", CreateTenantRequest{ID: "acme", Name: "ACME", Plan: "free"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	// The free plan has no web search and at most 3 iterations
+	sent, resp := ask("acme", LegalQueryRequest{Question: "Thủ tục đăng ký kết hôn gồm những gì?", Preset: "deep_research"})
+	if sent.EnableWebSearch || sent.MaxIterations != 3 || len(resp.Warnings) != 3 || resp.Warnings[0].Code != WarningPresetAdjusted {
+		t.Errorf("deep research on the free plan sent %+v, warnings %+v", sent, resp.Warnings)
+	}
+	web := true
+	if code := decodeError(t, do("", http.MethodPut, "/admin/tenants/acme/presets/web", QueryPreset{EnableWebSearch: &web})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("preset beyond the plan = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+	iterations := 2
+	if rec := do("", http.MethodPut, "/admin/tenants/acme/presets/quick_lookup", QueryPreset{MaxIterations: &iterations, Model: "gpt-4o-mini", BuiltIn: true}); rec.Code != http.StatusOK {
+		t.Fatalf("put preset = %d %s", rec.Code, rec.Body.String())
+	}
+	listed.Presets = nil
+	json.Unmarshal(do("acme", http.MethodGet, "/api/presets", nil).Body.Bytes(), &listed)
+	if len(listed.Presets) != 3 || listed.Presets[2].Name != "quick_lookup" || listed.Presets[2].BuiltIn || listed.Presets[2].Model != "gpt-4o-mini" {
+		t.Errorf("presets of acme = %+v, want its own quick_lookup", listed.Presets)
+	}
+	sent, _ = ask("acme", LegalQueryRequest{Question: "Người lao động được nghỉ phép năm bao nhiêu ngày?", Preset: "quick_lookup"})
+	if sent.MaxIterations != 2 || sent.Model != "gpt-4o-mini" || sent.Style.Length == "concise" {
+		t.Errorf("tenant quick lookup sent %+v", sent)
+	}
+
+	if rec := do("", http.MethodDelete, "/admin/tenants/acme/presets/quick_lookup", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("delete preset = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do("", http.MethodDelete, "/admin/tenants/acme/presets/quick_lookup", nil)).Code; code != ErrCodePresetNotFound {
+		t.Errorf("delete twice = %s, want %s", code, ErrCodePresetNotFound)
+	}
+}
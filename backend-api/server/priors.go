@@ -0,0 +1,172 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSourcePriors bounds the priors sent with every engine query
+	maxSourcePriors = 200
+
+	// Weights stay within these bounds, so that engagement reorders close
+	// results without burying relevant ones
+	minSourcePrior = 0.5
+	maxSourcePrior = 2.0
+)
+
+// SourcePriorConfig controls the usefulness priors of sources, learnt from
+// engagement events
+type SourcePriorConfig struct {
+	// Strength of zero disables the priors; one applies the engagement
+	// ratio in full
+	Strength float64
+
+	// MinShown is the number of answers that must have shown a source
+	// before it gets a prior; it also smooths the rates of rarely shown
+	// sources towards the average
+	MinShown int
+
+	Window   time.Duration
+	Interval time.Duration
+}
+
+// SourcePrior is the usefulness weight of one source
+type SourcePrior struct {
+	Source  string  `json:"source"`
+	Weight  float64 `json:"weight"`
+	Shown   int     `json:"shown"`
+	Answers int     `json:"answers"`
+}
+
+// SourcePriors turns engagement events into weights the engine applies to
+// the scores of search results, so that sources users rely on rank higher.
+// Weights are recomputed every interval rather than for every query.
+type SourcePriors struct {
+	config  SourcePriorConfig
+	events  *EventLog
+	history *HistoryStore
+
+	mu         sync.RWMutex
+	weights    map[string]float64
+	priors     []SourcePrior
+	baseRate   float64
+	computedAt time.Time
+}
+
+func NewSourcePriors(config SourcePriorConfig, events *EventLog, history *HistoryStore) *SourcePriors {
+	return &SourcePriors{config: config, events: events, history: history}
+}
+
+// Refresh recomputes the weights from the events of the window ending now
+func (p *SourcePriors) Refresh(now time.Time) {
+	from := now.Add(-p.config.Window)
+	report := aggregateEngagement(p.events.Since(from), p.history.Since(from), from, now)
+	priors, baseRate := computeSourcePriors(report.Sources, p.config)
+
+	weights := make(map[string]float64, len(priors))
+	for _, prior := range priors {
+		weights[prior.Source] = prior.Weight
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.weights = weights
+	p.priors = priors
+	p.baseRate = baseRate
+	p.computedAt = now
+}
+
+// Run refreshes the weights every interval until stop is closed
+func (p *SourcePriors) Run(stop <-chan struct{}) {
+	p.Refresh(time.Now().UTC())
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.Refresh(now.UTC())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Weights returns the weights to send with an engine query, or nil when
+// there are none. The map is shared and must not be modified.
+func (p *SourcePriors) Weights() map[string]float64 {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.weights) == 0 {
+		return nil
+	}
+	return p.weights
+}
+
+// computeSourcePriors weighs each source shown often enough by its smoothed
+// engagement rate relative to the average rate of all sources. Sources
+// whose weight barely differs from one are left out, as are the least
+// shown beyond maxSourcePriors.
+func computeSourcePriors(sources []SourceEngagement, config SourcePriorConfig) ([]SourcePrior, float64) {
+	priors := []SourcePrior{}
+	shown, answers := 0, 0
+	for _, s := range sources {
+		shown += s.Shown
+		answers += min(s.Answers, s.Shown)
+	}
+	if shown == 0 || answers == 0 || config.Strength <= 0 {
+		return priors, 0
+	}
+	baseRate := float64(answers) / float64(shown)
+
+	smoothing := float64(config.MinShown)
+	for _, s := range sources {
+		if s.Shown < config.MinShown {
+			continue
+		}
+		rate := (float64(min(s.Answers, s.Shown)) + smoothing*baseRate) / (float64(s.Shown) + smoothing)
+		weight := 1 + config.Strength*(rate/baseRate-1)
+		weight = math.Round(max(minSourcePrior, min(maxSourcePrior, weight))*1000) / 1000
+		if math.Abs(weight-1) < 0.01 {
+			continue
+		}
+		priors = append(priors, SourcePrior{Source: s.Source, Weight: weight, Shown: s.Shown, Answers: s.Answers})
+	}
+	sort.SliceStable(priors, func(i, j int) bool {
+		if priors[i].Shown != priors[j].Shown {
+			return priors[i].Shown > priors[j].Shown
+		}
+		return priors[i].Source < priors[j].Source
+	})
+	if len(priors) > maxSourcePriors {
+		priors = priors[:maxSourcePriors]
+	}
+	return priors, baseRate
+}
+
+// Handlers
+
+func sourcePriorsHandler(priors *SourcePriors) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		priors.mu.RLock()
+		defer priors.mu.RUnlock()
+		list := priors.priors
+		if list == nil {
+			list = []SourcePrior{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"strength":    priors.config.Strength,
+			"window":      priors.config.Window.String(),
+			"computed_at": priors.computedAt,
+			"base_rate":   math.Round(priors.baseRate*1000) / 1000,
+			"priors":      list,
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestSourcePriors(t *testing.T) {
+	history, err := NewHistoryStore("", 100)
+	if err != nil {
+		t.Fatalf("NewHistoryStore: %v", err)
+	}
+	events, err := NewEventLog("", 100)
+	if err != nil {
+		t.Fatalf("NewEventLog: %v", err)
+	}
+	results := []engine.DocumentHit{
+		{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"},
+		{Excerpt: "Điều 24. Thỏa thuận thử việc", Title: "Thỏa thuận thử việc"},
+		{Excerpt: "Điều 26. Tiền lương thử việc", Title: "Tiền lương thử việc"},
+	}
+	// Users click the first source in three of four answers, never the
+	// second, and the third is shown too rarely to be weighed
+	index := 0
+	for i := range 4 {
+		shown := results[:2]
+		if i == 0 {
+			shown = results
+		}
+		entry, err := history.Add(HistoryEntry{Question: "Thời gian thử việc?", Response: engine.LegalQueryResponse{SearchResults: shown}})
+		if err != nil {
+			t.Fatalf("history.Add: %v", err)
+		}
+		if i < 3 {
+			events.Add([]EngagementEvent{{Kind: EventCitationClicked, HistoryID: entry.ID, SourceIndex: &index, Source: "Thời gian thử việc"}})
+		}
+	}
+
+	config := SourcePriorConfig{Strength: 0.5, MinShown: 2, Window: time.Hour, Interval: time.Hour}
+	priors := NewSourcePriors(config, events, history)
+	if priors.Weights() != nil {
+		t.Error("weights before the first refresh, want none")
+	}
+	priors.Refresh(time.Now().UTC().Add(time.Second))
+	want := map[string]float64{"Thời gian thử việc": 1.417, "Thỏa thuận thử việc": 0.667}
+	weights := priors.Weights()
+	if len(weights) != len(want) {
+		t.Fatalf("weights = %v, want %v", weights, want)
+	}
+	for source, weight := range want {
+		if weights[source] != weight {
+			t.Errorf("weight of %q = %g, want %g", source, weights[source], weight)
+		}
+	}
+
+	// Queries of the shared corpus carry the weights, which leave the cache
+	// key unchanged
+	deps := queryDeps{priors: priors}
+	req := deps.engineRequest(&LegalQueryRequest{Question: "Thời gian thử việc tối đa?"}, QueryDefaults{}, plans[defaultPlanName])
+	if len(req.SourcePriors) != 2 {
+		t.Errorf("source priors = %v, want the 2 weights", req.SourcePriors)
+	}
+	withoutPriors := *req
+	withoutPriors.SourcePriors = nil
+	if cacheKey(req) != cacheKey(&withoutPriors) {
+		t.Error("source priors changed the cache key")
+	}
+	private := &LegalQueryRequest{Question: "Thời gian thử việc tối đa?", namespace: privateNamespace("acme")}
+	if req := deps.engineRequest(private, QueryDefaults{}, plans[defaultPlanName]); req.SourcePriors != nil {
+		t.Errorf("private query source priors = %v, want none", req.SourcePriors)
+	}
+
+	config.Strength = 0
+	disabled := NewSourcePriors(config, events, history)
+	disabled.Refresh(time.Now().UTC().Add(time.Second))
+	if disabled.Weights() != nil {
+		t.Errorf("weights with no strength = %v, want none", disabled.Weights())
+	}
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const maxPrivateCollectionTitleLen = 200
+
+// PrivateDocConfig limits the documents tenants upload into their private
+// collections
+type PrivateDocConfig struct {
+	MaxBytes     int
+	MaxDocuments int
+}
+
+// PrivateCollection is a document collection of a tenant, such as its own
+// contracts or internal memos. Its documents are indexed by the engine in
+// the tenant's namespace, apart from the shared corpus: only the tenant's
+// queries naming the collection search them, and they search nothing else.
+type PrivateCollection struct {
+	TenantID  string            `json:"tenant_id"`
+	Name      string            `json:"name"`
+	Title     string            `json:"title,omitempty"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Documents []PrivateDocument `json:"documents"`
+}
+
+// PrivateDocument is a document indexed in a private collection. Its text
+// is kept by the engine only.
+type PrivateDocument struct {
+	ID         string    `json:"id"`
+	Filename   string    `json:"filename"`
+	Title      string    `json:"title"`
+	Bytes      int       `json:"bytes"`
+	Chunks     int       `json:"chunks"`
+	UploadedBy string    `json:"uploaded_by,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+var (
+	errPrivateCollectionNotFound = errors.New("private collection not found")
+	errPrivateCollectionExists   = errors.New("collection already exists")
+	errPrivateDocumentNotFound   = errors.New("document not found")
+)
+
+// PrivateCollectionStore keeps the private collections of every tenant and
+// the list of their documents, persisted to a JSON file when a path is
+// configured
+type PrivateCollectionStore struct {
+	mu          sync.RWMutex
+	path        string
+	collections map[string]*PrivateCollection
+}
+
+func NewPrivateCollectionStore(path string) (*PrivateCollectionStore, error) {
+	store := &PrivateCollectionStore{
+		path:        path,
+		collections: make(map[string]*PrivateCollection),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var collections []*PrivateCollection
+	if _, err := readJSONFile(path, &collections); err != nil {
+		return nil, fmt.Errorf("failed to load private collections: %w", err)
+	}
+	for _, pc := range collections {
+		store.collections[privateCollectionKey(pc.TenantID, pc.Name)] = pc
+	}
+	return store, nil
+}
+
+func privateCollectionKey(tenantID, name string) string {
+	return tenantID + "/" + name
+}
+
+// List returns the private collections of a tenant by name
+func (s *PrivateCollectionStore) List(tenantID string) []PrivateCollection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	collections := []PrivateCollection{}
+	for _, pc := range s.collections {
+		if pc.TenantID == tenantID {
+			collections = append(collections, pc.clone())
+		}
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+	return collections
+}
+
+// Get returns a private collection of a tenant. Callers without a tenant
+// have none.
+func (s *PrivateCollectionStore) Get(tenantID, name string) (PrivateCollection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pc, ok := s.collections[privateCollectionKey(tenantID, name)]
+	if !ok || tenantID == "" {
+		return PrivateCollection{}, errPrivateCollectionNotFound
+	}
+	return pc.clone(), nil
+}
+
+// Has reports whether the tenant has a private collection of that name
+func (s *PrivateCollectionStore) Has(tenantID, name string) bool {
+	_, err := s.Get(tenantID, name)
+	return err == nil
+}
+
+func (s *PrivateCollectionStore) Create(pc PrivateCollection) (PrivateCollection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := privateCollectionKey(pc.TenantID, pc.Name)
+	if _, ok := s.collections[key]; ok {
+		return PrivateCollection{}, errPrivateCollectionExists
+	}
+	pc.CreatedAt = time.Now().UTC()
+	pc.Documents = []PrivateDocument{}
+	s.collections[key] = &pc
+	if err := s.saveLocked(); err != nil {
+		delete(s.collections, key)
+		return PrivateCollection{}, err
+	}
+	return pc.clone(), nil
+}
+
+func (s *PrivateCollectionStore) Delete(tenantID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := privateCollectionKey(tenantID, name)
+	pc, ok := s.collections[key]
+	if !ok {
+		return errPrivateCollectionNotFound
+	}
+	delete(s.collections, key)
+	if err := s.saveLocked(); err != nil {
+		s.collections[key] = pc
+		return err
+	}
+	return nil
+}
+
+// AddDocument records a document indexed in a private collection
+func (s *PrivateCollectionStore) AddDocument(tenantID, name string, doc PrivateDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pc, ok := s.collections[privateCollectionKey(tenantID, name)]
+	if !ok {
+		return errPrivateCollectionNotFound
+	}
+	previous := pc.Documents
+	pc.Documents = append(pc.clone().Documents, doc)
+	if err := s.saveLocked(); err != nil {
+		pc.Documents = previous
+		return err
+	}
+	return nil
+}
+
+func (s *PrivateCollectionStore) RemoveDocument(tenantID, name, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pc, ok := s.collections[privateCollectionKey(tenantID, name)]
+	if !ok {
+		return errPrivateCollectionNotFound
+	}
+	i := pc.documentIndex(id)
+	if i < 0 {
+		return errPrivateDocumentNotFound
+	}
+	previous := pc.Documents
+	documents := pc.clone().Documents
+	pc.Documents = append(documents[:i], documents[i+1:]...)
+	if err := s.saveLocked(); err != nil {
+		pc.Documents = previous
+		return err
+	}
+	return nil
+}
+
+func (pc *PrivateCollection) documentIndex(id string) int {
+	for i, doc := range pc.Documents {
+		if doc.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (pc *PrivateCollection) clone() PrivateCollection {
+	c := *pc
+	c.Documents = append([]PrivateDocument{}, pc.Documents...)
+	return c
+}
+
+func (s *PrivateCollectionStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	collections := make([]*PrivateCollection, 0, len(s.collections))
+	for _, pc := range s.collections {
+		collections = append(collections, pc)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return privateCollectionKey(collections[i].TenantID, collections[i].Name) < privateCollectionKey(collections[j].TenantID, collections[j].Name)
+	})
+	return writeJSONFile(s.path, collections)
+}
+
+// privateNamespace is the engine namespace of a tenant's private documents
+func privateNamespace(tenantID string) string {
+	return "tenant-" + tenantID
+}
+
+// Handlers
+
+// CreatePrivateCollectionRequest is the body of POST /api/private-collections
+type CreatePrivateCollectionRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Title string `json:"title"`
+}
+
+// privateCollectionsTenant returns the tenant of the caller's API key,
+// writing the error response for callers without a key bound to a tenant
+// or when the engine cannot index documents. X-Tenant-ID alone never
+// grants access, since any caller can set it.
+func privateCollectionsTenant(c *gin.Context, index engine.DocumentIndex) (Tenant, bool) {
+	tenant, ok := authenticatedTenant(c)
+	switch {
+	case !ok:
+		abortWithError(c, ErrCodeForbidden, "Private collections belong to a tenant; they need an API key bound to the tenant")
+	case index == nil:
+		abortWithError(c, ErrCodeForbidden, "Private collections need an engine that indexes documents")
+		ok = false
+	}
+	return tenant, ok
+}
+
+// privateCollectionError writes the error response of a store error
+func privateCollectionError(c *gin.Context, err error, name string) {
+	switch {
+	case errors.Is(err, errPrivateCollectionNotFound):
+		abortWithError(c, ErrCodeCollectionNotFound, fmt.Sprintf("Collection %q not found", name))
+	case errors.Is(err, errPrivateDocumentNotFound):
+		abortWithError(c, ErrCodeDocumentNotFound, fmt.Sprintf("Document %q not found in collection %q", c.Param("id"), name))
+	case errors.Is(err, errPrivateCollectionExists):
+		abortWithError(c, ErrCodeCollectionExists, fmt.Sprintf("Collection %q already exists", name))
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to save private collections", "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to save private collections")
+	}
+}
+
+func listPrivateCollectionsHandler(store *PrivateCollectionStore, index engine.DocumentIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"collections": store.List(tenant.ID)})
+	}
+}
+
+func getPrivateCollectionHandler(store *PrivateCollectionStore, index engine.DocumentIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		pc, err := store.Get(tenant.ID, c.Param("name"))
+		if err != nil {
+			privateCollectionError(c, err, c.Param("name"))
+			return
+		}
+		c.JSON(http.StatusOK, pc)
+	}
+}
+
+func createPrivateCollectionHandler(store *PrivateCollectionStore, catalog *CollectionCatalog, index engine.DocumentIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		var req CreatePrivateCollectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		req.Title = strings.TrimSpace(req.Title)
+		switch {
+		case !collectionNamePattern.MatchString(req.Name):
+			abortWithError(c, ErrCodeInvalidRequest, "name must be 1-64 letters, digits, '-' or '_'")
+			return
+		case utf8.RuneCountInString(req.Title) > maxPrivateCollectionTitleLen:
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("title must be at most %d characters", maxPrivateCollectionTitleLen))
+			return
+		}
+		// A private collection would hide the shared one of the same name
+		if catalog.Has(req.Name) {
+			privateCollectionError(c, errPrivateCollectionExists, req.Name)
+			return
+		}
+
+		pc, err := store.Create(PrivateCollection{
+			TenantID:  tenant.ID,
+			Name:      req.Name,
+			Title:     req.Title,
+			CreatedBy: callerUser(c),
+		})
+		if err != nil {
+			privateCollectionError(c, err, req.Name)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Created private collection", "collection", pc.Name, "tenant", pc.TenantID)
+		c.JSON(http.StatusCreated, pc)
+	}
+}
+
+// deletePrivateCollectionHandler removes the documents of a collection from
+// the engine, then the collection. When the engine fails, the documents
+// already removed are dropped from the collection and the others kept, so
+// that the deletion can be retried.
+func deletePrivateCollectionHandler(store *PrivateCollectionStore, index engine.DocumentIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		name := c.Param("name")
+		pc, err := store.Get(tenant.ID, name)
+		if err != nil {
+			privateCollectionError(c, err, name)
+			return
+		}
+		for _, doc := range pc.Documents {
+			if err := index.DeleteDocument(c.Request.Context(), privateNamespace(tenant.ID), doc.ID); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to delete document of private collection", "document", doc.ID, "collection", name, "error", err)
+				abortWithEngineError(c, err, fmt.Sprintf("Failed to delete document %q from the engine: %v", doc.ID, err))
+				return
+			}
+			if err := store.RemoveDocument(tenant.ID, name, doc.ID); err != nil {
+				privateCollectionError(c, err, name)
+				return
+			}
+		}
+		if err := store.Delete(tenant.ID, name); err != nil {
+			privateCollectionError(c, err, name)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Deleted private collection", "collection", name, "tenant", tenant.ID)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// uploadPrivateDocumentHandler extracts the text of an uploaded file and
+// has the engine index it in the tenant's namespace
+func uploadPrivateDocumentHandler(store *PrivateCollectionStore, index engine.DocumentIndex, limits PrivateDocConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		name := c.Param("name")
+		pc, err := store.Get(tenant.ID, name)
+		if err != nil {
+			privateCollectionError(c, err, name)
+			return
+		}
+		if len(pc.Documents) >= limits.MaxDocuments {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Collections hold at most %d documents", limits.MaxDocuments))
+			return
+		}
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Missing file: %v", err))
+			return
+		}
+		if file.Size > int64(limits.MaxBytes)*4 {
+			// Binary formats are larger than their text; the extracted text
+			// is checked against the real limit below
+			abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("File exceeds %d bytes", limits.MaxBytes*4))
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read file: %v", err))
+			return
+		}
+		text, err := extractText(file.Filename, file.Header.Get("Content-Type"), data)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if len(text) > limits.MaxBytes {
+			abortWithError(c, ErrCodePayloadTooLarge, fmt.Sprintf("Document text exceeds %d bytes", limits.MaxBytes))
+			return
+		}
+		if strings.TrimSpace(text) == "" {
+			abortWithError(c, ErrCodeInvalidRequest, "Document contains no text")
+			return
+		}
+		title := strings.TrimSpace(c.PostForm("title"))
+		if title == "" {
+			title = file.Filename
+		}
+
+		doc := PrivateDocument{
+			ID:         randomHex(12),
+			Filename:   file.Filename,
+			Title:      title,
+			Bytes:      len(text),
+			UploadedBy: callerUser(c),
+			UploadedAt: time.Now().UTC(),
+		}
+		namespace := privateNamespace(tenant.ID)
+		result, err := index.IndexDocument(c.Request.Context(), namespace, &engine.IndexedDocument{
+			ID:         doc.ID,
+			Collection: name,
+			Title:      title,
+			Text:       text,
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to index document in private collection", "collection", name, "error", err)
+			abortWithEngineError(c, err, fmt.Sprintf("Failed to index document: %v", err))
+			return
+		}
+		doc.Chunks = result.Chunks
+		if err := store.AddDocument(tenant.ID, name, doc); err != nil {
+			// The collection was deleted meanwhile, or could not be saved
+			if err := index.DeleteDocument(c.Request.Context(), namespace, doc.ID); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to delete orphaned document", "document", doc.ID, "error", err)
+			}
+			privateCollectionError(c, err, name)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Indexed document in private collection", "document", doc.ID, "chunks", doc.Chunks, "collection", name, "tenant", tenant.ID)
+		c.JSON(http.StatusCreated, doc)
+	}
+}
+
+func deletePrivateDocumentHandler(store *PrivateCollectionStore, index engine.DocumentIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := privateCollectionsTenant(c, index)
+		if !ok {
+			return
+		}
+		name, id := c.Param("name"), c.Param("id")
+		pc, err := store.Get(tenant.ID, name)
+		if err != nil {
+			privateCollectionError(c, err, name)
+			return
+		}
+		if pc.documentIndex(id) < 0 {
+			privateCollectionError(c, errPrivateDocumentNotFound, name)
+			return
+		}
+		if err := index.DeleteDocument(c.Request.Context(), privateNamespace(tenant.ID), id); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete document of private collection", "document", id, "collection", name, "error", err)
+			abortWithEngineError(c, err, fmt.Sprintf("Failed to delete document: %v", err))
+			return
+		}
+		if err := store.RemoveDocument(tenant.ID, name, id); err != nil {
+			privateCollectionError(c, err, name)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// namespacedEngine is a fake engine keeping private documents per
+// namespace; queries of a namespace return only its documents
+type namespacedEngine struct {
+	mu        sync.Mutex
+	documents map[string]map[string]engine.IndexedDocument
+	queries   []engine.PythonQueryRequest
+}
+
+func (e *namespacedEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r.URL.Path == "/api/query" {
+		var req engine.PythonQueryRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		e.queries = append(e.queries, req)
+		resp := engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019 ...", Iterations: 1, SearchResults: []engine.DocumentHit{}}
+		for id, doc := range e.documents[req.Namespace] {
+			if req.Collection == "" || doc.Collection == req.Collection {
+				resp.SearchResults = append(resp.SearchResults, engine.DocumentHit{Excerpt: doc.Text, DocumentID: id})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var namespace, id string
+	if _, rest, ok := strings.Cut(r.URL.Path, "/api/namespaces/"); ok {
+		namespace, id, _ = strings.Cut(rest, "/documents/")
+	}
+	switch {
+	case id == "":
+		http.NotFound(w, r)
+	case r.Method == http.MethodPut:
+		var doc engine.IndexedDocument
+		json.NewDecoder(r.Body).Decode(&doc)
+		if e.documents[namespace] == nil {
+			e.documents[namespace] = make(map[string]engine.IndexedDocument)
+		}
+		e.documents[namespace][id] = doc
+		json.NewEncoder(w).Encode(engine.IndexResult{DocumentID: id, Chunks: 1})
+	case r.Method == http.MethodDelete:
+		delete(e.documents[namespace], id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestPrivateCollections(t *testing.T) {
+	fake := &namespacedEngine{documents: make(map[string]map[string]engine.IndexedDocument)}
+	pythonEngine := httptest.NewServer(fake)
+	defer pythonEngine.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	t.Setenv("ENGINE_RETRY_ATTEMPTS", "1")
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	h := newTestServer(t, Options{}).Handler()
+	keys := make(map[string]string)
+	for _, id := range []string{"acme", "globex", "initech"} {
+		keys[id] = tenantKey(t, h, id)
+	}
+
+	// do calls as the tenant's API key; claimed names a tenant in
+	// X-Tenant-ID without one
+	do := func(tenant, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		if claimed, ok := strings.CutPrefix(tenant, "claimed:"); ok {
+			req.Header.Set("X-Tenant-ID", claimed)
+		} else if tenant != "" {
+			req.Header.Set("X-API-Key", keys[tenant])
+		}
+		req.Header.Set("X-User-ID", "lan")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(tenant, collection, text string) PrivateDocument {
+		t.Helper()
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		part, _ := form.CreateFormFile("file", "hop-dong.txt")
+		part.Write([]byte(text))
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/private-collections/"+collection+"/documents", &buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("X-API-Key", keys[tenant])
+		req.Header.Set("X-User-ID", "lan")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var doc PrivateDocument
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || rec.Code != http.StatusCreated {
+			t.Fatalf("upload to %s/%s = %d %s", tenant, collection, rec.Code, rec.Body.String())
+		}
+		return doc
+	}
+	ask := func(tenant, collection string) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(tenant, http.MethodPost, "/api/legal-query", LegalQueryRequest{
+			Question:   "Hợp đồng của công ty quy định thời gian thử việc bao lâu?",
+			Collection: collection,
+		})
+	}
+
+	if code := decodeError(t, do("", http.MethodPost, "/api/private-collections", CreatePrivateCollectionRequest{Name: "hop_dong"})).Code; code != ErrCodeForbidden {
+		t.Errorf("create without a tenant = %s, want %s", code, ErrCodeForbidden)
+	}
+	for _, tenant := range []string{"acme", "globex"} {
+		if rec := do(tenant, http.MethodPost, "/api/private-collections", CreatePrivateCollectionRequest{Name: "hop_dong", Title: "Hợp đồng lao động"}); rec.Code != http.StatusCreated {
+			t.Fatalf("create collection of %s = %d %s", tenant, rec.Code, rec.Body.String())
+		}
+	}
+	if code := decodeError(t, do("acme", http.MethodPost, "/api/private-collections", CreatePrivateCollectionRequest{Name: "hop_dong"})).Code; code != ErrCodeCollectionExists {
+		t.Errorf("create twice = %s, want %s", code, ErrCodeCollectionExists)
+	}
+	acmeDoc := upload("acme", "hop_dong", "Thời gian thử việc của ACME là 30 ngày.")
+	upload("globex", "hop_dong", "Thời gian thử việc của Globex là 60 ngày.")
+
+	var resp engine.LegalQueryResponse
+	rec := ask("acme", "hop_dong")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("query of acme = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.SearchResults) != 1 || !strings.Contains(resp.SearchResults[0].Excerpt, "ACME") {
+		t.Errorf("search results of acme = %+v, want only the ACME contract", resp.SearchResults)
+	}
+	if last := fake.queries[len(fake.queries)-1]; last.Namespace != "tenant-acme" {
+		t.Errorf("engine namespace = %q, want tenant-acme", last.Namespace)
+	}
+	// Without a catalog, another tenant's query names a shared collection
+	resp = engine.LegalQueryResponse{}
+	json.Unmarshal(ask("initech", "hop_dong").Body.Bytes(), &resp)
+	if last := fake.queries[len(fake.queries)-1]; last.Namespace != "" || len(resp.SearchResults) != 0 {
+		t.Errorf("query by another tenant searched namespace %q: %+v", last.Namespace, resp.SearchResults)
+	}
+	if rec := ask("acme", ""); rec.Code != http.StatusOK || fake.queries[len(fake.queries)-1].Namespace != "" {
+		t.Errorf("shared query = %d, namespace %q, want none", rec.Code, fake.queries[len(fake.queries)-1].Namespace)
+	}
+	// Claiming the tenant in X-Tenant-ID without its key reaches nothing
+	if code := decodeError(t, do("claimed:acme", http.MethodGet, "/api/private-collections", nil)).Code; code != ErrCodeForbidden {
+		t.Errorf("list claimed for acme = %s, want %s", code, ErrCodeForbidden)
+	}
+	resp = engine.LegalQueryResponse{}
+	json.Unmarshal(ask("claimed:acme", "hop_dong").Body.Bytes(), &resp)
+	if last := fake.queries[len(fake.queries)-1]; last.Namespace != "" || len(resp.SearchResults) != 0 {
+		t.Errorf("query claimed for acme searched namespace %q: %+v", last.Namespace, resp.SearchResults)
+	}
+
+	var listed struct {
+		Collections []CollectionSummary `json:"collections"`
+	}
+	json.Unmarshal(do("acme", http.MethodGet, "/api/collections", nil).Body.Bytes(), &listed)
+	if len(listed.Collections) != 1 || !listed.Collections[0].Private || listed.Collections[0].Name != "hop_dong" {
+		t.Errorf("collections of acme = %+v, want the private hop_dong", listed.Collections)
+	}
+
+	if rec := do("globex", http.MethodDelete, "/api/private-collections/hop_dong/documents/"+acmeDoc.ID, nil); decodeError(t, rec).Code != ErrCodeDocumentNotFound {
+		t.Errorf("delete by another tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("acme", http.MethodDelete, "/api/private-collections/hop_dong/documents/"+acmeDoc.ID, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete document = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.documents["tenant-acme"]) != 0 || len(fake.documents["tenant-globex"]) != 1 {
+		t.Errorf("engine documents after the delete = %+v", fake.documents)
+	}
+	if rec := do("globex", http.MethodDelete, "/api/private-collections/hop_dong", nil); rec.Code != http.StatusNoContent || len(fake.documents["tenant-globex"]) != 0 {
+		t.Errorf("delete collection = %d, engine documents %+v", rec.Code, fake.documents)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Readiness states
+const (
+	ReadinessReady    = "ready"
+	ReadinessDegraded = "degraded"
+	ReadinessNotReady = "not_ready"
+)
+
+// dependencyCheck checks one dependency of the server. A required
+// dependency that is down makes the server not ready, failing with code.
+type dependencyCheck struct {
+	name     string
+	required bool
+	code     ErrorCode
+	check    func() error
+}
+
+// DependencyStatus is the outcome of the check of one dependency
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	Required  bool   `json:"required"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessReport is returned by the readiness probe
+type ReadinessReport struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
+// Readiness checks the dependencies of the server for the readiness probe
+type Readiness struct {
+	timeout time.Duration
+	checks  []dependencyCheck
+}
+
+func NewReadiness(timeout time.Duration) *Readiness {
+	return &Readiness{timeout: timeout}
+}
+
+// add registers a dependency; required ones fail readiness with code
+func (r *Readiness) add(name string, required bool, code ErrorCode, check func() error) {
+	r.checks = append(r.checks, dependencyCheck{name: name, required: required, code: code, check: check})
+}
+
+// Check runs every check concurrently, each bounded by the timeout. It
+// returns the error code of the first required dependency that is down, in
+// the order they were added, or "" when the server is ready.
+func (r *Readiness) Check() (ReadinessReport, ErrorCode) {
+	statuses := make([]DependencyStatus, len(r.checks))
+	var wg sync.WaitGroup
+	for i, dep := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = r.run(dep)
+		}()
+	}
+	wg.Wait()
+
+	report := ReadinessReport{Status: ReadinessReady, Checks: make(map[string]DependencyStatus, len(r.checks))}
+	var code ErrorCode
+	for i, dep := range r.checks {
+		status := statuses[i]
+		report.Checks[dep.name] = status
+		if status.Status == "up" {
+			continue
+		}
+		if !dep.required {
+			if report.Status == ReadinessReady {
+				report.Status = ReadinessDegraded
+			}
+			continue
+		}
+		report.Status = ReadinessNotReady
+		if code == "" {
+			code = dep.code
+		}
+	}
+	return report, code
+}
+
+func (r *Readiness) run(dep dependencyCheck) DependencyStatus {
+	started := time.Now()
+	err := checkWithin(dep.check, r.timeout)
+	status := DependencyStatus{Status: "up", Required: dep.required, LatencyMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// checkTimeoutError is returned by checkWithin when a check takes too long
+type checkTimeoutError struct{ timeout time.Duration }
+
+func (e checkTimeoutError) Error() string { return fmt.Sprintf("no answer within %v", e.timeout) }
+
+// checkWithin runs check, giving up after timeout. A check that gives up
+// keeps running in the background until it returns.
+func checkWithin(check func() error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return checkTimeoutError{timeout}
+	}
+}
+
+// Handlers
+
+// livenessHandler answers as long as the process serves requests; it
+// checks no dependency, so that an engine outage does not restart the pod
+func livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// readinessHandler reports every dependency. When a required one is down it
+// answers 503 with the error code of that dependency alongside the report.
+func readinessHandler(readiness *Readiness) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, code := readiness.Check()
+		if code == "" {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+
+		down := []string{}
+		for name, status := range report.Checks {
+			if status.Required && status.Status != "up" {
+				down = append(down, name)
+			}
+		}
+		sort.Strings(down)
+		c.Set(errorCodeKey, code)
+		c.AbortWithStatusJSON(lookupError(code).HTTPStatus, struct {
+			ErrorResponse
+			ReadinessReport
+		}{
+			ErrorResponse: ErrorResponse{
+				Error:   errorName(code),
+				Code:    code,
+				Message: "Not ready: " + strings.Join(down, ", ") + " down",
+			},
+			ReadinessReport: report,
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := NewReadiness(50 * time.Millisecond)
+	readiness.add("engine", true, ErrCodeEngineUnavailable, func() error { return nil })
+	readiness.add("cache", false, "", func() error { return errors.New("connection refused") })
+
+	report, code := readiness.Check()
+	if report.Status != ReadinessDegraded || code != "" {
+		t.Errorf("status = %s with code %q, want degraded and ready", report.Status, code)
+	}
+	if cache := report.Checks["cache"]; cache.Status != "down" || cache.Required || cache.Error != "connection refused" {
+		t.Errorf("cache = %+v, want down, optional, with its error", cache)
+	}
+
+	// A dependency that does not answer in time is down
+	release := make(chan struct{})
+	defer close(release)
+	readiness.add("database", true, ErrCodeHistoryUnavailable, func() error { <-release; return nil })
+	report, code = readiness.Check()
+	if report.Status != ReadinessNotReady || code != ErrCodeHistoryUnavailable {
+		t.Errorf("status = %s with code %q, want not ready with %s", report.Status, code, ErrCodeHistoryUnavailable)
+	}
+	if db := report.Checks["database"]; db.Status != "down" || db.Error != "no answer within 50ms" {
+		t.Errorf("database = %+v, want down after the timeout", db)
+	}
+}
+
+// checkedEngine is a stub engine whose health can be changed
+type checkedEngine struct {
+	stubEngine
+	healthMu  sync.Mutex
+	healthErr error
+}
+
+func (e *checkedEngine) HealthCheck() error {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+	return e.healthErr
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	e := &checkedEngine{stubEngine: stubEngine{resp: engine.LegalQueryResponse{Answer: "Thời gian thử việc.", Iterations: 1}}}
+	e.healthErr = errors.New("health check failed: connection refused")
+	h := newTestServer(t, Options{Engine: e}).Handler()
+
+	// Liveness does not depend on the engine
+	if rec := doJSON(t, h, http.MethodGet, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Errorf("healthz = %d, want 200 while the engine is down", rec.Code)
+	}
+
+	rec := doJSON(t, h, http.MethodGet, "/readyz", nil)
+	if code := decodeError(t, rec).Code; rec.Code != http.StatusServiceUnavailable || code != ErrCodeEngineUnavailable {
+		t.Fatalf("readyz = %d %s, want 503 %s", rec.Code, code, ErrCodeEngineUnavailable)
+	}
+	var report ReadinessReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if report.Status != ReadinessNotReady || report.Checks["engine"].Status != "down" || !report.Checks["engine"].Required {
+		t.Errorf("report = %+v, want the engine down", report)
+	}
+
+	e.healthMu.Lock()
+	e.healthErr = nil
+	e.healthMu.Unlock()
+	rec = doJSON(t, h, http.MethodGet, "/readyz", nil)
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Status != ReadinessReady || report.Checks["engine"].Status != "up" {
+		t.Errorf("readyz = %d %s, want ready with the engine up", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/logging"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// profiles are the setting defaults selected by APP_ENV
+var profiles = map[string]settings.Profile{
+	"dev": {
+		Name: "dev",
+		Settings: map[string]string{
+			"LOG_LEVEL":           middleware.LogDebug,
+			"LOG_FORMAT":          logging.FormatText,
+			"CORS_ALLOW_ORIGIN":   "*",
+			"MOCK_ENGINE":         "true",
+			"DEFAULT_PLAN":        defaultPlanName,
+			"WARM_CACHE_ON_START": "false",
+		},
+	},
+	"staging": {
+		Name: "staging",
+		Settings: map[string]string{
+			"LOG_LEVEL":         middleware.LogInfo,
+			"CORS_ALLOW_ORIGIN": "*",
+			"MOCK_ENGINE":       "false",
+			"DEFAULT_PLAN":      "standard",
+			"STRICT_CONFIG":     "true",
+		},
+	},
+	"prod": {
+		Name: "prod",
+		Settings: map[string]string{
+			"LOG_LEVEL":         middleware.LogWarn,
+			"CORS_ALLOW_ORIGIN": middleware.CORSOff,
+			"MOCK_ENGINE":       "false",
+			"DEFAULT_PLAN":      "standard",
+			"STRICT_CONFIG":     "true",
+		},
+	},
+}
+
+// configFileSchema maps the sections of YAML and TOML config files to the
+// settings they set. Settings outside the schema can still be set by name
+// at the top level of the file.
+var configFileSchema = settings.Schema{
+	"server.port":                   "GO_SERVER_PORT",
+	"server.grpc_port":              "GRPC_PORT",
+	"server.admin_port":             "ADMIN_PORT",
+	"server.metrics_port":           "METRICS_PORT",
+	"server.unix_socket":            "UNIX_SOCKET",
+	"server.data_dir":               "DATA_DIR",
+	"server.request_timeout":        "REQUEST_TIMEOUT",
+	"server.shutdown_drain_timeout": "SHUTDOWN_DRAIN_TIMEOUT",
+	"server.strict":                 "STRICT_CONFIG",
+	"server.default_plan":           "DEFAULT_PLAN",
+	"server.cors.allow_origins":     "CORS_ALLOW_ORIGIN",
+	"server.cors.allow_credentials": "CORS_ALLOW_CREDENTIALS",
+	"server.cors.max_age":           "CORS_MAX_AGE",
+	"server.trusted_proxies":        "TRUSTED_PROXIES",
+	"server.reload_interval":        "CONFIG_RELOAD_INTERVAL",
+
+	"log.level":        "LOG_LEVEL",
+	"log.format":       "LOG_FORMAT",
+	"log.sample_rate":  "LOG_SAMPLE_RATE",
+	"log.dedup_window": "LOG_DEDUP_WINDOW",
+
+	"engine.url":                   "PYTHON_AI_ENGINE_URL",
+	"engine.mock":                  "MOCK_ENGINE",
+	"engine.concurrency_limit":     "ENGINE_CONCURRENCY_LIMIT",
+	"engine.slot_wait":             "ENGINE_SLOT_WAIT",
+	"engine.retry.attempts":        "ENGINE_RETRY_ATTEMPTS",
+	"engine.retry.base_delay":      "ENGINE_RETRY_BASE_DELAY",
+	"engine.retry.max_delay":       "ENGINE_RETRY_MAX_DELAY",
+	"engine.retry.deadline":        "ENGINE_RETRY_DEADLINE",
+	"engine.breaker.threshold":     "ENGINE_BREAKER_THRESHOLD",
+	"engine.breaker.cooldown":      "ENGINE_BREAKER_COOLDOWN",
+	"engine.signing.secret":        "ENGINE_SIGNING_SECRET",
+	"engine.signing.max_skew":      "ENGINE_SIGNING_MAX_SKEW",
+	"engine.callback.url":          "ENGINE_CALLBACK_URL",
+	"engine.callback.timeout":      "ENGINE_CALLBACK_TIMEOUT",
+	"engine.regions.primary":       "PRIMARY_REGION",
+	"engine.regions.secondary":     "SECONDARY_REGION",
+	"engine.regions.secondary_url": "SECONDARY_ENGINE_URL",
+
+	"cache.ttl":           "RESPONSE_CACHE_TTL",
+	"cache.max_entries":   "RESPONSE_CACHE_MAX_ENTRIES",
+	"cache.redis_url":     "REDIS_URL",
+	"cache.redis_prefix":  "REDIS_CACHE_PREFIX",
+	"cache.warm.top_n":    "WARM_CACHE_TOP_N",
+	"cache.warm.on_start": "WARM_CACHE_ON_START",
+	"cache.warm.interval": "WARM_CACHE_INTERVAL",
+
+	"auth.admin_token":          "ADMIN_TOKEN",
+	"auth.metrics_token":        "METRICS_TOKEN",
+	"auth.require_api_key":      "REQUIRE_API_KEY",
+	"auth.senior_lawyers":       "SENIOR_LAWYERS",
+	"auth.compliance_reviewers": "COMPLIANCE_REVIEWERS",
+
+	"rate_limit.per_minute":  "RATE_LIMIT_PER_MINUTE",
+	"rate_limit.burst":       "RATE_LIMIT_BURST",
+	"rate_limit.daily_quota": "QUERY_QUOTA_PER_DAY",
+
+	"query.max_iterations":  "DEFAULT_MAX_ITERATIONS",
+	"query.top_k":           "DEFAULT_TOP_K",
+	"query.web_search":      "DEFAULT_WEB_SEARCH",
+	"query.model":           "DEFAULT_MODEL",
+	"query.response_format": "DEFAULT_RESPONSE_FORMAT",
+}
+
+// SettingList collects repeated -set NAME=VALUE flags
+type SettingList map[string]string
+
+func (l SettingList) String() string {
+	items := make([]string, 0, len(l))
+	for name, value := range l {
+		items = append(items, name+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (l SettingList) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=VALUE, got %q", value)
+	}
+	l[name] = v
+	return nil
+}
+
+// flagSettings maps command-line flags to the settings they override
+var flagSettings = map[string]string{
+	"env":         "APP_ENV",
+	"config":      "CONFIG_FILE",
+	"mock-engine": "MOCK_ENGINE",
+	"strict":      "STRICT_CONFIG",
+}
+
+// RegisterSettingFlags adds the flags shared by every command that loads
+// the configuration
+func RegisterSettingFlags(flags *flag.FlagSet) SettingList {
+	overrides := SettingList{}
+	flags.String("env", "", "configuration profile: "+strings.Join(settings.ProfileNames(profiles), ", ")+" (env APP_ENV)")
+	flags.String("config", "", "config file: .yaml, .yml or .toml, or else NAME=VALUE lines (env CONFIG_FILE, defaults to .env when present)")
+	flags.Var(overrides, "set", "override a setting as NAME=VALUE; repeatable")
+	return overrides
+}
+
+// LoadSettings builds the setting layers from the parsed flags, the config
+// file and the profile selected by APP_ENV. flags may be nil when the server
+// is embedded without command-line flags.
+func LoadSettings(flags *flag.FlagSet, overrides SettingList) {
+	flagLayer := make(map[string]string)
+	if flags != nil {
+		flags.Visit(func(f *flag.Flag) {
+			if name, ok := flagSettings[f.Name]; ok {
+				flagLayer[name] = f.Value.String()
+			}
+		})
+	}
+	for name, value := range overrides {
+		flagLayer[name] = value
+	}
+	settings.Load(flagLayer, profiles, configFileSchema)
+}
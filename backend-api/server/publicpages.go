@@ -0,0 +1,548 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// publicPageMaxAge is how long browsers and CDNs may cache a public
+	// page; pages are revalidated with their ETag after that
+	publicPageMaxAge = time.Hour
+
+	maxSlugLength = 80
+
+	// maxSitemapURLs is the limit of the sitemap protocol
+	maxSitemapURLs = 50000
+)
+
+// PublicPagesSettings opts a tenant into publishing approved answers as
+// public FAQ pages under /faq/<tenant>
+type PublicPagesSettings struct {
+	Enabled bool `json:"enabled"`
+
+	// Title names the FAQ site in pages; empty uses the tenant's name
+	Title string `json:"title,omitempty"`
+
+	// NoIndex keeps the pages out of search engines: they are left out of
+	// the sitemap, disallowed in robots.txt and marked noindex
+	NoIndex bool `json:"noindex,omitempty"`
+}
+
+// PublicPage is an approved answer published on the tenant's FAQ site
+type PublicPage struct {
+	Slug        string    `json:"slug"`
+	TenantID    string    `json:"tenant_id"`
+	HistoryID   string    `json:"history_id"`
+	Path        string    `json:"path"`
+	PublishedBy string    `json:"published_by,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+var errPublicPageNotFound = errors.New("public page not found")
+
+// PublicPageStore keeps the published pages, persisted to a JSON file when
+// a path is configured
+type PublicPageStore struct {
+	mu    sync.RWMutex
+	path  string
+	pages map[string]*PublicPage
+}
+
+func NewPublicPageStore(path string) (*PublicPageStore, error) {
+	store := &PublicPageStore{
+		path:  path,
+		pages: make(map[string]*PublicPage),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var pages []*PublicPage
+	if _, err := readJSONFile(path, &pages); err != nil {
+		return nil, fmt.Errorf("failed to load public pages: %w", err)
+	}
+	for _, p := range pages {
+		store.pages[publicPageKey(p.TenantID, p.Slug)] = p
+	}
+	return store, nil
+}
+
+func publicPageKey(tenantID, slug string) string {
+	return tenantID + "\x00" + slug
+}
+
+// Get returns a page by its tenant and slug
+func (s *PublicPageStore) Get(tenantID, slug string) (PublicPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.pages[publicPageKey(tenantID, slug)]; ok {
+		return *p, nil
+	}
+	return PublicPage{}, errPublicPageNotFound
+}
+
+// List returns the pages of a tenant, newest first; "" lists every tenant's
+func (s *PublicPageStore) List(tenantID string) []PublicPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pages := []PublicPage{}
+	for _, p := range s.pages {
+		if tenantID == "" || p.TenantID == tenantID {
+			pages = append(pages, *p)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PublishedAt.After(pages[j].PublishedAt) })
+	return pages
+}
+
+// Publish stores a page for an answer, or returns the page it already has
+// with created unset
+func (s *PublicPageStore) Publish(tenantID, historyID, question, user string) (page PublicPage, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.pages {
+		if p.TenantID == tenantID && p.HistoryID == historyID {
+			return *p, false, nil
+		}
+	}
+	slug := slugify(question, maxSlugLength)
+	for {
+		candidate := strings.TrimPrefix(slug+"-"+randomHex(3), "-")
+		if _, taken := s.pages[publicPageKey(tenantID, candidate)]; !taken {
+			slug = candidate
+			break
+		}
+	}
+	p := &PublicPage{
+		Slug:        slug,
+		TenantID:    tenantID,
+		HistoryID:   historyID,
+		Path:        "/faq/" + tenantID + "/" + slug,
+		PublishedBy: user,
+		PublishedAt: time.Now().UTC(),
+	}
+	s.pages[publicPageKey(tenantID, slug)] = p
+	return *p, true, s.saveLocked()
+}
+
+// Unpublish deletes the page of an answer
+func (s *PublicPageStore) Unpublish(tenantID, historyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, p := range s.pages {
+		if p.TenantID == tenantID && p.HistoryID == historyID {
+			delete(s.pages, key)
+			return s.saveLocked()
+		}
+	}
+	return errPublicPageNotFound
+}
+
+func (s *PublicPageStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	pages := make([]*PublicPage, 0, len(s.pages))
+	for _, p := range s.pages {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return publicPageKey(pages[i].TenantID, pages[i].Slug) < publicPageKey(pages[j].TenantID, pages[j].Slug)
+	})
+	return writeJSONFile(s.path, pages)
+}
+
+// slugify turns a question into a lowercase ASCII URL segment, dropping
+// Vietnamese diacritics: "Thời gian thử việc?" becomes "thoi-gian-thu-viec"
+func slugify(text string, maxLen int) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			r = 'd'
+		case r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)):
+			dash = b.Len() > 0
+			continue
+		}
+		if b.Len()+2 > maxLen {
+			break
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// publicTenant returns a tenant that publishes public pages
+func publicTenant(tenants *TenantStore, id string) (Tenant, bool) {
+	tenant, ok := tenants.Get(id)
+	return tenant, ok && tenant.Settings.PublicPages.Enabled
+}
+
+func (s PublicPagesSettings) siteTitle(tenant Tenant) string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return tenant.Name
+}
+
+func (s PublicPagesSettings) robots() string {
+	if s.NoIndex {
+		return "noindex, nofollow"
+	}
+	return "index, follow"
+}
+
+// publicAnswer is the published version of a page's answer: its latest
+// edit, while it is approved
+type publicAnswer struct {
+	entry    HistoryEntry
+	approval ReviewEvent
+}
+
+func (a publicAnswer) updated(page PublicPage) time.Time {
+	if a.approval.At.After(page.PublishedAt) {
+		return a.approval.At
+	}
+	return page.PublishedAt
+}
+
+// answerOf returns the answer a page shows, or false when its approval was
+// revoked or it left the history
+func answerOf(page PublicPage, reviews *ReviewStore, history *HistoryStore, edits *EditStore) (publicAnswer, bool) {
+	approval, approved := reviews.Get(page.HistoryID, page.TenantID).approval()
+	if !approved {
+		return publicAnswer{}, false
+	}
+	entry, err := history.Get(page.HistoryID, page.TenantID)
+	if err != nil {
+		return publicAnswer{}, false
+	}
+	return publicAnswer{entry: edits.Current(entry), approval: approval}, true
+}
+
+// paragraphs splits an answer into paragraphs of lines for the page
+func paragraphs(text string) [][]string {
+	var paras [][]string
+	for _, block := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		var lines []string
+		for _, line := range strings.Split(block, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			paras = append(paras, lines)
+		}
+	}
+	return paras
+}
+
+var publicPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="vi">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} | {{.Site}}</title>
+{{with .Description}}<meta name="description" content="{{.}}">
+{{end}}<meta name="robots" content="{{.Robots}}">
+<link rel="canonical" href="{{.Canonical}}">
+{{with .StructuredData}}<script type="application/ld+json">{{.}}</script>
+{{end}}</head>
+<body>
+<header><a href="{{.IndexURL}}">{{.Site}}</a></header>
+<main>
+<h1>{{.Title}}</h1>
+{{range .Paragraphs}}<p>{{range $i, $line := .}}{{if $i}}<br>
+{{end}}{{$line}}{{end}}</p>
+{{end}}{{with .Sources}}<h2>Căn cứ pháp lý</h2>
+<ul>
+{{range .}}<li>{{if .URL}}<a href="{{.URL}}" rel="nofollow">{{.Title}}</a>{{else}}{{.Title}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{with .Links}}<ul>
+{{range .}}<li><a href="{{.URL}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{end}}{{with .Updated}}<p><small>Cập nhật ngày {{.}}</small></p>
+{{end}}</main>
+</body>
+</html>
+`))
+
+// publicPageData fills publicPageTemplate
+type publicPageData struct {
+	Title          string
+	Site           string
+	Description    string
+	Robots         string
+	Canonical      string
+	IndexURL       string
+	StructuredData any
+	Paragraphs     [][]string
+	Sources        []BinderSource
+	Links          []BinderSource
+	Updated        string
+}
+
+// writePublicPage renders a page with an ETag of its content, so caches
+// can revalidate it
+func writePublicPage(c *gin.Context, settings PublicPagesSettings, data publicPageData) {
+	var buf bytes.Buffer
+	if err := publicPageTemplate.Execute(&buf, data); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to render public page", "path", c.Request.URL.Path, "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to render the page")
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicPageMaxAge.Seconds())))
+	if settings.NoIndex {
+		c.Header("X-Robots-Tag", settings.robots())
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Handlers
+
+// publishPageHandler publishes an approved answer on the FAQ site of the
+// caller's tenant. Only senior lawyers may publish, and only for tenants
+// that opted into public pages.
+func publishPageHandler(pages *PublicPageStore, reviews *ReviewStore, history *HistoryStore, seniorLawyers []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		if !tenant.Settings.PublicPages.Enabled {
+			abortWithError(c, ErrCodeForbidden, "Public pages are not enabled for the tenant")
+			return
+		}
+		if !isSeniorLawyer(c, seniorLawyers) {
+			abortWithError(c, ErrCodeForbidden, "Only senior lawyers can publish answers")
+			return
+		}
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		if state := reviews.Get(entry.ID, tenant.ID).State; state != ReviewApproved {
+			abortWithError(c, ErrCodeReviewConflict, fmt.Sprintf("Answer %q is %s; only approved answers can be published", entry.ID, state))
+			return
+		}
+
+		page, created, err := pages.Publish(tenant.ID, entry.ID, entry.Question, authenticatedUser(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save public pages", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to publish the answer")
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		c.JSON(status, page)
+	}
+}
+
+func unpublishPageHandler(pages *PublicPageStore, seniorLawyers []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isSeniorLawyer(c, seniorLawyers) {
+			abortWithError(c, ErrCodeForbidden, "Only senior lawyers can unpublish answers")
+			return
+		}
+		tenant, _ := callerTenant(c)
+		err := pages.Unpublish(tenant.ID, c.Param("id"))
+		if errors.Is(err, errPublicPageNotFound) {
+			abortWithError(c, ErrCodePublicPageNotFound, fmt.Sprintf("Answer %q is not published", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save public pages", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to unpublish the answer")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func listPublicPagesHandler(pages *PublicPageStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		if tenant.ID == "" {
+			c.JSON(http.StatusOK, gin.H{"pages": []PublicPage{}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"pages": pages.List(tenant.ID)})
+	}
+}
+
+// publicPageHandler renders a published answer as an HTML page with its
+// schema.org FAQPage structured data. Pages whose approval was revoked are
+// gone until the answer is approved again.
+func publicPageHandler(pages *PublicPageStore, tenants *TenantStore, reviews *ReviewStore, history *HistoryStore, edits *EditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := publicTenant(tenants, c.Param("tenant"))
+		page, err := pages.Get(tenant.ID, c.Param("slug"))
+		if !ok || err != nil {
+			abortWithError(c, ErrCodePublicPageNotFound, "Page not found")
+			return
+		}
+		answer, ok := answerOf(page, reviews, history, edits)
+		if !ok {
+			abortWithError(c, ErrCodePublicPageNotFound, "Page not found")
+			return
+		}
+
+		base := requestBaseURL(c)
+		settings := tenant.Settings.PublicPages
+		text := answer.entry.Response.Answer
+		data := publicPageData{
+			Title:       answer.entry.Question,
+			Site:        settings.siteTitle(tenant),
+			Description: truncateUTF8(strings.Join(strings.Fields(text), " "), 160),
+			Robots:      settings.robots(),
+			Canonical:   base + page.Path,
+			IndexURL:    base + "/faq/" + tenant.ID,
+			StructuredData: map[string]any{
+				"@context": "https://schema.org",
+				"@type":    "FAQPage",
+				"mainEntity": []map[string]any{{
+					"@type": "Question",
+					"name":  answer.entry.Question,
+					"acceptedAnswer": map[string]any{
+						"@type": "Answer",
+						"text":  text,
+					},
+				}},
+			},
+			Paragraphs: paragraphs(text),
+			Updated:    answer.updated(page).Format("02/01/2006"),
+		}
+		for _, r := range answer.entry.Response.SearchResults {
+			source := sourceFromResult(r)
+			source.Excerpt = ""
+			data.Sources = append(data.Sources, source)
+		}
+		writePublicPage(c, settings, data)
+	}
+}
+
+// publicIndexHandler lists the published answers of a tenant's FAQ site
+func publicIndexHandler(pages *PublicPageStore, tenants *TenantStore, reviews *ReviewStore, history *HistoryStore, edits *EditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := publicTenant(tenants, c.Param("tenant"))
+		if !ok {
+			abortWithError(c, ErrCodePublicPageNotFound, "Page not found")
+			return
+		}
+		base := requestBaseURL(c)
+		settings := tenant.Settings.PublicPages
+		data := publicPageData{
+			Title:     "Hỏi đáp pháp luật",
+			Site:      settings.siteTitle(tenant),
+			Robots:    settings.robots(),
+			Canonical: base + "/faq/" + tenant.ID,
+			IndexURL:  base + "/faq/" + tenant.ID,
+		}
+		for _, page := range pages.List(tenant.ID) {
+			if answer, ok := answerOf(page, reviews, history, edits); ok {
+				data.Links = append(data.Links, BinderSource{Title: answer.entry.Question, URL: base + page.Path})
+			}
+		}
+		writePublicPage(c, settings, data)
+	}
+}
+
+// sitemapHandler lists the FAQ sites and pages of the tenants that publish
+// them and let search engines index them
+func sitemapHandler(pages *PublicPageStore, tenants *TenantStore, reviews *ReviewStore, history *HistoryStore, edits *EditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		base := requestBaseURL(c)
+		set := sitemapURLSet{URLs: []sitemapURL{}}
+		for _, tenant := range tenants.List() {
+			if settings := tenant.Settings.PublicPages; !settings.Enabled || settings.NoIndex {
+				continue
+			}
+			index := sitemapURL{Loc: base + "/faq/" + tenant.ID}
+			var newest time.Time
+			var urls []sitemapURL
+			for _, page := range pages.List(tenant.ID) {
+				answer, ok := answerOf(page, reviews, history, edits)
+				if !ok {
+					continue
+				}
+				updated := answer.updated(page)
+				if updated.After(newest) {
+					newest = updated
+				}
+				urls = append(urls, sitemapURL{Loc: base + page.Path, LastMod: updated.UTC().Format(time.RFC3339)})
+			}
+			if !newest.IsZero() {
+				index.LastMod = newest.UTC().Format(time.RFC3339)
+			}
+			set.URLs = append(set.URLs, index)
+			set.URLs = append(set.URLs, urls...)
+		}
+		if len(set.URLs) > maxSitemapURLs {
+			set.URLs = set.URLs[:maxSitemapURLs]
+		}
+		body, err := xml.MarshalIndent(set, "", "  ")
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to render sitemap", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to render the sitemap")
+			return
+		}
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicPageMaxAge.Seconds())))
+		c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), body...))
+	}
+}
+
+// robotsHandler keeps crawlers to the FAQ sites: the API is disallowed, as
+// are the sites of tenants that set noindex
+func robotsHandler(tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var b strings.Builder
+		b.WriteString("User-agent: *\nDisallow: /api/\nDisallow: /admin/\n")
+		for _, tenant := range tenants.List() {
+			if settings := tenant.Settings.PublicPages; settings.Enabled && settings.NoIndex {
+				fmt.Fprintf(&b, "Disallow: /faq/%s/\nDisallow: /faq/%s$\n", tenant.ID, tenant.ID)
+			}
+		}
+		fmt.Fprintf(&b, "Allow: /faq/\n\nSitemap: %s/sitemap.xml\n", requestBaseURL(c))
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicPageMaxAge.Seconds())))
+		c.String(http.StatusOK, b.String())
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestPublicPages(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.\n\nKhông quá 180 ngày đối với người quản lý doanh nghiệp.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"}},
+		Iterations:    1,
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	keys := map[string]string{"lan": userKey(t, h, "lan"), "minh": userKey(t, h, "minh")}
+	do := func(user, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "admin-token")
+		if !strings.HasPrefix(path, "/admin/") {
+			req.Header.Set("X-Tenant-ID", "acme")
+		}
+		if user != "" {
+			req.Header.Set("X-API-Key", keys[user])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do("", http.MethodPost, "/admin/tenants", Tenant{ID: "acme", Name: "ACME Law Firm", Settings: TenantSettings{SeniorLawyers: []string{"minh"}}}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	var answer engine.LegalQueryResponse
+	json.Unmarshal(do("lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}).Body.Bytes(), &answer)
+	publish := "/api/history/" + answer.HistoryID + "/publish"
+
+	if code := decodeError(t, do("minh", http.MethodPost, publish, nil)).Code; code != ErrCodeForbidden {
+		t.Errorf("publish without opting in = %s, want %s", code, ErrCodeForbidden)
+	}
+	if rec := do("", http.MethodPut, "/admin/tenants/acme/settings", TenantSettings{SeniorLawyers: []string{"minh"}, PublicPages: PublicPagesSettings{Enabled: true, Title: "ACME Hỏi đáp"}}); rec.Code != http.StatusOK {
+		t.Fatalf("enable public pages = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do("minh", http.MethodPost, publish, nil)).Code; code != ErrCodeReviewConflict {
+		t.Errorf("publish a draft = %s, want %s", code, ErrCodeReviewConflict)
+	}
+	review := "/api/history/" + answer.HistoryID + "/review"
+	do("lan", http.MethodPost, review, ReviewRequest{State: ReviewReviewed})
+	do("minh", http.MethodPost, review, ReviewRequest{State: ReviewApproved})
+	if code := decodeError(t, do("lan", http.MethodPost, publish, nil)).Code; code != ErrCodeForbidden {
+		t.Errorf("publish by a junior = %s, want %s", code, ErrCodeForbidden)
+	}
+	spoofed := httptest.NewRequest(http.MethodPost, publish, nil)
+	spoofed.Header.Set("X-Tenant-ID", "acme")
+	spoofed.Header.Set("X-User-ID", "minh")
+	rec := httptest.NewRecorder()
+	if h.ServeHTTP(rec, spoofed); rec.Code != http.StatusForbidden {
+		t.Errorf("publish claimed for minh in X-User-ID = %d, want 403", rec.Code)
+	}
+	rec = do("minh", http.MethodPost, publish, nil)
+	var page PublicPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("publish = %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(page.Path, "/faq/acme/thoi-gian-thu-viec-toi-da-la-bao-lau-") {
+		t.Errorf("path = %q, want a slug of the question", page.Path)
+	}
+
+	// Pages are public, cacheable and carry FAQPage structured data
+	req := httptest.NewRequest(http.MethodGet, page.Path, nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Cache-Control"), "public") {
+		t.Fatalf("page = %d %v", rec.Code, rec.Header())
+	}
+	for _, want := range []string{`"@type":"FAQPage"`, "<h1>Thời gian thử việc tối đa là bao lâu?</h1>", "<li>Thời gian thử việc</li>", `content="index, follow"`, "ACME Hỏi đáp"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %s:\n%s", want, body)
+		}
+	}
+	req = httptest.NewRequest(http.MethodGet, page.Path, nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidated page = %d, want 304", rec.Code)
+	}
+
+	rec = do("", http.MethodGet, "/sitemap.xml", nil)
+	var sitemap sitemapURLSet
+	if err := xml.Unmarshal(rec.Body.Bytes(), &sitemap); err != nil || len(sitemap.URLs) != 2 || !strings.HasSuffix(sitemap.URLs[1].Loc, page.Path) {
+		t.Errorf("sitemap = %s, want the FAQ index and the page", rec.Body.String())
+	}
+
+	// noindex keeps the site out of search engines
+	do("", http.MethodPut, "/admin/tenants/acme/settings", TenantSettings{SeniorLawyers: []string{"minh"}, PublicPages: PublicPagesSettings{Enabled: true, NoIndex: true}})
+	if robots := do("", http.MethodGet, "/robots.txt", nil).Body.String(); !strings.Contains(robots, "Disallow: /faq/acme/") {
+		t.Errorf("robots.txt = %q, want the site disallowed", robots)
+	}
+	if rec := do("", http.MethodGet, "/sitemap.xml", nil); strings.Contains(rec.Body.String(), "/faq/acme") {
+		t.Errorf("sitemap = %s, want no noindex site", rec.Body.String())
+	}
+	if rec := do("", http.MethodGet, page.Path, nil); rec.Header().Get("X-Robots-Tag") != "noindex, nofollow" {
+		t.Errorf("X-Robots-Tag = %q, want noindex", rec.Header().Get("X-Robots-Tag"))
+	}
+
+	// Revoked approvals and unpublished answers are gone
+	do("minh", http.MethodPost, review, ReviewRequest{State: ReviewDraft})
+	if code := decodeError(t, do("", http.MethodGet, page.Path, nil)).Code; code != ErrCodePublicPageNotFound {
+		t.Errorf("page of a revoked approval = %s, want %s", code, ErrCodePublicPageNotFound)
+	}
+	if rec := do("minh", http.MethodDelete, publish, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("unpublish = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do("minh", http.MethodDelete, publish, nil)).Code; code != ErrCodePublicPageNotFound {
+		t.Errorf("unpublish again = %s, want %s", code, ErrCodePublicPageNotFound)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"Thời gian thử việc tối đa là bao lâu?", "thoi-gian-thu-viec-toi-da-la-bao-lau"},
+		{"Điều 25 — BLLĐ 2019", "dieu-25-blld-2019"},
+		{"???", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.text, maxSlugLength); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if got := slugify(strings.Repeat("thu viec ", 20), 20); len(got) > 20 || strings.HasSuffix(got, "-") {
+		t.Errorf("long slug = %q, want at most 20 bytes", got)
+	}
+}
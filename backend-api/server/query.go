@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Request/Response Models
+
+// LegalQueryRequest represents the request from client
+type LegalQueryRequest struct {
+	Question        string `json:"question"`
+	MaxIterations   *int   `json:"max_iterations,omitempty"`
+	TopK            *int   `json:"top_k,omitempty"`
+	EnableWebSearch *bool  `json:"enable_web_search,omitempty"`
+	Model           string `json:"model,omitempty"`
+	ResponseFormat  string `json:"response_format,omitempty"`
+
+	// Preset names a query preset filling the parameters left unset
+	Preset string `json:"preset,omitempty"`
+
+	// CitationStyle is how the answer cites laws: inline (default),
+	// footnotes, endnotes or vn_formal
+	CitationStyle string `json:"citation_style,omitempty"`
+
+	Style *engine.AnswerStyle `json:"style,omitempty"`
+
+	// Language is the answer language, vi or en; Collection names the
+	// document collection to search and is forwarded to the engine
+	Language   string `json:"language,omitempty"`
+	Collection string `json:"collection,omitempty"`
+
+	// IterationPolicy overrides the mode of the server's iteration policy
+	IterationPolicy string `json:"iteration_policy,omitempty"`
+
+	// LatencyBudgetMs overrides how long the primary engine region may take
+	// before the secondary is tried
+	LatencyBudgetMs *int `json:"latency_budget_ms,omitempty"`
+
+	Attachments []AttachmentInput `json:"attachments,omitempty"`
+	ContextURLs []string          `json:"context_urls,omitempty"`
+
+	// Follow-up answering the clarifying questions of a pending query
+	PendingQueryID string `json:"pending_query_id,omitempty"`
+	Clarification  string `json:"clarification,omitempty"`
+
+	// ConversationID continues a conversation: its earlier answers are sent
+	// to the engine as history and the answer is added to it
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	// Explain asks for the breakdown of the search results' scores
+	Explain *ExplainOptions `json:"explain,omitempty"`
+
+	// namespace is the engine namespace of a private collection, set when
+	// the collection is checked
+	namespace string
+
+	// excludeCollections are the catalog collections the caller may not
+	// search, left out by the engine when the query names no collection
+	excludeCollections []string
+}
+
+// HealthResponse represents health check response
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Service string `json:"service"`
+	Version string `json:"version"`
+}
+
+// ErrorResponse represents error response
+type ErrorResponse struct {
+	Error   string    `json:"error"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+
+	// Violations lists the invalid fields of a rejected request body
+	Violations []Violation `json:"violations,omitempty"`
+
+	// Details are facts about the error a client can act on, e.g. the
+	// rejected parameter or the limit exceeded; the keys depend on the code
+	Details map[string]any `json:"details,omitempty"`
+
+	// RetryAfter is how many seconds to wait before retrying, also sent as
+	// the Retry-After header; omitted when there is no estimate
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// Handlers
+
+func healthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{
+		Status:  "healthy",
+		Service: "Legal RAG Backend API",
+		Version: "1.0.0",
+	})
+}
+
+// queryDeps are the collaborators of the query handlers
+type queryDeps struct {
+	engine           engine.QueryEngine
+	sandbox          engine.QueryEngine
+	attachments      *AttachmentStore
+	attachmentLimits AttachmentLimits
+	fetcher          *URLFetcher
+	pending          *PendingQueryStore
+	detectAmbiguous  bool
+	history          *HistoryStore
+	compare          []compareEngine
+	iterationPolicy  engine.IterationPolicy
+	stageBudgets     engine.StageBudgets
+	speculative      bool
+	speculation      *SpeculationStats
+	embeddings       *EmbeddingExperiment
+	routing          difficultyRouting
+	routingStats     *RoutingStats
+	postProcessors   *PostProcessorChain
+	lawLinks         *LinkResolver
+
+	taxonomy      *TaxonomyStore
+	preferences   *PreferenceStore
+	conversations *ConversationStore
+	summaries     *ConversationSummarizer
+	collections   *CollectionCatalog
+
+	// privateDocs holds the private collections, searched in their
+	// tenant's engine namespace
+	privateDocs *PrivateCollectionStore
+
+	// primaryRegion is the name of the primary engine region when
+	// failover is enabled
+	primaryRegion string
+
+	// slots limits the engine queries in flight when set
+	slots *slotLimiter
+
+	// explain holds the default weights of explained search results
+	explain ExplainConfig
+
+	// downgrade sets the deadlines of slow queries
+	downgrade DowngradeConfig
+
+	// reloader holds the server-wide query defaults, which reloads change
+	reloader *ConfigReloader
+
+	// priors weigh search results by how useful users found their source
+	priors *SourcePriors
+}
+
+// engineFor returns the sandbox engine for sandboxed requests, and marks
+// the queries of premium callers
+func (d queryDeps) engineFor(c *gin.Context) engine.QueryEngine {
+	if isSandboxRequest(c) {
+		return d.sandbox
+	}
+	if d.slots != nil && d.slots.isPremium(c) {
+		return premiumEngine{next: d.engine}
+	}
+	return d.engine
+}
+
+// queryEngine sends a query to the caller's engine. The engine request is
+// cancelled when the client closes the connection, so that the engine does
+// not keep generating an answer nobody reads.
+func (d queryDeps) queryEngine(c *gin.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return engine.QueryWithContext(c.Request.Context(), d.engineFor(c), req)
+}
+
+// engineRequest builds the engine request for a validated query, adding the
+// server-wide query defaults beneath the tenant's, the server-side iteration
+// policy, stage budgets, source priors, difficulty routing and query variants
+func (d queryDeps) engineRequest(req *LegalQueryRequest, defaults QueryDefaults, plan Plan) *engine.PythonQueryRequest {
+	defaults = defaults.withFallback(d.reloader.QueryDefaults())
+	pythonReq := buildPythonRequest(req, defaults, plan)
+	pythonReq.IterationPolicy = d.iterationPolicy.WithMode(req.IterationPolicy)
+	pythonReq.StageBudgets = d.stageBudgets
+	pythonReq.Deadlines = d.downgrade.deadlines()
+	if pythonReq.Namespace == "" {
+		// Engagement is measured on the shared corpus only
+		pythonReq.SourcePriors = d.priors.Weights()
+	}
+	d.routing.route(req, defaults, pythonReq)
+	if d.speculative {
+		if variant := rewriteQuery(pythonReq.Question); variant != "" {
+			pythonReq.QueryVariants = []string{variant}
+		}
+	}
+	return pythonReq
+}
+
+// finishAnswer links the laws an answer cites, rewrites its citations in
+// the requested style and grounds its sentences. Highlights point into the
+// answer text before the notes.
+func (d queryDeps) finishAnswer(ctx context.Context, resp *engine.LegalQueryResponse, citationStyle string) {
+	resp.Citations = d.lawLinks.Resolve(ctx, resp)
+	var notes []string
+	if !resp.NeedsClarification {
+		resp.Answer, notes = formatCitations(resp.Answer, answerCiteMarks(d.lawLinks, resp.Answer, resp.Citations), citationStyle)
+	}
+	resp.Highlights = groundAnswer(resp)
+	resp.Answer = appendNotes(resp.Answer, notes)
+}
+
+// record adds an answered query to the history. Failing to persist history
+// never fails the query.
+func (d queryDeps) record(tenantID, user string, req *engine.PythonQueryRequest, resp *engine.LegalQueryResponse, started time.Time, regeneratedFrom string) HistoryEntry {
+	entry, err := d.history.Add(HistoryEntry{
+		TenantID:        tenantID,
+		User:            user,
+		RegeneratedFrom: regeneratedFrom,
+		Question:        req.Question,
+		Parameters: QueryParameters{
+			MaxIterations:    req.MaxIterations,
+			TopK:             req.TopK,
+			EnableWebSearch:  req.EnableWebSearch,
+			Model:            req.Model,
+			ResponseFormat:   req.ResponseFormat,
+			CitationStyle:    req.CitationStyle,
+			Style:            req.Style,
+			Language:         req.Language,
+			Collection:       req.Collection,
+			IterationPolicy:  req.IterationPolicy.Mode,
+			ContextDocuments: len(req.ContextDocuments),
+		},
+		Response:   *resp,
+		DurationMs: time.Since(started).Milliseconds(),
+		Topics:     d.taxonomy.Tag(tenantID, req.Question),
+	})
+	if err != nil {
+		slog.Error("Failed to record history", "error", err)
+	}
+	return entry
+}
+
+// validateQuery validates a query against the caller's plan and clamps its
+// parameters. It writes the error response and returns false when the query
+// is invalid.
+func (d queryDeps) validateQuery(c *gin.Context, req *LegalQueryRequest, plan Plan) ([]engine.Warning, bool) {
+	violations := validateQueryRequest(req, plan)
+	violations = append(violations, validateAttachments(req.Attachments, d.attachmentLimits)...)
+	violations = append(violations, validateContextURLs(req.ContextURLs, d.fetcher.limits.MaxCount)...)
+	if len(violations) > 0 {
+		abortWithViolations(c, violations)
+		return nil, false
+	}
+	if !d.checkCollection(c, req) {
+		return nil, false
+	}
+
+	warnings := clampQueryRequest(req, plan)
+	for _, w := range warnings {
+		slog.InfoContext(c.Request.Context(), "Clamped request parameter", "client_ip", c.ClientIP(), "tenant", c.GetHeader("X-Tenant-ID"), "warning", w.Message)
+	}
+	return warnings, true
+}
+
+// resolveContext loads the attachments and fetches the context URLs of a
+// query. It writes the error response and returns false when an attachment
+// cannot be used.
+func (d queryDeps) resolveContext(c *gin.Context, req *LegalQueryRequest, tenantID string) ([]engine.ContextDocument, []engine.ContextURLError, bool) {
+	contextDocs, err := resolveAttachments(req.Attachments, d.attachments, tenantID)
+	if errors.Is(err, errAttachmentUnconfirmed) {
+		abortWithError(c, ErrCodeInvalidRequest, err.Error())
+		return nil, nil, false
+	}
+	if err != nil {
+		abortWithError(c, ErrCodeAttachmentNotFound, err.Error())
+		return nil, nil, false
+	}
+
+	var urlErrors []engine.ContextURLError
+	if len(req.ContextURLs) > 0 {
+		urlDocs, errs := d.fetcher.FetchAll(c.Request.Context(), req.ContextURLs)
+		contextDocs = append(contextDocs, urlDocs...)
+		urlErrors = errs
+	}
+	return contextDocs, urlErrors, true
+}
+
+func legalQueryHandler(deps queryDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if resp, ok := deps.answer(c, nil); ok {
+			c.JSON(http.StatusOK, resp)
+		}
+	}
+}
+
+// answer runs the legal query in the request body through validation, the
+// engine and post-processing, and records it. onEvent, when set, streams the
+// engine's intermediate events. It writes the error response and returns
+// false when the query fails.
+func (d queryDeps) answer(c *gin.Context, onEvent func(engine.StreamEvent)) (*engine.LegalQueryResponse, bool) {
+	var req LegalQueryRequest
+
+	// Bind JSON request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+		return nil, false
+	}
+	return d.answerQuery(c, &req, nil, onEvent)
+}
+
+// answerQuery answers a bound legal query like answer. history holds the
+// earlier turns of a chat session, or of the conversation named by the
+// request; its questions are follow-ups, so they are never held for
+// clarification. req is updated to the question that was answered, e.g.
+// with its clarification.
+func (d queryDeps) answerQuery(c *gin.Context, req *LegalQueryRequest, history []engine.ChatTurn, onEvent func(engine.StreamEvent)) (*engine.LegalQueryResponse, bool) {
+	started := time.Now()
+
+	// A follow-up continues the pending query it clarifies
+	tenant, _ := callerTenant(c)
+	plan := callerPlan(c)
+	followUp := req.PendingQueryID != ""
+	var presetWarnings []engine.Warning
+	if followUp {
+		if strings.TrimSpace(req.Clarification) == "" {
+			abortWithError(c, ErrCodeInvalidRequest, "clarification must not be empty when pending_query_id is set")
+			return nil, false
+		}
+		p, err := d.pending.Take(req.PendingQueryID, tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodePendingQueryNotFound, fmt.Sprintf("Pending query %q not found or expired", req.PendingQueryID))
+			return nil, false
+		}
+		applyClarification(req, p)
+	} else {
+		if req.Preset != "" {
+			preset, ok := lookupPreset(tenant, req.Preset)
+			if !ok {
+				abortWithError(c, ErrCodePresetNotFound, fmt.Sprintf("Unknown preset %q", req.Preset))
+				return nil, false
+			}
+			presetWarnings = preset.apply(req, plan)
+		}
+		d.preferences.Get(tenant.ID, callerUser(c)).apply(req)
+	}
+
+	warnings, ok := d.validateQuery(c, req, plan)
+	if !ok {
+		return nil, false
+	}
+	warnings = append(presetWarnings, warnings...)
+
+	var summary string
+	if req.ConversationID != "" {
+		s, turns, err := d.conversations.Context(req.ConversationID, tenant.ID, callerUser(c))
+		if err != nil {
+			abortWithError(c, ErrCodeConversationNotFound, fmt.Sprintf("Conversation %q not found", req.ConversationID))
+			return nil, false
+		}
+		summary, history = s, turns
+	}
+
+	slog.InfoContext(c.Request.Context(), "Received query", "question", req.Question)
+
+	if d.detectAmbiguous && !followUp && len(history) == 0 && summary == "" {
+		if questions := detectAmbiguity(req.Question); questions != nil {
+			p := d.pending.Put(tenant.ID, *req)
+			slog.InfoContext(c.Request.Context(), "Query needs clarification", "pending_query_id", p.ID)
+			return &engine.LegalQueryResponse{
+				SearchResults:       []engine.DocumentHit{},
+				WebResults:          []engine.WebHit{},
+				NeedsClarification:  true,
+				ClarifyingQuestions: questions,
+				PendingQueryID:      p.ID,
+				Warnings:            warnings,
+				Meta:                &engine.ResponseMeta{Features: []string{FeatureClarificationRequested}},
+			}, true
+		}
+	}
+
+	contextDocs, urlErrors, ok := d.resolveContext(c, req, tenant.ID)
+	if !ok {
+		return nil, false
+	}
+
+	pythonReq := d.engineRequest(req, tenant.Settings.Defaults, plan)
+	pythonReq.ContextDocuments = contextDocs
+	pythonReq.History = history
+	pythonReq.ConversationSummary = summary
+	pythonReq.OnEvent = onEvent
+
+	// Call Python AI Engine, or the canned sandbox engine
+	engineStarted := time.Now()
+	resp, err := d.queryEngine(c, pythonReq)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			slog.InfoContext(c.Request.Context(), "Client closed the connection, engine request cancelled", "after", time.Since(engineStarted).Round(time.Millisecond).String())
+		} else {
+			slog.ErrorContext(c.Request.Context(), "Error calling Python AI Engine", "error", err)
+		}
+		abortWithEngineError(c, err, fmt.Sprintf("Failed to process query: %v", err))
+		return nil, false
+	}
+	d.routingStats.Record(pythonReq, resp, time.Since(engineStarted))
+
+	slog.InfoContext(c.Request.Context(), "Query completed", "iterations", resp.Iterations, "policy", pythonReq.IterationPolicy.Mode, "stopped", resp.StoppedReason,
+		"internal_results", len(resp.SearchResults), "web_results", len(resp.WebResults))
+	if resp.Downgrade != nil && !resp.Cached {
+		slog.InfoContext(c.Request.Context(), "Query downgraded", "after_ms", resp.Downgrade.AfterMs, "iterations", resp.Downgrade.Iterations, "model", resp.Downgrade.Model)
+	}
+
+	if resp.Speculation != nil && !resp.Cached {
+		slog.InfoContext(c.Request.Context(), "Speculative retrieval", "winner", resp.Speculation.Winner)
+		d.speculation.Record(resp.Speculation)
+	}
+	if !resp.Cached && !isSandboxRequest(c) {
+		d.embeddings.Start(c, pythonReq)
+	}
+
+	resp.Meta = newResponseMeta(pythonReq, resp, d.primaryRegion, followUp)
+	postWarnings, err := d.postProcessors.Run(c.Request.Context(), &PostProcessInput{Question: req.Question, TenantID: tenant.ID, Response: resp})
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Post-processing rejected the answer", "error", err)
+		abortWithError(c, postProcessErrorCode(err), err.Error())
+		return nil, false
+	}
+
+	d.finishAnswer(c.Request.Context(), resp, pythonReq.CitationStyle)
+	if req.Explain != nil {
+		resp.Explanations = explainResults(req.Question, resp.SearchResults, req.Explain.weights(d.explain), d.lawLinks, time.Now())
+	}
+	resp.Warnings = append(warnings, postWarnings...)
+	resp.ContextURLErrors = urlErrors
+
+	// The engine may also ask for clarification; only answers are recorded
+	if resp.NeedsClarification {
+		resp.PendingQueryID = d.pending.Put(tenant.ID, *req).ID
+	} else {
+		resp.HistoryID = d.record(tenant.ID, callerUser(c), pythonReq, resp, started, "").ID
+		if req.ConversationID != "" {
+			m := ConversationMessage{Question: req.Question, Answer: resp.Answer, HistoryID: resp.HistoryID}
+			if err := d.conversations.Append(req.ConversationID, tenant.ID, callerUser(c), m); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to add the answer to the conversation", "conversation_id", req.ConversationID, "error", err)
+			}
+			d.summaries.Start(c.Request.Context(), req.ConversationID)
+		}
+	}
+
+	return resp, true
+}
+
+// buildPythonRequest fills parameters the client omitted, first from the
+// tenant defaults and then from the built-in defaults, never exceeding what
+// the caller's plan allows
+func buildPythonRequest(req *LegalQueryRequest, defaults QueryDefaults, plan Plan) *engine.PythonQueryRequest {
+	maxIterations := min(3, plan.MaxIterations)
+	if defaults.MaxIterations != nil {
+		maxIterations = min(*defaults.MaxIterations, plan.MaxIterations)
+	}
+	if req.MaxIterations != nil {
+		maxIterations = *req.MaxIterations
+	}
+
+	topK := min(3, plan.MaxTopK)
+	if defaults.TopK != nil {
+		topK = min(*defaults.TopK, plan.MaxTopK)
+	}
+	if req.TopK != nil {
+		topK = *req.TopK
+	}
+
+	enableWebSearch := plan.WebSearch
+	if defaults.EnableWebSearch != nil {
+		enableWebSearch = *defaults.EnableWebSearch && plan.WebSearch
+	}
+	if req.EnableWebSearch != nil {
+		enableWebSearch = *req.EnableWebSearch
+	}
+
+	model := defaults.Model
+	if req.Model != "" {
+		model = req.Model
+	}
+
+	responseFormat := defaults.ResponseFormat
+	if req.ResponseFormat != "" {
+		responseFormat = req.ResponseFormat
+	}
+
+	style := resolveAnswerStyle(req.Style)
+
+	var latencyBudget time.Duration
+	if req.LatencyBudgetMs != nil {
+		latencyBudget = time.Duration(*req.LatencyBudgetMs) * time.Millisecond
+	}
+
+	return &engine.PythonQueryRequest{
+		Question:           req.Question,
+		MaxIterations:      maxIterations,
+		TopK:               topK,
+		EnableWebSearch:    enableWebSearch,
+		Model:              model,
+		ResponseFormat:     responseFormat,
+		CitationStyle:      req.CitationStyle,
+		Style:              style,
+		StyleInstructions:  styleInstructions(style, req.Language),
+		Language:           req.Language,
+		Collection:         req.Collection,
+		Namespace:          req.namespace,
+		ExcludeCollections: req.excludeCollections,
+		LatencyBudget:      latencyBudget,
+	}
+}
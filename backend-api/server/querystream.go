@@ -0,0 +1,136 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// CitationsEvent lists the sources of an answer as soon as retrieval
+// completes. Sources and WebSources are in the order of the answer's
+// search_results and web_results.
+type CitationsEvent struct {
+	Sources    []BinderSource `json:"sources"`
+	WebSources []BinderSource `json:"web_sources"`
+}
+
+func citationsFrom(searchResults []engine.DocumentHit, webResults []engine.WebHit) CitationsEvent {
+	event := CitationsEvent{Sources: []BinderSource{}, WebSources: []BinderSource{}}
+	for _, r := range searchResults {
+		event.Sources = append(event.Sources, sourceFromResult(r))
+	}
+	for _, r := range webResults {
+		event.WebSources = append(event.WebSources, sourceFromWebResult(r))
+	}
+	return event
+}
+
+// TokenEvent is the next piece of the answer as the engine generates it
+type TokenEvent struct {
+	Text string `json:"text"`
+}
+
+// exclusiveStream returns a function giving each concurrent attempt of a
+// streamed query, e.g. a hedge or a failover, its own copy of req. Only the
+// attempt that sends the first event is forwarded, so the citations and
+// answer tokens of duplicates never interleave.
+func exclusiveStream(req *engine.PythonQueryRequest) func() *engine.PythonQueryRequest {
+	if req.OnEvent == nil {
+		return func() *engine.PythonQueryRequest { return req }
+	}
+	var mu sync.Mutex
+	attempts, owner := 0, 0
+	return func() *engine.PythonQueryRequest {
+		mu.Lock()
+		attempts++
+		attempt := attempts
+		mu.Unlock()
+
+		copied := *req
+		copied.OnEvent = func(event engine.StreamEvent) {
+			mu.Lock()
+			if owner == 0 {
+				owner = attempt
+			}
+			forward := owner == attempt
+			mu.Unlock()
+			if forward {
+				req.OnEvent(event)
+			}
+		}
+		return &copied
+	}
+}
+
+// answerStream forwards the engine's retrieval events, and its answer
+// tokens when tokens is set, to the client. The engine may call it from
+// other goroutines, e.g. a hedged request, so events arriving after the
+// query returned are dropped.
+type answerStream struct {
+	c      *gin.Context
+	tokens bool
+	mu     sync.Mutex
+	sent   bool
+	closed bool
+}
+
+func (s *answerStream) onEvent(event engine.StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	switch {
+	case event.Type == engine.EventRetrieval && !s.sent:
+		s.c.Header("Cache-Control", "no-cache")
+		s.c.Header("X-Accel-Buffering", "no")
+		s.sent = true
+		s.c.SSEvent("citations", citationsFrom(event.SearchResults, event.WebResults))
+	case event.Type == engine.EventToken && s.sent && s.tokens:
+		s.c.SSEvent("token", TokenEvent{Text: event.Text})
+	default:
+		return
+	}
+	s.c.Writer.Flush()
+}
+
+// close stops forwarding events and reports whether citations were sent
+func (s *answerStream) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.sent
+}
+
+// Handlers
+
+// streamLegalQueryHandler answers a legal query as server-sent events: a
+// citations event once retrieval completes, token events as the answer is
+// generated, then the answer event. Tokens are not streamed when
+// post-processors are configured, since they may rewrite or refuse the
+// answer. Errors before the stream starts are plain error responses; later
+// ones end the stream with an error event.
+func streamLegalQueryHandler(deps queryDeps) gin.HandlerFunc {
+	tokens := len(deps.postProcessors.Names()) == 0
+	return func(c *gin.Context) {
+		stream := &answerStream{c: c, tokens: tokens}
+		resp, ok := deps.answer(c, stream.onEvent)
+		sent := stream.close()
+		if !ok {
+			return
+		}
+
+		// Cached and sandboxed answers come without retrieval events
+		if !sent {
+			c.Header("Cache-Control", "no-cache")
+			c.Header("X-Accel-Buffering", "no")
+			if !resp.NeedsClarification {
+				c.SSEvent("citations", citationsFrom(resp.SearchResults, resp.WebResults))
+			}
+		}
+		c.SSEvent("answer", resp)
+		c.Writer.Flush()
+	}
+}
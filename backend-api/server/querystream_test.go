@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// streamingEngine reports the stub's search results as a retrieval event and
+// its answer in two tokens before answering, like PythonClient with a
+// streaming engine
+type streamingEngine struct {
+	stubEngine
+	failAfterRetrieval bool
+}
+
+func (e *streamingEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	if req.OnEvent != nil {
+		req.OnEvent(engine.StreamEvent{Type: engine.EventRetrieval, SearchResults: e.resp.SearchResults})
+	}
+	if e.failAfterRetrieval {
+		return nil, &engine.EngineStatusError{StatusCode: http.StatusInternalServerError, Body: "generation failed"}
+	}
+	if req.OnEvent != nil {
+		half := len(e.resp.Answer) / 2
+		req.OnEvent(engine.StreamEvent{Type: engine.EventToken, Text: e.resp.Answer[:half]})
+		req.OnEvent(engine.StreamEvent{Type: engine.EventToken, Text: e.resp.Answer[half:]})
+	}
+	return e.stubEngine.Query(req)
+}
+
+func TestStreamLegalQuery(t *testing.T) {
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	resp := engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"}},
+		Iterations:    1,
+	}
+	question := LegalQueryRequest{Question: "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"}
+
+	// events returns the event names of a server-sent event stream, in order
+	events := func(body string) []string {
+		var names []string
+		for _, line := range strings.Split(body, "\n") {
+			if name, ok := strings.CutPrefix(line, "event:"); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	for _, tt := range []struct {
+		name           string
+		engine         engine.QueryEngine
+		postProcessors string
+		want           string
+	}{
+		{"streaming engine", &streamingEngine{stubEngine: stubEngine{resp: resp}}, "", "citations,token,token,answer"},
+		{"post-processed answer", &streamingEngine{stubEngine: stubEngine{resp: resp}}, "disclaimer", "citations,answer"},
+		{"engine without events", &stubEngine{resp: resp}, "", "citations,answer"},
+	} {
+		t.Setenv("POST_PROCESSORS", tt.postProcessors)
+		h := newTestServer(t, Options{Engine: tt.engine}).Handler()
+		rec := doJSON(t, h, http.MethodPost, "/api/legal-query/stream", question)
+		if got := events(rec.Body.String()); strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: events = %v, want %s", tt.name, got, tt.want)
+		}
+		if !strings.Contains(rec.Body.String(), `"title":"Thời gian thử việc"`) || !strings.Contains(rec.Body.String(), `"history_id":"`) {
+			t.Errorf("%s: stream = %s, want the source and the recorded answer", tt.name, rec.Body.String())
+		}
+	}
+
+	// An engine failure after the citations ends the stream with an error
+	t.Setenv("POST_PROCESSORS", "")
+	h := newTestServer(t, Options{Engine: &streamingEngine{stubEngine: stubEngine{resp: resp}, failAfterRetrieval: true}}).Handler()
+	rec := doJSON(t, h, http.MethodPost, "/api/legal-query/stream", question)
+	if got := events(rec.Body.String()); strings.Join(got, ",") != "citations,error" || !strings.Contains(rec.Body.String(), string(ErrCodeEngineError)) {
+		t.Errorf("failed stream = %s, want citations then an ENGINE_ERROR event", rec.Body.String())
+	}
+
+	// Errors before the stream starts are plain error responses
+	h = newTestServer(t, Options{Engine: &stubEngine{err: errors.New("unreachable")}}).Handler()
+	rec = doJSON(t, h, http.MethodPost, "/api/legal-query/stream", LegalQueryRequest{})
+	if code := decodeError(t, rec).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("empty question = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+}
+
+func TestExclusiveStream(t *testing.T) {
+	var got []string
+	req := &engine.PythonQueryRequest{Question: "q", OnEvent: func(e engine.StreamEvent) { got = append(got, e.Text) }}
+	attempt := exclusiveStream(req)
+	first, hedge := attempt(), attempt()
+
+	// The hedge streams first, so the original's events are dropped
+	hedge.OnEvent(engine.StreamEvent{Type: engine.EventRetrieval, Text: "hedge"})
+	first.OnEvent(engine.StreamEvent{Type: engine.EventRetrieval, Text: "first"})
+	first.OnEvent(engine.StreamEvent{Type: engine.EventToken, Text: "first"})
+	hedge.OnEvent(engine.StreamEvent{Type: engine.EventToken, Text: "hedge"})
+	if strings.Join(got, ",") != "hedge,hedge" {
+		t.Errorf("forwarded events = %v, want only the hedge's", got)
+	}
+
+	plain := &engine.PythonQueryRequest{Question: "q"}
+	if exclusiveStream(plain)() != plain {
+		t.Error("exclusiveStream copied a request that does not stream")
+	}
+}
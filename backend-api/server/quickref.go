@@ -0,0 +1,474 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Quick reference statuses. Drafts are only visible to admins.
+const (
+	QuickRefDraft     = "draft"
+	QuickRefPublished = "published"
+)
+
+const (
+	maxQuickRefItems = 30
+
+	// quickRefMaxAge is how long clients may cache a quick reference
+	quickRefMaxAge = 5 * time.Minute
+)
+
+// QuickRef is a curated summary of a taxonomy topic: the key articles, the
+// thresholds and the deadlines a lawyer looks up most
+type QuickRef struct {
+	Topic       string            `json:"topic"`
+	TenantID    string            `json:"tenant_id,omitempty"`
+	Title       string            `json:"title"`
+	Summary     string            `json:"summary,omitempty"`
+	KeyArticles []QuickRefArticle `json:"key_articles"`
+	Thresholds  []QuickRefFact    `json:"thresholds"`
+	Deadlines   []QuickRefFact    `json:"deadlines"`
+
+	Status string `json:"status"`
+
+	// Drafted marks a reference drafted by the engine; Sources are the
+	// search results it was drafted from, for the reviewer
+	Drafted    bool           `json:"drafted,omitempty"`
+	Sources    []BinderSource `json:"sources,omitempty"`
+	ApprovedBy string         `json:"approved_by,omitempty"`
+	Version    int            `json:"version"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// QuickRefArticle is a key article of a topic
+type QuickRefArticle struct {
+	Citation string `json:"citation"`
+	Title    string `json:"title,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// QuickRefFact is a threshold or deadline with the article it comes from
+type QuickRefFact struct {
+	Label    string `json:"label"`
+	Value    string `json:"value"`
+	Citation string `json:"citation,omitempty"`
+}
+
+// etag identifies a version of the reference for conditional requests
+func (q QuickRef) etag() string {
+	return fmt.Sprintf(`"%s-%s-%d"`, q.TenantID, q.Topic, q.Version)
+}
+
+var errQuickRefNotFound = errors.New("quick reference not found")
+
+// QuickRefStore keeps the quick references of the default taxonomy ("") and
+// of tenants, persisted to a JSON file when a path is configured
+type QuickRefStore struct {
+	mu   sync.RWMutex
+	path string
+	refs map[string]*QuickRef
+}
+
+func NewQuickRefStore(path string) (*QuickRefStore, error) {
+	store := &QuickRefStore{
+		path: path,
+		refs: make(map[string]*QuickRef),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var refs []*QuickRef
+	if _, err := readJSONFile(path, &refs); err != nil {
+		return nil, fmt.Errorf("failed to load quick references: %w", err)
+	}
+	for _, q := range refs {
+		store.refs[quickRefKey(q.TenantID, q.Topic)] = q
+	}
+	return store, nil
+}
+
+func quickRefKey(tenantID, topic string) string {
+	return tenantID + "\x00" + topic
+}
+
+// Get returns the reference stored for a tenant ("" for the default)
+func (s *QuickRefStore) Get(tenantID, topic string) (QuickRef, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if q, ok := s.refs[quickRefKey(tenantID, topic)]; ok {
+		return *q, nil
+	}
+	return QuickRef{}, errQuickRefNotFound
+}
+
+// Published returns the published reference a tenant sees: its own, or
+// else the default one
+func (s *QuickRefStore) Published(tenantID, topic string) (QuickRef, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, id := range []string{tenantID, ""} {
+		if q, ok := s.refs[quickRefKey(id, topic)]; ok && q.Status == QuickRefPublished {
+			return *q, nil
+		}
+		if id == "" {
+			break
+		}
+	}
+	return QuickRef{}, errQuickRefNotFound
+}
+
+// List returns the references of a tenant ("" for the default), by topic.
+// With inherited set, published default references the tenant does not
+// override are included.
+func (s *QuickRefStore) List(tenantID string, publishedOnly, inherited bool) []QuickRef {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byTopic := make(map[string]QuickRef)
+	for _, q := range s.refs {
+		if publishedOnly && q.Status != QuickRefPublished {
+			continue
+		}
+		if q.TenantID == tenantID || (inherited && q.TenantID == "") {
+			if _, ok := byTopic[q.Topic]; !ok || q.TenantID == tenantID {
+				byTopic[q.Topic] = *q
+			}
+		}
+	}
+	refs := make([]QuickRef, 0, len(byTopic))
+	for _, q := range byTopic {
+		refs = append(refs, q)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Topic < refs[j].Topic })
+	return refs
+}
+
+// Put stores a reference, replacing the current version
+func (s *QuickRefStore) Put(q QuickRef) (QuickRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := quickRefKey(q.TenantID, q.Topic)
+	q.Version = 1
+	if current, ok := s.refs[key]; ok {
+		q.Version = current.Version + 1
+	}
+	q.UpdatedAt = time.Now().UTC()
+	s.refs[key] = &q
+	return q, s.saveLocked()
+}
+
+func (s *QuickRefStore) Delete(tenantID, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := quickRefKey(tenantID, topic)
+	if _, ok := s.refs[key]; !ok {
+		return errQuickRefNotFound
+	}
+	delete(s.refs, key)
+	return s.saveLocked()
+}
+
+func (s *QuickRefStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	refs := make([]*QuickRef, 0, len(s.refs))
+	for _, q := range s.refs {
+		refs = append(refs, q)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return quickRefKey(refs[i].TenantID, refs[i].Topic) < quickRefKey(refs[j].TenantID, refs[j].Topic)
+	})
+	return writeJSONFile(s.path, refs)
+}
+
+// validateQuickRef trims a reference and checks its content
+func validateQuickRef(q *QuickRef) error {
+	q.Title = strings.TrimSpace(q.Title)
+	if q.Title == "" {
+		return errors.New("title must not be empty")
+	}
+	if q.KeyArticles == nil {
+		q.KeyArticles = []QuickRefArticle{}
+	}
+	if q.Thresholds == nil {
+		q.Thresholds = []QuickRefFact{}
+	}
+	if q.Deadlines == nil {
+		q.Deadlines = []QuickRefFact{}
+	}
+	if len(q.KeyArticles) > maxQuickRefItems || len(q.Thresholds) > maxQuickRefItems || len(q.Deadlines) > maxQuickRefItems {
+		return fmt.Errorf("key_articles, thresholds and deadlines may have at most %d entries each", maxQuickRefItems)
+	}
+	for _, a := range q.KeyArticles {
+		if strings.TrimSpace(a.Citation) == "" {
+			return errors.New("every key article needs a citation")
+		}
+	}
+	for _, f := range append(append([]QuickRefFact(nil), q.Thresholds...), q.Deadlines...) {
+		if strings.TrimSpace(f.Label) == "" || strings.TrimSpace(f.Value) == "" {
+			return errors.New("every threshold and deadline needs a label and a value")
+		}
+	}
+	switch q.Status {
+	case "":
+		q.Status = QuickRefDraft
+	case QuickRefDraft, QuickRefPublished:
+	default:
+		return fmt.Errorf("status must be %s or %s", QuickRefDraft, QuickRefPublished)
+	}
+	return nil
+}
+
+// quickRefEngineRequest asks the engine to draft a reference for a topic as
+// a JSON object
+func quickRefEngineRequest(topic Topic, plan Plan) *engine.PythonQueryRequest {
+	prompt := fmt.Sprintf("Lập bảng tra cứu nhanh về chủ đề %q (từ khóa: %s). "+
+		"Chỉ trả về một đối tượng JSON, không kèm giải thích, với các trường: "+
+		`"summary" (tóm tắt 2-3 câu), `+
+		`"key_articles" (danh sách {"citation": "Điều ..., <văn bản>", "title", "summary"}), `+
+		`"thresholds" (các mức, tỷ lệ, giới hạn: {"label", "value", "citation"}), `+
+		`"deadlines" (các thời hạn: {"label", "value", "citation"}). `+
+		"Chỉ dùng các điều luật tìm được và ghi rõ điều luật cho từng mục.",
+		topic.Name, strings.Join(topic.Keywords, ", "))
+	req := buildPythonRequest(&LegalQueryRequest{Question: prompt}, QueryDefaults{}, plan)
+	req.EnableWebSearch = false
+	return req
+}
+
+// parseQuickRefDraft reads the JSON object of an engine draft, which may be
+// wrapped in a code fence or text
+func parseQuickRefDraft(answer string) (QuickRef, error) {
+	start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+	if start < 0 || end < start {
+		return QuickRef{}, errors.New("the draft holds no JSON object")
+	}
+	var draft QuickRef
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &draft); err != nil {
+		return QuickRef{}, fmt.Errorf("the draft is not valid JSON: %w", err)
+	}
+	return draft, nil
+}
+
+// Handlers
+
+func listQuickRefsHandler(store *QuickRefStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		type summary struct {
+			Topic     string    `json:"topic"`
+			Title     string    `json:"title"`
+			UpdatedAt time.Time `json:"updated_at"`
+		}
+		summaries := []summary{}
+		for _, q := range store.List(tenant.ID, true, true) {
+			summaries = append(summaries, summary{q.Topic, q.Title, q.UpdatedAt})
+		}
+		c.JSON(http.StatusOK, gin.H{"quickrefs": summaries})
+	}
+}
+
+// getQuickRefHandler serves a published reference. References change
+// rarely, so clients may cache them and revalidate with If-None-Match.
+func getQuickRefHandler(store *QuickRefStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		q, err := store.Published(tenant.ID, c.Param("topic"))
+		if err != nil {
+			abortWithError(c, ErrCodeQuickRefNotFound, fmt.Sprintf("No quick reference for topic %q", c.Param("topic")))
+			return
+		}
+		c.Header("ETag", q.etag())
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(quickRefMaxAge.Seconds())))
+		if c.GetHeader("If-None-Match") == q.etag() {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		q.Sources = nil
+		c.JSON(http.StatusOK, q)
+	}
+}
+
+func adminListQuickRefsHandler(store *QuickRefStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"quickrefs": store.List(tenantID, false, false)})
+	}
+}
+
+// quickRefTopic returns the taxonomy topic named in the URL
+func quickRefTopic(c *gin.Context, taxonomy *TaxonomyStore, tenantID string) (Topic, bool) {
+	topics := taxonomy.Topics(tenantID)
+	i := findTopic(topics, c.Param("topic"))
+	if i < 0 {
+		abortWithError(c, ErrCodeTopicNotFound, fmt.Sprintf("Topic %q not found", c.Param("topic")))
+		return Topic{}, false
+	}
+	return topics[i], true
+}
+
+func adminGetQuickRefHandler(store *QuickRefStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		q, err := store.Get(tenantID, c.Param("topic"))
+		if err != nil {
+			abortWithError(c, ErrCodeQuickRefNotFound, fmt.Sprintf("No quick reference for topic %q", c.Param("topic")))
+			return
+		}
+		c.JSON(http.StatusOK, q)
+	}
+}
+
+func putQuickRefHandler(store *QuickRefStore, taxonomy *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		topic, ok := quickRefTopic(c, taxonomy, tenantID)
+		if !ok {
+			return
+		}
+		var q QuickRef
+		if err := c.ShouldBindJSON(&q); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if err := validateQuickRef(&q); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		// A human edit replaces the engine draft
+		q.Topic, q.TenantID, q.Drafted, q.Sources = topic.ID, tenantID, false, nil
+		q.ApprovedBy = ""
+		if q.Status == QuickRefPublished {
+			if q.ApprovedBy, ok = requireAuthenticatedUser(c, "Publishing a quick reference"); !ok {
+				return
+			}
+		}
+		saved, err := store.Put(q)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save quick references", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save quick reference")
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+}
+
+func deleteQuickRefHandler(store *QuickRefStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		err := store.Delete(tenantID, c.Param("topic"))
+		if errors.Is(err, errQuickRefNotFound) {
+			abortWithError(c, ErrCodeQuickRefNotFound, fmt.Sprintf("No quick reference for topic %q", c.Param("topic")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save quick references", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to delete quick reference")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// draftQuickRefHandler has the engine draft a reference for a topic. The
+// draft replaces the stored reference only while it is unpublished; a
+// published reference keeps serving until the draft is approved.
+func draftQuickRefHandler(deps queryDeps, store *QuickRefStore, taxonomy *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		topic, ok := quickRefTopic(c, taxonomy, tenantID)
+		if !ok {
+			return
+		}
+
+		resp, err := deps.queryEngine(c, quickRefEngineRequest(topic, plans[defaultPlanName]))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error calling Python AI Engine", "error", err)
+			abortWithEngineError(c, err, fmt.Sprintf("Failed to draft quick reference: %v", err))
+			return
+		}
+		draft, err := parseQuickRefDraft(resp.Answer)
+		if err != nil {
+			abortWithError(c, ErrCodeEngineError, fmt.Sprintf("Failed to draft quick reference: %v", err))
+			return
+		}
+		draft.Title = topic.Name
+		draft.Status = QuickRefDraft
+		if err := validateQuickRef(&draft); err != nil {
+			abortWithError(c, ErrCodeEngineError, fmt.Sprintf("Failed to draft quick reference: %v", err))
+			return
+		}
+		draft.Topic, draft.TenantID, draft.Drafted = topic.ID, tenantID, true
+		for _, r := range resp.SearchResults {
+			draft.Sources = append(draft.Sources, sourceFromResult(r))
+		}
+
+		if current, err := store.Get(tenantID, topic.ID); err == nil && current.Status == QuickRefPublished {
+			c.JSON(http.StatusOK, gin.H{"draft": draft, "saved": false})
+			return
+		}
+		saved, err := store.Put(draft)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save quick references", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save quick reference")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"draft": saved, "saved": true})
+	}
+}
+
+// approveQuickRefHandler publishes a draft after human review
+func approveQuickRefHandler(store *QuickRefStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		q, err := store.Get(tenantID, c.Param("topic"))
+		if err != nil {
+			abortWithError(c, ErrCodeQuickRefNotFound, fmt.Sprintf("No quick reference for topic %q", c.Param("topic")))
+			return
+		}
+		if q.Status == QuickRefPublished {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Quick reference for topic %q is already published", q.Topic))
+			return
+		}
+		q.Status = QuickRefPublished
+		if q.ApprovedBy, ok = requireAuthenticatedUser(c, "Approving a quick reference"); !ok {
+			return
+		}
+		saved, err := store.Put(q)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save quick references", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save quick reference")
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+}
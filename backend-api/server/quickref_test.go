@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestQuickRef(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer: "```json\n" + `{"summary": "Thử việc tối đa 180 ngày.",
+			"key_articles": [{"citation": "Điều 25, Bộ luật Lao động 2019", "title": "Thời gian thử việc"}],
+			"thresholds": [{"label": "Lương thử việc", "value": "ít nhất 85%", "citation": "Điều 26"}],
+			"deadlines": [{"label": "Thử việc trình độ cao đẳng", "value": "60 ngày", "citation": "Điều 25"}]}` + "\n```",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"}},
+		Iterations:    1,
+	}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+	minh := userKey(t, h, "minh")
+
+	admin := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "admin-token")
+		req.Header.Set("X-API-Key", minh)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := admin(http.MethodPut, "/admin/quickref/khong-co", QuickRef{Title: "?"})
+	if code := decodeError(t, rec).Code; code != ErrCodeTopicNotFound {
+		t.Errorf("quick reference for an unknown topic = %s, want %s", code, ErrCodeTopicNotFound)
+	}
+
+	// The engine drafts, a human approves
+	rec = admin(http.MethodPost, "/admin/quickref/hop-dong-lao-dong/draft", nil)
+	var drafted struct {
+		Draft QuickRef `json:"draft"`
+		Saved bool     `json:"saved"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &drafted); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("draft = %d %s", rec.Code, rec.Body.String())
+	}
+	if !drafted.Saved || !drafted.Draft.Drafted || len(drafted.Draft.Deadlines) != 1 || len(drafted.Draft.Sources) != 1 {
+		t.Fatalf("draft = %+v, want a saved engine draft with its sources", drafted)
+	}
+	if got := stub.requests[len(stub.requests)-1]; got.EnableWebSearch || !strings.Contains(got.Question, "Hợp đồng lao động") {
+		t.Errorf("draft request = %+v, want the topic without web search", got)
+	}
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/quickref/hop-dong-lao-dong", nil)
+	if code := decodeError(t, rec).Code; code != ErrCodeQuickRefNotFound {
+		t.Errorf("unapproved draft = %s, want %s", code, ErrCodeQuickRefNotFound)
+	}
+
+	// The approver is the user of an API key, not whoever X-User-ID names
+	spoofed := httptest.NewRequest(http.MethodPost, "/admin/quickref/hop-dong-lao-dong/approve", nil)
+	spoofed.Header.Set("X-Admin-Token", "admin-token")
+	spoofed.Header.Set("X-User-ID", "minh")
+	rec = httptest.NewRecorder()
+	if h.ServeHTTP(rec, spoofed); rec.Code != http.StatusForbidden {
+		t.Errorf("approval claimed for minh in X-User-ID = %d, want 403", rec.Code)
+	}
+	if rec = admin(http.MethodPost, "/admin/quickref/hop-dong-lao-dong/approve", nil); rec.Code != http.StatusOK {
+		t.Fatalf("approve = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/quickref/hop-dong-lao-dong", nil)
+	var published QuickRef
+	if err := json.Unmarshal(rec.Body.Bytes(), &published); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("quick reference = %d %s", rec.Code, rec.Body.String())
+	}
+	if published.ApprovedBy != "minh" || published.Thresholds[0].Value != "ít nhất 85%" {
+		t.Errorf("quick reference = %+v, want the approved draft", published)
+	}
+
+	// Clients revalidate with the ETag
+	req := httptest.NewRequest(http.MethodGet, "/api/quickref/hop-dong-lao-dong", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	cached := httptest.NewRecorder()
+	h.ServeHTTP(cached, req)
+	if cached.Code != http.StatusNotModified {
+		t.Errorf("revalidation = %d, want %d", cached.Code, http.StatusNotModified)
+	}
+
+	// A human edit bumps the version and replaces the draft
+	edit := published
+	edit.Deadlines = append(edit.Deadlines, QuickRefFact{Label: "Thử việc quản lý doanh nghiệp", Value: "180 ngày", Citation: "Điều 25"})
+	if rec = admin(http.MethodPut, "/admin/quickref/hop-dong-lao-dong", edit); rec.Code != http.StatusOK {
+		t.Fatalf("edit = %d %s", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/quickref/hop-dong-lao-dong", nil)
+	req.Header.Set("If-None-Match", cached.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	published = QuickRef{}
+	json.Unmarshal(rec.Body.Bytes(), &published)
+	if rec.Code != http.StatusOK || published.Drafted || len(published.Deadlines) != 2 {
+		t.Errorf("edited quick reference = %d %+v, want the edit", rec.Code, published)
+	}
+
+	var listed struct {
+		QuickRefs []struct {
+			Topic string `json:"topic"`
+		} `json:"quickrefs"`
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/quickref", nil).Body.Bytes(), &listed)
+	if len(listed.QuickRefs) != 1 || listed.QuickRefs[0].Topic != "hop-dong-lao-dong" {
+		t.Errorf("quick references = %+v, want hop-dong-lao-dong", listed.QuickRefs)
+	}
+
+	if rec = admin(http.MethodDelete, "/admin/quickref/hop-dong-lao-dong", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/quickref/hop-dong-lao-dong", nil)
+	if code := decodeError(t, rec).Code; code != ErrCodeQuickRefNotFound {
+		t.Errorf("deleted quick reference = %s, want %s", code, ErrCodeQuickRefNotFound)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaConfig caps the queries one client may send per day
+type QuotaConfig struct {
+	// QueriesPerDay is the number of queries a client may send per UTC
+	// day; 0 disables the quota
+	QueriesPerDay int
+}
+
+// quotaRoutes are the routes that send a question to the engine, each
+// request counting as one query
+var quotaRoutes = map[string]bool{
+	"/api/legal-query":            true,
+	"/api/legal-query/async":      true,
+	"/api/legal-query/stream":     true,
+	"/api/legal-query/compare":    true,
+	"/api/history/:id/regenerate": true,
+}
+
+// QueryQuota counts the queries of each client, keyed like the rate limit,
+// during the current UTC day. Counts are kept in memory, so a restart
+// renews every quota.
+type QueryQuota struct {
+	mu     sync.Mutex
+	limit  int
+	day    time.Time
+	counts map[string]int
+	now    func() time.Time
+}
+
+func newQueryQuota(config QuotaConfig) *QueryQuota {
+	return &QueryQuota{
+		limit:  config.QueriesPerDay,
+		counts: make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+// quotaDecision is the outcome of a query against its client's quota
+type quotaDecision struct {
+	allowed bool
+	limit   int
+	// reset is how long until the quota is renewed
+	reset time.Duration
+}
+
+// Take counts a query of client when its quota is not used up
+func (q *QueryQuota) Take(client string) quotaDecision {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	if !day.Equal(q.day) {
+		q.day = day
+		clear(q.counts)
+	}
+
+	d := quotaDecision{allowed: q.counts[client] < q.limit, limit: q.limit, reset: day.Add(24 * time.Hour).Sub(now)}
+	if d.allowed {
+		q.counts[client]++
+	}
+	return d
+}
+
+// quotaMiddleware answers 429 QUOTA_EXCEEDED to clients that sent their
+// daily queries, until the quota is renewed at midnight UTC. Only routes
+// that query the engine are counted.
+func quotaMiddleware(quota *QueryQuota) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if quota.limit <= 0 || c.Request.Method != http.MethodPost || !quotaRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		d := quota.Take(rateLimitClient(c))
+		if !d.allowed {
+			resp := newErrorResponse(ErrCodeQuotaExceeded, fmt.Sprintf("Daily quota of %d queries exhausted; it renews in %d seconds", d.limit, ceilSeconds(d.reset)))
+			resp.Details = map[string]any{"limit": d.limit, "reset_seconds": ceilSeconds(d.reset)}
+			resp.RetryAfter = ceilSeconds(d.reset)
+			abortWithResponse(c, resp)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQueryQuota(t *testing.T) {
+	clock := time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC)
+	quota := newQueryQuota(QuotaConfig{QueriesPerDay: 2})
+	quota.now = func() time.Time { return clock }
+
+	for i, want := range []bool{true, true, false} {
+		if d := quota.Take("key:k1"); d.allowed != want {
+			t.Fatalf("query %d allowed = %v, want %v", i+1, d.allowed, want)
+		}
+	}
+	if d := quota.Take("key:k1"); d.reset != time.Hour || d.limit != 2 {
+		t.Errorf("decision = %+v, want the quota renewed in an hour", d)
+	}
+	if !quota.Take("key:k2").allowed {
+		t.Error("another client was refused")
+	}
+
+	// The quota renews at midnight UTC
+	clock = clock.Add(time.Hour)
+	if !quota.Take("key:k1").allowed {
+		t.Error("query of the next day was refused")
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(quotaMiddleware(newQueryQuota(QuotaConfig{QueriesPerDay: 1})))
+	router.POST("/api/legal-query", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/api/validate", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	post := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+	if rec := post("/api/legal-query"); rec.Code != http.StatusOK {
+		t.Fatalf("first query = %d, want it allowed", rec.Code)
+	}
+	rec := post("/api/legal-query")
+	resp := decodeError(t, rec)
+	if rec.Code != http.StatusTooManyRequests || resp.Code != ErrCodeQuotaExceeded || resp.Error != "quota_exceeded" {
+		t.Errorf("second query = %d %s %q, want 429 QUOTA_EXCEEDED", rec.Code, resp.Code, resp.Error)
+	}
+	if resp.RetryAfter <= 0 || resp.Details["limit"] != float64(1) || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("second query: retry_after %d, details %v, want the renewal and the limit", resp.RetryAfter, resp.Details)
+	}
+	if rec := post("/api/validate"); rec.Code != http.StatusOK {
+		t.Errorf("validation = %d, want it not counted", rec.Code)
+	}
+
+	// Without a quota every query goes through
+	router = gin.New()
+	router.Use(quotaMiddleware(newQueryQuota(QuotaConfig{})))
+	router.POST("/api/legal-query", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for i := range 3 {
+		if rec := post("/api/legal-query"); rec.Code != http.StatusOK {
+			t.Fatalf("query %d without a quota = %d", i+1, rec.Code)
+		}
+	}
+}
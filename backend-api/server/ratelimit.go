@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// RateLimitConfig limits how fast a single client may send requests.
+// PerMinute is the sustained rate and Burst how many requests may be sent
+// at once; a PerMinute of 0 disables the limit.
+type RateLimitConfig struct {
+	PerMinute int
+	Burst     int
+}
+
+// rateLimitSweepInterval is how often buckets of idle clients are dropped
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds the requests a client may still send
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps a token bucket per client, keyed by API key or, for
+// requests without one, by client IP
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter returns a limiter that lets every request through while
+// PerMinute is 0, so that SetConfig can enable it later
+func newRateLimiter(config RateLimitConfig) *RateLimiter {
+	l := &RateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+	l.SetConfig(config)
+	return l
+}
+
+// SetConfig changes the limit. Clients keep the requests they may still
+// send, up to the new burst; a PerMinute of 0 disables the limit.
+func (l *RateLimiter) SetConfig(config RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(max(config.PerMinute, 0)) / 60
+	l.burst = float64(max(config.Burst, 1))
+	if l.rate == 0 {
+		clear(l.buckets)
+	}
+	for _, b := range l.buckets {
+		b.tokens = math.Min(b.tokens, l.burst)
+	}
+}
+
+// rateDecision is the outcome of a request against its client's bucket
+type rateDecision struct {
+	allowed bool
+
+	// unlimited is set while the limit is disabled
+	unlimited bool
+
+	// limit is the burst of the bucket
+	limit int
+
+	// remaining is the number of requests the client may still send at
+	// once; retryAfter is how long until the next one is allowed, and reset
+	// how long until the bucket is full again
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// Allow takes a token from the bucket of client when one is left
+func (l *RateLimiter) Allow(client string) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return rateDecision{allowed: true, unlimited: true}
+	}
+	now := l.now()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	d := rateDecision{allowed: b.tokens >= 1, limit: int(l.burst)}
+	if d.allowed {
+		b.tokens--
+	} else {
+		d.retryAfter = l.timeToFill(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.timeToFill(l.burst - b.tokens)
+	return d
+}
+
+func (l *RateLimiter) timeToFill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweepLocked drops the buckets that have refilled, which are the same as
+// a new bucket, so idle clients do not accumulate
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// loadTrustedProxies reads TRUSTED_PROXIES: the addresses and CIDR ranges
+// of the proxies whose X-Forwarded-For names the client. Without it the
+// header is ignored and clients are told apart by their own address.
+func loadTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range settings.List("TRUSTED_PROXIES") {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			settings.Warn("TRUSTED_PROXIES", "TRUSTED_PROXIES entry %q is not an IP address or CIDR range; ignoring it", proxy)
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// rateLimitClient identifies the caller of a request: its API key, or its
+// IP address, read from X-Forwarded-For only behind a trusted proxy
+func rateLimitClient(c *gin.Context) string {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return "key:" + v.(APIKey).ID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// rateLimitMiddleware answers 429 RATE_LIMITED to clients sending requests
+// faster than the limit, so a single client cannot saturate the engine.
+// Health probes and the admin API are not limited.
+func rateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "/health" || route == "/ready" || route == "/healthz" || route == "/readyz" || route == "/metrics" || strings.HasPrefix(route, "/admin/") || strings.HasPrefix(route, engineCallbackPath) {
+			c.Next()
+			return
+		}
+
+		d := limiter.Allow(rateLimitClient(c))
+		if d.unlimited {
+			c.Next()
+			return
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(d.limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+		if !d.allowed {
+			resp := newErrorResponse(ErrCodeRateLimited, fmt.Sprintf("Too many requests; retry in %d seconds", ceilSeconds(d.retryAfter)))
+			resp.Details = map[string]any{"limit": d.limit, "reset_seconds": ceilSeconds(d.reset)}
+			resp.RetryAfter = ceilSeconds(d.retryAfter)
+			abortWithResponse(c, resp)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
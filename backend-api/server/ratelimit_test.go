@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 30, Burst: 2})
+	limiter.now = func() time.Time { return clock }
+
+	for i, want := range []bool{true, true, false} {
+		if d := limiter.Allow("ip:10.0.0.1"); d.allowed != want {
+			t.Fatalf("request %d allowed = %v, want %v", i+1, d.allowed, want)
+		}
+	}
+	d := limiter.Allow("ip:10.0.0.1")
+	if d.retryAfter != 2*time.Second || d.reset != 4*time.Second || d.remaining != 0 {
+		t.Errorf("decision = %+v, want a retry after 2s and a full bucket after 4s", d)
+	}
+	if !limiter.Allow("ip:10.0.0.2").allowed {
+		t.Error("another client was limited")
+	}
+
+	// One request every two seconds refills
+	clock = clock.Add(2 * time.Second)
+	if !limiter.Allow("ip:10.0.0.1").allowed {
+		t.Error("request after the refill was limited")
+	}
+
+	// Buckets of idle clients are dropped once refilled
+	clock = clock.Add(time.Hour)
+	limiter.Allow("ip:10.0.0.3")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets left, want only the new client's", len(limiter.buckets))
+	}
+
+	if d := newRateLimiter(RateLimitConfig{PerMinute: 0, Burst: 10}).Allow("ip:10.0.0.4"); !d.allowed || !d.unlimited {
+		t.Error("limiter with no rate is enabled")
+	}
+
+	// A reloaded limit caps the buckets at the new burst, and no rate lets
+	// every request through
+	limiter.SetConfig(RateLimitConfig{PerMinute: 30, Burst: 1})
+	for i, want := range []bool{true, false} {
+		if d := limiter.Allow("ip:10.0.0.4"); d.allowed != want || d.limit != 1 {
+			t.Errorf("request %d after lowering the burst = %+v, want allowed %v at a limit of 1", i+1, d, want)
+		}
+	}
+	limiter.SetConfig(RateLimitConfig{PerMinute: 0, Burst: 1})
+	if d := limiter.Allow("ip:10.0.0.4"); !d.allowed || !d.unlimited {
+		t.Errorf("decision without a rate = %+v, want unlimited", d)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(rateLimitMiddleware(newRateLimiter(RateLimitConfig{PerMinute: 60, Burst: 1})))
+	router.GET("/api/errors", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/api/errors"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "1" || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request = %d %v, want it allowed with rate limit headers", rec.Code, rec.Header())
+	}
+	rec := get("/api/errors")
+	resp := decodeError(t, rec)
+	if rec.Code != http.StatusTooManyRequests || resp.Code != ErrCodeRateLimited {
+		t.Errorf("second request = %d %s, want 429 RATE_LIMITED", rec.Code, resp.Code)
+	}
+	if resp.RetryAfter != 1 || resp.Details["limit"] != float64(1) {
+		t.Errorf("second request: retry_after %d, details %v, want 1 and the limit", resp.RetryAfter, resp.Details)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+	if rec := get("/health"); rec.Code != http.StatusOK {
+		t.Errorf("health probe = %d, want it never limited", rec.Code)
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	newServer := func(t *testing.T, proxies string) http.Handler {
+		t.Setenv("DATA_DIR", t.TempDir())
+		t.Setenv("TRUSTED_PROXIES", proxies)
+		config := LoadConfig()
+		config.RateLimit = RateLimitConfig{PerMinute: 60, Burst: 1}
+		return newTestServer(t, Options{Config: config}).Handler()
+	}
+	getFrom := func(h http.Handler, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/errors", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("spoofed header", func(t *testing.T) {
+		h := newServer(t, "")
+		if code := getFrom(h, "203.0.113.1"); code != http.StatusOK {
+			t.Fatalf("first request = %d, want 200", code)
+		}
+		if code := getFrom(h, "203.0.113.2"); code != http.StatusTooManyRequests {
+			t.Errorf("request with a new X-Forwarded-For = %d, want 429 without a trusted proxy", code)
+		}
+	})
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		// httptest requests come from 192.0.2.1.
+		h := newServer(t, "192.0.2.0/24")
+		if code := getFrom(h, "203.0.113.1"); code != http.StatusOK {
+			t.Fatalf("first request = %d, want 200", code)
+		}
+		if code := getFrom(h, "203.0.113.2"); code != http.StatusOK {
+			t.Errorf("request for another forwarded client = %d, want 200", code)
+		}
+		if code := getFrom(h, "203.0.113.1"); code != http.StatusTooManyRequests {
+			t.Errorf("repeated forwarded client = %d, want 429", code)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// defaultRedisCachePrefix starts the keys of the shared response cache
+const defaultRedisCachePrefix = "legal-rag:cache:"
+
+// redisTimeout bounds connecting to Redis and each command, unless the URL
+// sets its own timeouts
+const redisTimeout = 2 * time.Second
+
+// newRedisClient returns a client of the server of a redis:// or rediss://
+// URL. It connects on first use.
+func newRedisClient(rawURL string) (*redis.Client, error) {
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, timeout := range []*time.Duration{&options.DialTimeout, &options.ReadTimeout, &options.WriteTimeout} {
+		if *timeout == 0 {
+			*timeout = redisTimeout
+		}
+	}
+	return redis.NewClient(options), nil
+}
+
+// pingRedis checks that the server of client answers
+func pingRedis(client *redis.Client) error {
+	return client.Ping(context.Background()).Err()
+}
+
+// redisCache keeps cached responses in Redis, so every replica shares
+// them and an invalidation applies to all of them. Each response is
+// stored under <prefix>resp:<key> and listed in a <prefix>doc:<document> set
+// per document it was drawn from; both expire after the TTL.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (s *redisCache) Backend() string {
+	return CacheRedis
+}
+
+func (s *redisCache) responseKey(key string) string {
+	return s.prefix + "resp:" + key
+}
+
+func (s *redisCache) documentKey(document string) string {
+	return s.prefix + "doc:" + document
+}
+
+func (s *redisCache) Get(key string) (*engine.LegalQueryResponse, bool, error) {
+	data, err := s.client.Get(context.Background(), s.responseKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var resp engine.LegalQueryResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+	return &resp, true, nil
+}
+
+func (s *redisCache) Put(key string, resp *engine.LegalQueryResponse, documents []string) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	_, err = s.client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		ctx := context.Background()
+		pipe.Set(ctx, s.responseKey(key), data, s.ttl)
+		for _, document := range documents {
+			pipe.SAdd(ctx, s.documentKey(document), key)
+			pipe.Expire(ctx, s.documentKey(document), s.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisCache) Invalidate(documents []string) (int, error) {
+	ctx := context.Background()
+	n := 0
+	for _, document := range documents {
+		keys, err := s.client.SMembers(ctx, s.documentKey(document)).Result()
+		if err != nil {
+			return n, err
+		}
+		for i := range keys {
+			keys[i] = s.responseKey(keys[i])
+		}
+		deleted, err := s.del(keys)
+		n += deleted
+		if err != nil {
+			return n, err
+		}
+		if _, err := s.del([]string{s.documentKey(document)}); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (s *redisCache) Purge() (int, error) {
+	keys, err := s.scan(s.prefix + "resp:*")
+	if err != nil {
+		return 0, err
+	}
+	documents, err := s.scan(s.prefix + "doc:*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.del(documents); err != nil {
+		return 0, err
+	}
+	return s.del(keys)
+}
+
+func (s *redisCache) Size() (entries, documents int, err error) {
+	keys, err := s.scan(s.prefix + "resp:*")
+	if err != nil {
+		return 0, 0, err
+	}
+	docs, err := s.scan(s.prefix + "doc:*")
+	return len(keys), len(docs), err
+}
+
+// del deletes keys, counting those that existed; DEL takes at least one
+func (s *redisCache) del(keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	n, err := s.client.Del(context.Background(), keys...).Result()
+	return int(n), err
+}
+
+// scan lists the keys matching pattern
+func (s *redisCache) scan(pattern string) ([]string, error) {
+	ctx := context.Background()
+	var keys []string
+	iter := s.client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// normalizeQuestion folds case, spacing and trailing punctuation, so the
+// same question typed differently shares a cache entry
+func normalizeQuestion(question string) string {
+	question = strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	return strings.TrimRight(question, " ?.!…")
+}
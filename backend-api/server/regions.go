@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// RegionConfig describes the engine regions. The secondary region is used
+// only when SecondaryURL is set.
+type RegionConfig struct {
+	PrimaryName    string
+	SecondaryName  string
+	SecondaryURL   string
+	LatencyBudget  time.Duration
+	HealthInterval time.Duration
+}
+
+// engineRegion is an engine deployment in one region
+type engineRegion struct {
+	name    string
+	client  *engine.PythonClient
+	healthy atomic.Bool
+}
+
+// failoverEngine sends queries to the primary region and falls back to the
+// secondary when the primary is unhealthy, fails, or has not answered within
+// the latency budget. After the budget both regions race; the first answer
+// wins and the other request is cancelled.
+type failoverEngine struct {
+	primary   *engineRegion
+	secondary *engineRegion
+	budget    time.Duration
+
+	// status shows a banner while a region is unhealthy
+	status *StatusBoard
+}
+
+func newFailoverEngine(config RegionConfig, primary, secondary *engine.PythonClient) *failoverEngine {
+	e := &failoverEngine{
+		primary:   &engineRegion{name: config.PrimaryName, client: primary},
+		secondary: &engineRegion{name: config.SecondaryName, client: secondary},
+		budget:    config.LatencyBudget,
+	}
+	e.primary.healthy.Store(true)
+	e.secondary.healthy.Store(true)
+	return e
+}
+
+type regionResult struct {
+	region *engineRegion
+	resp   *engine.LegalQueryResponse
+	err    error
+}
+
+func (e *failoverEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *failoverEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	first, second := e.primary, e.secondary
+	if !first.healthy.Load() && second.healthy.Load() {
+		first, second = second, first
+	}
+	budget := e.budget
+	if req.LatencyBudget > 0 {
+		budget = req.LatencyBudget
+	}
+
+	results := make(chan regionResult, 2)
+	attempt := exclusiveStream(req)
+	query := func(region *engineRegion) {
+		resp, err := region.client.QueryContext(ctx, attempt())
+		results <- regionResult{region, resp, err}
+	}
+
+	go query(first)
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+
+	pending := 1
+	secondStarted := false
+	startSecond := func(reason string) {
+		if !secondStarted {
+			slog.WarnContext(ctx, "Trying secondary engine region", "region", second.name, "reason", reason)
+			secondStarted = true
+			pending++
+			go query(second)
+		}
+	}
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				r.resp.Region = r.region.name
+				return r.resp, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			// A cancelled query is not retried in the other region
+			if r.region == first && !isClientError(r.err) && ctx.Err() == nil {
+				startSecond("region " + first.name + " failed")
+			}
+		case <-timer.C:
+			startSecond("latency budget of " + budget.String() + " exceeded")
+		}
+	}
+	return nil, firstErr
+}
+
+// isClientError reports an engine rejection of the request itself, which
+// another region would reject too
+func isClientError(err error) bool {
+	var statusErr *engine.EngineStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= http.StatusBadRequest && statusErr.StatusCode < http.StatusInternalServerError
+}
+
+// checkHealth updates the health of every region every interval until stop
+// is closed
+func (e *failoverEngine) checkHealth(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, region := range []*engineRegion{e.primary, e.secondary} {
+			err := region.client.HealthCheck()
+			if healthy := err == nil; region.healthy.Swap(healthy) != healthy {
+				if healthy {
+					slog.Info("Engine region is healthy again", "region", region.name)
+					e.status.Clear("region_" + region.name)
+				} else {
+					slog.Warn("Engine region is unhealthy", "region", region.name, "error", err)
+					e.status.Raise("region_"+region.name, StatusDegraded, SeverityWarning,
+						fmt.Sprintf("Engine region %s is unavailable; answers may take longer than usual", region.name))
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// HealthCheck checks both regions, failing only when neither is reachable,
+// since queries fail over to the healthy one
+func (e *failoverEngine) HealthCheck() error {
+	primaryErr := e.primary.client.HealthCheck()
+	if primaryErr == nil {
+		return nil
+	}
+	if err := e.secondary.client.HealthCheck(); err != nil {
+		return fmt.Errorf("region %s: %v; region %s: %v", e.primary.name, primaryErr, e.secondary.name, err)
+	}
+	return nil
+}
+
+// RegionStatus is the health of one engine region
+type RegionStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Primary bool   `json:"primary"`
+}
+
+func (e *failoverEngine) Status() []RegionStatus {
+	return []RegionStatus{
+		{Name: e.primary.name, Healthy: e.primary.healthy.Load(), Primary: true},
+		{Name: e.secondary.name, Healthy: e.secondary.healthy.Load()},
+	}
+}
+
+// Handlers
+
+func regionStatusHandler(regions *failoverEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if regions == nil {
+			abortWithError(c, ErrCodeForbidden, "Engine failover is disabled; set SECONDARY_ENGINE_URL to enable it")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"regions": regions.Status()})
+	}
+}
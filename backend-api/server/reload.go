@@ -0,0 +1,265 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// Sources of configuration reloads
+const (
+	ReloadAdmin = "admin"
+	ReloadFile  = "file"
+)
+
+// queryDefaultSettings map the fields of the server-wide query defaults to
+// their settings
+var queryDefaultSettings = map[string]string{
+	"max_iterations":    "DEFAULT_MAX_ITERATIONS",
+	"top_k":             "DEFAULT_TOP_K",
+	"enable_web_search": "DEFAULT_WEB_SEARCH",
+	"model":             "DEFAULT_MODEL",
+	"response_format":   "DEFAULT_RESPONSE_FORMAT",
+}
+
+// loadQueryDefaults reads the server-wide defaults of query parameters,
+// which apply to the parameters neither the client nor the tenant defaults
+// set
+func loadQueryDefaults() QueryDefaults {
+	var d QueryDefaults
+	if settings.Get("DEFAULT_MAX_ITERATIONS") != "" {
+		n := settings.IntInRange("DEFAULT_MAX_ITERATIONS", 3, 1, engineMaxIterations)
+		d.MaxIterations = &n
+	}
+	if settings.Get("DEFAULT_TOP_K") != "" {
+		n := settings.IntInRange("DEFAULT_TOP_K", 3, 1, engineMaxTopK)
+		d.TopK = &n
+	}
+	if settings.Get("DEFAULT_WEB_SEARCH") != "" {
+		enabled := settings.Bool("DEFAULT_WEB_SEARCH", true)
+		d.EnableWebSearch = &enabled
+	}
+	d.Model = settings.Get("DEFAULT_MODEL")
+	d.ResponseFormat = settings.Get("DEFAULT_RESPONSE_FORMAT")
+	return d
+}
+
+// LiveSettings are the settings a reload applies to the running server
+type LiveSettings struct {
+	RequestTimeout time.Duration
+	RateLimit      RateLimitConfig
+	QueryDefaults  QueryDefaults
+}
+
+func liveSettings(config *Config) LiveSettings {
+	return LiveSettings{
+		RequestTimeout: config.RequestTimeout,
+		RateLimit:      config.RateLimit,
+		QueryDefaults:  config.QueryDefaults,
+	}
+}
+
+// changed names the settings that differ from old
+func (s LiveSettings) changed(old LiveSettings) []string {
+	names := []string{}
+	if s.RequestTimeout != old.RequestTimeout {
+		names = append(names, "REQUEST_TIMEOUT")
+	}
+	if s.RateLimit.PerMinute != old.RateLimit.PerMinute {
+		names = append(names, "RATE_LIMIT_PER_MINUTE")
+	}
+	if s.RateLimit.Burst != old.RateLimit.Burst {
+		names = append(names, "RATE_LIMIT_BURST")
+	}
+	d, o := s.QueryDefaults, old.QueryDefaults
+	if !equalPtr(d.MaxIterations, o.MaxIterations) {
+		names = append(names, "DEFAULT_MAX_ITERATIONS")
+	}
+	if !equalPtr(d.TopK, o.TopK) {
+		names = append(names, "DEFAULT_TOP_K")
+	}
+	if !equalPtr(d.EnableWebSearch, o.EnableWebSearch) {
+		names = append(names, "DEFAULT_WEB_SEARCH")
+	}
+	if d.Model != o.Model {
+		names = append(names, "DEFAULT_MODEL")
+	}
+	if d.ResponseFormat != o.ResponseFormat {
+		names = append(names, "DEFAULT_RESPONSE_FORMAT")
+	}
+	return names
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	return a == b || (a != nil && b != nil && *a == *b)
+}
+
+func (s LiveSettings) view() gin.H {
+	return gin.H{
+		"request_timeout": s.RequestTimeout.String(),
+		"rate_limit":      gin.H{"per_minute": s.RateLimit.PerMinute, "burst": s.RateLimit.Burst},
+		"query_defaults":  s.QueryDefaults,
+	}
+}
+
+// ReloadResult reports a configuration reload
+type ReloadResult struct {
+	Source   string          `json:"source"`
+	At       time.Time       `json:"at"`
+	Applied  bool            `json:"applied"`
+	Changed  []string        `json:"changed"`
+	Problems []ConfigProblem `json:"problems,omitempty"`
+}
+
+// errReloadRejected is returned when strict mode rejects reloaded settings
+var errReloadRejected = errors.New("reloaded configuration has problems")
+
+// ConfigReloader applies reloaded settings to the running server: the
+// timeout of engine requests, the rate limit and the server-wide query
+// defaults. Other settings still need a restart.
+type ConfigReloader struct {
+	mu       sync.Mutex
+	strict   bool
+	clients  []*engine.PythonClient
+	limiter  *RateLimiter
+	defaults atomic.Pointer[QueryDefaults]
+	current  LiveSettings
+	last     *ReloadResult
+}
+
+func newConfigReloader(config *Config, limiter *RateLimiter) *ConfigReloader {
+	r := &ConfigReloader{strict: config.Strict, limiter: limiter, current: liveSettings(config)}
+	defaults := config.QueryDefaults
+	r.defaults.Store(&defaults)
+	return r
+}
+
+// addClient has reloads change the timeout of an engine client
+func (r *ConfigReloader) addClient(client *engine.PythonClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = append(r.clients, client)
+}
+
+// QueryDefaults returns the server-wide query defaults; a nil reloader has
+// none
+func (r *ConfigReloader) QueryDefaults() QueryDefaults {
+	if r == nil {
+		return QueryDefaults{}
+	}
+	return *r.defaults.Load()
+}
+
+// Reload reads the config file again and applies the live settings. Like at
+// startup, problems are logged and their settings fall back to defaults,
+// except in strict mode, where the reload is rejected and the previous
+// settings are kept.
+func (r *ConfigReloader) Reload(source string) (ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := ReloadResult{Source: source, At: time.Now().UTC(), Changed: []string{}}
+	defer func() { r.last = &result }()
+
+	saved := settings.Save()
+	settings.Reload()
+	config := LoadConfig()
+	result.Problems = CheckConfig(config, false)
+	if len(result.Problems) > 0 && r.strict {
+		settings.Restore(saved)
+		slog.Error("Rejected configuration reload", "source", source, "problems", len(result.Problems))
+		return result, errReloadRejected
+	}
+	for _, p := range result.Problems {
+		slog.Warn("Configuration problem", "setting", p.Setting, "problem", p.Problem)
+	}
+
+	live := liveSettings(config)
+	result.Applied = true
+	result.Changed = live.changed(r.current)
+	for _, client := range r.clients {
+		client.SetTimeout(live.RequestTimeout)
+	}
+	if r.limiter != nil {
+		r.limiter.SetConfig(live.RateLimit)
+	}
+	r.defaults.Store(&live.QueryDefaults)
+	r.current = live
+	slog.Info("Reloaded configuration", "source", source, "changed", result.Changed)
+	return result, nil
+}
+
+// watch reloads the configuration when the config file changes, checking
+// every interval until stop is closed. Nothing is watched without a config
+// file.
+func (r *ConfigReloader) watch(path string, interval time.Duration, stop <-chan struct{}) {
+	if path == "" || interval <= 0 {
+		return
+	}
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// A file being replaced may be missing for a moment
+		t, n := stat()
+		if n < 0 || (t.Equal(modTime) && n == size) {
+			continue
+		}
+		modTime, size = t, n
+		r.Reload(ReloadFile)
+	}
+}
+
+// status returns the live settings and the last reload
+func (r *ConfigReloader) status() gin.H {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return gin.H{"live": r.current.view(), "config_file": settings.FilePath(), "last_reload": r.last}
+}
+
+// Handlers
+
+func getLiveConfigHandler(reloader *ConfigReloader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, reloader.status())
+	}
+}
+
+// reloadConfigHandler reloads the configuration. A rejected reload answers
+// 422 CONFIG_INVALID with the problems in the message.
+func reloadConfigHandler(reloader *ConfigReloader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := reloader.Reload(ReloadAdmin)
+		if err != nil {
+			problems := make([]string, 0, len(result.Problems))
+			for _, p := range result.Problems {
+				problems = append(problems, p.Setting+": "+p.Problem)
+			}
+			abortWithError(c, ErrCodeConfigInvalid, fmt.Sprintf("Configuration not reloaded: %s", strings.Join(problems, "; ")))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reload": result, "live": reloader.status()["live"]})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+func TestConfigReload(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "reload-admin-token")
+	path := filepath.Join(t.TempDir(), "legal-rag.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config file: %v", err)
+		}
+	}
+	write("server:\n  request_timeout: 60s\nquery:\n  top_k: 5\n")
+	settings.Load(map[string]string{"CONFIG_FILE": path}, nil, configFileSchema)
+	t.Cleanup(func() { settings.Load(nil, nil, nil) })
+
+	// Strict mode rejects reloads with problems instead of logging them
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "0")
+	config := LoadConfig()
+	config.Strict = true
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019.", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub, Config: config}).Handler()
+	// Unset the rate limit of the tests so that the file's applies
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "")
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "reload-admin-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	query := func(question string) int {
+		t.Helper()
+		return do(http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question}).Code
+	}
+	lastTopK := func() int {
+		t.Helper()
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		return stub.requests[len(stub.requests)-1].TopK
+	}
+
+	if code := query("Thời gian thử việc tối đa là bao lâu?"); code != http.StatusOK {
+		t.Fatalf("query = %d", code)
+	}
+	if got := lastTopK(); got != 5 {
+		t.Errorf("top_k = %d, want the file's default 5", got)
+	}
+
+	write("server:\n  request_timeout: 90s\nrate_limit:\n  per_minute: 60\n  burst: 1\nquery:\n  top_k: 7\n")
+	rec := do(http.MethodPost, "/admin/config/reload", nil)
+	var reloaded struct {
+		Reload ReloadResult `json:"reload"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reloaded); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("reload = %d %s", rec.Code, rec.Body.String())
+	}
+	want := []string{"REQUEST_TIMEOUT", "RATE_LIMIT_PER_MINUTE", "RATE_LIMIT_BURST", "DEFAULT_TOP_K"}
+	if !reloaded.Reload.Applied || !slices.Equal(reloaded.Reload.Changed, want) {
+		t.Errorf("reload = %+v, want applied with %v changed", reloaded.Reload, want)
+	}
+	if code := query("Thử việc có cần hợp đồng không?"); code != http.StatusOK {
+		t.Fatalf("query after reload = %d", code)
+	}
+	if got := lastTopK(); got != 7 {
+		t.Errorf("top_k = %d, want the reloaded default 7", got)
+	}
+	if code := query("Lương thử việc tối thiểu là bao nhiêu?"); code != http.StatusTooManyRequests {
+		t.Errorf("query over the reloaded burst = %d, want 429", code)
+	}
+
+	// A rejected reload keeps the previous settings
+	write("server:\n  request_timeout: 90s\nrate_limit:\n  per_minute: 60\n  burst: 1\nquery:\n  top_k: 500\n")
+	if code := decodeError(t, do(http.MethodPost, "/admin/config/reload", nil)).Code; code != ErrCodeConfigInvalid {
+		t.Errorf("strict reload = %s, want %s", code, ErrCodeConfigInvalid)
+	}
+	if got := settings.Get("DEFAULT_TOP_K"); got != "7" {
+		t.Errorf("DEFAULT_TOP_K after a rejected reload = %q, want 7", got)
+	}
+	rec = do(http.MethodGet, "/admin/config", nil)
+	var status struct {
+		Live struct {
+			RequestTimeout string        `json:"request_timeout"`
+			QueryDefaults  QueryDefaults `json:"query_defaults"`
+		} `json:"live"`
+		LastReload ReloadResult `json:"last_reload"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("live config = %d %s", rec.Code, rec.Body.String())
+	}
+	if status.Live.RequestTimeout != "1m30s" || status.Live.QueryDefaults.TopK == nil || *status.Live.QueryDefaults.TopK != 7 {
+		t.Errorf("live = %+v, want the settings of the last applied reload", status.Live)
+	}
+	if status.LastReload.Applied || len(status.LastReload.Problems) == 0 {
+		t.Errorf("last reload = %+v, want the rejected one with its problems", status.LastReload)
+	}
+}
@@ -0,0 +1,591 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// ReviewJobConfig controls document review jobs. Workers checklist
+// questions are answered at once across all jobs, and at most PerMinute
+// per minute so a large review does not crowd out interactive queries; a
+// PerMinute of 0 disables the limit. A finished job is kept for TTL.
+type ReviewJobConfig struct {
+	Workers      int
+	PerMinute    int
+	MaxDocuments int
+	MaxQuestions int
+	TTL          time.Duration
+}
+
+// ChecklistItem is a compliance question asked of every document of a
+// review job. Expected is the answer of a compliant document, "yes" or
+// "no".
+type ChecklistItem struct {
+	Question string `json:"question"`
+	Expected string `json:"expected"`
+}
+
+// ReviewVerdict is the outcome of one checklist question for one document
+type ReviewVerdict string
+
+const (
+	VerdictPending ReviewVerdict = "pending"
+	VerdictPass    ReviewVerdict = "pass"
+	VerdictFail    ReviewVerdict = "fail"
+	VerdictUnclear ReviewVerdict = "unclear"
+	VerdictError   ReviewVerdict = "error"
+)
+
+// ReviewFinding is the answer to one checklist question for one document
+type ReviewFinding struct {
+	Verdict   ReviewVerdict  `json:"verdict"`
+	Answer    string         `json:"answer,omitempty"`
+	HistoryID string         `json:"history_id,omitempty"`
+	Error     *ErrorResponse `json:"error,omitempty"`
+}
+
+// Scorecard sums up the findings of one document, in checklist order.
+// Score is the share of passed questions among those with a clear verdict.
+type Scorecard struct {
+	Document string          `json:"document"`
+	Findings []ReviewFinding `json:"findings"`
+	Passed   int             `json:"passed"`
+	Failed   int             `json:"failed"`
+	Unclear  int             `json:"unclear"`
+	Errors   int             `json:"errors"`
+	Score    *float64        `json:"score,omitempty"`
+}
+
+func (s *Scorecard) add(question int, finding ReviewFinding) {
+	s.Findings[question] = finding
+	switch finding.Verdict {
+	case VerdictPass:
+		s.Passed++
+	case VerdictFail:
+		s.Failed++
+	case VerdictUnclear:
+		s.Unclear++
+	case VerdictError:
+		s.Errors++
+	}
+	if s.Passed+s.Failed > 0 {
+		score := float64(s.Passed) / float64(s.Passed+s.Failed)
+		s.Score = &score
+	}
+}
+
+// ReviewProgress counts the (document, question) tasks of a review job
+type ReviewProgress struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Errors    int `json:"errors"`
+}
+
+// ReviewJob asks every question of a checklist of every document. It
+// succeeds once every question was answered, even when some failed.
+type ReviewJob struct {
+	ID         string          `json:"id"`
+	TenantID   string          `json:"tenant_id,omitempty"`
+	User       string          `json:"user,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Status     JobStatus       `json:"status"`
+	Checklist  []ChecklistItem `json:"checklist"`
+	Progress   ReviewProgress  `json:"progress"`
+	Scorecards []Scorecard     `json:"scorecards,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// clone copies a job with its scorecards, which workers keep updating
+func (j *ReviewJob) clone() ReviewJob {
+	job := *j
+	job.Scorecards = make([]Scorecard, len(j.Scorecards))
+	for i, card := range j.Scorecards {
+		card.Findings = append([]ReviewFinding(nil), card.Findings...)
+		job.Scorecards[i] = card
+	}
+	return job
+}
+
+// reviewRun answers a checklist question about a document
+type reviewRun func(doc engine.ContextDocument, question string) (*engine.LegalQueryResponse, *ErrorResponse)
+
+// reviewJobState is a review job with the documents it reviews and the
+// next task to hand to a worker, documents first
+type reviewJobState struct {
+	job       ReviewJob
+	documents []engine.ContextDocument
+	next      int
+	run       reviewRun
+}
+
+// reviewTask is one checklist question for one document
+type reviewTask struct {
+	state    *reviewJobState
+	document int
+	question int
+}
+
+var errReviewJobNotFound = errors.New("review job not found")
+
+// reviewRateKey is the single bucket of the review rate limiter, shared by
+// every job
+const reviewRateKey = "review"
+
+// ReviewJobs runs review jobs on a pool of workers, taking a task of each
+// job with questions left in turn so a large job does not hold up smaller
+// ones. Jobs are kept in memory; their answers are in the history.
+type ReviewJobs struct {
+	mu            sync.Mutex
+	jobs          map[string]*reviewJobState
+	queue         []*reviewJobState
+	wake          chan struct{}
+	limiter       *RateLimiter
+	ttl           time.Duration
+	notifications *NotificationCenter
+	now           func() time.Time
+}
+
+func NewReviewJobs(config ReviewJobConfig, notifications *NotificationCenter) *ReviewJobs {
+	return &ReviewJobs{
+		jobs:          make(map[string]*reviewJobState),
+		wake:          make(chan struct{}, 1),
+		limiter:       newRateLimiter(RateLimitConfig{PerMinute: config.PerMinute, Burst: config.Workers}),
+		ttl:           config.TTL,
+		notifications: notifications,
+		now:           time.Now,
+	}
+}
+
+// Start runs workers goroutines answering review tasks until stop is closed
+func (r *ReviewJobs) Start(workers int, stop <-chan struct{}) {
+	for range workers {
+		go func() {
+			for {
+				task, ok := r.take()
+				if !ok {
+					select {
+					case <-r.wake:
+						continue
+					case <-stop:
+						return
+					}
+				}
+				if !r.throttle(stop) {
+					return
+				}
+				r.execute(task)
+			}
+		}()
+	}
+}
+
+// Submit queues a job asking every question of its checklist of every
+// document with run
+func (r *ReviewJobs) Submit(job ReviewJob, documents []engine.ContextDocument, run reviewRun) ReviewJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked()
+	job.ID = "rj_" + randomHex(12)
+	job.Status = JobQueued
+	job.CreatedAt = r.now().UTC()
+	job.Progress = ReviewProgress{Total: len(documents) * len(job.Checklist)}
+	job.Scorecards = make([]Scorecard, len(documents))
+	for i, doc := range documents {
+		findings := make([]ReviewFinding, len(job.Checklist))
+		for q := range findings {
+			findings[q].Verdict = VerdictPending
+		}
+		job.Scorecards[i] = Scorecard{Document: doc.Name, Findings: findings}
+	}
+	state := &reviewJobState{job: job, documents: documents, run: run}
+	r.jobs[job.ID] = state
+	r.queue = append(r.queue, state)
+	r.signalLocked()
+	return state.job.clone()
+}
+
+// Get returns a job of tenantID
+func (r *ReviewJobs) Get(id, tenantID string) (ReviewJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked()
+	state, ok := r.jobs[id]
+	if !ok || state.job.TenantID != tenantID {
+		return ReviewJob{}, errReviewJobNotFound
+	}
+	return state.job.clone(), nil
+}
+
+// List returns the jobs of tenantID without their scorecards, newest first
+func (r *ReviewJobs) List(tenantID string) []ReviewJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked()
+	jobs := []ReviewJob{}
+	for _, state := range r.jobs {
+		if state.job.TenantID == tenantID {
+			job := state.job
+			job.Scorecards = nil
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// sweepLocked drops the jobs that finished more than the TTL ago
+func (r *ReviewJobs) sweepLocked() {
+	now := r.now()
+	for id, state := range r.jobs {
+		if state.job.FinishedAt != nil && now.Sub(*state.job.FinishedAt) > r.ttl {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// signalLocked wakes an idle worker when tasks are left
+func (r *ReviewJobs) signalLocked() {
+	if len(r.queue) == 0 {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take hands out the next task of the job at the head of the queue, which
+// then moves to the back while it has tasks left
+func (r *ReviewJobs) take() (reviewTask, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return reviewTask{}, false
+	}
+	state := r.queue[0]
+	r.queue = r.queue[1:]
+	questions := len(state.job.Checklist)
+	task := reviewTask{state: state, document: state.next / questions, question: state.next % questions}
+	state.next++
+	if state.next < state.job.Progress.Total {
+		r.queue = append(r.queue, state)
+	}
+	if state.job.Status == JobQueued {
+		started := r.now().UTC()
+		state.job.Status, state.job.StartedAt = JobRunning, &started
+	}
+	// Another idle worker may take the next task
+	r.signalLocked()
+	return task, true
+}
+
+// throttle waits until the rate limit allows another task. It returns
+// false when stop is closed first.
+func (r *ReviewJobs) throttle(stop <-chan struct{}) bool {
+	for {
+		d := r.limiter.Allow(reviewRateKey)
+		if d.allowed {
+			return true
+		}
+		select {
+		case <-time.After(d.retryAfter):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+func (r *ReviewJobs) execute(task reviewTask) {
+	state := task.state
+	item := state.job.Checklist[task.question]
+	resp, errResp := state.run(state.documents[task.document], item.Question)
+
+	var finding ReviewFinding
+	switch {
+	case errResp != nil || resp == nil:
+		finding = ReviewFinding{Verdict: VerdictError, Error: errResp}
+		if errResp == nil {
+			finding.Error = &ErrorResponse{Error: "internal_error", Code: ErrCodeInternal, Message: "The question ended without an answer"}
+		}
+	case resp.NeedsClarification:
+		finding = ReviewFinding{Verdict: VerdictUnclear, Answer: strings.Join(resp.ClarifyingQuestions, "\n")}
+	default:
+		finding = ReviewFinding{Verdict: reviewVerdict(resp.Answer, item.Expected), Answer: resp.Answer, HistoryID: resp.HistoryID}
+	}
+
+	r.mu.Lock()
+	job := &state.job
+	job.Scorecards[task.document].add(task.question, finding)
+	job.Progress.Completed++
+	if finding.Verdict == VerdictError {
+		job.Progress.Errors++
+	}
+	done := job.Progress.Completed == job.Progress.Total
+	if done {
+		finished := r.now().UTC()
+		job.Status, job.FinishedAt = JobSucceeded, &finished
+	}
+	finishedJob := job.clone()
+	r.mu.Unlock()
+
+	if !done {
+		return
+	}
+	slog.Info("Review job finished", "job", finishedJob.ID, "questions", finishedJob.Progress.Total, "failed", finishedJob.Progress.Errors,
+		"duration", finishedJob.FinishedAt.Sub(*finishedJob.StartedAt).String())
+	if finishedJob.User == "" {
+		return
+	}
+	r.notifications.Publish(Notification{
+		TenantID: finishedJob.TenantID,
+		User:     finishedJob.User,
+		Kind:     NotificationJobCompleted,
+		Title:    fmt.Sprintf("Review job %s finished", finishedJob.ID),
+		Body:     fmt.Sprintf("%d documents, %d questions each", len(finishedJob.Scorecards), len(finishedJob.Checklist)),
+		Link:     "/api/review-jobs/" + finishedJob.ID,
+	})
+}
+
+// polarityWords are the words a yes/no answer opens with
+var polarityWords = map[string]string{
+	"có": "yes", "đúng": "yes", "yes": "yes",
+	"không": "no", "chưa": "no", "no": "no",
+}
+
+// answerPolarity returns "yes" or "no" for an answer opening with a bare
+// yes or no ("Có, hợp đồng quy định ...", "Không."), and "" otherwise:
+// "Không quá 180 ngày" answers another kind of question.
+func answerPolarity(answer string) string {
+	s := strings.TrimLeft(strings.ToLower(answer), "*_#> \t\r\n")
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(s)
+	}
+	polarity, ok := polarityWords[s[:end]]
+	if !ok {
+		return ""
+	}
+	rest := strings.TrimLeft(s[end:], "*_")
+	if rest == "" || strings.ContainsAny(rest[:1], ".,;:!") {
+		return polarity
+	}
+	return ""
+}
+
+// reviewVerdict compares the answer to a checklist question with the
+// expected one; answers that are not a clear yes or no are left to a
+// reviewer
+func reviewVerdict(answer, expected string) ReviewVerdict {
+	switch answerPolarity(answer) {
+	case "":
+		return VerdictUnclear
+	case expected:
+		return VerdictPass
+	default:
+		return VerdictFail
+	}
+}
+
+// Handlers
+
+// ReviewJobRequest is the body of POST /api/review-jobs. Documents are
+// uploaded attachments or inline text, as in query attachments.
+type ReviewJobRequest struct {
+	Name      string            `json:"name"`
+	Documents []AttachmentInput `json:"documents"`
+	Checklist []ChecklistItem   `json:"checklist"`
+}
+
+// validateReviewJob checks the documents and checklist of a review job
+// against the limits of a review job and of a single query
+func validateReviewJob(req *ReviewJobRequest, config ReviewJobConfig, limits AttachmentLimits, plan Plan) []Violation {
+	var violations []Violation
+	for field, n := range map[string]struct{ count, max int }{
+		"documents": {len(req.Documents), config.MaxDocuments},
+		"checklist": {len(req.Checklist), config.MaxQuestions},
+	} {
+		if n.count == 0 || n.count > n.max {
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("%s must have between 1 and %d items", field, n.max),
+			})
+		}
+	}
+
+	for i, doc := range req.Documents {
+		field := fmt.Sprintf("documents[%d]", i)
+		for _, v := range validateAttachments([]AttachmentInput{doc}, limits) {
+			v.Field = strings.Replace(v.Field, "attachments[0]", field, 1)
+			v.Message = strings.Replace(v.Message, "attachments[0]", field, 1)
+			violations = append(violations, v)
+		}
+	}
+
+	for i := range req.Checklist {
+		item := &req.Checklist[i]
+		field := fmt.Sprintf("checklist[%d]", i)
+		for _, v := range validateQueryRequest(&LegalQueryRequest{Question: item.Question}, plan) {
+			v.Field = field + "." + v.Field
+			violations = append(violations, v)
+		}
+		item.Expected = strings.ToLower(strings.TrimSpace(item.Expected))
+		if item.Expected == "" {
+			item.Expected = "yes"
+		}
+		if item.Expected != "yes" && item.Expected != "no" {
+			violations = append(violations, Violation{
+				Field:   field + ".expected",
+				Code:    ViolationInvalidType,
+				Message: fmt.Sprintf("%s.expected must be \"yes\" or \"no\"", field),
+			})
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations
+}
+
+// createReviewJobHandler queues a review job and answers 202 with it. The
+// documents are read at once, so attachments may expire while the job runs.
+func createReviewJobHandler(deps queryDeps, jobs *ReviewJobs, config ReviewJobConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReviewJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if violations := validateReviewJob(&req, config, deps.attachmentLimits, callerPlan(c)); len(violations) > 0 {
+			abortWithViolations(c, violations)
+			return
+		}
+
+		tenant, _ := callerTenant(c)
+		for i := range req.Documents {
+			if req.Documents[i].ID == "" && req.Documents[i].Name == "" {
+				req.Documents[i].Name = fmt.Sprintf("document-%d", i+1)
+			}
+		}
+		documents, err := resolveAttachments(req.Documents, deps.attachments, tenant.ID)
+		if errors.Is(err, errAttachmentUnconfirmed) {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err != nil {
+			abortWithError(c, ErrCodeAttachmentNotFound, err.Error())
+			return
+		}
+
+		// Questions are answered after the response is sent, on copies of
+		// the context that are not canceled with the request
+		base := c.Copy()
+		base.Request = c.Request.WithContext(context.WithoutCancel(c.Request.Context()))
+		run := func(doc engine.ContextDocument, question string) (*engine.LegalQueryResponse, *ErrorResponse) {
+			qc := base.Copy()
+			failure := &jobRun{}
+			qc.Set(queryJobKey, failure)
+			query := LegalQueryRequest{
+				Question:    question,
+				Attachments: []AttachmentInput{{Name: doc.Name, Content: doc.Text}},
+			}
+			resp, ok := deps.answerQuery(qc, &query, nil, nil)
+			if !ok {
+				return nil, failure.err
+			}
+			return resp, nil
+		}
+
+		job := jobs.Submit(ReviewJob{
+			TenantID:  tenant.ID,
+			User:      callerUser(c),
+			Name:      strings.TrimSpace(req.Name),
+			Checklist: req.Checklist,
+		}, documents, run)
+		slog.InfoContext(c.Request.Context(), "Queued review job", "job", job.ID, "documents", len(documents), "questions", len(req.Checklist))
+		c.Header("Location", "/api/review-jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+func listReviewJobsHandler(jobs *ReviewJobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{"review_jobs": jobs.List(tenant.ID)})
+	}
+}
+
+func getReviewJobHandler(jobs *ReviewJobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		job, err := jobs.Get(c.Param("id"), tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodeReviewJobNotFound, fmt.Sprintf("Review job %q not found or expired", c.Param("id")))
+			return
+		}
+		if job.Status == JobQueued || job.Status == JobRunning {
+			c.Header("Retry-After", "5")
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// reviewReportHandler serves the findings of a review job as CSV, one row
+// per document and question; questions not answered yet are "pending"
+func reviewReportHandler(jobs *ReviewJobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		job, err := jobs.Get(c.Param("id"), tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodeReviewJobNotFound, fmt.Sprintf("Review job %q not found or expired", c.Param("id")))
+			return
+		}
+
+		var buf bytes.Buffer
+		// The byte order mark makes spreadsheets read the file as UTF-8
+		buf.WriteString("\ufeff")
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"document", "question", "expected", "verdict", "score", "answer", "history_id", "error"})
+		for _, card := range job.Scorecards {
+			score := ""
+			if card.Score != nil {
+				score = strconv.FormatFloat(*card.Score, 'f', 2, 64)
+			}
+			for q, finding := range card.Findings {
+				errMessage := ""
+				if finding.Error != nil {
+					errMessage = string(finding.Error.Code) + ": " + finding.Error.Message
+				}
+				w.Write([]string{
+					card.Document,
+					job.Checklist[q].Question,
+					job.Checklist[q].Expected,
+					string(finding.Verdict),
+					score,
+					finding.Answer,
+					finding.HistoryID,
+					errMessage,
+				})
+			}
+		}
+		w.Flush()
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, job.ID))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// contractEngine answers checklist questions from the text of the attached
+// contract
+type contractEngine struct {
+	stubEngine
+}
+
+func (e *contractEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	text := req.ContextDocuments[0].Text
+	answer := "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc do hai bên thỏa thuận."
+	switch {
+	case strings.Contains(text, "LỖI"):
+		return nil, errors.New("engine exploded")
+	case !strings.Contains(req.Question, "thử việc"):
+	case strings.Contains(text, "60 ngày"):
+		answer = "Có, hợp đồng quy định thời gian thử việc 60 ngày."
+	default:
+		answer = "Không."
+	}
+	return &engine.LegalQueryResponse{Answer: answer, Iterations: 1}, nil
+}
+
+func TestReviewJobs(t *testing.T) {
+	t.Setenv("REVIEW_JOB_WORKERS", "2")
+	t.Setenv("REVIEW_JOB_QUESTIONS_PER_MINUTE", "0")
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	srv := newTestServer(t, Options{Engine: &contractEngine{}})
+	defer srv.Close()
+	h := srv.Handler()
+
+	checklist := []ChecklistItem{
+		{Question: "Hợp đồng có quy định thời gian thử việc không quá 60 ngày không?"},
+		{Question: "Hợp đồng lao động có ghi rõ mức lương và phụ cấp của người lao động không?", Expected: "yes"},
+	}
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/review-jobs", ReviewJobRequest{
+		Name: "Rà soát hợp đồng Q4",
+		Documents: []AttachmentInput{
+			{Name: "hd-01.txt", Content: "Thời gian thử việc: 60 ngày kể từ ngày ký."},
+			{Name: "hd-02.txt", Content: "Người lao động làm việc ngay, không có thử việc."},
+			{Content: "LỖI"},
+		},
+		Checklist: checklist,
+	})
+	var job ReviewJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil || rec.Code != http.StatusAccepted || rec.Header().Get("Location") != "/api/review-jobs/"+job.ID {
+		t.Fatalf("submit = %d %s, want 202 with the job", rec.Code, rec.Body.String())
+	}
+	if job.Progress.Total != 6 || len(job.Scorecards) != 3 || job.Scorecards[2].Document != "document-3" || job.Scorecards[0].Findings[0].Verdict != VerdictPending {
+		t.Errorf("job = %+v, want 6 pending questions over 3 documents", job)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != JobSucceeded && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/review-jobs/"+job.ID, nil).Body.Bytes(), &job)
+	}
+	if job.Status != JobSucceeded || job.Progress.Completed != 6 || job.Progress.Errors != 2 || job.FinishedAt == nil {
+		t.Fatalf("job = %+v, want every question answered", job)
+	}
+	for i, want := range []struct {
+		verdicts []ReviewVerdict
+		score    float64
+	}{
+		{[]ReviewVerdict{VerdictPass, VerdictUnclear}, 1},
+		{[]ReviewVerdict{VerdictFail, VerdictUnclear}, 0},
+	} {
+		card := job.Scorecards[i]
+		if card.Findings[0].Verdict != want.verdicts[0] || card.Findings[1].Verdict != want.verdicts[1] || card.Score == nil || *card.Score != want.score || card.Findings[0].HistoryID == "" {
+			t.Errorf("scorecard %d = %+v, want %v scoring %v", i, card, want.verdicts, want.score)
+		}
+	}
+	if card := job.Scorecards[2]; card.Errors != 2 || card.Score != nil || card.Findings[0].Error == nil || card.Findings[0].Error.Code != ErrCodeEngineError {
+		t.Errorf("failed scorecard = %+v, want engine errors and no score", card)
+	}
+
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/review-jobs/"+job.ID+"/report", nil)
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(rec.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil || rec.Code != http.StatusOK || len(rows) != 7 {
+		t.Fatalf("report = %d %q, want a header and 6 rows", rec.Code, rec.Body.String())
+	}
+	if row := rows[1]; row[0] != "hd-01.txt" || row[2] != "yes" || row[3] != "pass" || row[4] != "1.00" {
+		t.Errorf("report row = %q", row)
+	}
+
+	var list struct {
+		ReviewJobs []ReviewJob `json:"review_jobs"`
+	}
+	json.Unmarshal(doAs(t, h, "lan", http.MethodGet, "/api/review-jobs", nil).Body.Bytes(), &list)
+	if len(list.ReviewJobs) != 1 || list.ReviewJobs[0].Scorecards != nil {
+		t.Errorf("list = %+v, want the job without its scorecards", list.ReviewJobs)
+	}
+
+	for name, body := range map[string]ReviewJobRequest{
+		"no documents":     {Checklist: checklist},
+		"invalid expected": {Documents: []AttachmentInput{{Content: "x"}}, Checklist: []ChecklistItem{{Question: checklist[0].Question, Expected: "maybe"}}},
+	} {
+		if code := decodeError(t, doAs(t, h, "lan", http.MethodPost, "/api/review-jobs", body)).Code; code != ErrCodeInvalidRequest {
+			t.Errorf("%s = %s, want %s", name, code, ErrCodeInvalidRequest)
+		}
+	}
+	rec = doAs(t, h, "lan", http.MethodPost, "/api/review-jobs", ReviewJobRequest{Documents: []AttachmentInput{{ID: "att_missing"}}, Checklist: checklist})
+	if code := decodeError(t, rec).Code; code != ErrCodeAttachmentNotFound {
+		t.Errorf("unknown attachment = %s, want %s", code, ErrCodeAttachmentNotFound)
+	}
+	if code := decodeError(t, doAs(t, h, "lan", http.MethodGet, "/api/review-jobs/rj_missing", nil)).Code; code != ErrCodeReviewJobNotFound {
+		t.Errorf("unknown job = %s, want %s", code, ErrCodeReviewJobNotFound)
+	}
+}
+
+func TestReviewJobMaxSize(t *testing.T) {
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+	config := srv.config
+	req := ReviewJobRequest{Name: "Rà soát toàn bộ hợp đồng"}
+	for range config.ReviewJobs.MaxDocuments {
+		req.Documents = append(req.Documents, AttachmentInput{Content: strings.Repeat("a", config.Attachments.MaxBytes)})
+	}
+	question := []rune(strings.Repeat("Hợp đồng có quy định thời gian thử việc không? ", config.QueryCaps.MaxQuestionLength))
+	for range config.ReviewJobs.MaxQuestions {
+		req.Checklist = append(req.Checklist, ChecklistItem{Question: string(question[:config.QueryCaps.MaxQuestionLength])})
+	}
+	body, _ := json.Marshal(req)
+	if len(body) <= config.BodyLimits.MaxBytes {
+		t.Fatalf("review job of %d bytes fits MAX_BODY_BYTES=%d; the test proves nothing", len(body), config.BodyLimits.MaxBytes)
+	}
+
+	rec := doAs(t, srv.Handler(), "lan", http.MethodPost, "/api/review-jobs", req)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("review job at every limit (%d bytes) = %d %.200s, want 202", len(body), rec.Code, rec.Body.String())
+	}
+}
+
+func TestAnswerPolarity(t *testing.T) {
+	for answer, want := range map[string]string{
+		"Có, hợp đồng quy định rõ.": "yes",
+		"**Có.** Theo Điều 25":      "yes",
+		"Không.":                    "no",
+		"No, it does not.":          "no",
+		"Chưa":                      "no",
+		"Không quá 180 ngày.":       "",
+		"Có thể, tùy thỏa thuận.":   "",
+		"Theo Điều 25 ...":          "",
+	} {
+		if got := answerPolarity(answer); got != want {
+			t.Errorf("answerPolarity(%q) = %q, want %q", answer, got, want)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewState is the stage of an answer in the internal review workflow
+type ReviewState string
+
+const (
+	ReviewDraft    ReviewState = "draft"
+	ReviewReviewed ReviewState = "reviewed"
+	ReviewApproved ReviewState = "approved"
+)
+
+// reviewTransitions lists the states each state can move to. Answers are
+// reviewed before a senior lawyer approves them; sending an answer back or
+// revoking an approval returns it to draft.
+var reviewTransitions = map[ReviewState][]ReviewState{
+	ReviewDraft:    {ReviewReviewed},
+	ReviewReviewed: {ReviewApproved, ReviewDraft},
+	ReviewApproved: {ReviewDraft},
+}
+
+const maxCommentLen = 10000
+
+// Review is the review state and discussion of an answer in the history
+type Review struct {
+	HistoryID string        `json:"history_id"`
+	TenantID  string        `json:"tenant_id,omitempty"`
+	State     ReviewState   `json:"state"`
+	Comments  []Comment     `json:"comments,omitempty"`
+	Events    []ReviewEvent `json:"events,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	// Signature signs the answer as approved; it is dropped when the
+	// approval is revoked
+	Signature *AnswerSignature `json:"signature,omitempty"`
+}
+
+// Comment is a comment on an answer; replies name their parent comment
+type Comment struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommentThread is a comment with its replies
+type CommentThread struct {
+	Comment
+	Replies []CommentThread `json:"replies,omitempty"`
+}
+
+// ReviewEvent records a change of review state
+type ReviewEvent struct {
+	From ReviewState `json:"from"`
+	To   ReviewState `json:"to"`
+	By   string      `json:"by,omitempty"`
+	Note string      `json:"note,omitempty"`
+	At   time.Time   `json:"at"`
+}
+
+// approval returns the event that approved the answer, if it is approved
+func (r Review) approval() (ReviewEvent, bool) {
+	if r.State != ReviewApproved || len(r.Events) == 0 {
+		return ReviewEvent{}, false
+	}
+	return r.Events[len(r.Events)-1], true
+}
+
+var (
+	errCommentNotFound   = errors.New("comment not found")
+	errNotCommentAuthor  = errors.New("only the author can delete a comment")
+	errReviewTransition  = errors.New("review transition not allowed")
+	errSeniorLawyersOnly = errors.New("only senior lawyers can approve answers or revoke approvals")
+)
+
+// ReviewStore keeps the reviews of answers, persisted to a JSON file when a
+// path is configured. Answers without a review are drafts.
+type ReviewStore struct {
+	mu      sync.RWMutex
+	path    string
+	reviews map[string]*Review
+}
+
+func NewReviewStore(path string) (*ReviewStore, error) {
+	store := &ReviewStore{
+		path:    path,
+		reviews: make(map[string]*Review),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var reviews []*Review
+	if _, err := readJSONFile(path, &reviews); err != nil {
+		return nil, fmt.Errorf("failed to load reviews: %w", err)
+	}
+	for _, r := range reviews {
+		store.reviews[r.HistoryID] = r
+	}
+	return store, nil
+}
+
+// Get returns the review of an answer owned by tenantID
+func (s *ReviewStore) Get(historyID, tenantID string) Review {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.reviews[historyID]; ok && r.TenantID == tenantID {
+		return *r
+	}
+	return Review{HistoryID: historyID, TenantID: tenantID, State: ReviewDraft}
+}
+
+// List returns the reviews of a tenant in a state, most recently updated
+// first
+func (s *ReviewStore) List(tenantID string, state ReviewState) []Review {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reviews := []Review{}
+	for _, r := range s.reviews {
+		if r.TenantID == tenantID && (state == "" || r.State == state) {
+			reviews = append(reviews, *r)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].UpdatedAt.After(reviews[j].UpdatedAt) })
+	return reviews
+}
+
+// Update applies fn to a copy of the review of an answer and persists the
+// result
+func (s *ReviewStore) Update(historyID, tenantID string, fn func(*Review) error) (Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := Review{HistoryID: historyID, TenantID: tenantID, State: ReviewDraft}
+	if current, ok := s.reviews[historyID]; ok && current.TenantID == tenantID {
+		updated = *current
+		updated.Comments = slices.Clone(current.Comments)
+		updated.Events = slices.Clone(current.Events)
+	}
+	if err := fn(&updated); err != nil {
+		return Review{}, err
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	s.reviews[historyID] = &updated
+	return updated, s.saveLocked()
+}
+
+func (s *ReviewStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	reviews := make([]*Review, 0, len(s.reviews))
+	for _, r := range s.reviews {
+		reviews = append(reviews, r)
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].HistoryID < reviews[j].HistoryID })
+	return writeJSONFile(s.path, reviews)
+}
+
+// transition moves a review to another state on behalf of a user
+func (r *Review) transition(to ReviewState, user, note string, senior bool) error {
+	if !slices.Contains(reviewTransitions[r.State], to) {
+		return fmt.Errorf("%w: %s to %s", errReviewTransition, r.State, to)
+	}
+	if (to == ReviewApproved || r.State == ReviewApproved) && !senior {
+		return errSeniorLawyersOnly
+	}
+	r.Events = append(r.Events, ReviewEvent{From: r.State, To: to, By: user, Note: note, At: time.Now().UTC()})
+	r.State = to
+	return nil
+}
+
+// commentThreads nests replies under their parent comments, oldest first
+func commentThreads(comments []Comment) []CommentThread {
+	replies := make(map[string][]Comment)
+	for _, c := range comments {
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+	}
+	var build func(parentID string) []CommentThread
+	build = func(parentID string) []CommentThread {
+		var threads []CommentThread
+		for _, c := range replies[parentID] {
+			threads = append(threads, CommentThread{Comment: c, Replies: build(c.ID)})
+		}
+		return threads
+	}
+	threads := build("")
+	if threads == nil {
+		threads = []CommentThread{}
+	}
+	return threads
+}
+
+// isSeniorLawyer reports whether the caller may approve answers: a senior
+// lawyer of the caller's tenant, or one of SENIOR_LAWYERS for callers
+// without a tenant. The caller is the user of its API key.
+func isSeniorLawyer(c *gin.Context, defaults []string) bool {
+	user := authenticatedUser(c)
+	if user == "" {
+		return false
+	}
+	seniors := defaults
+	if tenant, _ := callerTenant(c); tenant.ID != "" {
+		seniors = tenant.Settings.SeniorLawyers
+	}
+	return slices.Contains(seniors, user)
+}
+
+// Handlers
+
+// CommentRequest is the body of POST /api/history/:id/comments
+type CommentRequest struct {
+	Body     string `json:"body" binding:"required"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// ReviewRequest is the body of POST /api/history/:id/review
+type ReviewRequest struct {
+	State ReviewState `json:"state" binding:"required"`
+	Note  string      `json:"note,omitempty"`
+}
+
+// reviewedEntry loads the history entry a review route is about
+func reviewedEntry(c *gin.Context, history *HistoryStore) (HistoryEntry, bool) {
+	tenant, _ := callerTenant(c)
+	entry, err := history.Get(c.Param("id"), tenant.ID)
+	if err != nil {
+		abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", c.Param("id")))
+		return HistoryEntry{}, false
+	}
+	return entry, true
+}
+
+func getReviewHandler(reviews *ReviewStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		review := reviews.Get(entry.ID, entry.TenantID)
+		c.JSON(http.StatusOK, gin.H{
+			"history_id": review.HistoryID,
+			"state":      review.State,
+			"events":     review.Events,
+			"comments":   commentThreads(review.Comments),
+		})
+	}
+}
+
+func listCommentsHandler(reviews *ReviewStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"comments": commentThreads(reviews.Get(entry.ID, entry.TenantID).Comments)})
+	}
+}
+
+func addCommentHandler(reviews *ReviewStore, history *HistoryStore, notifications *NotificationCenter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CommentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		body := strings.TrimSpace(req.Body)
+		if body == "" || len(body) > maxCommentLen {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("body must be 1 to %d bytes", maxCommentLen))
+			return
+		}
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		author, ok := requireAuthenticatedUser(c, "Commenting")
+		if !ok {
+			return
+		}
+
+		comment := Comment{
+			ID:        "c_" + randomHex(8),
+			ParentID:  req.ParentID,
+			Author:    author,
+			Body:      body,
+			CreatedAt: time.Now().UTC(),
+		}
+		_, err := reviews.Update(entry.ID, entry.TenantID, func(r *Review) error {
+			if comment.ParentID != "" && !slices.ContainsFunc(r.Comments, func(c Comment) bool { return c.ID == comment.ParentID }) {
+				return errCommentNotFound
+			}
+			r.Comments = append(r.Comments, comment)
+			return nil
+		})
+		if err != nil {
+			abortWithReviewError(c, req.ParentID, err)
+			return
+		}
+		for _, user := range mentions(body) {
+			if user == comment.Author {
+				continue
+			}
+			notifications.Publish(Notification{
+				TenantID: entry.TenantID,
+				User:     user,
+				Kind:     NotificationMention,
+				Title:    fmt.Sprintf("%s mentioned you in a comment", cmp.Or(comment.Author, "Someone")),
+				Body:     truncateUTF8(body, 500),
+				Link:     "/api/history/" + entry.ID + "/comments",
+			})
+		}
+		c.JSON(http.StatusCreated, comment)
+	}
+}
+
+func deleteCommentHandler(reviews *ReviewStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		user, ok := requireAuthenticatedUser(c, "Deleting a comment")
+		if !ok {
+			return
+		}
+		id := c.Param("comment_id")
+		_, err := reviews.Update(entry.ID, entry.TenantID, func(r *Review) error {
+			i := slices.IndexFunc(r.Comments, func(c Comment) bool { return c.ID == id && !c.Deleted })
+			if i < 0 {
+				return errCommentNotFound
+			}
+			if r.Comments[i].Author != user {
+				return errNotCommentAuthor
+			}
+			// The comment stays as a placeholder so its replies keep their thread
+			r.Comments[i].Body = ""
+			r.Comments[i].Deleted = true
+			return nil
+		})
+		if err != nil {
+			abortWithReviewError(c, id, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func transitionReviewHandler(reviews *ReviewStore, history *HistoryStore, edits *EditStore, seniorLawyers []string, signer *AnswerSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if _, ok := reviewTransitions[req.State]; !ok {
+			abortWithInvalidParam(c, "state", fmt.Sprintf("state must be %s, %s or %s", ReviewDraft, ReviewReviewed, ReviewApproved))
+			return
+		}
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+
+		user, ok := requireAuthenticatedUser(c, "Moving a review")
+		if !ok {
+			return
+		}
+		senior := isSeniorLawyer(c, seniorLawyers)
+		review, err := reviews.Update(entry.ID, entry.TenantID, func(r *Review) error {
+			if err := r.transition(req.State, user, strings.TrimSpace(req.Note), senior); err != nil {
+				return err
+			}
+			r.Signature = nil
+			if approval, ok := r.approval(); ok {
+				sig, err := signer.Sign(signedAnswer(edits.Current(entry), approval))
+				if err != nil {
+					return fmt.Errorf("%w: %w", errSignAnswer, err)
+				}
+				r.Signature = &sig
+			}
+			return nil
+		})
+		if err != nil {
+			abortWithReviewError(c, "", err)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Review moved", "history_id", entry.ID, "state", review.State, "user", user)
+		c.JSON(http.StatusOK, gin.H{
+			"history_id": review.HistoryID,
+			"state":      review.State,
+			"events":     review.Events,
+			"signature":  review.Signature,
+		})
+	}
+}
+
+// listReviewsHandler is the review queue: the caller's reviews, optionally
+// in one state
+func listReviewsHandler(reviews *ReviewStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := ReviewState(c.Query("state"))
+		if _, ok := reviewTransitions[state]; state != "" && !ok {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("state must be %s, %s or %s", ReviewDraft, ReviewReviewed, ReviewApproved))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		type summary struct {
+			HistoryID string      `json:"history_id"`
+			State     ReviewState `json:"state"`
+			Comments  int         `json:"comments"`
+			UpdatedAt time.Time   `json:"updated_at"`
+		}
+		summaries := []summary{}
+		for _, r := range reviews.List(tenant.ID, state) {
+			summaries = append(summaries, summary{r.HistoryID, r.State, len(r.Comments), r.UpdatedAt})
+		}
+		c.JSON(http.StatusOK, gin.H{"reviews": summaries})
+	}
+}
+
+// abortWithReviewError maps a ReviewStore error onto the error catalog
+func abortWithReviewError(c *gin.Context, commentID string, err error) {
+	switch {
+	case errors.Is(err, errCommentNotFound):
+		abortWithError(c, ErrCodeCommentNotFound, fmt.Sprintf("Comment %q not found", commentID))
+	case errors.Is(err, errNotCommentAuthor), errors.Is(err, errSeniorLawyersOnly):
+		abortWithError(c, ErrCodeForbidden, err.Error())
+	case errors.Is(err, errReviewTransition):
+		abortWithError(c, ErrCodeReviewConflict, err.Error())
+	case errors.Is(err, errSignAnswer):
+		slog.ErrorContext(c.Request.Context(), "Failed to sign approval", "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to sign the approval")
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to save reviews", "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to save review")
+	}
+}
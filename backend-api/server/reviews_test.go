@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestReviewWorkflow(t *testing.T) {
+	t.Setenv("SENIOR_LAWYERS", "minh")
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{{Excerpt: "Điều 25. Thời gian thử việc", Title: "Thời gian thử việc"}},
+		Iterations:    1,
+	}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+	lan, minh := userKey(t, h, "lan"), userKey(t, h, "minh")
+
+	ask := func(question string) string {
+		t.Helper()
+		var answer engine.LegalQueryResponse
+		rec := doWithKey(t, h, lan, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+		if err := json.Unmarshal(rec.Body.Bytes(), &answer); err != nil || answer.HistoryID == "" {
+			t.Fatalf("query = %d %s, want a history entry", rec.Code, rec.Body.String())
+		}
+		return answer.HistoryID
+	}
+	approvedID := ask("Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?")
+	draftID := ask("Người lao động có được hưởng lương trong thời gian thử việc theo Bộ luật Lao động 2019 không?")
+
+	// Threaded comments
+	comments := "/api/history/" + approvedID + "/comments"
+	rec := doWithKey(t, h, lan, http.MethodPost, comments, CommentRequest{Body: "Cần dẫn thêm Điều 24"})
+	var root Comment
+	if err := json.Unmarshal(rec.Body.Bytes(), &root); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("comment = %d %s", rec.Code, rec.Body.String())
+	}
+	doWithKey(t, h, minh, http.MethodPost, comments, CommentRequest{Body: "Đồng ý", ParentID: root.ID})
+	rec = doWithKey(t, h, minh, http.MethodPost, comments, CommentRequest{Body: "?", ParentID: "c_missing"})
+	if code := decodeError(t, rec).Code; code != ErrCodeCommentNotFound {
+		t.Errorf("reply to a missing comment = %s, want %s", code, ErrCodeCommentNotFound)
+	}
+	rec = doWithKey(t, h, minh, http.MethodDelete, comments+"/"+root.ID, nil)
+	if code := decodeError(t, rec).Code; code != ErrCodeForbidden {
+		t.Errorf("deleting another user's comment = %s, want %s", code, ErrCodeForbidden)
+	}
+	var listed struct {
+		Comments []CommentThread `json:"comments"`
+	}
+	json.Unmarshal(doWithKey(t, h, lan, http.MethodGet, comments, nil).Body.Bytes(), &listed)
+	if len(listed.Comments) != 1 || len(listed.Comments[0].Replies) != 1 || listed.Comments[0].Replies[0].Author != "minh" {
+		t.Fatalf("comments = %+v, want one thread with minh's reply", listed.Comments)
+	}
+
+	// Review state machine
+	review := "/api/history/" + approvedID + "/review"
+	rec = doWithKey(t, h, minh, http.MethodPost, review, ReviewRequest{State: ReviewApproved})
+	if code := decodeError(t, rec).Code; code != ErrCodeReviewConflict {
+		t.Errorf("draft to approved = %s, want %s", code, ErrCodeReviewConflict)
+	}
+	if rec = doWithKey(t, h, lan, http.MethodPost, review, ReviewRequest{State: ReviewReviewed}); rec.Code != http.StatusOK {
+		t.Fatalf("review status = %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doWithKey(t, h, lan, http.MethodPost, review, ReviewRequest{State: ReviewApproved})
+	if code := decodeError(t, rec).Code; code != ErrCodeForbidden {
+		t.Errorf("approval by a junior = %s, want %s", code, ErrCodeForbidden)
+	}
+	// Senior lawyers are the users of API keys, so claiming one in X-User-ID approves nothing
+	if rec = doAs(t, h, "minh", http.MethodPost, review, ReviewRequest{State: ReviewApproved}); rec.Code != http.StatusForbidden {
+		t.Errorf("approval claimed for minh = %d, want 403", rec.Code)
+	}
+	if rec = doWithKey(t, h, minh, http.MethodPost, review, ReviewRequest{State: ReviewApproved, Note: "OK"}); rec.Code != http.StatusOK {
+		t.Fatalf("approve status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var queue struct {
+		Reviews []struct {
+			HistoryID string `json:"history_id"`
+		} `json:"reviews"`
+	}
+	json.Unmarshal(doWithKey(t, h, lan, http.MethodGet, "/api/reviews?state=approved", nil).Body.Bytes(), &queue)
+	if len(queue.Reviews) != 1 || queue.Reviews[0].HistoryID != approvedID {
+		t.Errorf("approved queue = %+v, want %s", queue.Reviews, approvedID)
+	}
+
+	// Only approved answers can be shared, and only while they stay approved
+	rec = doWithKey(t, h, lan, http.MethodPost, "/api/shares", CreateShareRequest{HistoryIDs: []string{approvedID, draftID}})
+	if code := decodeError(t, rec).Code; code != ErrCodeReviewConflict {
+		t.Errorf("sharing a draft = %s, want %s", code, ErrCodeReviewConflict)
+	}
+	rec = doWithKey(t, h, lan, http.MethodPost, "/api/shares", CreateShareRequest{HistoryIDs: []string{approvedID}, Title: "Thử việc"})
+	var share Share
+	if err := json.Unmarshal(rec.Body.Bytes(), &share); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("share = %d %s", rec.Code, rec.Body.String())
+	}
+	var shared struct {
+		Answers []SharedAnswer `json:"answers"`
+	}
+	json.Unmarshal(doAs(t, h, "", http.MethodGet, "/api/shared/"+share.ID, nil).Body.Bytes(), &shared)
+	if len(shared.Answers) != 1 || shared.Answers[0].ApprovedBy != "minh" || shared.Answers[0].Answer != stub.resp.Answer {
+		t.Fatalf("shared answers = %+v, want the approved answer", shared.Answers)
+	}
+
+	// Clients can verify the approval signature
+	sig := shared.Answers[0].Signature
+	if sig == nil {
+		t.Fatal("shared answer is not signed")
+	}
+	var verified struct {
+		Valid        bool         `json:"valid"`
+		SignedAnswer SignedAnswer `json:"signed_answer"`
+	}
+	json.Unmarshal(doAs(t, h, "", http.MethodPost, "/api/signatures/verify", sig).Body.Bytes(), &verified)
+	if !verified.Valid || verified.SignedAnswer.Answer != stub.resp.Answer || verified.SignedAnswer.ApprovedBy != "minh" {
+		t.Errorf("verify = %+v, want a valid signature over the approved answer", verified)
+	}
+	forged := *sig
+	forged.Payload = base64.StdEncoding.EncodeToString([]byte(`{"answer":"Không quá 180 ngày."}`))
+	json.Unmarshal(doAs(t, h, "", http.MethodPost, "/api/signatures/verify", forged).Body.Bytes(), &verified)
+	if verified.Valid {
+		t.Error("verify accepted a tampered payload")
+	}
+	var current struct {
+		Valid          bool `json:"valid"`
+		MatchesHistory bool `json:"matches_history"`
+	}
+	json.Unmarshal(doWithKey(t, h, lan, http.MethodGet, "/api/history/"+approvedID+"/signature", nil).Body.Bytes(), &current)
+	if !current.Valid || !current.MatchesHistory {
+		t.Errorf("answer signature = %+v, want valid and matching the history", current)
+	}
+
+	doWithKey(t, h, minh, http.MethodPost, review, ReviewRequest{State: ReviewDraft, Note: "Luật mới"})
+	shared.Answers = nil
+	json.Unmarshal(doAs(t, h, "", http.MethodGet, "/api/shared/"+share.ID, nil).Body.Bytes(), &shared)
+	if len(shared.Answers) != 0 {
+		t.Errorf("shared answers = %+v, want none after the approval was revoked", shared.Answers)
+	}
+
+	doWithKey(t, h, lan, http.MethodDelete, "/api/shares/"+share.ID, nil)
+	rec = doAs(t, h, "", http.MethodGet, "/api/shared/"+share.ID, nil)
+	if code := decodeError(t, rec).Code; code != ErrCodeShareNotFound {
+		t.Errorf("revoked share = %s, want %s", code, ErrCodeShareNotFound)
+	}
+}
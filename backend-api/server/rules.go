@@ -0,0 +1,232 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+var ruleIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Rule answers questions that have an exact statutory answer, such as a fee
+// amount or a filing deadline, without calling the engine. A question
+// matches when one of the patterns matches it and none of the exclusions
+// does; patterns are case-insensitive regular expressions.
+type Rule struct {
+	ID        string         `yaml:"id"`
+	Patterns  []string       `yaml:"patterns"`
+	Exclude   []string       `yaml:"exclude"`
+	Answer    string         `yaml:"answer"`
+	Citations []RuleCitation `yaml:"citations"`
+
+	// Language is the language of the answer, vi by default; the rule
+	// answers only questions asked in it
+	Language string `yaml:"language"`
+
+	// Collections limits the rule to questions searching these document
+	// collections or the default one
+	Collections []string `yaml:"collections"`
+
+	patterns []*regexp.Regexp
+	exclude  []*regexp.Regexp
+}
+
+// RuleCitation is a provision a rule's answer rests on. It is returned as a
+// search result, so it gets official links like retrieved provisions.
+type RuleCitation struct {
+	DocumentID string `yaml:"document_id"`
+	ArticleID  string `yaml:"article_id"`
+	Title      string `yaml:"title"`
+	Text       string `yaml:"text"`
+}
+
+// RuleSet is an ordered list of rules; the first match wins
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules"`
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+// LoadRules reads the rules of a YAML file; without a path there are none
+func LoadRules(path string) (*RuleSet, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	return parseRules(data)
+}
+
+func parseRules(data []byte) (*RuleSet, error) {
+	set := &RuleSet{hits: make(map[string]int)}
+	if err := yaml.UnmarshalWithOptions(data, set, yaml.Strict()); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules: %w", err)
+	}
+
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var compiled []*regexp.Regexp
+		for _, p := range patterns {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	seen := make(map[string]bool)
+	for i, rule := range set.Rules {
+		if rule == nil || !ruleIDPattern.MatchString(rule.ID) {
+			return nil, fmt.Errorf("rule %d: id must be 1-64 lowercase letters, digits, '-' or '_'", i+1)
+		}
+		if seen[rule.ID] {
+			return nil, fmt.Errorf("rule %q is defined twice", rule.ID)
+		}
+		seen[rule.ID] = true
+		if rule.Language == "" {
+			rule.Language = "vi"
+		}
+		var err error
+		switch {
+		case len(rule.Patterns) == 0:
+			err = errors.New("at least one pattern is required")
+		case strings.TrimSpace(rule.Answer) == "":
+			err = errors.New("answer must not be empty")
+		case rule.Language != "vi" && rule.Language != "en":
+			err = fmt.Errorf("language must be vi or en, got %q", rule.Language)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.ID, err)
+		}
+		if rule.patterns, err = compile(rule.Patterns); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid pattern: %w", rule.ID, err)
+		}
+		if rule.exclude, err = compile(rule.Exclude); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid exclusion: %w", rule.ID, err)
+		}
+	}
+	return set, nil
+}
+
+func (r *Rule) matches(req *engine.PythonQueryRequest, question string) bool {
+	switch {
+	case cmp.Or(req.Language, "vi") != r.Language:
+		return false
+	case req.Collection != "" && len(r.Collections) > 0 && !slices.Contains(r.Collections, req.Collection):
+		return false
+	}
+	matched := slices.ContainsFunc(r.patterns, func(re *regexp.Regexp) bool { return re.MatchString(question) })
+	return matched && !slices.ContainsFunc(r.exclude, func(re *regexp.Regexp) bool { return re.MatchString(question) })
+}
+
+// Match returns the first rule answering the question. Questions about
+// context documents or private documents, or following up a conversation,
+// are left to the engine, as their answer depends on more than the question.
+func (s *RuleSet) Match(req *engine.PythonQueryRequest) (*Rule, bool) {
+	if len(req.ContextDocuments) > 0 || len(req.History) > 0 || req.Namespace != "" {
+		return nil, false
+	}
+	question := strings.Join(strings.Fields(req.Question), " ")
+	for _, rule := range s.Rules {
+		if rule.matches(req, question) {
+			s.mu.Lock()
+			s.hits[rule.ID]++
+			s.mu.Unlock()
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// response is the answer of the rule, with its citations as search results
+func (r *Rule) response(req *engine.PythonQueryRequest) *engine.LegalQueryResponse {
+	resp := &engine.LegalQueryResponse{
+		Answer:        strings.TrimSpace(r.Answer),
+		SearchResults: []engine.DocumentHit{},
+		WebResults:    []engine.WebHit{},
+		QueryUsed:     req.Question,
+		Rule:          r.ID,
+	}
+	for _, c := range r.Citations {
+		resp.SearchResults = append(resp.SearchResults, engine.DocumentHit{
+			Title:         c.Title,
+			ArticleNumber: c.ArticleID,
+			Excerpt:       c.Text,
+			Score:         1.0,
+			ContentType:   "regulation",
+			DocumentID:    c.DocumentID,
+			SourceType:    "rule",
+		})
+	}
+	return resp
+}
+
+// RuleSummary describes a rule and how often it answered
+type RuleSummary struct {
+	ID        string   `json:"id"`
+	Patterns  []string `json:"patterns"`
+	Language  string   `json:"language"`
+	Citations int      `json:"citations"`
+	Hits      int      `json:"hits"`
+}
+
+// Summaries lists the rules in order
+func (s *RuleSet) Summaries() []RuleSummary {
+	summaries := []RuleSummary{}
+	if s == nil {
+		return summaries
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rule := range s.Rules {
+		summaries = append(summaries, RuleSummary{
+			ID:        rule.ID,
+			Patterns:  rule.Patterns,
+			Language:  rule.Language,
+			Citations: len(rule.Citations),
+			Hits:      s.hits[rule.ID],
+		})
+	}
+	return summaries
+}
+
+// ruleEngine answers questions matching a rule itself and passes the others
+// to the next engine
+type ruleEngine struct {
+	rules *RuleSet
+	next  engine.QueryEngine
+}
+
+func (e *ruleEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *ruleEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	if rule, ok := e.rules.Match(req); ok {
+		return rule.response(req), nil
+	}
+	return engine.QueryWithContext(ctx, e.next, req)
+}
+
+// Handlers
+
+func listRulesHandler(rules *RuleSet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rules": rules.Summaries()})
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const testRules = `
+rules:
+  - id: le-phi-dang-ky-ket-hon
+    patterns:
+      - "lệ phí đăng ký kết hôn"
+      - "đăng ký kết hôn.*(mất|tốn|bao nhiêu) (tiền|phí)"
+    exclude:
+      - "nước ngoài"
+    answer: |
+      Đăng ký kết hôn giữa công dân Việt Nam cư trú ở trong nước được miễn lệ phí.
+    citations:
+      - document_id: NghiDinh123-2015
+        article_id: Dieu_11
+        title: Miễn lệ phí đăng ký hộ tịch
+        text: Miễn lệ phí đăng ký hộ tịch trong những trường hợp sau đây ...
+  - id: marriage-fee
+    language: en
+    patterns: ["marriage registration fee"]
+    answer: Marriage registration between Vietnamese citizens residing in Vietnam is free of charge.
+`
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules([]byte(testRules))
+	if err != nil || len(rules.Rules) != 2 || rules.Rules[0].Language != "vi" {
+		t.Fatalf("parseRules = %+v, %v", rules, err)
+	}
+	for name, data := range map[string]string{
+		"no pattern":       "rules:\n  - id: a\n    answer: x\n",
+		"no answer":        "rules:\n  - id: a\n    patterns: [x]\n",
+		"duplicate id":     "rules:\n  - id: a\n    patterns: [x]\n    answer: x\n  - id: a\n    patterns: [y]\n    answer: y\n",
+		"invalid id":       "rules:\n  - id: Phí A\n    patterns: [x]\n    answer: x\n",
+		"invalid pattern":  "rules:\n  - id: a\n    patterns: [\"(\"]\n    answer: x\n",
+		"unknown field":    "rules:\n  - id: a\n    pattern: x\n    answer: x\n",
+		"unknown language": "rules:\n  - id: a\n    patterns: [x]\n    answer: x\n    language: fr\n",
+	} {
+		if _, err := parseRules([]byte(data)); err == nil {
+			t.Errorf("%s: parseRules succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRuleAnswers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(testRules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RULES_FILE", path)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo quy định...", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	query := func(req LegalQueryRequest) engine.LegalQueryResponse {
+		t.Helper()
+		var resp engine.LegalQueryResponse
+		rec := doJSON(t, h, http.MethodPost, "/api/legal-query", req)
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+
+	resp := query(LegalQueryRequest{Question: "Đăng ký  kết hôn tốn bao nhiêu tiền?"})
+	if resp.Rule != "le-phi-dang-ky-ket-hon" || !strings.HasPrefix(resp.Answer, "Đăng ký kết hôn") || len(stub.requests) != 0 {
+		t.Fatalf("response = %+v, want the rule's answer without calling the engine", resp)
+	}
+	if resp.Meta == nil || !slices.Contains(resp.Meta.Features, FeatureRule) || len(resp.SearchResults) != 1 || resp.HistoryID == "" {
+		t.Errorf("response = %+v, want the rule feature, its citation and a history entry", resp)
+	}
+
+	for name, req := range map[string]LegalQueryRequest{
+		"excluded":       {Question: "Lệ phí đăng ký kết hôn với người nước ngoài là bao nhiêu?"},
+		"other language": {Question: "Lệ phí đăng ký kết hôn là bao nhiêu?", Language: "en"},
+		"no match":       {Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày?"},
+	} {
+		before := len(stub.requests)
+		if resp := query(req); resp.Rule != "" || len(stub.requests) != before+1 {
+			t.Errorf("%s: rule = %q, want the engine to answer", name, resp.Rule)
+		}
+	}
+	if resp := query(LegalQueryRequest{Question: "What is the marriage registration fee?", Language: "en"}); resp.Rule != "marriage-fee" {
+		t.Errorf("english rule = %q, want marriage-fee", resp.Rule)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/rules", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var list struct {
+		Rules []RuleSummary `json:"rules"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Rules) != 2 || list.Rules[0].Hits != 1 || list.Rules[1].Hits != 1 {
+		t.Errorf("rules = %d %s, want a hit each", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+//go:embed fixtures/canned_responses.json
+var cannedResponsesJSON []byte
+
+// Fixture maps a question pattern to a canned engine response
+type Fixture struct {
+	Name     string                    `json:"name"`
+	Pattern  string                    `json:"pattern"`
+	Response engine.LegalQueryResponse `json:"response"`
+
+	re *regexp.Regexp
+}
+
+// FixtureSet is an ordered list of fixtures; the first match wins
+type FixtureSet struct {
+	Fixtures []Fixture `json:"fixtures"`
+}
+
+func parseFixtures(data []byte) (*FixtureSet, error) {
+	var set FixtureSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fixtures: %w", err)
+	}
+
+	for i := range set.Fixtures {
+		re, err := regexp.Compile("(?i)" + set.Fixtures[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for fixture %q: %w", set.Fixtures[i].Name, err)
+		}
+		set.Fixtures[i].re = re
+	}
+
+	return &set, nil
+}
+
+// Match returns the response of the first fixture matching the question
+func (s *FixtureSet) Match(question string) (*engine.LegalQueryResponse, bool) {
+	for _, fixture := range s.Fixtures {
+		if fixture.re.MatchString(question) {
+			resp := fixture.Response
+			return &resp, true
+		}
+	}
+	return nil, false
+}
+
+// Answer matches the question against the fixtures and shapes the canned
+// response by the request parameters, so the answer follows what the caller
+// asked for
+func (s *FixtureSet) Answer(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, bool) {
+	resp, ok := s.Match(req.Question)
+	if !ok {
+		return nil, false
+	}
+
+	if len(resp.SearchResults) > req.TopK {
+		resp.SearchResults = resp.SearchResults[:req.TopK]
+	}
+	if resp.SearchResults == nil {
+		resp.SearchResults = []engine.DocumentHit{}
+	}
+	if !req.EnableWebSearch || resp.WebResults == nil {
+		resp.WebResults = []engine.WebHit{}
+	}
+	resp.Iterations = min(resp.Iterations, req.MaxIterations)
+	if resp.QueryUsed == "" {
+		resp.QueryUsed = req.Question
+	}
+
+	return resp, true
+}
+
+// SandboxEngine answers queries from canned fixtures without calling the
+// Python engine
+type SandboxEngine struct {
+	fixtures *FixtureSet
+}
+
+func NewSandboxEngine() (*SandboxEngine, error) {
+	fixtures, err := parseFixtures(cannedResponsesJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &SandboxEngine{fixtures: fixtures}, nil
+}
+
+func (e *SandboxEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	resp, ok := e.fixtures.Answer(req)
+	if !ok {
+		return nil, fmt.Errorf("no sandbox fixture matches question")
+	}
+	resp.Sandbox = true
+
+	return resp, nil
+}
+
+const sandboxContextKey = "sandbox"
+
+// sandboxMiddleware flags requests that must be served by the sandbox engine,
+// either because sandbox mode is forced by configuration or because the
+// client sent an X-Sandbox: true header
+func sandboxMiddleware(forced bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sandbox := forced
+		if header := c.GetHeader("X-Sandbox"); header != "" {
+			if enabled, err := strconv.ParseBool(header); err == nil && enabled {
+				sandbox = true
+			}
+		}
+
+		if sandbox {
+			c.Set(sandboxContextKey, true)
+			c.Header("X-Sandbox", "true")
+		}
+		c.Next()
+	}
+}
+
+func isSandboxRequest(c *gin.Context) bool {
+	return c.GetBool(sandboxContextKey)
+}
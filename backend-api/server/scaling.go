@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// ScalingConfig controls the engine autoscaling signal
+type ScalingConfig struct {
+	// Capacity is the number of concurrent queries one engine replica handles
+	Capacity int
+	Webhook  string
+	Interval time.Duration
+}
+
+// ScalingSignal describes the pressure the backend puts on the engine, for
+// autoscaling the engine deployment
+type ScalingSignal struct {
+	Timestamp       time.Time `json:"timestamp"`
+	InFlight        int       `json:"in_flight"`
+	Peak            int       `json:"peak"`
+	Capacity        int       `json:"capacity"`
+	Utilization     float64   `json:"utilization"`
+	DesiredReplicas int       `json:"desired_replicas"`
+	QueriesInWindow int       `json:"queries_in_window"`
+	WindowSeconds   int       `json:"window_seconds"`
+}
+
+// pressureEngine counts the engine queries in flight. Peak and query counts
+// cover the current window, which starts over at every Signal.
+type pressureEngine struct {
+	next     engine.QueryEngine
+	capacity int
+
+	mu          sync.Mutex
+	inFlight    int
+	peak        int
+	queries     int
+	windowStart time.Time
+	lastQuery   time.Time
+}
+
+func newPressureEngine(next engine.QueryEngine, capacity int) *pressureEngine {
+	return &pressureEngine{next: next, capacity: capacity, windowStart: time.Now()}
+}
+
+func (e *pressureEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *pressureEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	e.mu.Lock()
+	e.inFlight++
+	e.queries++
+	e.peak = max(e.peak, e.inFlight)
+	e.lastQuery = time.Now()
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		e.inFlight--
+		e.mu.Unlock()
+	}()
+	return engine.QueryWithContext(ctx, e.next, req)
+}
+
+// LastQuery returns when the last engine query started
+func (e *pressureEngine) LastQuery() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastQuery
+}
+
+// Signal returns the current pressure and, when reset is set, starts a new
+// window. Desired replicas are sized for the window's peak so that short
+// bursts between two signals are not missed.
+func (e *pressureEngine) Signal(reset bool) ScalingSignal {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	signal := ScalingSignal{
+		Timestamp:       now.UTC(),
+		InFlight:        e.inFlight,
+		Peak:            e.peak,
+		Capacity:        e.capacity,
+		Utilization:     math.Round(float64(e.peak)/float64(e.capacity)*100) / 100,
+		DesiredReplicas: max(1, (e.peak+e.capacity-1)/e.capacity),
+		QueriesInWindow: e.queries,
+		WindowSeconds:   int(now.Sub(e.windowStart).Seconds()),
+	}
+	if reset {
+		e.peak = e.inFlight
+		e.queries = 0
+		e.windowStart = now
+	}
+	return signal
+}
+
+// publishScalingSignals closes a window every interval until stop is closed,
+// posting its signal to url when one is configured
+func publishScalingSignals(pressure *pressureEngine, url string, interval time.Duration, stop <-chan struct{}) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			signal := pressure.Signal(true)
+			if url == "" {
+				continue
+			}
+			if err := postScalingSignal(client, url, signal); err != nil {
+				slog.Warn("Failed to publish scaling signal", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func postScalingSignal(client *http.Client, url string, signal ScalingSignal) error {
+	body, err := json.Marshal(signal)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scaling signal: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send scaling signal: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("scaling webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handlers
+
+// scalingSignalHandler serves the current signal, e.g. to a KEDA metrics-api
+// scaler. Reading it does not start a new window, so it can be polled
+// alongside the webhook.
+func scalingSignalHandler(pressure *pressureEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, pressure.Signal(false))
+	}
+}
@@ -0,0 +1,958 @@
+// Package server is the legal RAG backend API. NewServer builds it from a
+// Config so that other Go services can embed it, add routes to its router
+// and run their own middleware on every request.
+package server
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/document"
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/postgres"
+	"github.com/nguyenvothetuyen/legal-rag-backend/middleware"
+)
+
+// Options configure a Server
+type Options struct {
+	// Config is the server configuration, usually from LoadConfig
+	Config *Config
+
+	// Engine answers queries instead of the Python engine at
+	// Config.PythonEngineURL when set. Engine failover and hedging need an
+	// engine.ContextQueryEngine; the engine is health checked when it has a
+	// HealthCheck() error method, and serves the full text of cited articles
+	// for source exports when it is an engine.ArticleSource.
+	Engine engine.QueryEngine
+
+	// Middleware runs on every route after the built-in middleware, so it
+	// can read the caller's tenant and plan
+	Middleware []gin.HandlerFunc
+
+	// Signer signs approved answers instead of the key in
+	// Config.SigningKeyFile, e.g. with an HSM or KMS key
+	Signer crypto.Signer
+}
+
+// Server is the HTTP API with its stores and background work
+type Server struct {
+	config      *Config
+	router      *gin.Engine
+	healthCheck func() error
+	stop        chan struct{}
+	stopOnce    sync.Once
+
+	// adminRouter and metricsRouter serve /admin and /metrics on their own
+	// listeners; nil when those are served by router
+	adminRouter   *gin.Engine
+	metricsRouter *gin.Engine
+
+	// background is the background work Close waits for, so that none of
+	// it outlives the server or writes to DATA_DIR after Close
+	background sync.WaitGroup
+
+	// cancelEngine cancels the engine requests in flight when a shutdown
+	// gives up draining
+	cancelEngine context.CancelCauseFunc
+
+	// db keeps the history when DATABASE_URL is set
+	db *pgxpool.Pool
+}
+
+// healthChecker is an engine that can report its health
+type healthChecker interface {
+	HealthCheck() error
+}
+
+// NewServer builds the server and starts its background work. Routes can be
+// added to Router before Run; Close stops the background work.
+func NewServer(opts Options) (*Server, error) {
+	config := opts.Config
+	if config == nil {
+		return nil, fmt.Errorf("server config is required")
+	}
+
+	slog.Info("Starting Legal RAG Backend API")
+	if config.Profile != "" {
+		slog.Info("Profile", "profile", config.Profile)
+	}
+	if config.ConfigFile != "" {
+		slog.Info("Config file", "path", config.ConfigFile)
+	}
+	slog.Info("Server port", "port", config.ServerPort)
+	slog.Info("Python AI Engine URL", "url", config.PythonEngineURL)
+	slog.Info("Request timeout", "timeout", config.RequestTimeout.String())
+	slog.Info("Default plan", "plan", config.DefaultPlan.Name)
+	slog.Info("Sandbox mode", "enabled", config.SandboxMode)
+	slog.Info("Data directory", "path", config.DataDir)
+	slog.Info("Query caps", "max_iterations", config.QueryCaps.MaxIterations, "top_k", config.QueryCaps.MaxTopK, "question_length", config.QueryCaps.MaxQuestionLength)
+	slog.Info("Iteration policy", "mode", config.IterationPolicy.Mode, "min_novelty", config.IterationPolicy.MinNovelty,
+		"min_score_gain", config.IterationPolicy.MinScoreGain, "patience", config.IterationPolicy.Patience)
+	if !config.StageBudgets.IsZero() {
+		slog.Info("Stage budgets", "ms", config.StageBudgets.Header())
+	}
+	slog.Info("Egress allowlist", "hosts", config.ContextURLs.Allowlist)
+
+	s := &Server{config: config, stop: make(chan struct{})}
+	engineCtx, cancelEngine := context.WithCancelCause(context.Background())
+	s.cancelEngine = cancelEngine
+
+	// Initialize Python client, optionally recording or replaying cassettes
+	var transport http.RoundTripper
+	if config.CassetteMode != CassetteOff {
+		cassettes, err := newCassetteTransport(config.CassetteMode, config.CassetteDir, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up engine cassettes: %w", err)
+		}
+		transport = cassettes
+		slog.Info("Engine cassettes", "mode", config.CassetteMode, "dir", config.CassetteDir)
+	}
+	engineSigner := config.EngineSigning.signer()
+	if engineSigner != nil {
+		transport = newSigningTransport(engineSigner, transport)
+		slog.Info("Engine request signing enabled", "max_skew", config.EngineSigning.MaxSkew.String())
+	}
+	var faults *faultTransport
+	if config.FaultInjection {
+		faults = newFaultTransport(transport)
+		transport = faults
+		slog.Warn("Fault injection is available via the admin API; never enable it in production")
+	}
+	transport = &cancelTransport{next: transport, ctx: engineCtx}
+	rateLimiter := newRateLimiter(config.RateLimit)
+	reloader := newConfigReloader(config, rateLimiter)
+	s.background.Go(func() { reloader.watch(config.ConfigFile, config.ReloadInterval, s.stop) })
+	// Every engine client retries transient failures the same way, and has
+	// its own circuit breaker
+	var breakers []engineBreaker
+	newPythonClient := func(name, url string, announce bool) *engine.PythonClient {
+		client := engine.NewPythonClient(url, config.RequestTimeout, transport)
+		client.Retry = config.EngineRetry
+		reloader.addClient(client)
+		if config.Breaker.Threshold > 0 {
+			client.Breaker = engine.NewCircuitBreaker(config.Breaker.Threshold, config.Breaker.Cooldown)
+			breakers = append(breakers, engineBreaker{name: name, breaker: client.Breaker, announce: announce})
+		}
+		return client
+	}
+	pythonClient := newPythonClient(config.Regions.PrimaryName, config.PythonEngineURL, true)
+
+	primary := engine.QueryEngine(pythonClient)
+	articles := engine.ArticleSource(pythonClient)
+	documentIndex := engine.DocumentIndex(pythonClient)
+	retriever := engine.Retriever(pythonClient)
+	summarizer := engine.Summarizer(pythonClient)
+	s.healthCheck = pythonClient.HealthCheck
+	if opts.Engine != nil {
+		primary = opts.Engine
+		s.healthCheck = func() error { return nil }
+		if checker, ok := opts.Engine.(healthChecker); ok {
+			s.healthCheck = checker.HealthCheck
+		}
+		articles, _ = opts.Engine.(engine.ArticleSource)
+		documentIndex, _ = opts.Engine.(engine.DocumentIndex)
+		retriever, _ = opts.Engine.(engine.Retriever)
+		summarizer, _ = opts.Engine.(engine.Summarizer)
+		slog.Info("Engine", "type", fmt.Sprintf("%T", opts.Engine))
+	}
+
+	// In callback mode the primary engine queues queries itself and posts
+	// the answers back, which requests signed with the shared secret
+	var callbacks *callbackEngine
+	if config.EngineCallbacks.URL != "" {
+		switch {
+		case opts.Engine != nil:
+			slog.Warn("ENGINE_CALLBACK_URL is set but a custom engine is used, engine callbacks disabled")
+		case engineSigner == nil:
+			slog.Warn("ENGINE_CALLBACK_URL is set but ENGINE_SIGNING_SECRET is not, engine callbacks disabled")
+		default:
+			callbacks = newCallbackEngine(config.EngineCallbacks, pythonClient, engineCtx)
+			primary = callbacks
+			slog.Info("Engine callbacks", "url", config.EngineCallbacks.URL, "timeout", config.EngineCallbacks.Timeout.String())
+		}
+	}
+
+	sandboxEngine, err := NewSandboxEngine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sandbox fixtures: %w", err)
+	}
+
+	attachmentStore := NewAttachmentStore(config.Attachments.TTL)
+	urlFetcher := NewURLFetcher(config.ContextURLs, config.Attachments.MaxBytes)
+	pendingQueries := NewPendingQueryStore(config.Clarification.TTL)
+
+	readiness := NewReadiness(config.ReadyTimeout)
+	var history *HistoryStore
+	if config.DatabaseURL != "" {
+		db, err := postgres.Open(config.DatabaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		s.db = db
+		if history, err = NewPostgresHistoryStore(db, config.HistoryMax); err != nil {
+			return nil, fmt.Errorf("failed to load history from Postgres at %s: %w", postgres.Addr(db), err)
+		}
+		readiness.add("database", true, ErrCodeHistoryUnavailable, func() error { return postgres.Ping(db) })
+		slog.Info("History kept in Postgres", "addr", postgres.Addr(db), "in_memory", config.HistoryMax)
+	} else if history, err = NewHistoryStore(filepath.Join(config.DataDir, "history.jsonl"), config.HistoryMax); err != nil {
+		return nil, fmt.Errorf("failed to load history: %w", err)
+	}
+
+	events, err := NewEventLog(filepath.Join(config.DataDir, "events.jsonl"), config.EngagementMax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load engagement events: %w", err)
+	}
+	priors := NewSourcePriors(config.SourcePriors, events, history)
+	if config.SourcePriors.Strength > 0 {
+		s.background.Go(func() { priors.Run(s.stop) })
+	}
+
+	var ocr OCREngine
+	if tesseract, err := NewTesseractOCR(config.OCR.Command, config.OCR.Languages, config.OCR.Timeout); err != nil {
+		slog.Warn("OCR unavailable, image uploads are disabled", "error", err)
+	} else {
+		ocr = tesseract
+		slog.Info("OCR", "command", config.OCR.Command, "languages", config.OCR.Languages)
+	}
+
+	tenantStore, err := NewTenantStore(filepath.Join(config.DataDir, "tenants.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	apiKeys, err := NewAPIKeyStore(filepath.Join(config.DataDir, "api_keys.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	if config.RequireAPIKey {
+		slog.Info("API keys required", "keys", len(apiKeys.List()))
+	}
+
+	taxonomy, err := NewTaxonomyStore(filepath.Join(config.DataDir, "taxonomy.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load taxonomy: %w", err)
+	}
+
+	binders, err := NewBinderStore(filepath.Join(config.DataDir, "binders.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load binders: %w", err)
+	}
+
+	reviews, err := NewReviewStore(filepath.Join(config.DataDir, "reviews.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reviews: %w", err)
+	}
+
+	preferences, err := NewPreferenceStore(filepath.Join(config.DataDir, "preferences.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load preferences: %w", err)
+	}
+
+	conversations, err := NewConversationStore(filepath.Join(config.DataDir, "conversations.json"), config.Conversations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversations: %w", err)
+	}
+
+	notifications, err := NewNotificationCenter(filepath.Join(config.DataDir, "notifications.json"), preferences)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notifications: %w", err)
+	}
+
+	quickRefs, err := NewQuickRefStore(filepath.Join(config.DataDir, "quickref.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quick references: %w", err)
+	}
+
+	disclaimers, err := NewDisclaimerStore(filepath.Join(config.DataDir, "disclaimers.json"), config.PostProcess.Disclaimer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load disclaimers: %w", err)
+	}
+
+	status, err := NewStatusBoard(filepath.Join(config.DataDir, "status.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load status messages: %w", err)
+	}
+	licensing, err := NewLicensing(config.License, filepath.Join(config.DataDir, "license_seats.json"), status)
+	if err != nil {
+		return nil, err
+	}
+	if licensing != nil {
+		s.background.Go(func() { licensing.Run(s.stop) })
+		slog.Info("License", "id", licensing.license.ID, "licensee", licensing.license.Licensee, "seats", licensing.license.Seats,
+			"expires_at", licensing.license.ExpiresAt.Format(time.DateOnly), "state", licensing.State(time.Now()))
+	}
+
+	signingKey := opts.Signer
+	if signingKey == nil {
+		keyFile := config.SigningKeyFile
+		if keyFile == "" {
+			keyFile = filepath.Join(config.DataDir, "signing_key.pem")
+		}
+		signingKey, err = loadSigningKey(keyFile, config.SigningKeyFile == "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key: %w", err)
+		}
+	}
+	signer, err := NewAnswerSigner(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up answer signing: %w", err)
+	}
+	slog.Info("Answer signing", "algorithm", signer.algorithm, "key_id", signer.keyID)
+
+	publicPages, err := NewPublicPageStore(filepath.Join(config.DataDir, "public_pages.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public pages: %w", err)
+	}
+
+	shares, err := NewShareStore(filepath.Join(config.DataDir, "shares.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shares: %w", err)
+	}
+
+	edits, err := NewEditStore(filepath.Join(config.DataDir, "edits.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load answer edits: %w", err)
+	}
+
+	pdfFont, err := document.FindFont(config.PDFFont)
+	if err != nil {
+		slog.Warn("PDF export unavailable", "error", err)
+	} else {
+		slog.Info("PDF font", "font", pdfFont.Name)
+	}
+
+	postProcessConfig := config.PostProcess
+	postProcessConfig.Disclaimers = disclaimers
+	postProcessors, err := NewPostProcessorChain(postProcessConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up post-processors: %w", err)
+	}
+	if names := postProcessors.Names(); len(names) > 0 {
+		slog.Info("Post-processors", "chain", strings.Join(names, " -> "))
+	}
+
+	lawLinks, err := NewLinkResolver(config.LawLinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load law links: %w", err)
+	}
+	if lawLinks != nil {
+		slog.Info("Official links", "documents", len(lawLinks.documents), "link_checks", config.LawLinks.Check)
+	}
+
+	rules, err := LoadRules(config.RulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	if rules != nil {
+		slog.Info("Rules", "answers", len(rules.Rules), "file", config.RulesFile)
+	}
+
+	collections, err := LoadCollections(config.CollectionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load collections: %w", err)
+	}
+	if collections != nil {
+		slog.Info("Collections", "collections", len(collections.Collections), "file", config.CollectionsFile)
+	}
+	privateDocs, err := NewPrivateCollectionStore(filepath.Join(config.DataDir, "private_collections.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private collections: %w", err)
+	}
+
+	var compareEngines []compareEngine
+	for _, target := range config.CompareTargets {
+		targetEngine := primary
+		if target.URL != "" {
+			targetEngine = newPythonClient("compare:"+target.Name, target.URL, false)
+		}
+		compareEngines = append(compareEngines, compareEngine{CompareTarget: target, engine: targetEngine})
+	}
+	if len(compareEngines) > 0 {
+		slog.Info("Compare targets", "targets", len(compareEngines))
+	}
+
+	var embeddingProviders []embeddingProvider
+	for _, provider := range config.Embeddings.Providers {
+		client := newPythonClient("embedding:"+provider.Name, provider.URL, false)
+		embeddingProviders = append(embeddingProviders, embeddingProvider{EmbeddingProvider: provider, retriever: client})
+	}
+	embeddings := newEmbeddingExperiment(config.Embeddings, retriever, embeddingProviders, &s.background)
+	if embeddings.Enabled() {
+		slog.Info("Embedding experiment", "providers", len(embeddingProviders), "sample_rate", config.Embeddings.SampleRate)
+	}
+
+	// Engines the warm-up keeps warm, by name
+	type namedEngine struct {
+		name   string
+		engine engine.QueryEngine
+	}
+	warmupEngines := []namedEngine{{config.Regions.PrimaryName, primary}}
+
+	base, cancellable := primary.(engine.ContextQueryEngine)
+	var regions *failoverEngine
+	if config.Regions.SecondaryURL != "" {
+		if opts.Engine != nil {
+			slog.Warn("SECONDARY_ENGINE_URL is set but a custom engine is used, engine failover disabled")
+		} else {
+			secondary := newPythonClient(config.Regions.SecondaryName, config.Regions.SecondaryURL, true)
+			regions = newFailoverEngine(config.Regions, pythonClient, secondary)
+			regions.status = status
+			warmupEngines = append(warmupEngines, namedEngine{config.Regions.SecondaryName, secondary})
+			base = regions
+			s.background.Go(func() { regions.checkHealth(config.Regions.HealthInterval, s.stop) })
+			slog.Info("Engine regions", "primary", config.Regions.PrimaryName, "secondary", config.Regions.SecondaryName,
+				"secondary_url", config.Regions.SecondaryURL, "latency_budget", config.Regions.LatencyBudget.String())
+		}
+	}
+
+	queryEngine := primary
+	engineCheck := s.healthCheck
+	if regions != nil {
+		queryEngine = regions
+		engineCheck = regions.HealthCheck
+	}
+	readiness.add("engine", true, ErrCodeEngineUnavailable, engineCheck)
+
+	// The server starts whether the engine is up or not; the monitor
+	// reports its state from the first check on
+	monitor, err := NewEngineMonitor(filepath.Join(config.DataDir, "engine_status.json"), config.EngineMonitor, engineCheck, status)
+	if err != nil {
+		return nil, err
+	}
+	s.background.Go(func() { monitor.Run(s.stop) })
+	var hedging *hedgingEngine
+	if config.Hedging.Enabled {
+		switch {
+		case len(config.Hedging.PoolURLs) == 0:
+			slog.Warn("ENABLE_HEDGING is set but ENGINE_POOL_URLS is empty, hedging disabled")
+		case !cancellable:
+			slog.Warn("ENABLE_HEDGING is set but the engine requests cannot be cancelled, hedging disabled")
+		default:
+			pool := []engine.ContextQueryEngine{base}
+			for _, url := range config.Hedging.PoolURLs {
+				client := newPythonClient(url, url, false)
+				pool = append(pool, client)
+				warmupEngines = append(warmupEngines, namedEngine{url, client})
+			}
+			hedging = newHedgingEngine(config.Hedging, pool)
+			queryEngine = hedging
+			slog.Info("Request hedging", "engines", len(pool), "budget_percent", config.Hedging.Budget*100)
+		}
+	}
+	if len(breakers) > 0 {
+		watchBreakers(breakers, status)
+		slog.Info("Engine circuit breakers", "threshold", config.Breaker.Threshold, "cooldown", config.Breaker.Cooldown.String())
+	}
+
+	var telemetry *serverMetrics
+	if config.Metrics.Enabled {
+		telemetry = newServerMetrics()
+		queryEngine = &meteredEngine{next: queryEngine, metrics: telemetry}
+	}
+
+	// Engine pressure is measured on real engine calls, after the cache
+	pressure := newPressureEngine(queryEngine, config.Scaling.Capacity)
+	s.background.Go(func() { publishScalingSignals(pressure, config.Scaling.Webhook, config.Scaling.Interval, s.stop) })
+	if config.Scaling.Webhook != "" {
+		slog.Info("Scaling signal", "interval", config.Scaling.Interval.String(), "capacity", config.Scaling.Capacity)
+	}
+	if telemetry != nil {
+		telemetry.observeEngine(pressure)
+	}
+
+	var engineWarmer *EngineWarmer
+	if config.Warmup.Enabled {
+		engineWarmer = NewEngineWarmer(config.Warmup, pressure.LastQuery)
+		for _, e := range warmupEngines {
+			engineWarmer.Register(e.name, e.engine)
+		}
+		s.background.Go(func() { engineWarmer.Warm() })
+		s.background.Go(func() { engineWarmer.Schedule(s.stop) })
+		readiness.add("warmup", true, ErrCodeEngineWarmingUp, engineWarmer.Ready)
+
+		// gRPC health reports NOT_SERVING until the engines are warm
+		healthCheck := s.healthCheck
+		s.healthCheck = func() error {
+			if err := healthCheck(); err != nil {
+				return err
+			}
+			return engineWarmer.Ready()
+		}
+		slog.Info("Engine warm-up", "engines", len(warmupEngines), "queries", len(config.Warmup.Queries),
+			"idle_after", config.Warmup.IdleAfter.String())
+	}
+
+	// Engine slots are taken below the cache, so cached answers never wait
+	limited := engine.QueryEngine(pressure)
+	var slots *slotLimiter
+	if config.Slots.Limit > 0 {
+		slots = newSlotLimiter(config.Slots)
+		limited = &slotEngine{next: pressure, limiter: slots}
+		slog.Info("Engine slots", "limit", config.Slots.Limit, "reserved", config.Slots.Reserved, "premium_plans", config.Slots.PremiumPlans)
+	}
+
+	deps := queryDeps{
+		engine:           limited,
+		sandbox:          sandboxEngine,
+		attachments:      attachmentStore,
+		attachmentLimits: config.Attachments,
+		fetcher:          urlFetcher,
+		pending:          pendingQueries,
+		detectAmbiguous:  config.Clarification.Detect,
+		history:          history,
+		compare:          compareEngines,
+		iterationPolicy:  config.IterationPolicy,
+		stageBudgets:     config.StageBudgets,
+		speculative:      config.Speculative,
+		speculation:      NewSpeculationStats(),
+		embeddings:       embeddings,
+		routing:          difficultyRouting{enabled: config.Routing.Enabled, fastModel: config.Routing.FastModel},
+		routingStats:     NewRoutingStats(),
+		postProcessors:   postProcessors,
+		lawLinks:         lawLinks,
+		taxonomy:         taxonomy,
+		preferences:      preferences,
+		conversations:    conversations,
+		summaries:        newConversationSummarizer(summarizer, conversations, config.RequestTimeout, &s.background),
+		collections:      collections,
+		privateDocs:      privateDocs,
+		slots:            slots,
+		explain:          config.Explain,
+		downgrade:        config.Downgrade,
+		reloader:         reloader,
+		priors:           priors,
+	}
+	if regions != nil {
+		deps.primaryRegion = config.Regions.PrimaryName
+	}
+
+	var cache *ResponseCache
+	var warmer *CacheWarmer
+	if config.Cache.MaxEntries > 0 {
+		if config.Cache.RedisURL != "" {
+			client, err := newRedisClient(config.Cache.RedisURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+			}
+			if err := pingRedis(client); err != nil {
+				slog.Warn("Redis is unreachable, responses are cached in memory until it is", "addr", client.Options().Addr, "error", err)
+			}
+			// Responses are cached in memory while Redis is down, so it is
+			// not required
+			readiness.add("cache", false, "", func() error { return pingRedis(client) })
+			cache = NewSharedResponseCache(client, config.Cache.RedisPrefix, config.Cache.TTL, config.Cache.MaxEntries)
+			slog.Info("Response cache shared through Redis", "addr", client.Options().Addr, "ttl", config.Cache.TTL.String(), "fallback_entries", config.Cache.MaxEntries)
+		} else {
+			cache = NewResponseCache(config.Cache.MaxEntries, config.Cache.TTL)
+			slog.Info("Response cache", "entries", config.Cache.MaxEntries, "ttl", config.Cache.TTL.String())
+		}
+		deps.engine = &cachedEngine{next: limited, cache: cache}
+		if telemetry != nil {
+			telemetry.observeCache(cache)
+		}
+		warmer = &CacheWarmer{
+			engine:  pressure,
+			cache:   cache,
+			history: history,
+			topN:    config.Cache.WarmTopN,
+			build: func(req *LegalQueryRequest) *engine.PythonQueryRequest {
+				return deps.engineRequest(req, QueryDefaults{}, plans[defaultPlanName])
+			},
+			notifications: notifications,
+		}
+
+		if config.Cache.WarmTopN > 0 {
+			if config.Cache.WarmOnStart {
+				s.background.Go(func() { warmer.Warm() })
+			}
+			if config.Cache.WarmInterval > 0 {
+				s.background.Go(func() { warmer.Schedule(config.Cache.WarmInterval, s.stop) })
+				slog.Info("Cache warming", "top", config.Cache.WarmTopN, "interval", config.Cache.WarmInterval.String())
+			}
+		}
+	}
+
+	// Rules answer before the cache and the engine are consulted
+	if rules != nil {
+		deps.engine = &ruleEngine{rules: rules, next: deps.engine}
+	}
+
+	notifier := multiNotifier{logNotifier{}, notifications}
+	if config.SLO.AlertWebhook != "" {
+		notifier = append(notifier, NewWebhookNotifier(config.SLO.AlertWebhook, 10*time.Second))
+	}
+	slos := NewSLOTracker(config.SLO, notifier)
+	for _, slo := range config.SLO.SLOs {
+		slog.Info("SLO", "name", slo.Name)
+	}
+	s.background.Go(func() { slos.Run(config.SLO.EvalInterval, s.stop) })
+
+	jobs := NewQueryJobs(config.Jobs, notifications)
+	jobs.Start(config.Jobs.Workers, s.stop)
+	slog.Info("Query jobs", "workers", config.Jobs.Workers, "queue_size", config.Jobs.QueueSize)
+
+	watches, err := NewWatchStore(filepath.Join(config.DataDir, "watches.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watches: %w", err)
+	}
+	// Changes are summarized by the engine itself, never by rules or the cache
+	corpusWatcher := NewCorpusWatcher(watches, articles, limited, notifications)
+	corpusChanges, err := NewCorpusChangeLog(filepath.Join(config.DataDir, "corpus_changes.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load corpus changes: %w", err)
+	}
+	feedTokens, err := NewFeedTokenStore(filepath.Join(config.DataDir, "feed_tokens.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feed tokens: %w", err)
+	}
+
+	reviewJobs := NewReviewJobs(config.ReviewJobs, notifications)
+	reviewJobs.Start(config.ReviewJobs.Workers, s.stop)
+	slog.Info("Review jobs", "workers", config.ReviewJobs.Workers, "per_minute", config.ReviewJobs.PerMinute)
+
+	graphqlSchema, err := newGraphQLSchema(deps, history, reviews, privateDocs, documentIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the GraphQL schema: %w", err)
+	}
+
+	// Setup Gin router
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("failed to set trusted proxies: %w", err)
+	}
+	logSettings := middleware.NewLogSettings(config.LogLevel, config.LogSampleRate)
+	router.Use(recoveryMiddleware())
+	if telemetry != nil {
+		router.Use(metricsMiddleware(telemetry))
+	}
+	router.Use(middleware.LoggingWith(logSettings))
+	router.Use(middleware.RequestID(), requestLogMiddleware())
+	router.Use(sloMiddleware(slos))
+	router.Use(middleware.CORSWithConfig(config.CORS))
+	router.Use(bodyLimitMiddleware(config.BodyLimits))
+	router.Use(compressionMiddleware(config.Compression))
+	router.Use(apiKeyMiddleware(apiKeys, config.RequireAPIKey))
+	router.Use(rateLimitMiddleware(rateLimiter))
+	router.Use(quotaMiddleware(newQueryQuota(config.Quota)))
+	router.Use(tenantMiddleware(tenantStore))
+	router.Use(planMiddleware(config.DefaultPlan, config.QueryCaps))
+	router.Use(licenseMiddleware(licensing))
+	router.Use(sandboxMiddleware(config.SandboxMode))
+	router.Use(opts.Middleware...)
+
+	// ADMIN_PORT and METRICS_PORT move the admin API and the metrics to
+	// routers of their own, without the middleware of API callers
+	adminRouter, metricsRouter := router, router
+	if config.Listeners.AdminPort != "" {
+		adminRouter = newInternalRouter(logSettings, config.BodyLimits, config.Compression, config.TrustedProxies)
+		s.adminRouter = adminRouter
+	}
+	if config.Listeners.MetricsPort != "" && telemetry != nil {
+		metricsRouter = newInternalRouter(logSettings, config.BodyLimits, config.Compression, config.TrustedProxies)
+		if config.Listeners.MetricsPort == config.Listeners.AdminPort {
+			metricsRouter = adminRouter
+		}
+		s.metricsRouter = metricsRouter
+	}
+
+	// Routes
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"service": "Legal RAG Backend API",
+			"version": "1.0.0",
+			"status":  "running",
+		})
+	})
+
+	router.GET("/health", healthHandler)
+	router.GET("/ready", readyHandler(engineWarmer))
+	router.GET("/healthz", livenessHandler)
+	router.GET("/readyz", readinessHandler(readiness))
+	if telemetry != nil {
+		metricsRouter.GET("/metrics", metricsHandler(telemetry, config.Metrics.Token))
+		slog.Info("Prometheus metrics", "path", "/metrics")
+	}
+	router.GET("/api/errors", errorCatalogHandler)
+	router.GET("/api/presets", listPresetsHandler())
+	router.GET("/api/collections", listCollectionsHandler(collections, privateDocs))
+	router.GET("/api/private-collections", listPrivateCollectionsHandler(privateDocs, documentIndex))
+	router.POST("/api/private-collections", createPrivateCollectionHandler(privateDocs, collections, documentIndex))
+	router.GET("/api/private-collections/:name", getPrivateCollectionHandler(privateDocs, documentIndex))
+	router.DELETE("/api/private-collections/:name", deletePrivateCollectionHandler(privateDocs, documentIndex))
+	router.POST("/api/private-collections/:name/documents", uploadPrivateDocumentHandler(privateDocs, documentIndex, config.PrivateDocs))
+	router.DELETE("/api/private-collections/:name/documents/:id", deletePrivateDocumentHandler(privateDocs, documentIndex))
+	router.GET("/api/status", statusHandler(status, monitor))
+	if callbacks != nil {
+		router.POST(engineCallbackPath+":id", requireEngineSignature(engineSigner), engineCallbackHandler(callbacks))
+	}
+	router.POST("/api/legal-query", legalQueryHandler(deps))
+	router.POST("/api/legal-query/async", asyncLegalQueryHandler(deps, jobs))
+	router.GET("/api/jobs/:id", getJobHandler(jobs))
+	router.GET("/api/review-jobs", listReviewJobsHandler(reviewJobs))
+	router.POST("/api/review-jobs", createReviewJobHandler(deps, reviewJobs, config.ReviewJobs))
+	router.GET("/api/review-jobs/:id", getReviewJobHandler(reviewJobs))
+	router.GET("/api/review-jobs/:id/report", reviewReportHandler(reviewJobs))
+	router.POST("/api/legal-query/stream", streamLegalQueryHandler(deps))
+	router.POST("/graphql", graphqlHandler(graphqlSchema))
+	router.POST("/api/legal-query/compare", compareHandler(deps))
+	router.GET("/api/legal-query/compare/targets", compareTargetsHandler(deps))
+	router.POST("/api/validate", validateHandler(config.Attachments, config.ContextURLs))
+	router.POST("/api/attachments", uploadAttachmentHandler(attachmentStore, config.Attachments, ocr))
+	router.POST("/api/attachments/:id/confirm", confirmAttachmentHandler(attachmentStore, config.Attachments))
+	router.DELETE("/api/attachments/:id", deleteAttachmentHandler(attachmentStore))
+	router.GET("/api/history", listHistoryHandler(history))
+	router.GET("/api/history/:id", getHistoryHandler(history))
+	router.POST("/api/events", reportEventsHandler(events, history))
+	router.GET("/api/history/:id/sources/export", exportSourcesHandler(history, &sourceExporter{articles: articles, pdfDir: config.SourcePDFDir, disclaimers: disclaimers}))
+	router.POST("/api/history/:id/regenerate", regenerateHandler(deps))
+	router.GET("/api/history/:id/review", getReviewHandler(reviews, history))
+	router.POST("/api/history/:id/review", transitionReviewHandler(reviews, history, edits, config.Review.SeniorLawyers, signer))
+	router.GET("/api/history/:id/signature", answerSignatureHandler(reviews, history, edits, signer))
+	router.GET("/api/history/:id/edits", listEditsHandler(edits, history))
+	router.POST("/api/history/:id/edits", editAnswerHandler(edits, reviews, history, lawLinks))
+	router.GET("/api/history/:id/comments", listCommentsHandler(reviews, history))
+	router.POST("/api/history/:id/comments", addCommentHandler(reviews, history, notifications))
+	router.DELETE("/api/history/:id/comments/:comment_id", deleteCommentHandler(reviews, history))
+	router.GET("/api/reviews", listReviewsHandler(reviews))
+	router.GET("/api/disclaimer", getDisclaimerHandler(disclaimers))
+	router.POST("/api/disclaimer/versions", proposeDisclaimerHandler(disclaimers, config.Review.ComplianceReviewers, notifications))
+	router.POST("/api/disclaimer/versions/:version/review", reviewDisclaimerHandler(disclaimers, config.Review.ComplianceReviewers, notifications))
+	router.GET("/api/shares", listSharesHandler(shares))
+	router.POST("/api/shares", createShareHandler(shares, reviews, history, config.Review.ShareTTL))
+	router.DELETE("/api/shares/:id", revokeShareHandler(shares))
+	router.GET("/api/shared/:id", viewShareHandler(shares, reviews, history, edits))
+	router.POST("/api/history/:id/publish", publishPageHandler(publicPages, reviews, history, config.Review.SeniorLawyers))
+	router.DELETE("/api/history/:id/publish", unpublishPageHandler(publicPages, config.Review.SeniorLawyers))
+	router.GET("/api/public-pages", listPublicPagesHandler(publicPages))
+	router.GET("/faq/:tenant", publicIndexHandler(publicPages, tenantStore, reviews, history, edits))
+	router.GET("/faq/:tenant/:slug", publicPageHandler(publicPages, tenantStore, reviews, history, edits))
+	router.GET("/sitemap.xml", sitemapHandler(publicPages, tenantStore, reviews, history, edits))
+	router.GET("/robots.txt", robotsHandler(tenantStore))
+	router.GET("/api/signing-key", signingKeyHandler(signer))
+	router.GET("/api/me/preferences", getPreferencesHandler(preferences))
+	router.PUT("/api/me/preferences", putPreferencesHandler(preferences))
+	router.DELETE("/api/me/preferences", deletePreferencesHandler(preferences))
+	router.GET("/api/notifications", listNotificationsHandler(notifications))
+	router.POST("/api/notifications/read", markNotificationsHandler(notifications))
+	router.GET("/api/notifications/stream", streamNotificationsHandler(notifications, s.stop))
+	router.POST("/api/signatures/verify", verifySignatureHandler(signer))
+	router.GET("/api/taxonomy", getTaxonomyHandler(taxonomy, history))
+	router.POST("/api/taxonomy/tag", tagTextHandler(taxonomy))
+	router.GET("/api/quickref", listQuickRefsHandler(quickRefs))
+	router.GET("/api/quickref/:topic", getQuickRefHandler(quickRefs))
+	router.GET("/api/binders", listBindersHandler(binders))
+	router.POST("/api/binders", createBinderHandler(binders))
+	router.GET("/api/binders/:id", getBinderHandler(binders))
+	router.PATCH("/api/binders/:id", updateBinderHandler(binders))
+	router.DELETE("/api/binders/:id", deleteBinderHandler(binders))
+	router.POST("/api/binders/:id/items", addBinderItemHandler(binders, history))
+	router.PUT("/api/binders/:id/items/:item_id", updateBinderItemHandler(binders))
+	router.DELETE("/api/binders/:id/items/:item_id", deleteBinderItemHandler(binders))
+	router.PUT("/api/binders/:id/order", reorderBinderHandler(binders))
+	router.GET("/api/binders/:id/export", exportBinderHandler(binders, pdfFont, disclaimers))
+	router.POST("/api/memos", memoHandler(deps, pdfFont))
+	router.POST("/api/conversations", createConversationHandler(conversations))
+	router.GET("/api/conversations/:id", getConversationHandler(conversations))
+	router.GET("/api/watches", listWatchesHandler(watches))
+	router.POST("/api/watches", createWatchHandler(watches, articles))
+	router.DELETE("/api/watches/:id", deleteWatchHandler(watches))
+	router.GET("/api/article-changes/:id", getArticleChangeHandler(watches))
+	router.GET("/api/digest", digestHandler(corpusChanges))
+	router.GET("/api/feeds/corpus.rss", feedHandler(feedTokens, FeedRSS, corpusFeed(corpusChanges)))
+	router.GET("/api/feeds/corpus.atom", feedHandler(feedTokens, FeedAtom, corpusFeed(corpusChanges)))
+	router.GET("/api/feeds/quickref.rss", feedHandler(feedTokens, FeedRSS, quickRefFeed(quickRefs)))
+	router.GET("/api/feeds/quickref.atom", feedHandler(feedTokens, FeedAtom, quickRefFeed(quickRefs)))
+	router.GET("/ws/chat", chatHandler(deps, config.Chat, config.CORS))
+
+	admin := adminRouter.Group("/admin", adminMiddleware(config.AdminToken))
+	if adminRouter != router {
+		// API keys bound to users identify the approvers of quick references
+		admin.Use(apiKeyMiddleware(apiKeys, false))
+	}
+	admin.GET("/status", listStatusHandler(status))
+	admin.POST("/status", createStatusHandler(status))
+	admin.PUT("/status/:id", updateStatusHandler(status))
+	admin.DELETE("/status/:id", deleteStatusHandler(status))
+	admin.GET("/faults", getFaultsHandler(faults))
+	admin.GET("/logging", getLoggingHandler(logSettings))
+	admin.GET("/license", licenseStatusHandler(licensing))
+	admin.GET("/config", getLiveConfigHandler(reloader))
+	admin.POST("/config/reload", reloadConfigHandler(reloader))
+	admin.PUT("/logging", putLoggingHandler(logSettings))
+	admin.GET("/speculation", speculationStatsHandler(deps.speculation))
+	admin.GET("/embeddings", embeddingExperimentHandler(deps.embeddings))
+	admin.GET("/routing", routingStatsHandler(deps.routing, deps.routingStats))
+	admin.GET("/rules", listRulesHandler(rules))
+	admin.GET("/slo", sloStatusHandler(slos))
+	admin.GET("/analytics/load", loadAnalyticsHandler(history))
+	admin.GET("/analytics/engagement", engagementAnalyticsHandler(events, history))
+	admin.GET("/analytics/source-priors", sourcePriorsHandler(priors))
+	admin.GET("/evaluation/edits", exportEditsHandler(edits))
+	admin.GET("/scaling", scalingSignalHandler(pressure))
+	admin.GET("/regions", regionStatusHandler(regions))
+	admin.GET("/breakers", breakerStatsHandler(breakers))
+	admin.GET("/hedging", hedgingStatsHandler(hedging))
+	admin.GET("/slots", slotStatsHandler(slots))
+	admin.GET("/warmup", warmupStatusHandler(engineWarmer))
+	admin.POST("/warmup", rewarmHandler(engineWarmer))
+	admin.GET("/law-links", lawLinksHandler(lawLinks))
+	admin.GET("/cache", cacheStatsHandler(cache, warmer))
+	admin.POST("/cache/warm", warmCacheHandler(cache, warmer))
+	admin.POST("/cache/invalidate", invalidateCacheHandler(cache, warmer))
+	admin.POST("/watches/check", checkWatchesHandler(corpusWatcher))
+	admin.POST("/corpus/changes", reportCorpusChangesHandler(corpusChanges, limited))
+	admin.PUT("/faults", putFaultsHandler(faults))
+	admin.GET("/api-keys", listAPIKeysHandler(apiKeys))
+	admin.POST("/api-keys", createAPIKeyHandler(apiKeys, tenantStore))
+	admin.DELETE("/api-keys/:id", revokeAPIKeyHandler(apiKeys))
+	admin.GET("/feed-tokens", listFeedTokensHandler(feedTokens))
+	admin.POST("/feed-tokens", createFeedTokenHandler(feedTokens, tenantStore))
+	admin.DELETE("/feed-tokens/:id", revokeFeedTokenHandler(feedTokens))
+	admin.GET("/tenants", listTenantsHandler(tenantStore))
+	admin.POST("/tenants", createTenantHandler(tenantStore))
+	admin.GET("/tenants/:id", getTenantHandler(tenantStore))
+	admin.PUT("/tenants/:id/settings", updateTenantSettingsHandler(tenantStore))
+	admin.PUT("/tenants/:id/presets/:name", putTenantPresetHandler(tenantStore))
+	admin.DELETE("/tenants/:id/presets/:name", deleteTenantPresetHandler(tenantStore))
+	admin.DELETE("/tenants/:id", deleteTenantHandler(tenantStore))
+	admin.GET("/taxonomy/topics", listTopicsHandler(taxonomy, tenantStore))
+	admin.POST("/taxonomy/topics", createTopicHandler(taxonomy, tenantStore))
+	admin.PUT("/taxonomy/topics/:id", updateTopicHandler(taxonomy, tenantStore))
+	admin.DELETE("/taxonomy/topics/:id", deleteTopicHandler(taxonomy, tenantStore))
+	admin.DELETE("/taxonomy", resetTaxonomyHandler(taxonomy, tenantStore))
+	admin.GET("/quickref", adminListQuickRefsHandler(quickRefs, tenantStore))
+	admin.GET("/quickref/:topic", adminGetQuickRefHandler(quickRefs, tenantStore))
+	admin.PUT("/quickref/:topic", putQuickRefHandler(quickRefs, taxonomy, tenantStore))
+	admin.DELETE("/quickref/:topic", deleteQuickRefHandler(quickRefs, tenantStore))
+	admin.POST("/quickref/:topic/draft", draftQuickRefHandler(deps, quickRefs, taxonomy, tenantStore))
+	admin.POST("/quickref/:topic/approve", approveQuickRefHandler(quickRefs, tenantStore))
+	admin.POST("/notifications", publishNotificationHandler(notifications, tenantStore))
+
+	router.NoRoute(notFoundHandler)
+	router.NoMethod(methodNotAllowedHandler)
+
+	s.router = router
+	return s, nil
+}
+
+// Router returns the Gin engine serving the API, to add routes to. Routes
+// added here run the built-in middleware and Options.Middleware.
+func (s *Server) Router() *gin.Engine {
+	return s.router
+}
+
+// Handler returns the API as an http.Handler, for mounting in another server
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// AdminHandler returns the handler serving /admin: the API itself unless
+// ADMIN_PORT is set
+func (s *Server) AdminHandler() http.Handler {
+	if s.adminRouter != nil {
+		return s.adminRouter
+	}
+	return s.router
+}
+
+// Run serves the API on the configured port, the admin API and the metrics
+// on ADMIN_PORT and METRICS_PORT, and the gRPC services when GRPC_PORT is
+// set, until an HTTP server fails or the process gets SIGINT or SIGTERM
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return s.RunContext(ctx)
+}
+
+// RunContext is Run until ctx is done. It then stops accepting requests and
+// drains the requests in flight for up to SHUTDOWN_DRAIN_TIMEOUT, before
+// cancelling the engine requests still running.
+func (s *Server) RunContext(ctx context.Context) error {
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	var grpcServer *GRPCServer
+	if s.config.GRPC.Port != "" {
+		grpcServer, err = NewGRPCServer(listenAddr(s.config.GRPC.Port), s.router)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to start gRPC server: %w", err)
+		}
+		s.background.Go(func() { grpcServer.watchEngine(s.healthCheck, s.config.GRPC.HealthInterval, s.stop) })
+		go func() {
+			if err := grpcServer.Serve(); err != nil {
+				slog.Error("gRPC server stopped", "error", err)
+			}
+		}()
+		slog.Info("gRPC listening", "port", s.config.GRPC.Port)
+	}
+
+	slog.Info("API documentation", "url", fmt.Sprintf("http://localhost:%s/", s.config.ServerPort))
+	servers := make([]*http.Server, len(listeners))
+	served := make(chan error, len(listeners))
+	for i, l := range listeners {
+		servers[i] = l.server
+		go func() { served <- l.server.Serve(l.ln) }()
+	}
+
+	// A listener that fails stops the others, as the server cannot do
+	// without any of them
+	select {
+	case err := <-served:
+		for _, server := range servers {
+			server.Close()
+		}
+		if grpcServer != nil {
+			grpcServer.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+	if grpcServer == nil {
+		return s.drain(servers...)
+	}
+
+	// gRPC calls drain alongside the HTTP requests, and are closed once
+	// those would have been
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.Stop()
+		close(stopped)
+	}()
+	deadline := time.Now().Add(s.config.DrainTimeout + shutdownGrace)
+	err = s.drain(servers...)
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		slog.Warn("Closing the gRPC calls still running")
+		grpcServer.Close()
+		<-stopped
+	}
+	return err
+}
+
+// Close stops the background work of the server and cancels its engine
+// requests
+func (s *Server) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.cancelEngine(errShuttingDown)
+	s.background.Wait()
+	if s.db != nil {
+		s.db.Close()
+	}
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// stubEngine answers every query with resp, or fails with err
+type stubEngine struct {
+	mu       sync.Mutex
+	resp     engine.LegalQueryResponse
+	err      error
+	requests []engine.PythonQueryRequest
+}
+
+func (e *stubEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, *req)
+	if e.err != nil {
+		return nil, e.err
+	}
+	resp := e.resp
+	return &resp, nil
+}
+
+func newTestServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("OCR_COMMAND", "legal-rag-test-missing-ocr")
+	t.Setenv("WARM_CACHE_ON_START", "false")
+	t.Setenv("LAW_LINK_CHECK", "false")
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "0")
+	if opts.Config == nil {
+		opts.Config = LoadConfig()
+	}
+
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doJSON(t *testing.T, handler http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode error response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestHealth(t *testing.T) {
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+
+	rec := doJSON(t, srv.Handler(), http.MethodGet, "/health", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var resp HealthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Status != "healthy" {
+		t.Errorf("status = %q, want healthy", resp.Status)
+	}
+}
+
+func TestLegalQuery(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 35 Bộ luật Lao động 2019...", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+
+	question := "Theo Bộ luật Lao động 2019, người lao động làm việc theo hợp đồng không xác định thời hạn phải báo trước bao nhiêu ngày khi đơn phương chấm dứt hợp đồng?"
+	rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var resp engine.LegalQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Answer != stub.resp.Answer {
+		t.Errorf("answer = %q, want %q", resp.Answer, stub.resp.Answer)
+	}
+	if resp.HistoryID == "" {
+		t.Error("history_id is empty, want the answer recorded in the history")
+	}
+	if len(stub.requests) != 1 || stub.requests[0].Question != question {
+		t.Errorf("engine requests = %+v, want the question forwarded once", stub.requests)
+	}
+}
+
+func TestLegalQueryValidation(t *testing.T) {
+	stub := &stubEngine{}
+	srv := newTestServer(t, Options{Engine: stub})
+
+	rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "  "})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	if resp := decodeError(t, rec); len(resp.Violations) != 1 || resp.Violations[0].Field != "question" || resp.Violations[0].Code != ViolationRequired {
+		t.Errorf("violations = %+v, want question required", resp.Violations)
+	}
+
+	// Length is counted in characters, so Vietnamese text is not penalized
+	t.Setenv("MAX_QUESTION_LENGTH", "40")
+	srv = newTestServer(t, Options{Engine: stub})
+	if rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"}); rec.Code != http.StatusOK {
+		t.Errorf("question of 36 characters: status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Người lao động được nghỉ bao nhiêu ngày phép mỗi năm?"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("long question: status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	if resp := decodeError(t, rec); len(resp.Violations) != 1 || resp.Violations[0].Field != "question" || resp.Violations[0].Code != ViolationTooLong {
+		t.Errorf("violations = %+v, want question too long", resp.Violations)
+	}
+	if len(stub.requests) != 1 {
+		t.Errorf("engine called %d times, want only for the valid query", len(stub.requests))
+	}
+}
+
+func TestLegalQueryEngineErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		code   ErrorCode
+		want   int
+	}{
+		{"unavailable", http.StatusServiceUnavailable, ErrCodeEngineUnavailable, http.StatusServiceUnavailable},
+		{"timeout", http.StatusGatewayTimeout, ErrCodeEngineTimeout, http.StatusGatewayTimeout},
+		{"error", http.StatusInternalServerError, ErrCodeEngineError, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubEngine{err: &engine.EngineStatusError{StatusCode: tt.status, Body: "boom"}}
+			srv := newTestServer(t, Options{Engine: stub})
+
+			rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"})
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			resp := decodeError(t, rec)
+			if resp.Code != tt.code {
+				t.Errorf("code = %s, want %s", resp.Code, tt.code)
+			}
+			// Clients from before the catalog match on the error string
+			if resp.Error != "ai_engine_error" {
+				t.Errorf("error = %q, want the legacy ai_engine_error", resp.Error)
+			}
+		})
+	}
+}
+
+func TestLegalQueryEngineSchemaMismatch(t *testing.T) {
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"answer": "Theo Điều 25 ...", "search_results": [{"text": "Điều 25. Thời gian thử việc", "score": "0.82"}], "web_results": [], "iterations": 1, "query_used": "thử việc"}`)
+	}))
+	defer pythonEngine.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	h := newTestServer(t, Options{}).Handler()
+
+	rec := doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?"})
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502: %s", rec.Code, rec.Body.String())
+	}
+	if resp := decodeError(t, rec); resp.Code != ErrCodeEngineSchema || !strings.Contains(resp.Message, "score") {
+		t.Errorf("error = %+v, want %s naming the score", resp, ErrCodeEngineSchema)
+	}
+}
+
+func TestClientDisconnectCancelsEngine(t *testing.T) {
+	cancelled := make(chan struct{})
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/query" {
+			return
+		}
+		// The server notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(time.Minute):
+		case <-r.Context().Done():
+			close(cancelled)
+		}
+	}))
+	defer pythonEngine.Close()
+	t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine.URL)
+	t.Setenv("ENGINE_RETRY_ATTEMPTS", "1")
+	t.Setenv("ENGINE_CONCURRENCY_LIMIT", "2")
+	h := newTestServer(t, Options{}).Handler()
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	body, _ := json.Marshal(LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao lâu?"})
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/legal-query", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	answered := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, req)
+		close(answered)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	disconnect()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("engine request still running after the client disconnected")
+	}
+	<-answered
+	if code := decodeError(t, rec).Code; code != ErrCodeClientClosed {
+		t.Errorf("code = %s, want %s", code, ErrCodeClientClosed)
+	}
+}
+
+func TestCustomMiddlewareAndRoutes(t *testing.T) {
+	var seen []string
+	srv := newTestServer(t, Options{
+		Engine: &stubEngine{},
+		Middleware: []gin.HandlerFunc{func(c *gin.Context) {
+			seen = append(seen, c.Request.URL.Path)
+			c.Next()
+		}},
+	})
+	srv.Router().GET("/internal/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	rec := doJSON(t, srv.Handler(), http.MethodGet, "/internal/status", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	doJSON(t, srv.Handler(), http.MethodGet, "/health", nil)
+	if len(seen) != 2 || seen[0] != "/internal/status" || seen[1] != "/health" {
+		t.Errorf("middleware saw %v, want both routes", seen)
+	}
+}
+
+func TestErrorRoutes(t *testing.T) {
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+
+	tests := []struct {
+		method, path string
+		want         int
+		code         ErrorCode
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound, ErrCodeNotFound},
+		{http.MethodGet, "/api/legal-query", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{http.MethodGet, "/admin/faults", http.StatusForbidden, ErrCodeForbidden},
+	}
+	for _, tt := range tests {
+		rec := doJSON(t, srv.Handler(), tt.method, tt.path, nil)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			continue
+		}
+		if resp := decodeError(t, rec); resp.Code != tt.code {
+			t.Errorf("%s %s: code = %s, want %s", tt.method, tt.path, resp.Code, tt.code)
+		}
+	}
+}
+
+func TestNewServerRequiresConfig(t *testing.T) {
+	if _, err := NewServer(Options{}); err == nil {
+		t.Fatal("NewServer without config succeeded, want an error")
+	}
+}
+
+func TestLegalQueryMeta(t *testing.T) {
+	t.Setenv("POST_PROCESSORS", "answer-stats")
+	t.Setenv("SPECULATIVE_RETRIEVAL", "false")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc tối đa là 180 ngày.", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+
+	query := func() *engine.ResponseMeta {
+		t.Helper()
+		rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{
+			Question: "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc của người quản lý doanh nghiệp là bao nhiêu ngày?",
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var resp engine.LegalQueryResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Meta == nil {
+			t.Fatal("response has no meta")
+		}
+		return resp.Meta
+	}
+
+	first := query()
+	if len(first.Features) != 1 || first.Features[0] != FeaturePostProcessed {
+		t.Errorf("first features = %v, want [%s]", first.Features, FeaturePostProcessed)
+	}
+	if len(first.PostProcessors) != 1 || first.PostProcessors[0] != "answer-stats" {
+		t.Errorf("post_processors = %v, want [answer-stats]", first.PostProcessors)
+	}
+	if first.IterationPolicy == "" || first.Style.Length == "" {
+		t.Errorf("meta = %+v, want the iteration policy and resolved style", first)
+	}
+
+	second := query()
+	if len(second.Features) != 2 || second.Features[0] != FeatureCacheHit || second.Features[1] != FeaturePostProcessed {
+		t.Errorf("second features = %v, want [%s %s]", second.Features, FeatureCacheHit, FeaturePostProcessed)
+	}
+	if len(stub.requests) != 1 {
+		t.Errorf("engine called %d times, want the second answer from the cache", len(stub.requests))
+	}
+}
+
+func TestStageBudgets(t *testing.T) {
+	t.Setenv("STAGE_BUDGET_RETRIEVAL", "15s")
+	t.Setenv("STAGE_BUDGET_ANSWER", "45s")
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Hết thời gian tạo câu trả lời. Các điều luật liên quan: Điều 25.",
+		Iterations:    1,
+		StageTimeouts: []string{engine.StageAnswer},
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	rec := doJSON(t, h, http.MethodPost, "/api/legal-query", LegalQueryRequest{
+		Question: "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?",
+	})
+	var resp engine.LegalQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+	}
+	want := engine.StageBudgets{Retrieval: 15 * time.Second, Answer: 45 * time.Second}
+	if got := stub.requests[0].StageBudgets; got != want {
+		t.Errorf("stage budgets = %+v, want %+v", got, want)
+	}
+	if !slices.Contains(resp.Meta.Features, FeatureStageTimeout) || resp.StageTimeouts[0] != engine.StageAnswer {
+		t.Errorf("response = %+v %v, want the answer stage timeout reported", resp.StageTimeouts, resp.Meta.Features)
+	}
+
+	t.Setenv("STAGE_BUDGET_ANSWER", "5m")
+	problems := CheckConfig(LoadConfig(), false)
+	if !slices.ContainsFunc(problems, func(p ConfigProblem) bool { return p.Setting == "STAGE_BUDGET_ANSWER" }) {
+		t.Errorf("problems = %v, want STAGE_BUDGET_ANSWER above REQUEST_TIMEOUT reported", problems)
+	}
+}
+
+func TestCORSConfigProblems(t *testing.T) {
+	t.Setenv("CORS_ALLOW_ORIGIN", "https://app.example.vn, https://admin.example.vn")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE", "10m")
+	if problems := CheckConfig(LoadConfig(), false); slices.ContainsFunc(problems, func(p ConfigProblem) bool { return strings.HasPrefix(p.Setting, "CORS_") }) {
+		t.Errorf("problems = %v, want the allowlist accepted", problems)
+	}
+
+	for _, origins := range []string{"*", "https://app.example.vn/login, *", "app.example.vn"} {
+		t.Setenv("CORS_ALLOW_ORIGIN", origins)
+		if problems := CheckConfig(LoadConfig(), false); !slices.ContainsFunc(problems, func(p ConfigProblem) bool { return strings.HasPrefix(p.Setting, "CORS_") }) {
+			t.Errorf("CORS_ALLOW_ORIGIN=%q problems = %v, want it reported", origins, problems)
+		}
+	}
+}
+
+func TestSoftDeadlineDowngrade(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "100s")
+	t.Setenv("SOFT_DEADLINE", "40s")
+	t.Setenv("FAST_PATH_MODEL", "qwen2.5:1.5b")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{
+		Answer:        "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc tối đa là 180 ngày.",
+		Iterations:    2,
+		StoppedReason: "soft_deadline",
+		Downgrade:     &engine.Downgrade{Reason: "soft_deadline", AfterMs: 40120, Iterations: 2, Model: "qwen2.5:1.5b"},
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	question := LegalQueryRequest{Question: "Thời gian thử việc tối đa là bao lâu?", Preset: "deep_research"}
+
+	var resp engine.LegalQueryResponse
+	rec := doJSON(t, h, http.MethodPost, "/api/legal-query", question)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("query = %d %s", rec.Code, rec.Body.String())
+	}
+	want := engine.Deadlines{SoftMs: 40000, HardMs: 90000, FastModel: "qwen2.5:1.5b"}
+	if got := stub.requests[0].Deadlines; got == nil || *got != want {
+		t.Errorf("deadlines = %+v, want %+v", got, want)
+	}
+	if resp.Downgrade == nil || !slices.Contains(resp.Meta.Features, FeatureDowngraded) {
+		t.Errorf("response = %+v %v, want the downgrade reported", resp.Downgrade, resp.Meta.Features)
+	}
+	// A downgraded answer is not cached, so the next query gets a full one
+	doJSON(t, h, http.MethodPost, "/api/legal-query", question)
+	if len(stub.requests) != 2 {
+		t.Errorf("engine called %d times, want the downgraded answer left out of the cache", len(stub.requests))
+	}
+
+	t.Setenv("SOFT_DEADLINE", "95s")
+	problems := CheckConfig(LoadConfig(), false)
+	if !slices.ContainsFunc(problems, func(p ConfigProblem) bool { return p.Setting == "SOFT_DEADLINE" }) {
+		t.Errorf("problems = %v, want SOFT_DEADLINE above HARD_DEADLINE reported", problems)
+	}
+}
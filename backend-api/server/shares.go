@@ -0,0 +1,261 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxShareAnswers = 20
+
+// Share is a read-only link for clients to a set of answers. Only answers
+// approved at the time the link is opened are shown.
+type Share struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	HistoryIDs []string  `json:"history_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SharedAnswer is an approved answer as shown to clients, without the
+// internal review discussion
+type SharedAnswer struct {
+	Question   string         `json:"question"`
+	Answer     string         `json:"answer"`
+	Sources    []BinderSource `json:"sources,omitempty"`
+	ApprovedBy string         `json:"approved_by,omitempty"`
+	ApprovedAt time.Time      `json:"approved_at"`
+
+	// Signature lets the client verify the answer was approved as shown
+	Signature *AnswerSignature `json:"signature,omitempty"`
+}
+
+var errShareNotFound = errors.New("share not found")
+
+// ShareStore keeps share links, persisted to a JSON file when a path is
+// configured
+type ShareStore struct {
+	mu     sync.RWMutex
+	path   string
+	shares map[string]*Share
+}
+
+func NewShareStore(path string) (*ShareStore, error) {
+	store := &ShareStore{
+		path:   path,
+		shares: make(map[string]*Share),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var shares []*Share
+	if _, err := readJSONFile(path, &shares); err != nil {
+		return nil, fmt.Errorf("failed to load shares: %w", err)
+	}
+	for _, sh := range shares {
+		store.shares[sh.ID] = sh
+	}
+	return store, nil
+}
+
+// Open returns an unexpired share by its ID, which is the link's secret
+func (s *ShareStore) Open(id string) (Share, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sh, ok := s.shares[id]
+	if !ok || time.Now().After(sh.ExpiresAt) {
+		return Share{}, errShareNotFound
+	}
+	return *sh, nil
+}
+
+// List returns the unexpired shares of a tenant, newest first
+func (s *ShareStore) List(tenantID string) []Share {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	shares := []Share{}
+	for _, sh := range s.shares {
+		if sh.TenantID == tenantID && now.Before(sh.ExpiresAt) {
+			shares = append(shares, *sh)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt.After(shares[j].CreatedAt) })
+	return shares
+}
+
+func (s *ShareStore) Create(sh Share) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh.ID = "s_" + randomHex(16)
+	sh.CreatedAt = time.Now().UTC()
+	s.shares[sh.ID] = &sh
+	return sh, s.saveLocked()
+}
+
+// Revoke deletes a share of the tenant, and drops expired shares
+func (s *ShareStore) Revoke(id, tenantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok || sh.TenantID != tenantID {
+		return errShareNotFound
+	}
+	delete(s.shares, id)
+	now := time.Now()
+	for id, sh := range s.shares {
+		if now.After(sh.ExpiresAt) {
+			delete(s.shares, id)
+		}
+	}
+	return s.saveLocked()
+}
+
+func (s *ShareStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	shares := make([]*Share, 0, len(s.shares))
+	for _, sh := range s.shares {
+		shares = append(shares, sh)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ID < shares[j].ID })
+	return writeJSONFile(s.path, shares)
+}
+
+// Handlers
+
+// CreateShareRequest is the body of POST /api/shares
+type CreateShareRequest struct {
+	HistoryIDs []string `json:"history_ids" binding:"required"`
+	Title      string   `json:"title,omitempty"`
+
+	// ExpiresInHours overrides SHARE_TTL, up to the same maximum
+	ExpiresInHours *int `json:"expires_in_hours,omitempty"`
+}
+
+func createShareHandler(shares *ShareStore, reviews *ReviewStore, history *HistoryStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateShareRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if len(req.HistoryIDs) == 0 || len(req.HistoryIDs) > maxShareAnswers {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("history_ids must list 1 to %d answers", maxShareAnswers))
+			return
+		}
+		if req.ExpiresInHours != nil {
+			if *req.ExpiresInHours < 1 || time.Duration(*req.ExpiresInHours)*time.Hour > ttl {
+				abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("expires_in_hours must be between 1 and %d", int(ttl.Hours())))
+				return
+			}
+			ttl = time.Duration(*req.ExpiresInHours) * time.Hour
+		}
+
+		tenant, _ := callerTenant(c)
+		for _, id := range req.HistoryIDs {
+			if _, err := history.Get(id, tenant.ID); err != nil {
+				abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", id))
+				return
+			}
+			if state := reviews.Get(id, tenant.ID).State; state != ReviewApproved {
+				abortWithError(c, ErrCodeReviewConflict, fmt.Sprintf("Answer %q is %s; only approved answers can be shared with clients", id, state))
+				return
+			}
+		}
+
+		share, err := shares.Create(Share{
+			TenantID:   tenant.ID,
+			CreatedBy:  callerUser(c),
+			Title:      strings.TrimSpace(req.Title),
+			HistoryIDs: req.HistoryIDs,
+			ExpiresAt:  time.Now().UTC().Add(ttl),
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save share", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save share")
+			return
+		}
+		c.JSON(http.StatusCreated, share)
+	}
+}
+
+func listSharesHandler(shares *ShareStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{"shares": shares.List(tenant.ID)})
+	}
+}
+
+func revokeShareHandler(shares *ShareStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		err := shares.Revoke(c.Param("id"), tenant.ID)
+		if errors.Is(err, errShareNotFound) {
+			abortWithError(c, ErrCodeShareNotFound, fmt.Sprintf("Share %q not found", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save shares", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to revoke share")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// viewShareHandler is the client-facing view of a share. It needs no tenant:
+// the share ID is the secret. Answers show their latest edited version;
+// those whose approval was revoked, or that left the history, are left out.
+func viewShareHandler(shares *ShareStore, reviews *ReviewStore, history *HistoryStore, edits *EditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		share, err := shares.Open(c.Param("id"))
+		if err != nil {
+			abortWithError(c, ErrCodeShareNotFound, "Share not found or expired")
+			return
+		}
+		answers := []SharedAnswer{}
+		for _, id := range share.HistoryIDs {
+			review := reviews.Get(id, share.TenantID)
+			approval, approved := review.approval()
+			if !approved {
+				continue
+			}
+			entry, err := history.Get(id, share.TenantID)
+			if err != nil {
+				continue
+			}
+			entry = edits.Current(entry)
+			answer := SharedAnswer{
+				Question:   entry.Question,
+				Answer:     entry.Response.Answer,
+				ApprovedBy: approval.By,
+				ApprovedAt: approval.At,
+				Signature:  review.Signature,
+			}
+			for _, r := range entry.Response.SearchResults {
+				source := sourceFromResult(r)
+				source.Excerpt = ""
+				answer.Sources = append(answer.Sources, source)
+			}
+			answers = append(answers, answer)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"title":      share.Title,
+			"expires_at": share.ExpiresAt,
+			"answers":    answers,
+		})
+	}
+}
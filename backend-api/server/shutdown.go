@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errShuttingDown cancels the engine requests still in flight when the
+// drain timeout of a shutdown has passed
+var errShuttingDown = errors.New("server is shutting down")
+
+// shutdownGrace is left to the handlers to answer once their engine
+// requests are cancelled, before their connections are closed
+const shutdownGrace = 5 * time.Second
+
+// cancelTransport sends engine requests with a context that is also
+// cancelled when the server gives up draining, so that no handler keeps
+// waiting for the engine after the drain timeout
+type cancelTransport struct {
+	next http.RoundTripper
+	ctx  context.Context
+}
+
+func (t *cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(t.ctx, func() { cancel(context.Cause(t.ctx)) })
+	release := func() {
+		stop()
+		cancel(nil)
+	}
+
+	resp, err := next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if errors.Is(context.Cause(ctx), errShuttingDown) {
+			err = errShuttingDown
+		}
+		release()
+		return nil, err
+	}
+	// The body is read after RoundTrip returns, under the same context
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody releases the context of an engine request when its body is
+// closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// drain stops the HTTP servers from accepting requests and waits up to the
+// drain timeout for the requests in flight. Engine requests still running
+// then are cancelled, and their handlers get shutdownGrace to answer.
+func (s *Server) drain(servers ...*http.Server) error {
+	slog.Info("Shutting down: waiting for requests in flight", "drain_timeout", s.config.DrainTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+	if err := shutdownAll(ctx, servers); err == nil {
+		slog.Info("Shutdown complete: every request finished")
+		return nil
+	}
+
+	slog.Warn("Drain timeout exceeded; cancelling engine requests in flight")
+	s.cancelEngine(errShuttingDown)
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer graceCancel()
+	if err := shutdownAll(graceCtx, servers); err != nil {
+		slog.Warn("Closing the connections of unfinished requests", "error", err)
+		var errs []error
+		for _, server := range servers {
+			errs = append(errs, server.Close())
+		}
+		return errors.Join(errs...)
+	}
+	slog.Info("Shutdown complete")
+	return nil
+}
+
+// shutdownAll shuts the servers down together, so that each drains for the
+// whole of ctx
+func shutdownAll(ctx context.Context, servers []*http.Server) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Go(func() { errs[i] = server.Shutdown(ctx) })
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
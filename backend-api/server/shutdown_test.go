@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		engineDelay  time.Duration
+		drainTimeout string
+		wantStatus   int
+	}{
+		{"drained", 100 * time.Millisecond, "5s", http.StatusOK},
+		{"cancelled", time.Minute, "100ms", http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pythonEngine, received := newDelayedEngine(t, tc.engineDelay)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := listener.Addr().(*net.TCPAddr).Port
+			listener.Close()
+			t.Setenv("PYTHON_AI_ENGINE_URL", pythonEngine)
+			t.Setenv("GO_SERVER_PORT", strconv.Itoa(port))
+			t.Setenv("ENGINE_RETRY_ATTEMPTS", "1")
+			t.Setenv("SHUTDOWN_DRAIN_TIMEOUT", tc.drainTimeout)
+			srv := newTestServer(t, Options{})
+
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			ran := make(chan error, 1)
+			go func() { ran <- srv.RunContext(ctx) }()
+			base := "http://127.0.0.1:" + strconv.Itoa(port)
+			waitForServer(t, base)
+
+			type result struct {
+				status int
+				body   ErrorResponse
+			}
+			answered := make(chan result, 1)
+			go func() {
+				body, _ := json.Marshal(LegalQueryRequest{Question: "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao lâu?"})
+				resp, err := testClient.Post(base+"/api/legal-query", "application/json", bytes.NewReader(body))
+				if err != nil {
+					answered <- result{}
+					return
+				}
+				defer resp.Body.Close()
+				var r result
+				r.status = resp.StatusCode
+				json.NewDecoder(resp.Body).Decode(&r.body)
+				answered <- r
+			}()
+
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the query did not reach the engine")
+			}
+			stop()
+			started := time.Now()
+			if err := <-ran; err != nil {
+				t.Fatalf("RunContext = %v", err)
+			}
+			if elapsed := time.Since(started); elapsed > 3*time.Second {
+				t.Errorf("shutdown took %v", elapsed)
+			}
+			r := <-answered
+			if r.status != tc.wantStatus {
+				t.Fatalf("query during shutdown = %d %+v, want %d", r.status, r.body, tc.wantStatus)
+			}
+			if r.status != http.StatusOK && r.body.Code != ErrCodeShuttingDown {
+				t.Errorf("query cancelled by the shutdown = %s, want %s", r.body.Code, ErrCodeShuttingDown)
+			}
+			if _, err := testClient.Get(base + "/health"); err == nil {
+				t.Error("server still accepts requests after shutdown")
+			}
+		})
+	}
+}
+
+// testClient opens a connection per request, so that no idle connection
+// of an earlier request holds up a shutdown
+var testClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// newDelayedEngine starts an engine answering queries after delay, or when
+// the request is cancelled. received is signalled as each query arrives.
+func newDelayedEngine(t *testing.T, delay time.Duration) (url string, received <-chan struct{}) {
+	t.Helper()
+	queries := make(chan struct{}, 1)
+	pythonEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/query" {
+			return
+		}
+		// The server notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case queries <- struct{}{}:
+		default:
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		json.NewEncoder(w).Encode(engine.LegalQueryResponse{Answer: "Theo Điều 25 Bộ luật Lao động 2019 ...", Iterations: 1})
+	}))
+	t.Cleanup(pythonEngine.Close)
+	return pythonEngine.URL, queries
+}
+
+func waitForServer(t *testing.T, base string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if resp, err := testClient.Get(base + "/health"); err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+}
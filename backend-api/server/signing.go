@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SignedAnswer is the snapshot of an approved answer that is signed. Its
+// JSON encoding is the signed payload.
+type SignedAnswer struct {
+	HistoryID  string    `json:"history_id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	Question   string    `json:"question"`
+	Answer     string    `json:"answer"`
+	Sources    []string  `json:"sources,omitempty"`
+	ApprovedBy string    `json:"approved_by"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// AnswerSignature is the firm's signature over a SignedAnswer. Payload holds
+// the exact signed bytes, so clients can verify without re-encoding.
+type AnswerSignature struct {
+	Algorithm string    `json:"algorithm"`
+	KeyID     string    `json:"key_id"`
+	Payload   string    `json:"payload"`
+	Signature string    `json:"signature"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+var (
+	errSignAnswer        = errors.New("failed to sign the approved answer")
+	errUnknownSigningKey = errors.New("signed with an unknown key")
+	errBadSignature      = errors.New("signature does not match the payload")
+)
+
+// AnswerSigner signs approved answers with a crypto.Signer: the key in
+// SIGNING_KEY_FILE, or an HSM or KMS key passed in Options.Signer
+type AnswerSigner struct {
+	key       crypto.Signer
+	keyID     string
+	algorithm string
+	publicPEM string
+}
+
+func NewAnswerSigner(key crypto.Signer) (*AnswerSigner, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	algorithm, err := signatureAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &AnswerSigner{
+		key:       key,
+		keyID:     hex.EncodeToString(sum[:8]),
+		algorithm: algorithm,
+		publicPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+// signatureAlgorithm names the signature scheme used with a public key
+func signatureAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		return "Ed25519", nil
+	case *ecdsa.PublicKey:
+		return "ECDSA-SHA256", nil
+	case *rsa.PublicKey:
+		return "RSA-PKCS1v15-SHA256", nil
+	}
+	return "", fmt.Errorf("unsupported signing key type %T", pub)
+}
+
+// loadSigningKey reads a PKCS #8 private key from a PEM file. When generate
+// is set, a missing file is created with a new Ed25519 key.
+func loadSigningKey(path string, generate bool) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && generate {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, fmt.Errorf("failed to save signing key: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM encoded PKCS #8 private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %T, which cannot sign", path, key)
+	}
+	return signer, nil
+}
+
+// Sign signs the snapshot of an approved answer
+func (s *AnswerSigner) Sign(answer SignedAnswer) (AnswerSignature, error) {
+	payload, err := json.Marshal(answer)
+	if err != nil {
+		return AnswerSignature{}, err
+	}
+	sig, err := signPayload(s.key, payload)
+	if err != nil {
+		return AnswerSignature{}, err
+	}
+	return AnswerSignature{
+		Algorithm: s.algorithm,
+		KeyID:     s.keyID,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+		SignedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// Verify checks a signature made by this signer and returns the signed
+// snapshot
+func (s *AnswerSigner) Verify(sig AnswerSignature) (SignedAnswer, error) {
+	if sig.KeyID != s.keyID {
+		return SignedAnswer{}, errUnknownSigningKey
+	}
+	payload, err := base64.StdEncoding.DecodeString(sig.Payload)
+	if err != nil {
+		return SignedAnswer{}, fmt.Errorf("payload is not base64: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return SignedAnswer{}, fmt.Errorf("signature is not base64: %w", err)
+	}
+
+	if !verifyPayload(s.key.Public(), payload, signature) {
+		return SignedAnswer{}, errBadSignature
+	}
+
+	var answer SignedAnswer
+	if err := json.Unmarshal(payload, &answer); err != nil {
+		return SignedAnswer{}, fmt.Errorf("payload is not a signed answer: %w", err)
+	}
+	return answer, nil
+}
+
+// signPayload signs payload itself with an Ed25519 key, its SHA-256 digest
+// with other keys
+func signPayload(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verifyPayload checks a signature made by signPayload
+func verifyPayload(pub crypto.PublicKey, payload, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, payload, signature)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// signedAnswer is the snapshot of a history entry approved by an event
+func signedAnswer(entry HistoryEntry, approval ReviewEvent) SignedAnswer {
+	answer := SignedAnswer{
+		HistoryID:  entry.ID,
+		TenantID:   entry.TenantID,
+		Question:   entry.Question,
+		Answer:     entry.Response.Answer,
+		ApprovedBy: approval.By,
+		ApprovedAt: approval.At,
+	}
+	for _, r := range entry.Response.SearchResults {
+		answer.Sources = append(answer.Sources, sourceFromResult(r).Title)
+	}
+	return answer
+}
+
+// Handlers
+
+func signingKeyHandler(signer *AnswerSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"key_id":     signer.keyID,
+			"algorithm":  signer.algorithm,
+			"public_key": signer.publicPEM,
+		})
+	}
+}
+
+// verifySignatureHandler verifies a signature from a share or a review. It
+// answers 200 either way; valid reports the outcome.
+func verifySignatureHandler(signer *AnswerSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sig AnswerSignature
+		if err := c.ShouldBindJSON(&sig); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		answer, err := signer.Verify(sig)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "reason": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"valid": true, "key_id": sig.KeyID, "signed_answer": answer})
+	}
+}
+
+// answerSignatureHandler returns the signature of an approved answer and
+// whether the history entry still matches what was signed
+func answerSignatureHandler(reviews *ReviewStore, history *HistoryStore, edits *EditStore, signer *AnswerSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := reviewedEntry(c, history)
+		if !ok {
+			return
+		}
+		review := reviews.Get(entry.ID, entry.TenantID)
+		approval, approved := review.approval()
+		if !approved || review.Signature == nil {
+			abortWithError(c, ErrCodeReviewConflict, fmt.Sprintf("Answer %q is %s and has no signature", entry.ID, review.State))
+			return
+		}
+
+		resp := gin.H{"signature": review.Signature, "valid": false}
+		signed, err := signer.Verify(*review.Signature)
+		if err != nil {
+			resp["reason"] = err.Error()
+		} else {
+			current, _ := json.Marshal(signedAnswer(edits.Current(entry), approval))
+			payload, _ := base64.StdEncoding.DecodeString(review.Signature.Payload)
+			resp["valid"] = true
+			resp["signed_answer"] = signed
+			resp["matches_history"] = bytes.Equal(current, payload)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
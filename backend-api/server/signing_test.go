@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnswerSigner(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	answer := SignedAnswer{HistoryID: "q_1", Question: "Thời gian thử việc?", Answer: "Không quá 60 ngày.", ApprovedBy: "minh", ApprovedAt: time.Now().UTC()}
+
+	for _, key := range []crypto.Signer{edKey, ecKey} {
+		signer, err := NewAnswerSigner(key)
+		if err != nil {
+			t.Fatalf("NewAnswerSigner(%T): %v", key, err)
+		}
+		sig, err := signer.Sign(answer)
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", signer.algorithm, err)
+		}
+		got, err := signer.Verify(sig)
+		if err != nil || got.Answer != answer.Answer {
+			t.Errorf("%s: Verify = %+v, %v, want the signed answer", signer.algorithm, got, err)
+		}
+
+		tampered := answer
+		tampered.Answer = "Không quá 180 ngày."
+		forged, _ := signer.Sign(tampered)
+		forged.Signature = sig.Signature
+		if _, err := signer.Verify(forged); !errors.Is(err, errBadSignature) {
+			t.Errorf("%s: Verify(tampered) = %v, want errBadSignature", signer.algorithm, err)
+		}
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing_key.pem")
+	if _, err := loadSigningKey(path, false); err == nil {
+		t.Error("missing key without generate loaded, want an error")
+	}
+	generated, err := loadSigningKey(path, true)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	loaded, err := loadSigningKey(path, false)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !loaded.Public().(ed25519.PublicKey).Equal(generated.Public()) {
+		t.Error("reloaded key differs from the generated key")
+	}
+}
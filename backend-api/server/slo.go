@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/internal/settings"
+)
+
+// SLO is a latency objective for one endpoint: Objective of the requests must
+// succeed (status below 500) within Latency
+type SLO struct {
+	Name      string
+	Method    string
+	Route     string
+	Latency   time.Duration
+	Objective float64
+}
+
+// SLOConfig controls SLO tracking and burn-rate alerting
+type SLOConfig struct {
+	SLOs         []SLO
+	FastBurnRate float64
+	SlowBurnRate float64
+	MinRequests  int
+	AlertWebhook string
+	EvalInterval time.Duration
+}
+
+const defaultSLODefinitions = "POST /api/legal-query p95<15s,POST /api/legal-query/compare p95<30s"
+
+var sloSpecPattern = regexp.MustCompile(`^p(\d{1,2}(?:\.\d+)?)<(\S+)$`)
+
+// parseSLOs parses SLO_DEFINITIONS: comma-separated "METHOD /route pNN<latency"
+// items, e.g. "POST /api/legal-query p95<15s"
+func parseSLOs(value string) ([]SLO, error) {
+	var slos []SLO
+	for _, item := range settings.SplitList(value) {
+		var method, route, spec string
+		if n, _ := fmt.Sscan(item, &method, &route, &spec); n != 3 {
+			return nil, fmt.Errorf("invalid SLO %q, expected \"METHOD /route pNN<latency\"", item)
+		}
+		m := sloSpecPattern.FindStringSubmatch(spec)
+		if m == nil {
+			return nil, fmt.Errorf("invalid SLO target %q, expected pNN<latency", spec)
+		}
+		percentile, _ := strconv.ParseFloat(m[1], 64)
+		latency, err := time.ParseDuration(m[2])
+		if err != nil || latency <= 0 || percentile <= 0 {
+			return nil, fmt.Errorf("invalid SLO target %q", spec)
+		}
+		slos = append(slos, SLO{
+			Name:      item,
+			Method:    method,
+			Route:     route,
+			Latency:   latency,
+			Objective: percentile / 100,
+		})
+	}
+	return slos, nil
+}
+
+// burnRateRule fires when the error budget burns faster than Factor times the
+// sustainable rate over both windows; the short window makes the alert
+// resolve quickly once the problem is fixed
+type burnRateRule struct {
+	Severity string
+	Long     time.Duration
+	Short    time.Duration
+	Factor   float64
+}
+
+const (
+	// sloMinuteBuckets covers the longest burn-rate window
+	sloMinuteBuckets = 6 * 60
+
+	// sloHourBuckets covers the error budget period
+	sloHourBuckets = 30 * 24
+	sloBudgetLabel = "30d"
+)
+
+// sloBucket counts the requests of one minute or hour
+type sloBucket struct {
+	slot  int64
+	good  int
+	total int
+}
+
+// sloSeries is the request history of one SLO
+type sloSeries struct {
+	slo     SLO
+	minutes [sloMinuteBuckets]sloBucket
+	hours   [sloHourBuckets]sloBucket
+}
+
+func (s *sloSeries) record(now time.Time, good bool) {
+	for _, ring := range []struct {
+		buckets []sloBucket
+		slot    int64
+	}{
+		{s.minutes[:], now.Unix() / 60},
+		{s.hours[:], now.Unix() / 3600},
+	} {
+		b := &ring.buckets[ring.slot%int64(len(ring.buckets))]
+		if b.slot != ring.slot {
+			*b = sloBucket{slot: ring.slot}
+		}
+		b.total++
+		if good {
+			b.good++
+		}
+	}
+}
+
+// window sums the minute buckets of the last d
+func (s *sloSeries) window(now time.Time, d time.Duration) (good, total int) {
+	return sumBuckets(s.minutes[:], now.Unix()/60, int64(d/time.Minute))
+}
+
+func sumBuckets(buckets []sloBucket, current, span int64) (good, total int) {
+	for _, b := range buckets {
+		if b.total > 0 && b.slot > current-span && b.slot <= current {
+			good += b.good
+			total += b.total
+		}
+	}
+	return good, total
+}
+
+// burnRate is the error rate over the window relative to the error budget;
+// 1 means the budget would be used up exactly at the end of the period
+func (s *sloSeries) burnRate(now time.Time, d time.Duration) (float64, int) {
+	good, total := s.window(now, d)
+	if total == 0 {
+		return 0, 0
+	}
+	errorRate := float64(total-good) / float64(total)
+	return errorRate / (1 - s.slo.Objective), total
+}
+
+// SLOTracker records requests against the configured SLOs and alerts when an
+// error budget burns too fast
+type SLOTracker struct {
+	mu          sync.Mutex
+	series      map[string]*sloSeries
+	ordered     []*sloSeries
+	rules       []burnRateRule
+	minRequests int
+	notifier    Notifier
+	firing      map[string]bool
+}
+
+func NewSLOTracker(config SLOConfig, notifier Notifier) *SLOTracker {
+	t := &SLOTracker{
+		series: make(map[string]*sloSeries),
+		rules: []burnRateRule{
+			{Severity: "page", Long: time.Hour, Short: 5 * time.Minute, Factor: config.FastBurnRate},
+			{Severity: "ticket", Long: 6 * time.Hour, Short: 30 * time.Minute, Factor: config.SlowBurnRate},
+		},
+		minRequests: config.MinRequests,
+		notifier:    notifier,
+		firing:      make(map[string]bool),
+	}
+	for _, slo := range config.SLOs {
+		s := &sloSeries{slo: slo}
+		t.series[slo.Method+" "+slo.Route] = s
+		t.ordered = append(t.ordered, s)
+	}
+	return t
+}
+
+// Evaluate checks every burn-rate rule and notifies when an alert starts or
+// stops firing
+func (t *SLOTracker) Evaluate(now time.Time) {
+	var alerts []Alert
+	t.mu.Lock()
+	for _, s := range t.ordered {
+		for _, rule := range t.rules {
+			longRate, longTotal := s.burnRate(now, rule.Long)
+			shortRate, _ := s.burnRate(now, rule.Short)
+			firing := longTotal >= t.minRequests && longRate >= rule.Factor && shortRate >= rule.Factor
+
+			name := burnRateAlertName(s.slo, rule)
+			if firing == t.firing[name] {
+				continue
+			}
+			t.firing[name] = firing
+
+			status := AlertResolved
+			if firing {
+				status = AlertFiring
+			}
+			alerts = append(alerts, Alert{
+				Name:     name,
+				Severity: rule.Severity,
+				Status:   status,
+				Summary: fmt.Sprintf("%s: error budget burning at %.1fx over %s and %.1fx over %s (threshold %.1fx)",
+					s.slo.Name, longRate, formatWindow(rule.Long), shortRate, formatWindow(rule.Short), rule.Factor),
+				FiredAt: now.UTC(),
+			})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, alert := range alerts {
+		t.notifier.Notify(context.Background(), alert)
+	}
+}
+
+func burnRateAlertName(slo SLO, rule burnRateRule) string {
+	return fmt.Sprintf("slo_burn_rate:%s %s:%s", slo.Method, slo.Route, rule.Severity)
+}
+
+// Run evaluates the rules every interval until stop is closed
+func (t *SLOTracker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.Evaluate(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ErrorBudget reports how much of the budget of the period is left
+type ErrorBudget struct {
+	Period    string  `json:"period"`
+	Requests  int     `json:"requests"`
+	Bad       int     `json:"bad"`
+	Allowed   float64 `json:"allowed"`
+	Remaining float64 `json:"remaining"`
+}
+
+// SLOStatus is returned by the SLO admin endpoint
+type SLOStatus struct {
+	Name            string             `json:"name"`
+	Method          string             `json:"method"`
+	Route           string             `json:"route"`
+	LatencyTargetMs int64              `json:"latency_target_ms"`
+	Objective       float64            `json:"objective"`
+	ErrorBudget     ErrorBudget        `json:"error_budget"`
+	BurnRates       map[string]float64 `json:"burn_rates"`
+	Firing          []string           `json:"firing"`
+}
+
+func (t *SLOTracker) Status(now time.Time) []SLOStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := []SLOStatus{}
+	for _, s := range t.ordered {
+		good, total := sumBuckets(s.hours[:], now.Unix()/3600, sloHourBuckets)
+		budget := ErrorBudget{Period: sloBudgetLabel, Requests: total, Bad: total - good, Remaining: 1}
+		// Remaining goes negative once the budget is overspent
+		allowed := float64(total) * (1 - s.slo.Objective)
+		budget.Allowed = roundHundredths(allowed)
+		if allowed > 0 {
+			budget.Remaining = roundHundredths(1 - float64(budget.Bad)/allowed)
+		} else if budget.Bad > 0 {
+			budget.Remaining = 0
+		}
+
+		status := SLOStatus{
+			Name:            s.slo.Name,
+			Method:          s.slo.Method,
+			Route:           s.slo.Route,
+			LatencyTargetMs: s.slo.Latency.Milliseconds(),
+			Objective:       s.slo.Objective,
+			ErrorBudget:     budget,
+			BurnRates:       make(map[string]float64),
+			Firing:          []string{},
+		}
+		for _, d := range []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour} {
+			rate, _ := s.burnRate(now, d)
+			status.BurnRates[formatWindow(d)] = roundHundredths(rate)
+		}
+		for _, rule := range t.rules {
+			if t.firing[burnRateAlertName(s.slo, rule)] {
+				status.Firing = append(status.Firing, rule.Severity)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func roundHundredths(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func formatWindow(d time.Duration) string {
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// Middleware
+
+// sloMiddleware records every request to an endpoint with an SLO as good
+// when it succeeded within the latency target
+func sloMiddleware(tracker *SLOTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		s, ok := tracker.series[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			return
+		}
+		good := c.Writer.Status() < http.StatusInternalServerError && time.Since(start) <= s.slo.Latency
+
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		s.record(time.Now(), good)
+	}
+}
+
+// Handlers
+
+func sloStatusHandler(tracker *SLOTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"slos": tracker.Status(time.Now())})
+	}
+}
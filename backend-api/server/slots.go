@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// SlotConfig limits the engine queries in flight. Reserved of the Limit
+// slots are kept for tenants on one of PremiumPlans, so a burst of other
+// traffic cannot queue them behind it.
+type SlotConfig struct {
+	Limit        int
+	Reserved     int
+	PremiumPlans []string
+	Wait         time.Duration
+}
+
+// errEngineBusy is returned when no slot frees up within the wait
+var errEngineBusy = errors.New("every engine slot available to the caller is in use")
+
+// slotClass counts the queries of premium or other callers
+type slotClass struct {
+	queries  int
+	waited   int
+	rejected int
+	waitTime time.Duration
+}
+
+// slotLimiter hands out engine slots. Other callers share Limit-Reserved
+// slots; premium callers use a shared slot when one is free and a reserved
+// one otherwise. Callers wait up to Wait for a slot.
+type slotLimiter struct {
+	limit    int
+	reserved int
+	wait     time.Duration
+	premium  []string
+
+	mu            sync.Mutex
+	shared        int
+	reservedInUse int
+	peakReserved  int
+	changed       chan struct{}
+	classes       map[bool]*slotClass
+	started       time.Time
+	lastChange    time.Time
+	reservedBusy  time.Duration // reserved slots in use integrated over time
+}
+
+func newSlotLimiter(config SlotConfig) *slotLimiter {
+	now := time.Now()
+	return &slotLimiter{
+		limit:      config.Limit,
+		reserved:   config.Reserved,
+		wait:       config.Wait,
+		premium:    config.PremiumPlans,
+		changed:    make(chan struct{}),
+		classes:    map[bool]*slotClass{false: {}, true: {}},
+		started:    now,
+		lastChange: now,
+	}
+}
+
+// isPremium reports whether the caller's tenant is on a premium plan.
+// Callers without a tenant are never premium.
+func (l *slotLimiter) isPremium(c *gin.Context) bool {
+	tenant, ok := callerTenant(c)
+	return ok && slices.Contains(l.premium, tenant.Plan)
+}
+
+// acquire takes a slot, waiting up to the configured wait or until ctx is
+// done, and returns the function releasing it
+func (l *slotLimiter) acquire(ctx context.Context, premium bool) (func(), error) {
+	started := time.Now()
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	l.mu.Lock()
+	class := l.classes[premium]
+	class.queries++
+	waited := false
+	for {
+		if l.shared < l.limit-l.reserved {
+			l.shared++
+			l.recordWait(class, waited, started)
+			l.mu.Unlock()
+			return l.releaser(false), nil
+		}
+		if premium && l.reservedInUse < l.reserved {
+			l.integrateLocked()
+			l.reservedInUse++
+			l.peakReserved = max(l.peakReserved, l.reservedInUse)
+			l.recordWait(class, waited, started)
+			l.mu.Unlock()
+			return l.releaser(true), nil
+		}
+
+		waited = true
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+			l.mu.Lock()
+		case <-timer.C:
+			l.mu.Lock()
+			class.rejected++
+			class.waited++
+			class.waitTime += time.Since(started)
+			l.mu.Unlock()
+			return nil, errEngineBusy
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *slotLimiter) recordWait(class *slotClass, waited bool, started time.Time) {
+	if waited {
+		class.waited++
+		class.waitTime += time.Since(started)
+	}
+}
+
+func (l *slotLimiter) releaser(reserved bool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if reserved {
+				l.integrateLocked()
+				l.reservedInUse--
+			} else {
+				l.shared--
+			}
+			// Wake every waiter; those that find no slot wait again
+			close(l.changed)
+			l.changed = make(chan struct{})
+		})
+	}
+}
+
+// integrateLocked adds the reserved slots in use since the last change to
+// the utilization integral
+func (l *slotLimiter) integrateLocked() {
+	now := time.Now()
+	l.reservedBusy += time.Duration(l.reservedInUse) * now.Sub(l.lastChange)
+	l.lastChange = now
+}
+
+// SlotClassStats counts the queries of one class of callers
+type SlotClassStats struct {
+	Queries       int   `json:"queries"`
+	Waited        int   `json:"waited"`
+	Rejected      int   `json:"rejected"`
+	AverageWaitMs int64 `json:"average_wait_ms"`
+}
+
+// SlotStats is returned by the slots endpoint. ReservedUtilization is the
+// average share of the reserved slots in use since the server started.
+type SlotStats struct {
+	Limit               int            `json:"limit"`
+	Reserved            int            `json:"reserved"`
+	PremiumPlans        []string       `json:"premium_plans"`
+	InUse               int            `json:"in_use"`
+	ReservedInUse       int            `json:"reserved_in_use"`
+	PeakReservedInUse   int            `json:"peak_reserved_in_use"`
+	ReservedUtilization float64        `json:"reserved_utilization"`
+	Premium             SlotClassStats `json:"premium"`
+	Other               SlotClassStats `json:"other"`
+}
+
+func (l *slotLimiter) Stats() SlotStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.integrateLocked()
+	stats := SlotStats{
+		Limit:             l.limit,
+		Reserved:          l.reserved,
+		PremiumPlans:      l.premium,
+		InUse:             l.shared + l.reservedInUse,
+		ReservedInUse:     l.reservedInUse,
+		PeakReservedInUse: l.peakReserved,
+		Premium:           l.classes[true].stats(),
+		Other:             l.classes[false].stats(),
+	}
+	if elapsed := time.Since(l.started); l.reserved > 0 && elapsed > 0 {
+		stats.ReservedUtilization = float64(l.reservedBusy) / (float64(l.reserved) * float64(elapsed))
+	}
+	if stats.PremiumPlans == nil {
+		stats.PremiumPlans = []string{}
+	}
+	return stats
+}
+
+func (c *slotClass) stats() SlotClassStats {
+	stats := SlotClassStats{Queries: c.queries, Waited: c.waited, Rejected: c.rejected}
+	if c.waited > 0 {
+		stats.AverageWaitMs = (c.waitTime / time.Duration(c.waited)).Milliseconds()
+	}
+	return stats
+}
+
+// slotEngine holds an engine slot for every query. It sits below the
+// response cache, so cached answers never wait for a slot.
+type slotEngine struct {
+	next    engine.QueryEngine
+	limiter *slotLimiter
+}
+
+func (e *slotEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e *slotEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	release, err := e.limiter.acquire(ctx, req.Premium)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return engine.QueryWithContext(ctx, e.next, req)
+}
+
+// premiumEngine marks the queries of a premium caller
+type premiumEngine struct {
+	next engine.QueryEngine
+}
+
+func (e premiumEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	return e.QueryContext(context.Background(), req)
+}
+
+func (e premiumEngine) QueryContext(ctx context.Context, req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	req.Premium = true
+	return engine.QueryWithContext(ctx, e.next, req)
+}
+
+// Handlers
+
+func slotStatsHandler(limiter *slotLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			abortWithError(c, ErrCodeForbidden, "Engine slots are unlimited; set ENGINE_CONCURRENCY_LIMIT to enable them")
+			return
+		}
+		c.JSON(http.StatusOK, limiter.Stats())
+	}
+}
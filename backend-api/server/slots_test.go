@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestSlotLimiter(t *testing.T) {
+	limiter := newSlotLimiter(SlotConfig{Limit: 3, Reserved: 1, Wait: 50 * time.Millisecond})
+
+	var releases []func()
+	for range 2 {
+		release, err := limiter.acquire(context.Background(), false)
+		if err != nil {
+			t.Fatalf("acquire() with a shared slot free = %v", err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := limiter.acquire(context.Background(), false); !errors.Is(err, errEngineBusy) {
+		t.Fatalf("acquire() with only the reserved slot free = %v, want %v", err, errEngineBusy)
+	}
+	premium, err := limiter.acquire(context.Background(), true)
+	if err != nil {
+		t.Fatalf("premium acquire() = %v, want the reserved slot", err)
+	}
+	if stats := limiter.Stats(); stats.InUse != 3 || stats.ReservedInUse != 1 {
+		t.Errorf("stats = %+v, want 3 slots in use, 1 reserved", stats)
+	}
+
+	// A waiting query gets the next slot released
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releases[0]()
+	}()
+	release, err := limiter.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("acquire() while a slot is released = %v", err)
+	}
+	release()
+	releases[1]()
+	premium()
+	premium() // releasing twice is harmless
+
+	stats := limiter.Stats()
+	if stats.InUse != 0 || stats.PeakReservedInUse != 1 || stats.ReservedUtilization <= 0 {
+		t.Errorf("stats = %+v, want no slot in use and the reservation used", stats)
+	}
+	if stats.Other.Queries != 4 || stats.Other.Rejected != 1 || stats.Other.Waited != 2 || stats.Premium.Queries != 1 {
+		t.Errorf("class stats = %+v %+v, want 4 other queries (1 rejected, 2 waited) and 1 premium", stats.Other, stats.Premium)
+	}
+}
+
+// blockingEngine holds queries mentioning "chờ" until released
+type blockingEngine struct {
+	stubEngine
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	if strings.Contains(req.Question, "chờ") {
+		e.started <- struct{}{}
+		<-e.release
+	}
+	return e.stubEngine.Query(req)
+}
+
+func TestPremiumSlots(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("ENGINE_CONCURRENCY_LIMIT", "2")
+	t.Setenv("PREMIUM_RESERVED_SLOTS", "1")
+	t.Setenv("ENGINE_SLOT_WAIT", "50ms")
+	t.Setenv("RESPONSE_CACHE_MAX_ENTRIES", "0")
+	blocking := &blockingEngine{
+		stubEngine: stubEngine{resp: engine.LegalQueryResponse{Answer: "Không quá 180 ngày.", Iterations: 1}},
+		started:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	h := newTestServer(t, Options{Engine: blocking}).Handler()
+
+	admin := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := admin(http.MethodPost, "/admin/tenants", map[string]string{"id": "acme", "name": "ACME", "plan": defaultPlanName}); rec.Code != http.StatusCreated {
+		t.Fatalf("create tenant = %d %s", rec.Code, rec.Body.String())
+	}
+	query := func(tenant, question string) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		json.NewEncoder(&buf).Encode(LegalQueryRequest{Question: question})
+		req := httptest.NewRequest(http.MethodPost, "/api/legal-query", strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		if tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A slow query holds the only shared slot
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- query("", "Thời gian thử việc tối đa theo Bộ luật Lao động 2019 là bao nhiêu ngày, xin chờ kết quả?")
+	}()
+	<-blocking.started
+
+	question := "Thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	if code := decodeError(t, query("", question)).Code; code != ErrCodeEngineBusy {
+		t.Errorf("query without a free shared slot = %s, want %s", code, ErrCodeEngineBusy)
+	}
+	if rec := query("acme", question); rec.Code != http.StatusOK {
+		t.Errorf("premium query = %d %s, want the reserved slot", rec.Code, rec.Body.String())
+	}
+
+	close(blocking.release)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Errorf("slow query = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := admin(http.MethodGet, "/admin/slots", nil)
+	var stats SlotStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("slot stats = %d %s", rec.Code, rec.Body.String())
+	}
+	if stats.Premium.Queries != 1 || stats.Other.Rejected != 1 || stats.PeakReservedInUse != 1 {
+		t.Errorf("slot stats = %+v, want 1 premium query on the reserved slot and 1 rejection", stats)
+	}
+}
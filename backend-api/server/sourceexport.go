@@ -0,0 +1,241 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Kinds of the documents in a source export
+const (
+	// SourceFullText is the full text of a cited article, from the engine
+	SourceFullText = "full_text"
+
+	// SourceExcerpt is the cited passage alone, when the engine cannot serve
+	// the full article
+	SourceExcerpt = "excerpt"
+
+	// SourceWeb is a web result, with its URL and the content that was cited
+	SourceWeb = "web"
+
+	// SourceOfficialPDF is the official PDF of a legal document from
+	// SOURCE_PDF_DIR
+	SourceOfficialPDF = "official_pdf"
+)
+
+// sourceFileNamePattern matches IDs usable as file names in an export
+var sourceFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// SourceExportManifest is manifest.json of a source export
+type SourceExportManifest struct {
+	HistoryID  string                 `json:"history_id"`
+	Question   string                 `json:"question"`
+	AnsweredAt time.Time              `json:"answered_at"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Documents  []SourceExportDocument `json:"documents"`
+
+	// Disclaimer is the disclaimer of the tenant at the time of the export
+	Disclaimer *engine.DisclaimerStamp `json:"disclaimer,omitempty"`
+}
+
+// SourceExportDocument is a file of a source export
+type SourceExportDocument struct {
+	File       string `json:"file"`
+	Kind       string `json:"kind"`
+	Title      string `json:"title"`
+	ArticleID  string `json:"article_id,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+type exportFile struct {
+	doc  SourceExportDocument
+	data []byte
+}
+
+// sourceExporter bundles the sources an answer cited. Without an article
+// source, cited articles are exported as their excerpts.
+type sourceExporter struct {
+	articles    engine.ArticleSource
+	pdfDir      string
+	disclaimers *DisclaimerStore
+}
+
+// export returns the ZIP of the sources of entry: one file per cited
+// article, each web result, the official PDF of each cited document when
+// there is one, and manifest.json listing them
+func (e *sourceExporter) export(ctx context.Context, entry HistoryEntry) ([]byte, error) {
+	var files []exportFile
+	numbered := 0
+	articles := make(map[string]bool)
+	documents := make(map[string]bool)
+	for _, r := range entry.Response.SearchResults {
+		articleID := resultArticleID(r)
+		if articleID != "" {
+			// Several chunks of one article are exported once
+			if articles[articleID] {
+				continue
+			}
+			articles[articleID] = true
+		}
+		file := e.articleFile(ctx, r, articleID)
+		name := "excerpt"
+		if sourceFileNamePattern.MatchString(articleID) {
+			name = articleID
+		}
+		numbered++
+		file.doc.File = fmt.Sprintf("sources/%02d-%s.txt", numbered, name)
+		files = append(files, file)
+
+		if id := file.doc.DocumentID; id != "" && !documents[id] {
+			documents[id] = true
+			if pdf, ok := e.officialPDF(id); ok {
+				files = append(files, pdf)
+			}
+		}
+	}
+	for i, r := range entry.Response.WebResults {
+		source := sourceFromWebResult(r)
+		files = append(files, exportFile{
+			doc: SourceExportDocument{
+				File:  fmt.Sprintf("web/%02d.txt", i+1),
+				Kind:  SourceWeb,
+				Title: source.Title,
+				URL:   source.URL,
+			},
+			data: []byte(fmt.Sprintf("%s\n%s\n\n%s\n", source.Title, source.URL, strings.TrimSpace(r.Content))),
+		})
+	}
+
+	manifest := SourceExportManifest{
+		HistoryID:  entry.ID,
+		Question:   entry.Question,
+		AnsweredAt: entry.CreatedAt,
+		ExportedAt: time.Now().UTC(),
+		Documents:  []SourceExportDocument{},
+		Disclaimer: e.disclaimers.Stamp(entry.TenantID),
+	}
+	for _, f := range files {
+		manifest.Documents = append(manifest.Documents, f.doc)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	files = append([]exportFile{{doc: SourceExportDocument{File: "manifest.json"}, data: data}}, files...)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.doc.File, Method: zip.Deflate, Modified: manifest.ExportedAt})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", f.doc.File, err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", f.doc.File, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// articleFile is the full text of a cited article, or the cited passage
+// when the engine cannot serve the article
+func (e *sourceExporter) articleFile(ctx context.Context, r engine.DocumentHit, articleID string) exportFile {
+	if e.articles != nil && articleID != "" {
+		article, err := e.articles.Article(ctx, articleID)
+		if err == nil {
+			title := strings.TrimSpace(article.Article)
+			if t := truncateUTF8(strings.TrimSpace(article.Title), maxSourceTitleLen); t != "" {
+				title = strings.TrimSpace(title + ". " + t)
+			}
+			heading := title
+			if article.Chapter != "" {
+				heading = fmt.Sprintf("%s\n%s: %s", title, article.Chapter, article.ChapterTitle)
+			}
+			return exportFile{
+				doc: SourceExportDocument{
+					Kind:       SourceFullText,
+					Title:      title,
+					ArticleID:  articleID,
+					DocumentID: article.DocumentID,
+				},
+				data: []byte(fmt.Sprintf("%s\n\n%s\n", heading, strings.TrimSpace(article.Text))),
+			}
+		}
+		if !errors.Is(err, engine.ErrArticleNotFound) {
+			slog.WarnContext(ctx, "Failed to fetch article for export", "article", articleID, "error", err)
+		}
+	}
+
+	source := sourceFromResult(r)
+	return exportFile{
+		doc: SourceExportDocument{
+			Kind:       SourceExcerpt,
+			Title:      source.Title,
+			ArticleID:  articleID,
+			DocumentID: r.DocumentID,
+		},
+		data: []byte(strings.TrimSpace(r.Excerpt) + "\n"),
+	}
+}
+
+// officialPDF reads <document_id>.pdf from SOURCE_PDF_DIR
+func (e *sourceExporter) officialPDF(documentID string) (exportFile, bool) {
+	if e.pdfDir == "" || !sourceFileNamePattern.MatchString(documentID) {
+		return exportFile{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(e.pdfDir, documentID+".pdf"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to read official PDF", "document", documentID, "error", err)
+		}
+		return exportFile{}, false
+	}
+	return exportFile{
+		doc: SourceExportDocument{
+			File:       fmt.Sprintf("official/%s.pdf", documentID),
+			Kind:       SourceOfficialPDF,
+			Title:      documentID,
+			DocumentID: documentID,
+		},
+		data: data,
+	}, true
+}
+
+// Handlers
+
+func exportSourcesHandler(history *HistoryStore, exporter *sourceExporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		entry, err := history.Get(c.Param("id"), tenant.ID)
+		if err != nil {
+			abortWithError(c, ErrCodeHistoryNotFound, fmt.Sprintf("History entry %q not found", c.Param("id")))
+			return
+		}
+		data, err := exporter.export(c.Request.Context(), entry)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to export sources", "history_id", entry.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to export the sources")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-sources.zip"`, entry.ID))
+		c.Data(http.StatusOK, "application/zip", data)
+	}
+}
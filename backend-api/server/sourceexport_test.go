@@ -0,0 +1,128 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// articleEngine is a stubEngine that also serves the full text of articles
+type articleEngine struct {
+	stubEngine
+	articles map[string]engine.Article
+}
+
+func (e *articleEngine) Article(ctx context.Context, id string) (*engine.Article, error) {
+	article, ok := e.articles[id]
+	if !ok {
+		return nil, engine.ErrArticleNotFound
+	}
+	return &article, nil
+}
+
+// readExport opens an exported ZIP and returns its files by name
+func readExport(t *testing.T, data []byte) (SourceExportManifest, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open export: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(content)
+	}
+	var manifest SourceExportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("decode manifest %q: %v", files["manifest.json"], err)
+	}
+	return manifest, files
+}
+
+func TestExportSources(t *testing.T) {
+	resp := engine.LegalQueryResponse{
+		Answer: "Theo Điều 25 Bộ luật Lao động 2019, thời gian thử việc không quá 60 ngày.",
+		SearchResults: []engine.DocumentHit{
+			{Excerpt: "Điều 25. Thời gian thử việc không quá 60 ngày", ArticleNumber: "Dieu_25", Title: "Thời gian thử việc"},
+			{Excerpt: "Điều 25. ... không quá 06 ngày làm việc", ArticleNumber: "Dieu_25"},
+			{Excerpt: "Điều 27. Kết thúc thời gian thử việc", Article: "Điều 27", Title: "Kết thúc thời gian thử việc"},
+		},
+		WebResults: []engine.WebHit{{Title: "Hỏi đáp thử việc", URL: "https://example.vn/thu-viec", Content: "Thử việc tối đa 180 ngày với người quản lý"}},
+		Iterations: 1,
+	}
+	stub := &articleEngine{
+		stubEngine: stubEngine{resp: resp},
+		articles: map[string]engine.Article{
+			"Dieu_25": {ArticleID: "Dieu_25", DocumentID: "BoLuatLaoDong2019", Article: "Điều 25", Title: "Thời gian thử việc", Text: "Thời gian thử việc do hai bên thỏa thuận..."},
+		},
+	}
+	pdfDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pdfDir, "BoLuatLaoDong2019.pdf"), []byte("%PDF-1.7"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOURCE_PDF_DIR", pdfDir)
+	srv := newTestServer(t, Options{Engine: stub})
+	h := srv.Handler()
+
+	question := "Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc cần trình độ cao đẳng là bao nhiêu ngày?"
+	rec := doAs(t, h, "lan", http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: question})
+	var answer engine.LegalQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &answer); err != nil || answer.HistoryID == "" {
+		t.Fatalf("query = %d %s, want a history entry", rec.Code, rec.Body.String())
+	}
+
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/history/"+answer.HistoryID+"/sources/export", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export = %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	manifest, files := readExport(t, rec.Body.Bytes())
+	if manifest.HistoryID != answer.HistoryID || manifest.Question != question {
+		t.Errorf("manifest = %+v, want the history entry", manifest)
+	}
+
+	// Both chunks of Điều 25 are exported once, as the full article; Điều 27
+	// is not served by the engine and falls back to its excerpt
+	want := []struct{ file, kind string }{
+		{"sources/01-Dieu_25.txt", SourceFullText},
+		{"official/BoLuatLaoDong2019.pdf", SourceOfficialPDF},
+		{"sources/02-Dieu_27.txt", SourceExcerpt},
+		{"web/01.txt", SourceWeb},
+	}
+	if len(manifest.Documents) != len(want) {
+		t.Fatalf("documents = %+v, want %d", manifest.Documents, len(want))
+	}
+	for i, w := range want {
+		doc := manifest.Documents[i]
+		if doc.File != w.file || doc.Kind != w.kind {
+			t.Errorf("document %d = %s (%s), want %s (%s)", i, doc.File, doc.Kind, w.file, w.kind)
+		}
+		if _, ok := files[doc.File]; !ok {
+			t.Errorf("%s is listed in the manifest but missing from the archive", doc.File)
+		}
+	}
+	if got := files["sources/01-Dieu_25.txt"]; !strings.Contains(got, "do hai bên thỏa thuận") {
+		t.Errorf("Điều 25 = %q, want the full text", got)
+	}
+	if manifest.Documents[0].DocumentID != "BoLuatLaoDong2019" || manifest.Documents[3].URL != "https://example.vn/thu-viec" {
+		t.Errorf("documents = %+v, want the document ID and web URL", manifest.Documents)
+	}
+
+	rec = doAs(t, h, "lan", http.MethodGet, "/api/history/missing/sources/export", nil)
+	if code := decodeError(t, rec).Code; code != ErrCodeHistoryNotFound {
+		t.Errorf("export of an unknown entry = %s, want HISTORY_NOT_FOUND", code)
+	}
+}
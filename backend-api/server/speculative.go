@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// queryAbbreviations expands abbreviations common in user questions but
+// absent from the legal corpus
+var queryAbbreviations = map[string]string{
+	"NSDLĐ": "người sử dụng lao động",
+	"NLĐ":   "người lao động",
+	"HĐLĐ":  "hợp đồng lao động",
+	"BLLĐ":  "Bộ luật Lao động",
+	"BHXH":  "bảo hiểm xã hội",
+	"BHYT":  "bảo hiểm y tế",
+	"BHTN":  "bảo hiểm thất nghiệp",
+}
+
+// queryFiller lists conversational phrases that carry no retrieval signal,
+// longest first so that "là bao nhiêu" is removed before "bao nhiêu"
+var queryFiller = [][]string{
+	{"cho", "tôi", "hỏi"}, {"cho", "em", "hỏi"}, {"cho", "mình", "hỏi"},
+	{"là", "bao", "nhiêu"}, {"như", "thế", "nào"}, {"có", "được", "không"},
+	{"xin", "hỏi"}, {"cho", "hỏi"}, {"bao", "nhiêu"}, {"thế", "nào"},
+	{"được", "không"}, {"hay", "không"}, {"vậy"}, {"ạ"}, {"nhé"},
+}
+
+// rewriteQuery returns a retrieval-oriented variant of a question, with
+// abbreviations expanded and conversational filler and punctuation removed,
+// or "" when the rewrite would not differ from the question or keeps too
+// little of it
+func rewriteQuery(question string) string {
+	words := strings.FieldsFunc(question, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var kept []string
+	for i := 0; i < len(words); {
+		if n := fillerAt(words[i:]); n > 0 {
+			i += n
+			continue
+		}
+		if expanded, ok := queryAbbreviations[strings.ToUpper(words[i])]; ok {
+			kept = append(kept, expanded)
+		} else {
+			kept = append(kept, words[i])
+		}
+		i++
+	}
+
+	rewritten := strings.Join(kept, " ")
+	if len(contentTokens(rewritten)) < minQuestionTokens || strings.EqualFold(rewritten, strings.Join(words, " ")) {
+		return ""
+	}
+	return rewritten
+}
+
+// fillerAt returns the length of the filler phrase words start with, or 0
+func fillerAt(words []string) int {
+	for _, phrase := range queryFiller {
+		if len(phrase) > len(words) {
+			continue
+		}
+		match := true
+		for j, w := range phrase {
+			if strings.ToLower(words[j]) != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return len(phrase)
+		}
+	}
+	return 0
+}
+
+// SpeculationStats counts how often each retrieval path wins, for tuning the
+// rewriter
+type SpeculationStats struct {
+	mu      sync.Mutex
+	queries int
+	wins    map[string]int
+	margin  float64
+}
+
+func NewSpeculationStats() *SpeculationStats {
+	return &SpeculationStats{wins: make(map[string]int)}
+}
+
+// Record counts the winner of a speculative retrieval along with its margin
+// over the runner-up mean score
+func (s *SpeculationStats) Record(spec *engine.Speculation) {
+	if spec == nil || spec.Winner == "" {
+		return
+	}
+	var winner, runnerUp float64
+	for _, c := range spec.Candidates {
+		if c.Path == spec.Winner {
+			winner = c.MeanScore
+		} else {
+			runnerUp = max(runnerUp, c.MeanScore)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	s.wins[spec.Winner]++
+	s.margin += winner - runnerUp
+}
+
+// SpeculationSummary is returned by the speculation stats endpoint
+type SpeculationSummary struct {
+	Queries       int            `json:"queries"`
+	Wins          map[string]int `json:"wins"`
+	AverageMargin float64        `json:"average_margin"`
+}
+
+func (s *SpeculationStats) Summary() SpeculationSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := SpeculationSummary{Queries: s.queries, Wins: make(map[string]int, len(s.wins))}
+	for path, n := range s.wins {
+		summary.Wins[path] = n
+	}
+	if s.queries > 0 {
+		summary.AverageMargin = s.margin / float64(s.queries)
+	}
+	return summary
+}
+
+// Handlers
+
+func speculationStatsHandler(stats *SpeculationStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, stats.Summary())
+	}
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusKind is what a status message announces
+type StatusKind string
+
+const (
+	StatusMaintenance  StatusKind = "maintenance"
+	StatusDegraded     StatusKind = "degraded"
+	StatusCorpusUpdate StatusKind = "corpus_update"
+	StatusNotice       StatusKind = "notice"
+)
+
+// StatusSeverity tells frontends how prominently to show a message
+type StatusSeverity string
+
+const (
+	SeverityInfo     StatusSeverity = "info"
+	SeverityWarning  StatusSeverity = "warning"
+	SeverityCritical StatusSeverity = "critical"
+)
+
+var (
+	statusKinds      = []StatusKind{StatusMaintenance, StatusDegraded, StatusCorpusUpdate, StatusNotice}
+	statusSeverities = []StatusSeverity{SeverityInfo, SeverityWarning, SeverityCritical}
+)
+
+// autoStatusPrefix starts the IDs of messages set by the server itself
+const autoStatusPrefix = "auto_"
+
+// StatusMessage is a banner shown by frontends, such as planned
+// maintenance. Messages set by operators may be scheduled with StartsAt and
+// EndsAt; messages set by the server stay until their condition clears.
+type StatusMessage struct {
+	ID        string         `json:"id"`
+	Kind      StatusKind     `json:"kind"`
+	Severity  StatusSeverity `json:"severity"`
+	Message   string         `json:"message"`
+	StartsAt  *time.Time     `json:"starts_at,omitempty"`
+	EndsAt    *time.Time     `json:"ends_at,omitempty"`
+	Automatic bool           `json:"automatic,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ended reports a message whose EndsAt has passed
+func (m *StatusMessage) ended(now time.Time) bool {
+	return m.EndsAt != nil && !now.Before(*m.EndsAt)
+}
+
+var (
+	errStatusNotFound  = errors.New("status message not found")
+	errStatusAutomatic = errors.New("status message is set automatically")
+)
+
+// StatusBoard keeps the status messages: those set by operators, persisted
+// to a JSON file, and those set by the server, kept in memory
+type StatusBoard struct {
+	mu        sync.Mutex
+	path      string
+	messages  map[string]*StatusMessage
+	automatic map[string]*StatusMessage
+	now       func() time.Time
+}
+
+func NewStatusBoard(path string) (*StatusBoard, error) {
+	board := &StatusBoard{
+		path:      path,
+		messages:  make(map[string]*StatusMessage),
+		automatic: make(map[string]*StatusMessage),
+		now:       time.Now,
+	}
+	var messages []*StatusMessage
+	if _, err := readJSONFile(path, &messages); err != nil {
+		return nil, fmt.Errorf("failed to load status messages: %w", err)
+	}
+	for _, m := range messages {
+		board.messages[m.ID] = m
+	}
+	return board, nil
+}
+
+// Current returns the messages to show: every message but those that ended,
+// including maintenance announced ahead of its StartsAt, most severe first
+func (b *StatusBoard) Current() []StatusMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	messages := make([]StatusMessage, 0, len(b.messages)+len(b.automatic))
+	for _, m := range b.automatic {
+		messages = append(messages, *m)
+	}
+	for _, m := range b.messages {
+		if !m.ended(now) {
+			messages = append(messages, *m)
+		}
+	}
+	sortStatusMessages(messages)
+	return messages
+}
+
+// List returns every message, ended ones included
+func (b *StatusBoard) List() []StatusMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := make([]StatusMessage, 0, len(b.messages)+len(b.automatic))
+	for _, m := range b.automatic {
+		messages = append(messages, *m)
+	}
+	for _, m := range b.messages {
+		messages = append(messages, *m)
+	}
+	sortStatusMessages(messages)
+	return messages
+}
+
+func sortStatusMessages(messages []StatusMessage) {
+	rank := func(s StatusSeverity) int {
+		for i, severity := range statusSeverities {
+			if s == severity {
+				return i
+			}
+		}
+		return 0
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if ri, rj := rank(messages[i].Severity), rank(messages[j].Severity); ri != rj {
+			return ri > rj
+		}
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+}
+
+// Create stores a message set by an operator
+func (b *StatusBoard) Create(m StatusMessage) (StatusMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now().UTC()
+	m.ID = "st_" + randomHex(8)
+	m.Automatic = false
+	m.CreatedAt, m.UpdatedAt = now, now
+	b.messages[m.ID] = &m
+	return m, b.saveLocked()
+}
+
+// Update replaces the content of a message set by an operator
+func (b *StatusBoard) Update(id string, update StatusMessage) (StatusMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.messages[id]
+	if !ok {
+		if _, ok := b.automatic[id]; ok {
+			return StatusMessage{}, errStatusAutomatic
+		}
+		return StatusMessage{}, errStatusNotFound
+	}
+	m.Kind, m.Severity, m.Message = update.Kind, update.Severity, update.Message
+	m.StartsAt, m.EndsAt = update.StartsAt, update.EndsAt
+	m.UpdatedAt = b.now().UTC()
+	return *m, b.saveLocked()
+}
+
+// Delete removes a message set by an operator
+func (b *StatusBoard) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.messages[id]; !ok {
+		if _, ok := b.automatic[id]; ok {
+			return errStatusAutomatic
+		}
+		return errStatusNotFound
+	}
+	delete(b.messages, id)
+	return b.saveLocked()
+}
+
+// Raise sets the message of a condition detected by the server, such as an
+// unhealthy engine region, under a key naming the condition. Raising it
+// again updates the message.
+func (b *StatusBoard) Raise(key string, kind StatusKind, severity StatusSeverity, message string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now().UTC()
+	id := autoStatusPrefix + key
+	m, ok := b.automatic[id]
+	if !ok {
+		m = &StatusMessage{ID: id, Automatic: true, CreatedAt: now}
+		b.automatic[id] = m
+		slog.Info("Status message raised", "id", id, "message", message)
+	}
+	m.Kind, m.Severity, m.Message, m.UpdatedAt = kind, severity, message, now
+}
+
+// Clear removes the message of a condition once it is resolved
+func (b *StatusBoard) Clear(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := autoStatusPrefix + key
+	if _, ok := b.automatic[id]; ok {
+		delete(b.automatic, id)
+		slog.Info("Status message cleared", "id", id)
+	}
+}
+
+func (b *StatusBoard) saveLocked() error {
+	if b.path == "" {
+		return nil
+	}
+	messages := make([]*StatusMessage, 0, len(b.messages))
+	for _, m := range b.messages {
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return writeJSONFile(b.path, messages)
+}
+
+// Handlers
+
+// StatusMessageRequest is the body of POST and PUT /admin/status
+type StatusMessageRequest struct {
+	Kind     StatusKind     `json:"kind" binding:"required"`
+	Severity StatusSeverity `json:"severity"`
+	Message  string         `json:"message" binding:"required"`
+	StartsAt *time.Time     `json:"starts_at"`
+	EndsAt   *time.Time     `json:"ends_at"`
+}
+
+// message validates the request and returns the message it describes
+func (r StatusMessageRequest) message() (StatusMessage, error) {
+	m := StatusMessage{
+		Kind:     r.Kind,
+		Severity: r.Severity,
+		Message:  strings.TrimSpace(r.Message),
+		StartsAt: r.StartsAt,
+		EndsAt:   r.EndsAt,
+	}
+	if m.Severity == "" {
+		m.Severity = SeverityInfo
+	}
+	switch {
+	case !slices.Contains(statusKinds, m.Kind):
+		return m, fmt.Errorf("kind must be one of %v", statusKinds)
+	case !slices.Contains(statusSeverities, m.Severity):
+		return m, fmt.Errorf("severity must be one of %v", statusSeverities)
+	case m.Message == "":
+		return m, errors.New("message must not be empty")
+	case m.StartsAt != nil && m.EndsAt != nil && !m.EndsAt.After(*m.StartsAt):
+		return m, errors.New("ends_at must be after starts_at")
+	}
+	return m, nil
+}
+
+// statusHandler serves the messages frontends show as banners
+func statusHandler(board *StatusBoard, monitor *EngineMonitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"messages": board.Current(), "engine": monitor.Health(time.Now().UTC())})
+	}
+}
+
+func listStatusHandler(board *StatusBoard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"messages": board.List()})
+	}
+}
+
+func createStatusHandler(board *StatusBoard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req StatusMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		m, err := req.message()
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		m, err = board.Create(m)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save status message", "id", m.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save status message")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Created status message", "id", m.ID, "kind", m.Kind)
+		c.JSON(http.StatusCreated, m)
+	}
+}
+
+func updateStatusHandler(board *StatusBoard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req StatusMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		m, err := req.message()
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		m, err = board.Update(c.Param("id"), m)
+		if err != nil {
+			abortWithStatusError(c, err, "update")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Updated status message", "id", m.ID)
+		c.JSON(http.StatusOK, m)
+	}
+}
+
+func deleteStatusHandler(board *StatusBoard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := board.Delete(c.Param("id")); err != nil {
+			abortWithStatusError(c, err, "delete")
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Deleted status message", "id", c.Param("id"))
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func abortWithStatusError(c *gin.Context, err error, action string) {
+	id := c.Param("id")
+	switch {
+	case errors.Is(err, errStatusNotFound):
+		abortWithError(c, ErrCodeStatusNotFound, fmt.Sprintf("Unknown status message %q", id))
+	case errors.Is(err, errStatusAutomatic):
+		abortWithError(c, ErrCodeForbidden, fmt.Sprintf("Status message %q is set by the server and clears when its condition is resolved", id))
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to change status message", "action", action, "id", id, "error", err)
+		abortWithError(c, ErrCodeInternal, fmt.Sprintf("Failed to %s status message", action))
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusMessages(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	srv := newTestServer(t, Options{Engine: &stubEngine{}})
+	h := srv.Handler()
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf strings.Builder
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, strings.NewReader(buf.String()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	current := func() []StatusMessage {
+		t.Helper()
+		var resp struct {
+			Messages []StatusMessage `json:"messages"`
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status = %d %s", rec.Code, rec.Body.String())
+		}
+		return resp.Messages
+	}
+
+	if messages := current(); len(messages) != 0 {
+		t.Fatalf("messages = %+v, want none", messages)
+	}
+
+	// Planned maintenance is announced before it starts; messages that
+	// ended are hidden
+	start := time.Now().Add(time.Hour)
+	end := start.Add(2 * time.Hour)
+	var planned StatusMessage
+	rec := do(http.MethodPost, "/admin/status", StatusMessageRequest{Kind: StatusMaintenance, Severity: SeverityWarning, Message: "Bảo trì hệ thống lúc 22:00", StartsAt: &start, EndsAt: &end})
+	if err := json.Unmarshal(rec.Body.Bytes(), &planned); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body.String())
+	}
+	past := time.Now().Add(-time.Minute)
+	do(http.MethodPost, "/admin/status", StatusMessageRequest{Kind: StatusCorpusUpdate, Message: "Đang cập nhật văn bản luật", EndsAt: &past})
+	if messages := current(); len(messages) != 1 || messages[0].ID != planned.ID {
+		t.Errorf("messages = %+v, want the planned maintenance only", messages)
+	}
+
+	if code := decodeError(t, do(http.MethodPost, "/admin/status", StatusMessageRequest{Kind: "outage", Message: "?"})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("unknown kind = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+	if code := decodeError(t, do(http.MethodPost, "/admin/status", StatusMessageRequest{Kind: StatusMaintenance, Message: "x", StartsAt: &end, EndsAt: &start})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("ends before it starts = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+
+	rec = do(http.MethodPut, "/admin/status/"+planned.ID, StatusMessageRequest{Kind: StatusMaintenance, Severity: SeverityCritical, Message: "Bảo trì kéo dài đến 02:00"})
+	var updated StatusMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); err != nil || updated.Severity != SeverityCritical || updated.EndsAt != nil {
+		t.Errorf("update = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodDelete, "/admin/status/"+planned.ID, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("delete = %d %s", rec.Code, rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodDelete, "/admin/status/"+planned.ID, nil)).Code; code != ErrCodeStatusNotFound {
+		t.Errorf("delete twice = %s, want %s", code, ErrCodeStatusNotFound)
+	}
+}
+
+func TestStatusBoardAutomatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	board, err := NewStatusBoard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := board.Create(StatusMessage{Kind: StatusNotice, Severity: SeverityInfo, Message: "Phiên bản mới"}); err != nil {
+		t.Fatal(err)
+	}
+	board.Raise("region_primary", StatusDegraded, SeverityWarning, "Engine region primary is unavailable")
+	board.Raise("region_primary", StatusDegraded, SeverityWarning, "Engine region primary is still unavailable")
+
+	messages := board.Current()
+	if len(messages) != 2 || !messages[0].Automatic || messages[0].Message != "Engine region primary is still unavailable" {
+		t.Fatalf("messages = %+v, want the raised message first", messages)
+	}
+	if err := board.Delete(messages[0].ID); err != errStatusAutomatic {
+		t.Errorf("delete automatic message = %v, want %v", err, errStatusAutomatic)
+	}
+	board.Clear("region_primary")
+	if messages := board.Current(); len(messages) != 1 || messages[0].Automatic {
+		t.Errorf("messages after clearing = %+v, want the operator's only", messages)
+	}
+
+	// Only operator messages are persisted
+	board.Raise("region_primary", StatusDegraded, SeverityWarning, "down")
+	reloaded, err := NewStatusBoard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages := reloaded.List(); len(messages) != 1 || messages[0].Kind != StatusNotice {
+		t.Errorf("reloaded = %+v, want the operator message", messages)
+	}
+}
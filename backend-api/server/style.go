@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Built-in style used for dimensions the client leaves unset
+var defaultAnswerStyle = engine.AnswerStyle{
+	Length:   "detailed",
+	Tone:     "formal",
+	Audience: "layperson",
+}
+
+// styleVariants holds the prompt instructions for every value of every style
+// dimension. The engine appends the instructions of the resolved style to its
+// answer prompt.
+var styleVariants = map[string]map[string]string{
+	"length": {
+		"concise":  "Trả lời ngắn gọn trong 2-4 câu, chỉ nêu kết luận và điều luật chính.",
+		"detailed": "Trả lời đầy đủ, giải thích từng điều kiện và ngoại lệ liên quan.",
+	},
+	"tone": {
+		"formal": "Dùng văn phong trang trọng, chuẩn mực pháp lý.",
+		"plain":  "Dùng ngôn ngữ đời thường, dễ hiểu, giải thích thuật ngữ pháp lý khi cần.",
+	},
+	"audience": {
+		"lawyer":    "Người đọc là luật sư: trích dẫn chính xác điều, khoản, điểm và có thể dùng thuật ngữ chuyên môn.",
+		"layperson": "Người đọc không có chuyên môn pháp lý: tập trung vào quyền, nghĩa vụ và việc cần làm.",
+	},
+}
+
+// languageInstructions holds the prompt instruction for each answer language
+var languageInstructions = map[string]string{
+	"vi": "Trả lời bằng tiếng Việt.",
+	"en": "Trả lời bằng tiếng Anh (English); giữ nguyên tên văn bản và số hiệu điều luật theo tiếng Việt.",
+}
+
+func answerLanguages() []string {
+	languages := make([]string, 0, len(languageInstructions))
+	for language := range languageInstructions {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// validateAnswerStyle reports style values that have no prompt variant
+func validateAnswerStyle(style *engine.AnswerStyle) []Violation {
+	if style == nil {
+		return nil
+	}
+	var violations []Violation
+	for _, dim := range []struct{ name, value string }{
+		{"length", style.Length},
+		{"tone", style.Tone},
+		{"audience", style.Audience},
+	} {
+		if dim.value == "" {
+			continue
+		}
+		if _, ok := styleVariants[dim.name][dim.value]; !ok {
+			violations = append(violations, Violation{
+				Field:   "style." + dim.name,
+				Code:    ViolationOutOfRange,
+				Message: fmt.Sprintf("style.%s must be one of: %s", dim.name, strings.Join(styleValues(dim.name), ", ")),
+			})
+		}
+	}
+	return violations
+}
+
+func styleValues(dimension string) []string {
+	values := make([]string, 0, len(styleVariants[dimension]))
+	for value := range styleVariants[dimension] {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// resolveAnswerStyle fills unset dimensions from the built-in style
+func resolveAnswerStyle(style *engine.AnswerStyle) engine.AnswerStyle {
+	resolved := defaultAnswerStyle
+	if style == nil {
+		return resolved
+	}
+	if style.Length != "" {
+		resolved.Length = style.Length
+	}
+	if style.Tone != "" {
+		resolved.Tone = style.Tone
+	}
+	if style.Audience != "" {
+		resolved.Audience = style.Audience
+	}
+	return resolved
+}
+
+// styleInstructions returns the prompt instructions for a resolved style,
+// and for the answer language when one is requested
+func styleInstructions(s engine.AnswerStyle, language string) string {
+	instructions := []string{
+		styleVariants["length"][s.Length],
+		styleVariants["tone"][s.Tone],
+		styleVariants["audience"][s.Audience],
+	}
+	if language != "" {
+		instructions = append(instructions, languageInstructions[language])
+	}
+	return strings.Join(instructions, " ")
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Topic is a node of the legal topic taxonomy. Text mentioning any of the
+// keywords is tagged with the topic and its ancestors.
+type Topic struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	ParentID string   `json:"parent_id,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// TopicNode is a topic with its subtopics and the number of history
+// entries of the caller tagged with it
+type TopicNode struct {
+	Topic
+	Count    int         `json:"count"`
+	Children []TopicNode `json:"children,omitempty"`
+}
+
+// defaultTaxonomy covers the main areas of Vietnamese law, with the labor
+// code the corpus is built from broken down further
+var defaultTaxonomy = []Topic{
+	{ID: "lao-dong", Name: "Lao động", Keywords: []string{"lao động", "người sử dụng lao động"}},
+	{ID: "hop-dong-lao-dong", Name: "Hợp đồng lao động", ParentID: "lao-dong", Keywords: []string{"hợp đồng lao động", "thử việc", "chấm dứt hợp đồng", "đơn phương"}},
+	{ID: "tien-luong", Name: "Tiền lương", ParentID: "lao-dong", Keywords: []string{"tiền lương", "mức lương", "trả lương", "tiền thưởng"}},
+	{ID: "thoi-gio-lam-viec", Name: "Thời giờ làm việc, nghỉ ngơi", ParentID: "lao-dong", Keywords: []string{"thời giờ làm việc", "làm thêm giờ", "nghỉ phép", "nghỉ hằng năm", "nghỉ lễ"}},
+	{ID: "ky-luat-lao-dong", Name: "Kỷ luật lao động", ParentID: "lao-dong", Keywords: []string{"kỷ luật", "sa thải", "khiển trách"}},
+	{ID: "bao-hiem-xa-hoi", Name: "Bảo hiểm xã hội", ParentID: "lao-dong", Keywords: []string{"bảo hiểm xã hội", "bảo hiểm thất nghiệp", "thai sản"}},
+	{ID: "hon-nhan-gia-dinh", Name: "Hôn nhân và gia đình", Keywords: []string{"kết hôn", "ly hôn", "nuôi con", "cấp dưỡng"}},
+	{ID: "dan-su", Name: "Dân sự", Keywords: []string{"dân sự", "bồi thường"}},
+	{ID: "thua-ke", Name: "Thừa kế", ParentID: "dan-su", Keywords: []string{"thừa kế", "di chúc"}},
+	{ID: "dat-dai", Name: "Đất đai", Keywords: []string{"đất đai", "quyền sử dụng đất", "sổ đỏ"}},
+	{ID: "doanh-nghiep", Name: "Doanh nghiệp", Keywords: []string{"doanh nghiệp", "công ty", "cổ phần"}},
+	{ID: "hinh-su", Name: "Hình sự", Keywords: []string{"hình sự", "truy cứu", "tội phạm"}},
+}
+
+var (
+	errTopicNotFound    = errors.New("topic not found")
+	errParentNotFound   = errors.New("parent topic not found")
+	errTopicExists      = errors.New("topic already exists")
+	errTopicHasChildren = errors.New("topic has subtopics")
+	errTopicCycle       = errors.New("topic cannot be its own ancestor")
+
+	topicIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,62}$`)
+)
+
+// taxonomyFile is the persisted form of the taxonomies. A nil Default means
+// the built-in taxonomy.
+type taxonomyFile struct {
+	Default []Topic            `json:"default,omitempty"`
+	Tenants map[string][]Topic `json:"tenants,omitempty"`
+}
+
+// TaxonomyStore keeps the default taxonomy and the taxonomies customized by
+// tenants. A tenant without its own taxonomy uses the default one; its first
+// change copies the default.
+type TaxonomyStore struct {
+	mu      sync.RWMutex
+	path    string
+	custom  []Topic
+	tenants map[string][]Topic
+}
+
+func NewTaxonomyStore(path string) (*TaxonomyStore, error) {
+	store := &TaxonomyStore{
+		path:    path,
+		tenants: make(map[string][]Topic),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var file taxonomyFile
+	if _, err := readJSONFile(path, &file); err != nil {
+		return nil, fmt.Errorf("failed to load taxonomy: %w", err)
+	}
+	store.custom = file.Default
+	for tenantID, topics := range file.Tenants {
+		store.tenants[tenantID] = topics
+	}
+	return store, nil
+}
+
+// topicsLocked returns the taxonomy used by a tenant; "" is the default
+func (s *TaxonomyStore) topicsLocked(tenantID string) []Topic {
+	if topics, ok := s.tenants[tenantID]; ok {
+		return topics
+	}
+	if s.custom != nil {
+		return s.custom
+	}
+	return defaultTaxonomy
+}
+
+// Topics returns the taxonomy used by a tenant, in definition order
+func (s *TaxonomyStore) Topics(tenantID string) []Topic {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Topic(nil), s.topicsLocked(tenantID)...)
+}
+
+// Customized reports whether a tenant has its own taxonomy
+func (s *TaxonomyStore) Customized(tenantID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.tenants[tenantID]
+	return ok
+}
+
+// update applies fn to a copy of the tenant's taxonomy and persists it
+func (s *TaxonomyStore) update(tenantID string, fn func([]Topic) ([]Topic, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics, err := fn(append([]Topic(nil), s.topicsLocked(tenantID)...))
+	if err != nil {
+		return err
+	}
+	if tenantID == "" {
+		s.custom = topics
+	} else {
+		s.tenants[tenantID] = topics
+	}
+	return s.saveLocked()
+}
+
+func (s *TaxonomyStore) Create(tenantID string, topic Topic) error {
+	return s.update(tenantID, func(topics []Topic) ([]Topic, error) {
+		if findTopic(topics, topic.ID) >= 0 {
+			return nil, errTopicExists
+		}
+		if topic.ParentID != "" && findTopic(topics, topic.ParentID) < 0 {
+			return nil, fmt.Errorf("%w: %q", errParentNotFound, topic.ParentID)
+		}
+		return append(topics, topic), nil
+	})
+}
+
+// Update replaces a topic; its ID cannot change
+func (s *TaxonomyStore) Update(tenantID string, topic Topic) error {
+	return s.update(tenantID, func(topics []Topic) ([]Topic, error) {
+		i := findTopic(topics, topic.ID)
+		if i < 0 {
+			return nil, errTopicNotFound
+		}
+		for parent := topic.ParentID; parent != ""; {
+			if parent == topic.ID {
+				return nil, errTopicCycle
+			}
+			p := findTopic(topics, parent)
+			if p < 0 {
+				return nil, fmt.Errorf("%w: %q", errParentNotFound, parent)
+			}
+			parent = topics[p].ParentID
+		}
+		topics[i] = topic
+		return topics, nil
+	})
+}
+
+// Delete removes a topic without subtopics
+func (s *TaxonomyStore) Delete(tenantID, id string) error {
+	return s.update(tenantID, func(topics []Topic) ([]Topic, error) {
+		i := findTopic(topics, id)
+		if i < 0 {
+			return nil, errTopicNotFound
+		}
+		for _, t := range topics {
+			if t.ParentID == id {
+				return nil, errTopicHasChildren
+			}
+		}
+		return append(topics[:i], topics[i+1:]...), nil
+	})
+}
+
+// Reset drops a tenant's own taxonomy, or restores the built-in default
+func (s *TaxonomyStore) Reset(tenantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tenantID == "" {
+		s.custom = nil
+	} else {
+		delete(s.tenants, tenantID)
+	}
+	return s.saveLocked()
+}
+
+// Tag returns the IDs of the topics whose keywords text mentions, each
+// preceded by its ancestors, in taxonomy order
+func (s *TaxonomyStore) Tag(tenantID, text string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	topics := s.topicsLocked(tenantID)
+	lower := strings.ToLower(text)
+
+	tagged := make(map[string]bool)
+	for _, topic := range topics {
+		if !mentionsAny(lower, topic.Keywords) {
+			continue
+		}
+		for id := topic.ID; id != "" && !tagged[id]; {
+			tagged[id] = true
+			p := findTopic(topics, id)
+			if p < 0 {
+				break
+			}
+			id = topics[p].ParentID
+		}
+	}
+
+	var ids []string
+	for _, topic := range topics {
+		if tagged[topic.ID] {
+			ids = append(ids, topic.ID)
+		}
+	}
+	return ids
+}
+
+func (s *TaxonomyStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, taxonomyFile{Default: s.custom, Tenants: s.tenants})
+}
+
+func findTopic(topics []Topic, id string) int {
+	for i, t := range topics {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func mentionsAny(lower string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicTree nests topics under their parents, counting the entries tagged
+// with each topic
+func topicTree(topics []Topic, counts map[string]int) []TopicNode {
+	children := make(map[string][]Topic)
+	for _, t := range topics {
+		children[t.ParentID] = append(children[t.ParentID], t)
+	}
+	var build func(parentID string) []TopicNode
+	build = func(parentID string) []TopicNode {
+		var nodes []TopicNode
+		for _, t := range children[parentID] {
+			nodes = append(nodes, TopicNode{Topic: t, Count: counts[t.ID], Children: build(t.ID)})
+		}
+		return nodes
+	}
+	return build("")
+}
+
+// validateTopic normalizes a topic from a request body
+func validateTopic(topic *Topic) error {
+	topic.Name = strings.TrimSpace(topic.Name)
+	if !topicIDPattern.MatchString(topic.ID) {
+		return errors.New("id must be 2-63 lowercase letters, digits, '-' or '_'")
+	}
+	if topic.Name == "" {
+		return errors.New("name must not be empty")
+	}
+	keywords := topic.Keywords[:0]
+	for _, keyword := range topic.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	topic.Keywords = keywords
+	return nil
+}
+
+// Handlers
+
+// TagRequest is the body of POST /api/taxonomy/tag
+type TagRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+func getTaxonomyHandler(store *TaxonomyStore, history *HistoryStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{
+			"customized": tenant.ID != "" && store.Customized(tenant.ID),
+			"topics":     topicTree(store.Topics(tenant.ID), history.TopicCounts(tenant.ID)),
+		})
+	}
+}
+
+func tagTextHandler(store *TaxonomyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		tenant, _ := callerTenant(c)
+		topics := store.Tag(tenant.ID, req.Text)
+		if topics == nil {
+			topics = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"topics": topics})
+	}
+}
+
+// adminTaxonomyTenant returns the tenant named by the tenant query
+// parameter, "" for the default taxonomy
+func adminTaxonomyTenant(c *gin.Context, tenants *TenantStore) (string, bool) {
+	id := c.Query("tenant")
+	if id == "" {
+		return "", true
+	}
+	if _, ok := tenants.Get(id); !ok {
+		abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", id))
+		return "", false
+	}
+	return id, true
+}
+
+func listTopicsHandler(store *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"customized": tenantID != "" && store.Customized(tenantID),
+			"topics":     store.Topics(tenantID),
+		})
+	}
+}
+
+func createTopicHandler(store *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		var topic Topic
+		if err := c.ShouldBindJSON(&topic); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if err := validateTopic(&topic); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err := store.Create(tenantID, topic); err != nil {
+			abortWithTaxonomyError(c, topic.ID, err)
+			return
+		}
+		c.JSON(http.StatusCreated, topic)
+	}
+}
+
+func updateTopicHandler(store *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		var topic Topic
+		if err := c.ShouldBindJSON(&topic); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		topic.ID = c.Param("id")
+		if err := validateTopic(&topic); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err := store.Update(tenantID, topic); err != nil {
+			abortWithTaxonomyError(c, topic.ID, err)
+			return
+		}
+		c.JSON(http.StatusOK, topic)
+	}
+}
+
+func deleteTopicHandler(store *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		if err := store.Delete(tenantID, c.Param("id")); err != nil {
+			abortWithTaxonomyError(c, c.Param("id"), err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func resetTaxonomyHandler(store *TaxonomyStore, tenants *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := adminTaxonomyTenant(c, tenants)
+		if !ok {
+			return
+		}
+		if err := store.Reset(tenantID); err != nil {
+			abortWithTaxonomyError(c, "", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// abortWithTaxonomyError maps a TaxonomyStore error onto the error catalog
+func abortWithTaxonomyError(c *gin.Context, id string, err error) {
+	switch {
+	case errors.Is(err, errTopicNotFound):
+		abortWithError(c, ErrCodeTopicNotFound, fmt.Sprintf("Topic %q not found", id))
+	case errors.Is(err, errParentNotFound):
+		abortWithError(c, ErrCodeTopicNotFound, fmt.Sprintf("Cannot save topic %q: %v", id, err))
+	case errors.Is(err, errTopicExists):
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Topic %q already exists", id))
+	case errors.Is(err, errTopicHasChildren):
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Topic %q has subtopics; delete or move them first", id))
+	case errors.Is(err, errTopicCycle):
+		abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Topic %q cannot be nested under itself", id))
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to save taxonomy", "error", err)
+		abortWithError(c, ErrCodeInternal, "Failed to save taxonomy")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestTaxonomyTag(t *testing.T) {
+	store, err := NewTaxonomyStore("")
+	if err != nil {
+		t.Fatalf("NewTaxonomyStore: %v", err)
+	}
+
+	got := store.Tag("", "Thời gian THỬ VIỆC và mức lương trong thời gian thử việc?")
+	want := []string{"lao-dong", "hop-dong-lao-dong", "tien-luong"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tag = %v, want %v", got, want)
+	}
+	if got := store.Tag("", "Thời tiết hôm nay thế nào?"); len(got) != 0 {
+		t.Errorf("Tag of an unrelated text = %v, want none", got)
+	}
+}
+
+func TestTaxonomyTenantCustomization(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taxonomy.json")
+	store, err := NewTaxonomyStore(path)
+	if err != nil {
+		t.Fatalf("NewTaxonomyStore: %v", err)
+	}
+
+	topic := Topic{ID: "an-toan-lao-dong", Name: "An toàn lao động", ParentID: "lao-dong", Keywords: []string{"tai nạn lao động"}}
+	if err := store.Create("acme", topic); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := store.Create("acme", topic); !errors.Is(err, errTopicExists) {
+		t.Errorf("duplicate Create error = %v, want errTopicExists", err)
+	}
+	if err := store.Delete("acme", "lao-dong"); !errors.Is(err, errTopicHasChildren) {
+		t.Errorf("Delete of a parent error = %v, want errTopicHasChildren", err)
+	}
+	if err := store.Update("acme", Topic{ID: "lao-dong", Name: "Lao động", ParentID: "an-toan-lao-dong"}); !errors.Is(err, errTopicCycle) {
+		t.Errorf("cyclic Update error = %v, want errTopicCycle", err)
+	}
+
+	// The change is private to the tenant and survives a reload
+	reloaded, err := NewTaxonomyStore(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := reloaded.Tag("acme", "Bồi thường tai nạn lao động"); !slices.Contains(got, "an-toan-lao-dong") {
+		t.Errorf("tenant Tag = %v, want the custom topic", got)
+	}
+	if got := reloaded.Tag("", "Bồi thường tai nạn lao động"); slices.Contains(got, "an-toan-lao-dong") {
+		t.Errorf("default Tag = %v, want no tenant topic", got)
+	}
+
+	if err := reloaded.Reset("acme"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if reloaded.Customized("acme") {
+		t.Error("tenant still customized after Reset")
+	}
+}
+
+func TestHistoryTopicFilter(t *testing.T) {
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Theo Bộ luật Lao động 2019...", Iterations: 1}}
+	srv := newTestServer(t, Options{Engine: stub})
+
+	questions := []string{
+		"Theo Bộ luật Lao động 2019, thời gian thử việc tối đa đối với công việc có chức danh nghề nghiệp cần trình độ cao đẳng là bao nhiêu ngày?",
+		"Theo Bộ luật Lao động 2019, người lao động làm thêm giờ vào ngày nghỉ lễ được trả lương ít nhất bằng bao nhiêu phần trăm?",
+	}
+	for _, q := range questions {
+		if rec := doJSON(t, srv.Handler(), http.MethodPost, "/api/legal-query", LegalQueryRequest{Question: q}); rec.Code != http.StatusOK {
+			t.Fatalf("query status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := doJSON(t, srv.Handler(), http.MethodGet, "/api/history?topic=hop-dong-lao-dong", nil)
+	var history struct {
+		Entries []HistoryEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatalf("decode history: %v", err)
+	}
+	if len(history.Entries) != 1 || history.Entries[0].Question != questions[0] {
+		t.Errorf("filtered history = %+v, want only the probation question", history.Entries)
+	}
+
+	rec = doJSON(t, srv.Handler(), http.MethodGet, "/api/taxonomy", nil)
+	var taxonomy struct {
+		Topics []TopicNode `json:"topics"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &taxonomy); err != nil {
+		t.Fatalf("decode taxonomy: %v", err)
+	}
+	if len(taxonomy.Topics) == 0 || taxonomy.Topics[0].ID != "lao-dong" || taxonomy.Topics[0].Count != 2 {
+		t.Errorf("taxonomy root = %+v, want lao-dong counting both questions", taxonomy.Topics)
+	}
+}
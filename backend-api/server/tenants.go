@@ -0,0 +1,366 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant is an organisation using the API
+type Tenant struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Plan      string         `json:"plan"`
+	Settings  TenantSettings `json:"settings"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// TenantSettings holds tenant-level configuration
+type TenantSettings struct {
+	Defaults QueryDefaults `json:"defaults"`
+
+	// SeniorLawyers are the user IDs allowed to approve answers for client
+	// shares; empty falls back to SENIOR_LAWYERS
+	SeniorLawyers []string `json:"senior_lawyers,omitempty"`
+
+	// ComplianceReviewers are the user IDs allowed to approve the tenant's
+	// disclaimer; empty falls back to COMPLIANCE_REVIEWERS
+	ComplianceReviewers []string `json:"compliance_reviewers,omitempty"`
+
+	// Roles maps role names to the user IDs holding them, for the access
+	// rules of restricted collections; unset falls back to the roles of
+	// COLLECTIONS_FILE
+	Roles map[string][]string `json:"roles,omitempty"`
+
+	// Presets are the tenant's query presets; one named like a built-in
+	// preset replaces it
+	Presets []QueryPreset `json:"presets,omitempty"`
+
+	// PublicPages opts the tenant into publishing approved answers as
+	// public FAQ pages
+	PublicPages PublicPagesSettings `json:"public_pages,omitzero"`
+}
+
+// QueryDefaults are applied to a query when the client omits the parameter.
+// Unset fields fall back to the built-in defaults.
+type QueryDefaults struct {
+	MaxIterations   *int   `json:"max_iterations,omitempty"`
+	TopK            *int   `json:"top_k,omitempty"`
+	EnableWebSearch *bool  `json:"enable_web_search,omitempty"`
+	Model           string `json:"model,omitempty"`
+	ResponseFormat  string `json:"response_format,omitempty"`
+}
+
+// withFallback fills the fields d leaves unset from fallback
+func (d QueryDefaults) withFallback(fallback QueryDefaults) QueryDefaults {
+	if d.MaxIterations == nil {
+		d.MaxIterations = fallback.MaxIterations
+	}
+	if d.TopK == nil {
+		d.TopK = fallback.TopK
+	}
+	if d.EnableWebSearch == nil {
+		d.EnableWebSearch = fallback.EnableWebSearch
+	}
+	if d.Model == "" {
+		d.Model = fallback.Model
+	}
+	if d.ResponseFormat == "" {
+		d.ResponseFormat = fallback.ResponseFormat
+	}
+	return d
+}
+
+var (
+	errTenantNotFound = errors.New("tenant not found")
+	errTenantExists   = errors.New("tenant already exists")
+
+	tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,62}$`)
+)
+
+// TenantStore keeps tenants in memory, persisted to a JSON file when a path
+// is configured
+type TenantStore struct {
+	mu      sync.RWMutex
+	path    string
+	tenants map[string]*Tenant
+}
+
+func NewTenantStore(path string) (*TenantStore, error) {
+	store := &TenantStore{
+		path:    path,
+		tenants: make(map[string]*Tenant),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	var tenants []*Tenant
+	if _, err := readJSONFile(path, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %w", err)
+	}
+	for _, t := range tenants {
+		store.tenants[t.ID] = t
+	}
+	return store, nil
+}
+
+func (s *TenantStore) Get(id string) (Tenant, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tenants[id]
+	if !ok {
+		return Tenant{}, false
+	}
+	return *t, true
+}
+
+func (s *TenantStore) List() []Tenant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenants := make([]Tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, *t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
+
+func (s *TenantStore) Create(t Tenant) (Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tenants[t.ID]; ok {
+		return Tenant{}, errTenantExists
+	}
+	now := time.Now().UTC()
+	t.CreatedAt, t.UpdatedAt = now, now
+	s.tenants[t.ID] = &t
+	return t, s.saveLocked()
+}
+
+// Update applies fn to the stored tenant and persists the result
+func (s *TenantStore) Update(id string, fn func(*Tenant) error) (Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.tenants[id]
+	if !ok {
+		return Tenant{}, errTenantNotFound
+	}
+	updated := *current
+	if err := fn(&updated); err != nil {
+		return Tenant{}, err
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	s.tenants[id] = &updated
+	return updated, s.saveLocked()
+}
+
+func (s *TenantStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tenants[id]; !ok {
+		return errTenantNotFound
+	}
+	delete(s.tenants, id)
+	return s.saveLocked()
+}
+
+func (s *TenantStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	tenants := make([]*Tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return writeJSONFile(s.path, tenants)
+}
+
+// validateQueryDefaults checks tenant defaults against the tenant's plan,
+// using the same rules as client-supplied parameters. Unlike client values,
+// out-of-range defaults are rejected rather than clamped.
+func validateQueryDefaults(d QueryDefaults, plan Plan) []Violation {
+	req := LegalQueryRequest{
+		Question:        "defaults",
+		MaxIterations:   d.MaxIterations,
+		TopK:            d.TopK,
+		EnableWebSearch: d.EnableWebSearch,
+		Model:           d.Model,
+		ResponseFormat:  d.ResponseFormat,
+	}
+	violations := validateQueryRequest(&req, plan)
+	for _, w := range clampQueryRequest(&req, plan) {
+		violations = append(violations, Violation{
+			Field:   w.Field,
+			Code:    ViolationOutOfRange,
+			Message: w.Message,
+		})
+	}
+	return violations
+}
+
+// validateTenantSettings checks a tenant's defaults and presets against its
+// plan
+func validateTenantSettings(s TenantSettings, plan Plan) []Violation {
+	return append(validateQueryDefaults(s.Defaults, plan), validatePresets(s.Presets, plan)...)
+}
+
+const tenantContextKey = "tenant"
+
+// tenantMiddleware resolves the caller's tenant from the X-Tenant-ID header.
+// Requests without the header are served without tenant settings.
+func tenantMiddleware(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Tenant-ID")
+		if id == "" {
+			c.Next()
+			return
+		}
+
+		tenant, ok := store.Get(id)
+		if !ok {
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", id))
+			return
+		}
+		c.Set(tenantContextKey, tenant)
+		c.Next()
+	}
+}
+
+// callerTenant returns the tenant attached by tenantMiddleware, if any
+func callerTenant(c *gin.Context) (Tenant, bool) {
+	if t, ok := c.Get(tenantContextKey); ok {
+		return t.(Tenant), true
+	}
+	return Tenant{}, false
+}
+
+// Handlers
+
+// CreateTenantRequest is the body of POST /admin/tenants
+type CreateTenantRequest struct {
+	ID       string         `json:"id" binding:"required"`
+	Name     string         `json:"name" binding:"required"`
+	Plan     string         `json:"plan"`
+	Settings TenantSettings `json:"settings"`
+}
+
+func listTenantsHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"tenants": store.List()})
+	}
+}
+
+func getTenantHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := store.Get(c.Param("id"))
+		if !ok {
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", c.Param("id")))
+			return
+		}
+		c.JSON(http.StatusOK, tenant)
+	}
+}
+
+func createTenantHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateTenantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if !tenantIDPattern.MatchString(req.ID) {
+			abortWithError(c, ErrCodeInvalidRequest, "id must be 2-63 lowercase letters, digits, '-' or '_'")
+			return
+		}
+		if req.Plan == "" {
+			req.Plan = defaultPlanName
+		}
+		plan, ok := lookupPlan(req.Plan)
+		if !ok {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Unknown plan %q (available: %v)", req.Plan, planNames()))
+			return
+		}
+		if violations := validateTenantSettings(req.Settings, plan); len(violations) > 0 {
+			abortWithViolations(c, violations)
+			return
+		}
+
+		tenant, err := store.Create(Tenant{
+			ID:       req.ID,
+			Name:     req.Name,
+			Plan:     req.Plan,
+			Settings: req.Settings,
+		})
+		if errors.Is(err, errTenantExists) {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Tenant %q already exists", req.ID))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save tenant", "tenant", req.ID, "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save tenant")
+			return
+		}
+
+		c.JSON(http.StatusCreated, tenant)
+	}
+}
+
+func updateTenantSettingsHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var settings TenantSettings
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+
+		var violations []Violation
+		tenant, err := store.Update(c.Param("id"), func(t *Tenant) error {
+			plan, _ := lookupPlan(t.Plan)
+			if violations = validateTenantSettings(settings, plan); len(violations) > 0 {
+				return errors.New("invalid settings")
+			}
+			t.Settings = settings
+			return nil
+		})
+		switch {
+		case errors.Is(err, errTenantNotFound):
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", c.Param("id")))
+			return
+		case len(violations) > 0:
+			abortWithViolations(c, violations)
+			return
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "Failed to save tenant", "tenant", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save tenant")
+			return
+		}
+
+		c.JSON(http.StatusOK, tenant)
+	}
+}
+
+func deleteTenantHandler(store *TenantStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.Delete(c.Param("id"))
+		if errors.Is(err, errTenantNotFound) {
+			abortWithError(c, ErrCodeTenantNotFound, fmt.Sprintf("Unknown tenant %q", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete tenant", "tenant", c.Param("id"), "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to delete tenant")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// Violation describes a single problem found in a query payload
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warning codes
+const (
+	// WarningClamped is reported when a parameter was clamped into range
+	WarningClamped = "CLAMPED"
+
+	// WarningContextDropped is reported when context documents of a stored
+	// query could not be reused
+	WarningContextDropped = "CONTEXT_DROPPED"
+
+	// WarningPostProcessorFailed is reported when a post-processor failed and
+	// the answer is returned without it
+	WarningPostProcessorFailed = "POST_PROCESSOR_FAILED"
+
+	// WarningCitationRemoved is reported when a memo cited a source that
+	// does not exist and the citation was removed
+	WarningCitationRemoved = "CITATION_REMOVED"
+
+	// WarningCitationUnsupported is reported when a memo sentence cites a
+	// source that shares little wording with it
+	WarningCitationUnsupported = "CITATION_UNSUPPORTED"
+
+	// WarningPresetAdjusted is reported when a preset parameter is not
+	// available on the caller's plan and was left out
+	WarningPresetAdjusted = "PRESET_ADJUSTED"
+)
+
+// Field-level violation codes
+const (
+	ViolationRequired      = "REQUIRED"
+	ViolationInvalidType   = "INVALID_TYPE"
+	ViolationUnknownField  = "UNKNOWN_FIELD"
+	ViolationOutOfRange    = "OUT_OF_RANGE"
+	ViolationTooLong       = "TOO_LONG"
+	ViolationNotInPlan     = "FEATURE_NOT_IN_PLAN"
+	ViolationMalformedJSON = "MALFORMED_JSON"
+)
+
+// ValidateResponse is returned by the payload linting endpoint
+type ValidateResponse struct {
+	Valid      bool             `json:"valid"`
+	Plan       Plan             `json:"plan"`
+	Violations []Violation      `json:"violations"`
+	Warnings   []engine.Warning `json:"warnings"`
+}
+
+var legalQueryFields = map[string]bool{
+	"question":          true,
+	"max_iterations":    true,
+	"top_k":             true,
+	"enable_web_search": true,
+	"model":             true,
+	"response_format":   true,
+	"citation_style":    true,
+	"attachments":       true,
+	"context_urls":      true,
+	"pending_query_id":  true,
+	"clarification":     true,
+	"style":             true,
+	"iteration_policy":  true,
+	"latency_budget_ms": true,
+	"language":          true,
+	"collection":        true,
+	"conversation_id":   true,
+	"explain":           true,
+	"preset":            true,
+}
+
+// Response formats understood by the engine
+var responseFormats = map[string]bool{
+	"text":     true,
+	"markdown": true,
+}
+
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/-]{0,99}$`)
+
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// validateQueryRequest checks a decoded request against the validation rules
+// and the features of the caller's plan, returning every violation found
+func validateQueryRequest(req *LegalQueryRequest, plan Plan) []Violation {
+	var violations []Violation
+
+	switch {
+	case req.PendingQueryID != "":
+		// A follow-up takes its question from the pending query
+		if strings.TrimSpace(req.Clarification) == "" {
+			violations = append(violations, Violation{
+				Field:   "clarification",
+				Code:    ViolationRequired,
+				Message: "clarification must not be empty when pending_query_id is set",
+			})
+		}
+	case strings.TrimSpace(req.Question) == "":
+		violations = append(violations, Violation{
+			Field:   "question",
+			Code:    ViolationRequired,
+			Message: "question must not be empty",
+		})
+	}
+	for _, text := range []struct{ field, value string }{{"question", req.Question}, {"clarification", req.Clarification}} {
+		if plan.MaxQuestionLength > 0 && utf8.RuneCountInString(strings.TrimSpace(text.value)) > plan.MaxQuestionLength {
+			violations = append(violations, Violation{
+				Field:   text.field,
+				Code:    ViolationTooLong,
+				Message: fmt.Sprintf("%s must be at most %d characters", text.field, plan.MaxQuestionLength),
+			})
+		}
+	}
+
+	if req.EnableWebSearch != nil && *req.EnableWebSearch && !plan.WebSearch {
+		violations = append(violations, Violation{
+			Field:   "enable_web_search",
+			Code:    ViolationNotInPlan,
+			Message: fmt.Sprintf("web search is not available on plan %q", plan.Name),
+		})
+	}
+
+	if req.Model != "" && !modelNamePattern.MatchString(req.Model) {
+		violations = append(violations, Violation{
+			Field:   "model",
+			Code:    ViolationInvalidType,
+			Message: "model must be a model name of at most 100 characters",
+		})
+	}
+
+	if req.ResponseFormat != "" && !responseFormats[req.ResponseFormat] {
+		violations = append(violations, Violation{
+			Field:   "response_format",
+			Code:    ViolationOutOfRange,
+			Message: "response_format must be one of: markdown, text",
+		})
+	}
+
+	if req.CitationStyle != "" && !validCitationStyle(req.CitationStyle) {
+		violations = append(violations, Violation{
+			Field:   "citation_style",
+			Code:    ViolationOutOfRange,
+			Message: fmt.Sprintf("citation_style must be one of: %s", strings.Join(citationStyles, ", ")),
+		})
+	}
+
+	if req.Language != "" && languageInstructions[req.Language] == "" {
+		violations = append(violations, Violation{
+			Field:   "language",
+			Code:    ViolationOutOfRange,
+			Message: fmt.Sprintf("language must be one of: %s", strings.Join(answerLanguages(), ", ")),
+		})
+	}
+
+	if req.Collection != "" && !collectionNamePattern.MatchString(req.Collection) {
+		violations = append(violations, Violation{
+			Field:   "collection",
+			Code:    ViolationInvalidType,
+			Message: "collection must be a collection name of at most 64 letters, digits, '_' or '-'",
+		})
+	}
+
+	violations = append(violations, validateAnswerStyle(req.Style)...)
+	violations = append(violations, validateIterationPolicy(req.IterationPolicy)...)
+
+	if req.Explain != nil {
+		weights := []struct {
+			field string
+			value *float64
+		}{
+			{"explain.keyword_weight", req.Explain.KeywordWeight},
+			{"explain.recency_weight", req.Explain.RecencyWeight},
+		}
+		for _, w := range weights {
+			if w.value != nil && (*w.value < 0 || *w.value > 1) {
+				violations = append(violations, Violation{
+					Field:   w.field,
+					Code:    ViolationOutOfRange,
+					Message: w.field + " must be between 0 and 1",
+				})
+			}
+		}
+	}
+
+	if req.LatencyBudgetMs != nil && (*req.LatencyBudgetMs < 100 || *req.LatencyBudgetMs > 600000) {
+		violations = append(violations, Violation{
+			Field:   "latency_budget_ms",
+			Code:    ViolationOutOfRange,
+			Message: "latency_budget_ms must be between 100 and 600000",
+		})
+	}
+
+	return violations
+}
+
+// clampQueryRequest clamps max_iterations and top_k into the range allowed by
+// the caller's plan (already capped by the server-wide limits) and returns a
+// warning for every adjusted value
+func clampQueryRequest(req *LegalQueryRequest, plan Plan) []engine.Warning {
+	var warnings []engine.Warning
+	if w, ok := clampParam("max_iterations", req.MaxIterations, plan.MaxIterations, plan.Name); ok {
+		warnings = append(warnings, w)
+	}
+	if w, ok := clampParam("top_k", req.TopK, plan.MaxTopK, plan.Name); ok {
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+func clampParam(field string, value *int, limit int, planName string) (engine.Warning, bool) {
+	if value == nil || (*value >= 1 && *value <= limit) {
+		return engine.Warning{}, false
+	}
+
+	requested := *value
+	*value = max(1, min(requested, limit))
+	return engine.Warning{
+		Field:   field,
+		Code:    WarningClamped,
+		Message: fmt.Sprintf("%s=%d is outside the allowed range 1-%d on plan %q and was set to %d", field, requested, limit, planName, *value),
+	}, true
+}
+
+// lintQueryPayload decodes a raw payload leniently so that unknown fields and
+// type mismatches are reported alongside the regular validation rules
+func lintQueryPayload(body []byte, plan Plan, limits AttachmentLimits, urlLimits ContextURLLimits) ([]Violation, []engine.Warning) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return []Violation{{
+			Code:    ViolationMalformedJSON,
+			Message: fmt.Sprintf("payload is not a JSON object: %v", err),
+		}}, nil
+	}
+
+	var violations []Violation
+	var req LegalQueryRequest
+	badType := make(map[string]bool)
+	for field, value := range raw {
+		if !legalQueryFields[field] {
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationUnknownField,
+				Message: fmt.Sprintf("unknown field %q", field),
+			})
+			continue
+		}
+
+		// Decode field by field so one bad type doesn't hide the others
+		single, _ := json.Marshal(map[string]json.RawMessage{field: value})
+		if err := json.Unmarshal(single, &req); err != nil {
+			violations = append(violations, Violation{
+				Field:   field,
+				Code:    ViolationInvalidType,
+				Message: describeTypeError(field, err),
+			})
+			badType[field] = true
+		}
+	}
+
+	for _, v := range validateQueryRequest(&req, plan) {
+		if !badType[v.Field] {
+			violations = append(violations, v)
+		}
+	}
+	if !badType["attachments"] {
+		violations = append(violations, validateAttachments(req.Attachments, limits)...)
+	}
+	if !badType["context_urls"] {
+		violations = append(violations, validateContextURLs(req.ContextURLs, urlLimits.MaxCount)...)
+	}
+	sortViolations(violations)
+
+	var warnings []engine.Warning
+	for _, w := range clampQueryRequest(&req, plan) {
+		if !badType[w.Field] {
+			warnings = append(warnings, w)
+		}
+	}
+	return violations, warnings
+}
+
+func describeTypeError(field string, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Sprintf("%s must be of type %s, got %s", field, typeErr.Type, typeErr.Value)
+	}
+	return fmt.Sprintf("%s is invalid: %v", field, err)
+}
+
+// sortViolations orders violations by field so output is deterministic
+// despite map iteration order
+func sortViolations(violations []Violation) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Field < violations[j].Field
+	})
+}
+
+func formatViolations(violations []Violation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		parts[i] = v.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Handlers
+
+func validateHandler(limits AttachmentLimits, urlLimits ContextURLLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+
+		plan := callerPlan(c)
+		violations, warnings := lintQueryPayload(bytes.TrimSpace(body), plan, limits, urlLimits)
+		if violations == nil {
+			violations = []Violation{}
+		}
+		if warnings == nil {
+			warnings = []engine.Warning{}
+		}
+
+		c.JSON(http.StatusOK, ValidateResponse{
+			Valid:      len(violations) == 0,
+			Plan:       plan,
+			Violations: violations,
+			Warnings:   warnings,
+		})
+	}
+}
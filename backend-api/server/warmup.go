@@ -0,0 +1,288 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// WarmupConfig controls engine warm-up. Engines load their models lazily, so
+// the first query after a start or a long idle period can take minutes; the
+// warm-up sends that query instead of the first user.
+type WarmupConfig struct {
+	Enabled   bool
+	Queries   []string
+	IdleAfter time.Duration
+	Tolerance float64
+	MaxRounds int
+}
+
+// defaultWarmupQueries are short lookups that load the embedding, search and
+// generation models without web search
+const defaultWarmupQueries = "Thời gian thử việc tối đa là bao nhiêu ngày?,Điều 25 Bộ luật Lao động 2019 quy định gì?"
+
+// Engine warm-up states
+const (
+	WarmupCold    = "cold"
+	WarmupWarming = "warming"
+	WarmupReady   = "ready"
+	WarmupFailed  = "failed"
+)
+
+// warmupCheckInterval is how often idle and failed engines are looked for
+const warmupCheckInterval = time.Minute
+
+// warmupTarget is an engine the warmer keeps warm
+type warmupTarget struct {
+	name   string
+	engine engine.QueryEngine
+
+	state     string
+	latencies []time.Duration
+	lastErr   error
+	warmedAt  time.Time
+}
+
+// EngineWarmer sends warm-up queries to every registered engine in rounds
+// and marks an engine ready once the latency of two consecutive rounds is
+// within Tolerance of each other. An engine that never stabilizes is marked
+// ready after MaxRounds; one that fails every round is retried later.
+type EngineWarmer struct {
+	config WarmupConfig
+
+	// lastQuery returns when the server last sent a user query to the
+	// engines
+	lastQuery func() time.Time
+
+	mu      sync.Mutex
+	targets []*warmupTarget
+}
+
+func NewEngineWarmer(config WarmupConfig, lastQuery func() time.Time) *EngineWarmer {
+	return &EngineWarmer{config: config, lastQuery: lastQuery}
+}
+
+// Register adds an engine to warm. It starts cold: the server is not ready
+// until the next pass warms it.
+func (w *EngineWarmer) Register(name string, e engine.QueryEngine) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.targets = append(w.targets, &warmupTarget{name: name, engine: e, state: WarmupCold})
+}
+
+// Warm warms every registered engine that is not warming already, in
+// parallel, and returns once they are done
+func (w *EngineWarmer) Warm() {
+	w.warm(func(*warmupTarget) bool { return true })
+}
+
+func (w *EngineWarmer) warm(include func(t *warmupTarget) bool) {
+	w.mu.Lock()
+	var targets []*warmupTarget
+	for _, t := range w.targets {
+		if t.state != WarmupWarming && include(t) {
+			t.state = WarmupWarming
+			t.latencies = nil
+			t.lastErr = nil
+			targets = append(targets, t)
+		}
+	}
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.warmTarget(t)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *EngineWarmer) warmTarget(t *warmupTarget) {
+	slog.Info("Warming up engine", "engine", t.name)
+	var previous time.Duration
+	for round := 1; round <= w.config.MaxRounds; round++ {
+		took, err := w.round(t.engine)
+		w.mu.Lock()
+		if err != nil {
+			t.lastErr = err
+			w.mu.Unlock()
+			slog.Warn("Engine warm-up round failed", "engine", t.name, "round", round, "error", err)
+			previous = 0
+			continue
+		}
+		t.latencies = append(t.latencies, took)
+		w.mu.Unlock()
+
+		if previous > 0 && w.stable(previous, took) {
+			w.finish(t, WarmupReady)
+			slog.Info("Engine is warm", "engine", t.name, "rounds", round, "per_round", took.Round(time.Millisecond).String())
+			return
+		}
+		previous = took
+	}
+
+	w.mu.Lock()
+	succeeded := len(t.latencies) > 0
+	w.mu.Unlock()
+	if !succeeded {
+		w.finish(t, WarmupFailed)
+		slog.Warn("Engine could not be warmed up, retrying", "engine", t.name, "retry_in", warmupCheckInterval.String())
+		return
+	}
+	w.finish(t, WarmupReady)
+	slog.Warn("Engine latency did not stabilize within the warm-up rounds, marking it ready anyway", "engine", t.name, "rounds", w.config.MaxRounds)
+}
+
+// round sends every warm-up query, one at a time, and returns their total
+// latency
+func (w *EngineWarmer) round(e engine.QueryEngine) (time.Duration, error) {
+	start := time.Now()
+	for _, question := range w.config.Queries {
+		_, err := e.Query(&engine.PythonQueryRequest{
+			Question:      question,
+			MaxIterations: 1,
+			TopK:          1,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+func (w *EngineWarmer) stable(previous, latest time.Duration) bool {
+	diff := latest - previous
+	if diff < 0 {
+		diff = -diff
+	}
+	return float64(diff) <= w.config.Tolerance*float64(previous)
+}
+
+func (w *EngineWarmer) finish(t *warmupTarget, state string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t.state = state
+	t.warmedAt = time.Now()
+}
+
+// Ready returns nil once every registered engine is warm, or an error naming
+// the first engine that is not
+func (w *EngineWarmer) Ready() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range w.targets {
+		if t.state != WarmupReady {
+			return fmt.Errorf("engine %s is %s", t.name, t.state)
+		}
+	}
+	return nil
+}
+
+// Schedule re-warms engines whose warm-up failed, and every engine once no
+// query or warm-up reached them for IdleAfter, until stop is closed
+func (w *EngineWarmer) Schedule(stop <-chan struct{}) {
+	ticker := time.NewTicker(warmupCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lastQuery := w.lastQuery()
+			w.warm(func(t *warmupTarget) bool {
+				if t.state == WarmupFailed {
+					return true
+				}
+				return w.config.IdleAfter > 0 && t.state == WarmupReady &&
+					time.Since(t.warmedAt) >= w.config.IdleAfter && time.Since(lastQuery) >= w.config.IdleAfter
+			})
+		case <-stop:
+			return
+		}
+	}
+}
+
+// WarmupTargetStatus is the warm-up state of one engine
+type WarmupTargetStatus struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	RoundsMs    []int64    `json:"rounds_ms"`
+	LastError   string     `json:"last_error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// WarmupStatus is returned by the warm-up endpoints
+type WarmupStatus struct {
+	Ready   bool                 `json:"ready"`
+	Engines []WarmupTargetStatus `json:"engines"`
+}
+
+func (w *EngineWarmer) Status() WarmupStatus {
+	ready := w.Ready() == nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := WarmupStatus{Ready: ready, Engines: []WarmupTargetStatus{}}
+	for _, t := range w.targets {
+		ts := WarmupTargetStatus{Name: t.name, State: t.state, RoundsMs: []int64{}}
+		for _, took := range t.latencies {
+			ts.RoundsMs = append(ts.RoundsMs, took.Milliseconds())
+		}
+		if t.lastErr != nil {
+			ts.LastError = t.lastErr.Error()
+		}
+		if !t.warmedAt.IsZero() {
+			completed := t.warmedAt.UTC()
+			ts.CompletedAt = &completed
+		}
+		status.Engines = append(status.Engines, ts)
+	}
+	return status
+}
+
+// Handlers
+
+// readyHandler reports whether the server should receive traffic: always
+// when warm-up is disabled, otherwise once every engine is warm
+func readyHandler(warmer *EngineWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if warmer == nil {
+			c.JSON(http.StatusOK, gin.H{"status": "ready"})
+			return
+		}
+		if err := warmer.Ready(); err != nil {
+			abortWithError(c, ErrCodeEngineWarmingUp, fmt.Sprintf("Not ready: %v", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
+
+func warmupStatusHandler(warmer *EngineWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if warmer == nil {
+			abortWithError(c, ErrCodeForbidden, "Engine warm-up is disabled; set ENGINE_WARMUP to enable it")
+			return
+		}
+		c.JSON(http.StatusOK, warmer.Status())
+	}
+}
+
+// rewarmHandler starts a warm-up pass of every engine, e.g. after deploying
+// a new model
+func rewarmHandler(warmer *EngineWarmer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if warmer == nil {
+			abortWithError(c, ErrCodeForbidden, "Engine warm-up is disabled; set ENGINE_WARMUP to enable it")
+			return
+		}
+		go warmer.Warm()
+		c.JSON(http.StatusAccepted, gin.H{"status": "warming"})
+	}
+}
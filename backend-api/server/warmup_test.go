@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+// coldEngine answers slowly until it has answered coldQueries queries
+type coldEngine struct {
+	mu          sync.Mutex
+	queries     int
+	coldQueries int
+	err         error
+}
+
+func (e *coldEngine) Query(req *engine.PythonQueryRequest) (*engine.LegalQueryResponse, error) {
+	e.mu.Lock()
+	e.queries++
+	cold := e.queries <= e.coldQueries
+	e.mu.Unlock()
+	if e.err != nil {
+		return nil, e.err
+	}
+	if cold {
+		time.Sleep(200 * time.Millisecond)
+	} else {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return &engine.LegalQueryResponse{Answer: "Không quá 180 ngày."}, nil
+}
+
+func TestEngineWarmer(t *testing.T) {
+	config := WarmupConfig{Queries: []string{"Thời gian thử việc tối đa là bao nhiêu ngày?"}, Tolerance: 0.5, MaxRounds: 5}
+	warmer := NewEngineWarmer(config, time.Now)
+	cold := &coldEngine{coldQueries: 1}
+	down := &coldEngine{err: errors.New("connection refused")}
+	warmer.Register("primary", cold)
+	warmer.Register("secondary", down)
+	if err := warmer.Ready(); err == nil {
+		t.Fatal("Ready() before warm-up = nil, want an error")
+	}
+
+	warmer.Warm()
+	status := warmer.Status()
+	if status.Ready || len(status.Engines) != 2 {
+		t.Fatalf("status = %+v, want two engines, not ready", status)
+	}
+	// The cold round does not count: the two warm rounds agree
+	if primary := status.Engines[0]; primary.State != WarmupReady || len(primary.RoundsMs) != 3 {
+		t.Errorf("primary = %+v, want ready after 3 rounds", primary)
+	}
+	if secondary := status.Engines[1]; secondary.State != WarmupFailed || secondary.LastError == "" {
+		t.Errorf("secondary = %+v, want failed with its error", secondary)
+	}
+
+	down.err = nil
+	warmer.Warm()
+	if err := warmer.Ready(); err != nil {
+		t.Errorf("Ready() after the engine recovered = %v, want nil", err)
+	}
+}
+
+func TestWarmupEndpoints(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("ENGINE_WARMUP", "true")
+	t.Setenv("ENGINE_WARMUP_QUERIES", "Điều 25 Bộ luật Lao động 2019 quy định gì?")
+	stub := &stubEngine{resp: engine.LegalQueryResponse{Answer: "Thời gian thử việc.", Iterations: 1}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if rec.Code == http.StatusOK {
+			break
+		}
+		if code := decodeError(t, rec).Code; code != ErrCodeEngineWarmingUp {
+			t.Fatalf("ready = %s, want %s", code, ErrCodeEngineWarmingUp)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not ready after 5s of warm-up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stub.mu.Lock()
+	warmup := stub.requests[0]
+	stub.mu.Unlock()
+	if warmup.Question != "Điều 25 Bộ luật Lao động 2019 quy định gì?" || warmup.MaxIterations != 1 || warmup.EnableWebSearch {
+		t.Errorf("warm-up request = %+v, want the configured query on one iteration", warmup)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/warmup", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var status WarmupStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("warm-up status = %d %s", rec.Code, rec.Body.String())
+	}
+	if !status.Ready || len(status.Engines) != 1 || status.Engines[0].Name != "primary" {
+		t.Errorf("warm-up status = %+v, want the primary engine ready", status)
+	}
+}
@@ -0,0 +1,616 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+const (
+	maxWatchesPerUser = 100
+	maxArticleChanges = 500
+
+	// maxChangeNotificationLen caps the summary or diff in a notification;
+	// the whole change is served by its link
+	maxChangeNotificationLen = 2000
+)
+
+// Watch asks for a notification when an article, or any article of a legal
+// document, changes in the corpus. Article is an article ID as the engine
+// serves it, e.g. Dieu_25; Document a document ID.
+type Watch struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	User      string    `json:"user"`
+	Document  string    `json:"document,omitempty"`
+	Article   string    `json:"article,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// matches reports whether a change of an article concerns the watch
+func (w *Watch) matches(document, article string) bool {
+	if w.Article != "" {
+		return w.Article == article && (w.Document == "" || w.Document == document)
+	}
+	return w.Document == document
+}
+
+// ArticleChange is a change of a watched article found in the corpus: a
+// line diff of its text and the engine's plain-language summary of it
+type ArticleChange struct {
+	ID       string `json:"id"`
+	Document string `json:"document,omitempty"`
+	Article  string `json:"article"`
+	Label    string `json:"label,omitempty"`
+
+	// Diff prefixes removed lines with "- ", added ones with "+ " and
+	// unchanged ones with "  "
+	Diff    string `json:"diff"`
+	Summary string `json:"summary,omitempty"`
+
+	// Repealed is set when the article is no longer in the corpus
+	Repealed   bool      `json:"repealed,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// articleSnapshot is the text of an article when it was last checked
+type articleSnapshot struct {
+	Document string    `json:"document,omitempty"`
+	Label    string    `json:"label,omitempty"`
+	Text     string    `json:"text"`
+	TakenAt  time.Time `json:"taken_at"`
+}
+
+var (
+	errWatchNotFound       = errors.New("watch not found")
+	errArticleChangeAbsent = errors.New("article change not found")
+	errWatchLimit          = fmt.Errorf("a user watches at most %d articles and documents", maxWatchesPerUser)
+)
+
+// watchData is the content of the watch file
+type watchData struct {
+	Watches   []*Watch                    `json:"watches"`
+	Snapshots map[string]*articleSnapshot `json:"snapshots"`
+	Changes   []*ArticleChange            `json:"changes"`
+}
+
+// WatchStore keeps the watches, the snapshots of the watched articles and
+// the last changes found, persisted to a JSON file when a path is
+// configured
+type WatchStore struct {
+	mu   sync.Mutex
+	path string
+	data watchData
+}
+
+func NewWatchStore(path string) (*WatchStore, error) {
+	store := &WatchStore{path: path}
+	if path != "" {
+		if _, err := readJSONFile(path, &store.data); err != nil {
+			return nil, fmt.Errorf("failed to load watches: %w", err)
+		}
+	}
+	if store.data.Snapshots == nil {
+		store.data.Snapshots = make(map[string]*articleSnapshot)
+	}
+	return store, nil
+}
+
+// List returns the watches of a user, oldest first
+func (s *WatchStore) List(tenantID, user string) []Watch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watches := []Watch{}
+	for _, w := range s.data.Watches {
+		if w.TenantID == tenantID && w.User == user {
+			watches = append(watches, *w)
+		}
+	}
+	return watches
+}
+
+// Add stores a watch, with the current text of its article when it has
+// one. Watching the same target again returns the existing watch.
+func (s *WatchStore) Add(w Watch, article *engine.Article) (Watch, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, existing := range s.data.Watches {
+		if existing.TenantID != w.TenantID || existing.User != w.User {
+			continue
+		}
+		if existing.Document == w.Document && existing.Article == w.Article {
+			return *existing, false, nil
+		}
+		count++
+	}
+	if count >= maxWatchesPerUser {
+		return Watch{}, false, errWatchLimit
+	}
+
+	w.ID = "w_" + randomHex(8)
+	w.CreatedAt = time.Now().UTC()
+	s.data.Watches = append(s.data.Watches, &w)
+	if article != nil {
+		if _, ok := s.data.Snapshots[article.ArticleID]; !ok {
+			s.data.Snapshots[article.ArticleID] = snapshotOf(article)
+		}
+	}
+	return w, true, s.saveLocked()
+}
+
+// Delete removes a watch of a user, and the snapshots no watch needs
+func (s *WatchStore) Delete(id, tenantID, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.data.Watches, func(w *Watch) bool {
+		return w.ID == id && w.TenantID == tenantID && w.User == user
+	})
+	if i < 0 {
+		return errWatchNotFound
+	}
+	s.data.Watches = slices.Delete(s.data.Watches, i, i+1)
+	for article, snapshot := range s.data.Snapshots {
+		if !s.watchedLocked(snapshot.Document, article) {
+			delete(s.data.Snapshots, article)
+		}
+	}
+	return s.saveLocked()
+}
+
+// Change returns a change found in the corpus
+func (s *WatchStore) Change(id string) (ArticleChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, change := range s.data.Changes {
+		if change.ID == id {
+			return *change, nil
+		}
+	}
+	return ArticleChange{}, errArticleChangeAbsent
+}
+
+func (s *WatchStore) watchedLocked(document, article string) bool {
+	return slices.ContainsFunc(s.data.Watches, func(w *Watch) bool { return w.matches(document, article) })
+}
+
+// affected resolves the corpus documents reported as changed, in the format
+// of cache invalidations, into the watched articles to check and the
+// watched documents none of whose articles is known yet
+func (s *WatchStore) affected(documents []string) (articles map[string]string, unknown []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	articles = make(map[string]string)
+	for _, id := range documents {
+		if document, article, ok := strings.Cut(id, "/"); ok {
+			if s.watchedLocked(document, article) {
+				articles[article] = document
+			}
+			continue
+		}
+		if snapshot, ok := s.data.Snapshots[id]; ok {
+			articles[id] = snapshot.Document
+			continue
+		}
+		known := false
+		for article, snapshot := range s.data.Snapshots {
+			if snapshot.Document == id {
+				articles[article], known = id, true
+			}
+		}
+		if !known && s.watchedLocked(id, "") {
+			unknown = append(unknown, id)
+		}
+	}
+	return articles, unknown
+}
+
+// record replaces the snapshot of an article, and stores the change when
+// there is one
+func (s *WatchStore) record(articleID string, snapshot *articleSnapshot, change *ArticleChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snapshot != nil {
+		s.data.Snapshots[articleID] = snapshot
+	} else {
+		delete(s.data.Snapshots, articleID)
+	}
+	if change != nil {
+		s.data.Changes = append(s.data.Changes, change)
+		if extra := len(s.data.Changes) - maxArticleChanges; extra > 0 {
+			s.data.Changes = slices.Delete(s.data.Changes, 0, extra)
+		}
+	}
+	return s.saveLocked()
+}
+
+// snapshot returns the last checked text of an article
+func (s *WatchStore) snapshot(articleID string) (articleSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot, ok := s.data.Snapshots[articleID]
+	if !ok {
+		return articleSnapshot{}, false
+	}
+	return *snapshot, true
+}
+
+// watchers returns the watches concerned by a change of an article
+func (s *WatchStore) watchers(document, article string) []Watch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var watches []Watch
+	for _, w := range s.data.Watches {
+		if w.matches(document, article) {
+			watches = append(watches, *w)
+		}
+	}
+	return watches
+}
+
+func (s *WatchStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.data)
+}
+
+func snapshotOf(article *engine.Article) *articleSnapshot {
+	return &articleSnapshot{
+		Document: article.DocumentID,
+		Label:    article.Article,
+		Text:     article.Text,
+		TakenAt:  time.Now().UTC(),
+	}
+}
+
+// WatchReport sums up a check of the watched articles
+type WatchReport struct {
+	Checked  int `json:"checked"`
+	Changed  int `json:"changed"`
+	Notified int `json:"notified"`
+}
+
+// CorpusWatcher checks the watched articles when the corpus changes and
+// notifies their watchers of the changes, summarized by the engine
+type CorpusWatcher struct {
+	store         *WatchStore
+	articles      engine.ArticleSource
+	engine        engine.QueryEngine
+	notifications *NotificationCenter
+}
+
+func NewCorpusWatcher(store *WatchStore, articles engine.ArticleSource, queryEngine engine.QueryEngine, notifications *NotificationCenter) *CorpusWatcher {
+	return &CorpusWatcher{store: store, articles: articles, engine: queryEngine, notifications: notifications}
+}
+
+// Check compares the watched articles of the changed documents with their
+// snapshots. An article seen for the first time only gets a snapshot, as
+// there is no earlier text to compare it with.
+func (w *CorpusWatcher) Check(ctx context.Context, documents []string) WatchReport {
+	var report WatchReport
+	articles, unknown := w.store.affected(documents)
+	ids := make([]string, 0, len(articles))
+	for id := range articles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		report.Checked++
+		change, err := w.checkArticle(ctx, id, articles[id])
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to check watched article", "article", id, "error", err)
+			continue
+		}
+		if change == nil {
+			continue
+		}
+		report.Changed++
+		title := fmt.Sprintf("%s was amended", change.name())
+		if change.Repealed {
+			title = fmt.Sprintf("%s was repealed", change.name())
+		}
+		body := change.Summary
+		if body == "" {
+			body = change.Diff
+		}
+		report.Notified += w.notify(change.Document, change.Article, Notification{
+			Title: title,
+			Body:  truncateUTF8(body, maxChangeNotificationLen),
+			Link:  "/api/article-changes/" + change.ID,
+		})
+	}
+	for _, document := range unknown {
+		report.Notified += w.notify(document, "", Notification{Title: fmt.Sprintf("%s was updated", document)})
+	}
+	slog.InfoContext(ctx, "Watched articles checked", "documents", documents, "checked", report.Checked, "changed", report.Changed, "notified", report.Notified)
+	return report
+}
+
+// checkArticle compares an article with its snapshot and returns its
+// change, or nil when it is unchanged or seen for the first time
+func (w *CorpusWatcher) checkArticle(ctx context.Context, id, document string) (*ArticleChange, error) {
+	previous, known := w.store.snapshot(id)
+	current, err := w.articles.Article(ctx, id)
+	switch {
+	case errors.Is(err, engine.ErrArticleNotFound):
+		if !known {
+			return nil, nil
+		}
+		change := &ArticleChange{Document: previous.Document, Article: id, Label: previous.Label, Repealed: true}
+		change.Diff = lineDiff(previous.Text, "")
+		change.Summary = w.summarize(ctx, change, previous.Text, "")
+		return change, w.finish(change, nil)
+	case err != nil:
+		return nil, err
+	}
+	snapshot := snapshotOf(current)
+	if snapshot.Document == "" {
+		snapshot.Document = document
+	}
+	if !known || previous.Text == current.Text {
+		return nil, w.store.record(id, snapshot, nil)
+	}
+	change := &ArticleChange{Document: snapshot.Document, Article: id, Label: snapshot.Label, Diff: lineDiff(previous.Text, current.Text)}
+	change.Summary = w.summarize(ctx, change, previous.Text, current.Text)
+	return change, w.finish(change, snapshot)
+}
+
+// finish stores a change and the new snapshot of its article, nil for a
+// repealed one
+func (w *CorpusWatcher) finish(change *ArticleChange, snapshot *articleSnapshot) error {
+	change.ID = "ac_" + randomHex(8)
+	change.DetectedAt = time.Now().UTC()
+	return w.store.record(change.Article, snapshot, change)
+}
+
+// summarize asks the engine to explain a change in plain language. A failed
+// summary leaves the diff alone in the notification.
+func (w *CorpusWatcher) summarize(ctx context.Context, change *ArticleChange, before, after string) string {
+	if w.engine == nil {
+		return ""
+	}
+	prompt := fmt.Sprintf("Tóm tắt ngắn gọn bằng ngôn ngữ dễ hiểu, cho người không chuyên, "+
+		"những gì đã thay đổi trong %s so với bản trước và ảnh hưởng thực tế của thay đổi. "+
+		"Dòng bắt đầu bằng \"- \" đã bị bỏ, dòng bắt đầu bằng \"+ \" được thêm:\n\n%s", change.name(), change.Diff)
+	if change.Repealed {
+		prompt = fmt.Sprintf("%s đã bị bãi bỏ. Tóm tắt ngắn gọn bằng ngôn ngữ dễ hiểu những quy định không còn áp dụng.", change.name())
+	}
+	req := buildPythonRequest(&LegalQueryRequest{Question: prompt}, QueryDefaults{}, plans[defaultPlanName])
+	req.EnableWebSearch = false
+	req.MaxIterations = 1
+	for _, doc := range []engine.ContextDocument{{Name: "Bản trước", Text: before}, {Name: "Bản hiện hành", Text: after}} {
+		if doc.Text != "" {
+			doc.Source = change.name()
+			req.ContextDocuments = append(req.ContextDocuments, doc)
+		}
+	}
+	resp, err := engine.QueryWithContext(ctx, w.engine, req)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to summarize article change", "article", change.Article, "error", err)
+		return ""
+	}
+	return strings.TrimSpace(resp.Answer)
+}
+
+// notify publishes n to the watchers of an article, or of a document when
+// article is empty, and returns how many were notified
+func (w *CorpusWatcher) notify(document, article string, n Notification) int {
+	watchers := w.store.watchers(document, article)
+	for _, watch := range watchers {
+		n.TenantID, n.User, n.Kind = watch.TenantID, watch.User, NotificationArticleChanged
+		w.notifications.Publish(n)
+	}
+	return len(watchers)
+}
+
+// name is how notifications refer to the changed article
+func (c *ArticleChange) name() string {
+	label := c.Label
+	if label == "" {
+		label = c.Article
+	}
+	if c.Document == "" {
+		return label
+	}
+	return fmt.Sprintf("%s (%s)", label, c.Document)
+}
+
+// lineDiff compares two texts line by line, keeping the lines common to
+// both in order (their longest common subsequence)
+func lineDiff(before, after string) string {
+	a, b := splitLines(before), splitLines(after)
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Handlers
+
+// CreateWatchRequest is the body of POST /api/watches: an article, a
+// document, or an article of a document
+type CreateWatchRequest struct {
+	Document string `json:"document"`
+	Article  string `json:"article"`
+}
+
+// CheckWatchesRequest is the body of POST /admin/watches/check, in the
+// format of cache invalidations
+type CheckWatchesRequest struct {
+	Documents []string `json:"documents"`
+}
+
+func listWatchesHandler(store *WatchStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		tenant, _ := callerTenant(c)
+		c.JSON(http.StatusOK, gin.H{"watches": store.List(tenant.ID, user)})
+	}
+}
+
+// createWatchHandler watches an article, which must exist, or a document.
+// The text of an article is kept to compare it with once it changes.
+func createWatchHandler(store *WatchStore, articles engine.ArticleSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req CreateWatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		req.Document, req.Article = strings.TrimSpace(req.Document), strings.TrimSpace(req.Article)
+		if req.Document == "" && req.Article == "" {
+			abortWithError(c, ErrCodeInvalidRequest, "document or article is required")
+			return
+		}
+
+		var article *engine.Article
+		if req.Article != "" {
+			if articles == nil {
+				abortWithError(c, ErrCodeEngineUnavailable, "The engine does not serve articles, so articles cannot be watched")
+				return
+			}
+			var err error
+			article, err = articles.Article(c.Request.Context(), req.Article)
+			if errors.Is(err, engine.ErrArticleNotFound) {
+				abortWithError(c, ErrCodeNotFound, fmt.Sprintf("Article %q is not in the corpus", req.Article))
+				return
+			}
+			if err != nil {
+				abortWithEngineError(c, err, fmt.Sprintf("Failed to fetch article %q: %v", req.Article, err))
+				return
+			}
+		}
+
+		tenant, _ := callerTenant(c)
+		watch, created, err := store.Add(Watch{TenantID: tenant.ID, User: user, Document: req.Document, Article: req.Article}, article)
+		if errors.Is(err, errWatchLimit) {
+			abortWithError(c, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save watches", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to save the watch")
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		c.JSON(status, watch)
+	}
+}
+
+func deleteWatchHandler(store *WatchStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		tenant, _ := callerTenant(c)
+		err := store.Delete(c.Param("id"), tenant.ID, user)
+		if errors.Is(err, errWatchNotFound) {
+			abortWithError(c, ErrCodeWatchNotFound, fmt.Sprintf("Watch %q not found", c.Param("id")))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save watches", "error", err)
+			abortWithError(c, ErrCodeInternal, "Failed to delete the watch")
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getArticleChangeHandler serves a change with its whole diff. Changes are
+// of the shared corpus, so any caller may read them.
+func getArticleChangeHandler(store *WatchStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		change, err := store.Change(c.Param("id"))
+		if err != nil {
+			abortWithError(c, ErrCodeNotFound, fmt.Sprintf("Article change %q not found", c.Param("id")))
+			return
+		}
+		c.JSON(http.StatusOK, change)
+	}
+}
+
+// checkWatchesHandler checks the watched articles of the changed documents
+// in the background, for the ingestion pipeline to call after an update
+func checkWatchesHandler(watcher *CorpusWatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CheckWatchesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithError(c, ErrCodeInvalidRequest, fmt.Sprintf("Invalid request format: %v", err))
+			return
+		}
+		if len(req.Documents) == 0 {
+			abortWithError(c, ErrCodeInvalidRequest, "documents must not be empty")
+			return
+		}
+		if watcher.articles == nil {
+			abortWithError(c, ErrCodeEngineUnavailable, "The engine does not serve articles, so watched articles cannot be checked")
+			return
+		}
+		ctx := context.WithoutCancel(c.Request.Context())
+		go watcher.Check(ctx, req.Documents)
+		c.JSON(http.StatusAccepted, gin.H{"status": "checking", "documents": len(req.Documents)})
+	}
+}
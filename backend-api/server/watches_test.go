@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenvothetuyen/legal-rag-backend/engine"
+)
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("1. Thử việc không quá 60 ngày.\n2. Lương thử việc.", "1. Thử việc không quá 180 ngày.\n2. Lương thử việc.\n3. Kết thúc thử việc.")
+	want := "- 1. Thử việc không quá 60 ngày.\n+ 1. Thử việc không quá 180 ngày.\n  2. Lương thử việc.\n+ 3. Kết thúc thử việc.\n"
+	if got != want {
+		t.Errorf("lineDiff =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCorpusWatcher(t *testing.T) {
+	store, err := NewWatchStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, _ := NewNotificationCenter("", nil)
+	articles := &articleEngine{articles: map[string]engine.Article{
+		"Dieu_25": {ArticleID: "Dieu_25", DocumentID: "blld-2019", Article: "Điều 25", Text: "Thời gian thử việc không quá 60 ngày."},
+		"Dieu_26": {ArticleID: "Dieu_26", DocumentID: "blld-2019", Article: "Điều 26", Text: "Tiền lương thử việc."},
+	}}
+	articles.resp = engine.LegalQueryResponse{Answer: "Thời gian thử việc tối đa tăng lên 180 ngày."}
+	watcher := NewCorpusWatcher(store, articles, articles, notifications)
+
+	// Minh's document watch knows no article yet: an update is reported
+	// without a diff, and an article named is kept for the next one
+	store.Add(Watch{TenantID: "acme", User: "minh", Document: "blld-2019"}, nil)
+	if report := watcher.Check(context.Background(), []string{"blld-2019"}); report.Checked != 0 || report.Notified != 1 {
+		t.Errorf("check of an unknown document = %+v, want Minh told of the update", report)
+	}
+	if report := watcher.Check(context.Background(), []string{"blld-2019/Dieu_26"}); report.Checked != 1 || report.Notified != 0 {
+		t.Errorf("first check of an article = %+v, want it kept without a notification", report)
+	}
+
+	article := articles.articles["Dieu_25"]
+	if _, _, err := store.Add(Watch{TenantID: "acme", User: "lan", Article: "Dieu_25"}, &article); err != nil {
+		t.Fatal(err)
+	}
+
+	article.Text = "Thời gian thử việc không quá 180 ngày."
+	articles.articles["Dieu_25"] = article
+	delete(articles.articles, "Dieu_26")
+	report := watcher.Check(context.Background(), []string{"blld-2019"})
+	if report.Checked != 2 || report.Changed != 2 || report.Notified != 3 {
+		t.Fatalf("check = %+v, want both articles changed, Lan told of one and Minh of both", report)
+	}
+
+	list, _ := notifications.List("acme", "lan", false, 10)
+	if len(list) != 1 || list[0].Kind != NotificationArticleChanged || list[0].Body != articles.resp.Answer {
+		t.Fatalf("lan's notifications = %+v, want the engine's summary of Điều 25", list)
+	}
+	change, err := store.Change(strings.TrimPrefix(list[0].Link, "/api/article-changes/"))
+	if err != nil || !strings.Contains(change.Diff, "+ Thời gian thử việc không quá 180 ngày.") {
+		t.Errorf("change = %+v, %v, want the diff of Điều 25", change, err)
+	}
+	if prompt := articles.requests[len(articles.requests)-1]; len(prompt.ContextDocuments) != 1 {
+		t.Errorf("summary of the repealed article got %d context documents, want its last text", len(prompt.ContextDocuments))
+	}
+
+	if report := watcher.Check(context.Background(), []string{"blld-2019/Dieu_25"}); report.Changed != 0 {
+		t.Errorf("check without a change = %+v, want no change", report)
+	}
+}
+
+func TestWatchesAPI(t *testing.T) {
+	stub := &articleEngine{articles: map[string]engine.Article{
+		"Dieu_25": {ArticleID: "Dieu_25", DocumentID: "blld-2019", Article: "Điều 25", Text: "Thời gian thử việc không quá 60 ngày."},
+	}}
+	h := newTestServer(t, Options{Engine: stub}).Handler()
+	do := func(method, path, user string, body any) *httptest.ResponseRecorder {
+		return doAs(t, h, user, method, path, body)
+	}
+
+	if code := decodeError(t, do(http.MethodPost, "/api/watches", "lan", CreateWatchRequest{Article: "Dieu_999"})).Code; code != ErrCodeNotFound {
+		t.Errorf("watch of an unknown article = %s, want %s", code, ErrCodeNotFound)
+	}
+	if code := decodeError(t, do(http.MethodPost, "/api/watches", "", CreateWatchRequest{Article: "Dieu_25"})).Code; code != ErrCodeInvalidRequest {
+		t.Errorf("watch without a user = %s, want %s", code, ErrCodeInvalidRequest)
+	}
+	rec := do(http.MethodPost, "/api/watches", "lan", CreateWatchRequest{Article: "Dieu_25"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create watch = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/watches", "lan", CreateWatchRequest{Article: "Dieu_25"}); rec.Code != http.StatusOK {
+		t.Errorf("watching again = %d, want 200 with the existing watch", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/watches", "lan", nil); !strings.Contains(rec.Body.String(), `"article":"Dieu_25"`) {
+		t.Errorf("list = %s, want the watch", rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/api/watches", "minh", nil); strings.Contains(rec.Body.String(), "Dieu_25") {
+		t.Errorf("minh's list = %s, want lan's watch hidden", rec.Body.String())
+	}
+	if code := decodeError(t, do(http.MethodDelete, "/api/watches/w_unknown", "lan", nil)).Code; code != ErrCodeWatchNotFound {
+		t.Errorf("delete unknown watch = %s, want %s", code, ErrCodeWatchNotFound)
+	}
+}